@@ -4,6 +4,10 @@
 // commands in this package are provided by the go-bindata binary and let you
 // read them from Go code. See the staticServer in server/serve.go for an
 // example.
+//
+// "make assets" also runs hashgen.go, which writes a name for each static file
+// with a hash of its contents. Templates link to those names with the
+// "static" function, so the files can be cached forever.
 package assets
 
 func MustAssetString(name string) string {
@@ -0,0 +1,65 @@
+package assets
+
+import "strings"
+
+// The number of hex characters in a hash. Keep in sync with hashgen.go.
+const hashLength = 10
+
+// The hashed name for each static file, and the other way around.
+var unhashedNames = make(map[string]string, len(hashedNames))
+
+func init() {
+	for name, hashed := range hashedNames {
+		unhashedNames[hashed] = name
+	}
+}
+
+// HashedName returns the name of a static file with a hash of its contents,
+// like "static/css/all.1a2b3c4d5e.css". The name changes whenever the file
+// does, so it can be cached forever. Names that aren't static files are
+// returned unchanged.
+func HashedName(name string) string {
+	if hashed, ok := hashedNames[name]; ok {
+		return hashed
+	}
+	return name
+}
+
+// Unhash returns the name of the static file for a name returned by
+// HashedName, and true, or false if hashed isn't the current name of any
+// file.
+func Unhash(hashed string) (string, bool) {
+	name, ok := unhashedNames[hashed]
+	return name, ok
+}
+
+// Hash returns the hash of the contents of a static file, and true, or false
+// if name isn't a static file.
+func Hash(name string) (string, bool) {
+	hashed, ok := hashedNames[name]
+	if !ok {
+		return "", false
+	}
+	hashed = hashed[:strings.LastIndexByte(hashed, '.')]
+	return hashed[len(hashed)-hashLength:], true
+}
+
+// StripHash removes the hash from a name like "static/css/all.1a2b3c4d5e.css",
+// whether or not it's current, and returns true. If name doesn't have a hash,
+// it returns false.
+func StripHash(name string) (string, bool) {
+	dot := strings.LastIndexByte(name, '.')
+	if dot < 0 {
+		return "", false
+	}
+	prev := strings.LastIndexByte(name[:dot], '.')
+	if prev < 0 || dot-prev-1 != hashLength {
+		return "", false
+	}
+	for _, c := range name[prev+1 : dot] {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return "", false
+		}
+	}
+	return name[:prev] + name[dot:], true
+}
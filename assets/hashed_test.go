@@ -0,0 +1,43 @@
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestHashesCurrent(t *testing.T) {
+	t.Parallel()
+	for name := range hashedNames {
+		bits, err := Asset(name)
+		if err != nil {
+			t.Errorf("%s is in hashes.go but isn't an asset", name)
+			continue
+		}
+		sum := sha256.Sum256(bits)
+		hash, _ := Hash(name)
+		if want := hex.EncodeToString(sum[:])[:hashLength]; hash != want {
+			t.Errorf("%s: hash is %s, contents hash to %s; run \"make assets\"", name, hash, want)
+		}
+	}
+}
+
+func TestStripHash(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"static/css/all.0123456789.css", "static/css/all.css", true},
+		{"static/css/all.css", "", false},
+		{"static/css/bootstrap.min.css", "", false},
+		{"static/favicon.zzzzzzzzzz.ico", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := StripHash(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("StripHash(%q): got %q, %t, want %q, %t", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
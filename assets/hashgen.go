@@ -0,0 +1,69 @@
+// +build ignore
+
+// hashgen writes hashes.go, which maps each file in static/ to a name with a
+// hash of its contents, so the files can be cached forever. Run it from the
+// root of the repository after go-bindata: "go run assets/hashgen.go".
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Long enough that two versions of a file won't collide.
+const hashLength = 10
+
+func hashedName(name string, contents []byte) string {
+	sum := sha256.Sum256(contents)
+	h := hex.EncodeToString(sum[:])[:hashLength]
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + h + ext
+}
+
+func main() {
+	names := make(map[string]string)
+	err := filepath.Walk("static", func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		contents, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(p)
+		names[name] = hashedName(name, contents)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys := make([]string, 0, len(names))
+	for name := range names {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	buf := new(bytes.Buffer)
+	buf.WriteString("// Code generated by assets/hashgen.go. DO NOT EDIT.\n\npackage assets\n\n")
+	buf.WriteString("var hashedNames = map[string]string{\n")
+	for _, name := range keys {
+		fmt.Fprintf(buf, "\t%q: %q,\n", name, names[name])
+	}
+	buf.WriteString("}\n")
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("assets/hashes.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,440 @@
+package cache
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/saintpete/logrole/store"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// ErrTooLarge is returned by BlobStore.Put if the data is too large to store.
+var ErrTooLarge = errors.New("Blob is too large to store in the cache")
+
+// BlobStore is a disk-backed cache for large, unchanging values like MMS
+// media and recordings, so we don't have to download them from Twilio every
+// time they are viewed.
+//
+// Blobs are stored by the SHA-256 hash of their contents, so the same image
+// sent in many messages is only stored once. When the total size of the blobs
+// exceeds the limit, the least recently used keys are removed. Entries expire
+// after a TTL, and survive restarts.
+//
+// A BlobStore with keys encrypts blobs with NaCl secretbox, and names them by
+// an HMAC of their contents instead of a plain hash, so the files on disk
+// don't reveal what's in them. Every blob is checked against its hash when
+// it's read, and blobs that fail the check are removed.
+type BlobStore struct {
+	log.Logger
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+	// The key new blobs are encrypted with, or nil if they aren't encrypted.
+	key   *[32]byte
+	keyID string
+	// Every key blobs can be decrypted with, including key, by key ID.
+	keys map[string]*[32]byte
+
+	mu      sync.Mutex
+	entries map[string]*blobEntry // keyed by the hash of the key
+	refs    map[string]int        // number of entries for each blob
+	size    int64                 // total size of the blobs on disk
+}
+
+type blobEntry struct {
+	Hash        string    `json:"hash"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Expires     time.Time `json:"expires"`
+	// The ID of the key the blob is encrypted with, or empty if it isn't
+	// encrypted.
+	KeyID    string `json:"key_id,omitempty"`
+	lastUsed time.Time
+}
+
+var errBlobCorrupt = errors.New("Cached blob failed its integrity check")
+
+// NewBlobStore creates a BlobStore in dir, creating the directory if
+// necessary, and loads any entries stored there by a previous process.
+// Blobs larger than a tenth of maxBytes are not stored.
+func NewBlobStore(l log.Logger, dir string, maxBytes int64, ttl time.Duration) (*BlobStore, error) {
+	return NewEncryptedBlobStore(l, dir, maxBytes, ttl, nil)
+}
+
+// NewEncryptedBlobStore is like NewBlobStore, but encrypts blobs with the
+// first of keys. Blobs encrypted with any of the other keys can still be
+// read, and are encrypted again with the first key when they are, or when
+// Rekey is called; once that's done, old keys can be dropped. Unencrypted
+// blobs from before encryption was turned on are treated the same way. If
+// keys is empty, blobs are not encrypted.
+func NewEncryptedBlobStore(l log.Logger, dir string, maxBytes int64, ttl time.Duration, keys []*[32]byte) (*BlobStore, error) {
+	if maxBytes <= 0 {
+		return nil, errors.New("Blob store size must be positive")
+	}
+	for _, sub := range []string{"blobs", "keys"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+	b := &BlobStore{
+		Logger:   l,
+		dir:      dir,
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		entries:  make(map[string]*blobEntry),
+		refs:     make(map[string]int),
+	}
+	if len(keys) > 0 {
+		b.key = keys[0]
+		b.keyID = blobKeyID(keys[0])
+		b.keys = make(map[string]*[32]byte, len(keys))
+		for _, k := range keys {
+			b.keys[blobKeyID(k)] = k
+		}
+	}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// blobKeyID identifies key in entries on disk, without revealing it.
+func blobKeyID(key *[32]byte) string {
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte("logrole blob key id"))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// blobHash names a blob. Encrypted blobs are named by an HMAC of their
+// contents, so the name doesn't reveal what's in them.
+func blobHash(data []byte, key *[32]byte) string {
+	if key == nil {
+		return hashBytes(data)
+	}
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// seal returns the name and contents of the file to store data in, and the
+// ID of the key it's encrypted with.
+func (b *BlobStore) seal(data []byte) (hash string, keyID string, sealed []byte) {
+	if b.key == nil {
+		return hashBytes(data), "", data
+	}
+	nonce := new([24]byte)
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		panic(err)
+	}
+	return blobHash(data, b.key), b.keyID, secretbox.Seal(nonce[:], data, nonce, b.key)
+}
+
+// open decrypts the contents of e's file, and checks them against e.Hash.
+func (b *BlobStore) open(e *blobEntry, raw []byte) ([]byte, error) {
+	var key *[32]byte
+	data := raw
+	if e.KeyID != "" {
+		key = b.keys[e.KeyID]
+		if key == nil {
+			return nil, errors.New("Cached blob is encrypted with an unknown key")
+		}
+		if len(raw) < 24 {
+			return nil, errBlobCorrupt
+		}
+		nonce := new([24]byte)
+		copy(nonce[:], raw[:24])
+		var ok bool
+		data, ok = secretbox.Open(nil, raw[24:], nonce, key)
+		if !ok {
+			return nil, errBlobCorrupt
+		}
+	}
+	if !hmac.Equal([]byte(blobHash(data, key)), []byte(e.Hash)) {
+		return nil, errBlobCorrupt
+	}
+	return data, nil
+}
+
+func (b *BlobStore) blobPath(hash string) string {
+	return filepath.Join(b.dir, "blobs", hash)
+}
+
+func (b *BlobStore) keyPath(keyHash string) string {
+	return filepath.Join(b.dir, "keys", keyHash+".json")
+}
+
+// load reads the entries on disk, removing any that have expired and any
+// blobs that no entry points to.
+func (b *BlobStore) load() error {
+	keys, err := ioutil.ReadDir(filepath.Join(b.dir, "keys"))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, fi := range keys {
+		name := fi.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		keyHash := strings.TrimSuffix(name, ".json")
+		data, err := ioutil.ReadFile(b.keyPath(keyHash))
+		if err != nil {
+			return err
+		}
+		e := new(blobEntry)
+		if err := json.Unmarshal(data, e); err != nil || e.Expires.Before(now) {
+			os.Remove(b.keyPath(keyHash))
+			continue
+		}
+		// The key was dropped from the config, so the blob can't be read.
+		if e.KeyID != "" && b.keys[e.KeyID] == nil {
+			os.Remove(b.keyPath(keyHash))
+			continue
+		}
+		if _, err := os.Stat(b.blobPath(e.Hash)); err != nil {
+			os.Remove(b.keyPath(keyHash))
+			continue
+		}
+		e.lastUsed = fi.ModTime()
+		b.entries[keyHash] = e
+		if b.refs[e.Hash] == 0 {
+			b.size += e.Size
+		}
+		b.refs[e.Hash]++
+	}
+	blobs, err := ioutil.ReadDir(filepath.Join(b.dir, "blobs"))
+	if err != nil {
+		return err
+	}
+	for _, fi := range blobs {
+		if b.refs[fi.Name()] == 0 {
+			os.Remove(b.blobPath(fi.Name()))
+		}
+	}
+	b.evict()
+	return nil
+}
+
+// Get returns the data and content type stored for key, if it's present and
+// has not expired. Blobs that aren't encrypted with the current key are
+// encrypted with it again.
+func (b *BlobStore) Get(key string) ([]byte, string, bool) {
+	keyHash := hashBytes([]byte(key))
+	b.mu.Lock()
+	e, ok := b.entries[keyHash]
+	if !ok {
+		b.mu.Unlock()
+		return nil, "", false
+	}
+	if time.Now().After(e.Expires) {
+		b.remove(keyHash)
+		b.mu.Unlock()
+		return nil, "", false
+	}
+	e.lastUsed = time.Now()
+	b.mu.Unlock()
+	data, err := b.read(e)
+	if err != nil {
+		b.Warn("Could not read cached blob", "hash", e.Hash, "err", err)
+		b.Purge(key)
+		return nil, "", false
+	}
+	if e.KeyID != b.keyID {
+		if err := b.store(keyHash, e.ContentType, data, e.Expires); err != nil {
+			b.Warn("Could not encrypt cached blob with the current key", "hash", e.Hash, "err", err)
+		}
+	}
+	return data, e.ContentType, true
+}
+
+// read returns the contents of e's blob.
+func (b *BlobStore) read(e *blobEntry) ([]byte, error) {
+	raw, err := ioutil.ReadFile(b.blobPath(e.Hash))
+	if err != nil {
+		return nil, err
+	}
+	return b.open(e, raw)
+}
+
+// Put stores data for key, replacing any existing value, and removes the
+// least recently used entries if the store is over its size limit.
+func (b *BlobStore) Put(key string, contentType string, data []byte) error {
+	if int64(len(data)) > b.maxBytes/10 {
+		return ErrTooLarge
+	}
+	return b.store(hashBytes([]byte(key)), contentType, data, time.Now().Add(b.ttl))
+}
+
+func (b *BlobStore) store(keyHash string, contentType string, data []byte, expires time.Time) error {
+	hash, keyID, sealed := b.seal(data)
+	e := &blobEntry{
+		Hash:        hash,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Expires:     expires,
+		KeyID:       keyID,
+		lastUsed:    time.Now(),
+	}
+	meta, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.refs[e.Hash] == 0 {
+		if err := store.WriteFile(b.blobPath(e.Hash), sealed); err != nil {
+			return err
+		}
+	}
+	if err := store.WriteFile(b.keyPath(keyHash), meta); err != nil {
+		return err
+	}
+	if b.refs[e.Hash] == 0 {
+		b.size += e.Size
+	}
+	// Take the new reference before dropping the old one, so storing the
+	// same blob for a key again doesn't delete it.
+	b.refs[e.Hash]++
+	if _, ok := b.entries[keyHash]; ok {
+		b.release(keyHash)
+	}
+	b.entries[keyHash] = e
+	b.evict()
+	return nil
+}
+
+// Rekey encrypts every blob that isn't encrypted with the current key with
+// it, and returns how many it encrypted. Blobs that can't be read are
+// removed.
+func (b *BlobStore) Rekey() (int, error) {
+	b.mu.Lock()
+	stale := make(map[string]*blobEntry)
+	for keyHash, e := range b.entries {
+		if e.KeyID != b.keyID {
+			stale[keyHash] = e
+		}
+	}
+	b.mu.Unlock()
+	n := 0
+	for keyHash, e := range stale {
+		data, err := b.read(e)
+		if err != nil {
+			b.Warn("Could not read cached blob", "hash", e.Hash, "err", err)
+			b.mu.Lock()
+			if b.entries[keyHash] == e {
+				b.remove(keyHash)
+			}
+			b.mu.Unlock()
+			continue
+		}
+		b.mu.Lock()
+		current := b.entries[keyHash] == e
+		b.mu.Unlock()
+		// Replaced or purged while we were reading it.
+		if !current {
+			continue
+		}
+		if err := b.store(keyHash, e.ContentType, data, e.Expires); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Purge removes the entry for key, if there is one.
+func (b *BlobStore) Purge(key string) {
+	keyHash := hashBytes([]byte(key))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.entries[keyHash]; ok {
+		b.remove(keyHash)
+	}
+}
+
+// PurgeAll removes every entry in the store.
+func (b *BlobStore) PurgeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for keyHash := range b.entries {
+		b.remove(keyHash)
+	}
+}
+
+// PurgeBefore removes the entries that were stored before cutoff, and returns
+// how many it removed. If dryRun is true, it only counts them.
+func (b *BlobStore) PurgeBefore(cutoff time.Time, dryRun bool) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	purged := 0
+	for keyHash, e := range b.entries {
+		if !e.Expires.Add(-b.ttl).Before(cutoff) {
+			continue
+		}
+		purged++
+		if !dryRun {
+			b.remove(keyHash)
+		}
+	}
+	return purged, nil
+}
+
+// Size returns the total size of the blobs in the store, in bytes.
+func (b *BlobStore) Size() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.size
+}
+
+// remove deletes the entry for keyHash from disk and memory. b.mu must be
+// held.
+func (b *BlobStore) remove(keyHash string) {
+	os.Remove(b.keyPath(keyHash))
+	b.release(keyHash)
+	delete(b.entries, keyHash)
+}
+
+// release drops the entry's reference to its blob, deleting the blob if no
+// other entry uses it. b.mu must be held.
+func (b *BlobStore) release(keyHash string) {
+	e := b.entries[keyHash]
+	b.refs[e.Hash]--
+	if b.refs[e.Hash] <= 0 {
+		delete(b.refs, e.Hash)
+		os.Remove(b.blobPath(e.Hash))
+		b.size -= e.Size
+	}
+}
+
+// evict removes the least recently used entries until the store is under its
+// size limit. b.mu must be held.
+func (b *BlobStore) evict() {
+	for b.size > b.maxBytes && len(b.entries) > 0 {
+		var oldest string
+		var oldestTime time.Time
+		for keyHash, e := range b.entries {
+			if oldest == "" || e.lastUsed.Before(oldestTime) {
+				oldest = keyHash
+				oldestTime = e.lastUsed
+			}
+		}
+		b.remove(oldest)
+	}
+}
@@ -0,0 +1,289 @@
+package cache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/test"
+)
+
+func newTestBlobStore(t *testing.T, maxBytes int64, ttl time.Duration) (*BlobStore, string) {
+	dir, err := ioutil.TempDir("", "logrole-blobs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBlobStore(test.NullLogger, dir, maxBytes, ttl)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return b, dir
+}
+
+func TestBlobStore(t *testing.T) {
+	t.Parallel()
+	b, dir := newTestBlobStore(t, 1000, time.Hour)
+	defer os.RemoveAll(dir)
+	data := []byte("image data")
+	if err := b.Put("one", "image/png", data); err != nil {
+		t.Fatal(err)
+	}
+	// The same content under a second key is only stored once.
+	if err := b.Put("two", "image/png", data); err != nil {
+		t.Fatal(err)
+	}
+	if b.Size() != int64(len(data)) {
+		t.Errorf("expected size %d, got %d", len(data), b.Size())
+	}
+	got, ctype, ok := b.Get("two")
+	if !ok {
+		t.Fatal("expected to find key in the store")
+	}
+	if !bytes.Equal(got, data) || ctype != "image/png" {
+		t.Errorf("got %q (%s), want %q (image/png)", got, ctype, data)
+	}
+	b.Purge("one")
+	if _, _, ok := b.Get("one"); ok {
+		t.Error("expected purged key to be missing")
+	}
+	if _, _, ok := b.Get("two"); !ok {
+		t.Error("purging one key should not remove a blob used by another")
+	}
+
+	// Entries survive a restart.
+	b2, err := NewBlobStore(test.NullLogger, dir, 1000, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := b2.Get("two"); !ok {
+		t.Error("expected entry to be loaded from disk")
+	}
+	b2.PurgeAll()
+	if b2.Size() != 0 {
+		t.Errorf("expected empty store after PurgeAll, got size %d", b2.Size())
+	}
+	blobs, _ := ioutil.ReadDir(filepath.Join(dir, "blobs"))
+	if len(blobs) != 0 {
+		t.Errorf("expected PurgeAll to remove blobs from disk, found %d", len(blobs))
+	}
+}
+
+func TestBlobStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+	b, dir := newTestBlobStore(t, 100, time.Hour)
+	defer os.RemoveAll(dir)
+	for i, key := range []string{"a", "b", "c"} {
+		if err := b.Put(key, "audio/x-wav", bytes.Repeat([]byte{byte(i)}, 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Put("big", "audio/x-wav", bytes.Repeat([]byte{'x'}, 11)); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+	// Fill the store; "a" was used least recently, so it should go first.
+	for i := 0; i < 8; i++ {
+		time.Sleep(time.Millisecond)
+		b.Get("b")
+		b.Get("c")
+		if err := b.Put(string('d'+rune(i)), "audio/x-wav", bytes.Repeat([]byte{byte(i + 10)}, 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.Size() > 100 {
+		t.Errorf("expected size to be capped at 100, got %d", b.Size())
+	}
+	if _, _, ok := b.Get("a"); ok {
+		t.Error("expected least recently used key to be evicted")
+	}
+	if _, _, ok := b.Get("b"); !ok {
+		t.Error("expected recently used key to be kept")
+	}
+}
+
+func TestBlobStoreExpires(t *testing.T) {
+	t.Parallel()
+	b, dir := newTestBlobStore(t, 1000, time.Millisecond)
+	defer os.RemoveAll(dir)
+	if err := b.Put("one", "image/png", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, _, ok := b.Get("one"); ok {
+		t.Error("expected expired entry to be missing")
+	}
+	if b.Size() != 0 {
+		t.Errorf("expected expired blob to be removed, got size %d", b.Size())
+	}
+}
+
+func TestBlobStorePurgeBefore(t *testing.T) {
+	t.Parallel()
+	b, dir := newTestBlobStore(t, 1000, time.Hour)
+	defer os.RemoveAll(dir)
+	if err := b.Put("old", "image/png", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	cutoff := time.Now().Add(time.Second)
+	if n, _ := b.PurgeBefore(cutoff, true); n != 1 {
+		t.Errorf("expected dry run to count 1 entry, got %d", n)
+	}
+	if _, _, ok := b.Get("old"); !ok {
+		t.Fatal("expected dry run to keep the entry")
+	}
+	if n, _ := b.PurgeBefore(cutoff, false); n != 1 {
+		t.Errorf("expected to purge 1 entry, got %d", n)
+	}
+	if _, _, ok := b.Get("old"); ok {
+		t.Error("expected the entry to be purged")
+	}
+	if b.Size() != 0 {
+		t.Errorf("expected an empty store, got %d bytes", b.Size())
+	}
+}
+
+func testKey(b byte) *[32]byte {
+	key := new([32]byte)
+	for i := range key {
+		key[i] = b
+	}
+	return key
+}
+
+func TestEncryptedBlobStore(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-blobs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := NewEncryptedBlobStore(test.NullLogger, dir, 1000, time.Hour, []*[32]byte{testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("a recording of a phone call")
+	if err := b.Put("one", "audio/x-wav", data); err != nil {
+		t.Fatal(err)
+	}
+	if b.Size() != int64(len(data)) {
+		t.Errorf("expected size %d, got %d", len(data), b.Size())
+	}
+	blobs, _ := ioutil.ReadDir(filepath.Join(dir, "blobs"))
+	if len(blobs) != 1 {
+		t.Fatalf("expected 1 blob on disk, got %d", len(blobs))
+	}
+	if blobs[0].Name() == hashBytes(data) {
+		t.Error("encrypted blob should not be named by the hash of its contents")
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "blobs", blobs[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, data) {
+		t.Error("found plaintext on disk")
+	}
+	got, _, ok := b.Get("one")
+	if !ok || !bytes.Equal(got, data) {
+		t.Fatalf("got %q, %t, want %q", got, ok, data)
+	}
+
+	// A store with a different key can't read the blob, and forgets it.
+	b2, err := NewEncryptedBlobStore(test.NullLogger, dir, 1000, time.Hour, []*[32]byte{testKey(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := b2.Get("one"); ok {
+		t.Error("expected blob encrypted with an unknown key to be missing")
+	}
+	if b2.Size() != 0 {
+		t.Errorf("expected empty store, got size %d", b2.Size())
+	}
+}
+
+func TestEncryptedBlobStoreDetectsTampering(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-blobs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := NewEncryptedBlobStore(test.NullLogger, dir, 1000, time.Hour, []*[32]byte{testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put("one", "image/png", []byte("image data")); err != nil {
+		t.Fatal(err)
+	}
+	blobs, _ := ioutil.ReadDir(filepath.Join(dir, "blobs"))
+	path := filepath.Join(dir, "blobs", blobs[0].Name())
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-1] ^= 0xff
+	if err := ioutil.WriteFile(path, raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := b.Get("one"); ok {
+		t.Error("expected tampered blob to be rejected")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected tampered blob to be removed, got %v", err)
+	}
+}
+
+func TestEncryptedBlobStoreRotatesKeys(t *testing.T) {
+	t.Parallel()
+	b, dir := newTestBlobStore(t, 1000, time.Hour)
+	defer os.RemoveAll(dir)
+	plain := []byte("stored before encryption was on")
+	old := []byte("stored with the old key")
+	if err := b.Put("plain", "image/png", plain); err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewEncryptedBlobStore(test.NullLogger, dir, 1000, time.Hour, []*[32]byte{testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put("old", "image/png", old); err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, oldKey := testKey(2), testKey(1)
+	b, err = NewEncryptedBlobStore(test.NullLogger, dir, 1000, time.Hour, []*[32]byte{newKey, oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reading a blob encrypts it with the current key.
+	if got, _, ok := b.Get("plain"); !ok || !bytes.Equal(got, plain) {
+		t.Fatalf("got %q, %t, want %q", got, ok, plain)
+	}
+	n, err := b.Rekey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected to encrypt 1 blob, got %d", n)
+	}
+	if b.Size() != int64(len(plain)+len(old)) {
+		t.Errorf("expected size %d, got %d", len(plain)+len(old), b.Size())
+	}
+
+	// The old key is no longer needed.
+	b, err = NewEncryptedBlobStore(test.NullLogger, dir, 1000, time.Hour, []*[32]byte{newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string][]byte{"plain": plain, "old": old} {
+		if got, _, ok := b.Get(key); !ok || !bytes.Equal(got, want) {
+			t.Errorf("%s: got %q, %t, want %q", key, got, ok, want)
+		}
+	}
+	blobs, _ := ioutil.ReadDir(filepath.Join(dir, "blobs"))
+	if len(blobs) != 2 {
+		t.Errorf("expected blobs under old keys to be removed, found %d blobs", len(blobs))
+	}
+}
@@ -8,9 +8,6 @@
 package cache
 
 import (
-	"bytes"
-	"compress/gzip"
-	"encoding/gob"
 	"errors"
 	"sync"
 	"time"
@@ -18,44 +15,59 @@ import (
 	"github.com/aristanetworks/goarista/monotime"
 	"github.com/golang/groupcache/lru"
 	log "github.com/inconshreveable/log15"
+	"github.com/saintpete/logrole/logging"
 )
 
 type Cache struct {
 	log.Logger
-	c  *lru.Cache
-	mu sync.RWMutex
+	c *lru.Cache
+	// lru.Cache moves an entry to the front on every Get, so reads need the
+	// write lock too.
+	mu sync.Mutex
+	// The same entries as c, so the cache can be snapshotted; lru.Cache can't
+	// be iterated.
+	entries map[string]*expiringBits
+	codec   Codec
+	// Called with each value stored by Set. See OnSet.
+	onSet func(*Entry)
+	// Values that were replaced or expired, newest first, if the cache keeps
+	// more than one generation of each key; past has the same values as
+	// history, so they can be listed. See KeepGenerations.
+	generations int
+	history     *lru.Cache
+	past        map[string][]*expiringBits
 }
 
 var expired = errors.New("expired")
 var errNotFound = errors.New("Key not found in cache")
 
+// NewCache creates a Cache that holds up to size values, encoded as JSON.
 func NewCache(size int, l log.Logger) *Cache {
-	return &Cache{
-		Logger: l,
-		c:      lru.New(size),
-	}
+	return NewCacheWithCodec(size, l, JSON)
 }
 
-// enc gob.Encodes + gzips data. do not try to gob.Encode an interface
-func enc(data interface{}) []byte {
-	var buf bytes.Buffer
-	writer := gzip.NewWriter(&buf)
-	ec := gob.NewEncoder(writer)
-	if err := ec.Encode(data); err != nil {
-		panic(err)
+// NewCacheWithCodec creates a Cache that encodes values with codec. Values
+// encoded with another codec, or by a version of Logrole from before codecs,
+// can still be read, and are encoded with codec the first time they are.
+func NewCacheWithCodec(size int, l log.Logger, codec Codec) *Cache {
+	c := &Cache{
+		Logger:  logging.Module(l, logging.ModuleCache),
+		c:       lru.New(size),
+		entries: make(map[string]*expiringBits),
+		codec:   codec,
 	}
-	if err := writer.Close(); err != nil {
-		panic(err)
+	c.c.OnEvicted = func(key lru.Key, _ interface{}) {
+		delete(c.entries, key.(string))
 	}
-	return buf.Bytes()
+	return c
 }
 
 // Get gets the value at the key and decodes it into val. Returns the time the
 // value was stored in the cache, or an error, if the value was not found,
 // expired, or could not be decoded into val.
 func (c *Cache) Get(key string, val interface{}) (uint64, error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	cacheVal, ok := c.c.Get(key)
 	if !ok {
 		c.Debug("cache miss", "key", key)
@@ -68,18 +80,26 @@ func (c *Cache) Get(key string, val interface{}) (uint64, error) {
 	}
 	if now, expires := monotime.Now(), e.Set+e.Timeout; now > expires {
 		c.Debug("found expired value in cache", "key", key, "expired_ago", time.Duration(now-expires))
+		c.retire(key, e)
 		c.c.Remove(key)
+		delete(c.entries, key)
 		return 0, expired
 	}
-	reader, err := gzip.NewReader(bytes.NewReader(e.Bits))
+	stale, err := dec(c.codec, e.Bits, val)
 	if err != nil {
-		panic(err)
-	}
-	defer reader.Close()
-	dec := gob.NewDecoder(reader)
-	if err := dec.Decode(val); err != nil {
+		// Probably written by a different version of Logrole. Drop it, so
+		// the caller's fresh value replaces it.
+		c.Warn("could not decode value in cache", "key", key, "err", err)
+		c.c.Remove(key)
+		delete(c.entries, key)
 		return 0, err
 	}
+	if stale {
+		// Replace the bits, instead of the entry, so it expires when it
+		// would have.
+		e.Bits = enc(c.codec, val)
+		c.Debug("re-encoded cached value", "key", key, "codec", string(c.codec.ID()))
+	}
 	c.Debug("cache hit", "key", key, "size", len(e.Bits))
 	return e.Set, nil
 }
@@ -88,16 +108,42 @@ func (c *Cache) Set(key string, val interface{}, timeout time.Duration) {
 	if timeout < 0 {
 		panic("invalid timeout")
 	}
-	now := monotime.Now()
+	now, wallNow := monotime.Now(), time.Now()
 	c.mu.Lock()
-	defer c.mu.Unlock()
 	e := &expiringBits{
 		Set:     now,
 		Timeout: uint64(timeout),
-		Bits:    enc(val),
+		Bits:    enc(c.codec, val),
+	}
+	if old, ok := c.entries[key]; ok {
+		c.retire(key, old)
 	}
 	c.c.Add(key, e)
+	c.entries[key] = e
+	onSet := c.onSet
 	c.Debug("stored data in cache", "key", key, "size", len(e.Bits), "cache_size", c.c.Len())
+	c.mu.Unlock()
+	if onSet != nil {
+		onSet(&Entry{Key: key, Bits: e.Bits, Stored: wallNow, Expires: wallNow.Add(timeout)})
+	}
+}
+
+// Stats returns the number of entries in the cache, and the number of bytes
+// they, and any old generations of them, take up once encoded. Expired
+// entries are counted until they're read or evicted.
+func (c *Cache) Stats() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var size int64
+	for _, e := range c.entries {
+		size += int64(len(e.Bits))
+	}
+	for _, old := range c.past {
+		for _, e := range old {
+			size += int64(len(e.Bits))
+		}
+	}
+	return len(c.entries), size
 }
 
 type expiringBits struct {
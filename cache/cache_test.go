@@ -57,3 +57,20 @@ func TestExpiredValueNotFound(t *testing.T) {
 		t.Errorf("retrieved message page from cache, it should have expired: %#v", err)
 	}
 }
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+	c := NewCache(1, test.NullLogger)
+	if n, size := c.Stats(); n != 0 || size != 0 {
+		t.Errorf("expected an empty cache, got %d entries, %d bytes", n, size)
+	}
+	c.Set("a", "hello", time.Hour)
+	c.Set("b", "world", time.Hour)
+	n, size := c.Stats()
+	if n != 1 {
+		t.Errorf("expected the first entry to be evicted, got %d entries", n)
+	}
+	if size <= 0 {
+		t.Errorf("expected a positive size, got %d", size)
+	}
+}
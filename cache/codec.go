@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A Codec turns cached values into bytes and back. Replicas sharing cached
+// values may run different versions of Logrole, so a codec should tolerate
+// fields being added or removed from a value's type.
+type Codec interface {
+	// ID is written before every value the codec encodes, so Get can find
+	// the codec that can decode it. It must be unique, and never change.
+	ID() byte
+	Encode(w io.Writer, val interface{}) error
+	Decode(r io.Reader, val interface{}) error
+}
+
+// JSON encodes values as JSON. Unknown fields are ignored and missing fields
+// are left empty, so values survive changes to their types. It's the default
+// codec.
+var JSON Codec = jsonCodec{}
+
+// Gob encodes values with encoding/gob, the way Logrole used to. It's faster
+// than JSON, but fails if a value's types don't line up with the types it was
+// encoded from. Only use it if every replica runs the same version.
+var Gob Codec = gobCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte { return 'j' }
+
+func (jsonCodec) Encode(w io.Writer, val interface{}) error {
+	return json.NewEncoder(w).Encode(val)
+}
+
+func (jsonCodec) Decode(r io.Reader, val interface{}) error {
+	return json.NewDecoder(r).Decode(val)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ID() byte { return 'g' }
+
+func (gobCodec) Encode(w io.Writer, val interface{}) error {
+	return gob.NewEncoder(w).Encode(val)
+}
+
+func (gobCodec) Decode(r io.Reader, val interface{}) error {
+	return gob.NewDecoder(r).Decode(val)
+}
+
+var codecs = map[byte]Codec{
+	JSON.ID(): JSON,
+	Gob.ID():  Gob,
+}
+
+// Values cached before codecs existed are gzipped gobs with no codec ID, so
+// they start with the gzip header.
+const legacyPrefix = 0x1f
+
+var errUnknownCodec = errors.New("cached value has an unknown encoding")
+
+// enc encodes val with codec and gzips it, after the codec's ID. Do not try
+// to encode an interface.
+func enc(codec Codec, val interface{}) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(codec.ID())
+	writer := gzip.NewWriter(&buf)
+	if err := codec.Encode(writer, val); err != nil {
+		panic(err)
+	}
+	if err := writer.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// dec decodes bits written by enc, or by an older version of Logrole, into
+// val. stale is true if bits weren't encoded with current, and should be
+// encoded again.
+func dec(current Codec, bits []byte, val interface{}) (stale bool, err error) {
+	if len(bits) == 0 {
+		return false, errUnknownCodec
+	}
+	// Legacy values are always stale, even if current is Gob, so they get a
+	// codec ID.
+	codec, legacy := Gob, bits[0] == legacyPrefix
+	if !legacy {
+		var ok bool
+		codec, ok = codecs[bits[0]]
+		if !ok {
+			return false, fmt.Errorf("%v: %q", errUnknownCodec, bits[0])
+		}
+		bits = bits[1:]
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(bits))
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+	if err := codec.Decode(reader, val); err != nil {
+		return false, err
+	}
+	return legacy || codec.ID() != current.ID(), nil
+}
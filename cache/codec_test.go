@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+	"github.com/saintpete/logrole/test"
+)
+
+// legacyBits encodes val the way Logrole did before codecs.
+func legacyBits(t *testing.T, val interface{}) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if err := gob.NewEncoder(w).Encode(val); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLegacyValueReencoded(t *testing.T) {
+	t.Parallel()
+	c := NewCache(10, test.NullLogger)
+	e := &expiringBits{Set: monotime.Now(), Timeout: uint64(time.Hour), Bits: legacyBits(t, "hello")}
+	c.c.Add("key", e)
+	c.entries["key"] = e
+	var val string
+	if _, err := c.Get("key", &val); err != nil || val != "hello" {
+		t.Fatalf("expected to read legacy value, got %q, %v", val, err)
+	}
+	if e.Bits[0] != JSON.ID() {
+		t.Errorf("expected legacy value to be encoded as JSON, got prefix %q", e.Bits[0])
+	}
+	val = ""
+	if _, err := c.Get("key", &val); err != nil || val != "hello" {
+		t.Errorf("expected to read re-encoded value, got %q, %v", val, err)
+	}
+}
+
+func TestSwitchCodec(t *testing.T) {
+	t.Parallel()
+	c := NewCacheWithCodec(10, test.NullLogger, Gob)
+	c.Set("key", "hello", time.Hour)
+	if b := c.entries["key"].Bits[0]; b != Gob.ID() {
+		t.Fatalf("expected value to be encoded with gob, got prefix %q", b)
+	}
+	c.codec = JSON
+	var val string
+	if _, err := c.Get("key", &val); err != nil || val != "hello" {
+		t.Fatalf("expected to read gob value, got %q, %v", val, err)
+	}
+	if b := c.entries["key"].Bits[0]; b != JSON.ID() {
+		t.Errorf("expected value to be encoded again with JSON, got prefix %q", b)
+	}
+}
+
+func TestJSONToleratesChangedTypes(t *testing.T) {
+	t.Parallel()
+	type before struct {
+		Sid     string
+		Removed int
+	}
+	type after struct {
+		Sid   string
+		Added string
+	}
+	c := NewCache(10, test.NullLogger)
+	c.Set("key", &before{Sid: "SM123", Removed: 7}, time.Hour)
+	val := new(after)
+	if _, err := c.Get("key", val); err != nil {
+		t.Fatal(err)
+	}
+	if val.Sid != "SM123" || val.Added != "" {
+		t.Errorf("unexpected value %#v", val)
+	}
+}
+
+func TestUnknownCodecDropped(t *testing.T) {
+	t.Parallel()
+	c := NewCache(10, test.NullLogger)
+	e := &expiringBits{Set: monotime.Now(), Timeout: uint64(time.Hour), Bits: []byte("zgarbage")}
+	c.c.Add("key", e)
+	c.entries["key"] = e
+	var val string
+	if _, err := c.Get("key", &val); err == nil {
+		t.Fatal("expected an error for an unknown codec")
+	}
+	if _, err := c.Get("key", &val); err != errNotFound {
+		t.Errorf("expected the bad value to be dropped, got %v", err)
+	}
+}
+
+func TestRestoreV1Snapshot(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	entries := []*Entry{
+		{Key: "key", Bits: legacyBits(t, "hello"), Stored: now.Add(-time.Minute), Expires: now.Add(time.Hour)},
+	}
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(entries); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(body.Bytes())
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n%s\n", snapshotHeaderV1, hex.EncodeToString(sum[:]))
+	body.WriteTo(&buf)
+	c := NewCache(10, test.NullLogger)
+	n, err := c.Restore(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected to restore 1 entry, got %d", n)
+	}
+	var val string
+	if _, err := c.Get("key", &val); err != nil || val != "hello" {
+		t.Errorf("expected to read value from v1 snapshot, got %q, %v", val, err)
+	}
+}
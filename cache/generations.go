@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+	"github.com/golang/groupcache/lru"
+)
+
+// ErrNoGeneration is returned by GetGeneration if the cache doesn't have a
+// value that was stored at the key at that time.
+var ErrNoGeneration = errors.New("No value was stored at that key at that time, or it's no longer kept")
+
+// A Generation is one of the values stored at a key.
+type Generation struct {
+	// The monotonic time the value was stored, like Get returns. Pass it to
+	// GetGeneration to read the value.
+	Set     uint64
+	Stored  time.Time
+	Expires time.Time
+	// The size of the value once encoded, in bytes.
+	Size int
+	// True for the value Get returns, until it expires.
+	Current bool
+}
+
+// Expired reports whether the generation had expired at now.
+func (g *Generation) Expired(now time.Time) bool {
+	return !now.Before(g.Expires)
+}
+
+// KeepGenerations makes the cache keep the last n values stored at each key,
+// including the current one, so they can be listed with Generations and read
+// with GetGeneration. Old values are kept after they expire or are replaced,
+// for as many keys as the cache holds, and take up memory on top of it. If n
+// is less than 2, only the current value is kept, which is the default.
+func (c *Cache) KeepGenerations(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n < 2 {
+		c.generations = 0
+		c.history = nil
+		c.past = nil
+		return
+	}
+	c.generations = n
+	c.history = lru.New(c.c.MaxEntries)
+	c.past = make(map[string][]*expiringBits)
+	c.history.OnEvicted = func(key lru.Key, _ interface{}) {
+		delete(c.past, key.(string))
+	}
+}
+
+// retire moves e, a value that's being replaced or removed, into the key's
+// history, if the cache keeps one. c.mu must be held.
+func (c *Cache) retire(key string, e *expiringBits) {
+	if c.history == nil {
+		return
+	}
+	old := append([]*expiringBits{e}, c.past[key]...)
+	if len(old) > c.generations-1 {
+		old = old[:c.generations-1]
+	}
+	c.history.Add(key, old)
+	c.past[key] = old
+}
+
+// generationsFor returns every value kept for key, newest first. c.mu must
+// be held.
+func (c *Cache) generationsFor(key string) []*expiringBits {
+	bits := make([]*expiringBits, 0, c.generations)
+	if e, ok := c.entries[key]; ok {
+		bits = append(bits, e)
+	}
+	return append(bits, c.past[key]...)
+}
+
+// Generations returns the values kept for key, newest first. Unless
+// KeepGenerations was called, that's at most the current value.
+func (c *Cache) Generations(key string) []*Generation {
+	now, wallNow := monotime.Now(), time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cur := c.entries[key]
+	bits := c.generationsFor(key)
+	gens := make([]*Generation, len(bits))
+	for i, e := range bits {
+		stored := wallNow.Add(-time.Duration(now - e.Set))
+		gens[i] = &Generation{
+			Set:     e.Set,
+			Stored:  stored,
+			Expires: stored.Add(time.Duration(e.Timeout)),
+			Size:    len(e.Bits),
+			Current: e == cur && now <= e.Set+e.Timeout,
+		}
+	}
+	return gens
+}
+
+// GetGeneration decodes the value stored at key at set, a time from
+// Generations, into val, even if it's expired or has been replaced. Returns
+// ErrNoGeneration if the value isn't kept.
+func (c *Cache) GetGeneration(key string, set uint64, val interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.generationsFor(key) {
+		if e.Set == set {
+			_, err := dec(c.codec, e.Bits, val)
+			return err
+		}
+	}
+	return ErrNoGeneration
+}
+
+// Keys returns every key the cache has a value for, including old
+// generations, sorted.
+func (c *Cache) Keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := make(map[string]bool, len(c.entries))
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	for key := range c.past {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/test"
+)
+
+func TestGenerations(t *testing.T) {
+	t.Parallel()
+	c := NewCache(10, test.NullLogger)
+	c.KeepGenerations(3)
+	for _, val := range []string{"one", "two", "three", "four"} {
+		c.Set("key", val, time.Hour)
+	}
+	gens := c.Generations("key")
+	if len(gens) != 3 {
+		t.Fatalf("expected 3 generations, got %d", len(gens))
+	}
+	if !gens[0].Current || gens[1].Current {
+		t.Errorf("expected only the newest generation to be current")
+	}
+	for i, want := range []string{"four", "three", "two"} {
+		var val string
+		if err := c.GetGeneration("key", gens[i].Set, &val); err != nil || val != want {
+			t.Errorf("generation %d: expected %q, got %q, %v", i, want, val, err)
+		}
+	}
+	var val string
+	if err := c.GetGeneration("key", gens[2].Set-1, &val); err != ErrNoGeneration {
+		t.Errorf("expected ErrNoGeneration for an unknown time, got %v", err)
+	}
+}
+
+func TestGenerationsKeptAfterExpiry(t *testing.T) {
+	t.Parallel()
+	c := NewCache(10, test.NullLogger)
+	c.KeepGenerations(2)
+	c.Set("key", "old", time.Nanosecond)
+	var val string
+	if _, err := c.Get("key", &val); err != expired {
+		t.Fatalf("expected the value to expire, got %v", err)
+	}
+	c.Set("key", "new", time.Hour)
+	gens := c.Generations("key")
+	if len(gens) != 2 {
+		t.Fatalf("expected the expired value to be kept, got %d generations", len(gens))
+	}
+	if err := c.GetGeneration("key", gens[1].Set, &val); err != nil || val != "old" {
+		t.Errorf("expected to read the expired value, got %q, %v", val, err)
+	}
+	if keys := c.Keys(); len(keys) != 1 || keys[0] != "key" {
+		t.Errorf("expected one key, got %v", keys)
+	}
+}
+
+func TestOneGenerationByDefault(t *testing.T) {
+	t.Parallel()
+	c := NewCache(10, test.NullLogger)
+	c.Set("key", "one", time.Hour)
+	c.Set("key", "two", time.Hour)
+	if gens := c.Generations("key"); len(gens) != 1 {
+		t.Errorf("expected only the current value to be kept, got %d generations", len(gens))
+	}
+}
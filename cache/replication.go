@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+)
+
+// OnSet calls fn with every value stored with Set, so it can be sent to other
+// instances. fn is called after the value is stored, on the goroutine that
+// called Set, so it shouldn't block. Values stored with Apply aren't passed
+// to fn, so two caches that replicate to each other don't send values back
+// and forth forever.
+func (c *Cache) OnSet(fn func(*Entry)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onSet = fn
+}
+
+// Apply stores entries received from another instance, skipping any that
+// have expired, and any for keys that were stored here more recently than
+// the entry was stored there. It returns the number of entries stored.
+func (c *Cache) Apply(entries []*Entry) int {
+	now, wallNow := monotime.Now(), time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count := 0
+	for _, e := range entries {
+		if cur, ok := c.entries[e.Key]; ok {
+			stored := wallNow.Add(-time.Duration(now - cur.Set))
+			if !e.Stored.After(stored) {
+				continue
+			}
+		}
+		if c.add(e, now, wallNow) {
+			count++
+		}
+	}
+	c.Debug("applied replicated cache entries", "received", len(entries), "stored", count, "cache_size", c.c.Len())
+	return count
+}
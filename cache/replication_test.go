@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/test"
+)
+
+func TestReplication(t *testing.T) {
+	t.Parallel()
+	a := NewCache(10, test.NullLogger)
+	b := NewCache(10, test.NullLogger)
+	a.OnSet(func(e *Entry) { b.Apply([]*Entry{e}) })
+	sent := 0
+	b.OnSet(func(e *Entry) { sent++ })
+	a.Set("key", "hello", time.Hour)
+	var val string
+	if _, err := b.Get("key", &val); err != nil || val != "hello" {
+		t.Errorf("expected the value to be replicated, got %q, %v", val, err)
+	}
+	if sent != 0 {
+		t.Errorf("expected applied values not to be sent on, got %d", sent)
+	}
+
+	b.Set("key", "newer", time.Hour)
+	old := &Entry{Key: "key", Bits: enc(JSON, "older"), Stored: time.Now().Add(-time.Minute), Expires: time.Now().Add(time.Hour)}
+	if n := b.Apply([]*Entry{old}); n != 0 {
+		t.Errorf("expected an entry older than the local one to be skipped, stored %d", n)
+	}
+	expired := &Entry{Key: "gone", Bits: enc(JSON, "x"), Stored: time.Now().Add(-time.Hour), Expires: time.Now().Add(-time.Minute)}
+	if n := b.Apply([]*Entry{expired}); n != 0 {
+		t.Errorf("expected an expired entry to be skipped, stored %d", n)
+	}
+	if _, err := b.Get("key", &val); err != nil || val != "newer" {
+		t.Errorf("expected the local value to win, got %q, %v", val, err)
+	}
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+)
+
+// The first line of every snapshot. Bump the version if Entry changes.
+const snapshotHeader = "logrole cache snapshot v2"
+
+// Snapshots written before v2 are a gob of Entry's. Restore can still
+// read them; the values in them are encoded again the first time they're
+// read from the cache.
+const snapshotHeaderV1 = "logrole cache snapshot v1"
+
+// ErrBadSnapshot is returned by Restore if a snapshot is truncated, corrupt
+// or from a different version of Logrole.
+var ErrBadSnapshot = errors.New("Cache snapshot is invalid or corrupt")
+
+// An Entry is a cache entry with wall clock times, since monotonic times
+// don't mean anything in another process. Entries are saved in snapshots and
+// sent to other instances by replication.
+type Entry struct {
+	Key     string    `json:"key"`
+	Bits    []byte    `json:"bits"`
+	Stored  time.Time `json:"stored"`
+	Expires time.Time `json:"expires"`
+}
+
+type entriesByStored []*Entry
+
+func (e entriesByStored) Len() int           { return len(e) }
+func (e entriesByStored) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e entriesByStored) Less(i, j int) bool { return e[i].Stored.After(e[j].Stored) }
+
+// Snapshot writes every unexpired entry in the cache to w, newest first,
+// stopping before the entries would take up more than maxBytes. It returns
+// the number of entries written. If maxBytes is zero, there's no limit.
+//
+// A snapshot starts with a header line and the SHA-256 of the rest of the
+// snapshot, which Restore checks before loading anything.
+func (c *Cache) Snapshot(w io.Writer, maxBytes int64) (int, error) {
+	c.mu.Lock()
+	now, wallNow := monotime.Now(), time.Now()
+	entries := make([]*Entry, 0, len(c.entries))
+	for key, e := range c.entries {
+		if now > e.Set+e.Timeout {
+			continue
+		}
+		entries = append(entries, &Entry{
+			Key:     key,
+			Bits:    e.Bits,
+			Stored:  wallNow.Add(-time.Duration(now - e.Set)),
+			Expires: wallNow.Add(time.Duration(e.Set + e.Timeout - now)),
+		})
+	}
+	c.mu.Unlock()
+	sort.Sort(entriesByStored(entries))
+	var size int64
+	for i, e := range entries {
+		size += int64(len(e.Key) + len(e.Bits))
+		if maxBytes > 0 && size > maxBytes {
+			entries = entries[:i]
+			break
+		}
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(entries); err != nil {
+		return 0, err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	if _, err := fmt.Fprintf(w, "%s\n%s\n", snapshotHeader, hex.EncodeToString(sum[:])); err != nil {
+		return 0, err
+	}
+	if _, err := buf.WriteTo(w); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// Restore loads the entries in a snapshot written by Snapshot into the cache,
+// skipping any that have expired since. It returns the number of entries
+// loaded. If the snapshot fails its integrity check, ErrBadSnapshot is
+// returned and nothing is loaded.
+func (c *Cache) Restore(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	header, err := br.ReadString('\n')
+	header = strings.TrimSuffix(header, "\n")
+	if err != nil || (header != snapshotHeader && header != snapshotHeaderV1) {
+		return 0, ErrBadSnapshot
+	}
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return 0, ErrBadSnapshot
+	}
+	want, err := hex.DecodeString(strings.TrimSuffix(line, "\n"))
+	if err != nil {
+		return 0, ErrBadSnapshot
+	}
+	data, err := ioutil.ReadAll(br)
+	if err != nil {
+		return 0, err
+	}
+	sum := sha256.Sum256(data)
+	if !bytes.Equal(sum[:], want) {
+		return 0, ErrBadSnapshot
+	}
+	var entries []*Entry
+	if header == snapshotHeaderV1 {
+		err = gob.NewDecoder(bytes.NewReader(data)).Decode(&entries)
+	} else {
+		err = json.Unmarshal(data, &entries)
+	}
+	if err != nil {
+		return 0, ErrBadSnapshot
+	}
+	now, wallNow := monotime.Now(), time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count := 0
+	// Oldest first, so the newest entries are the last to be evicted.
+	for i := len(entries) - 1; i >= 0; i-- {
+		if c.add(entries[i], now, wallNow) {
+			count++
+		}
+	}
+	c.Debug("restored cache from snapshot", "entries", count, "cache_size", c.c.Len())
+	return count, nil
+}
+
+// add stores e in the cache, unless it has expired. now and wallNow are the
+// current monotonic and wall clock times. c.mu must be held.
+func (c *Cache) add(e *Entry, now uint64, wallNow time.Time) bool {
+	if !wallNow.Before(e.Expires) {
+		return false
+	}
+	age := uint64(wallNow.Sub(e.Stored))
+	if e.Stored.After(wallNow) {
+		age = 0
+	}
+	// The monotonic clock starts near zero when the machine boots, so an
+	// entry can't be older than it.
+	if age > now {
+		age = now
+	}
+	set := now - age
+	bits := &expiringBits{
+		Set:     set,
+		Timeout: uint64(e.Expires.Sub(wallNow)) + (now - set),
+		Bits:    e.Bits,
+	}
+	if old, ok := c.entries[e.Key]; ok {
+		c.retire(e.Key, old)
+	}
+	c.c.Add(e.Key, bits)
+	c.entries[e.Key] = bits
+	return true
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/test"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	t.Parallel()
+	c := NewCache(10, test.NullLogger)
+	c.Set("first", "one", time.Hour)
+	c.Set("second", "two", time.Hour)
+	c.Set("gone", "three", 0)
+	time.Sleep(time.Millisecond)
+	var buf bytes.Buffer
+	n, err := c.Snapshot(&buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected expired entries to be skipped, wrote %d", n)
+	}
+	c2 := NewCache(10, test.NullLogger)
+	n, err = c2.Restore(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected to restore 2 entries, got %d", n)
+	}
+	var val string
+	if _, err := c2.Get("second", &val); err != nil || val != "two" {
+		t.Errorf("expected to get restored value, got %q, %v", val, err)
+	}
+	if _, err := c2.Get("gone", &val); err == nil {
+		t.Error("expected expired value not to be restored")
+	}
+}
+
+func TestSnapshotMaxBytes(t *testing.T) {
+	t.Parallel()
+	c := NewCache(10, test.NullLogger)
+	c.Set("old", "one", time.Hour)
+	time.Sleep(time.Millisecond)
+	c.Set("new", "two", time.Hour)
+	var buf bytes.Buffer
+	n, err := c.Snapshot(&buf, int64(len("new")+len(c.entries["new"].Bits)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected only the newest entry to fit, wrote %d", n)
+	}
+	c2 := NewCache(10, test.NullLogger)
+	c2.Restore(&buf)
+	var val string
+	if _, err := c2.Get("new", &val); err != nil {
+		t.Errorf("expected newest entry to be kept: %v", err)
+	}
+}
+
+func TestRestoreCorruptSnapshot(t *testing.T) {
+	t.Parallel()
+	c := NewCache(10, test.NullLogger)
+	c.Set("key", "value", time.Hour)
+	var buf bytes.Buffer
+	if _, err := c.Snapshot(&buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	corrupt := append([]byte{}, data...)
+	corrupt[len(corrupt)-5] ^= 0xff
+	for _, snapshot := range [][]byte{corrupt, data[:len(data)-10], []byte("not a snapshot\n")} {
+		c2 := NewCache(10, test.NullLogger)
+		if _, err := c2.Restore(bytes.NewReader(snapshot)); err != ErrBadSnapshot {
+			t.Errorf("expected ErrBadSnapshot, got %v", err)
+		}
+		if len(c2.entries) != 0 {
+			t.Errorf("expected nothing to be restored from a bad snapshot")
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Package client calls Logrole's JSON API. Requests are made as the user the
+// credentials belong to, so responses only have what that user is allowed to
+// see. The methods match the operations in the OpenAPI document Logrole
+// serves at /openapi.json.
+package client
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/kevinburke/rest"
+	"golang.org/x/net/context"
+)
+
+// A Client makes requests to one Logrole server. Errors from the server, like
+// a 403 for an operation the user isn't allowed to call, are a *rest.Error.
+type Client struct {
+	*rest.Client
+}
+
+// New returns a Client for the Logrole server at base, for example
+// "https://logrole.example.com", that logs in with HTTP Basic Auth. If the
+// server identifies users another way, leave user and password empty and set
+// up c.Client.Client, for example with a TLS client certificate.
+func New(base, user, password string) *Client {
+	return &Client{Client: rest.NewClient(user, password, base)}
+}
+
+// A NavLink is a link in one section of the navbar.
+type NavLink struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// A NavSection is one part of the navbar: "main", "tools" or "admin".
+type NavSection struct {
+	Name  string     `json:"name"`
+	Links []*NavLink `json:"links"`
+}
+
+// A NavRoute is a page the user can see. Pages about one resource have a
+// pattern in their Path, like /messages/{sid}.
+type NavRoute struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// Nav is the navbar and every page the user can see.
+type Nav struct {
+	// Paths are relative to this URL. It's empty unless the server has a
+	// public_host.
+	BaseURL string        `json:"base_url"`
+	Nav     []*NavSection `json:"nav"`
+	Routes  []*NavRoute   `json:"routes"`
+}
+
+// An AlertTrendPoint is the number of alerts created between Start and End.
+type AlertTrendPoint struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Count int       `json:"count"`
+	// How many of Count were during the user's business hours.
+	InHours int    `json:"in_hours"`
+	Label   string `json:"label"`
+	// The list of alerts in the bucket, relative to the server.
+	URL string `json:"url"`
+}
+
+// An AlertTrend counts the alerts created in each part of a window.
+type AlertTrend struct {
+	// True while the server counts in the background; ask again in a few
+	// seconds.
+	Counting bool `json:"counting"`
+	// The size of each bucket, e.g. "6 hours".
+	Bucket    string             `json:"bucket,omitempty"`
+	Points    []*AlertTrendPoint `json:"points"`
+	Truncated bool               `json:"truncated"`
+	// True if the user's group has business hours.
+	BusinessHours bool      `json:"business_hours"`
+	ComputedAt    time.Time `json:"computed_at"`
+	Err           string    `json:"error,omitempty"`
+}
+
+// Newer says whether there are resources newer than a time.
+type Newer struct {
+	Newer bool `json:"newer"`
+	Count int  `json:"count"`
+}
+
+func (c *Client) get(ctx context.Context, path string, data url.Values, v interface{}) error {
+	if len(data) > 0 {
+		path = path + "?" + data.Encode()
+	}
+	req, err := c.NewRequest("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	return c.Do(req.WithContext(ctx), v)
+}
+
+// Nav returns the navbar and the pages the user can see.
+func (c *Client) Nav(ctx context.Context) (*Nav, error) {
+	nav := new(Nav)
+	err := c.get(ctx, "/nav", nil, nav)
+	return nav, err
+}
+
+// AlertTrend counts the alerts matching data in each part of a window. data
+// can have "log-level", "resource-sid", "alert-start" and "alert-end".
+func (c *Client) AlertTrend(ctx context.Context, data url.Values) (*AlertTrend, error) {
+	trend := new(AlertTrend)
+	err := c.get(ctx, "/alerts/trend", data, trend)
+	return trend, err
+}
+
+// NewerMessages waits up to 25 seconds for messages matching data that were
+// created after after. data can have the filters on the message list, like
+// "from", "to" or "team".
+func (c *Client) NewerMessages(ctx context.Context, after time.Time, data url.Values) (*Newer, error) {
+	return c.newer(ctx, "/messages/newer", after, data)
+}
+
+// NewerCalls waits up to 25 seconds for calls matching data that were created
+// after after.
+func (c *Client) NewerCalls(ctx context.Context, after time.Time, data url.Values) (*Newer, error) {
+	return c.newer(ctx, "/calls/newer", after, data)
+}
+
+func (c *Client) newer(ctx context.Context, path string, after time.Time, data url.Values) (*Newer, error) {
+	query := url.Values{}
+	for k, v := range data {
+		query[k] = v
+	}
+	query.Set("after", after.UTC().Format(time.RFC3339))
+	n := new(Newer)
+	err := c.get(ctx, path, query, n)
+	return n, err
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/rest"
+	"golang.org/x/net/context"
+)
+
+func TestNav(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "test" || pass != "hymanrickover" {
+			t.Errorf("expected Basic Auth credentials, got %q %q", user, pass)
+		}
+		if r.URL.Path != "/nav" {
+			t.Errorf("expected a request for /nav, got %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"base_url": "", "nav": [{"name": "main", "links": [{"name": "Messages", "url": "/messages"}]}], "routes": [{"name": "Message", "path": "/messages/{sid}"}]}`))
+	}))
+	defer s.Close()
+	nav, err := New(s.URL, "test", "hymanrickover").Nav(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nav.Nav) != 1 || nav.Nav[0].Links[0].URL != "/messages" {
+		t.Errorf("expected the main section, got %v", nav.Nav)
+	}
+	if len(nav.Routes) != 1 || nav.Routes[0].Path != "/messages/{sid}" {
+		t.Errorf("expected the message route, got %v", nav.Routes)
+	}
+}
+
+func TestNewerMessages(t *testing.T) {
+	t.Parallel()
+	after := time.Date(2016, 10, 18, 15, 0, 0, 0, time.UTC)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("after"); got != "2016-10-18T15:00:00Z" {
+			t.Errorf("expected after to be set, got %q", got)
+		}
+		if got := r.URL.Query().Get("team"); got != "support" {
+			t.Errorf("expected the team filter, got %q", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"newer": true, "count": 3}`))
+	}))
+	defer s.Close()
+	data := url.Values{"team": []string{"support"}}
+	n, err := New(s.URL, "", "").NewerMessages(context.Background(), after, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.Newer || n.Count != 3 {
+		t.Errorf("expected 3 newer messages, got %v", n)
+	}
+}
+
+func TestForbidden(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+	}))
+	defer s.Close()
+	_, err := New(s.URL, "", "").AlertTrend(context.Background(), nil)
+	rerr, ok := err.(*rest.Error)
+	if !ok {
+		t.Fatalf("expected a *rest.Error, got %#v", err)
+	}
+	if rerr.Status != 403 || rerr.Title != "Access denied" {
+		t.Errorf("expected a 403, got %v", rerr)
+	}
+}
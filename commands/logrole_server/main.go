@@ -3,12 +3,15 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	log "github.com/inconshreveable/log15"
@@ -29,6 +32,11 @@ func init() {
 Configuration should be written to a file (default config.yml in the 
 current directory) and passed to the binary via the --config flag.
 
+Commands:
+  serve            Start the server (the default)
+  validate-config  Check the config file for mistakes and exit
+  version          Print the version and exit
+
 Usage of server:
 `)
 		flag.PrintDefaults()
@@ -53,41 +61,27 @@ func main() {
 			flag.Usage()
 		case "serve":
 			break
+		case "validate-config":
+			os.Exit(validateConfig(*cfg))
 		default:
 			fmt.Fprintf(os.Stderr, "Unknown flag: %s\n", flag.Arg(0))
 			os.Exit(2)
 		}
 	}
-	data, err := ioutil.ReadFile(*cfg)
-	c := new(config.FileConfig)
-	if err == nil {
-		if err := yaml.Unmarshal(data, c); err != nil {
-			logger.Error("Couldn't parse config file", "err", err)
-			os.Exit(2)
-		}
-	} else {
-		if *cfg != "config.yml" {
-			logger.Error("Couldn't find config file", "err", err)
-			os.Exit(2)
-		}
-		logger.Warn("Couldn't find config file, defaulting to localhost:4114")
-		c.Port = config.DefaultPort
-		c.Realm = services.Local
-	}
-	if c.Debug {
-		logger = handlers.NewLoggerLevel(log.LvlDebug)
-	}
-	settings, err := config.NewSettingsFromConfig(c, logger)
+	c, settings, err := loadConfig(*cfg)
 	if err != nil {
-		logger.Error("Error loading settings from config", "err", err)
+		logger.Error("Error loading config", "err", err)
 		os.Exit(2)
 	}
-	s, err := server.NewServer(settings)
+	s, err := server.NewReloader(settings.Logger, settings, func() (*config.Settings, error) {
+		_, settings, err := loadConfig(*cfg)
+		return settings, err
+	})
 	if err != nil {
 		logger.Error("Error creating the server", "err", err)
 		os.Exit(2)
 	}
-	s.CacheCommonQueries()
+	go reloadOnSignal(s)
 	publicMux := http.NewServeMux()
 	publicMux.Handle("/", s)
 	publicServer := http.Server{
@@ -100,9 +94,70 @@ func main() {
 		logger.Error("Error listening", "err", err, "port", c.Port)
 		os.Exit(2)
 	}
+	if settings.TLSConfig != nil {
+		listener = tls.NewListener(listener, settings.TLSConfig)
+	}
 	go func(p string) {
 		time.Sleep(30 * time.Millisecond)
 		logger.Info("Started server", "port", p, "public_host", settings.PublicHost)
 	}(c.Port)
 	publicServer.Serve(listener)
 }
+
+// loadConfig reads the config file at path and builds Settings from it. If
+// the default config file doesn't exist, Logrole serves on localhost:4114.
+func loadConfig(path string) (*config.FileConfig, *config.Settings, error) {
+	data, err := ioutil.ReadFile(path)
+	c := new(config.FileConfig)
+	if err == nil {
+		if err := yaml.Unmarshal(data, c); err != nil {
+			return nil, nil, fmt.Errorf("Couldn't parse config file: %v", err)
+		}
+	} else {
+		if path != "config.yml" {
+			return nil, nil, fmt.Errorf("Couldn't find config file: %v", err)
+		}
+		logger.Warn("Couldn't find config file, defaulting to localhost:4114")
+		c.Port = config.DefaultPort
+		c.Realm = services.Local
+	}
+	settings, err := config.NewSettingsFromConfig(c, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error loading settings from config: %v", err)
+	}
+	// The request log and anything else that logs with handlers.Logger use
+	// the configured format and default level too.
+	logger.SetHandler(settings.Logger.GetHandler())
+	return c, settings, nil
+}
+
+// validateConfig checks the config file at path, prints any problems it
+// finds, and returns the exit code for the process.
+func validateConfig(path string) int {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't read config file: %v\n", err)
+		return 2
+	}
+	errs := config.ValidateConfig(data)
+	if len(errs) == 0 {
+		fmt.Fprintf(os.Stdout, "%s is valid\n", path)
+		return 0
+	}
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+	}
+	fmt.Fprintf(os.Stderr, "\nFound %d problem(s) in %s\n", len(errs), path)
+	return 1
+}
+
+// reloadOnSignal reloads the config file every time the process gets a
+// SIGHUP.
+func reloadOnSignal(rl *server.Reloader) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		logger.Info("Got SIGHUP, reloading config")
+		rl.Reload()
+	}
+}
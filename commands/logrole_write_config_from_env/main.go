@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/kevinburke/handlers"
@@ -31,6 +32,10 @@ PUBLIC_HOST            Host your users will browse to to see the site
 
 TWILIO_ACCOUNT_SID     Account SID for your Twilio account
 TWILIO_AUTH_TOKEN      Auth token
+TWILIO_API_KEY_SID     Sid of an API key to use instead of the auth token
+TWILIO_API_KEY_SECRET  Secret for the API key
+TELNYX_API_KEY         API key for a Telnyx account, to show its messages and
+                       calls alongside Twilio's
 
 REALM                  Realm (either "local" or "prod")
 TZ                     Default timezone (example "America/Los_Angeles")
@@ -45,14 +50,142 @@ MAX_RESOURCE_AGE       How long resources should be visible for - "720h" to
                        hide anything older than 30 days
 SHOW_MEDIA_BY_DEFAULT  "false" to hide images behind a toggle when a user
                        browses to a MMS message.
+READ_ONLY              "true" to disable sending, deleting and other changes
+                       for every user.
+FEATURES               Comma-separated list of features to turn on or off, like
+                       "resend_messages=false,stream_lists=true"
+STUCK_MESSAGE_THRESHOLD
+                       Flag messages queued or sending for longer than this,
+                       like "15m"
+STUCK_MESSAGE_INTERVAL How often to check for stuck messages. Defaults to "5m"
+NUMBER_INVENTORY       "true" to snapshot the account's phone numbers and
+                       notify when numbers are purchased or released
+NUMBER_INVENTORY_INTERVAL
+                       How often to take a snapshot. Defaults to "24h"
+NUMBER_INVENTORY_FILE  Save phone number snapshots to this file
+NOTIFY_WEBHOOK_URL     POST notifications to this URL, like a Slack incoming
+                       webhook
+MEDIA_CACHE_DIR        Cache MMS media and recordings on disk in this directory
+MEDIA_CACHE_SIZE_MB    Maximum size of the media cache. Defaults to 512
+MEDIA_CACHE_TTL        How long to keep cached media. Defaults to "720h"
+EXPORTS_DIR            Write exports to this directory, so they resume after a
+                       restart
+MEDIA_SCAN_URL         POST MMS media to this URL to be scanned before it's
+                       shown
+ATTACHMENT_TEXT_URL    POST MMS media to this URL to extract its text, so
+                       messages can be searched by it
+ATTACHMENT_TEXT_FILE   Save extracted attachment text to this file, and load
+                       it on boot
+TRANSLATION_URL        POST message bodies to this URL to translate them
+TRANSLATION_LANGUAGE   Translate message bodies into this language. Defaults
+                       to "en"
+BODY_HASHING           Set to "true" to hash message bodies and report
+                       duplicate content
+DUPLICATE_BODY_RECIPIENTS
+                       Flag bodies sent to this many numbers at once. Defaults
+                       to 20
+DUPLICATE_BODY_WINDOW  How close together sends count as at once. Defaults to
+                       "10m"
+MAX_RECORDING_DOWNLOAD_MB
+                       Largest zip of recordings a user can download at once.
+                       Defaults to 500
+CACHE_SNAPSHOT_FILE    Save the API cache to this file, and load it on boot
+CACHE_SNAPSHOT_INTERVAL
+                       How often to save the API cache. Defaults to "10m"
+MAX_CACHE_SNAPSHOT_MB  Largest cache snapshot to write. Defaults to 50
+CACHE_REPLICATION_PEERS
+                       Comma-separated list of other Logrole servers to send
+                       cached API responses to, like "https://standby:4114"
+CACHE_REPLICATION_KB_PER_SECOND
+                       Most cached data to send each peer every second, in KB.
+                       Defaults to 1024
+CACHE_REPLICATION_KEY  64 byte hex key to encrypt cached data sent to peers.
+                       Must differ from SECRET_KEY
+CACHE_REPLICATION_SUBNETS
+                       Comma-separated list of subnets peers can send cached
+                       data from. Defaults to IP_SUBNETS
+CACHE_GENERATIONS      Keep this many cached API responses for each list, so
+                       admins can see older versions
+DISABLE_PREFETCH       Set to "true" to stop fetching the next page of each list
+                       into the cache in the background
+PREFETCH_WORKERS       How many next pages to fetch at once. Defaults to 4
+STORAGE_DRIVER         Keep labels, owners, tickets, notes, grants, the
+                       blocklist, sessions and the audit log in one store -
+                       "memory", "sqlite" or "postgres"
+STORAGE_DSN            Where the store keeps its data, like a SQLite file path
+                       or a Postgres connection URL
+LABELS_FILE            Save phone number labels to this CSV file
+OWNERS_FILE            Save the team and on-call rotation that own each phone
+                       number to this CSV file
+TICKET_LINKS           Comma-separated list of "Create ticket" links for message
+                       and call pages, in the format "Text=URL template"
+TICKETS_FILE           Save references to created tickets to this file
+NOTES_FILE             Save internal notes on messages, calls and alerts to
+                       this file
+GRANTS_FILE            Save temporary permissions granted from /admin/grants to
+                       this file
+AUDIT_LOG_FILE         Append audited actions, like granting permissions, to
+                       this file
+QUEUE_EVENTS_FILE      Save callers leaving queues, for the queue analytics
+                       page, to this file
+MESSAGE_STATUSES_FILE  Save the message statuses posted to /webhooks/messages
+                       to this file
+STATUS_RECONCILE_INTERVAL
+                       How often to re-fetch messages that haven't reached a
+                       final status. Defaults to "5m"
+RETENTION              Comma-separated list of how long to keep local data in
+                       each store, like "audit_log=8760h,exports=72h"
+RETENTION_INTERVAL     How often to purge expired local data. Defaults to "1h"
+RETENTION_DRY_RUN      "true" to log what would be purged without deleting it
+CORS_ALLOWED_ORIGINS   Comma-separated list of origins that can make requests
+                       from a browser, like "https://tools.example.com"
+CORS_ALLOWED_HEADERS   Comma-separated list of extra request headers those
+                       origins can send
+CORS_MAX_AGE           How long browsers can cache preflight responses, like
+                       "10m"
+CSRF_SAME_SITE         SameSite attribute of the CSRF token cookie: "lax",
+                       "strict" or "none". Defaults to "lax"
+CSP_REPORT_ONLY        "true" to report what the Content-Security-Policy would
+                       block without blocking it
+REFERRER_POLICY        Referrer-Policy header. Defaults to "same-origin"
+ARCHIVE_DIR            Serve data from the archive in this directory, instead
+                       of from Twilio
+MAX_TWILIO_CALLS_PER_REQUEST
+                       Fail Twilio API requests after this many for a single
+                       page
+MAX_CONCURRENT_TWILIO_REQUESTS
+                       The most requests to Twilio in progress at once
+TWILIO_CONCURRENCY_LIMITS
+                       Comma-separated list of limits for each type of
+                       resource, like "recordings=2,alerts=4"
+TWILIO_QUEUE_TIMEOUT   How long a request waits for a slot. Defaults to "5s"
 
-AUTH_SCHEME            "basic", "noop", or "google"
+PRODUCT_NAME           Name shown in the navigation bar, instead of "Logrole"
+LOGO_URL               Image shown next to the product name
+PRIMARY_COLOR          Navigation bar color, like "#1d5fa8"
+FOOTER_LINKS           Comma-separated list of links to show in the footer, in
+                       the format "Text=URL" (example
+                       "Runbook=https://wiki.example.com/twilio")
+
+AUTH_SCHEME            "basic", "noop", "google", "oidc", or "client_cert". Use
+                       a comma separated list to accept more than one.
 BASIC_AUTH_USER        For basic auth, the username
 BASIC_AUTH_PASSWORD    For basic auth, the password
 GOOGLE_CLIENT_ID       For Google OAuth
 GOOGLE_CLIENT_SECRET   For Google OAuth
 GOOGLE_ALLOWED_DOMAINS Comma separated list of domains to allow to
                        authenticate. If empty or omitted, all domains allowed.
+OIDC_ISSUER            For OpenID Connect, the provider's issuer URL
+OIDC_CLIENT_ID         For OpenID Connect
+OIDC_CLIENT_SECRET     For OpenID Connect
+OIDC_ALLOWED_DOMAINS   Comma separated list of domains to allow to
+                       authenticate with OpenID Connect.
+TLS_CERT_FILE          Serve HTTPS with this certificate
+TLS_KEY_FILE           Key for TLS_CERT_FILE
+CLIENT_CA_FILE         For client_cert auth, the CA certificates that sign
+                       client certificates
+REQUIRE_CLIENT_CERT    Reject connections without a client certificate signed
+                       by CLIENT_CA_FILE
 
 ERROR_REPORTER         "sentry", empty, or register your own.
 ERROR_REPORTER_TOKEN   Token for the error reporter.
@@ -113,6 +246,87 @@ func writeCommaSeparatedVal(w io.Writer, e environment, env string, cfgval strin
 	return false
 }
 
+// writeQuotedVal is like writeVal, but quotes the value, for values that
+// YAML would otherwise misinterpret, like "#1d5fa8".
+func writeQuotedVal(w io.Writer, e environment, env string, cfgval string) bool {
+	if v, ok := e.LookupEnv(env); ok {
+		_, err := fmt.Fprintf(w, "%s: %s\n", cfgval, strconv.Quote(v))
+		checkErr(err, "writing config")
+		return true
+	}
+	return false
+}
+
+// writeFeatures writes a comma-separated list of "name=true" or
+// "name=false" pairs as a map of features.
+func writeFeatures(w io.Writer, e environment, env string, cfgval string) bool {
+	if v, ok := e.LookupEnv(env); ok {
+		_, err := fmt.Fprintf(w, "%s:\n", cfgval)
+		checkErr(err, "writing config")
+		for _, val := range strings.Split(v, ",") {
+			parts := strings.SplitN(val, "=", 2)
+			if len(parts) != 2 {
+				checkErr(fmt.Errorf("%s should look like name=true, got %q", env, val), "writing config")
+			}
+			on, err := strconv.ParseBool(strings.TrimSpace(parts[1]))
+			checkErr(err, "writing config")
+			_, err = fmt.Fprintf(w, "  %s: %t\n", strings.TrimSpace(parts[0]), on)
+			checkErr(err, "writing config")
+		}
+		return true
+	}
+	return false
+}
+
+// writeMap writes a comma-separated list of "key=value" pairs as a map. The
+// values are written as they are.
+func writeMap(w io.Writer, e environment, env string, cfgval string) bool {
+	if v, ok := e.LookupEnv(env); ok {
+		_, err := fmt.Fprintf(w, "%s:\n", cfgval)
+		checkErr(err, "writing config")
+		for _, val := range strings.Split(v, ",") {
+			parts := strings.SplitN(val, "=", 2)
+			if len(parts) != 2 {
+				checkErr(fmt.Errorf("%s should look like key=value, got %q", env, val), "writing config")
+			}
+			_, err := fmt.Fprintf(w, "  %s: %s\n", strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+			checkErr(err, "writing config")
+		}
+		return true
+	}
+	return false
+}
+
+// writeProvider writes the API key in env as the credentials for the named
+// provider.
+func writeProvider(w io.Writer, e environment, env string, name string) bool {
+	if v, ok := e.LookupEnv(env); ok {
+		_, err := fmt.Fprintf(w, "providers:\n  %s:\n    api_key: %s\n", name, strconv.Quote(v))
+		checkErr(err, "writing config")
+		return true
+	}
+	return false
+}
+
+// writeLinks writes a comma-separated list of "Text=URL" pairs as a list of
+// links.
+func writeLinks(w io.Writer, e environment, env string, cfgval string) bool {
+	if v, ok := e.LookupEnv(env); ok {
+		_, err := fmt.Fprintf(w, "%s:\n", cfgval)
+		checkErr(err, "writing config")
+		for _, val := range strings.Split(v, ",") {
+			parts := strings.SplitN(val, "=", 2)
+			if len(parts) != 2 {
+				checkErr(fmt.Errorf("%s should look like Text=URL, got %q", env, val), "writing config")
+			}
+			_, err := fmt.Fprintf(w, "  - text: %s\n    url: %s\n", strconv.Quote(strings.TrimSpace(parts[0])), strconv.Quote(strings.TrimSpace(parts[1])))
+			checkErr(err, "writing config")
+		}
+		return true
+	}
+	return false
+}
+
 func writeConfig(b *bytes.Buffer, e environment) {
 	var ok bool
 	ok = writeVal(b, e, "PORT", "port") || ok
@@ -124,6 +338,9 @@ func writeConfig(b *bytes.Buffer, e environment) {
 	}
 	ok = writeVal(b, e, "TWILIO_ACCOUNT_SID", "twilio_account_sid") || ok
 	ok = writeVal(b, e, "TWILIO_AUTH_TOKEN", "twilio_auth_token") || ok
+	ok = writeVal(b, e, "TWILIO_API_KEY_SID", "twilio_api_key_sid") || ok
+	ok = writeVal(b, e, "TWILIO_API_KEY_SECRET", "twilio_api_key_secret") || ok
+	ok = writeProvider(b, e, "TELNYX_API_KEY", "telnyx") || ok
 	if ok {
 		b.WriteByte('\n')
 		ok = false
@@ -140,6 +357,71 @@ func writeConfig(b *bytes.Buffer, e environment) {
 	ok = writeVal(b, e, "SECRET_KEY", "secret_key") || ok
 	ok = writeVal(b, e, "MAX_RESOURCE_AGE", "max_resource_age") || ok
 	ok = writeVal(b, e, "SHOW_MEDIA_BY_DEFAULT", "show_media_by_default") || ok
+	ok = writeVal(b, e, "READ_ONLY", "read_only") || ok
+	ok = writeFeatures(b, e, "FEATURES", "features") || ok
+	ok = writeVal(b, e, "STUCK_MESSAGE_THRESHOLD", "stuck_message_threshold") || ok
+	ok = writeVal(b, e, "STUCK_MESSAGE_INTERVAL", "stuck_message_interval") || ok
+	ok = writeVal(b, e, "NUMBER_INVENTORY", "number_inventory") || ok
+	ok = writeVal(b, e, "NUMBER_INVENTORY_INTERVAL", "number_inventory_interval") || ok
+	ok = writeQuotedVal(b, e, "NUMBER_INVENTORY_FILE", "number_inventory_file") || ok
+	ok = writeQuotedVal(b, e, "NOTIFY_WEBHOOK_URL", "notify_webhook_url") || ok
+	ok = writeQuotedVal(b, e, "MEDIA_CACHE_DIR", "media_cache_dir") || ok
+	ok = writeVal(b, e, "MEDIA_CACHE_SIZE_MB", "media_cache_size_mb") || ok
+	ok = writeVal(b, e, "MEDIA_CACHE_TTL", "media_cache_ttl") || ok
+	ok = writeQuotedVal(b, e, "EXPORTS_DIR", "exports_dir") || ok
+	ok = writeQuotedVal(b, e, "MEDIA_SCAN_URL", "media_scan_url") || ok
+	ok = writeQuotedVal(b, e, "ATTACHMENT_TEXT_URL", "attachment_text_url") || ok
+	ok = writeQuotedVal(b, e, "ATTACHMENT_TEXT_FILE", "attachment_text_file") || ok
+	ok = writeQuotedVal(b, e, "TRANSLATION_URL", "translation_url") || ok
+	ok = writeQuotedVal(b, e, "TRANSLATION_LANGUAGE", "translation_language") || ok
+	ok = writeVal(b, e, "BODY_HASHING", "body_hashing") || ok
+	ok = writeVal(b, e, "DUPLICATE_BODY_RECIPIENTS", "duplicate_body_recipients") || ok
+	ok = writeVal(b, e, "DUPLICATE_BODY_WINDOW", "duplicate_body_window") || ok
+	ok = writeVal(b, e, "MAX_RECORDING_DOWNLOAD_MB", "max_recording_download_mb") || ok
+	ok = writeQuotedVal(b, e, "CACHE_SNAPSHOT_FILE", "cache_snapshot_file") || ok
+	ok = writeVal(b, e, "CACHE_SNAPSHOT_INTERVAL", "cache_snapshot_interval") || ok
+	ok = writeVal(b, e, "MAX_CACHE_SNAPSHOT_MB", "max_cache_snapshot_mb") || ok
+	ok = writeCommaSeparatedVal(b, e, "CACHE_REPLICATION_PEERS", "cache_replication_peers") || ok
+	ok = writeVal(b, e, "CACHE_REPLICATION_KB_PER_SECOND", "cache_replication_kb_per_second") || ok
+	ok = writeVal(b, e, "CACHE_REPLICATION_KEY", "cache_replication_key") || ok
+	ok = writeCommaSeparatedVal(b, e, "CACHE_REPLICATION_SUBNETS", "cache_replication_subnets") || ok
+	ok = writeVal(b, e, "CACHE_GENERATIONS", "cache_generations") || ok
+	ok = writeVal(b, e, "DISABLE_PREFETCH", "disable_prefetch") || ok
+	ok = writeVal(b, e, "PREFETCH_WORKERS", "prefetch_workers") || ok
+	ok = writeQuotedVal(b, e, "STORAGE_DRIVER", "storage_driver") || ok
+	ok = writeQuotedVal(b, e, "STORAGE_DSN", "storage_dsn") || ok
+	ok = writeQuotedVal(b, e, "LABELS_FILE", "labels_file") || ok
+	ok = writeQuotedVal(b, e, "OWNERS_FILE", "owners_file") || ok
+	ok = writeLinks(b, e, "TICKET_LINKS", "ticket_links") || ok
+	ok = writeQuotedVal(b, e, "TICKETS_FILE", "tickets_file") || ok
+	ok = writeQuotedVal(b, e, "NOTES_FILE", "notes_file") || ok
+	ok = writeQuotedVal(b, e, "GRANTS_FILE", "grants_file") || ok
+	ok = writeQuotedVal(b, e, "AUDIT_LOG_FILE", "audit_log_file") || ok
+	ok = writeQuotedVal(b, e, "QUEUE_EVENTS_FILE", "queue_events_file") || ok
+	ok = writeQuotedVal(b, e, "MESSAGE_STATUSES_FILE", "message_statuses_file") || ok
+	ok = writeVal(b, e, "STATUS_RECONCILE_INTERVAL", "status_reconcile_interval") || ok
+	ok = writeMap(b, e, "RETENTION", "retention") || ok
+	ok = writeVal(b, e, "RETENTION_INTERVAL", "retention_interval") || ok
+	ok = writeVal(b, e, "RETENTION_DRY_RUN", "retention_dry_run") || ok
+	ok = writeCommaSeparatedVal(b, e, "CORS_ALLOWED_ORIGINS", "cors_allowed_origins") || ok
+	ok = writeCommaSeparatedVal(b, e, "CORS_ALLOWED_HEADERS", "cors_allowed_headers") || ok
+	ok = writeVal(b, e, "CORS_MAX_AGE", "cors_max_age") || ok
+	ok = writeVal(b, e, "CSRF_SAME_SITE", "csrf_same_site") || ok
+	ok = writeVal(b, e, "CSP_REPORT_ONLY", "csp_report_only") || ok
+	ok = writeVal(b, e, "REFERRER_POLICY", "referrer_policy") || ok
+	ok = writeQuotedVal(b, e, "ARCHIVE_DIR", "archive_dir") || ok
+	ok = writeVal(b, e, "MAX_TWILIO_CALLS_PER_REQUEST", "max_twilio_calls_per_request") || ok
+	ok = writeVal(b, e, "MAX_CONCURRENT_TWILIO_REQUESTS", "max_concurrent_twilio_requests") || ok
+	ok = writeMap(b, e, "TWILIO_CONCURRENCY_LIMITS", "twilio_concurrency_limits") || ok
+	ok = writeVal(b, e, "TWILIO_QUEUE_TIMEOUT", "twilio_queue_timeout") || ok
+	if ok {
+		b.WriteByte('\n')
+		ok = false
+	}
+	ok = writeQuotedVal(b, e, "PRODUCT_NAME", "product_name") || ok
+	ok = writeQuotedVal(b, e, "LOGO_URL", "logo_url") || ok
+	ok = writeQuotedVal(b, e, "PRIMARY_COLOR", "primary_color") || ok
+	ok = writeLinks(b, e, "FOOTER_LINKS", "footer_links") || ok
 	if ok {
 		b.WriteByte('\n')
 		ok = false
@@ -150,6 +432,14 @@ func writeConfig(b *bytes.Buffer, e environment) {
 	ok = writeVal(b, e, "GOOGLE_CLIENT_ID", "google_client_id") || ok
 	ok = writeVal(b, e, "GOOGLE_CLIENT_SECRET", "google_client_secret") || ok
 	ok = writeCommaSeparatedVal(b, e, "GOOGLE_ALLOWED_DOMAINS", "google_allowed_domains") || ok
+	ok = writeVal(b, e, "OIDC_ISSUER", "oidc_issuer") || ok
+	ok = writeVal(b, e, "OIDC_CLIENT_ID", "oidc_client_id") || ok
+	ok = writeVal(b, e, "OIDC_CLIENT_SECRET", "oidc_client_secret") || ok
+	ok = writeCommaSeparatedVal(b, e, "OIDC_ALLOWED_DOMAINS", "oidc_allowed_domains") || ok
+	ok = writeVal(b, e, "TLS_CERT_FILE", "tls_cert_file") || ok
+	ok = writeVal(b, e, "TLS_KEY_FILE", "tls_key_file") || ok
+	ok = writeVal(b, e, "CLIENT_CA_FILE", "client_ca_file") || ok
+	ok = writeVal(b, e, "REQUIRE_CLIENT_CERT", "require_client_cert") || ok
 	if ok {
 		b.WriteByte('\n')
 		ok = false
@@ -103,3 +103,66 @@ func TestValidateUnknownURL(t *testing.T) {
 		t.Errorf("Wrong error: %v", err)
 	}
 }
+
+func TestWriteBranding(t *testing.T) {
+	t.Parallel()
+	e := &dummyEnvironment{
+		env: map[string]string{
+			"PRIMARY_COLOR": "#1d5fa8",
+			"FOOTER_LINKS":  "Runbook=https://wiki.example.com/twilio?a=b, Status=https://status.example.com",
+		},
+	}
+	buf := new(bytes.Buffer)
+	writeConfig(buf, e)
+	expected := `primary_color: "#1d5fa8"
+footer_links:
+  - text: "Runbook"
+    url: "https://wiki.example.com/twilio?a=b"
+  - text: "Status"
+    url: "https://status.example.com"
+
+`
+	if s := buf.String(); s != expected {
+		t.Errorf("expected config to be %s, got %s", expected, s)
+	}
+}
+
+func TestWriteFeatures(t *testing.T) {
+	t.Parallel()
+	e := &dummyEnvironment{
+		env: map[string]string{
+			"FEATURES": "resend_messages=false, stream_lists=1",
+		},
+	}
+	buf := new(bytes.Buffer)
+	writeConfig(buf, e)
+	expected := `features:
+  resend_messages: false
+  stream_lists: true
+
+`
+	if s := buf.String(); s != expected {
+		t.Errorf("expected config to be %s, got %s", expected, s)
+	}
+}
+
+func TestWriteProvider(t *testing.T) {
+	t.Parallel()
+	e := &dummyEnvironment{
+		env: map[string]string{
+			"TWILIO_ACCOUNT_SID": "AC123",
+			"TELNYX_API_KEY":     "KEY123",
+		},
+	}
+	buf := new(bytes.Buffer)
+	writeConfig(buf, e)
+	expected := `twilio_account_sid: AC123
+providers:
+  telnyx:
+    api_key: "KEY123"
+
+`
+	if s := buf.String(); s != expected {
+		t.Errorf("expected config to be %s, got %s", expected, s)
+	}
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// An APIKey is a Twilio API key, which Logrole can use to talk to the Twilio
+// API instead of the account's auth token. Either a standard or a main key
+// works; a standard key can't manage other keys or subaccounts, which
+// Logrole never needs to do.
+type APIKey struct {
+	Sid    string `yaml:"sid"`
+	Secret string `yaml:"secret"`
+}
+
+// Validate returns an error if k doesn't look like an API key.
+func (k *APIKey) Validate() error {
+	if !strings.HasPrefix(k.Sid, "SK") {
+		return fmt.Errorf("API key sid should start with \"SK\", got %q", k.Sid)
+	}
+	if k.Secret == "" {
+		return fmt.Errorf("API key %s has no secret", k.Sid)
+	}
+	return nil
+}
+
+// apiKey returns the API key configured for c.AccountSid, or nil if the auth
+// token should be used. A key in twilio_api_keys for the account wins over
+// twilio_api_key_sid, so one config file can hold keys for several
+// subaccounts.
+func (c *FileConfig) apiKey() (*APIKey, error) {
+	if k, ok := c.APIKeys[c.AccountSid]; ok {
+		if err := k.Validate(); err != nil {
+			return nil, fmt.Errorf("twilio_api_keys: %v", err)
+		}
+		return &k, nil
+	}
+	if c.APIKeySid == "" && c.APIKeySecret == "" {
+		return nil, nil
+	}
+	k := &APIKey{Sid: c.APIKeySid, Secret: c.APIKeySecret}
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// NewTwilioClient returns a client for the Twilio account accountSid. If key
+// is non-nil, requests are authenticated with it instead of authToken;
+// authToken is still kept on the client, because Twilio signs webhooks with
+// it, but it may be empty.
+func NewTwilioClient(accountSid, authToken string, key *APIKey, httpClient *http.Client) *twilio.Client {
+	if key == nil {
+		return twilio.NewClient(accountSid, authToken, httpClient)
+	}
+	client := twilio.NewClient(accountSid, key.Secret, httpClient)
+	// Resource URLs still use the account sid, but requests authenticate as
+	// the key.
+	client.Client.ID = key.Sid
+	client.Monitor.Client.ID = key.Sid
+	client.AuthToken = authToken
+	client.Monitor.AuthToken = authToken
+	return client
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestAPIKeyForAccount(t *testing.T) {
+	t.Parallel()
+	c := &FileConfig{
+		AccountSid:   "AC456",
+		APIKeySid:    "SK123",
+		APIKeySecret: "secret",
+		APIKeys:      map[string]APIKey{"AC456": {Sid: "SK456", Secret: "secret456"}},
+	}
+	k, err := c.apiKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k == nil || k.Sid != "SK456" {
+		t.Errorf("expected the subaccount's key, got %v", k)
+	}
+	c.AccountSid = "AC123"
+	if k, _ := c.apiKey(); k == nil || k.Sid != "SK123" {
+		t.Errorf("expected the default key, got %v", k)
+	}
+	c.APIKeySid = "AC123"
+	if _, err := c.apiKey(); err == nil {
+		t.Error("expected an account sid to be rejected as a key sid")
+	}
+	if k, err := (&FileConfig{AccountSid: "AC123"}).apiKey(); k != nil || err != nil {
+		t.Errorf("expected no key, got %v, %v", k, err)
+	}
+}
+
+func TestTwilioClientUsesAPIKey(t *testing.T) {
+	t.Parallel()
+	var user, pass string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ = r.BasicAuth()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"sid": "SM123", "date_created": "Tue, 18 Oct 2016 17:00:00 +0000"}`))
+	}))
+	defer ts.Close()
+	client := NewTwilioClient("AC123", "token", &APIKey{Sid: "SK123", Secret: "secret"}, nil)
+	client.Base = ts.URL
+	if _, err := client.Messages.Get(context.Background(), "SM123"); err != nil {
+		t.Fatal(err)
+	}
+	if user != "SK123" || pass != "secret" {
+		t.Errorf("expected requests to authenticate with the API key, got %q, %q", user, pass)
+	}
+	if client.AuthToken != "token" {
+		t.Errorf("expected the auth token to be kept for webhook signatures, got %q", client.AuthToken)
+	}
+}
@@ -28,6 +28,13 @@ type Authenticator interface {
 	Logout(http.ResponseWriter, *http.Request)
 }
 
+// A CredentialChecker reports whether a request carries credentials that it
+// can check, like a Basic Auth header or a login cookie. ChainAuthenticator
+// uses this to pick which Authenticator to try.
+type CredentialChecker interface {
+	HasCredentials(*http.Request) bool
+}
+
 // NoopAuthenticator returns the given User in response to all Authenticate
 // requests.
 type NoopAuthenticator struct {
@@ -140,12 +147,22 @@ func (b *BasicAuthAuthenticator) Authenticate(w http.ResponseWriter, r *http.Req
 	}
 }
 
+// HasCredentials returns true if the request has a Basic Auth header.
+func (b *BasicAuthAuthenticator) HasCredentials(r *http.Request) bool {
+	_, _, ok := r.BasicAuth()
+	return ok
+}
+
 func (b *BasicAuthAuthenticator) Logout(w http.ResponseWriter, r *http.Request) {
 	// There's apparently no good way to do this.
 	// http://stackoverflow.com/a/449914/329700
 }
 
-type GoogleAuthenticator struct {
+// OIDCAuthenticator authenticates users by sending them to an OpenID Connect
+// provider to login. Once the provider sends them back, we look up their
+// email address via the provider's userinfo endpoint and set an encrypted
+// cookie.
+type OIDCAuthenticator struct {
 	log.Logger
 	AllowUnencryptedTraffic bool
 	Conf                    *oauth2.Config
@@ -154,7 +171,74 @@ type GoogleAuthenticator struct {
 	allowedDomains          []string
 	secretKey               *[32]byte
 	policy                  *Policy
+	userInfo                func(context.Context, *http.Client) (*services.GoogleUser, error)
 	mu                      sync.Mutex
+
+	// If not nil, every login starts a session in this store, and a login
+	// cookie is only valid while its session is.
+	Sessions SessionStore
+}
+
+// An OIDCProvider holds the endpoints of an OpenID Connect provider. Call
+// DiscoverOIDCProvider to fetch them from the provider's configuration.
+type OIDCProvider struct {
+	AuthURL     string `json:"authorization_endpoint"`
+	TokenURL    string `json:"token_endpoint"`
+	UserInfoURL string `json:"userinfo_endpoint"`
+}
+
+// DiscoverOIDCProvider fetches the endpoints for the provider at issuer, for
+// example "https://accounts.google.com", from its
+// /.well-known/openid-configuration document.
+func DiscoverOIDCProvider(ctx context.Context, issuer string) (*OIDCProvider, error) {
+	rc := rest.NewClient("", "", strings.TrimSuffix(issuer, "/"))
+	req, err := rc.NewRequest("GET", "/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	p := new(OIDCProvider)
+	if err := rc.Do(req, p); err != nil {
+		return nil, err
+	}
+	if p.AuthURL == "" || p.TokenURL == "" || p.UserInfoURL == "" {
+		return nil, fmt.Errorf("OpenID configuration for %s is missing an endpoint", issuer)
+	}
+	return p, nil
+}
+
+// NewOIDCAuthenticator creates a new OIDCAuthenticator that logs users in
+// with the given provider. Users are sent back to baseURL + "/auth/callback"
+// after they login.
+func NewOIDCAuthenticator(logger log.Logger, provider *OIDCProvider, clientID string, clientSecret string, baseURL string, allowedDomains []string, secretKey *[32]byte) *OIDCAuthenticator {
+	conf := &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  baseURL + "/auth/callback",
+		Scopes: []string{
+			"openid",
+			"profile",
+			"email",
+		},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  provider.AuthURL,
+			TokenURL: provider.TokenURL,
+		},
+	}
+	return &OIDCAuthenticator{
+		Logger:         logger,
+		Conf:           conf,
+		allowedDomains: allowedDomains,
+		secretKey:      secretKey,
+		userInfo: func(ctx context.Context, client *http.Client) (*services.GoogleUser, error) {
+			return services.GetUserInfo(ctx, client, provider.UserInfoURL)
+		},
+	}
+}
+
+// GoogleAuthenticator authenticates users via Google login.
+type GoogleAuthenticator struct {
+	*OIDCAuthenticator
 }
 
 // NewGoogleAuthenticator creates a new GoogleAuthenticator that can
@@ -174,12 +258,13 @@ func NewGoogleAuthenticator(logger log.Logger, clientID string, clientSecret str
 		},
 		Endpoint: google.Endpoint,
 	}
-	return &GoogleAuthenticator{
+	return &GoogleAuthenticator{&OIDCAuthenticator{
 		Logger:         logger,
 		Conf:           conf,
 		allowedDomains: allowedDomains,
 		secretKey:      secretKey,
-	}
+		userInfo:       services.GetGoogleUserData,
+	}}
 }
 
 type state struct {
@@ -187,11 +272,14 @@ type state struct {
 	Time       time.Time
 }
 
+// An OAuthAuthenticator sends users to a third party site to login. If
+// Authenticate returns MustLogin, the login page links to URL.
 type OAuthAuthenticator interface {
+	Authenticator
 	URL(http.ResponseWriter, *http.Request) string
 }
 
-func (g *GoogleAuthenticator) URL(w http.ResponseWriter, r *http.Request) string {
+func (g *OIDCAuthenticator) URL(w http.ResponseWriter, r *http.Request) string {
 	var uri string
 	if g := r.URL.Query().Get("g"); g != "" {
 		// prevent open redirect by only using the Path part
@@ -219,7 +307,7 @@ func (g *GoogleAuthenticator) URL(w http.ResponseWriter, r *http.Request) string
 
 const AuthTimeout = 1 * time.Hour
 
-func (g *GoogleAuthenticator) validState(encrypted string) (string, bool) {
+func (g *OIDCAuthenticator) validState(encrypted string) (string, bool) {
 	b, err := services.UnopaqueByte(encrypted, g.secretKey)
 	if err != nil {
 		return "", false
@@ -234,22 +322,29 @@ func (g *GoogleAuthenticator) validState(encrypted string) (string, bool) {
 	return st.CurrentURL, true
 }
 
+// GoogleTimeout is the timeout for requests to Google, or to other OpenID
+// Connect providers.
 const GoogleTimeout = 5 * time.Second
 
 type token struct {
 	ID     string
 	Expiry time.Time
+	// The ID of the login's session, if sessions are stored on the server.
+	Session string `json:",omitempty"`
 }
 
 func newToken(id string) *token {
 	return &token{
 		ID:     id,
-		Expiry: time.Now().UTC().Add(14 * 24 * time.Hour),
+		Expiry: time.Now().UTC().Add(SessionDuration),
 	}
 }
 
-func (g *GoogleAuthenticator) newCookie(id string) *http.Cookie {
-	t := newToken(id)
+func (g *OIDCAuthenticator) newCookie(id string) *http.Cookie {
+	return g.tokenCookie(newToken(id))
+}
+
+func (g *OIDCAuthenticator) tokenCookie(t *token) *http.Cookie {
 	b, err := json.Marshal(t)
 	if err != nil {
 		panic(err)
@@ -265,7 +360,7 @@ func (g *GoogleAuthenticator) newCookie(id string) *http.Cookie {
 	}
 }
 
-func (g *GoogleAuthenticator) handleGoogleCallback(w http.ResponseWriter, r *http.Request) error {
+func (g *OIDCAuthenticator) handleCallback(w http.ResponseWriter, r *http.Request) error {
 	query := r.URL.Query()
 	st := query.Get("state")
 	currentURL, ok := g.validState(st)
@@ -293,7 +388,7 @@ func (g *GoogleAuthenticator) handleGoogleCallback(w http.ResponseWriter, r *htt
 	}
 
 	client := g.Conf.Client(ctx, tok)
-	u, err := services.GetGoogleUserData(ctx, client)
+	u, err := g.userInfo(ctx, client)
 	if err != nil {
 		rest.ServerError(w, r, err)
 		return err
@@ -309,13 +404,21 @@ func (g *GoogleAuthenticator) handleGoogleCallback(w http.ResponseWriter, r *htt
 		rest.Forbidden(w, r, restErr)
 		return lookupErr
 	}
-	cookie := g.newCookie(u.Email)
-	http.SetCookie(w, cookie)
+	t := newToken(u.Email)
+	if g.Sessions != nil {
+		session, err := g.Sessions.Create(u.Email, t.Expiry, r.UserAgent())
+		if err != nil {
+			rest.ServerError(w, r, err)
+			return err
+		}
+		t.Session = session.ID
+	}
+	http.SetCookie(w, g.tokenCookie(t))
 	http.Redirect(w, r, currentURL, 302)
 	return errors.New("redirected, make another request")
 }
 
-func (g *GoogleAuthenticator) permitted(id string) error {
+func (g *OIDCAuthenticator) permitted(id string) error {
 	if len(g.allowedDomains) > 0 {
 		domainMatch := false
 		for _, domain := range g.allowedDomains {
@@ -333,29 +436,28 @@ func (g *GoogleAuthenticator) permitted(id string) error {
 
 var MustLogin = errors.New("Need to login")
 
-func (g *GoogleAuthenticator) Authenticate(w http.ResponseWriter, r *http.Request) (*User, error) {
+func (g *OIDCAuthenticator) Authenticate(w http.ResponseWriter, r *http.Request) (*User, error) {
 	if r.URL.Path == "/auth/callback" {
-		err := g.handleGoogleCallback(w, r)
+		err := g.handleCallback(w, r)
 		return nil, err
 	}
 	// Check if the request has a valid cookie, if so allow it.
-	cookie, err := r.Cookie("token")
-	if err != nil {
-		return nil, MustLogin
-	}
-	val, err := services.UnopaqueByte(cookie.Value, g.secretKey)
-	if err != nil {
-		// need a 400 bad request here
-		return nil, MustLogin
-	}
-	t := new(token)
-	if err := json.Unmarshal(val, t); err != nil {
+	t, ok := g.readToken(r)
+	if !ok {
 		return nil, MustLogin
 	}
 	if t.Expiry.Before(time.Now().UTC()) {
 		// TODO logout
 		return nil, MustLogin
 	}
+	if g.Sessions != nil {
+		// The session was revoked, or the cookie is from before sessions
+		// were stored on the server.
+		if _, ok := g.Sessions.Get(t.Session, time.Now()); !ok {
+			http.SetCookie(w, g.expiredCookie())
+			return nil, MustLogin
+		}
+	}
 	// if you got to this point you have a valid login cookie, don't show you
 	// the login page.
 	if r.URL.Path == "/login" {
@@ -372,7 +474,34 @@ func (g *GoogleAuthenticator) Authenticate(w http.ResponseWriter, r *http.Reques
 	return u, nil
 }
 
-func (g *GoogleAuthenticator) lookupUser(id string) (*User, error) {
+// readToken decrypts the login cookie in r, if there is one.
+func (g *OIDCAuthenticator) readToken(r *http.Request) (*token, bool) {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		return nil, false
+	}
+	val, err := services.UnopaqueByte(cookie.Value, g.secretKey)
+	if err != nil {
+		return nil, false
+	}
+	t := new(token)
+	if err := json.Unmarshal(val, t); err != nil {
+		return nil, false
+	}
+	return t, true
+}
+
+// HasCredentials returns true if the request has a login cookie, or is the
+// provider sending the user back after they login.
+func (g *OIDCAuthenticator) HasCredentials(r *http.Request) bool {
+	if r.URL.Path == "/auth/callback" {
+		return true
+	}
+	_, err := r.Cookie("token")
+	return err == nil
+}
+
+func (g *OIDCAuthenticator) lookupUser(id string) (*User, error) {
 	if g.policy == nil {
 		// no policy, only check whether domain is permitted and return
 		// DefaultUser
@@ -405,19 +534,32 @@ func (g *GoogleAuthenticator) lookupUser(id string) (*User, error) {
 	}
 }
 
-func (g *GoogleAuthenticator) SetPolicy(p *Policy) {
+func (g *OIDCAuthenticator) SetPolicy(p *Policy) {
 	g.mu.Lock()
 	g.policy = p
 	g.mu.Unlock()
 }
 
-func (g *GoogleAuthenticator) Logout(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{
+// Logout clears the login cookie, and ends its session if sessions are
+// stored on the server.
+func (g *OIDCAuthenticator) Logout(w http.ResponseWriter, r *http.Request) {
+	if g.Sessions != nil {
+		if t, ok := g.readToken(r); ok && t.Session != "" {
+			if _, err := g.Sessions.Revoke(t.Session); err != nil {
+				g.Warn("Couldn't end session on logout", "err", err)
+			}
+		}
+	}
+	http.SetCookie(w, g.expiredCookie())
+	http.Redirect(w, r, "/", 302)
+}
+
+func (g *OIDCAuthenticator) expiredCookie() *http.Cookie {
+	return &http.Cookie{
 		Name:     "token",
 		Secure:   g.AllowUnencryptedTraffic == false,
 		HttpOnly: true,
 		MaxAge:   -1,
 		Path:     "/",
-	})
-	http.Redirect(w, r, "/", 302)
+	}
 }
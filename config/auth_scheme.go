@@ -0,0 +1,236 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+	"golang.org/x/net/context"
+)
+
+// AuthOptions holds settings that every auth scheme may need.
+type AuthOptions struct {
+	Logger log.Logger
+	// The URL of the site, like "https://logrole.example.com". OAuth
+	// providers send users back here after they login.
+	BaseURL                 string
+	AllowUnencryptedTraffic bool
+	SecretKey               *[32]byte
+	// Where the google and oidc schemes store logins. May be nil.
+	Sessions SessionStore
+}
+
+// An AuthScheme builds an Authenticator from the config file.
+type AuthScheme func(c *FileConfig, o *AuthOptions) (Authenticator, error)
+
+var authSchemes = map[string]AuthScheme{}
+var authSchemeMu sync.Mutex
+
+func init() {
+	RegisterAuthScheme("noop", newNoopScheme)
+	RegisterAuthScheme("basic", newBasicScheme)
+	RegisterAuthScheme("google", newGoogleScheme)
+	RegisterAuthScheme("oidc", newOIDCScheme)
+	RegisterAuthScheme("client_cert", newClientCertScheme)
+}
+
+// RegisterAuthScheme allows the AuthScheme with the given name to be used in
+// the auth_scheme setting. Use this to add your own authentication provider.
+//
+// Call RegisterAuthScheme(name, nil) to delete a scheme.
+func RegisterAuthScheme(name string, s AuthScheme) {
+	authSchemeMu.Lock()
+	defer authSchemeMu.Unlock()
+	if s == nil {
+		delete(authSchemes, name)
+		return
+	}
+	authSchemes[name] = s
+}
+
+func getAuthScheme(name string) (AuthScheme, bool) {
+	authSchemeMu.Lock()
+	defer authSchemeMu.Unlock()
+	s, ok := authSchemes[name]
+	return s, ok
+}
+
+// newAuthenticator builds the Authenticator for c.AuthScheme, which is a
+// comma separated list of scheme names. If more than one scheme is listed,
+// they are combined with a ChainAuthenticator.
+func newAuthenticator(c *FileConfig, o *AuthOptions) (Authenticator, error) {
+	names := strings.Split(c.AuthScheme, ",")
+	authenticators := make([]Authenticator, 0, len(names))
+	oauthCount := 0
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			if len(names) > 1 {
+				return nil, fmt.Errorf("Empty auth scheme in %q", c.AuthScheme)
+			}
+			name = "noop"
+		}
+		scheme, ok := getAuthScheme(name)
+		if !ok && name == "saml" {
+			return nil, errors.New("Logrole doesn't include a saml auth scheme yet. Add one with config.RegisterAuthScheme, see docs/settings.md#custom-schemes")
+		}
+		if !ok {
+			return nil, fmt.Errorf("Unknown auth scheme: %s. Valid schemes are: %s", name, strings.Join(AuthSchemes(), ", "))
+		}
+		a, err := scheme(c, o)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) > 1 {
+			if _, ok := a.(CredentialChecker); !ok {
+				return nil, fmt.Errorf("Auth scheme %s can't be combined with other auth schemes", name)
+			}
+			if _, ok := a.(OAuthAuthenticator); ok {
+				oauthCount++
+			}
+		}
+		authenticators = append(authenticators, a)
+	}
+	if oauthCount > 1 {
+		return nil, errors.New("Only one of the google and oidc auth schemes can be used at a time")
+	}
+	if len(authenticators) == 1 {
+		return authenticators[0], nil
+	}
+	return NewChainAuthenticator(authenticators...), nil
+}
+
+// AuthSchemes returns the names of the registered auth schemes.
+func AuthSchemes() []string {
+	authSchemeMu.Lock()
+	defer authSchemeMu.Unlock()
+	names := make([]string, 0, len(authSchemes))
+	for name := range authSchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newNoopScheme(c *FileConfig, o *AuthOptions) (Authenticator, error) {
+	o.Logger.Warn("Disabling basic authentication")
+	return &NoopAuthenticator{User: DefaultUser}, nil
+}
+
+func newBasicScheme(c *FileConfig, o *AuthOptions) (Authenticator, error) {
+	if c.User == "" || c.Password == "" {
+		return nil, errors.New("Cannot use basic auth without a username or password, set a basic_auth_user")
+	}
+	ba := NewBasicAuthAuthenticator("logrole")
+	ba.AddUserPassword(c.User, c.Password)
+	return ba, nil
+}
+
+func newGoogleScheme(c *FileConfig, o *AuthOptions) (Authenticator, error) {
+	if c.GoogleClientID == "" || c.GoogleClientSecret == "" {
+		return nil, missingGoogleCredentials
+	}
+	g := NewGoogleAuthenticator(o.Logger, c.GoogleClientID, c.GoogleClientSecret, o.BaseURL, c.GoogleAllowedDomains, o.SecretKey)
+	g.AllowUnencryptedTraffic = o.AllowUnencryptedTraffic
+	g.Sessions = o.Sessions
+	return g, nil
+}
+
+func newOIDCScheme(c *FileConfig, o *AuthOptions) (Authenticator, error) {
+	if c.OIDCIssuer == "" || c.OIDCClientID == "" || c.OIDCClientSecret == "" {
+		return nil, errors.New("Cannot use oidc auth without an oidc_issuer, oidc_client_id and oidc_client_secret")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), GoogleTimeout)
+	defer cancel()
+	provider, err := DiscoverOIDCProvider(ctx, c.OIDCIssuer)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't load OpenID configuration for %s: %v", c.OIDCIssuer, err)
+	}
+	a := NewOIDCAuthenticator(o.Logger, provider, c.OIDCClientID, c.OIDCClientSecret, o.BaseURL, c.OIDCAllowedDomains, o.SecretKey)
+	a.AllowUnencryptedTraffic = o.AllowUnencryptedTraffic
+	a.Sessions = o.Sessions
+	return a, nil
+}
+
+func newClientCertScheme(c *FileConfig, o *AuthOptions) (Authenticator, error) {
+	if c.TLSCertFile == "" || c.ClientCAFile == "" {
+		return nil, errors.New("Cannot use client_cert auth unless Logrole serves TLS, set a tls_cert_file, tls_key_file and client_ca_file")
+	}
+	return NewClientCertAuthenticator(), nil
+}
+
+// ChainAuthenticator tries several Authenticators in order. A request is
+// checked by the first Authenticator that finds credentials in it, so for
+// example a request with a client certificate can skip the Google login.
+//
+// If the request has no credentials at all, users are asked to login with the
+// OAuth provider in the chain, if there is one, and otherwise with the last
+// Authenticator in the chain.
+type ChainAuthenticator struct {
+	Authenticators []Authenticator
+}
+
+func NewChainAuthenticator(authenticators ...Authenticator) *ChainAuthenticator {
+	return &ChainAuthenticator{Authenticators: authenticators}
+}
+
+func (c *ChainAuthenticator) Authenticate(w http.ResponseWriter, r *http.Request) (*User, error) {
+	for _, a := range c.Authenticators {
+		if cc, ok := a.(CredentialChecker); ok && !cc.HasCredentials(r) {
+			continue
+		}
+		return a.Authenticate(w, r)
+	}
+	if c.oauth() != nil {
+		return nil, MustLogin
+	}
+	if len(c.Authenticators) == 0 {
+		return DefaultUser, nil
+	}
+	return c.Authenticators[len(c.Authenticators)-1].Authenticate(w, r)
+}
+
+func (c *ChainAuthenticator) oauth() OAuthAuthenticator {
+	for _, a := range c.Authenticators {
+		if o, ok := a.(OAuthAuthenticator); ok {
+			return o
+		}
+	}
+	return nil
+}
+
+// URL returns the login URL for the OAuth provider in the chain, or the empty
+// string if there isn't one.
+func (c *ChainAuthenticator) URL(w http.ResponseWriter, r *http.Request) string {
+	if o := c.oauth(); o != nil {
+		return o.URL(w, r)
+	}
+	return ""
+}
+
+// HasCredentials returns true if any Authenticator in the chain finds
+// credentials in the request.
+func (c *ChainAuthenticator) HasCredentials(r *http.Request) bool {
+	for _, a := range c.Authenticators {
+		if cc, ok := a.(CredentialChecker); !ok || cc.HasCredentials(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ChainAuthenticator) SetPolicy(p *Policy) {
+	for _, a := range c.Authenticators {
+		a.SetPolicy(p)
+	}
+}
+
+func (c *ChainAuthenticator) Logout(w http.ResponseWriter, r *http.Request) {
+	for _, a := range c.Authenticators {
+		a.Logout(w, r)
+	}
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saintpete/logrole/services"
+)
+
+func TestChainAuthenticator(t *testing.T) {
+	t.Parallel()
+	ba := NewBasicAuthAuthenticator("logrole")
+	ba.AddUserPassword("test", "password")
+	g := NewGoogleAuthenticator(NullLogger, "", "", "http://localhost", nil, services.NewRandomKey())
+	chain := NewChainAuthenticator(NewClientCertAuthenticator(), ba, g)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("test", "password")
+	u, err := chain.Authenticate(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != DefaultUser {
+		t.Errorf("expected DefaultUser, got %v", u)
+	}
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req = withClientCert(req, &x509.Certificate{Subject: pkix.Name{CommonName: "build-server"}})
+	if _, err := chain.Authenticate(httptest.NewRecorder(), req); err != nil {
+		t.Errorf("expected client certificate to authenticate, got %v", err)
+	}
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.AddCookie(g.newCookie("user@example.com"))
+	if _, err := chain.Authenticate(httptest.NewRecorder(), req); err != nil {
+		t.Errorf("expected login cookie to authenticate, got %v", err)
+	}
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	if _, err := chain.Authenticate(httptest.NewRecorder(), req); err != MustLogin {
+		t.Errorf("expected MustLogin for request without credentials, got %v", err)
+	}
+	if url := chain.URL(httptest.NewRecorder(), req); url == "" {
+		t.Error("expected chain to return the Google login URL")
+	}
+
+	// Without an OAuth provider, the last authenticator asks for credentials.
+	chain = NewChainAuthenticator(NewClientCertAuthenticator(), ba)
+	w := httptest.NewRecorder()
+	if _, err := chain.Authenticate(w, req); err == nil {
+		t.Error("expected request without credentials to fail")
+	}
+	if w.Code != 401 {
+		t.Errorf("expected Code to be 401, got %d", w.Code)
+	}
+}
+
+var authSchemeTests = []struct {
+	scheme string
+	err    string
+}{
+	{"", ""},
+	{"basic", ""},
+	{"basic, google", ""},
+	{"unknown", "Unknown auth scheme: unknown"},
+	{"saml", "config.RegisterAuthScheme"},
+	{"noop,basic", "can't be combined"},
+	{"basic,", "Empty auth scheme"},
+	{"client_cert", "Cannot use client_cert auth"},
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	t.Parallel()
+	o := &AuthOptions{Logger: NullLogger, BaseURL: "http://localhost", SecretKey: services.NewRandomKey()}
+	for _, tt := range authSchemeTests {
+		c := &FileConfig{
+			AuthScheme:         tt.scheme,
+			User:               "test",
+			Password:           "password",
+			GoogleClientID:     "id",
+			GoogleClientSecret: "secret",
+		}
+		a, err := newAuthenticator(c, o)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("newAuthenticator(%q): %v", tt.scheme, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("newAuthenticator(%q): expected error, got %v", tt.scheme, a)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("newAuthenticator(%q): expected error to contain %q, got %v", tt.scheme, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/store"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// The store.Store bucket numbers blocked at runtime are kept in, keyed by
+// phone number.
+const blocklistBucket = "blocklist"
+
+// BlocklistConfig hides the traffic of a few phone numbers from everyone
+// except one policy group, for example
+//
+//     blocklist:
+//       group: executives
+//       numbers:
+//         - "+14155550123"
+//       file: /var/lib/logrole/blocklist.json
+type BlocklistConfig struct {
+	// Only members of this group can see the blocked numbers' traffic, or
+	// change the blocklist from /admin/blocklist. If empty, nobody can.
+	Group   string   `yaml:"group"`
+	Numbers []string `yaml:"numbers"`
+	// Save numbers blocked from /admin/blocklist to this file. If empty,
+	// they're forgotten when the server restarts, unless storage_driver is
+	// set.
+	File string `yaml:"file"`
+}
+
+// A BlockedNumber is a phone number on the blocklist.
+type BlockedNumber struct {
+	PhoneNumber twilio.PhoneNumber `json:"phone_number"`
+	// Empty for numbers in the config file.
+	AddedBy string    `json:"added_by"`
+	AddedAt time.Time `json:"added_at"`
+	// Numbers in the config file can only be removed by editing the file.
+	FromConfig bool `json:"-"`
+}
+
+// Blocklist is a list of phone numbers whose messages, calls, conversations,
+// alerts and phone number records are hidden from everyone outside one policy
+// group, whatever their other permissions, including in exports. Grants and
+// break glass access can't get around it. A nil Blocklist blocks nothing.
+type Blocklist struct {
+	group  string
+	path   string
+	db     store.Store
+	mu     sync.RWMutex
+	static map[twilio.PhoneNumber]*BlockedNumber
+	added  map[twilio.PhoneNumber]*BlockedNumber
+}
+
+func normalizeBlockedNumber(pn string) (twilio.PhoneNumber, error) {
+	num, err := twilio.NewPhoneNumber(strings.TrimSpace(pn))
+	if err != nil {
+		return "", fmt.Errorf("Invalid phone number %q in blocklist: %v", pn, err)
+	}
+	return num, nil
+}
+
+// NewBlocklist creates a Blocklist with the numbers in c, loading any numbers
+// blocked at runtime from c.File. The file doesn't need to exist yet. If c is
+// nil, NewBlocklist returns nil.
+func NewBlocklist(c *BlocklistConfig) (*Blocklist, error) {
+	if c == nil {
+		return nil, nil
+	}
+	b, err := newBlocklist(c)
+	if err != nil {
+		return nil, err
+	}
+	b.path = c.File
+	if b.path == "" {
+		return b, nil
+	}
+	data, err := ioutil.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var added []*BlockedNumber
+	if err := json.Unmarshal(data, &added); err != nil {
+		return nil, fmt.Errorf("Couldn't read blocklist from %s: %v", b.path, err)
+	}
+	for _, bn := range added {
+		b.added[bn.PhoneNumber] = bn
+	}
+	return b, nil
+}
+
+// NewBlocklistWith is like NewBlocklist, but saves numbers blocked at runtime
+// in db instead of c.File, loading the numbers already there.
+func NewBlocklistWith(c *BlocklistConfig, db store.Store) (*Blocklist, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.File != "" {
+		return nil, errors.New("Cannot set both storage_driver and blocklist.file")
+	}
+	b, err := newBlocklist(c)
+	if err != nil {
+		return nil, err
+	}
+	b.db = db
+	all, err := db.All(blocklistBucket)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read blocklist: %v", err)
+	}
+	for pn, data := range all {
+		bn := new(BlockedNumber)
+		if err := json.Unmarshal(data, bn); err != nil {
+			return nil, fmt.Errorf("Couldn't read blocked number %s: %v", pn, err)
+		}
+		b.added[bn.PhoneNumber] = bn
+	}
+	return b, nil
+}
+
+func newBlocklist(c *BlocklistConfig) (*Blocklist, error) {
+	b := &Blocklist{
+		group:  c.Group,
+		static: make(map[twilio.PhoneNumber]*BlockedNumber, len(c.Numbers)),
+		added:  make(map[twilio.PhoneNumber]*BlockedNumber),
+	}
+	for _, n := range c.Numbers {
+		pn, err := normalizeBlockedNumber(n)
+		if err != nil {
+			return nil, err
+		}
+		b.static[pn] = &BlockedNumber{PhoneNumber: pn, FromConfig: true}
+	}
+	return b, nil
+}
+
+// Group returns the policy group that can see the blocked numbers.
+func (b *Blocklist) Group() string {
+	if b == nil {
+		return ""
+	}
+	return b.group
+}
+
+// Exempt reports whether u can see the blocked numbers' traffic and change
+// the blocklist. When an admin is viewing the site as u, the admin has to be
+// exempt as well.
+func (b *Blocklist) Exempt(u *User) bool {
+	if b == nil || b.group == "" || u == nil {
+		return false
+	}
+	if viewer := u.Viewer(); viewer != nil && viewer.Group() != b.group {
+		return false
+	}
+	return u.Group() == b.group
+}
+
+// Hides reports whether any of the numbers is blocked, and u isn't exempt.
+// WhatsApp addresses are blocked along with their number.
+func (b *Blocklist) Hides(u *User, numbers ...string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.RLock()
+	blocked := false
+	for _, n := range numbers {
+		pn := services.BareNumber(twilio.PhoneNumber(n))
+		if b.static[pn] != nil || b.added[pn] != nil {
+			blocked = true
+			break
+		}
+	}
+	b.mu.RUnlock()
+	return blocked && !b.Exempt(u)
+}
+
+// Numbers returns every blocked number, sorted.
+func (b *Blocklist) Numbers() []*BlockedNumber {
+	if b == nil {
+		return nil
+	}
+	b.mu.RLock()
+	numbers := make([]*BlockedNumber, 0, len(b.static)+len(b.added))
+	for _, bn := range b.static {
+		numbers = append(numbers, bn)
+	}
+	for pn, bn := range b.added {
+		if b.static[pn] == nil {
+			numbers = append(numbers, bn)
+		}
+	}
+	b.mu.RUnlock()
+	sort.Sort(blockedByNumber(numbers))
+	return numbers
+}
+
+// Add blocks the number pn, on behalf of the user with the given id.
+func (b *Blocklist) Add(pn string, by string) (*BlockedNumber, error) {
+	if b == nil {
+		return nil, errors.New("No blocklist is configured")
+	}
+	num, err := normalizeBlockedNumber(pn)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.static[num] != nil || b.added[num] != nil {
+		return nil, fmt.Errorf("%s is already blocked", num)
+	}
+	bn := &BlockedNumber{PhoneNumber: num, AddedBy: by, AddedAt: time.Now().UTC()}
+	b.added[num] = bn
+	return bn, b.save(num)
+}
+
+// Remove unblocks a number that was blocked at runtime.
+func (b *Blocklist) Remove(pn string) error {
+	if b == nil {
+		return errors.New("No blocklist is configured")
+	}
+	num, err := normalizeBlockedNumber(pn)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.static[num] != nil {
+		return errors.New("Numbers in the config file can't be unblocked here, remove them from the file and reload the config")
+	}
+	if b.added[num] == nil {
+		return fmt.Errorf("%s isn't blocked", num)
+	}
+	delete(b.added, num)
+	return b.save(num)
+}
+
+// save writes num to b.db, or every number blocked at runtime to b.path.
+// b.mu must be held.
+func (b *Blocklist) save(num twilio.PhoneNumber) error {
+	if b.db != nil {
+		bn, ok := b.added[num]
+		if !ok {
+			return b.db.Delete(blocklistBucket, string(num))
+		}
+		data, err := json.Marshal(bn)
+		if err != nil {
+			return err
+		}
+		return b.db.Put(blocklistBucket, string(num), data)
+	}
+	if b.path == "" {
+		return nil
+	}
+	added := make([]*BlockedNumber, 0, len(b.added))
+	for _, bn := range b.added {
+		added = append(added, bn)
+	}
+	sort.Sort(blockedByNumber(added))
+	data, err := json.MarshalIndent(added, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFile(b.path, data)
+}
+
+type blockedByNumber []*BlockedNumber
+
+func (b blockedByNumber) Len() int           { return len(b) }
+func (b blockedByNumber) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b blockedByNumber) Less(i, j int) bool { return b[i].PhoneNumber < b[j].PhoneNumber }
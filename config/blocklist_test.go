@@ -0,0 +1,123 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/saintpete/logrole/store"
+)
+
+func TestBlocklistHides(t *testing.T) {
+	t.Parallel()
+	b, err := NewBlocklist(&BlocklistConfig{
+		Group:   "executives",
+		Numbers: []string{"+1 (415) 555-0123"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := NewUser(AllUserSettings())
+	u.id = "support@example.com"
+	if !b.Hides(u, "+14105550199", "+14155550123") {
+		t.Error("expected the blocked number to be hidden")
+	}
+	if !b.Hides(u, "whatsapp:+14155550123") {
+		t.Error("expected the blocked number's WhatsApp address to be hidden")
+	}
+	if b.Hides(u, "+14105550199", "") {
+		t.Error("expected other numbers not to be hidden")
+	}
+	exec := NewUser(&UserSettings{CanViewMessages: true})
+	exec.id = "ceo@example.com"
+	exec.group = "executives"
+	if b.Hides(exec, "+14155550123") {
+		t.Error("expected the blocked number to be visible to the group")
+	}
+	if !b.Hides(exec.ViewedBy(u), "+14155550123") {
+		t.Error("expected an admin viewing the site as an executive not to see the blocked number")
+	}
+	var nilList *Blocklist
+	if nilList.Hides(u, "+14155550123") {
+		t.Error("expected a nil blocklist not to hide anything")
+	}
+}
+
+func TestBlocklistAddRemove(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := &BlocklistConfig{
+		Group:   "executives",
+		Numbers: []string{"+14155550123"},
+		File:    filepath.Join(dir, "blocklist.json"),
+	}
+	b, err := NewBlocklist(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Add("not a number", "ceo@example.com"); err == nil {
+		t.Error("expected an invalid number to be rejected")
+	}
+	if _, err := b.Add("+14155550123", "ceo@example.com"); err == nil {
+		t.Error("expected a number that's already blocked to be rejected")
+	}
+	if _, err := b.Add("+14105550199", "ceo@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Remove("+14155550123"); err == nil {
+		t.Error("expected a number from the config file not to be removable")
+	}
+
+	b2, err := NewBlocklist(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numbers := b2.Numbers()
+	if len(numbers) != 2 || numbers[0].PhoneNumber != "+14105550199" || numbers[0].AddedBy != "ceo@example.com" {
+		t.Fatalf("expected the added number to be loaded from the file, got %v", numbers)
+	}
+	if err := b2.Remove("+14105550199"); err != nil {
+		t.Fatal(err)
+	}
+	b3, err := NewBlocklist(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numbers := b3.Numbers(); len(numbers) != 1 || !numbers[0].FromConfig {
+		t.Errorf("expected only the configured number after removing, got %v", numbers)
+	}
+}
+
+func TestBlocklistWith(t *testing.T) {
+	t.Parallel()
+	s := store.NewMemory()
+	c := &BlocklistConfig{Group: "executives"}
+	b, err := NewBlocklistWith(c, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pn := range []string{"+14105550199", "+14105550198"} {
+		if _, err := b.Add(pn, "ceo@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Remove("+14105550198"); err != nil {
+		t.Fatal(err)
+	}
+	b2, err := NewBlocklistWith(c, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numbers := b2.Numbers(); len(numbers) != 1 || numbers[0].PhoneNumber != "+14105550199" {
+		t.Errorf("expected the added number to be loaded from the store, got %v", numbers)
+	}
+	c.File = "/var/lib/logrole/blocklist.json"
+	if _, err := NewBlocklistWith(c, s); err == nil {
+		t.Error("expected an error setting both storage_driver and blocklist.file")
+	}
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+// Branding customizes the name, logo and colors of the site, so people can
+// tell different Logrole instances apart.
+type Branding struct {
+	// Shown in the navigation bar and page titles.
+	ProductName string
+	// If set, an image shown in the navigation bar next to the product name.
+	LogoURL string
+	// Background color for the navigation bar, as a hex color like "#1d5fa8".
+	// If empty, the default color is used.
+	PrimaryColor string
+	// Extra links shown in the footer of every page.
+	FooterLinks []FooterLink
+}
+
+// A FooterLink is a link in the footer of every page.
+type FooterLink struct {
+	Text string `yaml:"text"`
+	URL  string `yaml:"url"`
+}
+
+// DefaultBranding is used if no branding is configured.
+var DefaultBranding = &Branding{
+	ProductName: "Logrole",
+}
+
+var colorRx = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// validateBrandingURL returns an error unless rawurl is an absolute http(s)
+// URL or a path on this site.
+func validateBrandingURL(rawurl string, allowMailto bool) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return nil
+	case "mailto":
+		if allowMailto {
+			return nil
+		}
+	case "":
+		if u.Host == "" && len(u.Path) > 0 && u.Path[0] == '/' {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid URL %q, use an http or https URL", rawurl)
+}
+
+// NewBranding validates the given values and returns a Branding, or an error.
+// An empty productName defaults to "Logrole".
+func NewBranding(productName, logoURL, primaryColor string, links []FooterLink) (*Branding, error) {
+	if productName == "" {
+		productName = DefaultBranding.ProductName
+	}
+	if logoURL != "" {
+		if err := validateBrandingURL(logoURL, false); err != nil {
+			return nil, fmt.Errorf("Couldn't parse logo_url: %v", err)
+		}
+	}
+	if primaryColor != "" && !colorRx.MatchString(primaryColor) {
+		return nil, fmt.Errorf("Invalid primary_color %q, use a hex color like \"#1d5fa8\"", primaryColor)
+	}
+	for _, link := range links {
+		if link.Text == "" {
+			return nil, fmt.Errorf("Footer link to %s has no text", link.URL)
+		}
+		if err := validateBrandingURL(link.URL, true); err != nil {
+			return nil, fmt.Errorf("Couldn't parse footer link %q: %v", link.Text, err)
+		}
+	}
+	return &Branding{
+		ProductName:  productName,
+		LogoURL:      logoURL,
+		PrimaryColor: primaryColor,
+		FooterLinks:  links,
+	}, nil
+}
@@ -0,0 +1,46 @@
+package config
+
+import "testing"
+
+func TestNewBrandingDefaults(t *testing.T) {
+	t.Parallel()
+	b, err := NewBranding("", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.ProductName != "Logrole" {
+		t.Errorf("expected default product name, got %q", b.ProductName)
+	}
+}
+
+var invalidBrandingTests = []struct {
+	logo  string
+	color string
+	links []FooterLink
+}{
+	{"javascript:alert(1)", "", nil},
+	{"", "red", nil},
+	{"", "#12345", nil},
+	{"", "#fff;}body{", nil},
+	{"", "", []FooterLink{{Text: "", URL: "https://example.com"}}},
+	{"", "", []FooterLink{{Text: "Runbook", URL: "javascript:alert(1)"}}},
+}
+
+func TestNewBrandingInvalid(t *testing.T) {
+	t.Parallel()
+	for _, tt := range invalidBrandingTests {
+		if _, err := NewBranding("Support", tt.logo, tt.color, tt.links); err == nil {
+			t.Errorf("expected NewBranding(%q, %q, %v) to error, got nil", tt.logo, tt.color, tt.links)
+		}
+	}
+	b, err := NewBranding("Support", "/static/logo.png", "#1d5fa8", []FooterLink{
+		{Text: "Runbook", URL: "https://wiki.example.com/twilio"},
+		{Text: "Email us", URL: "mailto:support@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.FooterLinks) != 2 {
+		t.Errorf("expected 2 footer links, got %d", len(b.FooterLinks))
+	}
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultBreakGlassDuration is how long emergency access lasts if the config
+// doesn't say.
+const DefaultBreakGlassDuration = time.Hour
+
+// The longest emergency access can last, so nobody can break the glass for a
+// month.
+const maxBreakGlassDuration = 24 * time.Hour
+
+// BreakGlassConfig lets users give themselves extra permissions in an
+// emergency, without waiting for someone to grant them, for example
+//
+//     break_glass:
+//       permissions:
+//         - can_view_message_body
+//         - can_play_recordings
+//       duration: 2h
+//       groups:
+//         - oncall
+type BreakGlassConfig struct {
+	Permissions []string      `yaml:"permissions"`
+	Duration    time.Duration `yaml:"duration"`
+	// Only users in these policy groups can break the glass. If empty,
+	// anyone can.
+	Groups []string `yaml:"groups"`
+}
+
+// BreakGlass is the emergency access users can give themselves. A nil
+// BreakGlass can't be used by anyone.
+type BreakGlass struct {
+	// The permissions users get, in the order they were configured.
+	Permissions []string
+	Duration    time.Duration
+	groups      map[string]bool
+}
+
+// NewBreakGlass validates c. If c is nil, or doesn't give any permissions,
+// NewBreakGlass returns nil.
+func NewBreakGlass(c *BreakGlassConfig) (*BreakGlass, error) {
+	if c == nil || len(c.Permissions) == 0 {
+		return nil, nil
+	}
+	for _, p := range c.Permissions {
+		if _, ok := grantablePermissions[p]; !ok {
+			return nil, fmt.Errorf("Unknown permission %q in break_glass", p)
+		}
+	}
+	b := &BreakGlass{
+		Permissions: c.Permissions,
+		Duration:    c.Duration,
+	}
+	if b.Duration == 0 {
+		b.Duration = DefaultBreakGlassDuration
+	}
+	if b.Duration < time.Minute || b.Duration > maxBreakGlassDuration {
+		return nil, fmt.Errorf("break_glass duration must be between 1m and %s, got %s", maxBreakGlassDuration, c.Duration)
+	}
+	if len(c.Groups) > 0 {
+		b.groups = make(map[string]bool, len(c.Groups))
+		for _, g := range c.Groups {
+			b.groups[g] = true
+		}
+	}
+	return b, nil
+}
+
+// Allowed reports whether u can break the glass.
+func (b *BreakGlass) Allowed(u *User) bool {
+	if b == nil || u == nil || u.id == "" {
+		return false
+	}
+	return b.groups == nil || b.groups[u.Group()]
+}
+
+// Missing returns the emergency permissions u doesn't already have.
+func (b *BreakGlass) Missing(u *User) []string {
+	if b == nil {
+		return nil
+	}
+	missing := make([]string, 0, len(b.Permissions))
+	for _, p := range b.Permissions {
+		if !*grantablePermissions[p](u) {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
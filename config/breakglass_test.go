@@ -0,0 +1,75 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakGlass(t *testing.T) {
+	t.Parallel()
+	b, err := NewBreakGlass(&BreakGlassConfig{
+		Permissions: []string{"can_view_message_body", "can_play_recordings"},
+		Groups:      []string{"oncall"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Duration != DefaultBreakGlassDuration {
+		t.Errorf("expected default duration, got %v", b.Duration)
+	}
+	u := NewUser(&UserSettings{CanViewMessages: true, CanPlayRecordings: true})
+	u.id = "oncall@example.com"
+	if b.Allowed(u) {
+		t.Error("expected a user outside the groups not to be allowed")
+	}
+	u.group = "oncall"
+	if !b.Allowed(u) {
+		t.Error("expected a user in the group to be allowed")
+	}
+	missing := b.Missing(u)
+	if len(missing) != 1 || missing[0] != "can_view_message_body" {
+		t.Errorf("expected only can_view_message_body to be missing, got %v", missing)
+	}
+
+	now := time.Now()
+	u2 := u.WithGrants([]*Grant{{User: u.id, Permissions: missing, Expires: now.Add(time.Hour), BreakGlass: true}}, now)
+	if u2.BreakGlass() == nil || u.BreakGlass() != nil {
+		t.Error("expected only the user with the grant to have broken the glass")
+	}
+	if len(b.Missing(u2)) != 0 {
+		t.Errorf("expected nothing to be missing after breaking the glass, got %v", b.Missing(u2))
+	}
+}
+
+func TestNilBreakGlass(t *testing.T) {
+	t.Parallel()
+	b, err := NewBreakGlass(&BreakGlassConfig{Duration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != nil {
+		t.Fatal("expected break glass without permissions to be nil")
+	}
+	u := NewUser(AllUserSettings())
+	u.id = "admin@example.com"
+	if b.Allowed(u) || b.Missing(u) != nil {
+		t.Error("expected nobody to be able to use nil break glass")
+	}
+}
+
+func TestInvalidBreakGlass(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		c    *BreakGlassConfig
+		want string
+	}{
+		{&BreakGlassConfig{Permissions: []string{"can_grant_permissions"}}, `Unknown permission "can_grant_permissions" in break_glass`},
+		{&BreakGlassConfig{Permissions: []string{"can_view_calls"}, Duration: 48 * time.Hour}, "break_glass duration must be between 1m and 24h0m0s, got 48h0m0s"},
+	}
+	for _, tt := range tests {
+		_, err := NewBreakGlass(tt.c)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("NewBreakGlass(%v): got %v, want %q", tt.c, err, tt.want)
+		}
+	}
+}
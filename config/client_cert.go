@@ -0,0 +1,139 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/kevinburke/rest"
+)
+
+// ClientCertAuthenticator authenticates users who present a TLS client
+// certificate signed by a trusted CA. A certificate can name a user by its
+// Common Name or by any of its email or DNS Subject Alternative Names; add
+// any of these to a group in the policy to give that certificate the group's
+// permissions.
+//
+// Logrole must terminate TLS itself for this to work; see NewTLSConfig.
+type ClientCertAuthenticator struct {
+	policy *Policy
+	mu     sync.Mutex
+}
+
+func NewClientCertAuthenticator() *ClientCertAuthenticator {
+	return &ClientCertAuthenticator{}
+}
+
+var errNoClientCert = &rest.Error{
+	Title: "This site requires a valid client certificate",
+	ID:    "client_certificate_required",
+}
+
+// clientCertIDs returns the names in the request's verified client
+// certificate, email addresses first, then DNS names, then the Common Name.
+// If the request doesn't have a verified certificate, clientCertIDs returns
+// nil.
+func clientCertIDs(r *http.Request) []string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	ids := make([]string, 0, len(cert.EmailAddresses)+len(cert.DNSNames)+1)
+	ids = append(ids, cert.EmailAddresses...)
+	ids = append(ids, cert.DNSNames...)
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	return ids
+}
+
+// Authenticate looks up each name in the user's client certificate in the
+// policy, and returns the first user that's found. If none are found but the
+// policy has a default group, a user from that group is returned. If no
+// policy is present, config.DefaultUser is returned for all users with a
+// valid certificate.
+func (c *ClientCertAuthenticator) Authenticate(w http.ResponseWriter, r *http.Request) (*User, error) {
+	ids := clientCertIDs(r)
+	if len(ids) == 0 {
+		rest.Forbidden(w, r, errNoClientCert)
+		return nil, errNoClientCert
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.policy == nil {
+		return DefaultUser, nil
+	}
+	for _, id := range ids {
+		if u, ok, _ := c.policy.Lookup(id); ok {
+			return u, nil
+		}
+	}
+	u, _, err := c.policy.Lookup(ids[0])
+	if err != nil {
+		restErr := &rest.Error{
+			Title: "Certificate for " + ids[0] + " is not authorized to access this site",
+			ID:    "forbidden",
+		}
+		rest.Forbidden(w, r, restErr)
+		return nil, restErr
+	}
+	return u, nil
+}
+
+// HasCredentials returns true if the request has a verified client
+// certificate.
+func (c *ClientCertAuthenticator) HasCredentials(r *http.Request) bool {
+	return len(clientCertIDs(r)) > 0
+}
+
+func (c *ClientCertAuthenticator) SetPolicy(p *Policy) {
+	c.mu.Lock()
+	c.policy = p
+	c.mu.Unlock()
+}
+
+// Logout does nothing; the browser will send the certificate again on the
+// next request.
+func (c *ClientCertAuthenticator) Logout(w http.ResponseWriter, r *http.Request) {}
+
+// NewTLSConfig loads the certificate and key Logrole should serve. If
+// clientCAFile is not empty, clients may present certificates signed by the
+// CAs in that file, which ClientCertAuthenticator uses to identify them.
+//
+// If requireClientCert is true, connections without a valid certificate from
+// those CAs are rejected during the TLS handshake. Otherwise clients without
+// a certificate can still connect, so other authenticators can be used
+// alongside client certificates.
+func NewTLSConfig(certFile, keyFile, clientCAFile string, requireClientCert bool) (*tls.Config, error) {
+	if requireClientCert && clientCAFile == "" {
+		return nil, errors.New("Cannot require client certificates without a client_ca_file")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		data, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("Couldn't find any certificates in " + clientCAFile)
+		}
+		conf.ClientCAs = pool
+		if requireClientCert {
+			conf.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			conf.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return conf, nil
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func withClientCert(r *http.Request, cert *x509.Certificate) *http.Request {
+	r.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{cert}},
+	}
+	return r
+}
+
+func TestClientCertAuthenticator(t *testing.T) {
+	t.Parallel()
+	a := NewClientCertAuthenticator()
+	a.SetPolicy(&Policy{&Group{Name: "eng", Users: []string{"eng@example.com", "build-server"}}})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	if _, err := a.Authenticate(w, req); err == nil {
+		t.Fatal("expected request without a certificate to fail")
+	}
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+
+	req = withClientCert(req, &x509.Certificate{EmailAddresses: []string{"eng@example.com"}})
+	u, err := a.Authenticate(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID() != "eng@example.com" {
+		t.Errorf("expected eng@example.com, got %q", u.ID())
+	}
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req = withClientCert(req, &x509.Certificate{Subject: pkix.Name{CommonName: "build-server"}})
+	if _, err := a.Authenticate(httptest.NewRecorder(), req); err != nil {
+		t.Errorf("expected certificate to be looked up by Common Name, got %v", err)
+	}
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req = withClientCert(req, &x509.Certificate{EmailAddresses: []string{"other@example.com"}})
+	w = httptest.NewRecorder()
+	if _, err := a.Authenticate(w, req); err == nil {
+		t.Error("expected unknown user to fail")
+	}
+}
+
+func TestClientCertMatchesAnyName(t *testing.T) {
+	t.Parallel()
+	a := NewClientCertAuthenticator()
+	a.SetPolicy(&Policy{
+		&Group{Name: "support", Default: true},
+		&Group{Name: "monitoring", Users: []string{"monitor.example.com"}},
+	})
+	req, _ := http.NewRequest("GET", "/", nil)
+	req = withClientCert(req, &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "Monitoring"},
+		EmailAddresses: []string{"ops@example.com"},
+		DNSNames:       []string{"monitor.example.com"},
+	})
+	u, err := a.Authenticate(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID() != "monitor.example.com" {
+		t.Errorf("expected user to be found by DNS name, got %q", u.ID())
+	}
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req = withClientCert(req, &x509.Certificate{EmailAddresses: []string{"new@example.com"}})
+	u, err = a.Authenticate(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID() != "new@example.com" {
+		t.Errorf("expected default group user, got %q", u.ID())
+	}
+}
+
+// writeTestCert writes a self-signed certificate and its key to dir.
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "logrole-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+
+	conf, err := NewTLSConfig(certFile, keyFile, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.ClientAuth != tls.NoClientCert {
+		t.Errorf("expected no client certificates without a CA, got %v", conf.ClientAuth)
+	}
+	conf, err = NewTLSConfig(certFile, keyFile, certFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Errorf("expected optional client certificates, got %v", conf.ClientAuth)
+	}
+	conf, err = NewTLSConfig(certFile, keyFile, certFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("expected required client certificates, got %v", conf.ClientAuth)
+	}
+	if _, err := NewTLSConfig(certFile, keyFile, "", true); err == nil {
+		t.Error("expected error when requiring certificates without a CA")
+	}
+	if _, err := NewTLSConfig(certFile, keyFile, keyFile, false); err == nil {
+		t.Error("expected error for a CA file without certificates")
+	}
+}
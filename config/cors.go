@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CORS controls which other sites can make requests to Logrole from a
+// browser, with the user's credentials.
+type CORS struct {
+	// Origins like "https://tools.example.com". Requests from other origins
+	// don't get CORS headers.
+	AllowedOrigins []string
+	// Request headers other sites can send, in addition to the ones browsers
+	// always allow.
+	AllowedHeaders []string
+	// How long browsers can cache the response to a preflight request.
+	MaxAge time.Duration
+}
+
+// NewCORS validates the given values and returns a CORS, or nil if origins is
+// empty.
+func NewCORS(origins []string, headers []string, maxAge time.Duration) (*CORS, error) {
+	if len(origins) == 0 {
+		if len(headers) > 0 || maxAge != 0 {
+			return nil, fmt.Errorf("Set cors_allowed_origins to use cors_allowed_headers or cors_max_age")
+		}
+		return nil, nil
+	}
+	if maxAge < 0 {
+		return nil, fmt.Errorf("cors_max_age can't be negative")
+	}
+	c := &CORS{
+		AllowedOrigins: make([]string, len(origins)),
+		AllowedHeaders: make([]string, len(headers)),
+		MaxAge:         maxAge,
+	}
+	for i, origin := range origins {
+		if origin == "*" {
+			return nil, fmt.Errorf("cors_allowed_origins can't be \"*\", since requests include the user's credentials; list each origin")
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return nil, fmt.Errorf("Invalid CORS origin %q, use a scheme and host like \"https://tools.example.com\"", origin)
+		}
+		c.AllowedOrigins[i] = u.Scheme + "://" + strings.ToLower(u.Host)
+	}
+	for i, header := range headers {
+		header = strings.TrimSpace(header)
+		if header == "" || strings.ContainsAny(header, " ,:") {
+			return nil, fmt.Errorf("Invalid CORS header %q", header)
+		}
+		c.AllowedHeaders[i] = http.CanonicalHeaderKey(header)
+	}
+	return c, nil
+}
+
+// AllowOrigin returns true if requests from origin should get CORS headers.
+// A nil CORS allows no origins.
+func (c *CORS) AllowOrigin(origin string) bool {
+	if c == nil || origin == "" {
+		return false
+	}
+	origin = strings.ToLower(origin)
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == origin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewCORS(t *testing.T) {
+	t.Parallel()
+	c, err := NewCORS([]string{"https://Tools.Example.com"}, []string{"x-requested-with"}, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.AllowOrigin("https://tools.example.com") {
+		t.Errorf("expected origin to be allowed")
+	}
+	if c.AllowOrigin("http://tools.example.com") {
+		t.Errorf("expected origin with a different scheme to be denied")
+	}
+	if c.AllowedHeaders[0] != "X-Requested-With" {
+		t.Errorf("expected header to be canonicalized, got %q", c.AllowedHeaders[0])
+	}
+	c, err = NewCORS(nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != nil || c.AllowOrigin("https://tools.example.com") {
+		t.Errorf("expected no origins to be allowed")
+	}
+}
+
+var invalidCORS = []struct {
+	origins []string
+	headers []string
+	maxAge  time.Duration
+	err     string
+}{
+	{nil, []string{"X-Foo"}, 0, "Set cors_allowed_origins"},
+	{[]string{"*"}, nil, 0, "can't be \"*\""},
+	{[]string{"ftp://example.com"}, nil, 0, "Invalid CORS origin"},
+	{[]string{"https://example.com/path"}, nil, 0, "Invalid CORS origin"},
+	{[]string{"https://example.com"}, []string{"X Foo"}, 0, "Invalid CORS header"},
+	{[]string{"https://example.com"}, nil, -time.Second, "can't be negative"},
+}
+
+func TestNewCORSInvalid(t *testing.T) {
+	t.Parallel()
+	for _, tt := range invalidCORS {
+		_, err := NewCORS(tt.origins, tt.headers, tt.maxAge)
+		if err == nil {
+			t.Errorf("expected error for %v, got nil", tt.origins)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("expected error to contain %q, got %v", tt.err, err)
+		}
+	}
+}
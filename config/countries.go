@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/saintpete/logrole/services"
+)
+
+// CountryGroupEU can be used in excluded_countries in place of every member
+// state of the European Union.
+const CountryGroupEU = "EU"
+
+// euCountries are the region codes of the EU member states.
+var euCountries = []string{
+	"AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GR",
+	"HR", "HU", "IE", "IT", "LT", "LU", "LV", "MT", "NL", "PL", "PT", "RO",
+	"SE", "SI", "SK",
+}
+
+// countrySet expands codes, which are region codes like "GB" or
+// CountryGroupEU, into a set of region codes. It returns nil if codes is
+// empty.
+func countrySet(codes []string) map[string]bool {
+	if len(codes) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		if code == CountryGroupEU {
+			for _, eu := range euCountries {
+				set[eu] = true
+			}
+			continue
+		}
+		set[code] = true
+	}
+	return set
+}
+
+// validateCountries returns an error if any of codes isn't a region code that
+// phone numbers can belong to, or CountryGroupEU.
+func validateCountries(codes []string) error {
+	for _, code := range codes {
+		if code != CountryGroupEU && !services.ValidCountryCode(code) {
+			return fmt.Errorf("Unknown country %q in excluded_countries, use a two letter region code like \"GB\"", code)
+		}
+	}
+	return nil
+}
+
+// ExcludedCountries returns the region codes of the countries whose traffic
+// is hidden from u, in alphabetical order.
+func (u *User) ExcludedCountries() []string {
+	codes := make([]string, 0, len(u.excludedCountries))
+	for code := range u.excludedCountries {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// CanViewCountry returns false if traffic to or from the country with the
+// given region code is hidden from u.
+func (u *User) CanViewCountry(code string) bool {
+	return !u.excludedCountries[code]
+}
+
+// CanViewNumbers returns false if any of the numbers belongs to a country
+// whose traffic is hidden from u. Numbers without a country, like client
+// identifiers and short codes, never hide anything.
+func (u *User) CanViewNumbers(numbers ...string) bool {
+	if len(u.excludedCountries) == 0 {
+		// Don't bother parsing the numbers.
+		return true
+	}
+	for _, pn := range numbers {
+		if code := services.CountryCode(pn); code != "" && u.excludedCountries[code] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package config
+
+import "testing"
+
+func TestExcludedCountries(t *testing.T) {
+	t.Parallel()
+	us := AllUserSettings()
+	us.ExcludedCountries = []string{"EU", "GB"}
+	u := NewUser(us)
+	if u.CanViewCountry("DE") || u.CanViewCountry("GB") {
+		t.Error("expected DE and GB to be excluded")
+	}
+	if !u.CanViewCountry("US") {
+		t.Error("expected US to be viewable")
+	}
+	if got := len(u.ExcludedCountries()); got != len(euCountries)+1 {
+		t.Errorf("expected %d excluded countries, got %d", len(euCountries)+1, got)
+	}
+	tests := []struct {
+		numbers []string
+		want    bool
+	}{
+		{[]string{"+14105551234", "+14155556789"}, true},
+		{[]string{"+14105551234", "+493012345678"}, false},
+		{[]string{"whatsapp:+447911123456"}, false},
+		{[]string{"client:alice", "12345"}, true},
+	}
+	for _, tt := range tests {
+		if got := u.CanViewNumbers(tt.numbers...); got != tt.want {
+			t.Errorf("CanViewNumbers(%q): got %t, want %t", tt.numbers, got, tt.want)
+		}
+	}
+	if !NewUser(AllUserSettings()).CanViewNumbers("+493012345678") {
+		t.Error("expected a user with no excluded countries to view every number")
+	}
+}
+
+func TestValidateExcludedCountries(t *testing.T) {
+	t.Parallel()
+	us := AllUserSettings()
+	us.ExcludedCountries = []string{"XX"}
+	p := &Policy{{Name: "support", Permissions: us}}
+	if err := validatePolicy(p); err == nil {
+		t.Error("expected an error for an unknown country")
+	}
+	us.ExcludedCountries = []string{"EU", "CH"}
+	if err := validatePolicy(p); err != nil {
+		t.Errorf("expected EU and CH to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Values for csrf_same_site, the SameSite attribute of the cookie that holds
+// each browser's CSRF token.
+const (
+	// The cookie is sent when the user follows a link from another site, but
+	// not with forms or requests that other sites send. The default.
+	SameSiteLax = "lax"
+	// The cookie is only sent with requests that start on Logrole.
+	SameSiteStrict = "strict"
+	// The cookie is sent with requests from any site. Only useful if tools on
+	// another site post to Logrole; see cors_allowed_origins.
+	SameSiteNone = "none"
+)
+
+// NewSameSite validates val, a csrf_same_site setting, and returns it in
+// lower case, or SameSiteLax if val is empty. Browsers only accept
+// SameSite=None cookies over HTTPS, so "none" can't be used with
+// allowUnencryptedTraffic.
+func NewSameSite(val string, allowUnencryptedTraffic bool) (string, error) {
+	switch ss := strings.ToLower(strings.TrimSpace(val)); ss {
+	case "":
+		return SameSiteLax, nil
+	case SameSiteLax, SameSiteStrict:
+		return ss, nil
+	case SameSiteNone:
+		if allowUnencryptedTraffic {
+			return "", fmt.Errorf("csrf_same_site can't be %q when allowing unencrypted traffic, since browsers only send those cookies over HTTPS", val)
+		}
+		return ss, nil
+	default:
+		return "", fmt.Errorf("Unknown csrf_same_site %q, use \"lax\", \"strict\" or \"none\"", val)
+	}
+}
@@ -0,0 +1,36 @@
+package config
+
+import "testing"
+
+var sameSiteTests = []struct {
+	in        string
+	allowHTTP bool
+	want      string
+	err       bool
+}{
+	{"", false, SameSiteLax, false},
+	{"Strict", false, SameSiteStrict, false},
+	{"none", false, SameSiteNone, false},
+	{"none", true, "", true},
+	{"sometimes", false, "", true},
+}
+
+func TestNewSameSite(t *testing.T) {
+	t.Parallel()
+	for _, tt := range sameSiteTests {
+		got, err := NewSameSite(tt.in, tt.allowHTTP)
+		if tt.err {
+			if err == nil {
+				t.Errorf("NewSameSite(%q, %t): expected an error, got nil", tt.in, tt.allowHTTP)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewSameSite(%q, %t): %v", tt.in, tt.allowHTTP, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NewSameSite(%q, %t): got %q, want %q", tt.in, tt.allowHTTP, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Names of the features that can be turned on or off, in the features setting
+// or for a single group in the policy.
+const (
+	// The "Resend" button for failed messages.
+	FeatureResendMessages = "resend_messages"
+	// The scheduled messages page, and the buttons to cancel them.
+	FeatureScheduledMessages = "scheduled_messages"
+	// Sending the top of a slow list page before its results arrive.
+	FeatureStreamLists = "stream_lists"
+	// The queue analytics page.
+	FeatureQueues = "queues"
+	// The A2P 10DLC registration page.
+	FeatureA2P = "a2p"
+	// The "Auto-refresh" toggle on the message and call lists.
+	FeatureAutoRefresh = "auto_refresh"
+	// The conversation pages, for the Conversations API.
+	FeatureConversations = "conversations"
+	// The delivery summary for Messaging Services at /messages/campaigns.
+	FeatureCampaigns = "campaigns"
+	// Message and call volume by hour of the week at /traffic.
+	FeatureTraffic = "traffic"
+	// Swapping in new results on the message, call and conference lists,
+	// instead of loading the whole page.
+	FeaturePartialLists = "partial_lists"
+)
+
+// defaultFeatures are the features that are on when the config doesn't say
+// otherwise.
+var defaultFeatures = map[string]bool{
+	FeatureResendMessages:    true,
+	FeatureScheduledMessages: true,
+	FeatureStreamLists:       true,
+	FeatureQueues:            true,
+	FeatureA2P:               true,
+	FeatureAutoRefresh:       true,
+	FeatureConversations:     true,
+	FeatureCampaigns:         true,
+	FeatureTraffic:           true,
+	FeaturePartialLists:      true,
+}
+
+// Features turns features on or off, keyed by the feature name. Features that
+// aren't in the map have their default value.
+type Features map[string]bool
+
+// Enabled reports whether the named feature is on. Unknown features are off.
+func (f Features) Enabled(name string) bool {
+	if on, ok := f[name]; ok {
+		return on
+	}
+	return defaultFeatures[name]
+}
+
+// merge returns a copy of base with the values in f on top.
+func (f Features) merge(base Features) Features {
+	merged := make(Features, len(base)+len(f))
+	for name, on := range base {
+		merged[name] = on
+	}
+	for name, on := range f {
+		merged[name] = on
+	}
+	return merged
+}
+
+// FeatureNames returns the names of every feature, in alphabetical order.
+func FeatureNames() []string {
+	names := make([]string, 0, len(defaultFeatures))
+	for name := range defaultFeatures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateFeatures(f Features) error {
+	for name := range f {
+		if _, ok := defaultFeatures[name]; !ok {
+			return fmt.Errorf("Unknown feature %q, the features are %s", name, strings.Join(FeatureNames(), ", "))
+		}
+	}
+	return nil
+}
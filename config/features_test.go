@@ -0,0 +1,75 @@
+package config
+
+import (
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var featurePolicy = []byte(`
+- name: beta
+  features:
+    resend_messages: true
+  users:
+    - beta@example.com
+
+- name: everyone
+  default: true
+  users:
+    - support@example.com
+`)
+
+func TestGroupFeaturesOverrideGlobal(t *testing.T) {
+	t.Parallel()
+	var p Policy
+	if err := yaml.Unmarshal(featurePolicy, &p); err != nil {
+		t.Fatal(err)
+	}
+	if err := validatePolicy(&p); err != nil {
+		t.Fatal(err)
+	}
+	global := Features{FeatureResendMessages: false}
+	beta, _, err := p.Lookup("beta@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !beta.WithFeatures(global).Feature(FeatureResendMessages) {
+		t.Error("expected the beta group to be able to resend messages")
+	}
+	for _, id := range []string{"support@example.com", "unknown@example.com"} {
+		u, _, err := p.Lookup(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.WithFeatures(global).Feature(FeatureResendMessages) {
+			t.Errorf("expected resend_messages to be off for %s", id)
+		}
+		if !u.WithFeatures(global).Feature(FeatureQueues) {
+			t.Errorf("expected queues to keep its default for %s", id)
+		}
+	}
+}
+
+func TestFeatureDefaults(t *testing.T) {
+	t.Parallel()
+	u := NewUser(AllUserSettings())
+	for _, name := range FeatureNames() {
+		if !u.Feature(name) {
+			t.Errorf("expected %s to be on by default", name)
+		}
+	}
+	if u.Feature("teleport") {
+		t.Error("expected an unknown feature to be off")
+	}
+}
+
+func TestValidateUnknownFeature(t *testing.T) {
+	t.Parallel()
+	p := Policy{{Name: "beta", Features: Features{"live_tial": true}}}
+	if err := validatePolicy(&p); err == nil {
+		t.Error("expected an unknown feature in a group to be an error")
+	}
+	if err := validateFeatures(Features{FeatureA2P: false}); err != nil {
+		t.Errorf("expected a2p to be a valid feature, got %v", err)
+	}
+}
@@ -0,0 +1,430 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/saintpete/logrole/store"
+)
+
+// MaxGrantDuration is the longest a grant made from the /admin/grants page
+// can last. Grants in the config file can last longer.
+const MaxGrantDuration = 7 * 24 * time.Hour
+
+// The store.Store bucket runtime grants are kept in, keyed by grant ID.
+const grantsBucket = "grants"
+
+// grantablePermissions are the permissions that can be granted temporarily,
+// keyed by their name in the policy file. Nobody can be granted the ability
+// to grant permissions.
+var grantablePermissions = map[string]func(u *User) *bool{
+	"can_view_num_media":       func(u *User) *bool { return &u.canViewNumMedia },
+	"can_view_messages":        func(u *User) *bool { return &u.canViewMessages },
+	"can_view_message_from":    func(u *User) *bool { return &u.canViewMessageFrom },
+	"can_view_message_to":      func(u *User) *bool { return &u.canViewMessageTo },
+	"can_view_message_body":    func(u *User) *bool { return &u.canViewMessageBody },
+	"can_view_prices":          func(u *User) *bool { return &u.canViewPrices },
+	"can_view_message_price":   func(u *User) *bool { return &u.canViewMessagePrice },
+	"can_view_media":           func(u *User) *bool { return &u.canViewMedia },
+	"can_view_flagged_media":   func(u *User) *bool { return &u.canViewFlaggedMedia },
+	"can_view_calls":           func(u *User) *bool { return &u.canViewCalls },
+	"can_view_call_from":       func(u *User) *bool { return &u.canViewCallFrom },
+	"can_view_call_to":         func(u *User) *bool { return &u.canViewCallTo },
+	"can_view_call_price":      func(u *User) *bool { return &u.canViewCallPrice },
+	"can_view_call_digits":     func(u *User) *bool { return &u.canViewCallDigits },
+	"can_view_num_recordings":  func(u *User) *bool { return &u.canViewNumRecordings },
+	"can_play_recordings":      func(u *User) *bool { return &u.canPlayRecordings },
+	"can_download_recordings":  func(u *User) *bool { return &u.canDownloadRecordings },
+	"can_view_recording_price": func(u *User) *bool { return &u.canViewRecordingPrice },
+	"can_view_conferences":     func(u *User) *bool { return &u.canViewConferences },
+	"can_view_alerts":          func(u *User) *bool { return &u.canViewAlerts },
+	"can_view_callback_urls":   func(u *User) *bool { return &u.canViewCallbackURLs },
+	"can_view_alert_payloads":  func(u *User) *bool { return &u.canViewAlertPayloads },
+	"can_manage_labels":        func(u *User) *bool { return &u.canManageLabels },
+	"can_reload_config":        func(u *User) *bool { return &u.canReloadConfig },
+	"can_debug_permissions":    func(u *User) *bool { return &u.canDebugPermissions },
+	"can_resend_messages":      func(u *User) *bool { return &u.canResendMessages },
+	"can_cancel_messages":      func(u *User) *bool { return &u.canCancelMessages },
+	"can_translate_messages":   func(u *User) *bool { return &u.canTranslateMessages },
+	"can_view_notes":           func(u *User) *bool { return &u.canViewNotes },
+}
+
+// permissionDependencies lists the permissions each permission needs, besides
+// itself, in the order HiddenReason reports them. It matches the accessors on
+// User.
+var permissionDependencies = map[string][]string{
+	"can_view_num_media":       {"can_view_messages"},
+	"can_view_message_from":    {"can_view_messages"},
+	"can_view_message_to":      {"can_view_messages"},
+	"can_view_message_body":    {"can_view_messages"},
+	"can_view_message_price":   {"can_view_messages", "can_view_prices"},
+	"can_view_media":           {"can_view_messages"},
+	"can_view_flagged_media":   {"can_view_messages", "can_view_media"},
+	"can_view_call_from":       {"can_view_calls"},
+	"can_view_call_to":         {"can_view_calls"},
+	"can_view_call_price":      {"can_view_calls", "can_view_prices"},
+	"can_view_call_digits":     {"can_view_calls"},
+	"can_download_recordings":  {"can_play_recordings"},
+	"can_view_recording_price": {"can_view_prices"},
+	"can_resend_messages":      {"can_view_messages"},
+	"can_cancel_messages":      {"can_view_messages"},
+	"can_translate_messages":   {"can_view_messages", "can_view_message_body"},
+	"can_view_alert_payloads":  {"can_view_alerts"},
+}
+
+// GrantablePermissions returns the names of the permissions that can be
+// granted, in alphabetical order.
+func GrantablePermissions() []string {
+	names := make([]string, 0, len(grantablePermissions))
+	for name := range grantablePermissions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasPermission reports whether u has the named grantable permission,
+// including any dependencies - a user who can't view messages doesn't have
+// can_view_message_body, even if it's set.
+func (u *User) HasPermission(name string) bool {
+	if _, ok := grantablePermissions[name]; !ok {
+		return false
+	}
+	switch name {
+	case "can_view_num_media":
+		return u.CanViewNumMedia()
+	case "can_view_message_from":
+		return u.CanViewMessageFrom()
+	case "can_view_message_to":
+		return u.CanViewMessageTo()
+	case "can_view_message_body":
+		return u.CanViewMessageBody()
+	case "can_view_message_price":
+		return u.CanViewMessagePrice()
+	case "can_view_media":
+		return u.CanViewMedia()
+	case "can_view_flagged_media":
+		return u.CanViewFlaggedMedia()
+	case "can_view_call_from":
+		return u.CanViewCallFrom()
+	case "can_view_call_to":
+		return u.CanViewCallTo()
+	case "can_view_call_price":
+		return u.CanViewCallPrice()
+	case "can_view_call_digits":
+		return u.CanViewCallDigits()
+	case "can_download_recordings":
+		return u.CanDownloadRecordings()
+	case "can_view_recording_price":
+		return u.CanViewRecordingPrice()
+	case "can_resend_messages":
+		return u.CanResendMessages()
+	case "can_cancel_messages":
+		return u.CanCancelMessages()
+	case "can_translate_messages":
+		return u.CanTranslateMessages()
+	case "can_view_alert_payloads":
+		return u.CanViewAlertPayloads()
+	}
+	return *grantablePermissions[name](u)
+}
+
+// MissingPermission returns the first permission u lacks that the named
+// permission depends on, or name itself if u has all of its dependencies.
+func (u *User) MissingPermission(name string) string {
+	for _, dep := range permissionDependencies[name] {
+		if !*grantablePermissions[dep](u) {
+			return dep
+		}
+	}
+	return name
+}
+
+// A Grant gives a user extra permissions until it expires - "compliance
+// access until Friday", or a few hours of elevated access to debug a
+// problem.
+type Grant struct {
+	ID          string    `json:"id"`
+	User        string    `json:"user"`
+	Permissions []string  `json:"permissions"`
+	Expires     time.Time `json:"expires"`
+	Reason      string    `json:"reason"`
+	// Empty for grants in the config file.
+	GrantedBy string    `json:"granted_by"`
+	GrantedAt time.Time `json:"granted_at"`
+	// True if the user gave themselves emergency access from /break-glass.
+	BreakGlass bool `json:"break_glass,omitempty"`
+	// Grants in the config file can only be removed by editing the file.
+	FromConfig bool `json:"-"`
+}
+
+// Active reports whether the grant applies at the given time.
+func (g *Grant) Active(now time.Time) bool {
+	return now.Before(g.Expires)
+}
+
+func validateGrant(g *Grant) error {
+	if g.User == "" {
+		return errors.New("Grant has no user, choose who to grant permissions to")
+	}
+	if len(g.Permissions) == 0 {
+		return fmt.Errorf("Grant for %s has no permissions", g.User)
+	}
+	for _, p := range g.Permissions {
+		if _, ok := grantablePermissions[p]; !ok {
+			return fmt.Errorf("Unknown permission %q in grant for %s", p, g.User)
+		}
+	}
+	if g.Expires.IsZero() {
+		return fmt.Errorf("Grant for %s has no expiry", g.User)
+	}
+	return nil
+}
+
+// GrantConfig defines a Grant in the config file.
+type GrantConfig struct {
+	User        string   `yaml:"user"`
+	Permissions []string `yaml:"permissions"`
+	// A date like "2016-11-04", which means the grant lasts until the end of
+	// that day in UTC, or a RFC 3339 timestamp.
+	Expires string `yaml:"expires"`
+	Reason  string `yaml:"reason"`
+}
+
+func newGrantFromConfig(gc GrantConfig) (*Grant, error) {
+	g := &Grant{
+		User:        gc.User,
+		Permissions: gc.Permissions,
+		Reason:      gc.Reason,
+		FromConfig:  true,
+	}
+	if day, err := time.Parse("2006-01-02", gc.Expires); err == nil {
+		g.Expires = day.AddDate(0, 0, 1)
+	} else if t, err := time.Parse(time.RFC3339, gc.Expires); err == nil {
+		g.Expires = t
+	} else {
+		return nil, fmt.Errorf("Couldn't parse expiry %q for grant to %s, use a date like 2016-11-04", gc.Expires, gc.User)
+	}
+	if err := validateGrant(g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// GrantStore holds the grants from the config file and the ones made while
+// Logrole is running. If it has a path, the runtime grants are saved to that
+// file as JSON after every change, and loaded from it on startup. If it has a
+// store.Store, they're saved there instead.
+type GrantStore struct {
+	path   string
+	db     store.Store
+	mu     sync.RWMutex
+	static []*Grant
+	grants []*Grant
+}
+
+// NewGrantStore creates a GrantStore with the grants from the config file,
+// loading any runtime grants in the file at path. The file doesn't need to
+// exist yet. If path is empty, runtime grants are only kept in memory.
+func NewGrantStore(path string, configured []GrantConfig) (*GrantStore, error) {
+	gs, err := newGrantStore(configured)
+	if err != nil {
+		return nil, err
+	}
+	gs.path = path
+	if path == "" {
+		return gs, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return gs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &gs.grants); err != nil {
+		return nil, fmt.Errorf("Couldn't read grants from %s: %v", path, err)
+	}
+	return gs, nil
+}
+
+// NewGrantStoreWith creates a GrantStore with the grants from the config file
+// that saves runtime grants in db, loading the grants already there.
+func NewGrantStoreWith(db store.Store, configured []GrantConfig) (*GrantStore, error) {
+	gs, err := newGrantStore(configured)
+	if err != nil {
+		return nil, err
+	}
+	gs.db = db
+	all, err := db.All(grantsBucket)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read grants: %v", err)
+	}
+	for id, data := range all {
+		g := new(Grant)
+		if err := json.Unmarshal(data, g); err != nil {
+			return nil, fmt.Errorf("Couldn't read grant %s: %v", id, err)
+		}
+		gs.grants = append(gs.grants, g)
+	}
+	sort.Sort(grantsByExpiry(gs.grants))
+	return gs, nil
+}
+
+func newGrantStore(configured []GrantConfig) (*GrantStore, error) {
+	gs := new(GrantStore)
+	for i, gc := range configured {
+		g, err := newGrantFromConfig(gc)
+		if err != nil {
+			return nil, err
+		}
+		g.ID = "config-" + strconv.Itoa(i+1)
+		gs.static = append(gs.static, g)
+	}
+	return gs, nil
+}
+
+// Active returns every grant that applies at now, soonest to expire first.
+// A nil GrantStore has no grants.
+func (gs *GrantStore) Active(now time.Time) []*Grant {
+	if gs == nil {
+		return nil
+	}
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	active := make([]*Grant, 0)
+	for _, list := range [][]*Grant{gs.static, gs.grants} {
+		for _, g := range list {
+			if g.Active(now) {
+				active = append(active, g)
+			}
+		}
+	}
+	sort.Sort(grantsByExpiry(active))
+	return active
+}
+
+// Apply returns u with the permissions from any grants for u that are
+// active at now. Users without grants are returned unchanged.
+func (gs *GrantStore) Apply(u *User, now time.Time) *User {
+	if gs == nil || u == nil || u.id == "" {
+		return u
+	}
+	return u.WithGrants(gs.Active(now), now)
+}
+
+// Add saves g, giving it an ID and setting GrantedAt.
+func (gs *GrantStore) Add(g *Grant) (*Grant, error) {
+	if err := validateGrant(g); err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	g.ID = hex.EncodeToString(id)
+	g.GrantedAt = time.Now().UTC()
+	g.FromConfig = false
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.grants = append(gs.grants, g)
+	return g, gs.save(g)
+}
+
+// KeepGrants copies the runtime grants in old to gs, if neither of them saves
+// grants to a file or a store, so reloading the config doesn't lose them.
+// Grants from the config file are always the ones gs was created with.
+func (gs *GrantStore) KeepGrants(old *GrantStore) {
+	if gs == nil || old == nil || gs == old {
+		return
+	}
+	if gs.path != "" || gs.db != nil || old.path != "" || old.db != nil {
+		return
+	}
+	old.mu.RLock()
+	grants := make([]*Grant, len(old.grants))
+	copy(grants, old.grants)
+	old.mu.RUnlock()
+	gs.mu.Lock()
+	gs.grants = grants
+	gs.mu.Unlock()
+}
+
+// Revoke removes the runtime grant with the given id and returns it.
+func (gs *GrantStore) Revoke(id string) (*Grant, error) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for i, g := range gs.grants {
+		if g.ID == id {
+			gs.grants = append(gs.grants[:i:i], gs.grants[i+1:]...)
+			return g, gs.save(g)
+		}
+	}
+	for _, g := range gs.static {
+		if g.ID == id {
+			return nil, errors.New("Grants in the config file can't be revoked here, remove them from the file and reload the config")
+		}
+	}
+	return nil, fmt.Errorf("No grant with id %s", id)
+}
+
+// save drops any runtime grants that have expired, and saves the rest. With
+// gs.db, it saves the changed grants that are still held and deletes the
+// others, along with the expired ones; otherwise it writes every runtime grant
+// to gs.path. gs.mu must be held.
+func (gs *GrantStore) save(changed ...*Grant) error {
+	now := time.Now()
+	kept := make(map[string]bool, len(gs.grants))
+	var expired []*Grant
+	active := gs.grants[:0]
+	for _, g := range gs.grants {
+		if g.Active(now) {
+			active = append(active, g)
+			kept[g.ID] = true
+		} else {
+			expired = append(expired, g)
+		}
+	}
+	gs.grants = active
+	if gs.db != nil {
+		for _, g := range append(expired, changed...) {
+			if !kept[g.ID] {
+				if err := gs.db.Delete(grantsBucket, g.ID); err != nil {
+					return err
+				}
+				continue
+			}
+			data, err := json.Marshal(g)
+			if err != nil {
+				return err
+			}
+			if err := gs.db.Put(grantsBucket, g.ID, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if gs.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(gs.grants, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFile(gs.path, data)
+}
+
+type grantsByExpiry []*Grant
+
+func (g grantsByExpiry) Len() int           { return len(g) }
+func (g grantsByExpiry) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
+func (g grantsByExpiry) Less(i, j int) bool { return g[i].Expires.Before(g[j].Expires) }
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/store"
+)
+
+func TestWithGrants(t *testing.T) {
+	t.Parallel()
+	us := &UserSettings{CanViewMessages: true}
+	u := NewUser(us)
+	u.id = "compliance@example.com"
+	now := time.Date(2016, 11, 1, 12, 0, 0, 0, time.UTC)
+	grants := []*Grant{
+		{User: "compliance@example.com", Permissions: []string{"can_view_message_body"}, Expires: now.Add(time.Hour)},
+		{User: "compliance@example.com", Permissions: []string{"can_play_recordings"}, Expires: now.Add(-time.Hour)},
+		{User: "other@example.com", Permissions: []string{"can_view_calls"}, Expires: now.Add(time.Hour)},
+	}
+	u2 := u.WithGrants(grants, now)
+	if !u2.CanViewMessageBody() {
+		t.Error("expected active grant to apply")
+	}
+	if u2.CanPlayRecordings() {
+		t.Error("expected expired grant not to apply")
+	}
+	if u2.CanViewCalls() {
+		t.Error("expected another user's grant not to apply")
+	}
+	if len(u2.Grants()) != 1 {
+		t.Errorf("expected 1 applied grant, got %d", len(u2.Grants()))
+	}
+	if u.CanViewMessageBody() {
+		t.Error("expected WithGrants not to change the original user")
+	}
+	if u3 := u.WithGrants(grants, now.Add(2*time.Hour)); u3 != u {
+		t.Error("expected the user to be returned unchanged once every grant expired")
+	}
+}
+
+func TestGrantStore(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-grants-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "grants.json")
+	configured := []GrantConfig{
+		{User: "auditor@example.com", Permissions: []string{"can_view_message_body"}, Expires: "2100-01-01", Reason: "Annual audit"},
+	}
+	gs, err := NewGrantStore(path, configured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gs.Add(&Grant{User: "dev@example.com", Permissions: []string{"can_grant_permissions"}, Expires: time.Now().Add(time.Hour)}); err == nil {
+		t.Error("expected can_grant_permissions not to be grantable")
+	}
+	g, err := gs.Add(&Grant{User: "dev@example.com", Permissions: []string{"can_play_recordings"}, Expires: time.Now().Add(time.Hour), GrantedBy: "admin@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs2, err := NewGrantStore(path, configured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := gs2.Active(time.Now())
+	if len(active) != 2 {
+		t.Fatalf("expected 2 active grants, got %d", len(active))
+	}
+	if active[0].ID != g.ID || active[1].ID != "config-1" {
+		t.Errorf("expected grants to be sorted by expiry, got %s, %s", active[0].ID, active[1].ID)
+	}
+	if !active[1].Expires.Equal(time.Date(2100, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected config grant to last until the end of the day, got %v", active[1].Expires)
+	}
+	if _, err := gs2.Revoke("config-1"); err == nil {
+		t.Error("expected config grants not to be revocable")
+	}
+	if _, err := gs2.Revoke(g.ID); err != nil {
+		t.Fatal(err)
+	}
+	if active := gs2.Active(time.Now()); len(active) != 1 {
+		t.Errorf("expected 1 grant after revoking, got %d", len(active))
+	}
+}
+
+func TestGrantStoreWith(t *testing.T) {
+	t.Parallel()
+	s := store.NewMemory()
+	gs, err := NewGrantStoreWith(s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gs.Add(&Grant{User: "dev@example.com", Permissions: []string{"can_play_recordings"}, Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := gs.Add(&Grant{User: "dev@example.com", Permissions: []string{"can_view_prices"}, Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gs.Revoke(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	gs2, err := NewGrantStoreWith(s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if active := gs2.Active(time.Now()); len(active) != 1 || active[0].ID != g.ID {
+		t.Errorf("expected only the unrevoked grant to be reloaded, got %v", active)
+	}
+}
+
+func TestNewGrantStoreInvalidConfig(t *testing.T) {
+	t.Parallel()
+	tests := []GrantConfig{
+		{User: "a@example.com", Permissions: []string{"can_view_calls"}, Expires: "next friday"},
+		{User: "a@example.com", Permissions: []string{"can_fly"}, Expires: "2100-01-01"},
+		{Permissions: []string{"can_view_calls"}, Expires: "2100-01-01"},
+	}
+	for _, tt := range tests {
+		if _, err := NewGrantStore("", []GrantConfig{tt}); err == nil {
+			t.Errorf("expected an error for grant %#v", tt)
+		}
+	}
+}
+
+func TestHiddenReason(t *testing.T) {
+	t.Parallel()
+	s := AllUserSettings()
+	s.CanViewPrices = false
+	s.CanPlayRecordings = false
+	u := NewUser(s)
+	if r := u.HiddenReason("can_view_call_price"); r != "" {
+		t.Errorf("expected no reason unless debugging, got %q", r)
+	}
+	u = u.WithPermissionDebugging()
+	tests := []struct {
+		name   string
+		reason string
+	}{
+		{"can_view_calls", ""},
+		{"can_view_call_price", "requires can_view_prices (for can_view_call_price)"},
+		{"can_play_recordings", "requires can_play_recordings"},
+		{"can_download_recordings", "requires can_play_recordings (for can_download_recordings)"},
+	}
+	for _, tt := range tests {
+		if r := u.HiddenReason(tt.name); r != tt.reason {
+			t.Errorf("HiddenReason(%q): got %q, want %q", tt.name, r, tt.reason)
+		}
+	}
+	s.CanDebugPermissions = false
+	if u := NewUser(s).WithPermissionDebugging(); u.DebugPermissions() {
+		t.Error("expected users without can_debug_permissions not to debug permissions")
+	}
+}
+
+func TestPermissionDependencies(t *testing.T) {
+	t.Parallel()
+	// Turning off any dependency of a permission should take the permission
+	// away, or HiddenReason would blame the wrong setting.
+	for name, deps := range permissionDependencies {
+		for _, dep := range deps {
+			u := NewUser(AllUserSettings())
+			*grantablePermissions[dep](u) = false
+			if u.HasPermission(name) {
+				t.Errorf("expected %s to depend on %s", name, dep)
+			}
+			if missing := u.MissingPermission(name); missing != dep {
+				t.Errorf("MissingPermission(%q) without %s: got %q", name, dep, missing)
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBusinessHours apply to a group that sets a timezone but not
+// business_hours.
+const DefaultBusinessHours = "Mon-Fri 09:00-17:00"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// BusinessHours are the days and hours a group works, in its home timezone.
+// A nil BusinessHours means the group hasn't set any, and no time is outside
+// them.
+type BusinessHours struct {
+	loc  *time.Location
+	days [7]bool
+	// Minutes after midnight. end is after start.
+	start, end int
+	spec       string
+}
+
+// parseClock parses a time of day like "09:00" or "24:00" into minutes after
+// midnight.
+func parseClock(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) != 2 {
+		return 0, fmt.Errorf("Invalid time %q, use a 24 hour time like 09:00", s)
+	}
+	hour, err1 := strconv.Atoi(parts[0])
+	minute, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("Invalid time %q, use a 24 hour time like 09:00", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// parseDays parses a list of days like "Mon-Fri" or "Mon,Wed,Sat-Sun".
+func parseDays(s string) ([7]bool, error) {
+	var days [7]bool
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		bounds := strings.Split(part, "-")
+		if len(bounds) > 2 {
+			return days, fmt.Errorf("Invalid days %q, use days like Mon-Fri", s)
+		}
+		first, ok := weekdays[bounds[0]]
+		if !ok {
+			return days, fmt.Errorf("Unknown day %q, use the first three letters, like Mon", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[bounds[1]]; !ok {
+				return days, fmt.Errorf("Unknown day %q, use the first three letters, like Mon", bounds[1])
+			}
+		}
+		// Ranges can wrap around the weekend, like Sat-Sun or Fri-Mon.
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// ParseBusinessHours parses hours like "Mon-Fri 09:00-17:00" in loc. The days
+// are optional, and default to Monday through Friday. Hours can't cross
+// midnight.
+func ParseBusinessHours(spec string, loc *time.Location) (*BusinessHours, error) {
+	fields := strings.Fields(spec)
+	var dayStr, hourStr string
+	switch len(fields) {
+	case 1:
+		dayStr, hourStr = "Mon-Fri", fields[0]
+	case 2:
+		dayStr, hourStr = fields[0], fields[1]
+	default:
+		return nil, fmt.Errorf("Invalid business_hours %q, use a format like %q", spec, DefaultBusinessHours)
+	}
+	days, err := parseDays(dayStr)
+	if err != nil {
+		return nil, err
+	}
+	bounds := strings.Split(hourStr, "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("Invalid business_hours %q, use a format like %q", spec, DefaultBusinessHours)
+	}
+	start, err := parseClock(bounds[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(bounds[1])
+	if err != nil {
+		return nil, err
+	}
+	if end <= start {
+		return nil, fmt.Errorf("Invalid business_hours %q, the hours have to end after they start on the same day", spec)
+	}
+	return &BusinessHours{
+		loc:   loc,
+		days:  days,
+		start: start,
+		end:   end,
+		spec:  dayStr + " " + hourStr,
+	}, nil
+}
+
+// Contains reports whether t is during business hours. It's false for a nil
+// BusinessHours.
+func (b *BusinessHours) Contains(t time.Time) bool {
+	if b == nil {
+		return false
+	}
+	t = t.In(b.loc)
+	if !b.days[t.Weekday()] {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	return minute >= b.start && minute < b.end
+}
+
+// Outside reports whether t is outside business hours. It's false for a nil
+// BusinessHours, so nothing is shaded for groups without business hours.
+func (b *BusinessHours) Outside(t time.Time) bool {
+	return b != nil && !b.Contains(t)
+}
+
+// Location returns the timezone the hours are in.
+func (b *BusinessHours) Location() *time.Location {
+	return b.loc
+}
+
+// String returns the hours and their timezone, like
+// "Mon-Fri 09:00-17:00 America/New_York".
+func (b *BusinessHours) String() string {
+	if b == nil {
+		return ""
+	}
+	return b.spec + " " + b.loc.String()
+}
+
+var locMu sync.Mutex
+var locations = make(map[string]*time.Location)
+
+// loadLocation is time.LoadLocation, but only reads each timezone from disk
+// once, since users are looked up on every request.
+func loadLocation(name string) (*time.Location, error) {
+	locMu.Lock()
+	defer locMu.Unlock()
+	if loc, ok := locations[name]; ok {
+		return loc, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations[name] = loc
+	return loc, nil
+}
+
+// businessHours returns the group's business hours, or nil if it doesn't have
+// a home timezone.
+func (g *Group) businessHours() (*BusinessHours, error) {
+	if g.Timezone == "" {
+		if g.BusinessHours != "" {
+			return nil, errors.New("Set a timezone for business_hours to be in")
+		}
+		return nil, nil
+	}
+	loc, err := loadLocation(g.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown timezone %q", g.Timezone)
+	}
+	spec := g.BusinessHours
+	if spec == "" {
+		spec = DefaultBusinessHours
+	}
+	return ParseBusinessHours(spec, loc)
+}
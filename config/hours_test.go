@@ -0,0 +1,63 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+var hoursTests = []struct {
+	spec string
+	err  string
+}{
+	{"Mon-Fri 09:00-17:00", ""},
+	{"08:30-18:00", ""},
+	{"Sat-Sun,Wed 10:00-24:00", ""},
+	{"Mon-Fri", `Invalid business_hours "Mon-Fri", use a format like "Mon-Fri 09:00-17:00"`},
+	{"Mon-Fri 17:00-09:00", `Invalid business_hours "Mon-Fri 17:00-09:00", the hours have to end after they start on the same day`},
+	{"Mon-Funday 09:00-17:00", `Unknown day "funday", use the first three letters, like Mon`},
+	{"Mon-Fri 9am-5pm", `Invalid time "9am", use a 24 hour time like 09:00`},
+}
+
+func TestParseBusinessHours(t *testing.T) {
+	t.Parallel()
+	for _, tt := range hoursTests {
+		_, err := ParseBusinessHours(tt.spec, time.UTC)
+		switch {
+		case err == nil && tt.err != "":
+			t.Errorf("ParseBusinessHours(%q): expected error %s, got nil", tt.spec, tt.err)
+		case err != nil && err.Error() != tt.err:
+			t.Errorf("ParseBusinessHours(%q): got error %v, want %q", tt.spec, err, tt.err)
+		}
+	}
+}
+
+func TestBusinessHoursContains(t *testing.T) {
+	t.Parallel()
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no timezone data")
+	}
+	hours, err := ParseBusinessHours("Mon-Fri 09:00-17:00", ny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tuesday October 18, 2016, 14:30 UTC is 10:30 in New York.
+	tue := time.Date(2016, 10, 18, 14, 30, 0, 0, time.UTC)
+	if !hours.Contains(tue) || hours.Outside(tue) {
+		t.Errorf("expected %v to be in business hours", tue)
+	}
+	// 02:00 UTC on Wednesday is still Tuesday evening in New York.
+	if evening := time.Date(2016, 10, 19, 2, 0, 0, 0, time.UTC); !hours.Outside(evening) {
+		t.Errorf("expected %v to be outside business hours", evening)
+	}
+	if sat := time.Date(2016, 10, 22, 14, 30, 0, 0, time.UTC); !hours.Outside(sat) {
+		t.Errorf("expected %v to be outside business hours", sat)
+	}
+	var none *BusinessHours
+	if none.Outside(tue) || none.Contains(tue) {
+		t.Errorf("expected nil business hours to contain nothing and shade nothing")
+	}
+	if s := hours.String(); s != "Mon-Fri 09:00-17:00 America/New_York" {
+		t.Errorf("expected String to include the timezone, got %q", s)
+	}
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/saintpete/logrole/logging"
+)
+
+// newLogger builds the server's logger from the log_level, log_levels and
+// log_format settings, and returns the Levels it logs at, so they can be
+// changed while the server runs. The logger logs as the server module.
+func newLogger(c *FileConfig) (log.Logger, *logging.Levels, error) {
+	def := log.LvlInfo
+	if c.Debug {
+		def = log.LvlDebug
+	}
+	if c.LogLevel != "" {
+		lvl, err := logging.ParseLevel(c.LogLevel)
+		if err != nil {
+			return nil, nil, fmt.Errorf("log_level: %v", err)
+		}
+		def = lvl
+	}
+	modules := make(map[string]log.Lvl, len(c.LogLevels))
+	for name, level := range c.LogLevels {
+		if !validLogModule(name) {
+			return nil, nil, fmt.Errorf("log_levels: unknown module %q", name)
+		}
+		lvl, err := logging.ParseLevel(level)
+		if err != nil {
+			return nil, nil, fmt.Errorf("log_levels.%s: %v", name, err)
+		}
+		modules[name] = lvl
+	}
+	levels := logging.NewLevels(def, modules)
+	l, err := logging.New(logging.Config{Levels: levels, Format: c.LogFormat, Output: os.Stdout})
+	if err != nil {
+		return nil, nil, fmt.Errorf("log_format: %v", err)
+	}
+	return logging.Module(l, logging.ModuleServer), levels, nil
+}
+
+func validLogModule(name string) bool {
+	for _, m := range logging.Modules() {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
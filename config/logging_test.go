@@ -0,0 +1,36 @@
+package config
+
+import (
+	"testing"
+
+	log "github.com/inconshreveable/log15"
+)
+
+func TestNewLogger(t *testing.T) {
+	t.Parallel()
+	_, levels, err := newLogger(&FileConfig{
+		Debug:     true,
+		LogLevels: map[string]string{"cache": "warn"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if levels.Default() != log.LvlDebug {
+		t.Errorf("expected debug to set the default level, got %v", levels.Default())
+	}
+	if lvl, ok := levels.Get("cache"); !ok || lvl != log.LvlWarn {
+		t.Errorf("expected the cache to log at warn, got %v", lvl)
+	}
+
+	tests := []*FileConfig{
+		{LogLevel: "loud"},
+		{LogLevels: map[string]string{"nope": "debug"}},
+		{LogLevels: map[string]string{"cache": "loud"}},
+		{LogFormat: "xml"},
+	}
+	for _, c := range tests {
+		if _, _, err := newLogger(c); err == nil {
+			t.Errorf("expected an error for %+v", c)
+		}
+	}
+}
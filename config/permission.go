@@ -14,6 +14,25 @@ type Group struct {
 	Name        string        `yaml:"name"`
 	Default     bool          `yaml:"default,omitempty"`
 	Users       []string      `yaml:"users"`
+	// Turns features on or off for users in this group, overriding the
+	// features setting.
+	Features Features `yaml:"features,omitempty"`
+	// The group's home timezone, like "America/New_York", and the hours it
+	// works there, like "Mon-Fri 09:00-17:00". Business hours default to
+	// DefaultBusinessHours if only the timezone is set.
+	Timezone      string `yaml:"timezone,omitempty"`
+	BusinessHours string `yaml:"business_hours,omitempty"`
+}
+
+// newUser returns a User with the group's permissions, features and business
+// hours. newUser assumes the group is valid.
+func (g *Group) newUser(id string) *User {
+	u := NewUser(g.Permissions)
+	u.id = id
+	u.group = g.Name
+	u.features = g.Features
+	u.hours, _ = g.businessHours()
+	return u
 }
 
 type PolicyPolicy struct {
@@ -45,7 +64,7 @@ func (p *Policy) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	}
 	for _, group := range yp {
 		if group.Permissions == nil {
-			group.Permissions = AllUserSettings()
+			group.Permissions = defaultUserSettings()
 		}
 	}
 	*p = Policy(yp)
@@ -66,7 +85,7 @@ func (p *Policy) Lookup(id string) (*User, bool, error) {
 	for _, group := range *p {
 		for _, user := range group.Users {
 			if user == id {
-				return NewUser(group.Permissions), true, nil
+				return group.newUser(id), true, nil
 			}
 		}
 		if group.Default == true {
@@ -74,11 +93,25 @@ func (p *Policy) Lookup(id string) (*User, bool, error) {
 		}
 	}
 	if defaultGroup != nil {
-		return NewUser(defaultGroup.Permissions), false, nil
+		return defaultGroup.newUser(id), false, nil
 	}
 	return nil, false, fmt.Errorf("User %s not found in the policy, and no default configured", id)
 }
 
+// GroupUser returns a User with the permissions and features of the named
+// group, who isn't any particular member of it. GroupUser assumes the Policy
+// is valid.
+func (p *Policy) GroupUser(name string) (*User, error) {
+	if p != nil {
+		for _, group := range *p {
+			if group.Name == name {
+				return group.newUser(""), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("Group %s not found in the policy", name)
+}
+
 // Users returns a map of all Users defined in the policy. Users assumes the
 // Policy is valid.
 func (p *Policy) Users() map[string]*User {
@@ -88,7 +121,7 @@ func (p *Policy) Users() map[string]*User {
 	}
 	for _, group := range *p {
 		for _, user := range group.Users {
-			users[user] = NewUser(group.Permissions)
+			users[user] = group.newUser(user)
 		}
 	}
 	return users
@@ -96,6 +129,8 @@ func (p *Policy) Users() map[string]*User {
 
 type Permission struct {
 	maxResourceAge time.Duration
+	alertRedactor  *Redactor
+	blocklist      *Blocklist
 }
 
 func validatePolicy(p *Policy) error {
@@ -113,6 +148,23 @@ func validatePolicy(p *Policy) error {
 			return fmt.Errorf("Group name %s appears twice in the list", group.Name)
 		}
 		names[group.Name] = true
+		if err := validateFeatures(group.Features); err != nil {
+			return fmt.Errorf("Group %s: %v", group.Name, err)
+		}
+		if _, err := group.businessHours(); err != nil {
+			return fmt.Errorf("Group %s: %v", group.Name, err)
+		}
+		if group.Permissions != nil {
+			if err := validateCountries(group.Permissions.ExcludedCountries); err != nil {
+				return fmt.Errorf("Group %s: %v", group.Name, err)
+			}
+			if group.Permissions.MaxResourceAge < 0 {
+				return fmt.Errorf("Group %s: max_resource_age can't be negative", group.Name)
+			}
+			if group.Permissions.MaxPageSize > MaxPageSize {
+				return fmt.Errorf("Group %s: max_page_size is more than Twilio's maximum of %d", group.Name, MaxPageSize)
+			}
+		}
 		if group.Default == true {
 			defaultCount++
 			if defaultCount > 1 {
@@ -139,6 +191,32 @@ func (p *Permission) MaxResourceAge() time.Duration {
 	return p.maxResourceAge
 }
 
+// AlertRedactor hides sensitive text in alert payloads. It may be nil.
+func (p *Permission) AlertRedactor() *Redactor {
+	return p.alertRedactor
+}
+
+// WithAlertRedactor returns a copy of p that redacts alert payloads with r.
+func (p *Permission) WithAlertRedactor(r *Redactor) *Permission {
+	p2 := *p
+	p2.alertRedactor = r
+	return &p2
+}
+
+// Blocklist holds numbers whose traffic is hidden from most users. It may be
+// nil.
+func (p *Permission) Blocklist() *Blocklist {
+	return p.blocklist
+}
+
+// WithBlocklist returns a copy of p that hides the traffic of the numbers in
+// b.
+func (p *Permission) WithBlocklist(b *Blocklist) *Permission {
+	p2 := *p
+	p2.blocklist = b
+	return &p2
+}
+
 func NewPermission(maxResourceAge time.Duration) *Permission {
 	return &Permission{
 		maxResourceAge: maxResourceAge,
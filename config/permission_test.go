@@ -3,6 +3,7 @@ package config
 import (
 	"strings"
 	"testing"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -63,6 +64,29 @@ func TestLoadPermission(t *testing.T) {
 	}
 }
 
+func TestLookupSetsID(t *testing.T) {
+	t.Parallel()
+	var p Policy
+	if err := yaml.Unmarshal(policy, &p); err != nil {
+		t.Fatal(err)
+	}
+	u, ok, err := p.Lookup("test@example.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || u.ID() != "test@example.net" {
+		t.Errorf("expected to find test@example.net, got %t %q", ok, u.ID())
+	}
+	// Users in the default group keep the id they authenticated with.
+	u, ok, err = p.Lookup("unknown@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok || u.ID() != "unknown@example.com" {
+		t.Errorf("expected default user with id unknown@example.com, got %t %q", ok, u.ID())
+	}
+}
+
 var extraPolicy = []byte(`
 policy:
   - name: empty
@@ -134,6 +158,22 @@ var policyTests = []struct {
 		&Group{Name: "2", Default: false, Users: []string{"two"}},
 	},
 		err: "Group has no name, define a group name"},
+	{p: &Policy{
+		&Group{Name: "1", Permissions: &UserSettings{MaxResourceAge: -time.Hour}, Users: []string{"foo"}},
+	},
+		err: "Group 1: max_resource_age can't be negative"},
+	{p: &Policy{
+		&Group{Name: "1", Permissions: &UserSettings{MaxPageSize: 5000}, Users: []string{"foo"}},
+	},
+		err: "Group 1: max_page_size is more than Twilio's maximum of 1000"},
+	{p: &Policy{
+		&Group{Name: "1", Timezone: "Mars/Olympus_Mons", Users: []string{"foo"}},
+	},
+		err: `Group 1: Unknown timezone "Mars/Olympus_Mons"`},
+	{p: &Policy{
+		&Group{Name: "1", BusinessHours: "Mon-Fri 09:00-17:00", Users: []string{"foo"}},
+	},
+		err: "Group 1: Set a timezone for business_hours to be in"},
 	{p: &Policy{
 		&Group{Name: "1", Default: true, Users: []string{"foo"}},
 		&Group{Name: "2", Default: false, Users: []string{"two"}},
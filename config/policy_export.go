@@ -0,0 +1,219 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/saintpete/logrole/store"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// PolicyExportVersion is the version of the permission file written by
+// ExportPolicy. Bump it if the format changes in a way older versions of
+// Logrole can't read.
+const PolicyExportVersion = 1
+
+// A PolicyExport is every group in the policy, with its users, permissions
+// and features. It's also a valid policy_file.
+type PolicyExport struct {
+	Version int    `yaml:"version"`
+	Policy  Policy `yaml:"policy"`
+}
+
+// ExportPolicy returns p as a versioned YAML document that ParsePolicyExport
+// can read. A nil Policy exports with no groups.
+func ExportPolicy(p *Policy) ([]byte, error) {
+	pe := &PolicyExport{Version: PolicyExportVersion, Policy: Policy{}}
+	if p != nil {
+		pe.Policy = *p
+	}
+	return yaml.Marshal(pe)
+}
+
+// ParsePolicyExport reads a document written by ExportPolicy and validates
+// the policy in it. JSON documents are accepted too, since they're valid
+// YAML.
+func ParsePolicyExport(data []byte) (*Policy, error) {
+	pe := new(PolicyExport)
+	if err := yaml.Unmarshal(data, pe); err != nil {
+		return nil, fmt.Errorf("Couldn't parse permission file: %v", err)
+	}
+	switch {
+	case pe.Version == 0:
+		return nil, errors.New("Permission file has no version; export the current permissions to see the format")
+	case pe.Version > PolicyExportVersion:
+		return nil, fmt.Errorf("Permission file is version %d, but this version of Logrole can only read version %d", pe.Version, PolicyExportVersion)
+	}
+	if len(pe.Policy) == 0 {
+		return nil, errors.New("Permission file has no groups")
+	}
+	for _, group := range pe.Policy {
+		if group == nil {
+			return nil, errors.New("Permission file has an empty group")
+		}
+	}
+	if err := validatePolicy(&pe.Policy); err != nil {
+		return nil, err
+	}
+	return &pe.Policy, nil
+}
+
+// WritePolicyFile replaces the policy file at path with an export of p. The
+// old file stays in place if the new one can't be written in full.
+func WritePolicyFile(path string, p *Policy) error {
+	data, err := ExportPolicy(p)
+	if err != nil {
+		return err
+	}
+	return store.WriteFile(path, data)
+}
+
+// DiffPolicies describes, one change per line, what changes if old is
+// replaced with new. Groups are compared by name. It returns nothing if the
+// policies are the same.
+func DiffPolicies(old, new *Policy) []string {
+	oldGroups, newGroups := groupsByName(old), groupsByName(new)
+	var changes []string
+	if old != nil {
+		for _, g := range *old {
+			if _, ok := newGroups[g.Name]; !ok {
+				changes = append(changes, fmt.Sprintf("Remove group %s and its %d users", g.Name, len(g.Users)))
+			}
+		}
+	}
+	if new == nil {
+		return changes
+	}
+	for _, g := range *new {
+		og, ok := oldGroups[g.Name]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("Add group %s with %d users", g.Name, len(g.Users)))
+			continue
+		}
+		if og.Default != g.Default {
+			if g.Default {
+				changes = append(changes, fmt.Sprintf("Make %s the default group", g.Name))
+			} else {
+				changes = append(changes, fmt.Sprintf("Stop making %s the default group", g.Name))
+			}
+		}
+		added, removed := diffStrings(og.Users, g.Users)
+		for _, user := range added {
+			changes = append(changes, fmt.Sprintf("Add %s to group %s", user, g.Name))
+		}
+		for _, user := range removed {
+			changes = append(changes, fmt.Sprintf("Remove %s from group %s", user, g.Name))
+		}
+		added, removed = diffStrings(settingNames(og.Permissions), settingNames(g.Permissions))
+		for _, p := range added {
+			changes = append(changes, fmt.Sprintf("Give group %s %s", g.Name, p))
+		}
+		for _, p := range removed {
+			changes = append(changes, fmt.Sprintf("Take %s away from group %s", p, g.Name))
+		}
+		if oldAge, newAge := maxResourceAge(og.Permissions), maxResourceAge(g.Permissions); oldAge != newAge {
+			changes = append(changes, fmt.Sprintf("Change max_resource_age for group %s from %s to %s", g.Name, oldAge, newAge))
+		}
+		if og.Timezone != g.Timezone || og.BusinessHours != g.BusinessHours {
+			changes = append(changes, fmt.Sprintf("Change business hours for group %s from %s to %s", g.Name, groupHours(og), groupHours(g)))
+		}
+		for _, name := range FeatureNames() {
+			was, wasSet := og.Features[name]
+			is, isSet := g.Features[name]
+			if wasSet != isSet || was != is {
+				changes = append(changes, fmt.Sprintf("Change feature %s for group %s from %s to %s", name, g.Name, featureOverride(was, wasSet), featureOverride(is, isSet)))
+			}
+		}
+	}
+	return changes
+}
+
+func groupsByName(p *Policy) map[string]*Group {
+	groups := make(map[string]*Group)
+	if p != nil {
+		for _, g := range *p {
+			groups[g.Name] = g
+		}
+	}
+	return groups
+}
+
+// diffStrings returns the values in b but not a, and in a but not b, sorted.
+func diffStrings(a, b []string) (added, removed []string) {
+	inA := make(map[string]bool, len(a))
+	for _, s := range a {
+		inA[s] = true
+	}
+	inB := make(map[string]bool, len(b))
+	for _, s := range b {
+		inB[s] = true
+		if !inA[s] {
+			added = append(added, s)
+		}
+	}
+	for _, s := range a {
+		if !inB[s] {
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// settingNames returns the policy file names of the permissions that are
+// true in us, like "can_view_messages".
+func settingNames(us *UserSettings) []string {
+	if us == nil {
+		return nil
+	}
+	var names []string
+	v := reflect.ValueOf(us).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type.Kind() != reflect.Bool || !v.Field(i).Bool() {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func maxResourceAge(us *UserSettings) time.Duration {
+	if us == nil {
+		return 0
+	}
+	return us.MaxResourceAge
+}
+
+// groupHours describes a group's business hours for a policy diff.
+func groupHours(g *Group) string {
+	hours, err := g.businessHours()
+	switch {
+	case err != nil:
+		return fmt.Sprintf("%q in %q", g.BusinessHours, g.Timezone)
+	case hours == nil:
+		return "none"
+	default:
+		return hours.String()
+	}
+}
+
+func featureOverride(on, set bool) string {
+	switch {
+	case !set:
+		return "the site-wide setting"
+	case on:
+		return "on"
+	default:
+		return "off"
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestExportPolicyRoundTrip(t *testing.T) {
+	t.Parallel()
+	us := AllUserSettings()
+	us.CanViewMessageBody = false
+	p := &Policy{
+		&Group{Name: "support", Users: []string{"alice", "bob"}, Permissions: us, Features: Features{FeatureQueues: false}},
+		&Group{Name: "everyone", Default: true, Permissions: AllUserSettings()},
+	}
+	data, err := ExportPolicy(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "version: 1\n") {
+		t.Errorf("expected the export to start with its version, got %s", data)
+	}
+	p2, err := ParsePolicyExport(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*p2) != 2 || !(*p2)[1].Default || (*p2)[0].Features.Enabled(FeatureQueues) {
+		t.Errorf("expected the groups to survive a round trip, got %#v", p2)
+	}
+	if changes := DiffPolicies(p, p2); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+	// The export is also a valid policy file.
+	var loaded Policy
+	if err := yaml.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 || loaded[0].Name != "support" {
+		t.Errorf("expected the export to load as a policy file, got %#v", loaded)
+	}
+}
+
+func TestParsePolicyExportVersion(t *testing.T) {
+	t.Parallel()
+	if _, err := ParsePolicyExport([]byte(`{"version": 1, "policy": [{"name": "support", "users": ["alice"]}]}`)); err != nil {
+		t.Errorf("expected JSON to be accepted, got %v", err)
+	}
+	if _, err := ParsePolicyExport([]byte("policy:\n  - name: support\n")); err == nil || !strings.Contains(err.Error(), "no version") {
+		t.Errorf("expected an error for a missing version, got %v", err)
+	}
+	if _, err := ParsePolicyExport([]byte("version: 2\npolicy:\n  - name: support\n")); err == nil || !strings.Contains(err.Error(), "version 2") {
+		t.Errorf("expected an error for a newer version, got %v", err)
+	}
+}
+
+func TestDiffPolicies(t *testing.T) {
+	t.Parallel()
+	old := &Policy{
+		&Group{Name: "support", Users: []string{"alice", "bob"}, Permissions: AllUserSettings()},
+		&Group{Name: "finance", Users: []string{"carol"}, Permissions: AllUserSettings()},
+	}
+	us := AllUserSettings()
+	us.CanViewMessageBody = false
+	new := &Policy{
+		&Group{Name: "support", Users: []string{"alice", "dave"}, Permissions: us, Default: true, Features: Features{FeatureA2P: false}},
+		&Group{Name: "ops", Users: []string{"carol"}, Permissions: AllUserSettings()},
+	}
+	want := []string{
+		"Remove group finance and its 1 users",
+		"Make support the default group",
+		"Add dave to group support",
+		"Remove bob from group support",
+		"Take can_view_message_body away from group support",
+		"Change feature a2p for group support from the site-wide setting to off",
+		"Add group ops with 1 users",
+	}
+	if got := DiffPolicies(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffPolicies:\ngot  %q\nwant %q", got, want)
+	}
+}
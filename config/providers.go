@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Names of the providers messages and calls can come from. Twilio is always
+// the primary provider; the others are configured in the providers setting.
+const (
+	ProviderTwilio = "twilio"
+	ProviderTelnyx = "telnyx"
+)
+
+// otherProviders are the providers that can be configured alongside Twilio.
+var otherProviders = []string{ProviderTelnyx}
+
+// ProviderConfig holds the credentials for a provider other than Twilio.
+type ProviderConfig struct {
+	APIKey string `yaml:"api_key"`
+	// Defaults to the provider's production API.
+	BaseURL string `yaml:"base_url,omitempty"`
+}
+
+// ProviderNames returns the names of the providers that can be configured
+// alongside Twilio, in alphabetical order.
+func ProviderNames() []string {
+	names := make([]string, len(otherProviders))
+	copy(names, otherProviders)
+	sort.Strings(names)
+	return names
+}
+
+func isOtherProvider(name string) bool {
+	for _, p := range otherProviders {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+func validateProviders(providers map[string]ProviderConfig) error {
+	for name, pc := range providers {
+		if !isOtherProvider(name) {
+			return fmt.Errorf("Unknown provider %q, the providers are %s", name, strings.Join(ProviderNames(), ", "))
+		}
+		if pc.APIKey == "" {
+			return fmt.Errorf("Provider %s needs an api_key", name)
+		}
+		if pc.BaseURL != "" {
+			u, err := url.Parse(pc.BaseURL)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("Invalid base_url for provider %s: %q", name, pc.BaseURL)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+// Redacted replaces text that matches a redaction pattern.
+const Redacted = "[redacted]"
+
+// A Redactor hides text that matches any of a list of patterns, like card
+// numbers or auth tokens in the requests Twilio made to our servers. A nil
+// Redactor doesn't change anything.
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor compiles patterns, which use the syntax accepted by the regexp
+// package. If patterns is empty, NewRedactor returns nil.
+func NewRedactor(patterns []string) (*Redactor, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	r := &Redactor{patterns: make([]*regexp.Regexp, len(patterns))}
+	for i, pattern := range patterns {
+		if pattern == "" {
+			return nil, fmt.Errorf("alert_redactions[%d] is empty", i)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern in alert_redactions[%d]: %v", i, err)
+		}
+		r.patterns[i] = re
+	}
+	return r, nil
+}
+
+// Redact returns s with every match of r's patterns replaced by Redacted.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, re := range r.patterns {
+		s = re.ReplaceAllLiteralString(s, Redacted)
+	}
+	return s
+}
+
+// RedactValues returns a copy of vals with every value redacted. Keys are
+// left alone, so you can still see which parameters were sent.
+func (r *Redactor) RedactValues(vals url.Values) url.Values {
+	if r == nil || vals == nil {
+		return vals
+	}
+	redacted := make(url.Values, len(vals))
+	for k, vs := range vals {
+		redacted[k] = make([]string, len(vs))
+		for i, v := range vs {
+			redacted[k][i] = r.Redact(v)
+		}
+	}
+	return redacted
+}
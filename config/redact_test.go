@@ -0,0 +1,50 @@
+package config
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	r, err := NewRedactor([]string{`\b\d{13,16}\b`, `(?i)token=\w+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := r.Redact("card 4111111111111111 and Token=abc123, ok")
+	want := "card [redacted] and [redacted], ok"
+	if got != want {
+		t.Errorf("Redact: got %q, want %q", got, want)
+	}
+	vals := url.Values{"Body": []string{"my card is 4111111111111111"}, "From": []string{"+14105551234"}}
+	redacted := r.RedactValues(vals)
+	if redacted.Get("Body") != "my card is [redacted]" {
+		t.Errorf("RedactValues: got Body %q", redacted.Get("Body"))
+	}
+	if redacted.Get("From") != "+14105551234" {
+		t.Errorf("RedactValues: got From %q", redacted.Get("From"))
+	}
+	if vals.Get("Body") != "my card is 4111111111111111" {
+		t.Errorf("RedactValues changed its argument")
+	}
+}
+
+func TestNilRedactor(t *testing.T) {
+	r, err := NewRedactor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != nil {
+		t.Fatalf("expected nil Redactor for no patterns, got %v", r)
+	}
+	if got := r.Redact("4111111111111111"); got != "4111111111111111" {
+		t.Errorf("nil Redactor changed the string: %q", got)
+	}
+}
+
+func TestNewRedactorInvalid(t *testing.T) {
+	for _, patterns := range [][]string{{"("}, {"ok", ""}} {
+		if _, err := NewRedactor(patterns); err == nil {
+			t.Errorf("expected an error for %q, got nil", patterns)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// The longest note a runbook can have. Notes are shown inline next to error
+// codes, so anything longer belongs on the linked page.
+const maxRunbookNote = 500
+
+// A Runbook is an internal link or note about a Twilio error code, shown
+// wherever the code appears, for example
+//
+//     30007:
+//       url: https://wiki.example.com/runbooks/carrier-filtering
+//       note: Carrier filtered the message. Check it for URL shorteners.
+type Runbook struct {
+	URL  string `yaml:"url"`
+	Note string `yaml:"note"`
+}
+
+// Runbooks finds the runbook for an error code. A nil Runbooks doesn't have
+// any.
+type Runbooks struct {
+	codes map[twilio.Code]*Runbook
+}
+
+// NewRunbooks validates the runbooks in codes. If codes is empty,
+// NewRunbooks returns nil.
+func NewRunbooks(codes map[twilio.Code]Runbook) (*Runbooks, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	keys := make([]int, 0, len(codes))
+	for code := range codes {
+		keys = append(keys, int(code))
+	}
+	// Report errors in the same order every time.
+	sort.Ints(keys)
+	r := &Runbooks{codes: make(map[twilio.Code]*Runbook, len(codes))}
+	for _, key := range keys {
+		code := twilio.Code(key)
+		rb := codes[code]
+		if code <= 0 {
+			return nil, fmt.Errorf("Invalid error code %d in runbooks", code)
+		}
+		if rb.URL == "" && rb.Note == "" {
+			return nil, fmt.Errorf("Runbook for error %d needs a url or a note", code)
+		}
+		if rb.URL != "" {
+			u, err := url.Parse(rb.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("Invalid runbook url for error %d, use an http or https URL", code)
+			}
+		}
+		if len(rb.Note) > maxRunbookNote {
+			return nil, fmt.Errorf("Runbook note for error %d is too long, the maximum is %d characters", code, maxRunbookNote)
+		}
+		r.codes[code] = &Runbook{URL: rb.URL, Note: rb.Note}
+	}
+	return r, nil
+}
+
+// Get returns the runbook for code, or nil if there isn't one.
+func (r *Runbooks) Get(code twilio.Code) *Runbook {
+	if r == nil {
+		return nil
+	}
+	return r.codes[code]
+}
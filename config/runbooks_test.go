@@ -0,0 +1,59 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	twilio "github.com/saintpete/twilio-go"
+)
+
+func TestRunbooks(t *testing.T) {
+	r, err := NewRunbooks(map[twilio.Code]Runbook{
+		30007: {URL: "https://wiki.example.com/runbooks/carrier-filtering", Note: "Check for URL shorteners"},
+		11200: {Note: "Page the on-call engineer for the webhook"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rb := r.Get(30007); rb == nil || rb.URL != "https://wiki.example.com/runbooks/carrier-filtering" {
+		t.Errorf("expected the runbook for 30007, got %v", rb)
+	}
+	if rb := r.Get(11200); rb == nil || rb.Note != "Page the on-call engineer for the webhook" {
+		t.Errorf("expected the runbook for 11200, got %v", rb)
+	}
+	if rb := r.Get(30003); rb != nil {
+		t.Errorf("expected no runbook for 30003, got %v", rb)
+	}
+}
+
+func TestNilRunbooks(t *testing.T) {
+	r, err := NewRunbooks(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != nil {
+		t.Fatalf("expected nil Runbooks, got %v", r)
+	}
+	if rb := r.Get(30007); rb != nil {
+		t.Errorf("expected nil Runbooks to have no runbooks, got %v", rb)
+	}
+}
+
+var invalidRunbookTests = []struct {
+	rb   Runbook
+	want string
+}{
+	{Runbook{}, "needs a url or a note"},
+	{Runbook{URL: "javascript:alert(1)"}, "use an http or https URL"},
+	{Runbook{URL: "wiki/runbooks"}, "use an http or https URL"},
+	{Runbook{Note: strings.Repeat("a", maxRunbookNote+1)}, "too long"},
+}
+
+func TestInvalidRunbooks(t *testing.T) {
+	for _, tt := range invalidRunbookTests {
+		_, err := NewRunbooks(map[twilio.Code]Runbook{30007: tt.rb})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("NewRunbooks(%v): expected error containing %q, got %v", tt.rb, tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// CSPReportPath is where browsers send reports of content the
+// Content-Security-Policy blocked.
+const CSPReportPath = "/csp-report"
+
+// defaultCSP is the Content-Security-Policy for every page, before the
+// csp_directives overrides. Inline scripts need the nonce for the request,
+// which is added to script-src.
+var defaultCSP = map[string]string{
+	"default-src": "'self'",
+	"script-src":  "'self'",
+	// Branding colors and a few templates use inline styles.
+	"style-src": "'self' 'unsafe-inline' https://fonts.googleapis.com",
+	"font-src":  "'self' https://fonts.gstatic.com",
+	// MMS images and recordings can be loaded from Twilio, and the logo
+	// from anywhere.
+	"img-src":         "'self' https: data:",
+	"media-src":       "'self' https:",
+	"object-src":      "'none'",
+	"base-uri":        "'self'",
+	"form-action":     "'self'",
+	"frame-ancestors": "'none'",
+	"report-uri":      CSPReportPath,
+}
+
+// referrerPolicies are the values browsers understand for Referrer-Policy, in
+// alphabetical order.
+var referrerPolicies = []string{
+	"no-referrer", "no-referrer-when-downgrade", "origin",
+	"origin-when-cross-origin", "same-origin", "strict-origin",
+	"strict-origin-when-cross-origin", "unsafe-url",
+}
+
+var directiveRx = regexp.MustCompile(`^[a-z]+(-[a-z]+)*$`)
+
+// SecurityHeaders are sent with every response, to limit what browsers will
+// do with Logrole's pages: which scripts they run, which sites can frame
+// them, and what they tell other sites about where the user came from.
+type SecurityHeaders struct {
+	// Content-Security-Policy directives, keyed by name, like "img-src".
+	CSP map[string]string
+	// Send Content-Security-Policy-Report-Only instead, so browsers report
+	// what the policy would block without blocking it.
+	ReportOnly     bool
+	ReferrerPolicy string
+}
+
+// DefaultSecurityHeaders are used if none are configured.
+var DefaultSecurityHeaders = &SecurityHeaders{
+	CSP:            defaultCSP,
+	ReferrerPolicy: "same-origin",
+}
+
+// NewSecurityHeaders validates the given values and returns SecurityHeaders.
+// Each of overrides replaces the default directive with the same name, or
+// removes it if the value is empty. An empty referrerPolicy means
+// "same-origin".
+func NewSecurityHeaders(overrides map[string]string, reportOnly bool, referrerPolicy string) (*SecurityHeaders, error) {
+	s := &SecurityHeaders{
+		CSP:            make(map[string]string, len(defaultCSP)+len(overrides)),
+		ReportOnly:     reportOnly,
+		ReferrerPolicy: DefaultSecurityHeaders.ReferrerPolicy,
+	}
+	for name, val := range defaultCSP {
+		s.CSP[name] = val
+	}
+	for name, val := range overrides {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !directiveRx.MatchString(name) {
+			return nil, fmt.Errorf("Invalid csp_directives name %q, use a directive like \"img-src\"", name)
+		}
+		val = strings.TrimSpace(val)
+		if strings.ContainsAny(val, ";,\r\n") {
+			return nil, fmt.Errorf("Invalid value for csp_directives %s: %q can't contain a semicolon, comma or newline", name, val)
+		}
+		if val == "" {
+			delete(s.CSP, name)
+			continue
+		}
+		s.CSP[name] = val
+	}
+	if referrerPolicy != "" {
+		referrerPolicy = strings.ToLower(strings.TrimSpace(referrerPolicy))
+		i := sort.SearchStrings(referrerPolicies, referrerPolicy)
+		if i == len(referrerPolicies) || referrerPolicies[i] != referrerPolicy {
+			return nil, fmt.Errorf("Unknown referrer_policy %q, use one of %s", referrerPolicy, strings.Join(referrerPolicies, ", "))
+		}
+		s.ReferrerPolicy = referrerPolicy
+	}
+	return s, nil
+}
+
+// ContentSecurityPolicy returns the Content-Security-Policy header, allowing
+// inline scripts with the given nonce.
+func (s *SecurityHeaders) ContentSecurityPolicy(nonce string) string {
+	names := make([]string, 0, len(s.CSP)+1)
+	for name := range s.CSP {
+		names = append(names, name)
+	}
+	if _, ok := s.CSP["script-src"]; !ok {
+		names = append(names, "script-src")
+	}
+	sort.Strings(names)
+	directives := make([]string, len(names))
+	for i, name := range names {
+		val := s.CSP[name]
+		if name == "script-src" {
+			val = strings.TrimSpace(val + " 'nonce-" + nonce + "'")
+		}
+		directives[i] = name + " " + val
+	}
+	return strings.Join(directives, "; ")
+}
+
+// FrameOptions returns the X-Frame-Options header that matches the
+// frame-ancestors directive, for browsers that don't support it, or the
+// empty string if there isn't one.
+func (s *SecurityHeaders) FrameOptions() string {
+	switch s.CSP["frame-ancestors"] {
+	case "'none'":
+		return "DENY"
+	case "'self'":
+		return "SAMEORIGIN"
+	default:
+		return ""
+	}
+}
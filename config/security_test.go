@@ -0,0 +1,58 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewSecurityHeaders(t *testing.T) {
+	t.Parallel()
+	s, err := NewSecurityHeaders(map[string]string{
+		"IMG-SRC":         "'self' https://media.example.com",
+		"frame-ancestors": "'self'",
+		"report-uri":      "",
+	}, true, "no-referrer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	csp := s.ContentSecurityPolicy("abc")
+	for _, want := range []string{
+		"img-src 'self' https://media.example.com",
+		"script-src 'self' 'nonce-abc'",
+		"default-src 'self'",
+	} {
+		if !strings.Contains(csp, want) {
+			t.Errorf("expected policy to contain %q, got %q", want, csp)
+		}
+	}
+	if strings.Contains(csp, "report-uri") {
+		t.Errorf("expected report-uri to be removed, got %q", csp)
+	}
+	if fo := s.FrameOptions(); fo != "SAMEORIGIN" {
+		t.Errorf("expected X-Frame-Options SAMEORIGIN, got %q", fo)
+	}
+	if !s.ReportOnly || s.ReferrerPolicy != "no-referrer" {
+		t.Errorf("unexpected headers %#v", s)
+	}
+	if _, ok := DefaultSecurityHeaders.CSP["report-uri"]; !ok {
+		t.Errorf("expected overrides not to change the defaults")
+	}
+}
+
+var securityHeaderErrors = []struct {
+	overrides      map[string]string
+	referrerPolicy string
+}{
+	{map[string]string{"img src": "'self'"}, ""},
+	{map[string]string{"img-src": "'self'; script-src *"}, ""},
+	{nil, "sometimes"},
+}
+
+func TestNewSecurityHeadersErrors(t *testing.T) {
+	t.Parallel()
+	for _, tt := range securityHeaderErrors {
+		if _, err := NewSecurityHeaders(tt.overrides, false, tt.referrerPolicy); err == nil {
+			t.Errorf("NewSecurityHeaders(%v, %q): expected an error, got nil", tt.overrides, tt.referrerPolicy)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/saintpete/logrole/store"
+)
+
+// SessionDuration is how long a login lasts.
+const SessionDuration = 14 * 24 * time.Hour
+
+// The store.Store bucket sessions are kept in, keyed by session ID.
+const sessionsBucket = "sessions"
+
+// A DBSessionStore saves the time a session was last used at most this often,
+// so every page view doesn't write a row.
+const sessionTouchInterval = time.Minute
+
+// A Session is one login through Google or OpenID Connect. The login cookie
+// holds the session's ID, so revoking the session logs that browser out right
+// away, instead of when the cookie expires.
+type Session struct {
+	ID        string    `json:"id"`
+	User      string    `json:"user"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+	UserAgent string    `json:"user_agent"`
+	// The last time the session was used. Updated in memory on every
+	// request, and saved with the next change to the store.
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Active reports whether the session can be used at the given time.
+func (s *Session) Active(now time.Time) bool {
+	return now.Before(s.Expires)
+}
+
+// A SessionStore keeps track of logins on the server, so they can be listed
+// and revoked.
+type SessionStore interface {
+	// Create starts a session for user, lasting until expires.
+	Create(user string, expires time.Time, userAgent string) (*Session, error)
+	// Get returns the active session with the given id, and records that it
+	// was used at now.
+	Get(id string, now time.Time) (*Session, bool)
+	// Active returns every session that's active at now.
+	Active(now time.Time) []*Session
+	// Revoke ends the session with the given id and returns it.
+	Revoke(id string) (*Session, error)
+	// RevokeUser ends every session for user and returns how many there
+	// were.
+	RevokeUser(user string) (int, error)
+}
+
+// FileSessionStore is a SessionStore that keeps sessions in memory. If it has
+// a path, sessions are saved to that file as JSON after every change, and
+// loaded from it on startup, so logins survive a restart.
+type FileSessionStore struct {
+	path     string
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+// NewFileSessionStore creates a FileSessionStore, loading any sessions in the
+// file at path. The file doesn't need to exist yet. If path is empty,
+// sessions are only kept in memory, and everyone has to login again after a
+// restart.
+func NewFileSessionStore(path string) (*FileSessionStore, error) {
+	ss := &FileSessionStore{path: path, sessions: make(map[string]*Session)}
+	if path == "" {
+		return ss, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ss, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sessions []*Session
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, fmt.Errorf("Couldn't read sessions from %s: %v", path, err)
+	}
+	for _, s := range sessions {
+		ss.sessions[s.ID] = s
+	}
+	return ss, nil
+}
+
+func newSession(user string, expires time.Time, userAgent string) (*Session, error) {
+	if user == "" {
+		return nil, errors.New("Can't create a session without a user")
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	return &Session{
+		ID:        hex.EncodeToString(id),
+		User:      user,
+		Created:   now,
+		Expires:   expires,
+		UserAgent: userAgent,
+		LastSeen:  now,
+	}, nil
+}
+
+func (ss *FileSessionStore) Create(user string, expires time.Time, userAgent string) (*Session, error) {
+	s, err := newSession(user, expires, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.sessions[s.ID] = s
+	return s, ss.save()
+}
+
+func (ss *FileSessionStore) Get(id string, now time.Time) (*Session, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s, ok := ss.sessions[id]
+	if !ok || !s.Active(now) {
+		return nil, false
+	}
+	s.LastSeen = now.UTC()
+	s2 := *s
+	return &s2, true
+}
+
+// Active returns every session that's active at now, most recently used
+// first.
+func (ss *FileSessionStore) Active(now time.Time) []*Session {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	active := make([]*Session, 0, len(ss.sessions))
+	for _, s := range ss.sessions {
+		if s.Active(now) {
+			s2 := *s
+			active = append(active, &s2)
+		}
+	}
+	sort.Sort(sessionsByLastSeen(active))
+	return active
+}
+
+func (ss *FileSessionStore) Revoke(id string) (*Session, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s, ok := ss.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("No session with id %s", id)
+	}
+	delete(ss.sessions, id)
+	return s, ss.save()
+}
+
+func (ss *FileSessionStore) RevokeUser(user string) (int, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	count := 0
+	for id, s := range ss.sessions {
+		if s.User == user {
+			delete(ss.sessions, id)
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	return count, ss.save()
+}
+
+// save writes the sessions to ss.path, dropping any that have expired. ss.mu
+// must be held.
+func (ss *FileSessionStore) save() error {
+	now := time.Now()
+	sessions := make([]*Session, 0, len(ss.sessions))
+	for id, s := range ss.sessions {
+		if !s.Active(now) {
+			delete(ss.sessions, id)
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	if ss.path == "" {
+		return nil
+	}
+	sort.Sort(sessionsByLastSeen(sessions))
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFile(ss.path, data)
+}
+
+// DBSessionStore is a SessionStore that keeps sessions in a store.Store, like
+// a Postgres database. Every lookup reads the store, so servers that share a
+// database share logins, and a session revoked on one server is logged out on
+// all of them.
+type DBSessionStore struct {
+	db store.Store
+	// Held while reading and writing a session, so saving the time it was
+	// last used can't bring back a session that was just revoked.
+	mu sync.Mutex
+}
+
+// NewDBSessionStore creates a DBSessionStore that keeps sessions in db.
+func NewDBSessionStore(db store.Store) *DBSessionStore {
+	return &DBSessionStore{db: db}
+}
+
+func (ss *DBSessionStore) get(id string) (*Session, error) {
+	data, err := ss.db.Get(sessionsBucket, id)
+	if err != nil {
+		return nil, err
+	}
+	s := new(Session)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("Couldn't read session %s: %v", id, err)
+	}
+	return s, nil
+}
+
+func (ss *DBSessionStore) put(s *Session) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return ss.db.Put(sessionsBucket, s.ID, data)
+}
+
+// all returns every session that's active at now, deleting the ones that
+// have expired.
+func (ss *DBSessionStore) all(now time.Time) ([]*Session, error) {
+	all, err := ss.db.All(sessionsBucket)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*Session, 0, len(all))
+	for id, data := range all {
+		s := new(Session)
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("Couldn't read session %s: %v", id, err)
+		}
+		if !s.Active(now) {
+			if err := ss.db.Delete(sessionsBucket, id); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+func (ss *DBSessionStore) Create(user string, expires time.Time, userAgent string) (*Session, error) {
+	s, err := newSession(user, expires, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	// Logins are rare enough to clean up expired sessions here.
+	if _, err := ss.all(time.Now()); err != nil {
+		return nil, err
+	}
+	return s, ss.put(s)
+}
+
+// Get returns the active session with the given id. If the store can't be
+// read, the session isn't found, and the user has to login again.
+func (ss *DBSessionStore) Get(id string, now time.Time) (*Session, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s, err := ss.get(id)
+	if err != nil || !s.Active(now) {
+		return nil, false
+	}
+	if now.Sub(s.LastSeen) >= sessionTouchInterval {
+		s.LastSeen = now.UTC()
+		// Not worth failing the request over.
+		ss.put(s)
+	}
+	return s, true
+}
+
+// Active returns every session that's active at now, most recently used
+// first, or none if the store can't be read.
+func (ss *DBSessionStore) Active(now time.Time) []*Session {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	active, err := ss.all(now)
+	if err != nil {
+		return []*Session{}
+	}
+	sort.Sort(sessionsByLastSeen(active))
+	return active
+}
+
+func (ss *DBSessionStore) Revoke(id string) (*Session, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s, err := ss.get(id)
+	if err == store.ErrNotFound {
+		return nil, fmt.Errorf("No session with id %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, ss.db.Delete(sessionsBucket, id)
+}
+
+func (ss *DBSessionStore) RevokeUser(user string) (int, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	sessions, err := ss.all(time.Now())
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, s := range sessions {
+		if s.User != user {
+			continue
+		}
+		if err := ss.db.Delete(sessionsBucket, s.ID); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+type sessionsByLastSeen []*Session
+
+func (s sessionsByLastSeen) Len() int      { return len(s) }
+func (s sessionsByLastSeen) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sessionsByLastSeen) Less(i, j int) bool {
+	if !s[i].LastSeen.Equal(s[j].LastSeen) {
+		return s[i].LastSeen.After(s[j].LastSeen)
+	}
+	return s[i].ID < s[j].ID
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/store"
+)
+
+func TestFileSessionStore(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sessions.json")
+	ss, err := NewFileSessionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s1, err := ss.Create("user@example.com", now.Add(time.Hour), "Firefox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.Create("user@example.com", now.Add(time.Hour), "Chrome"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.Create("other@example.com", now.Add(time.Hour), "Safari"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ss.Get(s1.ID, now); !ok {
+		t.Fatalf("expected to find session %s", s1.ID)
+	}
+	if _, ok := ss.Get(s1.ID, now.Add(2*time.Hour)); ok {
+		t.Errorf("expected session to expire")
+	}
+
+	// Sessions are loaded from the file.
+	ss2, err := NewFileSessionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ss2.Active(now)); n != 3 {
+		t.Fatalf("expected 3 sessions after loading the file, got %d", n)
+	}
+	if _, err := ss2.Revoke(s1.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ss2.Get(s1.ID, now); ok {
+		t.Errorf("expected revoked session to be gone")
+	}
+	if _, err := ss2.Revoke(s1.ID); err == nil {
+		t.Errorf("expected an error revoking a session twice")
+	}
+	count, err := ss2.RevokeUser("user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected to revoke 1 session, got %d", count)
+	}
+	active := ss2.Active(now)
+	if len(active) != 1 || active[0].User != "other@example.com" {
+		t.Errorf("expected only other@example.com's session to be left, got %v", active)
+	}
+}
+
+func TestDBSessionStore(t *testing.T) {
+	t.Parallel()
+	db := store.NewMemory()
+	// Two servers sharing a database.
+	ss1, ss2 := NewDBSessionStore(db), NewDBSessionStore(db)
+	now := time.Now()
+	s1, err := ss1.Create("user@example.com", now.Add(time.Hour), "Firefox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss1.Create("other@example.com", now.Add(time.Hour), "Safari"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ss2.Get(s1.ID, now); !ok {
+		t.Fatalf("expected the other server to find session %s", s1.ID)
+	}
+	if _, ok := ss2.Get(s1.ID, now.Add(2*time.Hour)); ok {
+		t.Errorf("expected session to expire")
+	}
+	later := now.Add(10 * time.Minute)
+	ss2.Get(s1.ID, later)
+	if active := ss1.Active(later); len(active) != 2 || active[0].ID != s1.ID || !active[0].LastSeen.Equal(later.UTC()) {
+		t.Errorf("expected the session to be used most recently, got %v", active)
+	}
+	if _, err := ss1.Revoke(s1.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ss2.Get(s1.ID, now); ok {
+		t.Errorf("expected a session revoked on one server to be gone on the other")
+	}
+	if _, err := ss2.Revoke(s1.ID); err == nil {
+		t.Errorf("expected an error revoking a session twice")
+	}
+	if count, err := ss2.RevokeUser("other@example.com"); err != nil || count != 1 {
+		t.Errorf("expected to revoke 1 session, got %d, %v", count, err)
+	}
+	if all, _ := db.All(sessionsBucket); len(all) != 0 {
+		t.Errorf("expected no sessions to be left, got %d", len(all))
+	}
+}
+
+func TestRevokedSessionMustLogin(t *testing.T) {
+	t.Parallel()
+	key := services.NewRandomKey()
+	ss, _ := NewFileSessionStore("")
+	a := NewGoogleAuthenticator(NullLogger, "", "", "http://localhost", nil, key)
+	a.Sessions = ss
+	session, err := ss.Create("user@example.com", time.Now().Add(time.Hour), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := newToken("user@example.com")
+	tok.Session = session.ID
+	cookie := a.tokenCookie(tok)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	if _, err := a.Authenticate(httptest.NewRecorder(), req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.Revoke(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	if _, err := a.Authenticate(w, req); err != MustLogin {
+		t.Errorf("expected MustLogin for a revoked session, got %v", err)
+	}
+	if w.Header().Get("Set-Cookie") == "" {
+		t.Errorf("expected the login cookie to be cleared")
+	}
+
+	// Cookies from before sessions were turned on don't have a session.
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.AddCookie(a.newCookie("user@example.com"))
+	if _, err := a.Authenticate(httptest.NewRecorder(), req); err != MustLogin {
+		t.Errorf("expected MustLogin for a cookie without a session, got %v", err)
+	}
+}
@@ -1,24 +1,81 @@
 package config
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/inconshreveable/log15"
-	"github.com/kevinburke/handlers"
 	twilio "github.com/saintpete/twilio-go"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/logging"
 	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/store"
 	yaml "gopkg.in/yaml.v2"
 )
 
 const DefaultPort = "4114"
 const DefaultPageSize = 50
 
+// DefaultStuckMessageInterval is how often we check for stuck messages, if
+// stuck_message_threshold is set.
+const DefaultStuckMessageInterval = 5 * time.Minute
+
+// DefaultTranslationLanguage is the language message bodies are translated
+// into, if translation_url is set and translation_language isn't.
+const DefaultTranslationLanguage = "en"
+
+// DefaultNumberInventoryInterval is how often we take a snapshot of the
+// account's phone numbers, if number_inventory is set.
+const DefaultNumberInventoryInterval = 24 * time.Hour
+
+// DefaultMediaCacheSizeMB and DefaultMediaCacheTTL apply if media_cache_dir
+// is set. Media and recordings don't change, so they can be kept for a while.
+const DefaultMediaCacheSizeMB = 512
+const DefaultMediaCacheTTL = 30 * 24 * time.Hour
+
+// DefaultTwilioQueueTimeout is how long a request to Twilio waits for one of
+// the requests in progress to finish, if max_concurrent_twilio_requests or
+// twilio_concurrency_limits is set.
+const DefaultTwilioQueueTimeout = 5 * time.Second
+
+// DefaultMaxRecordingDownloadMB is the largest zip of recordings a user can
+// download at once, unless max_recording_download_mb is set.
+const DefaultMaxRecordingDownloadMB = 500
+
+// DefaultCacheSnapshotInterval and DefaultMaxCacheSnapshotMB apply if
+// cache_snapshot_file is set. The API cache itself holds about 25MB.
+const DefaultCacheSnapshotInterval = 10 * time.Minute
+const DefaultMaxCacheSnapshotMB = 50
+
+// DefaultCacheReplicationKBPerSecond is the most cached data sent to each
+// peer every second, unless cache_replication_kb_per_second is set.
+const DefaultCacheReplicationKBPerSecond = 1024
+
+// DefaultStatusReconcileInterval is how often messages stuck in a
+// non-terminal status are re-fetched, unless status_reconcile_interval is
+// set.
+const DefaultStatusReconcileInterval = 5 * time.Minute
+
+// DefaultRetentionInterval is how often expired local data is purged, if any
+// retention policy is set and retention_interval isn't.
+const DefaultRetentionInterval = time.Hour
+
+// DefaultPrefetchWorkers is how many next pages can be fetched into the cache
+// at once, unless prefetch_workers is set.
+const DefaultPrefetchWorkers = 4
+
 // DefaultTimezones are a user's options if no timezones are configured. These
 // correspond to the 4 timezones in the USA, west to east.
 var DefaultTimezones = []string{
@@ -44,6 +101,15 @@ type FileConfig struct {
 	Port       string `yaml:"port"`
 	AccountSid string `yaml:"twilio_account_sid"`
 	AuthToken  string `yaml:"twilio_auth_token"`
+	// Talk to the Twilio API with an API key instead of the auth token - see
+	// docs/settings.md#api-keys. Keys in APIKeys are keyed by account sid.
+	APIKeySid    string            `yaml:"twilio_api_key_sid"`
+	APIKeySecret string            `yaml:"twilio_api_key_secret"`
+	APIKeys      map[string]APIKey `yaml:"twilio_api_keys"`
+	// Subaccounts to list messages, calls and alerts from, alongside the main
+	// account - see docs/settings.md#subaccounts.
+	AccountName string             `yaml:"twilio_account_name"`
+	Subaccounts []SubaccountConfig `yaml:"twilio_subaccounts"`
 
 	Realm services.Rlm `yaml:"realm"`
 	// Default timezone for dates/times in the UI
@@ -69,6 +135,227 @@ type FileConfig struct {
 	// "false" from "omitted"
 	ShowMediaByDefault *bool `yaml:"show_media_by_default,omitempty"`
 
+	// Disable everything that makes changes to the Twilio account, and admin
+	// changes, for every user.
+	ReadOnly bool `yaml:"read_only"`
+
+	// Turn features on or off for everyone - see
+	// docs/settings.md#feature-flags. Groups in the policy can override
+	// these.
+	Features Features `yaml:"features"`
+
+	// Flag messages that have been queued or sending for longer than this.
+	// If zero, we don't check for stuck messages.
+	StuckMessageThreshold time.Duration `yaml:"stuck_message_threshold"`
+	StuckMessageInterval  time.Duration `yaml:"stuck_message_interval"`
+
+	// Take a snapshot of the account's phone numbers every
+	// NumberInventoryInterval, and send a notification when numbers were
+	// purchased or released since the last one. Save snapshots to
+	// NumberInventoryFile, or only keep them in memory if it's empty.
+	NumberInventory         bool          `yaml:"number_inventory"`
+	NumberInventoryInterval time.Duration `yaml:"number_inventory_interval"`
+	NumberInventoryFile     string        `yaml:"number_inventory_file"`
+
+	// POST notifications, like stuck messages, to this URL.
+	NotifyWebhookURL string `yaml:"notify_webhook_url"`
+
+	// Cache MMS media and recordings in this directory. If empty, media is
+	// fetched from Twilio every time it's viewed.
+	MediaCacheDir    string        `yaml:"media_cache_dir"`
+	MediaCacheSizeMB int64         `yaml:"media_cache_size_mb"`
+	MediaCacheTTL    time.Duration `yaml:"media_cache_ttl"`
+	// Encrypt cached media with this key, 64 hex characters. If empty, the
+	// secret_key is used. Media encrypted with one of the old keys can still
+	// be read, and is encrypted again with the new key - see
+	// docs/settings.md#media-cache.
+	MediaCacheKey     string   `yaml:"media_cache_key"`
+	MediaCacheOldKeys []string `yaml:"media_cache_old_keys"`
+
+	// Write exports and their checkpoints to this directory, so exports that
+	// are running when the server stops pick up where they left off. If
+	// empty, exports are held in memory.
+	ExportsDir string `yaml:"exports_dir"`
+
+	// POST MMS media to this URL to be scanned before it's shown - see
+	// docs/settings.md#scanning-media.
+	MediaScanURL string `yaml:"media_scan_url"`
+
+	// POST MMS media to this URL to find the text in it, so messages can be
+	// searched by what their attachments say - see
+	// docs/settings.md#searching-attachment-text. Keep the text in
+	// AttachmentTextFile; if it's empty, the text is lost on restart.
+	AttachmentTextURL  string `yaml:"attachment_text_url"`
+	AttachmentTextFile string `yaml:"attachment_text_file"`
+
+	// POST message bodies to this URL to translate them into
+	// TranslationLanguage, when a user asks - see
+	// docs/settings.md#translating-messages.
+	TranslationURL      string `yaml:"translation_url"`
+	TranslationLanguage string `yaml:"translation_language"`
+
+	// Hash the bodies of outbound messages people view, and flag bodies sent
+	// to at least DuplicateBodyRecipients numbers within DuplicateBodyWindow
+	// - see docs/settings.md#duplicate-content.
+	BodyHashing             bool          `yaml:"body_hashing"`
+	DuplicateBodyRecipients int           `yaml:"duplicate_body_recipients"`
+	DuplicateBodyWindow     time.Duration `yaml:"duplicate_body_window"`
+
+	// Stop adding recordings to a bulk download once it's this big.
+	MaxRecordingDownloadMB int64 `yaml:"max_recording_download_mb"`
+
+	// Regular expressions for text to hide in the request and response on
+	// alerts, like card numbers - see docs/settings.md#alert-payloads.
+	AlertRedactions []string `yaml:"alert_redactions"`
+
+	// Internal runbook links and notes for Twilio error codes, shown wherever
+	// the code appears - see docs/settings.md#runbooks.
+	Runbooks map[twilio.Code]Runbook `yaml:"runbooks"`
+
+	// Save the API cache to this file every CacheSnapshotInterval, and load it
+	// on boot, so a restarted server starts warm.
+	CacheSnapshotFile     string        `yaml:"cache_snapshot_file"`
+	CacheSnapshotInterval time.Duration `yaml:"cache_snapshot_interval"`
+	MaxCacheSnapshotMB    int64         `yaml:"max_cache_snapshot_mb"`
+
+	// Send cached API responses to these other Logrole instances, and accept
+	// the ones they send, so a standby is warm when it takes over. Sending is
+	// capped at CacheReplicationKBPerSecond for each peer.
+	CacheReplicationPeers       []string `yaml:"cache_replication_peers"`
+	CacheReplicationKBPerSecond int64    `yaml:"cache_replication_kb_per_second"`
+	// Batches are encrypted with this key, which every peer needs, and which
+	// can't be the secret_key.
+	CacheReplicationKey string `yaml:"cache_replication_key"`
+	// Only accept batches from these subnets. Defaults to ip_subnets.
+	CacheReplicationSubnets []string `yaml:"cache_replication_subnets"`
+
+	// Keep the last CacheGenerations API responses cached at each key, so
+	// admins can see what a list looked like earlier - see
+	// docs/settings.md#cache-history.
+	CacheGenerations int `yaml:"cache_generations"`
+
+	// Don't fetch the next page of a list into the cache in the background.
+	DisablePrefetch bool `yaml:"disable_prefetch"`
+	// How many next pages to fetch at once.
+	PrefetchWorkers int `yaml:"prefetch_workers"`
+
+	// Groups of phone numbers that belong to the same customer or line, so
+	// the number timeline shows their traffic as one thread - see
+	// docs/settings.md#number-aliases.
+	NumberAliases [][]string `yaml:"number_aliases"`
+
+	// Keep labels, owners, ticket references, notes, grants, the blocklist,
+	// sessions and audit events in one store, instead of their files -
+	// "memory", "sqlite" or "postgres". The database drivers need a build
+	// tag, see docs/settings.md#storage.
+	StorageDriver string `yaml:"storage_driver"`
+	StorageDSN    string `yaml:"storage_dsn"`
+
+	// Save phone number labels to this CSV file. If empty, labels are lost
+	// when the server restarts.
+	LabelsFile string `yaml:"labels_file"`
+	// Save the team that owns each phone number to this CSV file. If empty,
+	// owners are lost when the server restarts.
+	OwnersFile string `yaml:"owners_file"`
+
+	// "Create ticket" buttons on message and call pages - see
+	// docs/settings.md#tickets.
+	TicketLinks []TicketLink `yaml:"ticket_links"`
+	// Save references to created tickets to this file.
+	TicketsFile string `yaml:"tickets_file"`
+
+	// Save the notes people attach to messages, calls and alerts to this
+	// file. If empty, notes are lost when the server restarts.
+	NotesFile string `yaml:"notes_file"`
+
+	// Extra permissions for users, until a date - see
+	// docs/settings.md#temporary-permissions.
+	Grants []GrantConfig `yaml:"grants"`
+	// Save grants made from /admin/grants to this file.
+	GrantsFile string `yaml:"grants_file"`
+	// Let users give themselves extra permissions in an emergency - see
+	// docs/settings.md#break-glass-access.
+	BreakGlass *BreakGlassConfig `yaml:"break_glass"`
+	// Hide the traffic of a few numbers from everyone outside one group -
+	// see docs/settings.md#blocked-numbers.
+	Blocklist *BlocklistConfig `yaml:"blocklist"`
+
+	// Store Google and OpenID Connect logins in this file, so they can be
+	// listed and revoked from /admin/sessions. If empty, logins are only
+	// checked against the cookie, unless storage_driver is set.
+	SessionsFile string `yaml:"sessions_file"`
+
+	// Append a JSON line for every audited action, like granting
+	// permissions, to this file.
+	AuditLogFile string `yaml:"audit_log_file"`
+
+	// Save the results posted to /webhooks/queues to this file. If empty,
+	// queue wait times are lost when the server restarts.
+	QueueEventsFile string `yaml:"queue_events_file"`
+
+	// Save the statuses posted to /webhooks/messages to this file. Messages
+	// that haven't reached a final status are re-fetched every
+	// StatusReconcileInterval, in case a callback was missed.
+	MessageStatusesFile     string        `yaml:"message_statuses_file"`
+	StatusReconcileInterval time.Duration `yaml:"status_reconcile_interval"`
+
+	// Delete local data once it's older than this, keyed by store, like
+	// "audit_log" - see docs/settings.md#data-retention. Purge every
+	// RetentionInterval; with RetentionDryRun, only log what would be
+	// deleted.
+	Retention         map[string]time.Duration `yaml:"retention"`
+	RetentionInterval time.Duration            `yaml:"retention_interval"`
+	RetentionDryRun   bool                     `yaml:"retention_dry_run"`
+
+	// Let browser-based tools on these origins make requests to Logrole.
+	CORSAllowedOrigins []string      `yaml:"cors_allowed_origins"`
+	CORSAllowedHeaders []string      `yaml:"cors_allowed_headers"`
+	CORSMaxAge         time.Duration `yaml:"cors_max_age"`
+
+	// The SameSite attribute of the CSRF token cookie: "lax" (the default),
+	// "strict" or "none".
+	CSRFSameSite string `yaml:"csrf_same_site"`
+
+	// Override directives of the default Content-Security-Policy, or remove
+	// them with an empty value - see docs/settings.md#security-headers.
+	CSPDirectives  map[string]string `yaml:"csp_directives"`
+	CSPReportOnly  bool              `yaml:"csp_report_only"`
+	ReferrerPolicy string            `yaml:"referrer_policy"`
+
+	// Serve resources from an archive in this directory instead of from the
+	// Twilio API - see docs/settings.md#archived-accounts.
+	ArchiveDir string `yaml:"archive_dir"`
+
+	// Serve made up resources instead of asking the Twilio API - see
+	// docs/settings.md#demo-mode.
+	Demo bool `yaml:"demo"`
+
+	// Credentials for providers other than Twilio, keyed by provider name,
+	// like "telnyx" - see docs/settings.md#other-providers.
+	Providers map[string]ProviderConfig `yaml:"providers"`
+
+	// Serve Go's profiler at /debug/pprof, and runtime stats at /debug/vars,
+	// to users with the can_profile permission.
+	EnableProfiling bool `yaml:"enable_profiling"`
+
+	// Fail Twilio API requests after this many for a single page. If zero,
+	// there's no limit.
+	MaxTwilioCallsPerRequest int `yaml:"max_twilio_calls_per_request"`
+
+	// The most requests to Twilio in progress at once, across all users, and
+	// for each type of resource, like "recordings". Requests wait up to
+	// TwilioQueueTimeout for a slot - see
+	// docs/settings.md#twilio-concurrency-limits.
+	MaxConcurrentTwilioRequests int            `yaml:"max_concurrent_twilio_requests"`
+	TwilioConcurrencyLimits     map[string]int `yaml:"twilio_concurrency_limits"`
+	TwilioQueueTimeout          time.Duration  `yaml:"twilio_queue_timeout"`
+
+	// Branding for the site - see docs/settings.md#branding.
+	ProductName  string       `yaml:"product_name"`
+	LogoURL      string       `yaml:"logo_url"`
+	PrimaryColor string       `yaml:"primary_color"`
+	FooterLinks  []FooterLink `yaml:"footer_links"`
+
 	EmailAddress string `yaml:"email_address"`
 
 	ErrorReporter      string `yaml:"error_reporter,omitempty"`
@@ -82,16 +369,39 @@ type FileConfig struct {
 	GoogleClientSecret   string   `yaml:"google_client_secret"`
 	GoogleAllowedDomains []string `yaml:"google_allowed_domains"`
 
+	OIDCIssuer         string   `yaml:"oidc_issuer"`
+	OIDCClientID       string   `yaml:"oidc_client_id"`
+	OIDCClientSecret   string   `yaml:"oidc_client_secret"`
+	OIDCAllowedDomains []string `yaml:"oidc_allowed_domains"`
+
+	TLSCertFile  string `yaml:"tls_cert_file"`
+	TLSKeyFile   string `yaml:"tls_key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	// Reject connections that don't present a client certificate.
+	RequireClientCert bool `yaml:"require_client_cert"`
+
 	PolicyFile string `yaml:"policy_file"`
 	Policy     *Policy
 
 	Debug bool `yaml:"debug"`
+	// The lowest level to log: "debug", "info", "warn", "error" or "crit".
+	// Defaults to "info", or "debug" if debug is set.
+	LogLevel string `yaml:"log_level"`
+	// Levels for individual modules, like "cache: debug", overriding
+	// log_level - see docs/settings.md#logging.
+	LogLevels map[string]string `yaml:"log_levels"`
+	// "text" (the default), or "json" for one JSON object per line.
+	LogFormat string `yaml:"log_format"`
 }
 
 // Settings are used to configure a Server and apply to all of the website's
 // users.
 type Settings struct {
 	Logger log.Logger
+	// The level each module logs at, which can be changed from
+	// /admin/log-levels. nil if the Logger was passed to
+	// NewSettingsFromConfig.
+	LogLevels *logging.Levels
 
 	// The host the user visits to get to this site.
 	PublicHost string
@@ -99,6 +409,10 @@ type Settings struct {
 	// Whether to allow HTTP traffic.
 	AllowUnencryptedTraffic bool
 	Client                  *twilio.Client
+	// What lists call the main account, if there are subaccounts.
+	AccountName string
+	// Messages, calls and alerts can also be listed from these subaccounts.
+	Subaccounts []*Subaccount
 
 	// LocationFinder determines the correct timezone to display for a given
 	// request, based on the default and a user's TZ cookie (if present).
@@ -118,6 +432,189 @@ type Settings struct {
 	// Should a user have to click a button to view media attached to a MMS?
 	ShowMediaByDefault bool
 
+	// If true, reject any request that could modify data, like sending
+	// a message, regardless of the user's permissions.
+	ReadOnly bool
+
+	// Features that are on or off for every user whose group doesn't say
+	// otherwise.
+	Features Features
+
+	// If greater than zero, check every StuckMessageInterval for messages
+	// that have been queued or sending for longer than this.
+	StuckMessageThreshold time.Duration
+	StuckMessageInterval  time.Duration
+
+	// The latest snapshot of the account's phone numbers and the changes
+	// between snapshots, which are taken every NumberInventoryInterval. nil
+	// unless number_inventory is set.
+	NumberInventory         *services.InventoryStore
+	NumberInventoryInterval time.Duration
+
+	// Sends notifications, like stuck messages. If nil, notifications are
+	// dropped.
+	Notifier services.Notifier
+
+	// Stores MMS media and recordings on disk. If nil, they are fetched from
+	// Twilio on every request.
+	MediaCache *cache.BlobStore
+
+	// Exports in progress are checkpointed in this directory, if it's set.
+	ExportsDir string
+
+	// Checks MMS media before it's shown. If nil, media isn't scanned.
+	MediaScanner services.MediaScanner
+
+	// Finds the text in MMS media, which is kept in AttachmentText. Both are
+	// nil unless attachment_text_url is set.
+	TextExtractor  services.TextExtractor
+	AttachmentText *services.AttachmentTextStore
+
+	// Translates message bodies into TranslationLanguage. nil unless
+	// translation_url is set.
+	Translator          services.Translator
+	TranslationLanguage string
+
+	// Counts messages by a hash of their body. nil unless body_hashing is
+	// set.
+	BodyHashes *services.BodyHashStore
+
+	// The most recording data, in bytes, one bulk download can include.
+	MaxRecordingDownload int64
+
+	// Hides matching text in alert request and response payloads. If nil,
+	// payloads are shown as Twilio recorded them.
+	AlertRedactor *Redactor
+
+	// If not empty, the API cache is saved to this file every
+	// CacheSnapshotInterval and restored from it on boot. Snapshots are capped
+	// at MaxCacheSnapshot bytes.
+	CacheSnapshotFile     string
+	CacheSnapshotInterval time.Duration
+	MaxCacheSnapshot      int64
+
+	// The base URLs of other Logrole instances to send cached API responses
+	// to, and accept them from, so a standby instance is warm when it takes
+	// over. At most ReplicationBandwidth bytes of cached data are sent to each
+	// peer every second.
+	ReplicationPeers     []string
+	ReplicationBandwidth int64
+	// The key batches are encrypted with, and the subnets peers can send them
+	// from. Set if ReplicationPeers is.
+	ReplicationKey     *[32]byte
+	ReplicationSubnets []*net.IPNet
+
+	// How many API responses to keep for each cache key, including the
+	// current one. If less than 2, only the current one is kept.
+	CacheGenerations int
+
+	// After showing a page of a list, fetch the next page into the cache with
+	// one of PrefetchWorkers background workers, unless DisablePrefetch is
+	// set. If PrefetchWorkers is zero, DefaultPrefetchWorkers is used.
+	DisablePrefetch bool
+	PrefetchWorkers int
+
+	// Where labels, tickets, notes and audit events are kept. If nil, they're
+	// kept in the files set in the config, if any.
+	Storage store.Store
+
+	// Names for phone numbers, shown wherever the number appears.
+	Labels *services.LabelStore
+	// Numbers whose traffic the timeline shows together. May be nil.
+	Aliases *services.NumberAliases
+	// The team and on-call rotation that own each phone number.
+	Owners *services.OwnerStore
+
+	// If not nil, show "Create ticket" buttons on message and call pages.
+	TicketLinks *TicketLinks
+	// Runbook links and notes for error codes. May be nil.
+	Runbooks *Runbooks
+	// Tickets people have created for messages and calls.
+	Tickets *services.TicketStore
+
+	// Internal notes on messages, calls and alerts.
+	Notes *services.NoteStore
+
+	// Temporary permissions, applied to every request. If nil, users only
+	// have the permissions in the policy.
+	Grants *GrantStore
+	// Emergency access users can give themselves. If nil, nobody can.
+	BreakGlass *BreakGlass
+	// Numbers whose traffic is hidden from everyone outside one group. If
+	// nil, nothing is hidden.
+	Blocklist *Blocklist
+
+	// Logins that can be listed and revoked. If nil, a login lasts until its
+	// cookie expires.
+	Sessions SessionStore
+
+	// Records grants and other actions someone may need to account for.
+	AuditLog *services.AuditLog
+
+	// Callers leaving <Enqueue> queues, for the queue analytics page.
+	QueueEvents *services.QueueStore
+
+	// The last status of each message, from status callbacks. Messages that
+	// haven't reached a final status are re-fetched every
+	// StatusReconcileInterval.
+	MessageStatuses         *services.StatusStore
+	StatusReconcileInterval time.Duration
+
+	// The maximum age of the data in each local store, keyed by one of
+	// services.RetentionStores. Stores that aren't in the map keep data
+	// until their own limits remove it. Expired data is purged every
+	// RetentionInterval, or only counted if RetentionDryRun is set.
+	Retention         map[string]time.Duration
+	RetentionInterval time.Duration
+	RetentionDryRun   bool
+
+	// Which other sites can make requests from a browser. If nil, none can.
+	CORS *CORS
+
+	// The SameSite attribute of the cookie holding each browser's CSRF token,
+	// one of SameSiteLax, SameSiteStrict or SameSiteNone. Empty means
+	// SameSiteLax.
+	CSRFSameSite string
+
+	// The Content-Security-Policy and other headers sent with every
+	// response. If nil, DefaultSecurityHeaders are sent.
+	SecurityHeaders *SecurityHeaders
+
+	// If not empty, resources are read from the archive in this directory,
+	// instead of from Twilio.
+	ArchiveDir string
+
+	// If true, made up resources are served, instead of resources from
+	// Twilio.
+	Demo bool
+
+	// Messages and calls can also be listed from these providers, keyed by
+	// provider name. Twilio is always available.
+	Providers map[string]ProviderConfig
+
+	// If true, serve /debug/pprof and /debug/vars to users who can profile.
+	EnableProfiling bool
+
+	// Identifies the config the server was loaded from, so you can tell
+	// whether two servers, or a server before and after a reload, have the
+	// same settings.
+	ConfigFingerprint string
+
+	// The most Twilio API requests a single page can make, including pages
+	// fetched into the cache in the background. If zero, there's no limit.
+	MaxTwilioCalls int
+
+	// Limits the requests to Twilio in progress at once. Nil if there's no
+	// limit.
+	TwilioLimiter *services.ConcurrencyLimiter
+	// Sends requests to the Twilio API, within TwilioLimiter. Requests that
+	// don't go through the Twilio client, like fetching recordings, use it.
+	TwilioTransport http.RoundTripper
+
+	// The name, logo and colors shown on every page. If nil, DefaultBranding
+	// is used.
+	Branding *Branding
+
 	// Email address for server errors / "contact me" on error pages.
 	Mailto *mail.Address
 
@@ -128,11 +625,32 @@ type Settings struct {
 	// The authentication scheme.
 	Authenticator Authenticator
 
+	// The groups of users and their permissions. If nil, every user has
+	// DefaultUser's permissions.
+	Policy *Policy
+	// The file Policy was loaded from, if it wasn't in the config file.
+	// Permissions imported from /admin/permissions are written here.
+	PolicyFile string
+
+	// If not nil, serve HTTPS with this config instead of plain HTTP.
+	TLSConfig *tls.Config
+
 	// THIS IS NOT A SECURITY FEATURE AND SHOULD NOT BE RELIED ON FOR IP
 	// WHITELISTING.
 	IPSubnets []*net.IPNet
 }
 
+// configFingerprint returns a short hash of c. It doesn't reveal any of the
+// settings, but changes if any of them do.
+func configFingerprint(c *FileConfig) string {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
 var errWrongLength = errors.New("Secret key has wrong length. Should be a 64-byte hex string")
 
 // getSecretKey produces a valid [32]byte secret key or returns an error. If
@@ -155,11 +673,32 @@ func getSecretKey(hexKey string) (*[32]byte, error) {
 	return secretKey, nil
 }
 
+var storageMu sync.Mutex
+var openStores = make(map[string]store.Store)
+
+// openStorage opens the store for driver and dsn, or returns the one that's
+// already open, so reloading the config doesn't open another connection pool
+// or lose what's in the memory store.
+func openStorage(driver string, dsn string) (store.Store, error) {
+	storageMu.Lock()
+	defer storageMu.Unlock()
+	key := driver + " " + dsn
+	if s, ok := openStores[key]; ok {
+		return s, nil
+	}
+	s, err := store.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	openStores[key] = s
+	return s, nil
+}
+
 // NewSettingsFromConfig creates a new Settings object from the given
 // FileConfig, or an error.
 //
 // Pass a log.Logger to configure how messages are logged. If the Logger is
-// nil, github.com/kevinburke/handlers.Logger will be used.
+// nil, one is built from the log_level, log_levels and log_format settings.
 func NewSettingsFromConfig(c *FileConfig, l log.Logger) (settings *Settings, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -171,11 +710,11 @@ func NewSettingsFromConfig(c *FileConfig, l log.Logger) (settings *Settings, err
 			return
 		}
 	}()
+	var levels *logging.Levels
 	if l == nil {
-		if c.Debug {
-			l = handlers.NewLoggerLevel(log.LvlDebug)
-		} else {
-			l = handlers.Logger
+		l, levels, err = newLogger(c)
+		if err != nil {
+			return nil, err
 		}
 	}
 	if c.Policy != nil && c.PolicyFile != "" {
@@ -195,6 +734,9 @@ func NewSettingsFromConfig(c *FileConfig, l log.Logger) (settings *Settings, err
 	if c.MaxResourceAge == 0 {
 		c.MaxResourceAge = DefaultMaxResourceAge
 	}
+	if err := validateFeatures(c.Features); err != nil {
+		return nil, err
+	}
 	var address *mail.Address
 	if c.EmailAddress != "" {
 		address, err = mail.ParseAddress(c.EmailAddress)
@@ -230,36 +772,100 @@ func NewSettingsFromConfig(c *FileConfig, l log.Logger) (settings *Settings, err
 			return nil, err
 		}
 	}
-	var authenticator Authenticator
-	switch c.AuthScheme {
-	case "", "noop":
-		l.Warn("Disabling basic authentication")
-		authenticator = &NoopAuthenticator{User: DefaultUser}
-	case "basic":
-		if c.User == "" || c.Password == "" {
-			return nil, errors.New("Cannot use basic auth without a username or password, set a basic_auth_user")
-		}
-		ba := NewBasicAuthAuthenticator("logrole")
-		ba.AddUserPassword(c.User, c.Password)
-		authenticator = ba
-	case "google":
-		if c.GoogleClientID == "" || c.GoogleClientSecret == "" {
-			return nil, missingGoogleCredentials
-		}
-		var baseURL string
-		if allowHTTP {
-			baseURL = "http://" + c.PublicHost
-		} else {
-			baseURL = "https://" + c.PublicHost
-		}
-		gauthenticator := NewGoogleAuthenticator(l, c.GoogleClientID, c.GoogleClientSecret, baseURL, c.GoogleAllowedDomains, secretKey)
-		gauthenticator.AllowUnencryptedTraffic = allowHTTP
-		authenticator = gauthenticator
-	default:
-		return nil, fmt.Errorf("Unknown auth scheme: %s", c.AuthScheme)
+	var baseURL string
+	if allowHTTP {
+		baseURL = "http://" + c.PublicHost
+	} else {
+		baseURL = "https://" + c.PublicHost
+	}
+	var storage store.Store
+	if c.StorageDriver != "" {
+		for _, f := range []struct{ name, path string }{
+			{"labels_file", c.LabelsFile},
+			{"owners_file", c.OwnersFile},
+			{"tickets_file", c.TicketsFile},
+			{"notes_file", c.NotesFile},
+			{"grants_file", c.GrantsFile},
+			{"sessions_file", c.SessionsFile},
+			{"audit_log_file", c.AuditLogFile},
+		} {
+			if f.path != "" {
+				return nil, fmt.Errorf("Cannot set both storage_driver and %s", f.name)
+			}
+		}
+		storage, err = openStorage(c.StorageDriver, c.StorageDSN)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't open storage: %v", err)
+		}
+	} else if c.StorageDSN != "" {
+		return nil, errors.New("Set a storage_driver to use storage_dsn")
+	}
+	var sessions SessionStore
+	if storage != nil {
+		sessions = NewDBSessionStore(storage)
+	} else if c.SessionsFile != "" {
+		sessions, err = NewFileSessionStore(c.SessionsFile)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't load sessions_file: %v", err)
+		}
+	}
+	authenticator, err := newAuthenticator(c, &AuthOptions{
+		Logger:                  l,
+		BaseURL:                 baseURL,
+		AllowUnencryptedTraffic: allowHTTP,
+		SecretKey:               secretKey,
+		Sessions:                sessions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.RequireClientCert && c.TLSCertFile == "" {
+		return nil, errors.New("Cannot require client certificates unless Logrole serves TLS, set a tls_cert_file and tls_key_file")
+	}
+	var tlsConfig *tls.Config
+	if c.TLSCertFile != "" || c.TLSKeyFile != "" {
+		tlsConfig, err = NewTLSConfig(c.TLSCertFile, c.TLSKeyFile, c.ClientCAFile, c.RequireClientCert)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't load TLS certificate: %v", err)
+		}
 	}
 	authenticator.SetPolicy(c.Policy)
-	client := twilio.NewClient(c.AccountSid, c.AuthToken, nil)
+	if c.MaxTwilioCallsPerRequest < 0 {
+		return nil, errors.New("max_twilio_calls_per_request can't be negative")
+	}
+	apiKey, err := c.apiKey()
+	if err != nil {
+		return nil, err
+	}
+	if apiKey != nil && c.AuthToken == "" {
+		l.Info("Using an API key with no auth token, webhook signatures can't be checked")
+	}
+	var limiter *services.ConcurrencyLimiter
+	if c.MaxConcurrentTwilioRequests != 0 || len(c.TwilioConcurrencyLimits) > 0 {
+		if c.TwilioQueueTimeout == 0 {
+			c.TwilioQueueTimeout = DefaultTwilioQueueTimeout
+		}
+		limiter, err = services.NewConcurrencyLimiter(c.MaxConcurrentTwilioRequests, c.TwilioConcurrencyLimits, c.TwilioQueueTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+	twilioTransport := services.NewConcurrencyTransport(http.DefaultTransport, limiter)
+	twilioHTTPClient := &http.Client{
+		Timeout:   31 * time.Second,
+		Transport: services.NewRetryTransport(services.NewCallBudgetTransport(twilioTransport)),
+	}
+	client := NewTwilioClient(c.AccountSid, c.AuthToken, apiKey, twilioHTTPClient)
+	if err := validateSubaccounts(c.AccountSid, c.Subaccounts); err != nil {
+		return nil, err
+	}
+	subaccounts, err := c.subaccounts(apiKey, twilioHTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	if c.AccountName == "" {
+		c.AccountName = DefaultAccountName
+	}
 	if c.Timezone == "" {
 		l.Info("No timezone provided, defaulting to UTC")
 	}
@@ -295,27 +901,447 @@ func NewSettingsFromConfig(c *FileConfig, l log.Logger) (settings *Settings, err
 	if c.PageSize == 0 {
 		c.PageSize = DefaultPageSize
 	}
-	if c.PageSize > 1000 {
-		return nil, fmt.Errorf("Maximum allowable page size is 1000, got %d", c.PageSize)
+	if c.PageSize > MaxPageSize {
+		l.Warn("Page size is larger than Twilio allows, using the maximum", "page_size", c.PageSize, "max", MaxPageSize)
+		c.PageSize = MaxPageSize
 	}
 	if c.ShowMediaByDefault == nil {
 		b := true
 		c.ShowMediaByDefault = &b
 	}
 
+	if c.StuckMessageThreshold < 0 || c.StuckMessageInterval < 0 {
+		return nil, errors.New("stuck_message_threshold and stuck_message_interval can't be negative")
+	}
+	if c.StuckMessageInterval == 0 {
+		c.StuckMessageInterval = DefaultStuckMessageInterval
+	}
+	var numberInventory *services.InventoryStore
+	if c.NumberInventory {
+		if c.NumberInventoryInterval < 0 {
+			return nil, errors.New("number_inventory_interval can't be negative")
+		}
+		if c.NumberInventoryInterval == 0 {
+			c.NumberInventoryInterval = DefaultNumberInventoryInterval
+		}
+		numberInventory, err = services.NewInventoryStore(c.NumberInventoryFile)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't load number_inventory_file: %v", err)
+		}
+	}
+	var notifier services.Notifier = &services.NoopNotifier{}
+	if c.NotifyWebhookURL != "" {
+		u, err := url.Parse(c.NotifyWebhookURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return nil, fmt.Errorf("Invalid notify_webhook_url %q, use an http or https URL", c.NotifyWebhookURL)
+		}
+		notifier = &services.WebhookNotifier{URL: c.NotifyWebhookURL}
+	}
+
+	var mediaCache *cache.BlobStore
+	if c.MediaCacheDir != "" {
+		if c.MediaCacheSizeMB < 0 || c.MediaCacheTTL < 0 {
+			return nil, errors.New("media_cache_size_mb and media_cache_ttl can't be negative")
+		}
+		if c.MediaCacheSizeMB == 0 {
+			c.MediaCacheSizeMB = DefaultMediaCacheSizeMB
+		}
+		if c.MediaCacheTTL == 0 {
+			c.MediaCacheTTL = DefaultMediaCacheTTL
+		}
+		keys := []*[32]byte{secretKey}
+		if c.MediaCacheKey != "" {
+			key, err := getSecretKey(c.MediaCacheKey)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid media_cache_key: %v", err)
+			}
+			keys[0] = key
+		} else if c.SecretKey == "" {
+			l.Warn("No secret key or media_cache_key provided, cached media won't be readable after a restart")
+		}
+		for _, hexKey := range c.MediaCacheOldKeys {
+			if hexKey == "" {
+				return nil, errors.New("media_cache_old_keys can't contain an empty key")
+			}
+			key, err := getSecretKey(hexKey)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid key in media_cache_old_keys: %v", err)
+			}
+			keys = append(keys, key)
+		}
+		mediaCache, err = cache.NewEncryptedBlobStore(l, c.MediaCacheDir, c.MediaCacheSizeMB*1024*1024, c.MediaCacheTTL, keys)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't create media cache in %s: %v", c.MediaCacheDir, err)
+		}
+	}
+
+	if c.ExportsDir != "" {
+		if err := os.MkdirAll(c.ExportsDir, 0700); err != nil {
+			return nil, fmt.Errorf("Couldn't create exports_dir %s: %v", c.ExportsDir, err)
+		}
+	}
+
+	if c.MaxRecordingDownloadMB < 0 {
+		return nil, errors.New("max_recording_download_mb can't be negative")
+	}
+	if c.MaxRecordingDownloadMB == 0 {
+		c.MaxRecordingDownloadMB = DefaultMaxRecordingDownloadMB
+	}
+
+	if c.CacheSnapshotInterval < 0 || c.MaxCacheSnapshotMB < 0 {
+		return nil, errors.New("cache_snapshot_interval and max_cache_snapshot_mb can't be negative")
+	}
+	if c.CacheSnapshotInterval == 0 {
+		c.CacheSnapshotInterval = DefaultCacheSnapshotInterval
+	}
+	if c.MaxCacheSnapshotMB == 0 {
+		c.MaxCacheSnapshotMB = DefaultMaxCacheSnapshotMB
+	}
+	if c.CacheGenerations < 0 {
+		return nil, errors.New("cache_generations can't be negative")
+	}
+	if c.PrefetchWorkers < 0 {
+		return nil, errors.New("prefetch_workers can't be negative")
+	}
+	if c.PrefetchWorkers == 0 {
+		c.PrefetchWorkers = DefaultPrefetchWorkers
+	}
+
+	peers := make([]string, 0, len(c.CacheReplicationPeers))
+	for _, peer := range c.CacheReplicationPeers {
+		u, err := url.Parse(peer)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("Invalid cache_replication_peers URL %q, use the http or https URL of another Logrole instance", peer)
+		}
+		peers = append(peers, strings.TrimSuffix(peer, "/"))
+	}
+	if c.CacheReplicationKBPerSecond < 0 {
+		return nil, errors.New("cache_replication_kb_per_second can't be negative")
+	}
+	if c.CacheReplicationKBPerSecond == 0 {
+		c.CacheReplicationKBPerSecond = DefaultCacheReplicationKBPerSecond
+	}
+	var replicationKey *[32]byte
+	replicationNets := nets
+	if len(peers) > 0 {
+		if c.CacheReplicationKey == "" {
+			return nil, errors.New("cache_replication_peers needs a cache_replication_key, generate one with \"openssl rand -hex 32\"")
+		}
+		if strings.EqualFold(c.CacheReplicationKey, c.SecretKey) {
+			return nil, errors.New("cache_replication_key can't be the same as secret_key")
+		}
+		key, err := getSecretKey(c.CacheReplicationKey)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cache_replication_key: %v", err)
+		}
+		replicationKey = key
+		if len(c.CacheReplicationSubnets) > 0 {
+			replicationNets = make([]*net.IPNet, len(c.CacheReplicationSubnets))
+			for i, ipStr := range c.CacheReplicationSubnets {
+				_, n, err := net.ParseCIDR(ipStr)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid cache_replication_subnets: %v", err)
+				}
+				replicationNets[i] = n
+			}
+		}
+		if len(replicationNets) == 0 {
+			return nil, errors.New("cache_replication_peers needs cache_replication_subnets or ip_subnets, so only your servers can send cache entries")
+		}
+	}
+
+	var mediaScanner services.MediaScanner
+	if c.MediaScanURL != "" {
+		u, err := url.Parse(c.MediaScanURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return nil, fmt.Errorf("Invalid media_scan_url %q, use an http or https URL", c.MediaScanURL)
+		}
+		mediaScanner = &services.WebhookScanner{
+			URL:    c.MediaScanURL,
+			Client: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	var textExtractor services.TextExtractor
+	var attachmentText *services.AttachmentTextStore
+	if c.AttachmentTextURL != "" {
+		u, err := url.Parse(c.AttachmentTextURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return nil, fmt.Errorf("Invalid attachment_text_url %q, use an http or https URL", c.AttachmentTextURL)
+		}
+		textExtractor = &services.WebhookExtractor{
+			URL:    c.AttachmentTextURL,
+			Client: &http.Client{Timeout: 30 * time.Second},
+		}
+		attachmentText, err = services.NewAttachmentTextStore(c.AttachmentTextFile)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't load attachment_text_file: %v", err)
+		}
+	} else if c.AttachmentTextFile != "" {
+		l.Info("No attachment_text_url provided, ignoring attachment_text_file")
+	}
+
+	var translator services.Translator
+	if c.TranslationURL != "" {
+		u, err := url.Parse(c.TranslationURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return nil, fmt.Errorf("Invalid translation_url %q, use an http or https URL", c.TranslationURL)
+		}
+		translator = &services.WebhookTranslator{
+			URL:    c.TranslationURL,
+			Client: &http.Client{Timeout: 10 * time.Second},
+		}
+		if c.TranslationLanguage == "" {
+			c.TranslationLanguage = DefaultTranslationLanguage
+		}
+	}
+
+	var bodyHashes *services.BodyHashStore
+	if c.BodyHashing {
+		if c.DuplicateBodyRecipients < 0 || c.DuplicateBodyWindow < 0 {
+			return nil, errors.New("duplicate_body_recipients and duplicate_body_window can't be negative")
+		}
+		bodyHashes = services.NewBodyHashStore(c.DuplicateBodyRecipients, c.DuplicateBodyWindow)
+	}
+
+	alertRedactor, err := NewRedactor(c.AlertRedactions)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases, err := services.NewNumberAliases(c.NumberAliases)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid number_aliases: %v", err)
+	}
+
+	var labels *services.LabelStore
+	if storage != nil {
+		labels, err = services.NewLabelStoreWith(storage)
+	} else {
+		if c.LabelsFile == "" {
+			l.Info("No labels_file provided, phone number labels won't persist across restarts")
+		}
+		labels, err = services.NewLabelStore(c.LabelsFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var owners *services.OwnerStore
+	if storage != nil {
+		owners, err = services.NewOwnerStoreWith(storage)
+	} else {
+		if c.OwnersFile == "" {
+			l.Info("No owners_file provided, phone number owners won't persist across restarts")
+		}
+		owners, err = services.NewOwnerStore(c.OwnersFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ticketLinks *TicketLinks
+	if len(c.TicketLinks) > 0 {
+		ticketLinks, err = NewTicketLinks(c.TicketLinks)
+		if err != nil {
+			return nil, err
+		}
+	}
+	runbooks, err := NewRunbooks(c.Runbooks)
+	if err != nil {
+		return nil, err
+	}
+	var tickets *services.TicketStore
+	var notes *services.NoteStore
+	if storage != nil {
+		tickets, err = services.NewTicketStoreWith(storage)
+	} else {
+		tickets, err = services.NewTicketStore(c.TicketsFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if storage != nil {
+		notes, err = services.NewNoteStoreWith(storage)
+	} else {
+		notes, err = services.NewNoteStore(c.NotesFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var grants *GrantStore
+	if storage != nil {
+		grants, err = NewGrantStoreWith(storage, c.Grants)
+	} else {
+		grants, err = NewGrantStore(c.GrantsFile, c.Grants)
+	}
+	if err != nil {
+		return nil, err
+	}
+	breakGlass, err := NewBreakGlass(c.BreakGlass)
+	if err != nil {
+		return nil, err
+	}
+	var blocklist *Blocklist
+	if storage != nil {
+		blocklist, err = NewBlocklistWith(c.Blocklist, storage)
+	} else {
+		blocklist, err = NewBlocklist(c.Blocklist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var auditLog *services.AuditLog
+	if storage != nil {
+		auditLog = services.NewAuditLogWith(l, storage)
+	} else {
+		auditLog, err = services.NewAuditLog(l, c.AuditLogFile)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't open audit_log_file: %v", err)
+		}
+	}
+	queueEvents, err := services.NewQueueStore(c.QueueEventsFile)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't load queue_events_file: %v", err)
+	}
+	messageStatuses, err := services.NewStatusStore(c.MessageStatusesFile)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't load message_statuses_file: %v", err)
+	}
+	if c.StatusReconcileInterval < 0 {
+		return nil, errors.New("status_reconcile_interval can't be negative")
+	}
+	if c.StatusReconcileInterval == 0 {
+		c.StatusReconcileInterval = DefaultStatusReconcileInterval
+	}
+
+	for name, age := range c.Retention {
+		if !services.IsRetentionStore(name) {
+			return nil, fmt.Errorf("Unknown store %q in retention, the stores are %s", name, strings.Join(services.RetentionStores, ", "))
+		}
+		if age <= 0 {
+			return nil, fmt.Errorf("retention for %s must be positive", name)
+		}
+	}
+	if c.RetentionInterval < 0 {
+		return nil, errors.New("retention_interval can't be negative")
+	}
+	if c.RetentionInterval == 0 {
+		c.RetentionInterval = DefaultRetentionInterval
+	}
+
+	if err := validateProviders(c.Providers); err != nil {
+		return nil, err
+	}
+
+	if c.Demo && c.ArchiveDir != "" {
+		return nil, errors.New("Can't serve the demo and an archive_dir at once")
+	}
+	if c.ArchiveDir != "" {
+		fi, err := os.Stat(c.ArchiveDir)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't open archive_dir: %v", err)
+		}
+		if !fi.IsDir() {
+			return nil, fmt.Errorf("archive_dir %s is not a directory", c.ArchiveDir)
+		}
+	}
+
+	cors, err := NewCORS(c.CORSAllowedOrigins, c.CORSAllowedHeaders, c.CORSMaxAge)
+	if err != nil {
+		return nil, err
+	}
+	sameSite, err := NewSameSite(c.CSRFSameSite, allowHTTP)
+	if err != nil {
+		return nil, err
+	}
+	securityHeaders, err := NewSecurityHeaders(c.CSPDirectives, c.CSPReportOnly, c.ReferrerPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	branding, err := NewBranding(c.ProductName, c.LogoURL, c.PrimaryColor, c.FooterLinks)
+	if err != nil {
+		return nil, err
+	}
+
 	settings = &Settings{
 		Logger:                  l,
+		LogLevels:               levels,
 		AllowUnencryptedTraffic: allowHTTP,
 		Client:                  client,
+		AccountName:             c.AccountName,
+		Subaccounts:             subaccounts,
 		LocationFinder:          locationFinder,
 		PublicHost:              c.PublicHost,
 		PageSize:                c.PageSize,
 		SecretKey:               secretKey,
 		MaxResourceAge:          c.MaxResourceAge,
 		ShowMediaByDefault:      *c.ShowMediaByDefault,
+		ReadOnly:                c.ReadOnly,
+		Features:                c.Features,
+		StuckMessageThreshold:   c.StuckMessageThreshold,
+		StuckMessageInterval:    c.StuckMessageInterval,
+		NumberInventory:         numberInventory,
+		NumberInventoryInterval: c.NumberInventoryInterval,
+		Notifier:                notifier,
+		MediaCache:              mediaCache,
+		ExportsDir:              c.ExportsDir,
+		MediaScanner:            mediaScanner,
+		TextExtractor:           textExtractor,
+		AttachmentText:          attachmentText,
+		Translator:              translator,
+		TranslationLanguage:     c.TranslationLanguage,
+		BodyHashes:              bodyHashes,
+		MaxRecordingDownload:    c.MaxRecordingDownloadMB * 1024 * 1024,
+		AlertRedactor:           alertRedactor,
+		CacheSnapshotFile:       c.CacheSnapshotFile,
+		CacheSnapshotInterval:   c.CacheSnapshotInterval,
+		MaxCacheSnapshot:        c.MaxCacheSnapshotMB * 1024 * 1024,
+		ReplicationPeers:        peers,
+		ReplicationBandwidth:    c.CacheReplicationKBPerSecond * 1024,
+		ReplicationKey:          replicationKey,
+		ReplicationSubnets:      replicationNets,
+		CacheGenerations:        c.CacheGenerations,
+		DisablePrefetch:         c.DisablePrefetch,
+		PrefetchWorkers:         c.PrefetchWorkers,
+		Storage:                 storage,
+		Labels:                  labels,
+		Aliases:                 aliases,
+		Owners:                  owners,
+		TicketLinks:             ticketLinks,
+		Runbooks:                runbooks,
+		Tickets:                 tickets,
+		Notes:                   notes,
+		Grants:                  grants,
+		BreakGlass:              breakGlass,
+		Blocklist:               blocklist,
+		Sessions:                sessions,
+		AuditLog:                auditLog,
+		QueueEvents:             queueEvents,
+		MessageStatuses:         messageStatuses,
+		StatusReconcileInterval: c.StatusReconcileInterval,
+		Retention:               c.Retention,
+		RetentionInterval:       c.RetentionInterval,
+		RetentionDryRun:         c.RetentionDryRun,
+		CORS:                    cors,
+		CSRFSameSite:            sameSite,
+		SecurityHeaders:         securityHeaders,
+		ArchiveDir:              c.ArchiveDir,
+		Demo:                    c.Demo,
+		Providers:               c.Providers,
+		MaxTwilioCalls:          c.MaxTwilioCallsPerRequest,
+		TwilioLimiter:           limiter,
+		TwilioTransport:         twilioTransport,
+		EnableProfiling:         c.EnableProfiling,
+		ConfigFingerprint:       configFingerprint(c),
+		Branding:                branding,
 		Mailto:                  address,
 		Reporter:                reporter,
 		Authenticator:           authenticator,
+		Policy:                  c.Policy,
+		PolicyFile:              c.PolicyFile,
+		TLSConfig:               tlsConfig,
 		IPSubnets:               nets,
 	}
 	return
@@ -6,6 +6,9 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/services"
 )
 
 func TestGetSecretKey(t *testing.T) {
@@ -192,3 +195,150 @@ func TestIPAddressParse(t *testing.T) {
 		t.Errorf("bad mask: %s", n.Mask.String())
 	}
 }
+
+func TestCacheReplication(t *testing.T) {
+	t.Parallel()
+	c := &FileConfig{
+		AccountSid:            "AC123",
+		AuthToken:             "123",
+		SecretKey:             "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		CacheReplicationPeers: []string{"https://standby.example.com"},
+	}
+	if _, err := NewSettingsFromConfig(c, NullLogger); err == nil || !strings.Contains(err.Error(), "cache_replication_key") {
+		t.Errorf("expected an error for replication without a key, got %v", err)
+	}
+	c.CacheReplicationKey = strings.ToUpper(c.SecretKey)
+	if _, err := NewSettingsFromConfig(c, NullLogger); err == nil || !strings.Contains(err.Error(), "secret_key") {
+		t.Errorf("expected an error for reusing the secret key, got %v", err)
+	}
+	c.CacheReplicationKey = "6368616e676520746869732070617373776f726420746f206120736563726574"
+	if _, err := NewSettingsFromConfig(c, NullLogger); err == nil || !strings.Contains(err.Error(), "cache_replication_subnets") {
+		t.Errorf("expected an error for replication without any subnets, got %v", err)
+	}
+	c.IPSubnets = []string{"10.0.0.0/8"}
+	settings, err := NewSettingsFromConfig(c, NullLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.ReplicationKey == nil || hex.EncodeToString(settings.ReplicationKey[:]) != c.CacheReplicationKey {
+		t.Errorf("expected the replication key to be set, got %v", settings.ReplicationKey)
+	}
+	if len(settings.ReplicationSubnets) != 1 || settings.ReplicationSubnets[0].String() != "10.0.0.0/8" {
+		t.Errorf("expected replication subnets to default to ip_subnets, got %v", settings.ReplicationSubnets)
+	}
+	c.CacheReplicationSubnets = []string{"192.168.1.0/24"}
+	settings, err = NewSettingsFromConfig(c, NullLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(settings.ReplicationSubnets) != 1 || settings.ReplicationSubnets[0].String() != "192.168.1.0/24" {
+		t.Errorf("expected the replication subnets, got %v", settings.ReplicationSubnets)
+	}
+}
+
+func TestNotifyWebhookURL(t *testing.T) {
+	t.Parallel()
+	c := &FileConfig{
+		AccountSid:       "AC123",
+		AuthToken:        "123",
+		NotifyWebhookURL: "ftp://example.com/hook",
+	}
+	if _, err := NewSettingsFromConfig(c, NullLogger); err == nil {
+		t.Error("expected error for a non-HTTP notify_webhook_url")
+	}
+	c.NotifyWebhookURL = "https://example.com/hook"
+	settings, err := NewSettingsFromConfig(c, NullLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := settings.Notifier.(*services.WebhookNotifier); !ok {
+		t.Errorf("expected a WebhookNotifier, got %T", settings.Notifier)
+	}
+	if settings.StuckMessageInterval != DefaultStuckMessageInterval {
+		t.Errorf("expected default stuck message interval, got %v", settings.StuckMessageInterval)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	t.Parallel()
+	c := &FileConfig{
+		AccountSid: "AC123",
+		AuthToken:  "123",
+		Retention:  map[string]time.Duration{"audit_logs": time.Hour},
+	}
+	if _, err := NewSettingsFromConfig(c, NullLogger); err == nil {
+		t.Error("expected error for an unknown retention store")
+	}
+	c.Retention = map[string]time.Duration{services.RetentionAuditLog: -time.Hour}
+	if _, err := NewSettingsFromConfig(c, NullLogger); err == nil {
+		t.Error("expected error for a negative retention")
+	}
+	c.Retention = map[string]time.Duration{services.RetentionAuditLog: 24 * time.Hour}
+	settings, err := NewSettingsFromConfig(c, NullLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Retention[services.RetentionAuditLog] != 24*time.Hour {
+		t.Errorf("expected audit log retention of 24h, got %v", settings.Retention)
+	}
+	if settings.RetentionInterval != DefaultRetentionInterval {
+		t.Errorf("expected default retention interval, got %v", settings.RetentionInterval)
+	}
+}
+
+func TestProviders(t *testing.T) {
+	t.Parallel()
+	c := &FileConfig{
+		AccountSid: "AC123",
+		AuthToken:  "123",
+		Providers:  map[string]ProviderConfig{"telnyx": {APIKey: "KEY123"}},
+	}
+	settings, err := NewSettingsFromConfig(c, NullLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Providers["telnyx"].APIKey != "KEY123" {
+		t.Errorf("expected the telnyx api key, got %v", settings.Providers)
+	}
+	c.Providers = map[string]ProviderConfig{"bandwidth": {APIKey: "KEY123"}}
+	if _, err := NewSettingsFromConfig(c, NullLogger); err == nil || !strings.Contains(err.Error(), "Unknown provider") {
+		t.Errorf("expected an unknown provider error, got %v", err)
+	}
+	c.Providers = map[string]ProviderConfig{"telnyx": {}}
+	if _, err := NewSettingsFromConfig(c, NullLogger); err == nil {
+		t.Error("expected an error for a provider without an api_key")
+	}
+}
+
+func TestStorage(t *testing.T) {
+	t.Parallel()
+	c := &FileConfig{
+		AccountSid:    "AC123",
+		AuthToken:     "123",
+		StorageDriver: "memory",
+		NotesFile:     "/tmp/notes.json",
+	}
+	if _, err := NewSettingsFromConfig(c, NullLogger); err == nil || !strings.Contains(err.Error(), "notes_file") {
+		t.Errorf("expected an error for setting both storage_driver and notes_file, got %v", err)
+	}
+	c.NotesFile = ""
+	settings, err := NewSettingsFromConfig(c, NullLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := settings.Notes.Add("SM123", "Escalated to carrier", "test"); err != nil {
+		t.Fatal(err)
+	}
+	// Reloading the config keeps what's in the store.
+	settings, err = NewSettingsFromConfig(c, NullLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notes := settings.Notes.Get("SM123"); len(notes) != 1 {
+		t.Errorf("expected the note to survive a reload, got %v", notes)
+	}
+	c.StorageDriver = "mysql"
+	if _, err := NewSettingsFromConfig(c, NullLogger); err == nil {
+		t.Error("expected an error for an unknown storage driver")
+	}
+}
@@ -0,0 +1,62 @@
+package config
+
+import "time"
+
+// permissionBits gives each grantable permission a bit in a
+// PermissionSnapshot, in alphabetical order.
+var permissionBits = make(map[string]uint64)
+
+func init() {
+	names := GrantablePermissions()
+	if len(names) > 64 {
+		panic("too many permissions to fit in a PermissionSnapshot")
+	}
+	for i, name := range names {
+		permissionBits[name] = 1 << uint(i)
+	}
+}
+
+// A PermissionSnapshot is a user's permissions, and the oldest resource they
+// can view, worked out once. Building a page checks the same permissions for
+// every resource on it; with a snapshot each check is a bit test, instead of
+// a walk through the permission's dependencies.
+//
+// A snapshot doesn't change if the user's grants do, so make a new one for
+// each request.
+type PermissionSnapshot struct {
+	bits uint64
+	// Resources created before cutoff are too old to view. Zero if every
+	// resource can be viewed.
+	cutoff time.Time
+}
+
+// Snapshot returns u's permissions at now. globalMaxAge is the site-wide
+// MaxResourceAge; the user's own setting overrides it, like in
+// CanViewResource.
+func (u *User) Snapshot(globalMaxAge time.Duration, now time.Time) *PermissionSnapshot {
+	s := new(PermissionSnapshot)
+	for name, bit := range permissionBits {
+		if u.HasPermission(name) {
+			s.bits |= bit
+		}
+	}
+	if maxAge := u.MaxResourceAge(globalMaxAge); maxAge != 0 {
+		s.cutoff = now.Add(-maxAge)
+	}
+	return s
+}
+
+// Has reports whether the snapshot has the named permission, including its
+// dependencies, like User.HasPermission. A nil snapshot has no permissions.
+func (s *PermissionSnapshot) Has(name string) bool {
+	if s == nil {
+		return false
+	}
+	return s.bits&permissionBits[name] != 0
+}
+
+// CanViewResource reports whether a resource created at the given time is
+// new enough to view.
+func (s *PermissionSnapshot) CanViewResource(created time.Time) bool {
+	return s.cutoff.IsZero() || created.After(s.cutoff)
+}
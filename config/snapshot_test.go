@@ -0,0 +1,52 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapshotMatchesHasPermission(t *testing.T) {
+	t.Parallel()
+	all := AllUserSettings()
+	noPrices := AllUserSettings()
+	noPrices.CanViewPrices = false
+	noMessages := AllUserSettings()
+	noMessages.CanViewMessages = false
+	for _, us := range []*UserSettings{all, noPrices, noMessages, new(UserSettings)} {
+		u := NewUser(us)
+		s := u.Snapshot(0, time.Now())
+		for _, name := range GrantablePermissions() {
+			if got, want := s.Has(name), u.HasPermission(name); got != want {
+				t.Errorf("%s: snapshot has %t, user has %t", name, got, want)
+			}
+		}
+	}
+	if (*PermissionSnapshot)(nil).Has("can_view_messages") {
+		t.Error("expected a nil snapshot to have no permissions")
+	}
+	if NewUser(all).Snapshot(0, time.Now()).Has("can_fly") {
+		t.Error("expected an unknown permission to be denied")
+	}
+}
+
+func TestSnapshotCanViewResource(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2016, 11, 1, 12, 0, 0, 0, time.UTC)
+	us := AllUserSettings()
+	us.MaxResourceAge = 0
+	u := NewUser(us)
+	s := u.Snapshot(time.Hour, now)
+	if !s.CanViewResource(now.Add(-30 * time.Minute)) {
+		t.Error("expected a new resource to be viewable")
+	}
+	if s.CanViewResource(now.Add(-2 * time.Hour)) {
+		t.Error("expected an old resource to be hidden")
+	}
+	if s := u.Snapshot(0, now); !s.CanViewResource(now.Add(-24 * 365 * time.Hour)) {
+		t.Error("expected every resource to be viewable with no max age")
+	}
+	us.MaxResourceAge = 3 * time.Hour
+	if s := NewUser(us).Snapshot(time.Hour, now); !s.CanViewResource(now.Add(-2 * time.Hour)) {
+		t.Error("expected the user's max age to override the global one")
+	}
+}
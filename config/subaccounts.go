@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// DefaultAccountName is what lists call the main account, alongside its
+// subaccounts, if twilio_account_name isn't set.
+const DefaultAccountName = "Main account"
+
+// A SubaccountConfig is a subaccount of twilio_account_sid whose messages,
+// calls and alerts Logrole can list.
+type SubaccountConfig struct {
+	Sid string `yaml:"sid"`
+	// Defaults to the sid.
+	Name string `yaml:"name,omitempty"`
+}
+
+// A Subaccount is a configured subaccount, with a client for its resources.
+type Subaccount struct {
+	Sid  string
+	Name string
+	// True if the subaccount has its own key in twilio_api_keys. Twilio lists
+	// the alerts of the account that made the request, so a subaccount's
+	// alerts can only be listed with its own key.
+	OwnKey bool
+	Client *twilio.Client
+}
+
+func validateSubaccounts(accountSid string, subaccounts []SubaccountConfig) error {
+	seen := map[string]bool{accountSid: true}
+	for _, s := range subaccounts {
+		if !strings.HasPrefix(s.Sid, "AC") || len(s.Sid) != 34 {
+			return fmt.Errorf("twilio_subaccounts: %q isn't an account sid", s.Sid)
+		}
+		if seen[s.Sid] {
+			return fmt.Errorf("twilio_subaccounts: %s is listed twice, or is twilio_account_sid", s.Sid)
+		}
+		seen[s.Sid] = true
+	}
+	return nil
+}
+
+// subaccounts returns a Subaccount for each of c.Subaccounts. A subaccount
+// with a key in twilio_api_keys uses it. The others use the main account's
+// auth token or key, which Twilio accepts for a subaccount's messages and
+// calls.
+func (c *FileConfig) subaccounts(key *APIKey, httpClient *http.Client) ([]*Subaccount, error) {
+	subaccounts := make([]*Subaccount, len(c.Subaccounts))
+	for i, sc := range c.Subaccounts {
+		s := &Subaccount{Sid: sc.Sid, Name: sc.Name}
+		if s.Name == "" {
+			s.Name = sc.Sid
+		}
+		if k, ok := c.APIKeys[sc.Sid]; ok {
+			if err := k.Validate(); err != nil {
+				return nil, fmt.Errorf("twilio_api_keys: %v", err)
+			}
+			s.OwnKey = true
+			s.Client = NewTwilioClient(sc.Sid, c.AuthToken, &k, httpClient)
+		} else {
+			s.Client = NewTwilioClient(sc.Sid, c.AuthToken, key, httpClient)
+			if key == nil {
+				// Subaccount URLs, authenticated as the main account.
+				s.Client.Client.ID = c.AccountSid
+				s.Client.Monitor.Client.ID = c.AccountSid
+			}
+		}
+		subaccounts[i] = s
+	}
+	return subaccounts, nil
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestValidateSubaccounts(t *testing.T) {
+	t.Parallel()
+	main := "AC" + strings.Repeat("1", 32)
+	sub := "AC" + strings.Repeat("2", 32)
+	if err := validateSubaccounts(main, []SubaccountConfig{{Sid: sub, Name: "Support"}}); err != nil {
+		t.Fatal(err)
+	}
+	tests := [][]SubaccountConfig{
+		{{Sid: "SK" + strings.Repeat("2", 32)}},
+		{{Sid: "AC123"}},
+		{{Sid: main}},
+		{{Sid: sub}, {Sid: sub}},
+	}
+	for _, subaccounts := range tests {
+		if err := validateSubaccounts(main, subaccounts); err == nil {
+			t.Errorf("expected %v to be invalid", subaccounts)
+		}
+	}
+}
+
+func TestSubaccountClients(t *testing.T) {
+	t.Parallel()
+	var user, path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ = r.BasicAuth()
+		path = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"sid": "SM123", "date_created": "Tue, 18 Oct 2016 17:00:00 +0000"}`))
+	}))
+	defer ts.Close()
+	c := &FileConfig{
+		AccountSid:  "AC123",
+		AuthToken:   "token",
+		APIKeys:     map[string]APIKey{"AC789": {Sid: "SK789", Secret: "secret789"}},
+		Subaccounts: []SubaccountConfig{{Sid: "AC456", Name: "Support"}, {Sid: "AC789"}},
+	}
+	subaccounts, err := c.subaccounts(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subaccounts) != 2 || subaccounts[1].Name != "AC789" {
+		t.Fatalf("expected a subaccount without a name to be named after its sid, got %v", subaccounts)
+	}
+	if subaccounts[0].OwnKey || !subaccounts[1].OwnKey {
+		t.Errorf("expected only the subaccount with a key to have its own key")
+	}
+	client := subaccounts[0].Client
+	client.Base = ts.URL
+	if _, err := client.Messages.Get(context.Background(), "SM123"); err != nil {
+		t.Fatal(err)
+	}
+	if user != "AC123" || !strings.Contains(path, "/Accounts/AC456/") {
+		t.Errorf("expected a request for the subaccount as the main account, got %q for %s", user, path)
+	}
+	client = subaccounts[1].Client
+	client.Base = ts.URL
+	if _, err := client.Messages.Get(context.Background(), "SM123"); err != nil {
+		t.Fatal(err)
+	}
+	if user != "SK789" || !strings.Contains(path, "/Accounts/AC789/") {
+		t.Errorf("expected a request with the subaccount's key, got %q for %s", user, path)
+	}
+}
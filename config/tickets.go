@@ -0,0 +1,120 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"text/template"
+)
+
+// A TicketLink is a "Create ticket" button shown on message and call pages.
+// URL is a text/template that's filled in with a TicketFields value, for
+// example
+//
+//     https://example.zendesk.com/hc/requests/new?subject=Twilio+{{ .Sid }}
+type TicketLink struct {
+	Text string `yaml:"text"`
+	URL  string `yaml:"url"`
+}
+
+// TicketFields describe the resource a ticket is being created for. Every
+// field is URL-encoded before it's substituted into a TicketLink URL. Fields
+// the user isn't allowed to see are empty.
+type TicketFields struct {
+	Sid string
+	// "message" or "call"
+	Resource    string
+	DateCreated string
+	Status      string
+	// Phone numbers, with all but the last four digits masked.
+	From string
+	To   string
+	// A link to the resource in Logrole.
+	Link string
+}
+
+// A RenderedTicketLink is a TicketLink filled in for a single resource.
+type RenderedTicketLink struct {
+	Text string
+	URL  string
+}
+
+// TicketLinks renders the configured TicketLinks for a resource.
+type TicketLinks struct {
+	links []*ticketLinkTpl
+}
+
+type ticketLinkTpl struct {
+	text string
+	tpl  *template.Template
+}
+
+var sampleTicketFields = &TicketFields{
+	Sid:         "SM123",
+	Resource:    "message",
+	DateCreated: "2016-10-16T12:00:00Z",
+	Status:      "delivered",
+	From:        "+*******1234",
+	To:          "+*******5678",
+	Link:        "https://logrole.example.com/messages/SM123",
+}
+
+// NewTicketLinks parses and validates the URL templates in links.
+func NewTicketLinks(links []TicketLink) (*TicketLinks, error) {
+	t := &TicketLinks{links: make([]*ticketLinkTpl, len(links))}
+	for i, link := range links {
+		if link.Text == "" {
+			return nil, fmt.Errorf("Ticket link to %s has no text", link.URL)
+		}
+		tpl, err := template.New(link.Text).Option("missingkey=error").Parse(link.URL)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't parse ticket link %q: %v", link.Text, err)
+		}
+		t.links[i] = &ticketLinkTpl{text: link.Text, tpl: tpl}
+		rendered, err := t.links[i].render(sampleTicketFields)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't render ticket link %q: %v", link.Text, err)
+		}
+		u, err := url.Parse(rendered)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("Invalid ticket link %q, use an http or https URL", link.Text)
+		}
+	}
+	return t, nil
+}
+
+func (l *ticketLinkTpl) render(f *TicketFields) (string, error) {
+	escaped := &TicketFields{
+		Sid:         url.QueryEscape(f.Sid),
+		Resource:    url.QueryEscape(f.Resource),
+		DateCreated: url.QueryEscape(f.DateCreated),
+		Status:      url.QueryEscape(f.Status),
+		From:        url.QueryEscape(f.From),
+		To:          url.QueryEscape(f.To),
+		Link:        url.QueryEscape(f.Link),
+	}
+	buf := new(bytes.Buffer)
+	if err := l.tpl.Execute(buf, escaped); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Render returns the ticket links for the resource described by f. A nil
+// TicketLinks has no links.
+func (t *TicketLinks) Render(f *TicketFields) []*RenderedTicketLink {
+	if t == nil {
+		return nil
+	}
+	rendered := make([]*RenderedTicketLink, 0, len(t.links))
+	for _, link := range t.links {
+		u, err := link.render(f)
+		if err != nil {
+			// We checked the template in NewTicketLinks, so this shouldn't
+			// happen.
+			continue
+		}
+		rendered = append(rendered, &RenderedTicketLink{Text: link.text, URL: u})
+	}
+	return rendered
+}
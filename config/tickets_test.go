@@ -0,0 +1,55 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTicketLinks(t *testing.T) {
+	t.Parallel()
+	links, err := NewTicketLinks([]TicketLink{
+		{Text: "Zendesk", URL: "https://example.zendesk.com/new?subject={{ .Sid }}&description=From+{{ .From }}%0A{{ .Link }}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered := links.Render(&TicketFields{
+		Sid:  "SM123",
+		From: "+*******1234",
+		Link: "https://logrole.example.com/messages/SM123",
+	})
+	if len(rendered) != 1 {
+		t.Fatalf("expected 1 link, got %d", len(rendered))
+	}
+	want := "https://example.zendesk.com/new?subject=SM123&description=From+%2B%2A%2A%2A%2A%2A%2A%2A1234%0Ahttps%3A%2F%2Flogrole.example.com%2Fmessages%2FSM123"
+	if rendered[0].URL != want {
+		t.Errorf("got URL %s, want %s", rendered[0].URL, want)
+	}
+	if rendered[0].Text != "Zendesk" {
+		t.Errorf("expected Text to be Zendesk, got %q", rendered[0].Text)
+	}
+}
+
+var invalidTicketLinks = []struct {
+	link TicketLink
+	err  string
+}{
+	{TicketLink{URL: "https://example.com"}, "has no text"},
+	{TicketLink{Text: "Bad", URL: "https://example.com/{{ .Sid"}, "Couldn't parse"},
+	{TicketLink{Text: "Bad", URL: "https://example.com/{{ .Unknown }}"}, "Couldn't render"},
+	{TicketLink{Text: "Bad", URL: "javascript:alert({{ .Sid }})"}, "use an http or https URL"},
+}
+
+func TestInvalidTicketLinks(t *testing.T) {
+	t.Parallel()
+	for _, tt := range invalidTicketLinks {
+		_, err := NewTicketLinks([]TicketLink{tt.link})
+		if err == nil {
+			t.Errorf("NewTicketLinks(%v): expected error, got nil", tt.link)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("NewTicketLinks(%v): expected error to contain %q, got %v", tt.link, tt.err, err)
+		}
+	}
+}
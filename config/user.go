@@ -12,30 +12,70 @@ import (
 var DefaultUser = NewUser(AllUserSettings())
 
 type User struct {
+	// The name the user authenticated with - a Basic Auth username or a Google
+	// email address. Empty for users that aren't defined in a policy.
+	id                    string
 	canViewNumMedia       bool
 	canViewMessages       bool
 	canViewMessageFrom    bool
 	canViewMessageTo      bool
 	canViewMessageBody    bool
+	canViewPrices         bool
 	canViewMessagePrice   bool
 	canViewMedia          bool
+	canViewFlaggedMedia   bool
 	canViewCalls          bool
 	canViewCallFrom       bool
 	canViewCallTo         bool
 	canViewCallPrice      bool
+	canViewCallDigits     bool
 	canViewNumRecordings  bool
 	canPlayRecordings     bool
+	canDownloadRecordings bool
 	canViewRecordingPrice bool
 	canViewConferences    bool
 	canViewAlerts         bool
 	canViewCallbackURLs   bool
+	canViewAlertPayloads  bool
+	canManageLabels       bool
+	canReloadConfig       bool
+	canGrantPermissions   bool
+	canManageSessions     bool
+	canDebugPermissions   bool
+	canProfile            bool
+	canResendMessages     bool
+	canCancelMessages     bool
+	canTranslateMessages  bool
+	canViewNotes          bool
+	// Set for a single request when a user who can debug permissions asks to
+	// see why fields are hidden.
+	debugPermissions bool
+	// Active grants that gave this user extra permissions.
+	grants []*Grant
+	// The policy group the user is in, if any.
+	group string
+	// Set when an admin is viewing the site as this user; the admin's own
+	// User.
+	viewedBy *User
+	// Features turned on or off for this user. Starts with the overrides for
+	// the user's group; WithFeatures adds the site-wide settings.
+	features Features
+	// The business hours of the user's group, in its home timezone. nil if
+	// the group doesn't have any.
+	hours *BusinessHours
 	// The maximum viewable age this viewer can view resources. If nonzero,
 	// this overrides any global setting.
 	maxResourceAge time.Duration
+	// Region codes of the countries whose traffic is hidden from this user.
+	excludedCountries map[string]bool
+	// The most rows this user can see on a list page. Zero means as many as
+	// Twilio returns.
+	maxPageSize uint
 }
 
 // UserSettings are used to define which permissions a User has. When parsing
-// from YAML, any omitted fields are set to "true".
+// from YAML, any omitted fields are set to "true", except admin permissions,
+// which are "false" unless a group sets them.
 type UserSettings struct {
 	// Can the user see whether a message had MMS attached?
 	CanViewNumMedia bool `yaml:"can_view_num_media"`
@@ -49,7 +89,14 @@ type UserSettings struct {
 	CanViewMessageBody bool `yaml:"can_view_message_body"`
 	// Can the user view the photos in a MMS message?
 	CanViewMedia bool `yaml:"can_view_media"`
-
+	// Can the user click through the warning on media flagged by the media
+	// scanner?
+	CanViewFlaggedMedia bool `yaml:"can_view_flagged_media"`
+
+	// Can the user see what anything cost? If false, message, call and
+	// recording prices are hidden everywhere, including exports, whatever the
+	// settings below are.
+	CanViewPrices bool `yaml:"can_view_prices"`
 	// Can the user see how much a message cost to send?
 	CanViewMessagePrice bool `yaml:"can_view_message_price"`
 
@@ -60,10 +107,17 @@ type UserSettings struct {
 	// Can the user view the call recipient?
 	CanViewCallTo    bool `yaml:"can_view_call_to"`
 	CanViewCallPrice bool `yaml:"can_view_call_price"`
+	// Can the user reveal the digits callers pressed on the keypad during a
+	// call? These can be PINs or card numbers, so they're masked until the
+	// user clicks them.
+	CanViewCallDigits bool `yaml:"can_view_call_digits"`
 	// Can the user see whether a call has recordings attached?
 	CanViewNumRecordings bool `yaml:"can_view_num_recordings"`
 	// Can the user listen to recordings?
-	CanPlayRecordings     bool `yaml:"can_play_recordings"`
+	CanPlayRecordings bool `yaml:"can_play_recordings"`
+	// Can the user download every recording for a call, conference or date
+	// range as a zip file?
+	CanDownloadRecordings bool `yaml:"can_download_recordings"`
 	CanViewRecordingPrice bool `yaml:"can_view_recording_price"`
 	// Can the user view metadata about a conference (sid, date created,
 	// region, etc)?
@@ -74,28 +128,74 @@ type UserSettings struct {
 	// Can the user view a StatusCallbackURL? Also protects
 	// Voice/SMS/Fallback/Callback URL's for phone numbers.
 	CanViewCallbackURLs bool `yaml:"can_view_callback_urls"`
+	// Can the user view the parameters Twilio sent to a webhook, and the
+	// headers and body we responded with, on an alert? These can hold
+	// customer data.
+	CanViewAlertPayloads bool `yaml:"can_view_alert_payloads"`
+	// Can the user add, change, import and delete phone number labels?
+	CanManageLabels bool `yaml:"can_manage_labels"`
+	// Can the user reload the config file from /admin/reload?
+	CanReloadConfig bool `yaml:"can_reload_config"`
+	// Can the user grant other users temporary permissions from
+	// /admin/grants? They can only grant permissions they have.
+	CanGrantPermissions bool `yaml:"can_grant_permissions"`
+	// Can the user see who is logged in, and log them out, from
+	// /admin/sessions?
+	CanManageSessions bool `yaml:"can_manage_sessions"`
+	// Can the user send the X-Logrole-Debug-Permissions header to see which
+	// permission hides each hidden field?
+	CanDebugPermissions bool `yaml:"can_debug_permissions"`
+	// Can the user view CPU and memory profiles at /debug/pprof, runtime
+	// stats at /debug/vars, the prefetch queue at /debug/prefetch,
+	// retention purges at /debug/retention, status reconciliation at
+	// /debug/reconcile and CSP reports at /debug/csp? Profiles and runtime
+	// stats are only served if enable_profiling is set.
+	CanProfile bool `yaml:"can_profile"`
+	// Can the user resend an outbound message that failed or went
+	// undelivered? Resending sends a new message through the Twilio API, and
+	// costs money.
+	CanResendMessages bool `yaml:"can_resend_messages"`
+	// Can the user cancel a message that a Messaging Service is scheduled to
+	// send later?
+	CanCancelMessages bool `yaml:"can_cancel_messages"`
+	// Can the user send a message body to the translation service, if one
+	// is configured, and read the translation?
+	CanTranslateMessages bool `yaml:"can_translate_messages"`
+	// Can the user read the internal notes people attached to messages,
+	// calls and alerts, and add their own?
+	CanViewNotes bool `yaml:"can_view_notes"`
 
 	// The maximum viewable age of resources this user can view. If nonzero,
-	// this overrides any global setting.
+	// this overrides any global setting, so a group can be allowed to search
+	// further back than everyone else (or less far). Groups that don't set it
+	// use the global setting.
 	//
 	// Note phone numbers are *exempt* from this rule. All of your account's phone
 	// numbers will be viewable even if the phone number was purchased before this
 	// age.
 	MaxResourceAge time.Duration `yaml:"max_resource_age"`
+
+	// Messages, calls, conversations and phone numbers to or from these
+	// countries are hidden from the user everywhere, including exports. Use
+	// two letter region codes like "GB", or "EU" for every member state of
+	// the European Union.
+	ExcludedCountries []string `yaml:"excluded_countries,omitempty"`
+
+	// The most rows a list page can show the user, whatever page size they
+	// choose. Zero means Twilio's maximum.
+	MaxPageSize uint `yaml:"max_page_size,omitempty"`
 }
 
 // An alias type to avoid infinite recursion when calling UnmarshalYAML.
 type yamlSettings UserSettings
 
 // Unmarshal YAML into the UserSettings object. By default, unspecified values
-// are set to true.
+// are set to true, except admin permissions.
 func (us *UserSettings) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if us == nil {
 		us = new(UserSettings)
 	}
-	// sets everything to true
-	aus := AllUserSettings()
-	ys := yamlSettings(*aus)
+	ys := yamlSettings(*defaultUserSettings())
 	if err := unmarshal(&ys); err != nil {
 		if strings.Contains(err.Error(), "unmarshal !!seq") {
 			return fmt.Errorf("%s. Double check that permissions is a map and "+
@@ -116,22 +216,56 @@ func AllUserSettings() *UserSettings {
 		CanViewMessageFrom:    true,
 		CanViewMessageTo:      true,
 		CanViewMessageBody:    true,
+		CanViewPrices:         true,
 		CanViewMessagePrice:   true,
 		CanViewMedia:          true,
+		CanViewFlaggedMedia:   true,
 		CanViewCalls:          true,
 		CanViewCallFrom:       true,
 		CanViewCallTo:         true,
 		CanViewCallPrice:      true,
+		CanViewCallDigits:     true,
 		CanViewNumRecordings:  true,
 		CanPlayRecordings:     true,
+		CanDownloadRecordings: true,
 		CanViewRecordingPrice: true,
 		CanViewConferences:    true,
 		CanViewAlerts:         true,
 		CanViewCallbackURLs:   true,
+		CanViewAlertPayloads:  true,
+		CanManageLabels:       true,
+		CanReloadConfig:       true,
+		CanGrantPermissions:   true,
+		CanManageSessions:     true,
+		CanDebugPermissions:   true,
+		CanProfile:            true,
+		CanResendMessages:     true,
+		CanCancelMessages:     true,
+		CanTranslateMessages:  true,
+		CanViewNotes:          true,
 		MaxResourceAge:        DefaultMaxResourceAge,
 	}
 }
 
+// defaultUserSettings returns the permissions of a policy group that doesn't
+// mention them. Admin permissions are off, so upgrading Logrole doesn't hand
+// a new one to every existing group.
+func defaultUserSettings() *UserSettings {
+	us := AllUserSettings()
+	us.CanGrantPermissions = false
+	us.CanReloadConfig = false
+	us.CanResendMessages = false
+	us.CanCancelMessages = false
+	us.CanViewAlertPayloads = false
+	us.CanManageSessions = false
+	us.CanProfile = false
+	us.CanDebugPermissions = false
+	// A group that doesn't set max_resource_age gets the global setting, not
+	// every resource ever.
+	us.MaxResourceAge = 0
+	return us
+}
+
 // NewUser creates a new User with the given settings.
 func NewUser(us *UserSettings) *User {
 	if us == nil {
@@ -143,19 +277,36 @@ func NewUser(us *UserSettings) *User {
 		canViewMessageFrom:    us.CanViewMessageFrom,
 		canViewMessageTo:      us.CanViewMessageTo,
 		canViewMessageBody:    us.CanViewMessageBody,
+		canViewPrices:         us.CanViewPrices,
 		canViewMessagePrice:   us.CanViewMessagePrice,
 		canViewMedia:          us.CanViewMedia,
+		canViewFlaggedMedia:   us.CanViewFlaggedMedia,
 		canViewCalls:          us.CanViewCalls,
 		canViewCallFrom:       us.CanViewCallFrom,
 		canViewCallTo:         us.CanViewCallTo,
 		canViewCallPrice:      us.CanViewCallPrice,
+		canViewCallDigits:     us.CanViewCallDigits,
 		canViewNumRecordings:  us.CanViewNumRecordings,
 		canPlayRecordings:     us.CanPlayRecordings,
+		canDownloadRecordings: us.CanDownloadRecordings,
 		canViewRecordingPrice: us.CanViewRecordingPrice,
 		canViewConferences:    us.CanViewConferences,
 		canViewAlerts:         us.CanViewAlerts,
 		canViewCallbackURLs:   us.CanViewCallbackURLs,
+		canViewAlertPayloads:  us.CanViewAlertPayloads,
+		canManageLabels:       us.CanManageLabels,
+		canReloadConfig:       us.CanReloadConfig,
+		canGrantPermissions:   us.CanGrantPermissions,
+		canManageSessions:     us.CanManageSessions,
+		canDebugPermissions:   us.CanDebugPermissions,
+		canProfile:            us.CanProfile,
+		canResendMessages:     us.CanResendMessages,
+		canCancelMessages:     us.CanCancelMessages,
+		canTranslateMessages:  us.CanTranslateMessages,
+		canViewNotes:          us.CanViewNotes,
 		maxResourceAge:        us.MaxResourceAge,
+		excludedCountries:     countrySet(us.ExcludedCountries),
+		maxPageSize:           us.MaxPageSize,
 	}
 }
 
@@ -179,14 +330,24 @@ func (u *User) CanViewMessageBody() bool {
 	return u.CanViewMessages() && u.canViewMessageBody
 }
 
+// CanViewPrices reports whether the user can see prices at all. Each kind of
+// price also has its own permission.
+func (u *User) CanViewPrices() bool {
+	return u.canViewPrices
+}
+
 func (u *User) CanViewMessagePrice() bool {
-	return u.CanViewMessages() && u.canViewMessagePrice
+	return u.CanViewMessages() && u.CanViewPrices() && u.canViewMessagePrice
 }
 
 func (u *User) CanViewMedia() bool {
 	return u.CanViewMessages() && u.canViewMedia
 }
 
+func (u *User) CanViewFlaggedMedia() bool {
+	return u.CanViewMedia() && u.canViewFlaggedMedia
+}
+
 func (u *User) CanViewCalls() bool {
 	return u.canViewCalls
 }
@@ -200,7 +361,11 @@ func (u *User) CanViewCallTo() bool {
 }
 
 func (u *User) CanViewCallPrice() bool {
-	return u.CanViewCalls() && u.canViewCallPrice
+	return u.CanViewCalls() && u.CanViewPrices() && u.canViewCallPrice
+}
+
+func (u *User) CanViewCallDigits() bool {
+	return u.CanViewCalls() && u.canViewCallDigits
 }
 
 func (u *User) CanViewNumRecordings() bool {
@@ -211,8 +376,12 @@ func (u *User) CanPlayRecordings() bool {
 	return u.canPlayRecordings
 }
 
+func (u *User) CanDownloadRecordings() bool {
+	return u.CanPlayRecordings() && u.canDownloadRecordings
+}
+
 func (u *User) CanViewRecordingPrice() bool {
-	return u.canViewRecordingPrice
+	return u.CanViewPrices() && u.canViewRecordingPrice
 }
 
 func (u *User) CanViewConferences() bool {
@@ -227,15 +396,202 @@ func (u *User) CanViewCallbackURLs() bool {
 	return u.canViewCallbackURLs
 }
 
+// CanViewAlertPayloads reports whether the user can see the request and
+// response bodies on alerts. A user who can't view alerts can't see them.
+func (u *User) CanViewAlertPayloads() bool {
+	return u.CanViewAlerts() && u.canViewAlertPayloads
+}
+
+func (u *User) CanManageLabels() bool {
+	return u.canManageLabels
+}
+
+func (u *User) CanReloadConfig() bool {
+	return u.canReloadConfig
+}
+
+func (u *User) CanGrantPermissions() bool {
+	return u.canGrantPermissions
+}
+
+func (u *User) CanManageSessions() bool {
+	return u.canManageSessions
+}
+
+func (u *User) CanDebugPermissions() bool {
+	return u.canDebugPermissions
+}
+
+func (u *User) CanProfile() bool {
+	return u.canProfile
+}
+
+// CanResendMessages reports whether the user can send a failed message again.
+// A user who can't view messages can't resend them.
+func (u *User) CanResendMessages() bool {
+	return u.CanViewMessages() && u.canResendMessages
+}
+
+// CanCancelMessages reports whether the user can cancel a scheduled message.
+// A user who can't view messages can't cancel them.
+func (u *User) CanCancelMessages() bool {
+	return u.CanViewMessages() && u.canCancelMessages
+}
+
+// CanTranslateMessages reports whether the user can translate a message
+// body. A user who can't read the body can't translate it.
+func (u *User) CanTranslateMessages() bool {
+	return u.CanViewMessageBody() && u.canTranslateMessages
+}
+
+// CanViewNotes reports whether the user can read and add notes. They also
+// need permission to view the resource a note is attached to.
+func (u *User) CanViewNotes() bool {
+	return u.canViewNotes
+}
+
+// WithPermissionDebugging returns a copy of u that explains why fields are
+// hidden, or u unchanged if u can't debug permissions.
+func (u *User) WithPermissionDebugging() *User {
+	if !u.CanDebugPermissions() {
+		return u
+	}
+	u2 := *u
+	u2.debugPermissions = true
+	return &u2
+}
+
+// DebugPermissions reports whether hidden fields should say which permission
+// hides them.
+func (u *User) DebugPermissions() bool {
+	return u.debugPermissions
+}
+
+// HiddenReason explains why a field that requires the named permission is
+// hidden, like "requires can_view_prices (for can_view_call_price)". It
+// returns the empty string if the field isn't hidden, or if u isn't debugging
+// permissions.
+func (u *User) HiddenReason(name string) string {
+	if !u.debugPermissions || name == "" || u.HasPermission(name) {
+		return ""
+	}
+	missing := u.MissingPermission(name)
+	if missing == name {
+		return "requires " + name
+	}
+	return "requires " + missing + " (for " + name + ")"
+}
+
+// WithGrants returns a copy of u with the permissions from every grant for u
+// that's active at now. If none apply, u is returned unchanged.
+func (u *User) WithGrants(grants []*Grant, now time.Time) *User {
+	var applied []*Grant
+	for _, g := range grants {
+		if g.User == u.id && g.Active(now) {
+			applied = append(applied, g)
+		}
+	}
+	if len(applied) == 0 {
+		return u
+	}
+	u2 := *u
+	u2.grants = applied
+	for _, g := range applied {
+		for _, p := range g.Permissions {
+			if field, ok := grantablePermissions[p]; ok {
+				*field(&u2) = true
+			}
+		}
+	}
+	return &u2
+}
+
+// WithFeatures returns a copy of u with the features in global, except where
+// the user's group overrides them.
+func (u *User) WithFeatures(global Features) *User {
+	if len(global) == 0 {
+		return u
+	}
+	u2 := *u
+	u2.features = u.features.merge(global)
+	return &u2
+}
+
+// Feature reports whether the named feature is on for the user.
+func (u *User) Feature(name string) bool {
+	return u.features.Enabled(name)
+}
+
+// BusinessHours returns the business hours of the user's group, or nil if it
+// doesn't have a home timezone.
+func (u *User) BusinessHours() *BusinessHours {
+	return u.hours
+}
+
+// Grants returns the grants that gave the user extra permissions for this
+// request.
+func (u *User) Grants() []*Grant {
+	return u.grants
+}
+
+// BreakGlass returns the user's emergency access grant, or nil if they
+// haven't broken the glass.
+func (u *User) BreakGlass() *Grant {
+	for _, g := range u.grants {
+		if g.BreakGlass {
+			return g
+		}
+	}
+	return nil
+}
+
+// ID returns the name the user authenticated with, or the empty string if the
+// user was not looked up in a policy.
+func (u *User) ID() string {
+	return u.id
+}
+
+// Group returns the name of the user's group in the policy, or the empty
+// string if the user was not looked up in a policy.
+func (u *User) Group() string {
+	return u.group
+}
+
+// ViewedBy returns a copy of u that viewer is looking at the site as.
+func (u *User) ViewedBy(viewer *User) *User {
+	u2 := *u
+	u2.viewedBy = viewer
+	return &u2
+}
+
+// Viewer returns the user who is viewing the site as u, or nil if u is
+// viewing the site themselves.
+func (u *User) Viewer() *User {
+	return u.viewedBy
+}
+
+// MaxResourceAge returns the age of the oldest resource u can view. If the
+// user's maxResourceAge is nonzero, it overrides the globalMaxAge. Zero means
+// every resource can be viewed.
+func (u *User) MaxResourceAge(globalMaxAge time.Duration) time.Duration {
+	if u.maxResourceAge != 0 {
+		return u.maxResourceAge
+	}
+	return globalMaxAge
+}
+
+// MaxPageSize returns the most rows u can see on a list page, or 0 if the
+// only limit is Twilio's.
+func (u *User) MaxPageSize() uint {
+	return u.maxPageSize
+}
+
 // CanViewResource returns true if the specified timestamp is within the
 // user's maxResourceAge setting. If the user's maxResourceAge is nonzero, it
 // overrides the globalMaxAge. Returns true if the globalMaxAge and the user's
 // maxResourceAge are both zero.
 func (u *User) CanViewResource(resourceCreatedAt time.Time, globalMaxAge time.Duration) bool {
-	var maxAge = globalMaxAge
-	if u.maxResourceAge != 0 {
-		maxAge = u.maxResourceAge
-	}
+	maxAge := u.MaxResourceAge(globalMaxAge)
 	if maxAge == 0 {
 		return true
 	}
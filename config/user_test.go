@@ -1,6 +1,7 @@
 package config
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -28,6 +29,51 @@ can_view_messages: false
 	}
 }
 
+func TestAdminPermissionsDefaultOff(t *testing.T) {
+	t.Parallel()
+	admin := []struct {
+		name string
+		can  func(*User) bool
+	}{
+		{"can_grant_permissions", (*User).CanGrantPermissions},
+		{"can_reload_config", (*User).CanReloadConfig},
+		{"can_resend_messages", (*User).CanResendMessages},
+		{"can_cancel_messages", (*User).CanCancelMessages},
+		{"can_view_alert_payloads", (*User).CanViewAlertPayloads},
+		{"can_manage_sessions", (*User).CanManageSessions},
+		{"can_profile", (*User).CanProfile},
+		{"can_debug_permissions", (*User).CanDebugPermissions},
+	}
+	us := new(UserSettings)
+	if err := yaml.Unmarshal([]byte("can_view_calls: false\n"), us); err != nil {
+		t.Fatal(err)
+	}
+	u := NewUser(us)
+	for _, p := range admin {
+		if p.can(u) {
+			t.Errorf("expected %s to be off unless a group sets it", p.name)
+		}
+	}
+	if !u.CanViewMessages() {
+		t.Error("expected other permissions to still default to true")
+	}
+
+	lines := make([]string, len(admin))
+	for i, p := range admin {
+		lines[i] = p.name + ": true"
+	}
+	us = new(UserSettings)
+	if err := yaml.Unmarshal([]byte(strings.Join(lines, "\n")), us); err != nil {
+		t.Fatal(err)
+	}
+	u = NewUser(us)
+	for _, p := range admin {
+		if !p.can(u) {
+			t.Errorf("expected a group to be able to turn on %s", p.name)
+		}
+	}
+}
+
 func TestCanViewResource(t *testing.T) {
 	u := &User{maxResourceAge: 0}
 	now := time.Now()
@@ -48,3 +94,41 @@ func TestCanViewResource(t *testing.T) {
 		t.Errorf("with local Age = time.Minute, global Age == time.Nanosecond, CanViewResource (2 minutes ago) should be false, got true")
 	}
 }
+
+func TestUnmarshalMaxResourceAge(t *testing.T) {
+	t.Parallel()
+	us := new(UserSettings)
+	if err := yaml.Unmarshal([]byte("can_view_calls: false\n"), us); err != nil {
+		t.Fatal(err)
+	}
+	if age := NewUser(us).MaxResourceAge(30 * 24 * time.Hour); age != 30*24*time.Hour {
+		t.Errorf("expected a group without max_resource_age to use the global setting, got %s", age)
+	}
+	us = new(UserSettings)
+	if err := yaml.Unmarshal([]byte("max_resource_age: 17520h\n"), us); err != nil {
+		t.Fatal(err)
+	}
+	u := NewUser(us)
+	if age := u.MaxResourceAge(30 * 24 * time.Hour); age != 17520*time.Hour {
+		t.Errorf("expected group max_resource_age to override the global setting, got %s", age)
+	}
+	if !u.CanViewResource(time.Now().Add(-365*24*time.Hour), 30*24*time.Hour) {
+		t.Errorf("expected group to view a resource older than the global setting")
+	}
+}
+
+func TestCanViewPrices(t *testing.T) {
+	us := AllUserSettings()
+	us.CanViewPrices = false
+	u := NewUser(us)
+	if u.CanViewMessagePrice() || u.CanViewCallPrice() || u.CanViewRecordingPrice() {
+		t.Error("expected can_view_prices: false to hide every price")
+	}
+	if u.HasPermission("can_view_call_price") {
+		t.Error("expected HasPermission to check can_view_prices")
+	}
+	u = u.WithGrants([]*Grant{{Permissions: []string{"can_view_prices"}, Expires: time.Now().Add(time.Hour)}}, time.Now())
+	if !u.CanViewMessagePrice() {
+		t.Error("expected a grant of can_view_prices to show message prices")
+	}
+}
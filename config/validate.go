@@ -0,0 +1,226 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// MaxPageSize is the largest page Twilio will return.
+const MaxPageSize = 1000
+
+// A ConfigError is a problem with one key in a config file.
+type ConfigError struct {
+	// Path to the key, like "page_size" or "retention.audit_log". Empty for
+	// problems with the file as a whole.
+	Key     string
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Key == "" {
+		return e.Message
+	}
+	return e.Key + ": " + e.Message
+}
+
+type configErrors []*ConfigError
+
+func (c configErrors) Len() int           { return len(c) }
+func (c configErrors) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c configErrors) Less(i, j int) bool { return c[i].Key < c[j].Key }
+
+var durationType = reflect.TypeOf(time.Duration(0))
+var unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// ValidateConfig checks the YAML in data against FileConfig, without loading
+// anything it refers to, and returns every problem it finds, sorted by key:
+// unknown keys, durations without units, page sizes Twilio won't accept and
+// unknown timezones, as well as values of the wrong type.
+func ValidateConfig(data []byte) []*ConfigError {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return []*ConfigError{{Message: "Couldn't parse the file as YAML: " + err.Error()}}
+	}
+	var errs configErrors
+	if doc != nil {
+		checkKeys(&errs, "", doc, reflect.TypeOf(FileConfig{}))
+	}
+	c := new(FileConfig)
+	if err := yaml.Unmarshal(data, c); err != nil {
+		if terr, ok := err.(*yaml.TypeError); ok {
+			for _, msg := range terr.Errors {
+				// checkKeys has already reported these, with the key.
+				if strings.HasSuffix(msg, "into time.Duration") {
+					continue
+				}
+				errs = append(errs, &ConfigError{Message: msg})
+			}
+		} else if len(errs) == 0 {
+			errs = append(errs, &ConfigError{Message: err.Error()})
+		}
+	}
+	if c.PageSize > MaxPageSize {
+		errs = append(errs, &ConfigError{Key: "page_size", Message: fmt.Sprintf("%d is more than Twilio's maximum of %d", c.PageSize, MaxPageSize)})
+	}
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			errs = append(errs, timezoneError("default_timezone", c.Timezone))
+		}
+	}
+	for i, tz := range c.Timezones {
+		if _, err := time.LoadLocation(tz); err != nil {
+			errs = append(errs, timezoneError(fmt.Sprintf("timezones[%d]", i), tz))
+		}
+	}
+	sort.Stable(errs)
+	return errs
+}
+
+func timezoneError(key, tz string) *ConfigError {
+	return &ConfigError{Key: key, Message: fmt.Sprintf("Unknown timezone %q, use a name from the tz database like \"America/Los_Angeles\"", tz)}
+}
+
+// yamlKeys returns the YAML keys of the fields in the struct type t.
+func yamlKeys(t reflect.Type) map[string]reflect.Type {
+	keys := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		keys[name] = f.Type
+	}
+	return keys
+}
+
+// checkKeys compares the decoded YAML value v at path against type t,
+// looking for keys t doesn't have and durations that aren't strings. Types
+// that decode themselves, like Policy, aren't checked.
+func checkKeys(errs *configErrors, path string, v interface{}, t reflect.Type) {
+	if t == durationType {
+		checkDuration(errs, path, v)
+		return
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		checkKeys(errs, path, v, t.Elem())
+	case reflect.Slice:
+		items, ok := v.([]interface{})
+		if !ok {
+			return
+		}
+		for i, item := range items {
+			checkKeys(errs, fmt.Sprintf("%s[%d]", path, i), item, t.Elem())
+		}
+	case reflect.Map:
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return
+		}
+		for k, item := range m {
+			checkKeys(errs, join(path, fmt.Sprint(k)), item, t.Elem())
+		}
+	case reflect.Struct:
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return
+		}
+		keys := yamlKeys(t)
+		for k, item := range m {
+			name := fmt.Sprint(k)
+			ft, ok := keys[name]
+			if !ok {
+				msg := "Unknown key"
+				if s := closestKey(name, keys); s != "" {
+					msg += fmt.Sprintf(", did you mean %q?", s)
+				}
+				*errs = append(*errs, &ConfigError{Key: join(path, name), Message: msg})
+				continue
+			}
+			checkKeys(errs, join(path, name), item, ft)
+		}
+	}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// checkDuration reports durations written without a unit, which YAML reads
+// as nanoseconds, and ones time.ParseDuration can't read.
+func checkDuration(errs *configErrors, path string, v interface{}) {
+	switch d := v.(type) {
+	case nil:
+	case string:
+		if _, err := time.ParseDuration(d); err != nil {
+			*errs = append(*errs, &ConfigError{Key: path, Message: fmt.Sprintf("Invalid duration %q, use a number and a unit, like \"30s\", \"5m\" or \"72h\"", d)})
+		}
+	case int, int64, uint64, float64:
+		if d != 0 {
+			*errs = append(*errs, &ConfigError{Key: path, Message: fmt.Sprintf("%v has no unit, so it means %v nanoseconds; add a unit, like \"%vs\" or \"%vm\"", d, d, d, d)})
+		}
+	default:
+		*errs = append(*errs, &ConfigError{Key: path, Message: fmt.Sprintf("Invalid duration %v, use a number and a unit, like \"5m\"", d)})
+	}
+}
+
+// closestKey returns the key in keys that's closest to name, if one is close
+// enough to be a likely typo.
+func closestKey(name string, keys map[string]reflect.Type) string {
+	best, bestDist := "", 3
+	for k := range keys {
+		if d := editDistance(name, k); d < bestDist || (d == bestDist && best != "" && k < best) {
+			best, bestDist = k, d
+		}
+	}
+	if bestDist >= 3 {
+		return ""
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	t.Parallel()
+	data := []byte(`
+port: 4114
+page_sise: 50
+page_size: 5000
+max_resource_age: 3600
+default_timezone: America/Los_Angeles
+timezones:
+  - America/New_York
+  - Mars/Olympus_Mons
+retention:
+  audit_log: 72x
+footer_links:
+  - text: Status
+    uri: https://status.example.com
+`)
+	errs := ValidateConfig(data)
+	want := []string{
+		`footer_links[0].uri: Unknown key, did you mean "url"?`,
+		"max_resource_age: 3600 has no unit",
+		`page_sise: Unknown key, did you mean "page_size"?`,
+		"page_size: 5000 is more than Twilio's maximum of 1000",
+		`retention.audit_log: Invalid duration "72x"`,
+		`timezones[1]: Unknown timezone "Mars/Olympus_Mons"`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got %d: %v", len(want), len(errs), errs)
+	}
+	for i, err := range errs {
+		if !strings.HasPrefix(err.Error(), want[i]) {
+			t.Errorf("error %d: expected %q, got %q", i, want[i], err.Error())
+		}
+	}
+}
+
+func TestValidateConfigValid(t *testing.T) {
+	t.Parallel()
+	data := []byte("port: 4114\npage_size: 1000\nmax_resource_age: 720h\nretention:\n  audit_log: 2160h\n")
+	if errs := ValidateConfig(data); len(errs) != 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+	if errs := ValidateConfig([]byte("port: [4114")); len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "Couldn't parse the file as YAML") {
+		t.Errorf("expected a YAML syntax error, got %v", errs)
+	}
+}
@@ -0,0 +1,185 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/saintpete/logrole/store"
+)
+
+// A Resumable Task saves its progress after every Step, so it can carry on
+// where it left off if the server restarts in the middle of a long job. If
+// the Queue has a Dir, a Resumable Task writes its output to a file there
+// instead of holding it in memory.
+type Resumable interface {
+	Task
+	// Open is called before the first Step with the file to write output to.
+	// When a job is resumed, the file holds the output written up to the last
+	// checkpoint, and writes go to the end of it. Step must flush everything
+	// it writes to f before it returns.
+	Open(f *os.File) error
+	// Checkpoint returns what the Task needs to resume after the last Step.
+	Checkpoint() ([]byte, error)
+}
+
+// A ResumeFunc recreates a Task for the job's owner from the state its
+// Checkpoint method returned.
+type ResumeFunc func(owner string, state []byte) (Resumable, error)
+
+// checkpoint is saved to <id>.json in the Queue's Dir after every Step of a
+// Resumable job. The job's output is in <id>.out.
+type checkpoint struct {
+	Job Job
+	// The size of the output file when the checkpoint was saved. Anything
+	// after it was written by a Step that hadn't finished, and is thrown
+	// away when the job is resumed, so it isn't written twice.
+	Offset int64
+	State  []byte
+}
+
+func (q *Queue) checkpointPath(id string) string {
+	return filepath.Join(q.Dir, id+".json")
+}
+
+func (q *Queue) outputPath(id string) string {
+	return filepath.Join(q.Dir, id+".out")
+}
+
+// open creates the output file for a new Resumable job, and saves its first
+// checkpoint.
+func (q *Queue) open(j *job) error {
+	f, err := os.OpenFile(q.outputPath(j.ID), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	j.out = f
+	if err := j.task.(Resumable).Open(f); err != nil {
+		q.removeFiles(j)
+		return err
+	}
+	if err := q.saveCheckpoint(j, j.Job); err != nil {
+		q.removeFiles(j)
+		return err
+	}
+	return nil
+}
+
+// saveCheckpoint writes the progress of j, as of snapshot, to disk. The file
+// is replaced atomically, so a crash leaves the old checkpoint or the new
+// one.
+func (q *Queue) saveCheckpoint(j *job, snapshot Job) error {
+	state, err := j.task.(Resumable).Checkpoint()
+	if err != nil {
+		return err
+	}
+	fi, err := j.out.Stat()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&checkpoint{Job: snapshot, Offset: fi.Size(), State: state})
+	if err != nil {
+		return err
+	}
+	return store.WriteFile(q.checkpointPath(j.ID), data)
+}
+
+// removeFiles closes and deletes the output file and checkpoint of j, if it
+// has them.
+func (q *Queue) removeFiles(j *job) {
+	if j.out == nil {
+		return
+	}
+	j.out.Close()
+	j.out = nil
+	for _, path := range []string{q.outputPath(j.ID), q.checkpointPath(j.ID)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			q.Warn("Couldn't remove job file", "path", path, "err", err)
+		}
+	}
+}
+
+// resume loads the checkpoint at path, and returns the job it was saved for,
+// ready to run.
+func (q *Queue) resume(path string, fn ResumeFunc) (*job, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cp := new(checkpoint)
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, err
+	}
+	if cp.Job.ID == "" || cp.Job.Status.Finished() {
+		return nil, fmt.Errorf("jobs: %s is not a checkpoint for an unfinished job", path)
+	}
+	task, err := fn(cp.Job.Owner, cp.State)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(q.outputPath(cp.Job.ID), os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(cp.Offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(cp.Offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := task.Open(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	j := &job{Job: cp.Job, task: task, out: f}
+	j.Status = StatusQueued
+	j.Resumed = true
+	return j, nil
+}
+
+// Resume restarts the jobs that were checkpointed in Dir and hadn't finished
+// when the server stopped, and returns how many it restarted. fn recreates
+// each job's Task. Checkpoints that can't be resumed, because the Task can't
+// be recreated or the output is missing, are logged and deleted. Call Resume
+// once, before jobs are submitted.
+func (q *Queue) Resume(fn ResumeFunc) (int, error) {
+	if q.Dir == "" {
+		return 0, nil
+	}
+	files, err := ioutil.ReadDir(q.Dir)
+	if err != nil {
+		return 0, err
+	}
+	resumed := make([]*job, 0)
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(q.Dir, fi.Name())
+		j, err := q.resume(path, fn)
+		if err != nil {
+			q.Warn("Couldn't resume job, deleting it", "path", path, "err", err)
+			id := strings.TrimSuffix(fi.Name(), ".json")
+			os.Remove(path)
+			os.Remove(q.outputPath(id))
+			continue
+		}
+		resumed = append(resumed, j)
+	}
+	q.mu.Lock()
+	for _, j := range resumed {
+		q.jobs[j.ID] = j
+	}
+	q.mu.Unlock()
+	for _, j := range resumed {
+		q.Info("Resuming job", "id", j.ID, "description", j.Description, "steps", j.Steps)
+		go q.run(j)
+	}
+	return len(resumed), nil
+}
@@ -0,0 +1,137 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// lineTask writes a line per Step to its output file. If block is set, the
+// Step that writes line stopAt closes stopped and blocks after writing it,
+// like a server that stopped partway through a Step.
+type lineTask struct {
+	count   int
+	steps   int
+	stopAt  int
+	block   chan bool
+	stopped chan bool
+	f       *os.File
+}
+
+func (l *lineTask) Open(f *os.File) error {
+	l.f = f
+	return nil
+}
+
+func (l *lineTask) Checkpoint() ([]byte, error) {
+	return json.Marshal(l.count)
+}
+
+func (l *lineTask) Step(ctx context.Context) (int, bool, error) {
+	l.count++
+	fmt.Fprintf(l.f, "line %d\n", l.count)
+	if l.block != nil && l.count == l.stopAt {
+		close(l.stopped)
+		<-l.block
+	}
+	return 1, l.count >= l.steps, nil
+}
+
+func (l *lineTask) Artifact() (*Artifact, error) {
+	if _, err := l.f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(l.f)
+	if err != nil {
+		return nil, err
+	}
+	return &Artifact{Filename: "lines.txt", ContentType: "text/plain", Data: data}, nil
+}
+
+func TestResume(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-jobs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	q := newTestQueue()
+	q.Dir = dir
+	// Never unblocked; the job is abandoned, like it would be on a restart.
+	block := make(chan bool)
+	stopped := make(chan bool)
+	j, err := q.Submit("test", "Lines", &lineTask{steps: 5, stopAt: 3, block: block, stopped: stopped})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not get to the third step")
+	}
+
+	q2 := newTestQueue()
+	q2.Dir = dir
+	n, err := q2.Resume(func(owner string, state []byte) (Resumable, error) {
+		if owner != "test" {
+			t.Errorf("expected owner to be saved, got %q", owner)
+		}
+		task := &lineTask{steps: 5}
+		return task, json.Unmarshal(state, &task.count)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected to resume 1 job, got %d", n)
+	}
+	resumed := waitFinished(t, q2, "test", j.ID)
+	if resumed.Status != StatusComplete {
+		t.Fatalf("expected resumed job to complete, got %s (%s)", resumed.Status, resumed.Err)
+	}
+	if !resumed.Resumed || resumed.Steps != 5 {
+		t.Errorf("expected a resumed job with 5 steps, got %t and %d", resumed.Resumed, resumed.Steps)
+	}
+	a, err := q2.Artifact("test", j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "line 1\nline 2\nline 3\nline 4\nline 5\n"; string(a.Data) != want {
+		t.Errorf("expected each line once, got %q", a.Data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, j.ID+".json")); !os.IsNotExist(err) {
+		t.Errorf("expected the checkpoint to be deleted when the job finished, got %v", err)
+	}
+}
+
+func TestResumeDeletesBadCheckpoints(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-jobs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "abc.json")
+	if err := ioutil.WriteFile(path, []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	q := newTestQueue()
+	q.Dir = dir
+	n, err := q.Resume(func(owner string, state []byte) (Resumable, error) {
+		t.Error("expected a bad checkpoint not to be resumed")
+		return nil, nil
+	})
+	if err != nil || n != 0 {
+		t.Errorf("expected nothing to be resumed, got %d, %v", n, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the bad checkpoint to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,427 @@
+// Package jobs runs long running work, like bulk exports, in the background.
+//
+// A user submits a Task, which is run in a goroutine one Step at a time. The
+// Queue limits the number of Tasks that run at once and the rate at which
+// Steps are run (a Step generally makes one request to the Twilio API), and
+// retries failed Steps with exponential backoff. When the Task finishes, its
+// Artifact is held in memory and can be downloaded until it expires.
+//
+// If the Queue has a Dir, Resumable Tasks are checkpointed there after every
+// Step, and Resume restarts them from their last checkpoint after the server
+// restarts.
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/saintpete/logrole/logging"
+	"golang.org/x/net/context"
+)
+
+type Status string
+
+const (
+	StatusQueued   = Status("queued")
+	StatusRunning  = Status("running")
+	StatusComplete = Status("complete")
+	StatusFailed   = Status("failed")
+)
+
+// Friendly returns a capitalized version of the Status.
+func (s Status) Friendly() string {
+	switch s {
+	case StatusQueued:
+		return "Queued"
+	case StatusRunning:
+		return "Running"
+	case StatusComplete:
+		return "Complete"
+	case StatusFailed:
+		return "Failed"
+	default:
+		return string(s)
+	}
+}
+
+// Finished returns true if the job is no longer running.
+func (s Status) Finished() bool {
+	return s == StatusComplete || s == StatusFailed
+}
+
+// A Task is a unit of background work.
+type Task interface {
+	// Step does the next unit of work (generally fetching a page of
+	// resources), and returns the number of items processed and whether the
+	// task is done. Step is called again (with the same state) if it returns
+	// an error, unless the error is Permanent.
+	Step(context.Context) (n int, done bool, err error)
+	// Artifact returns the result of the finished Task.
+	Artifact() (*Artifact, error)
+}
+
+// An Artifact is the downloadable result of a job.
+type Artifact struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string {
+	return p.err.Error()
+}
+
+// Permanent wraps err to indicate that a Step should not be retried.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// A Job is a snapshot of the state of a Task submitted to the Queue.
+type Job struct {
+	ID          string
+	Owner       string
+	Description string
+	Status      Status
+	// Number of Steps completed, and items processed.
+	Steps int
+	Items int
+	// Number of times a Step has been retried.
+	Retries    int
+	Err        string
+	CreatedAt  time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// The time the job and its artifact will be deleted. Zero until the job
+	// finishes.
+	ExpiresAt time.Time
+	// Size of the artifact, in bytes.
+	Size int
+	// True if the job was restarted from a checkpoint.
+	Resumed bool
+}
+
+type job struct {
+	Job
+	task     Task
+	artifact *Artifact
+	// The output file of a Resumable task, if the Queue has a Dir.
+	out *os.File
+}
+
+// ErrNotFound is returned if a job does not exist, has expired, or is owned by
+// a different user.
+var ErrNotFound = errors.New("jobs: Job not found")
+
+// ErrNotFinished is returned when trying to retrieve an artifact for a job
+// that has not completed.
+var ErrNotFinished = errors.New("jobs: Job has not finished")
+
+// ErrTooManyJobs is returned if a user tries to submit a job while they
+// already have MaxPerOwner jobs in the queue.
+var ErrTooManyJobs = errors.New("jobs: Too many jobs in progress, wait for one to finish")
+
+type Queue struct {
+	log.Logger
+	// The amount of time a finished job is kept around.
+	TTL time.Duration
+	// The minimum amount of time between Steps, across all jobs.
+	Interval time.Duration
+	// The number of times to retry a failing Step.
+	MaxRetries int
+	// The amount of time to wait before the first retry. The wait doubles
+	// after each failure.
+	Backoff time.Duration
+	// The maximum number of unfinished jobs a single owner can have.
+	MaxPerOwner int
+	// If set, Resumable jobs write their output and checkpoints to this
+	// directory, so they can be resumed after a restart.
+	Dir string
+
+	sem chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*job
+
+	limitMu  sync.Mutex
+	nextStep time.Time
+}
+
+// NewQueue creates a new Queue that runs at most workers jobs at once. Steps
+// are run no more often than once every interval, and finished jobs are
+// deleted after ttl.
+func NewQueue(l log.Logger, workers int, interval time.Duration, ttl time.Duration) *Queue {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &Queue{
+		Logger:      logging.Module(l, logging.ModuleJobs),
+		TTL:         ttl,
+		Interval:    interval,
+		MaxRetries:  5,
+		Backoff:     time.Second,
+		MaxPerOwner: 5,
+		sem:         make(chan struct{}, workers),
+		jobs:        make(map[string]*job),
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Submit adds t to the queue and starts it as soon as a worker is available.
+// Submit returns a snapshot of the new job.
+func (q *Queue) Submit(owner string, description string, t Task) (Job, error) {
+	q.mu.Lock()
+	q.prune(time.Now())
+	count := 0
+	for _, j := range q.jobs {
+		if j.Owner == owner && !j.Status.Finished() {
+			count++
+		}
+	}
+	if q.MaxPerOwner > 0 && count >= q.MaxPerOwner {
+		q.mu.Unlock()
+		return Job{}, ErrTooManyJobs
+	}
+	j := &job{
+		Job: Job{
+			ID:          newID(),
+			Owner:       owner,
+			Description: description,
+			Status:      StatusQueued,
+			CreatedAt:   time.Now().UTC(),
+		},
+		task: t,
+	}
+	if _, ok := t.(Resumable); ok && q.Dir != "" {
+		if err := q.open(j); err != nil {
+			q.mu.Unlock()
+			return Job{}, err
+		}
+	}
+	q.jobs[j.ID] = j
+	snapshot := j.Job
+	q.mu.Unlock()
+	go q.run(j)
+	return snapshot, nil
+}
+
+// wait blocks until the next Step is allowed to run, or ctx is canceled.
+func (q *Queue) wait(ctx context.Context) error {
+	if q.Interval <= 0 {
+		return ctx.Err()
+	}
+	q.limitMu.Lock()
+	now := time.Now()
+	if q.nextStep.Before(now) {
+		q.nextStep = now
+	}
+	sleep := q.nextStep.Sub(now)
+	q.nextStep = q.nextStep.Add(q.Interval)
+	q.limitMu.Unlock()
+	return sleepCtx(ctx, sleep)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) update(j *job, fn func(j *job)) {
+	q.mu.Lock()
+	fn(j)
+	q.mu.Unlock()
+}
+
+func (q *Queue) finish(j *job, artifact *Artifact, err error) {
+	q.removeFiles(j)
+	q.update(j, func(j *job) {
+		now := time.Now().UTC()
+		j.FinishedAt = now
+		j.ExpiresAt = now.Add(q.TTL)
+		j.task = nil
+		if err != nil {
+			j.Status = StatusFailed
+			j.Err = err.Error()
+			return
+		}
+		j.Status = StatusComplete
+		j.artifact = artifact
+		j.Size = len(artifact.Data)
+	})
+	if err != nil {
+		q.Warn("Job failed", "id", j.ID, "description", j.Description, "err", err)
+	} else {
+		q.Info("Job complete", "id", j.ID, "description", j.Description, "size", len(artifact.Data))
+	}
+}
+
+func (q *Queue) run(j *job) {
+	q.sem <- struct{}{}
+	defer func() { <-q.sem }()
+	q.update(j, func(j *job) {
+		j.Status = StatusRunning
+		j.StartedAt = time.Now().UTC()
+	})
+	ctx := context.Background()
+	for {
+		n, done, err := q.step(ctx, j)
+		if err != nil {
+			q.finish(j, nil, err)
+			return
+		}
+		var snapshot Job
+		q.update(j, func(j *job) {
+			j.Steps++
+			j.Items += n
+			snapshot = j.Job
+		})
+		if j.out != nil {
+			if err := q.saveCheckpoint(j, snapshot); err != nil {
+				q.Warn("Couldn't save job checkpoint", "id", j.ID, "err", err)
+			}
+		}
+		if done {
+			break
+		}
+	}
+	artifact, err := j.task.Artifact()
+	if err == nil && artifact == nil {
+		err = errors.New("jobs: Task finished without an artifact")
+	}
+	q.finish(j, artifact, err)
+}
+
+// step runs the next Step of j, retrying with exponential backoff if it
+// fails.
+func (q *Queue) step(ctx context.Context, j *job) (int, bool, error) {
+	backoff := q.Backoff
+	for attempt := 0; ; attempt++ {
+		if err := q.wait(ctx); err != nil {
+			return 0, false, err
+		}
+		n, done, err := j.task.Step(ctx)
+		if err == nil {
+			return n, done, nil
+		}
+		if perr, ok := err.(*permanentError); ok {
+			return 0, false, perr.err
+		}
+		if attempt >= q.MaxRetries {
+			return 0, false, err
+		}
+		q.Debug("Job step failed, retrying", "id", j.ID, "attempt", attempt+1, "backoff", backoff, "err", err)
+		q.update(j, func(j *job) {
+			j.Retries++
+		})
+		if err := sleepCtx(ctx, backoff); err != nil {
+			return 0, false, err
+		}
+		backoff *= 2
+	}
+}
+
+// prune deletes jobs that have expired. The caller must hold q.mu.
+func (q *Queue) prune(now time.Time) {
+	for id, j := range q.jobs {
+		if j.Status.Finished() && now.After(j.ExpiresAt) {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// PurgeBefore deletes the finished jobs, and their artifacts, that finished
+// before cutoff, and returns how many it deleted. If dryRun is true, it only
+// counts them. Jobs that haven't finished are never deleted.
+func (q *Queue) PurgeBefore(cutoff time.Time, dryRun bool) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	purged := 0
+	for id, j := range q.jobs {
+		if !j.Status.Finished() || !j.FinishedAt.Before(cutoff) {
+			continue
+		}
+		purged++
+		if !dryRun {
+			delete(q.jobs, id)
+		}
+	}
+	return purged, nil
+}
+
+type byCreated []Job
+
+func (b byCreated) Len() int           { return len(b) }
+func (b byCreated) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byCreated) Less(i, j int) bool { return b[i].CreatedAt.After(b[j].CreatedAt) }
+
+// List returns all of the jobs belonging to owner, most recent first.
+func (q *Queue) List(owner string) []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune(time.Now())
+	jobs := make([]Job, 0)
+	for _, j := range q.jobs {
+		if j.Owner == owner {
+			jobs = append(jobs, j.Job)
+		}
+	}
+	sort.Sort(byCreated(jobs))
+	return jobs
+}
+
+// Get returns the job with the given id, or ErrNotFound if the job does not
+// exist or belongs to a different owner.
+func (q *Queue) Get(owner string, id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune(time.Now())
+	j, ok := q.jobs[id]
+	if !ok || j.Owner != owner {
+		return Job{}, ErrNotFound
+	}
+	return j.Job, nil
+}
+
+// Artifact returns the result of the job with the given id.
+func (q *Queue) Artifact(owner string, id string) (*Artifact, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune(time.Now())
+	j, ok := q.jobs[id]
+	if !ok || j.Owner != owner {
+		return nil, ErrNotFound
+	}
+	if j.Status != StatusComplete {
+		return nil, ErrNotFinished
+	}
+	return j.artifact, nil
+}
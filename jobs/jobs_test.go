@@ -0,0 +1,200 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/test"
+	"golang.org/x/net/context"
+)
+
+type fakeTask struct {
+	steps    int
+	failures int
+	err      error
+	count    int
+	block    chan bool
+}
+
+func (f *fakeTask) Step(ctx context.Context) (int, bool, error) {
+	if f.block != nil {
+		<-f.block
+	}
+	if f.err != nil {
+		return 0, false, f.err
+	}
+	if f.failures > 0 {
+		f.failures--
+		return 0, false, errors.New("temporary failure")
+	}
+	f.count++
+	return 10, f.count >= f.steps, nil
+}
+
+func (f *fakeTask) Artifact() (*Artifact, error) {
+	return &Artifact{Filename: "out.csv", ContentType: "text/csv", Data: []byte("a,b,c\n")}, nil
+}
+
+func newTestQueue() *Queue {
+	q := NewQueue(test.NullLogger, 2, 0, time.Hour)
+	q.Backoff = time.Millisecond
+	return q
+}
+
+func waitFinished(t *testing.T, q *Queue, owner, id string) Job {
+	timeout := time.After(5 * time.Second)
+	for {
+		j, err := q.Get(owner, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Status.Finished() {
+			return j
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("job %s did not finish, status %s", id, j.Status)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestJobRetriesAndCompletes(t *testing.T) {
+	t.Parallel()
+	q := newTestQueue()
+	j, err := q.Submit("test", "Test export", &fakeTask{steps: 3, failures: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Status != StatusQueued {
+		t.Errorf("expected new job to be queued, got %s", j.Status)
+	}
+	j = waitFinished(t, q, "test", j.ID)
+	if j.Status != StatusComplete {
+		t.Fatalf("expected job to complete, got %s (%s)", j.Status, j.Err)
+	}
+	if j.Steps != 3 || j.Items != 30 {
+		t.Errorf("expected 3 steps and 30 items, got %d and %d", j.Steps, j.Items)
+	}
+	if j.Retries != 2 {
+		t.Errorf("expected 2 retries, got %d", j.Retries)
+	}
+	if j.ExpiresAt.IsZero() {
+		t.Errorf("expected finished job to have an expiry")
+	}
+	a, err := q.Artifact("test", j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a.Data) != "a,b,c\n" {
+		t.Errorf("unexpected artifact data %q", a.Data)
+	}
+}
+
+func TestJobFailsAfterMaxRetries(t *testing.T) {
+	t.Parallel()
+	q := newTestQueue()
+	q.MaxRetries = 1
+	j, _ := q.Submit("test", "Test export", &fakeTask{steps: 1, failures: 5})
+	j = waitFinished(t, q, "test", j.ID)
+	if j.Status != StatusFailed {
+		t.Errorf("expected job to fail, got %s", j.Status)
+	}
+	if j.Err != "temporary failure" {
+		t.Errorf("unexpected error %q", j.Err)
+	}
+	if _, err := q.Artifact("test", j.ID); err != ErrNotFinished {
+		t.Errorf("expected ErrNotFinished, got %v", err)
+	}
+}
+
+func TestPermanentErrorNotRetried(t *testing.T) {
+	t.Parallel()
+	q := newTestQueue()
+	j, _ := q.Submit("test", "Test export", &fakeTask{err: Permanent(errors.New("denied"))})
+	j = waitFinished(t, q, "test", j.ID)
+	if j.Status != StatusFailed || j.Err != "denied" {
+		t.Errorf("expected job to fail with 'denied', got %s %q", j.Status, j.Err)
+	}
+	if j.Retries != 0 {
+		t.Errorf("expected no retries, got %d", j.Retries)
+	}
+}
+
+func TestJobsScopedToOwner(t *testing.T) {
+	t.Parallel()
+	q := newTestQueue()
+	j, _ := q.Submit("alice", "Test export", &fakeTask{steps: 1})
+	waitFinished(t, q, "alice", j.ID)
+	if _, err := q.Get("bob", j.ID); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := q.Artifact("bob", j.ID); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if l := q.List("bob"); len(l) != 0 {
+		t.Errorf("expected bob to have no jobs, got %d", len(l))
+	}
+	if l := q.List("alice"); len(l) != 1 {
+		t.Errorf("expected alice to have one job, got %d", len(l))
+	}
+}
+
+func TestExpiredJobsPruned(t *testing.T) {
+	t.Parallel()
+	q := newTestQueue()
+	q.TTL = -1 * time.Second
+	j, _ := q.Submit("test", "Test export", &fakeTask{steps: 1})
+	timeout := time.After(5 * time.Second)
+	for {
+		if _, err := q.Get("test", j.ID); err == ErrNotFound {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatal("expired job was not pruned")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestMaxPerOwner(t *testing.T) {
+	t.Parallel()
+	q := newTestQueue()
+	q.MaxPerOwner = 1
+	block := make(chan bool)
+	defer close(block)
+	if _, err := q.Submit("test", "Test export", &fakeTask{steps: 1, block: block}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Submit("test", "Test export", &fakeTask{steps: 1}); err != ErrTooManyJobs {
+		t.Errorf("expected ErrTooManyJobs, got %v", err)
+	}
+	if _, err := q.Submit("other", "Test export", &fakeTask{steps: 1}); err != nil {
+		t.Errorf("expected other owner to be able to submit a job, got %v", err)
+	}
+}
+
+func TestPurgeBefore(t *testing.T) {
+	t.Parallel()
+	q := newTestQueue()
+	block := make(chan bool)
+	running, _ := q.Submit("test", "Running export", &fakeTask{steps: 1, block: block})
+	defer close(block)
+	done, _ := q.Submit("test", "Finished export", &fakeTask{steps: 1})
+	waitFinished(t, q, "test", done.ID)
+	cutoff := time.Now().Add(time.Second)
+	if n, _ := q.PurgeBefore(cutoff, true); n != 1 {
+		t.Errorf("expected dry run to count 1 job, got %d", n)
+	}
+	if n, _ := q.PurgeBefore(cutoff, false); n != 1 {
+		t.Errorf("expected to purge 1 job, got %d", n)
+	}
+	if _, err := q.Get("test", done.ID); err != ErrNotFound {
+		t.Errorf("expected finished job to be purged, got %v", err)
+	}
+	if _, err := q.Get("test", running.ID); err != nil {
+		t.Errorf("expected running job to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,233 @@
+// Package logging builds the loggers Logrole uses. Each part of Logrole logs
+// as a module, and each module can log at its own level, so the cache can log
+// every hit and miss while the rest of the server only logs at info. Levels
+// can be changed while the server is running.
+//
+// Loggers are still log15 Loggers; the module is a "module" key in their
+// context.
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/handlers"
+)
+
+// ModuleKey is the context key that holds a logger's module.
+const ModuleKey = "module"
+
+// The modules Logrole logs as. Records without a module, like the request
+// log, use the default level.
+const (
+	ModuleServer = "server"
+	ModuleViews  = "views"
+	ModuleCache  = "cache"
+	ModuleJobs   = "jobs"
+)
+
+// Modules returns the names of every module, in alphabetical order.
+func Modules() []string {
+	return []string{ModuleCache, ModuleJobs, ModuleServer, ModuleViews}
+}
+
+func validModule(name string) bool {
+	for _, m := range Modules() {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// The output formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// levelNames are the names of the levels, indexed by log.Lvl.
+var levelNames = []string{"crit", "error", "warn", "info", "debug"}
+
+// LevelNames returns the names of the levels, most severe first.
+func LevelNames() []string {
+	names := make([]string, len(levelNames))
+	copy(names, levelNames)
+	return names
+}
+
+// ParseLevel parses a level like "debug" or "warn".
+func ParseLevel(name string) (log.Lvl, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, n := range levelNames {
+		if n == name {
+			return log.Lvl(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown log level %q, use one of %s", name, strings.Join(levelNames, ", "))
+}
+
+// LevelName returns the name of lvl, like "debug".
+func LevelName(lvl log.Lvl) string {
+	if int(lvl) < 0 || int(lvl) >= len(levelNames) {
+		return lvl.String()
+	}
+	return levelNames[lvl]
+}
+
+// Module returns a Logger that logs as the named module. If l already has a
+// module, the new one replaces it.
+func Module(l log.Logger, name string) log.Logger {
+	return l.New(ModuleKey, name)
+}
+
+// Levels holds the level each module logs at. It's safe to use from more than
+// one goroutine.
+type Levels struct {
+	mu      sync.RWMutex
+	def     log.Lvl
+	modules map[string]log.Lvl
+}
+
+// NewLevels returns Levels where every module logs at def, except the ones in
+// modules.
+func NewLevels(def log.Lvl, modules map[string]log.Lvl) *Levels {
+	l := &Levels{def: def, modules: make(map[string]log.Lvl, len(modules))}
+	for name, lvl := range modules {
+		l.modules[name] = lvl
+	}
+	return l
+}
+
+// Default returns the level for records without a module, and modules
+// without their own level.
+func (l *Levels) Default() log.Lvl {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.def
+}
+
+// Get returns the level the named module logs at, and whether the module has
+// its own level.
+func (l *Levels) Get(module string) (log.Lvl, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if lvl, ok := l.modules[module]; ok {
+		return lvl, true
+	}
+	return l.def, false
+}
+
+// Set makes the named module log at lvl. If module is empty, Set changes the
+// default level.
+func (l *Levels) Set(module string, lvl log.Lvl) error {
+	if module != "" && !validModule(module) {
+		return fmt.Errorf("Unknown module %q", module)
+	}
+	if int(lvl) < 0 || int(lvl) >= len(levelNames) {
+		return errors.New("Invalid log level")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if module == "" {
+		l.def = lvl
+	} else {
+		l.modules[module] = lvl
+	}
+	return nil
+}
+
+// Reset makes the named module log at the default level.
+func (l *Levels) Reset(module string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.modules, module)
+}
+
+// Overrides returns the names of the modules with their own level, sorted.
+func (l *Levels) Overrides() []string {
+	l.mu.RLock()
+	names := make([]string, 0, len(l.modules))
+	for name := range l.modules {
+		names = append(names, name)
+	}
+	l.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// Handler returns a Handler that passes records to h if their module logs at
+// their level. A record's module is the last one in its context, and any
+// earlier ones are dropped, so a cache created with a server logger logs as
+// module=cache, not as both.
+func (l *Levels) Handler(h log.Handler) log.Handler {
+	return log.FuncHandler(func(r *log.Record) error {
+		module := ""
+		last := -1
+		dupes := 0
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			if k, ok := r.Ctx[i].(string); ok && k == ModuleKey {
+				if last >= 0 {
+					dupes++
+				}
+				module, _ = r.Ctx[i+1].(string)
+				last = i
+			}
+		}
+		lvl, _ := l.Get(module)
+		if r.Lvl > lvl {
+			return nil
+		}
+		if dupes == 0 {
+			return h.Log(r)
+		}
+		r2 := *r
+		r2.Ctx = make([]interface{}, 0, len(r.Ctx)-2*dupes)
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			if k, ok := r.Ctx[i].(string); ok && k == ModuleKey && i != last {
+				continue
+			}
+			r2.Ctx = append(r2.Ctx, r.Ctx[i], r.Ctx[i+1])
+		}
+		return h.Log(&r2)
+	})
+}
+
+// Config configures a Logger.
+type Config struct {
+	Levels *Levels
+	// FormatText or FormatJSON. Defaults to FormatText.
+	Format string
+	// Where JSON output goes. Text output uses the same writer as
+	// handlers.Logger.
+	Output io.Writer
+}
+
+// New returns a Logger that writes records in c.Format, at the levels in
+// c.Levels.
+func New(c Config) (log.Logger, error) {
+	var sink log.Handler
+	switch c.Format {
+	case "", FormatText:
+		// Everything gets through the debug filter; c.Levels decides.
+		sink = handlers.NewLoggerLevel(log.LvlDebug).GetHandler()
+	case FormatJSON:
+		if c.Output == nil {
+			return nil, errors.New("No output configured for JSON logs")
+		}
+		sink = log.StreamHandler(c.Output, log.JsonFormat())
+	default:
+		return nil, fmt.Errorf("Unknown log format %q, use %q or %q", c.Format, FormatText, FormatJSON)
+	}
+	if c.Levels == nil {
+		c.Levels = NewLevels(log.LvlInfo, nil)
+	}
+	l := log.New()
+	l.SetHandler(c.Levels.Handler(sink))
+	return l, nil
+}
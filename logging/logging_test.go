@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	log "github.com/inconshreveable/log15"
+)
+
+func TestLevelsHandler(t *testing.T) {
+	t.Parallel()
+	levels := NewLevels(log.LvlInfo, map[string]log.Lvl{ModuleCache: log.LvlDebug})
+	var records []*log.Record
+	l := log.New()
+	l.SetHandler(levels.Handler(log.FuncHandler(func(r *log.Record) error {
+		records = append(records, r)
+		return nil
+	})))
+	server := Module(l, ModuleServer)
+	cache := Module(server, ModuleCache)
+	server.Debug("hidden")
+	server.Info("shown")
+	cache.Debug("cache hit", "key", "abc")
+	l.Debug("hidden too")
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	ctx := records[1].Ctx
+	if len(ctx) != 4 || ctx[0] != ModuleKey || ctx[1] != ModuleCache || ctx[2] != "key" {
+		t.Errorf("expected only the cache module in the context, got %v", ctx)
+	}
+
+	if err := levels.Set(ModuleServer, log.LvlDebug); err != nil {
+		t.Fatal(err)
+	}
+	server.Debug("shown now")
+	if len(records) != 3 {
+		t.Errorf("expected the new level to apply right away, got %d records", len(records))
+	}
+	if err := levels.Set("nope", log.LvlDebug); err == nil {
+		t.Error("expected an unknown module to be rejected")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	t.Parallel()
+	for i, name := range LevelNames() {
+		lvl, err := ParseLevel(name)
+		if err != nil || lvl != log.Lvl(i) || LevelName(lvl) != name {
+			t.Errorf("ParseLevel(%q): got %v, %v", name, lvl, err)
+		}
+	}
+	if lvl, err := ParseLevel(" WARN "); err != nil || lvl != log.LvlWarn {
+		t.Errorf("expected levels to be case insensitive, got %v, %v", lvl, err)
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("expected an unknown level to be rejected")
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	l, err := New(Config{Format: FormatJSON, Output: buf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Module(l, ModuleJobs).Info("export finished", "rows", 3)
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("expected a JSON object, got %q: %v", buf.String(), err)
+	}
+	if rec["msg"] != "export finished" || rec[ModuleKey] != ModuleJobs || rec["rows"] != float64(3) {
+		t.Errorf("unexpected record: %v", rec)
+	}
+	if _, err := New(Config{Format: "xml"}); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// a2pServer shows the account's A2P 10DLC brands and campaigns, and which
+// numbers are attached to each messaging service, so support can tell if a
+// message failed because of registration.
+type a2pServer struct {
+	log.Logger
+	Finder         views.A2PFinder
+	LocationFinder services.LocationFinder
+	tpl            *template.Template
+}
+
+func newA2PServer(l log.Logger, finder views.A2PFinder, lf services.LocationFinder) (*a2pServer, error) {
+	tpl, err := newTpl(template.FuncMap{
+		"status_class": views.StatusClass,
+	}, base+a2pTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &a2pServer{
+		Logger:         l,
+		Finder:         finder,
+		LocationFinder: lf,
+		tpl:            tpl,
+	}, nil
+}
+
+type a2pData struct {
+	Registrations *views.A2PRegistrations
+	// The number the user asked about, if any, and the services it's
+	// attached to.
+	Number         twilio.PhoneNumber
+	NumberServices []*views.A2PService
+	Loc            *time.Location
+	Err            string
+}
+
+func (d *a2pData) Title() string {
+	return "A2P 10DLC Registration"
+}
+
+func (d *a2pData) Path() string {
+	return "/a2p"
+}
+
+func (s *a2pServer) validParams() []string {
+	return []string{"number"}
+}
+
+func (s *a2pServer) render(w http.ResponseWriter, r *http.Request, code int, bd *baseData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", bd); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// GET /a2p?number=+14105551234
+func (s *a2pServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewMessages() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	start := monotime.Now()
+	query := r.URL.Query()
+	data := &a2pData{Loc: s.LocationFinder.GetLocationReq(r)}
+	bd := &baseData{LF: s.LocationFinder, Data: data}
+	err := validateParams(s.validParams(), query)
+	if err == nil && query.Get("number") != "" {
+		data.Number, err = twilio.NewPhoneNumber(query.Get("number"))
+	}
+	if err != nil {
+		data.Err = cleanError(err)
+		s.render(w, r, http.StatusBadRequest, bd)
+		return
+	}
+	ctx, cancel := getContext(r.Context(), 3*time.Second)
+	defer cancel()
+	regs, err := s.Finder.GetA2PRegistrations(ctx, u)
+	switch {
+	case err == config.PermissionDenied:
+		rest.Forbidden(w, r, &rest.Error{Title: err.Error()})
+		return
+	case err != nil:
+		s.Warn("Couldn't fetch A2P registrations", "err", err)
+		data.Err = "Couldn't load registrations from Twilio: " + err.Error()
+		s.render(w, r, http.StatusBadGateway, bd)
+		return
+	}
+	data.Registrations = regs
+	if data.Number != "" {
+		data.NumberServices = regs.ForNumber(data.Number)
+	}
+	bd.Duration = monotime.Since(start)
+	bd.CachedDuration = regs.Age
+	s.render(w, r, http.StatusOK, bd)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+)
+
+var a2pResponses = map[string]string{
+	"/v1/a2p/BrandRegistrations":          `{"data": [{"sid": "BN123", "status": "APPROVED", "brand_type": "STANDARD", "date_updated": "2016-11-01T17:03:12Z"}], "meta": {}}`,
+	"/v1/Services":                        `{"services": [{"sid": "MG123", "friendly_name": "Notifications"}], "meta": {"next_page_url": null}}`,
+	"/v1/Services/MG123/Compliance/Usa2p": `{"compliance": [{"sid": "QE123", "brand_registration_sid": "BN123", "campaign_status": "FAILED", "us_app_to_person_usecase": "ACCOUNT_NOTIFICATION"}], "meta": {}}`,
+	"/v1/Services/MG123/PhoneNumbers":     `{"phone_numbers": [{"sid": "PN123", "phone_number": "+14105551234"}], "meta": {"next_page_url": null}}`,
+}
+
+func TestA2PPage(t *testing.T) {
+	t.Parallel()
+	ts := harness.NewTwilioServer(&views.Fixtures{})
+	defer ts.Close()
+	for path, body := range a2pResponses {
+		ts.Respond(path, body)
+	}
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: ts.Server, SecretKey: key})
+	s, err := newA2PServer(NullLogger, vc.(views.A2PFinder), lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "/a2p?number=%2B14105551234", nil)
+		req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+		}
+		body := w.Body.String()
+		for _, want := range []string{"Notifications", "The campaign is failed", "label-danger", "ACCOUNT_NOTIFICATION"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("expected body to contain %q, got %s", want, body)
+			}
+		}
+	}
+	if n := len(ts.Requests()); n != 4 {
+		t.Errorf("expected registrations to be fetched once with 4 requests, got %d", n)
+	}
+
+	us := config.AllUserSettings()
+	us.CanViewMessages = false
+	req, _ := http.NewRequest("GET", "/a2p", nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+}
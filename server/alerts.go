@@ -7,13 +7,11 @@ import (
 	"net/url"
 	"regexp"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aristanetworks/goarista/monotime"
 	log "github.com/inconshreveable/log15"
-	types "github.com/kevinburke/go-types"
 	"github.com/kevinburke/rest"
 	"github.com/saintpete/logrole/config"
 	"github.com/saintpete/logrole/services"
@@ -37,7 +35,11 @@ type alertInstanceServer struct {
 	log.Logger
 	Client         views.Client
 	LocationFinder services.LocationFinder
-	tpl            *template.Template
+	// May be nil.
+	Notes *services.NoteStore
+	// Runbook links and notes for error codes. May be nil.
+	Runbooks *config.Runbooks
+	tpl      *template.Template
 }
 
 func halve(firstHalf bool, vals url.Values) map[string]string {
@@ -82,7 +84,7 @@ func newAlertInstanceServer(l log.Logger, vc views.Client, lf services.LocationF
 		"has_prefix":  strings.HasPrefix,
 		"status_text": http.StatusText,
 		"halve":       halve,
-	}, base+alertInstanceTpl+sidTpl)
+	}, base+alertInstanceTpl+sidTpl+notesTpl+runbookTpl)
 	if err != nil {
 		return nil, err
 	}
@@ -97,12 +99,27 @@ func newAlertInstanceServer(l log.Logger, vc views.Client, lf services.LocationF
 type alertInstanceData struct {
 	Alert *views.Alert
 	Loc   *time.Location
+	// nil if the user can't view notes.
+	Notes    *noteData
+	Runbooks *config.Runbooks
 }
 
 func (a *alertInstanceData) Title() string {
 	return "Alert Details"
 }
 
+// alertsResp holds the alerts for a message or call, for the "related-alerts"
+// template.
+type alertsResp struct {
+	// "message" or "call"
+	Noun   string
+	Sid    string
+	Err    error
+	Alerts *views.AlertPage
+	// Runbook links and notes for error codes. May be nil.
+	Runbooks *config.Runbooks
+}
+
 func (s *alertInstanceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	u, ok := config.GetUser(r)
 	if !ok {
@@ -141,10 +158,13 @@ func (s *alertInstanceServer) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	data := &baseData{
 		LF:       s.LocationFinder,
 		Duration: monotime.Since(start),
-		Data: &alertInstanceData{
-			Alert: alert,
-			Loc:   s.LocationFinder.GetLocationReq(r),
-		},
+	}
+	loc := s.LocationFinder.GetLocationReq(r)
+	data.Data = &alertInstanceData{
+		Alert:    alert,
+		Loc:      loc,
+		Notes:    notesFor(s.Notes, u, sid, loc),
+		Runbooks: s.Runbooks,
 	}
 	if err := render(w, r, s.tpl, "base", data); err != nil {
 		rest.ServerError(w, r, err)
@@ -158,7 +178,11 @@ type alertListServer struct {
 	MaxResourceAge time.Duration
 	LocationFinder services.LocationFinder
 	secretKey      *[32]byte
-	tpl            *template.Template
+	// nil if prefetching is disabled.
+	Prefetcher *prefetcher
+	// Runbook links and notes for error codes. May be nil.
+	Runbooks *config.Runbooks
+	tpl      *template.Template
 }
 
 type alertListData struct {
@@ -168,7 +192,16 @@ type alertListData struct {
 	Loc                   *time.Location
 	Query                 url.Values
 	Err                   string
-	Freq                  []*alertFrequency
+	// The age of the oldest alert the user can view.
+	MaxResourceAge time.Duration
+	// Whether the user can export request variables and response bodies.
+	CanExportBodies bool
+	// Whether the user can see webhook uptime, which needs request URLs.
+	CanViewUptime bool
+	// The business hours of the user's group; dates outside them are
+	// shaded. nil if the group doesn't have any.
+	Hours    *config.BusinessHours
+	Runbooks *config.Runbooks
 }
 
 func (ad *alertListData) Title() string {
@@ -197,6 +230,21 @@ func (c *alertListData) NextQuery() template.URL {
 	return template.URL(data.Encode())
 }
 
+// TrendURL returns the URL the alert trend chart loads its counts from, for
+// the same search as the list.
+func (c *alertListData) TrendURL() string {
+	data := url.Values{}
+	for _, param := range []string{"log-level", "resource-sid", "alert-start", "alert-end"} {
+		if val := c.Query.Get(param); val != "" {
+			data.Set(param, val)
+		}
+	}
+	if len(data) == 0 {
+		return "/alerts/trend"
+	}
+	return "/alerts/trend?" + data.Encode()
+}
+
 func (c *alertListData) PreviousQuery() template.URL {
 	data := url.Values{}
 	if c.EncryptedPreviousPage != "" {
@@ -211,33 +259,6 @@ func (c *alertListData) PreviousQuery() template.URL {
 	return template.URL(data.Encode())
 }
 
-type alertFrequency struct {
-	Since    time.Duration
-	Name     string
-	Count    uint
-	HaveMore bool
-}
-
-func getAlertFrequency(alerts []*views.Alert, name string, since time.Duration) *alertFrequency {
-	now := time.Now()
-	count := uint(0)
-	for _, alert := range alerts {
-		createdAt, err := alert.DateCreated()
-		if err != nil {
-			continue
-		}
-		if createdAt.Valid && now.Sub(createdAt.Time) < since {
-			count++
-		}
-	}
-	return &alertFrequency{
-		Name:     name,
-		Count:    count,
-		Since:    since,
-		HaveMore: count > 0 && int(count) >= len(alerts),
-	}
-}
-
 func newAlertListServer(l log.Logger, vc views.Client,
 	lf services.LocationFinder, pageSize uint, maxResourceAge time.Duration,
 	secretKey *[32]byte) (*alertListServer, error) {
@@ -249,13 +270,15 @@ func newAlertListServer(l log.Logger, vc views.Client,
 		MaxResourceAge: maxResourceAge,
 		secretKey:      secretKey,
 	}
+	accounts := listAccounts(vc)
 	tpl, err := newTpl(template.FuncMap{
-		"min":        minFunc(s.MaxResourceAge),
+		"min":        minLoc,
 		"max":        maxLoc,
 		"has_prefix": strings.HasPrefix,
 		"start_val":  s.StartSearchVal,
 		"end_val":    s.EndSearchVal,
-	}, base+alertListTpl+pagingTpl)
+		"accounts":   func() []*views.Account { return accounts },
+	}, base+alertListTpl+pagingTpl+runbookTpl)
 	if err != nil {
 		return nil, err
 	}
@@ -263,15 +286,15 @@ func newAlertListServer(l log.Logger, vc views.Client,
 	return s, nil
 }
 
-func (s *alertListServer) StartSearchVal(query url.Values, loc *time.Location) string {
+func (s *alertListServer) StartSearchVal(query url.Values, maxAge time.Duration, loc *time.Location) string {
 	if start, ok := query["alert-start"]; ok {
 		return start[0]
 	}
-	if s.MaxResourceAge == config.DefaultMaxResourceAge {
+	if maxAge == config.DefaultMaxResourceAge {
 		// one week ago, arbitrary
 		return minLoc(7*24*time.Hour, loc)
 	} else {
-		return minLoc(s.MaxResourceAge, loc)
+		return minLoc(maxAge, loc)
 	}
 }
 
@@ -287,10 +310,11 @@ func (s *alertListServer) renderError(w http.ResponseWriter, r *http.Request, co
 	data := &baseData{
 		LF: s.LocationFinder,
 		Data: &alertListData{
-			Err:   str,
-			Loc:   s.LocationFinder.GetLocationReq(r),
-			Query: query,
-			Page:  new(views.AlertPage),
+			Err:            str,
+			Loc:            s.LocationFinder.GetLocationReq(r),
+			Query:          query,
+			Page:           new(views.AlertPage),
+			MaxResourceAge: userMaxResourceAge(r, s.MaxResourceAge),
 		},
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -302,7 +326,7 @@ func (s *alertListServer) renderError(w http.ResponseWriter, r *http.Request, co
 }
 
 func (s *alertListServer) validParams() []string {
-	return []string{"log-level", "resource-sid", "next", "alert-start", "alert-end"}
+	return []string{"log-level", "resource-sid", "next", "alert-start", "alert-end", "account"}
 }
 
 func (s *alertListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -323,14 +347,16 @@ func (s *alertListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	loc := s.LocationFinder.GetLocationReq(r)
 	// We always set startTime and endTime on the request, though they may end
 	// up just being sentinels
-	startTime, endTime, wroteError := getTimes(w, r, "alert-start", "alert-end", loc, query, s)
+	maxAge := u.MaxResourceAge(s.MaxResourceAge)
+	startTime, endTime, wroteError := getTimes(w, r, "alert-start", "alert-end", loc, maxAge, query, s)
 	if wroteError {
 		return
 	}
 	ctx, cancel := getContext(r.Context(), 3*time.Second)
 	defer cancel()
 	var err error
-	next, nextErr := getNext(query, s.secretKey)
+	scope := pageScope(u, query, "alert-start", "alert-end")
+	next, nextErr := getNext(query, scope, s.secretKey)
 	if nextErr != nil {
 		err = errors.New("Could not decrypt `next` query parameter: " + nextErr.Error())
 		s.renderError(w, r, http.StatusBadRequest, query, err)
@@ -346,10 +372,11 @@ func (s *alertListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		page, cachedAt, err = s.Client.GetNextAlertPageInRange(ctx, u, startTime, endTime, next)
+		s.Prefetcher.Requested("alerts", next)
 		setNextPageValsOnQuery(next, query)
 	} else {
 		vals := url.Values{}
-		vals.Set("PageSize", strconv.FormatUint(uint64(s.PageSize), 10))
+		vals.Set("PageSize", userPageSize(r, u, s.PageSize))
 		if filterErr := setPageFilters(query, vals); filterErr != nil {
 			s.renderError(w, r, http.StatusBadRequest, query, filterErr)
 			return
@@ -377,13 +404,12 @@ func (s *alertListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Fetch the next page into the cache
-	go func(u *config.User, n types.NullString, start, end time.Time) {
-		if n.Valid {
-			if _, _, err := s.Client.GetNextAlertPageInRange(context.Background(), u, start, end, n.String); err != nil {
-				s.Debug("Error fetching next page", "err", err)
-			}
-		}
-	}(u, page.NextPageURI(), startTime, endTime)
+	if n := page.NextPageURI(); n.Valid {
+		s.Prefetcher.Prefetch(services.DetachCallBudget(r.Context()), "alerts", n.String, func(ctx context.Context) error {
+			_, _, err := s.Client.GetNextAlertPageInRange(ctx, u, startTime, endTime, n.String)
+			return err
+		})
+	}
 	data := &baseData{
 		LF:       s.LocationFinder,
 		Duration: monotime.Since(start),
@@ -395,18 +421,13 @@ func (s *alertListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Page:                  page,
 		Query:                 query,
 		Loc:                   s.LocationFinder.GetLocationReq(r),
-		EncryptedNextPage:     getEncryptedPage(page.NextPageURI(), s.secretKey),
-		EncryptedPreviousPage: getEncryptedPage(page.PreviousPageURI(), s.secretKey),
-	}
-	if next == "" {
-		alerts := page.Alerts()
-		freq := []*alertFrequency{
-			getAlertFrequency(alerts, "5 minutes", 5*time.Minute),
-			getAlertFrequency(alerts, "hour", time.Hour),
-			getAlertFrequency(alerts, "day", 24*time.Hour),
-			getAlertFrequency(alerts, "3 days", 3*24*time.Hour),
-		}
-		ad.Freq = freq
+		MaxResourceAge:        maxAge,
+		EncryptedNextPage:     getEncryptedPage(page.NextPageURI(), scope, s.secretKey),
+		EncryptedPreviousPage: getEncryptedPage(page.PreviousPageURI(), scope, s.secretKey),
+		CanExportBodies:       u.CanViewAlertPayloads(),
+		CanViewUptime:         u.CanViewCallbackURLs(),
+		Hours:                 u.BusinessHours(),
+		Runbooks:              s.Runbooks,
 	}
 	data.Data = ad
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -0,0 +1,381 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+// The trend covers this long, ending now, unless the search sets a start.
+const defaultAlertTrendWindow = 3 * 24 * time.Hour
+
+// The longest window the trend will count. Longer searches show the end of
+// the window.
+const maxAlertTrendWindow = 30 * 24 * time.Hour
+
+// The most bars in the chart. Hourly counts are added together into bigger
+// buckets until they fit.
+const maxAlertTrendPoints = 72
+
+// Stop counting after this many pages, and show the counts as lower bounds.
+const maxAlertTrendPages = 100
+
+// How long to reuse the counts for a window. Alerts keep arriving, so this is
+// much shorter than the heatmap's.
+const alertTrendTimeout = 5 * time.Minute
+
+// Counting runs in the background, since a noisy account can take a while.
+const alertTrendCountTimeout = 2 * time.Minute
+
+// The format of the keys of alertTrendCounts, an hour in the user's timezone.
+const alertTrendHourFormat = "2006-01-02T15"
+
+// alertTrendBuckets are the bucket sizes to try, smallest first. Windows too
+// long for a day per bar use multiples of a day.
+var alertTrendBuckets = []time.Duration{
+	time.Hour,
+	2 * time.Hour,
+	3 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+}
+
+// alertTrendCounts are the alerts created in each hour of a window, cached for
+// each user, filter, window and timezone.
+type alertTrendCounts struct {
+	Counts map[string]int
+	// How many of Counts were during business hours.
+	InHours map[string]int
+	// True if we stopped counting before reaching the start of the window.
+	Truncated  bool
+	Err        string
+	ComputedAt time.Time
+}
+
+// An alertTrendPoint is one bar in the chart.
+type alertTrendPoint struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Count   int       `json:"count"`
+	InHours int       `json:"in_hours"`
+	// The start of the bucket in the user's timezone, e.g. "Tue, Oct 18 3pm".
+	Label string `json:"label"`
+	// The list of alerts in the bucket.
+	URL string `json:"url"`
+}
+
+type alertTrendResponse struct {
+	// True while the counts are being computed in the background; ask again
+	// in a few seconds.
+	Counting bool `json:"counting"`
+	// The size of each bucket, e.g. "6 hours".
+	Bucket    string             `json:"bucket,omitempty"`
+	Points    []*alertTrendPoint `json:"points"`
+	Truncated bool               `json:"truncated"`
+	// True if the user's group has business hours.
+	BusinessHours bool      `json:"business_hours"`
+	ComputedAt    time.Time `json:"computed_at"`
+	Err           string    `json:"error,omitempty"`
+}
+
+type alertTrendServer struct {
+	log.Logger
+	Client         views.Client
+	LocationFinder services.LocationFinder
+	MaxResourceAge time.Duration
+	cache          *cache.Cache
+
+	mu sync.Mutex
+	// Keys of trends being counted right now.
+	running map[string]bool
+}
+
+func newAlertTrendServer(l log.Logger, vc views.Client, lf services.LocationFinder, maxResourceAge time.Duration) *alertTrendServer {
+	return &alertTrendServer{
+		Logger:         l,
+		Client:         vc,
+		LocationFinder: lf,
+		MaxResourceAge: maxResourceAge,
+		cache:          cache.NewCache(100, l),
+		running:        make(map[string]bool),
+	}
+}
+
+func (s *alertTrendServer) validParams() []string {
+	return []string{"log-level", "resource-sid", "alert-start", "alert-end"}
+}
+
+func (s *alertTrendServer) renderError(w http.ResponseWriter, r *http.Request, code int, query url.Values, err error) {
+	rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+}
+
+// alertTrendWindow fills in the parts of the search window the user left out,
+// and limits it to the past and to maxAlertTrendWindow. The end is rounded up
+// to the hour, so the window (which is part of the cache key) only changes
+// once an hour.
+func alertTrendWindow(start, end, now time.Time) (time.Time, time.Time) {
+	latest := now.Truncate(time.Hour).Add(time.Hour)
+	if end.After(latest) {
+		end = latest
+	}
+	if start.Equal(twilio.Epoch) {
+		start = end.Add(-defaultAlertTrendWindow)
+	}
+	if end.Sub(start) > maxAlertTrendWindow {
+		start = end.Add(-maxAlertTrendWindow)
+	}
+	return start, end
+}
+
+// GET /alerts/trend?log-level=error&alert-start=2016-10-18T00:00
+//
+// Count the alerts matching the search on the alert list in each hour of the
+// window, and return them as JSON, added together into at most
+// maxAlertTrendPoints buckets. The counts are computed in the background and
+// cached; until they're ready the response has "counting": true.
+func (s *alertTrendServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewAlerts() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	query := r.URL.Query()
+	if err := validateParams(s.validParams(), query); err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	filters := url.Values{}
+	if err := setPageFilters(query, filters); err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	loc := s.LocationFinder.GetLocationReq(r)
+	maxAge := u.MaxResourceAge(s.MaxResourceAge)
+	startTime, endTime, wroteError := getTimes(w, r, "alert-start", "alert-end", loc, maxAge, query, s)
+	if wroteError {
+		return
+	}
+	startTime, endTime = alertTrendWindow(startTime, endTime, time.Now().In(loc))
+	if !startTime.Before(endTime) {
+		rest.BadRequest(w, r, &rest.Error{Title: "The start of the search has to be before the end"})
+		return
+	}
+	key := "alert-trend:" + filters.Encode() + ":" + strconv.FormatInt(startTime.Unix(), 10) + ":" + strconv.FormatInt(endTime.Unix(), 10) + ":" + loc.String() + ":" + u.ID()
+	resp := &alertTrendResponse{BusinessHours: u.BusinessHours() != nil}
+	counts := new(alertTrendCounts)
+	if _, err := s.cache.Get(key, counts); err != nil {
+		s.start(key, u, filters, startTime, endTime)
+		resp.Counting = true
+	} else {
+		bucket, points := buildAlertTrend(counts, startTime, endTime, query)
+		resp.Bucket = friendlyBucket(bucket)
+		resp.Points = points
+		resp.Truncated = counts.Truncated
+		resp.ComputedAt = counts.ComputedAt
+		resp.Err = counts.Err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// start counts the trend for key in the background, unless it's already being
+// counted.
+func (s *alertTrendServer) start(key string, u *config.User, filters url.Values, start, end time.Time) {
+	s.mu.Lock()
+	if s.running[key] {
+		s.mu.Unlock()
+		return
+	}
+	s.running[key] = true
+	s.mu.Unlock()
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.running, key)
+			s.mu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), alertTrendCountTimeout)
+		defer cancel()
+		counts := &alertTrendCounts{
+			Counts:     make(map[string]int),
+			InHours:    make(map[string]int),
+			ComputedAt: time.Now(),
+		}
+		var err error
+		counts.Truncated, err = s.count(ctx, u, filters, start, end, counts)
+		if err != nil {
+			s.Warn("Error counting alert trend", "key", key, "err", err)
+			counts.Err = cleanError(err)
+			// Cache the failure briefly, so the chart stops polling and shows
+			// the error.
+			s.cache.Set(key, counts, 10*time.Second)
+			return
+		}
+		s.cache.Set(key, counts, alertTrendTimeout)
+	}()
+}
+
+// count adds the alerts matching filters and created between start and end to
+// counts, by the hour they were created in start's timezone. It returns true
+// if it stopped after maxAlertTrendPages.
+func (s *alertTrendServer) count(ctx context.Context, u *config.User, filters url.Values, start, end time.Time, counts *alertTrendCounts) (bool, error) {
+	loc := start.Location()
+	hours := u.BusinessHours()
+	data := url.Values{}
+	for k, v := range filters {
+		data[k] = v
+	}
+	data.Set("PageSize", strconv.Itoa(dashboardPageSize))
+	page, _, err := s.Client.GetAlertPageInRange(ctx, u, start, end, data)
+	for pages := 1; ; pages++ {
+		if err == twilio.NoMoreResults {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, alert := range page.Alerts() {
+			created, err := alert.DateCreated()
+			if err != nil || !created.Valid {
+				continue
+			}
+			hour := created.Time.In(loc).Format(alertTrendHourFormat)
+			counts.Counts[hour]++
+			if hours.Contains(created.Time) {
+				counts.InHours[hour]++
+			}
+		}
+		next := page.NextPageURI()
+		if !next.Valid {
+			return false, nil
+		}
+		if pages >= maxAlertTrendPages {
+			return true, nil
+		}
+		page, _, err = s.Client.GetNextAlertPageInRange(ctx, u, start, end, next.String)
+	}
+}
+
+// alertTrendBucket returns the smallest bucket size that fits a window of d
+// into maxAlertTrendPoints bars.
+func alertTrendBucket(d time.Duration) time.Duration {
+	for _, bucket := range alertTrendBuckets {
+		if d <= bucket*maxAlertTrendPoints {
+			return bucket
+		}
+	}
+	day := 24 * time.Hour
+	days := (d + day*maxAlertTrendPoints - 1) / (day * maxAlertTrendPoints)
+	return days * day
+}
+
+// friendlyBucket describes a bucket size, e.g. "6 hours" or "1 day".
+func friendlyBucket(bucket time.Duration) string {
+	n, unit := int(bucket/time.Hour), "hour"
+	if bucket%(24*time.Hour) == 0 {
+		n, unit = int(bucket/(24*time.Hour)), "day"
+	}
+	if n == 1 {
+		return "1 " + unit
+	}
+	return strconv.Itoa(n) + " " + unit + "s"
+}
+
+// bucketStart returns the start of the bucket containing t. Buckets line up
+// with midnight in t's timezone, so a 6 hour bucket starts at midnight, 6am,
+// noon or 6pm, and a day bucket starts at midnight.
+func bucketStart(t time.Time, bucket time.Duration) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if bucket >= 24*time.Hour {
+		return midnight
+	}
+	hours := int(bucket / time.Hour)
+	return midnight.Add(time.Duration(t.Hour()/hours*hours) * time.Hour)
+}
+
+// nextBucket returns the start of the bucket after the one starting at t.
+func nextBucket(t time.Time, bucket time.Duration) time.Time {
+	if bucket >= 24*time.Hour {
+		return t.AddDate(0, 0, int(bucket/(24*time.Hour)))
+	}
+	next := bucketStart(t.Add(bucket), bucket)
+	if !next.After(t) {
+		// The clocks went back, and the hour after t has the same time of
+		// day as t.
+		return t.Add(bucket)
+	}
+	return next
+}
+
+// buildAlertTrend adds the hourly counts between start and end together into
+// buckets, and returns the bucket size and a point for each bucket, oldest
+// first.
+func buildAlertTrend(counts *alertTrendCounts, start, end time.Time, query url.Values) (time.Duration, []*alertTrendPoint) {
+	loc := start.Location()
+	bucket := alertTrendBucket(end.Sub(start))
+	points := make([]*alertTrendPoint, 0, maxAlertTrendPoints+1)
+	for t := bucketStart(start, bucket); t.Before(end); t = nextBucket(t, bucket) {
+		points = append(points, &alertTrendPoint{Start: t, End: nextBucket(t, bucket)})
+	}
+	for hour, count := range counts.Counts {
+		t, err := time.ParseInLocation(alertTrendHourFormat, hour, loc)
+		if err != nil || t.Before(points[0].Start) || !t.Before(points[len(points)-1].End) {
+			continue
+		}
+		i := sort.Search(len(points), func(i int) bool {
+			return t.Before(points[i].End)
+		})
+		points[i].Count += count
+		points[i].InHours += counts.InHours[hour]
+	}
+	labelFormat := "Mon, Jan 2 3pm"
+	if bucket >= 24*time.Hour {
+		labelFormat = "Mon, Jan 2"
+	}
+	for _, point := range points {
+		point.Label = point.Start.Format(labelFormat)
+		point.URL = alertTrendURL(point, start, end, query)
+	}
+	return bucket, points
+}
+
+// alertTrendURL returns the alert list for the part of point inside the
+// window, with the same filters as the trend.
+func alertTrendURL(point *alertTrendPoint, start, end time.Time, query url.Values) string {
+	data := url.Values{}
+	for _, param := range []string{"log-level", "resource-sid"} {
+		if val := query.Get(param); val != "" {
+			data.Set(param, val)
+		}
+	}
+	from, to := point.Start, point.End
+	if from.Before(start) {
+		from = start
+	}
+	if to.After(end) {
+		to = end
+	}
+	data.Set("alert-start", from.Format(HTML5DatetimeLocalFormat))
+	data.Set("alert-end", to.Format(HTML5DatetimeLocalFormat))
+	return "/alerts?" + data.Encode()
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+var alertTrendBucketTests = []struct {
+	window time.Duration
+	bucket time.Duration
+}{
+	{time.Hour, time.Hour},
+	{3 * 24 * time.Hour, time.Hour},
+	{4 * 24 * time.Hour, 2 * time.Hour},
+	{7 * 24 * time.Hour, 3 * time.Hour},
+	{30 * 24 * time.Hour, 12 * time.Hour},
+	{72 * 24 * time.Hour, 24 * time.Hour},
+	{90 * 24 * time.Hour, 48 * time.Hour},
+}
+
+func TestAlertTrendBucket(t *testing.T) {
+	t.Parallel()
+	for _, tt := range alertTrendBucketTests {
+		if bucket := alertTrendBucket(tt.window); bucket != tt.bucket {
+			t.Errorf("alertTrendBucket(%v): got %v, want %v", tt.window, bucket, tt.bucket)
+		}
+	}
+}
+
+func TestAlertTrendWindow(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2016, 10, 18, 15, 20, 0, 0, time.UTC)
+	start, end := alertTrendWindow(twilio.Epoch, twilio.HeatDeath, now)
+	if want := time.Date(2016, 10, 18, 16, 0, 0, 0, time.UTC); !end.Equal(want) {
+		t.Errorf("expected the end to be rounded up to the hour, got %v", end)
+	}
+	if end.Sub(start) != defaultAlertTrendWindow {
+		t.Errorf("expected the default window, got %v", end.Sub(start))
+	}
+	searchEnd := time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC)
+	start, end = alertTrendWindow(searchEnd.AddDate(-1, 0, 0), searchEnd, now)
+	if !end.Equal(searchEnd) || end.Sub(start) != maxAlertTrendWindow {
+		t.Errorf("expected a long search to be cut to the last 30 days, got %v to %v", start, end)
+	}
+}
+
+func TestBuildAlertTrend(t *testing.T) {
+	t.Parallel()
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2016, 10, 10, 5, 30, 0, 0, loc)
+	end := time.Date(2016, 10, 17, 5, 30, 0, 0, loc)
+	counts := &alertTrendCounts{
+		Counts: map[string]int{
+			"2016-10-10T05": 2,
+			"2016-10-10T06": 3,
+			"2016-10-10T07": 1,
+			"2016-10-17T05": 4,
+			// Outside the window.
+			"2016-10-01T00": 100,
+		},
+		InHours: map[string]int{"2016-10-10T07": 1},
+	}
+	query := url.Values{"log-level": []string{"error"}}
+	bucket, points := buildAlertTrend(counts, start, end, query)
+	if bucket != 3*time.Hour {
+		t.Fatalf("expected 3 hour buckets for a week, got %v", bucket)
+	}
+	if len(points) != 57 {
+		t.Fatalf("expected 57 points, got %d", len(points))
+	}
+	first := points[0]
+	if !first.Start.Equal(time.Date(2016, 10, 10, 3, 0, 0, 0, loc)) {
+		t.Errorf("expected the first bucket to line up with midnight, got %v", first.Start)
+	}
+	if first.Count != 2 || points[1].Count != 4 || points[1].InHours != 1 {
+		t.Errorf("bad counts: %d, %d (%d in hours)", first.Count, points[1].Count, points[1].InHours)
+	}
+	if last := points[len(points)-1]; last.Count != 4 {
+		t.Errorf("expected the last bucket to count the last hour, got %d", last.Count)
+	}
+	if first.Label != "Mon, Oct 10 3am" {
+		t.Errorf("bad label: %q", first.Label)
+	}
+	u, err := url.Parse(first.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := u.Query(); q.Get("alert-start") != "2016-10-10T05:30" || q.Get("alert-end") != "2016-10-10T06:00" || q.Get("log-level") != "error" {
+		t.Errorf("expected the first link to start at the start of the window, got %q", first.URL)
+	}
+}
+
+func TestAlertTrendServer(t *testing.T) {
+	t.Parallel()
+	created := twilio.TwilioTime{Valid: true, Time: time.Now().UTC().Add(-2 * time.Hour)}
+	vc := harness.FixtureClient(harness.ViewHarness{MaxResourceAge: 1000 * 1000 * time.Hour}, &views.Fixtures{
+		Alerts: []*twilio.Alert{
+			{Sid: "NO1", ErrorCode: 11200, LogLevel: twilio.LogLevelError, DateCreated: created},
+			{Sid: "NO2", ErrorCode: 11200, LogLevel: twilio.LogLevelError, DateCreated: created},
+		},
+	})
+	s := newAlertTrendServer(dlog, vc, lf, 1000*1000*time.Hour)
+
+	req, _ := http.NewRequest("GET", "/alerts/trend", nil)
+	req = config.SetUser(req, config.NewUser(&config.UserSettings{CanViewMessages: true}))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected users who can't see alerts to get 403, got %d", w.Code)
+	}
+
+	admin := config.NewUser(config.AllUserSettings())
+	var resp alertTrendResponse
+	for i := 0; i < 100; i++ {
+		req, _ = http.NewRequest("GET", "/alerts/trend?log-level=error", nil)
+		req = config.SetUser(req, admin)
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+		}
+		resp = alertTrendResponse{}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if !resp.Counting {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if resp.Counting {
+		t.Fatal("counting never finished")
+	}
+	if resp.Err != "" || resp.Bucket != "1 hour" {
+		t.Fatalf("expected hourly counts, got %#v", resp)
+	}
+	total := 0
+	for _, p := range resp.Points {
+		total += p.Count
+		if p.Count > 0 && !strings.Contains(p.URL, "log-level=error") {
+			t.Errorf("expected the bar to link to the same search, got %q", p.URL)
+		}
+	}
+	if total != 2 {
+		t.Errorf("expected 2 alerts, got %d", total)
+	}
+}
@@ -0,0 +1,357 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+// Messages waiting to be indexed beyond this many are dropped, and indexed
+// the next time someone views them.
+const attachmentQueueSize = 100
+
+// Attachments bigger than this aren't indexed.
+const maxAttachmentBytes = 10 * 1024 * 1024
+
+// How long to spend fetching and extracting the attachments of one message.
+const attachmentExtractTimeout = 2 * time.Minute
+
+// Show at most this many messages that match a search, and look them up this
+// many at a time.
+const maxAttachmentSearchResults = 50
+const attachmentSearchConcurrency = 5
+
+// Searches shorter than this match too many messages to be useful.
+const minAttachmentQueryLength = 3
+
+// extractable reports whether an attachment with the given Content-Type is
+// worth sending to the text extractor.
+func extractable(ctype string) bool {
+	mtype := strings.ToLower(strings.TrimSpace(strings.Split(ctype, ";")[0]))
+	return strings.HasPrefix(mtype, "image/") || mtype == "application/pdf"
+}
+
+type attachmentTask struct {
+	MessageSid string
+	URLs       []*url.URL
+}
+
+// attachmentIndexer finds the text in the attachments of messages people
+// view, in the background, and adds it to the attachment text store. A nil
+// attachmentIndexer indexes nothing, which is how indexing is disabled.
+type attachmentIndexer struct {
+	log.Logger
+	Extractor services.TextExtractor
+	Store     *services.AttachmentTextStore
+	// If set, attachments are read from the media cache when they're in it.
+	Blobs *cache.BlobStore
+	// If set, flagged attachments aren't indexed, so their text can't be
+	// found by searching.
+	Scanner   services.MediaScanner
+	secretKey *[32]byte
+
+	queue chan *attachmentTask
+	mu    sync.Mutex
+	// Messages in the queue.
+	queued map[string]bool
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newAttachmentIndexer(l log.Logger, extractor services.TextExtractor, store *services.AttachmentTextStore, blobs *cache.BlobStore, scanner services.MediaScanner, secretKey *[32]byte) *attachmentIndexer {
+	return &attachmentIndexer{
+		Logger:    l,
+		Extractor: extractor,
+		Store:     store,
+		Blobs:     blobs,
+		Scanner:   scanner,
+		secretKey: secretKey,
+		queue:     make(chan *attachmentTask, attachmentQueueSize),
+		queued:    make(map[string]bool),
+		done:      make(chan struct{}),
+	}
+}
+
+// Index queues the attachments of the message with the given sid to be
+// indexed, unless they already have been. urls are the /images URLs the
+// message page shows to u. It never blocks; if the queue is full, the
+// message isn't indexed.
+func (i *attachmentIndexer) Index(u *config.User, sid string, urls []*url.URL) {
+	if i == nil || len(urls) == 0 || i.Store.Has(sid) {
+		return
+	}
+	t := &attachmentTask{MessageSid: sid, URLs: make([]*url.URL, 0, len(urls))}
+	for _, opaque := range urls {
+		enc := strings.TrimPrefix(opaque.Path, "/images/")
+		str, err := services.UnopaqueFor(enc, u.ID(), i.secretKey)
+		if err != nil {
+			i.Warn("Couldn't decode media URL to index", "sid", sid, "err", err)
+			return
+		}
+		mediaURL, err := url.Parse(str)
+		if err != nil {
+			return
+		}
+		t.URLs = append(t.URLs, mediaURL)
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.queued[sid] {
+		return
+	}
+	select {
+	case i.queue <- t:
+		i.queued[sid] = true
+	default:
+		i.Debug("Attachment index queue is full, dropping message", "sid", sid)
+	}
+}
+
+// fetch returns an attachment and its Content-Type, from the media cache if
+// it's there.
+func (i *attachmentIndexer) fetch(ctx context.Context, u *url.URL) ([]byte, string, error) {
+	if i.Blobs != nil {
+		if data, ctype, ok := i.Blobs.Get(mediaCacheKey(u)); ok {
+			return data, ctype, nil
+		}
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req = req.WithContext(ctx)
+	resp, err := twilio.MediaClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("Fetching media returned status %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxAttachmentBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxAttachmentBytes {
+		return nil, "", nil
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// run extracts the text of each of the message's attachments that can have
+// text in it, and stores it. If any attachment fails, nothing is stored, so
+// the message is tried again the next time it's viewed.
+func (i *attachmentIndexer) run(t *attachmentTask) {
+	defer func() {
+		i.mu.Lock()
+		delete(i.queued, t.MessageSid)
+		i.mu.Unlock()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), attachmentExtractTimeout)
+	defer cancel()
+	texts := make([]string, 0, len(t.URLs))
+	for _, u := range t.URLs {
+		data, ctype, err := i.fetch(ctx, u)
+		if err != nil {
+			i.Warn("Couldn't fetch attachment to index", "sid", t.MessageSid, "err", err)
+			return
+		}
+		if data == nil || !extractable(ctype) {
+			continue
+		}
+		if i.Scanner != nil {
+			result, err := i.Scanner.Scan(ctx, ctype, data)
+			if err != nil {
+				i.Warn("Couldn't scan attachment to index", "sid", t.MessageSid, "err", err)
+				return
+			}
+			if result.Flagged {
+				continue
+			}
+		}
+		text, err := i.Extractor.Extract(ctx, ctype, data)
+		if err != nil {
+			i.Warn("Couldn't extract attachment text", "sid", t.MessageSid, "err", err)
+			return
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	err := i.Store.Add(&services.AttachmentText{
+		MessageSid: t.MessageSid,
+		Text:       strings.Join(texts, "\n\n"),
+		Indexed:    time.Now(),
+	})
+	if err != nil {
+		i.Warn("Couldn't save attachment text", "sid", t.MessageSid, "err", err)
+		return
+	}
+	i.Debug("Indexed attachment text", "sid", t.MessageSid, "attachments", len(t.URLs))
+}
+
+// Run indexes queued messages one at a time until Stop is called. Messages
+// still in the queue are dropped.
+func (i *attachmentIndexer) Run() {
+	for {
+		select {
+		case <-i.done:
+			return
+		case t := <-i.queue:
+			i.run(t)
+		}
+	}
+}
+
+func (i *attachmentIndexer) Stop() {
+	i.stopOnce.Do(func() { close(i.done) })
+}
+
+type attachmentSearchServer struct {
+	log.Logger
+	Client         views.Client
+	Store          *services.AttachmentTextStore
+	LocationFinder services.LocationFinder
+	tpl            *template.Template
+}
+
+func newAttachmentSearchServer(l log.Logger, vc views.Client, store *services.AttachmentTextStore, lf services.LocationFinder, labels *services.LabelStore, owners *services.OwnerStore) (*attachmentSearchServer, error) {
+	tpl, err := newTpl(template.FuncMap{
+		"is_our_pn": vc.IsTwilioNumber,
+		"pn_label":  labels.Get,
+		"pn_owner":  owners.Get,
+	}, base+attachmentSearchTpl+phoneTpl+copyScript)
+	if err != nil {
+		return nil, err
+	}
+	return &attachmentSearchServer{
+		Logger:         l,
+		Client:         vc,
+		Store:          store,
+		LocationFinder: lf,
+		tpl:            tpl,
+	}, nil
+}
+
+type attachmentMatch struct {
+	Message *views.Message
+	Snippet string
+}
+
+type attachmentSearchData struct {
+	Query string
+	Loc   *time.Location
+	// The store only holds attachments of messages someone has viewed.
+	Indexed int
+	Matches []*attachmentMatch
+	// More messages matched than are shown.
+	Full bool
+	Err  string
+}
+
+func (d *attachmentSearchData) Title() string {
+	return "Search Attachments"
+}
+
+// GET /search/attachments?q=<text>
+//
+// Find messages whose attachments contain the text. Each match is looked up
+// as the user, so they only see messages they could view anyway.
+func (s *attachmentSearchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewMedia() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to view attachments"})
+		return
+	}
+	data := &attachmentSearchData{
+		Query:   strings.TrimSpace(r.URL.Query().Get("q")),
+		Loc:     s.LocationFinder.GetLocationReq(r),
+		Indexed: s.Store.Len(),
+	}
+	code := http.StatusOK
+	switch {
+	case data.Query == "":
+	case len(data.Query) < minAttachmentQueryLength:
+		data.Err = fmt.Sprintf("Search for at least %d characters", minAttachmentQueryLength)
+		code = http.StatusBadRequest
+	default:
+		ctx, cancel := getContext(r.Context(), 10*time.Second)
+		defer cancel()
+		matches, err := s.search(ctx, u, data)
+		data.Matches = matches
+		if err != nil {
+			data.Err = "Couldn't look up some messages: " + cleanError(err)
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (s *attachmentSearchServer) search(ctx context.Context, u *config.User, data *attachmentSearchData) ([]*attachmentMatch, error) {
+	texts := s.Store.Search(data.Query, maxAttachmentSearchResults+1)
+	if len(texts) > maxAttachmentSearchResults {
+		texts = texts[:maxAttachmentSearchResults]
+		data.Full = true
+	}
+	matches := make([]*attachmentMatch, len(texts))
+	var mu sync.Mutex
+	var firstErr error
+	var g errgroup.Group
+	sem := make(chan struct{}, attachmentSearchConcurrency)
+	for i, at := range texts {
+		i, at := i, at
+		g.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			message, err := s.Client.GetMessage(ctx, u, at.MessageSid)
+			switch {
+			case err == config.PermissionDenied || err == config.ErrTooOld:
+			case err != nil:
+				if terr, ok := err.(*rest.Error); ok && terr.StatusCode == 404 {
+					break
+				}
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			case message.CanViewMedia():
+				matches[i] = &attachmentMatch{Message: message, Snippet: at.Snippet(data.Query)}
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	found := matches[:0]
+	for _, match := range matches {
+		if match != nil {
+			found = append(found, match)
+		}
+	}
+	return found, firstErr
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+const attachmentSid = "MM55555555555555555555555555555555"
+
+type fakeExtractor struct {
+	contentTypes []string
+}
+
+func (f *fakeExtractor) Extract(ctx context.Context, contentType string, data []byte) (string, error) {
+	f.contentTypes = append(f.contentTypes, contentType)
+	return "  " + string(data) + "\n", nil
+}
+
+// newTestAttachmentSearchServer searches one indexed message, which was
+// delivered.
+func newTestAttachmentSearchServer(t *testing.T) *attachmentSearchServer {
+	vc := harness.FixtureClient(harness.ViewHarness{}, &views.Fixtures{Messages: []*twilio.Message{{
+		Sid:         attachmentSid,
+		To:          "+14105551234",
+		Status:      twilio.StatusDelivered,
+		Direction:   twilio.DirectionOutboundAPI,
+		NumSegments: 1,
+		DateCreated: twilio.TwilioTime{Valid: true, Time: time.Now().UTC()},
+	}}})
+	store, _ := services.NewAttachmentTextStore("")
+	store.Add(&services.AttachmentText{MessageSid: attachmentSid, Text: "INVOICE #4471\nTotal due: $120", Indexed: time.Now()})
+	s, err := newAttachmentSearchServer(NullLogger, vc, store, lf, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAttachmentSearch(t *testing.T) {
+	t.Parallel()
+	s := newTestAttachmentSearchServer(t)
+	req, _ := http.NewRequest("GET", "/search/attachments?q=invoice", nil)
+	req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `href="/messages/`+attachmentSid+`"`) {
+		t.Errorf("expected a link to the matching message, got %s", body)
+	}
+	if !strings.Contains(body, "Total due: $120") {
+		t.Errorf("expected the matching text, got %s", body)
+	}
+}
+
+func TestAttachmentSearchForbidden(t *testing.T) {
+	t.Parallel()
+	s := newTestAttachmentSearchServer(t)
+	us := config.AllUserSettings()
+	us.CanViewMedia = false
+	req, _ := http.NewRequest("GET", "/search/attachments?q=invoice", nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected 403, got %d", w.Code)
+	}
+}
+
+func TestAttachmentIndexer(t *testing.T) {
+	t.Parallel()
+	media := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".mp3") {
+			w.Header().Set("Content-Type", "audio/mpeg")
+			w.Write([]byte("not text"))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("Receipt: $42"))
+	}))
+	defer media.Close()
+	store, _ := services.NewAttachmentTextStore("")
+	extractor := new(fakeExtractor)
+	i := newAttachmentIndexer(NullLogger, extractor, store, nil, nil, key)
+	u := config.NewUser(config.AllUserSettings())
+	var urls []*url.URL
+	for _, path := range []string{"/receipt.png", "/voicemail.mp3"} {
+		enc := services.StableOpaqueFor(media.URL+path, u.ID(), key)
+		opaque, _ := url.Parse("/images/" + enc)
+		urls = append(urls, opaque)
+	}
+	i.Index(u, attachmentSid, urls)
+	i.Index(u, attachmentSid, urls)
+	if len(i.queue) != 1 {
+		t.Fatalf("expected the message to be queued once, got %d", len(i.queue))
+	}
+	i.run(<-i.queue)
+	if len(extractor.contentTypes) != 1 || extractor.contentTypes[0] != "image/png" {
+		t.Errorf("expected only the image to be extracted, got %v", extractor.contentTypes)
+	}
+	results := store.Search("receipt", 10)
+	if len(results) != 1 || results[0].MessageSid != attachmentSid || results[0].Text != "Receipt: $42" {
+		t.Fatalf("expected the image text to be indexed, got %v", results)
+	}
+	i.Index(u, attachmentSid, urls)
+	if len(i.queue) != 0 {
+		t.Errorf("expected an indexed message not to be queued again")
+	}
+}
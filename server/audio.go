@@ -1,24 +1,36 @@
 package server
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"regexp"
+	"time"
 
+	"github.com/kevinburke/handlers"
+	"github.com/kevinburke/rest"
 	twilio "github.com/saintpete/twilio-go"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
 	"github.com/saintpete/logrole/views"
+	"golang.org/x/net/context"
 )
 
 type audioServer struct {
-	Client    views.Client
-	Proxy     *httputil.ReverseProxy
+	Client views.Client
+	Proxy  *httputil.ReverseProxy
+	// If nil, recordings are proxied to Twilio on every request.
+	Blobs *cache.BlobStore
+	// Fetches recordings from Twilio. If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
 	secretKey *[32]byte
 }
 
 var audioRoute = regexp.MustCompile("^/audio/(?P<encrypted>([-_a-zA-Z0-9=]+))$")
 
-func newAudioReverseProxy() (*httputil.ReverseProxy, error) {
+func newAudioReverseProxy(transport http.RoundTripper) (*httputil.ReverseProxy, error) {
 	u, err := url.Parse(twilio.BaseURL)
 	if err != nil {
 		return nil, err
@@ -28,21 +40,36 @@ func newAudioReverseProxy() (*httputil.ReverseProxy, error) {
 			r.URL.Host = u.Host
 			r.URL.Scheme = "https"
 		},
+		Transport: transport,
 	}, nil
 }
 
 // GET /audio/<encrypted URL>
 //
 // Decode the encrypted URL, then make a request to retrieve the resource in
-// question and forward it to the frontend.
-//
-// TODO: add some sort of caching layer, since the images are not changing.
+// question and forward it to the frontend. If a media cache is configured,
+// the whole recording is downloaded and cached the first time it's requested;
+// if that fails we fall back to proxying the request.
 func (a *audioServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, ok := config.GetUser(r); ok && !user.CanPlayRecordings() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
 	encoded := audioRoute.FindStringSubmatch(r.URL.Path)[1]
 	u, wroteError := decryptURL(w, r, encoded, a.secretKey)
 	if wroteError {
 		return
 	}
+	if a.Blobs != nil {
+		ctx, cancel := getContext(r.Context(), 5*time.Second)
+		data, ctype, err := a.load(ctx, u)
+		cancel()
+		if err == nil {
+			serveCachedMedia(w, r, ctype, data)
+			return
+		}
+		handlers.Logger.Warn("Could not download recording for media cache", "err", err)
+	}
 	// Note this also rewrites the path in the logs, but that's probably OK,
 	// since only admins have access to the server logs.
 	r.URL.Path = u.Path
@@ -50,3 +77,61 @@ func (a *audioServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	a.Client.SetBasicAuth(r)
 	a.Proxy.ServeHTTP(w, r)
 }
+
+// load returns the recording at u from the media cache, if there is one, or
+// downloads it from Twilio and caches it.
+func (a *audioServer) load(ctx context.Context, u *url.URL) ([]byte, string, error) {
+	if a.Blobs == nil {
+		return a.fetch(ctx, u)
+	}
+	key := mediaCacheKey(u)
+	if data, ctype, ok := a.Blobs.Get(key); ok {
+		return data, ctype, nil
+	}
+	data, ctype, err := a.fetch(ctx, u)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := a.Blobs.Put(key, ctype, data); err != nil && err != cache.ErrTooLarge {
+		handlers.Logger.Warn("Could not store recording in media cache", "err", err)
+	}
+	return data, ctype, nil
+}
+
+// fetch downloads the entire recording at u from the Twilio API.
+func (a *audioServer) fetch(ctx context.Context, u *url.URL) ([]byte, string, error) {
+	base, err := url.Parse(twilio.BaseURL)
+	if err != nil {
+		return nil, "", err
+	}
+	fetchURL := &url.URL{
+		Scheme:   base.Scheme,
+		Host:     base.Host,
+		Path:     u.Path,
+		RawQuery: u.RawQuery,
+	}
+	req, err := http.NewRequest("GET", fetchURL.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req = req.WithContext(ctx)
+	a.Client.SetBasicAuth(req)
+	client := &http.Client{Transport: a.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("Twilio returned status %d for recording", resp.StatusCode)
+	}
+	ctype := resp.Header.Get("Content-Type")
+	if ctype == "" {
+		ctype = "audio/x-wav"
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, ctype, nil
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+// blocklistServer lists, blocks and unblocks the numbers whose traffic is
+// hidden from everyone outside the blocklist's group. Only members of that
+// group can use it; everyone else gets a 404, so they can't tell whether
+// anything is blocked.
+type blocklistServer struct {
+	log.Logger
+	Blocklist      *config.Blocklist
+	Audit          *services.AuditLog
+	LocationFinder services.LocationFinder
+	tpl            *template.Template
+}
+
+func newBlocklistServer(l log.Logger, b *config.Blocklist, audit *services.AuditLog, lf services.LocationFinder) (*blocklistServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+blocklistTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &blocklistServer{
+		Logger:         l,
+		Blocklist:      b,
+		Audit:          audit,
+		LocationFinder: lf,
+		tpl:            tpl,
+	}, nil
+}
+
+type blocklistData struct {
+	Numbers []*config.BlockedNumber
+	Group   string
+	Loc     *time.Location
+	Err     string
+	// Form value, so it isn't lost after an error.
+	PhoneNumber string
+}
+
+func (d *blocklistData) Title() string {
+	return "Blocked Numbers"
+}
+
+func (s *blocklistServer) renderList(w http.ResponseWriter, r *http.Request, code int, data *blocklistData) {
+	data.Numbers = s.Blocklist.Numbers()
+	data.Group = s.Blocklist.Group()
+	data.Loc = s.LocationFinder.GetLocationReq(r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (s *blocklistServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !s.Blocklist.Exempt(u) {
+		rest.NotFound(w, r)
+		return
+	}
+	if r.Method == "GET" {
+		s.renderList(w, r, http.StatusOK, &blocklistData{})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.renderList(w, r, http.StatusBadRequest, &blocklistData{Err: err.Error()})
+		return
+	}
+	pn := r.PostForm.Get("phone_number")
+	action := "block_number"
+	var err error
+	if r.URL.Path == "/admin/blocklist/remove" {
+		action = "unblock_number"
+		err = s.Blocklist.Remove(pn)
+	} else {
+		_, err = s.Blocklist.Add(pn, u.ID())
+	}
+	if err != nil {
+		s.renderList(w, r, http.StatusBadRequest, &blocklistData{Err: err.Error(), PhoneNumber: pn})
+		return
+	}
+	s.Audit.Record(&services.AuditEvent{
+		User:     u.ID(),
+		Action:   action,
+		Resource: pn,
+	})
+	http.Redirect(w, r, "/admin/blocklist", http.StatusFound)
+}
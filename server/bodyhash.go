@@ -0,0 +1,247 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+// Pages of messages waiting to be hashed beyond this many are dropped, and
+// hashed the next time someone views them.
+const bodyQueueSize = 100
+
+// Show at most this many duplicate bodies, and look up the message that
+// shows what each one says this many at a time.
+const maxDuplicateBodies = 50
+const duplicateLookupConcurrency = 5
+
+type bodyTask struct {
+	MessageSid string
+	Body       string
+	To         string
+	Created    time.Time
+}
+
+// bodyIndexer hashes the bodies of outbound messages people view, in the
+// background, and counts them in the body hash store. A nil bodyIndexer
+// hashes nothing, which is how body hashing is disabled.
+type bodyIndexer struct {
+	log.Logger
+	Store *services.BodyHashStore
+
+	queue    chan []*bodyTask
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newBodyIndexer(l log.Logger, store *services.BodyHashStore) *bodyIndexer {
+	return &bodyIndexer{
+		Logger: l,
+		Store:  store,
+		queue:  make(chan []*bodyTask, bodyQueueSize),
+		done:   make(chan struct{}),
+	}
+}
+
+// Index queues the bodies of the messages to be hashed, skipping inbound
+// messages, messages that have already been counted, and ones the viewer
+// can't see the body or recipient of. It never blocks; if the queue is full,
+// the messages aren't hashed.
+func (i *bodyIndexer) Index(messages []*views.Message) {
+	if i == nil {
+		return
+	}
+	tasks := make([]*bodyTask, 0, len(messages))
+	for _, m := range messages {
+		sid, err := m.Sid()
+		if err != nil || i.Store.Has(sid) {
+			continue
+		}
+		if direction, err := m.Direction(); err != nil || direction == twilio.DirectionInbound {
+			continue
+		}
+		body, err := m.Body()
+		if err != nil || strings.TrimSpace(body) == "" {
+			continue
+		}
+		to, err := m.To()
+		if err != nil {
+			continue
+		}
+		created, err := m.DateCreated()
+		if err != nil || !created.Valid {
+			continue
+		}
+		tasks = append(tasks, &bodyTask{MessageSid: sid, Body: body, To: string(to), Created: created.Time})
+	}
+	if len(tasks) == 0 {
+		return
+	}
+	select {
+	case i.queue <- tasks:
+	default:
+		i.Debug("Body hash queue is full, dropping messages", "count", len(tasks))
+	}
+}
+
+func (i *bodyIndexer) run(tasks []*bodyTask) {
+	for _, t := range tasks {
+		i.Store.Add(t.MessageSid, services.HashBody(t.Body), t.To, t.Created)
+	}
+}
+
+// Run hashes queued messages until Stop is called. Messages still in the
+// queue are dropped.
+func (i *bodyIndexer) Run() {
+	for {
+		select {
+		case <-i.done:
+			return
+		case tasks := <-i.queue:
+			i.run(tasks)
+		}
+	}
+}
+
+func (i *bodyIndexer) Stop() {
+	i.stopOnce.Do(func() { close(i.done) })
+}
+
+type duplicateServer struct {
+	log.Logger
+	Client         views.Client
+	Store          *services.BodyHashStore
+	LocationFinder services.LocationFinder
+	tpl            *template.Template
+}
+
+func newDuplicateServer(l log.Logger, vc views.Client, store *services.BodyHashStore, lf services.LocationFinder) (*duplicateServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+duplicateTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &duplicateServer{
+		Logger:         l,
+		Client:         vc,
+		Store:          store,
+		LocationFinder: lf,
+		tpl:            tpl,
+	}, nil
+}
+
+type duplicateBody struct {
+	*services.BodyHash
+	// The most recent message with the body, if the viewer can see it.
+	Message *views.Message
+}
+
+// Body returns what the body says, if the viewer can see it.
+func (d *duplicateBody) Body() string {
+	if d.Message == nil {
+		return ""
+	}
+	body, err := d.Message.Body()
+	if err != nil {
+		return ""
+	}
+	return body
+}
+
+type duplicateData struct {
+	Duplicates []*duplicateBody
+	// How many messages have been counted.
+	Counted    int
+	Recipients int
+	Window     time.Duration
+	Loc        *time.Location
+	Err        string
+}
+
+func (d *duplicateData) Title() string {
+	return "Duplicate Content"
+}
+
+func (d *duplicateData) Path() string {
+	return "/messages/duplicates"
+}
+
+// GET /messages/duplicates
+//
+// List the message bodies that went to more than one number, with the ones
+// that went to lots of numbers at once first.
+func (s *duplicateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewMessages() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to view messages"})
+		return
+	}
+	data := &duplicateData{
+		Counted:    s.Store.Len(),
+		Recipients: s.Store.Recipients,
+		Window:     s.Store.Window,
+		Loc:        s.LocationFinder.GetLocationReq(r),
+	}
+	ctx, cancel := getContext(r.Context(), 10*time.Second)
+	defer cancel()
+	dups, err := s.lookup(ctx, u, s.Store.Duplicates(maxDuplicateBodies))
+	data.Duplicates = dups
+	if err != nil {
+		data.Err = "Couldn't look up some messages: " + cleanError(err)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// lookup finds the most recent message with each body as the user, so they
+// only see what it says if they could view the message anyway.
+func (s *duplicateServer) lookup(ctx context.Context, u *config.User, hashes []*services.BodyHash) ([]*duplicateBody, error) {
+	dups := make([]*duplicateBody, len(hashes))
+	var mu sync.Mutex
+	var firstErr error
+	var g errgroup.Group
+	sem := make(chan struct{}, duplicateLookupConcurrency)
+	for i, bh := range hashes {
+		i, bh := i, bh
+		dups[i] = &duplicateBody{BodyHash: bh}
+		g.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			message, err := s.Client.GetMessage(ctx, u, bh.SampleSid)
+			switch {
+			case err == config.PermissionDenied || err == config.ErrTooOld:
+			case err != nil:
+				if terr, ok := err.(*rest.Error); ok && terr.StatusCode == 404 {
+					break
+				}
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			default:
+				dups[i].Message = message
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	return dups, firstErr
+}
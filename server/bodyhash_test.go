@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+func duplicateFixtures() *views.Fixtures {
+	message := func(sid string, sec int, direction twilio.Direction, body string, from, to twilio.PhoneNumber) *twilio.Message {
+		status := twilio.StatusDelivered
+		if direction == twilio.DirectionInbound {
+			status = twilio.StatusReceived
+		}
+		return &twilio.Message{
+			Sid:         sid,
+			Body:        body,
+			From:        from,
+			To:          to,
+			Status:      status,
+			Direction:   direction,
+			DateCreated: twilio.TwilioTime{Valid: true, Time: time.Date(2016, 10, 20, 21, 13, sec, 0, time.UTC)},
+		}
+	}
+	return &views.Fixtures{Messages: []*twilio.Message{
+		message("SM1", 2, twilio.DirectionOutboundAPI, "claim your  PRIZE", "+14105551234", "+14155551234"),
+		message("SM2", 1, twilio.DirectionOutboundAPI, "Claim your prize", "+14105551234", "+14155551235"),
+		message("SM3", 0, twilio.DirectionInbound, "Claim your prize", "+14155551236", "+14105551234"),
+		message("MM4", -1, twilio.DirectionOutboundAPI, "", "+14105551234", "+14155551237"),
+	}}
+}
+
+func TestDuplicateBodies(t *testing.T) {
+	t.Parallel()
+	vc := harness.FixtureClient(harness.ViewHarness{MaxResourceAge: 1000 * 1000 * time.Hour}, duplicateFixtures())
+	page, _, err := vc.GetMessagePageInRange(context.Background(), theUser, twilio.Epoch, twilio.HeatDeath, url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := services.NewBodyHashStore(2, time.Minute)
+	i := newBodyIndexer(dlog, store)
+	i.Index(page.Messages())
+	i.run(<-i.queue)
+	if store.Len() != 2 {
+		t.Fatalf("expected only the outbound messages with a body to be counted, got %d", store.Len())
+	}
+	// Messages that have been counted aren't queued again.
+	i.Index(page.Messages())
+	if len(i.queue) != 0 {
+		t.Errorf("expected counted messages to be skipped, got %d queued", len(i.queue))
+	}
+
+	s, err := newDuplicateServer(dlog, vc, store, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/messages/duplicates", nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Claim your prize") || !strings.Contains(body, "Burst") {
+		t.Errorf("expected the flagged body in the report, got %s", body)
+	}
+
+	req, _ = http.NewRequest("GET", "/messages/duplicates", nil)
+	req = config.SetUser(req, config.NewUser(&config.UserSettings{CanViewCalls: true}))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected users who can't see messages to get 403, got %d", w.Code)
+	}
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"golang.org/x/net/context"
+)
+
+// The shortest and longest justification someone can give for breaking the
+// glass. It goes in the notification, so it has to say what the emergency is.
+const minBreakGlassReason = 10
+const maxBreakGlassReason = 500
+
+const breakGlassNotifyTimeout = 10 * time.Second
+
+var breakGlassKey ctxVar = 4
+
+// withBreakGlass sets b in the context of every request, so the base template
+// can link to it, and records every request made with emergency access in
+// the audit log. It runs after withGrants, and after withViewAs, since an
+// admin viewing the site as someone else has their permissions, not the
+// emergency ones.
+func withBreakGlass(h http.Handler, b *config.BreakGlass, audit *services.AuditLog) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if b == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), breakGlassKey, b))
+		if u, ok := config.GetUser(r); ok && u.Viewer() == nil {
+			if g := u.BreakGlass(); g != nil {
+				audit.Record(&services.AuditEvent{
+					User:     u.ID(),
+					Action:   "break_glass_request",
+					Resource: r.URL.Path,
+					Details: map[string]string{
+						"id":     g.ID,
+						"method": r.Method,
+						"query":  r.URL.RawQuery,
+					},
+				})
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// getBreakGlass returns the emergency access for the request, or nil if it
+// isn't configured.
+func getBreakGlass(r *http.Request) *config.BreakGlass {
+	b, _ := r.Context().Value(breakGlassKey).(*config.BreakGlass)
+	return b
+}
+
+// breakGlassServer lets users give themselves emergency access, with a
+// justification. Access is granted right away, but the people running
+// Logrole are notified, every page is marked, and every request is recorded
+// in the audit log until it expires.
+type breakGlassServer struct {
+	log.Logger
+	BreakGlass     *config.BreakGlass
+	Grants         *config.GrantStore
+	Audit          *services.AuditLog
+	Notifier       services.Notifier
+	LocationFinder services.LocationFinder
+	// Link to the grants page in notifications, if set.
+	GrantsURL string
+	tpl       *template.Template
+}
+
+func newBreakGlassServer(l log.Logger, b *config.BreakGlass, grants *config.GrantStore, audit *services.AuditLog, n services.Notifier, lf services.LocationFinder, grantsURL string) (*breakGlassServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+breakGlassTpl)
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		n = &services.NoopNotifier{}
+	}
+	return &breakGlassServer{
+		Logger:         l,
+		BreakGlass:     b,
+		Grants:         grants,
+		Audit:          audit,
+		Notifier:       n,
+		LocationFinder: lf,
+		GrantsURL:      grantsURL,
+		tpl:            tpl,
+	}, nil
+}
+
+type breakGlassData struct {
+	// The permissions the user would get.
+	Permissions []string
+	// The user's emergency access, if they already have it.
+	Active   *config.Grant
+	Duration time.Duration
+	Loc      *time.Location
+	Err      string
+	// Form value, so it isn't lost after an error.
+	Reason    string
+	MinReason int
+	MaxReason int
+}
+
+func (d *breakGlassData) Title() string {
+	return "Emergency Access"
+}
+
+// Length describes how long emergency access lasts, like "2 hours".
+func (d *breakGlassData) Length() string {
+	if d.Duration%time.Hour == 0 {
+		if d.Duration == time.Hour {
+			return "an hour"
+		}
+		return fmt.Sprintf("%d hours", d.Duration/time.Hour)
+	}
+	return fmt.Sprintf("%d minutes", d.Duration/time.Minute)
+}
+
+func (s *breakGlassServer) render(w http.ResponseWriter, r *http.Request, u *config.User, code int, data *breakGlassData) {
+	data.Permissions = s.BreakGlass.Missing(u)
+	data.Active = u.BreakGlass()
+	data.Duration = s.BreakGlass.Duration
+	data.Loc = s.LocationFinder.GetLocationReq(r)
+	data.MinReason = minBreakGlassReason
+	data.MaxReason = maxBreakGlassReason
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (s *breakGlassServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if s.BreakGlass == nil {
+		rest.NotFound(w, r)
+		return
+	}
+	if !s.BreakGlass.Allowed(u) {
+		rest.Forbidden(w, r, &rest.Error{Title: "Your group can't use emergency access"})
+		return
+	}
+	switch {
+	case r.Method == "GET":
+		s.render(w, r, u, http.StatusOK, &breakGlassData{})
+	case r.URL.Path == "/break-glass/end":
+		s.end(w, r, u)
+	default:
+		s.create(w, r, u)
+	}
+}
+
+// POST /break-glass
+//
+// Give the user every emergency permission they don't already have, for the
+// configured duration, and tell everyone about it.
+func (s *breakGlassServer) create(w http.ResponseWriter, r *http.Request, u *config.User) {
+	if err := r.ParseForm(); err != nil {
+		s.render(w, r, u, http.StatusBadRequest, &breakGlassData{Err: err.Error()})
+		return
+	}
+	data := &breakGlassData{Reason: strings.TrimSpace(r.PostForm.Get("reason"))}
+	permissions := s.BreakGlass.Missing(u)
+	switch {
+	case u.BreakGlass() != nil:
+		data.Err = "You already have emergency access"
+	case len(permissions) == 0:
+		data.Err = "You already have every permission emergency access gives"
+	case len(data.Reason) < minBreakGlassReason:
+		data.Err = fmt.Sprintf("Say what the emergency is in at least %d characters; it's sent to the people running Logrole", minBreakGlassReason)
+	case len(data.Reason) > maxBreakGlassReason:
+		data.Err = fmt.Sprintf("Reason is longer than %d characters", maxBreakGlassReason)
+	}
+	if data.Err != "" {
+		s.render(w, r, u, http.StatusBadRequest, data)
+		return
+	}
+	g, err := s.Grants.Add(&config.Grant{
+		User:        u.ID(),
+		Permissions: permissions,
+		Expires:     time.Now().UTC().Add(s.BreakGlass.Duration),
+		Reason:      data.Reason,
+		GrantedBy:   u.ID(),
+		BreakGlass:  true,
+	})
+	if err != nil {
+		data.Err = err.Error()
+		s.render(w, r, u, http.StatusBadRequest, data)
+		return
+	}
+	s.Warn("Emergency access granted", "user", u.ID(), "permissions", strings.Join(g.Permissions, ","), "expires", g.Expires, "reason", g.Reason)
+	s.Audit.Record(&services.AuditEvent{
+		User:     u.ID(),
+		Action:   "break_glass",
+		Resource: u.ID(),
+		Details: map[string]string{
+			"id":          g.ID,
+			"permissions": strings.Join(g.Permissions, ","),
+			"expires":     g.Expires.Format(time.RFC3339),
+			"reason":      g.Reason,
+		},
+	})
+	go s.notify(g)
+	http.Redirect(w, r, "/break-glass", http.StatusFound)
+}
+
+// notify tells the people running Logrole that someone broke the glass.
+func (s *breakGlassServer) notify(g *config.Grant) {
+	ctx, cancel := context.WithTimeout(context.Background(), breakGlassNotifyTimeout)
+	defer cancel()
+	subject := fmt.Sprintf("%s used emergency access in Logrole", g.User)
+	body := fmt.Sprintf("Reason: %s\nPermissions: %s\nExpires: %s",
+		g.Reason, strings.Join(g.Permissions, ", "), g.Expires.Format(time.RFC1123))
+	if s.GrantsURL != "" {
+		body += "\n\nRevoke it at " + s.GrantsURL
+	}
+	if err := s.Notifier.Notify(ctx, subject, body); err != nil {
+		s.Warn("Couldn't send emergency access notification", "user", g.User, "err", err)
+	}
+}
+
+// POST /break-glass/end
+//
+// Give up emergency access before it expires.
+func (s *breakGlassServer) end(w http.ResponseWriter, r *http.Request, u *config.User) {
+	active := u.BreakGlass()
+	if active == nil {
+		s.render(w, r, u, http.StatusBadRequest, &breakGlassData{Err: "You don't have emergency access"})
+		return
+	}
+	g, err := s.Grants.Revoke(active.ID)
+	if err != nil {
+		s.render(w, r, u, http.StatusBadRequest, &breakGlassData{Err: err.Error()})
+		return
+	}
+	s.Audit.Record(&services.AuditEvent{
+		User:     u.ID(),
+		Action:   "end_break_glass",
+		Resource: u.ID(),
+		Details: map[string]string{
+			"id": g.ID,
+		},
+	})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
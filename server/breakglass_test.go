@@ -0,0 +1,128 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"golang.org/x/net/context"
+)
+
+// chanNotifier sends each subject on a channel, since break glass
+// notifications are sent in a goroutine.
+type chanNotifier chan string
+
+func (c chanNotifier) Notify(ctx context.Context, subject string, body string) error {
+	c <- subject
+	return nil
+}
+
+var breakGlassPolicy = &config.Policy{
+	&config.Group{Name: "oncall", Users: []string{"oncall@example.com"}, Permissions: &config.UserSettings{CanViewMessages: true}},
+	&config.Group{Name: "support", Users: []string{"support@example.com"}, Permissions: &config.UserSettings{CanViewMessages: true}},
+}
+
+func TestBreakGlass(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-break-glass-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	auditPath := filepath.Join(dir, "audit.log")
+	audit, err := services.NewAuditLog(NullLogger, auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grants, _ := config.NewGrantStore("", nil)
+	b, err := config.NewBreakGlass(&config.BreakGlassConfig{
+		Permissions: []string{"can_view_message_body"},
+		Groups:      []string{"oncall"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifications := make(chanNotifier, 1)
+	lf, _ := services.NewLocationFinder("America/Los_Angeles")
+	s, err := newBreakGlassServer(NullLogger, b, grants, audit, notifications, lf, "https://logrole.example.com/admin/grants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oncall, _, _ := breakGlassPolicy.Lookup("oncall@example.com")
+	support, _, _ := breakGlassPolicy.Lookup("support@example.com")
+
+	if w := postGrant(s, support, "/break-glass", url.Values{"reason": {"Customer reports lost messages"}}); w.Code != 403 {
+		t.Errorf("expected users outside the groups to get a 403, got %d", w.Code)
+	}
+	if w := postGrant(s, oncall, "/break-glass", url.Values{"reason": {"help"}}); w.Code != 400 {
+		t.Errorf("expected a short reason to get a 400, got %d", w.Code)
+	}
+	if w := postGrant(s, oncall, "/break-glass", url.Values{"reason": {"Customer reports lost messages"}}); w.Code != 302 {
+		t.Fatalf("expected Code to be 302, got %d: %s", w.Code, w.Body.String())
+	}
+	select {
+	case subject := <-notifications:
+		if subject != "oncall@example.com used emergency access in Logrole" {
+			t.Errorf("bad notification subject: %q", subject)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a notification")
+	}
+
+	// Every request with emergency access is audited, and shows the banner.
+	h := withGrants(withBreakGlass(s, b, audit), grants)
+	req, _ := http.NewRequest("GET", "/break-glass", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, config.SetUser(req, oncall))
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "End emergency access") || !strings.Contains(body, "Customer reports lost messages") {
+		t.Errorf("expected the emergency access banner, got %s", body)
+	}
+	data, err := ioutil.ReadFile(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"action":"break_glass"`) || !strings.Contains(string(data), `"action":"break_glass_request","resource":"/break-glass"`) {
+		t.Errorf("expected break glass and the request to be audited, got %s", data)
+	}
+
+	active := grants.Active(time.Now())
+	if len(active) != 1 || !active[0].BreakGlass || active[0].User != "oncall@example.com" {
+		t.Fatalf("expected one break glass grant, got %#v", active)
+	}
+	if w := postGrant(h, oncall, "/break-glass/end", url.Values{}); w.Code != 302 {
+		t.Fatalf("expected Code to be 302, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(grants.Active(time.Now())) != 0 {
+		t.Error("expected ending emergency access to revoke the grant")
+	}
+}
+
+func TestBreakGlassNotConfigured(t *testing.T) {
+	t.Parallel()
+	grants, _ := config.NewGrantStore("", nil)
+	audit, _ := services.NewAuditLog(NullLogger, "")
+	lf, _ := services.NewLocationFinder("America/Los_Angeles")
+	s, err := newBreakGlassServer(NullLogger, nil, grants, audit, nil, lf, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oncall, _, _ := breakGlassPolicy.Lookup("oncall@example.com")
+	req, _ := http.NewRequest("GET", "/break-glass", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, config.SetUser(req, oncall))
+	if w.Code != 404 {
+		t.Errorf("expected Code to be 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/saintpete/logrole/services"
+)
+
+// withCallBudget counts the Twilio API requests made for each request,
+// including pages fetched into the cache in the background, and stops making
+// them after max requests (if max is nonzero). The count is logged after
+// every request that made one, and sent in a Server-Timing header.
+func withCallBudget(h http.Handler, l log.Logger, max int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := services.NewCallBudget(max)
+		r = r.WithContext(services.WithCallBudget(r.Context(), b))
+		bw := &budgetWriter{ResponseWriter: w, budget: b}
+		h.ServeHTTP(bw, r)
+		if calls, denied := b.Calls(), b.Denied(); calls > 0 || denied > 0 {
+			l.Info("Twilio API calls", "method", r.Method, "path", r.URL.Path,
+				"request_id", r.Header.Get("X-Request-Id"), "calls", calls,
+				"denied", denied, "twilio_time", b.Duration())
+		}
+	})
+}
+
+// budgetWriter adds a Server-Timing header with the Twilio API requests made
+// before the response started.
+type budgetWriter struct {
+	http.ResponseWriter
+	budget      *services.CallBudget
+	wroteHeader bool
+}
+
+func (w *budgetWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set("Server-Timing", serverTiming(w.budget))
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *budgetWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streamed list pages reach the browser, if the underlying
+// ResponseWriter supports it.
+func (w *budgetWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func serverTiming(b *services.CallBudget) string {
+	ms := float64(b.Duration()) / float64(time.Millisecond)
+	return fmt.Sprintf(`twilio;dur=%.1f;desc="%d Twilio API calls"`, ms, b.Calls())
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saintpete/logrole/services"
+)
+
+func TestCallBudgetSetsServerTiming(t *testing.T) {
+	t.Parallel()
+	twilioServer := newServerWithResponse(200, []byte("{}"))
+	defer twilioServer.Close()
+	client := &http.Client{Transport: services.NewCallBudgetTransport(http.DefaultTransport)}
+	var errs []error
+	h := withCallBudget(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			req, _ := http.NewRequest("GET", twilioServer.URL, nil)
+			resp, err := client.Do(req.WithContext(r.Context()))
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			resp.Body.Close()
+		}
+		w.Write([]byte("ok"))
+	}), NullLogger, 2)
+	req, _ := http.NewRequest("GET", "/messages", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if len(errs) != 1 {
+		t.Fatalf("expected one request to be denied, got %d errors", len(errs))
+	}
+	if !strings.Contains(errs[0].Error(), services.ErrCallBudgetExceeded.Error()) {
+		t.Errorf("expected ErrCallBudgetExceeded, got %v", errs[0])
+	}
+	timing := w.Header().Get("Server-Timing")
+	if !strings.HasPrefix(timing, "twilio;dur=") || !strings.Contains(timing, `desc="2 Twilio API calls"`) {
+		t.Errorf("unexpected Server-Timing header: %q", timing)
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+)
+
+// List at most this many cache keys at once; search to narrow them down.
+const maxCacheKeys = 200
+
+// cacheHistoryServer lists the API responses in the cache, the versions of
+// each one the cache kept, and renders an old version of a page of messages
+// or calls, for reports that a list looked different earlier. It requires
+// the can_profile permission. Pages are rendered with the admin's own
+// permissions.
+type cacheHistoryServer struct {
+	log.Logger
+	Cache          views.CacheHistorian
+	LocationFinder services.LocationFinder
+	// The number of versions kept for each key.
+	Generations int
+	tpl         *template.Template
+}
+
+func newCacheHistoryServer(l log.Logger, ch views.CacheHistorian, lf services.LocationFinder, generations int) (*cacheHistoryServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+cacheHistoryTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheHistoryServer{
+		Logger:         l,
+		Cache:          ch,
+		LocationFinder: lf,
+		Generations:    generations,
+		tpl:            tpl,
+	}, nil
+}
+
+type cacheHistoryData struct {
+	// Whether old versions are kept, or only the current one.
+	KeepsHistory bool
+	Generations  int
+	Query        string
+	Keys         []string
+	// True if there were more than maxCacheKeys matching keys.
+	More bool
+	// The key whose versions are listed, if any.
+	Key      string
+	Versions []*cache.Generation
+	// The version being rendered, if any, and its page.
+	Version  *cache.Generation
+	Messages *views.MessagePage
+	Calls    *views.CallPage
+	// Set if the version can't be rendered, like a page of alerts.
+	Unrenderable bool
+	Now          time.Time
+	Loc          *time.Location
+	Err          string
+}
+
+func (d *cacheHistoryData) Title() string {
+	return "Cache History"
+}
+
+func (s *cacheHistoryServer) keys(query string) ([]string, bool) {
+	keys := make([]string, 0)
+	for _, key := range s.Cache.CacheKeys() {
+		if query != "" && !strings.Contains(key, query) {
+			continue
+		}
+		if len(keys) == maxCacheKeys {
+			return keys, true
+		}
+		keys = append(keys, key)
+	}
+	return keys, false
+}
+
+// GET /admin/cache?q=messages
+// GET /admin/cache?key=<key>
+// GET /admin/cache?key=<key>&set=<set>
+func (s *cacheHistoryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanProfile() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	query := r.URL.Query()
+	data := &cacheHistoryData{
+		KeepsHistory: s.Generations > 1,
+		Generations:  s.Generations,
+		Query:        query.Get("q"),
+		Key:          query.Get("key"),
+		Now:          time.Now(),
+		Loc:          s.LocationFinder.GetLocationReq(r),
+	}
+	code := http.StatusOK
+	if data.Key == "" {
+		data.Keys, data.More = s.keys(data.Query)
+	} else {
+		data.Versions = s.Cache.CacheGenerations(data.Key)
+		if setStr := query.Get("set"); setStr != "" {
+			code = s.setVersion(data, u, setStr)
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// setVersion loads the version of data.Key stored at setStr into data, and
+// returns the status code to respond with.
+func (s *cacheHistoryServer) setVersion(data *cacheHistoryData, u *config.User, setStr string) int {
+	set, err := strconv.ParseUint(setStr, 10, 64)
+	if err != nil {
+		data.Err = "Invalid version: " + setStr
+		return http.StatusBadRequest
+	}
+	for _, v := range data.Versions {
+		if v.Set == set {
+			data.Version = v
+		}
+	}
+	if data.Version == nil {
+		data.Err = cache.ErrNoGeneration.Error()
+		return http.StatusNotFound
+	}
+	switch views.CacheKeyKind(data.Key) {
+	case views.CacheKindMessages:
+		data.Messages, err = s.Cache.GetCachedMessagePage(u, data.Key, set)
+	case views.CacheKindCalls:
+		data.Calls, err = s.Cache.GetCachedCallPage(u, data.Key, set)
+	default:
+		data.Unrenderable = true
+		return http.StatusOK
+	}
+	switch err {
+	case nil:
+		s.Info("Viewed cached page", "user", u.ID(), "key", data.Key, "stored", data.Version.Stored)
+		return http.StatusOK
+	case cache.ErrNoGeneration:
+		data.Err = err.Error()
+		return http.StatusNotFound
+	case config.PermissionDenied, config.ErrTooOld:
+		data.Err = err.Error()
+		return http.StatusForbidden
+	default:
+		data.Err = "Couldn't load cached page: " + err.Error()
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+func getCacheHistory(s http.Handler, u *config.User, query url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "/admin/cache?"+query.Encode(), nil)
+	req = config.SetUser(req, u)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func TestCacheHistory(t *testing.T) {
+	t.Parallel()
+	ts := harness.NewTwilioServer(scheduledFixtures(twilio.StatusDelivered))
+	defer ts.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: ts.Server, SecretKey: key})
+	ch := vc.(views.CacheHistorian)
+	ch.KeepCacheGenerations(3)
+	u := config.NewUser(config.AllUserSettings())
+	if _, _, err := vc.GetMessagePageInRange(context.Background(), u, twilio.Epoch, twilio.HeatDeath, url.Values{}); err != nil {
+		t.Fatal(err)
+	}
+	keys := ch.CacheKeys()
+	if len(keys) != 1 || views.CacheKeyKind(keys[0]) != views.CacheKindMessages {
+		t.Fatalf("expected one cached page of messages, got %v", keys)
+	}
+	s, err := newCacheHistoryServer(NullLogger, ch, lf, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := getCacheHistory(s, u, url.Values{"q": {"messages"}})
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "/admin/cache?key=messages") {
+		t.Errorf("expected a link to the cached page, got %s", w.Body.String())
+	}
+
+	gens := ch.CacheGenerations(keys[0])
+	if len(gens) != 1 {
+		t.Fatalf("expected one version of the page, got %d", len(gens))
+	}
+	set := strconv.FormatUint(gens[0].Set, 10)
+	w = getCacheHistory(s, u, url.Values{"key": {keys[0]}, "set": {set}})
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	for _, want := range []string{"Your appointment is tomorrow", "/messages/" + deliveredSid} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected the cached page to contain %q, got %s", want, body)
+		}
+	}
+
+	w = getCacheHistory(s, u, url.Values{"key": {keys[0]}, "set": {set + "0"}})
+	if w.Code != 404 {
+		t.Errorf("expected a version that isn't kept to be a 404, got %d", w.Code)
+	}
+
+	us := config.AllUserSettings()
+	us.CanViewMessageBody = false
+	w = getCacheHistory(s, config.NewUser(us), url.Values{"key": {keys[0]}, "set": {set}})
+	if strings.Contains(w.Body.String(), "Your appointment is tomorrow") {
+		t.Errorf("expected the cached page to be rendered with the admin's permissions, got %s", w.Body.String())
+	}
+
+	us = config.AllUserSettings()
+	us.CanProfile = false
+	if w := getCacheHistory(s, config.NewUser(us), url.Values{}); w.Code != 403 {
+		t.Errorf("expected users without can_profile to get a 403, got %d", w.Code)
+	}
+}
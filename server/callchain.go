@@ -0,0 +1,220 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/views"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+// The most calls we follow up or down a chain of calls created with <Dial>,
+// so a long chain, or ParentCallSid links that lead back to themselves, can't
+// keep us fetching calls.
+const maxLegDepth = 5
+
+// The most lists of child calls we fetch below the current call.
+const maxLegFetches = 10
+
+// fetchAncestors follows the ParentCallSid links up from leg, adding the
+// parent of each call, with its other children, above it. It returns the
+// oldest call it found, or leg if leg doesn't have a parent u can view.
+func (c *callInstanceServer) fetchAncestors(ctx context.Context, u *config.User, leg *callLeg) (*callLeg, error) {
+	sid, err := leg.Call.Sid()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{sid: true}
+	for i := 0; i < maxLegDepth; i++ {
+		parentSid, err := leg.Call.ParentCallSid()
+		if err != nil {
+			return nil, err
+		}
+		if !parentSid.Valid {
+			break
+		}
+		if seen[parentSid.String] {
+			leg.Loop = true
+			break
+		}
+		seen[parentSid.String] = true
+		parent, siblings, err := c.fetchParent(ctx, u, parentSid.String)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			break
+		}
+		p := &callLeg{Call: parent, Children: []*callLeg{leg}}
+		for _, sibling := range siblings.Calls() {
+			if siblingSid, _ := sibling.Sid(); siblingSid != sid {
+				p.Children = append(p.Children, &callLeg{Call: sibling})
+			}
+		}
+		sort.Sort(legsByDate(p.Children))
+		leg = p
+		sid = parentSid.String
+	}
+	return leg, nil
+}
+
+// fetchParent gets the call with the given sid and its children
+// concurrently. If the call is too old for u to view, it returns nil.
+func (c *callInstanceServer) fetchParent(ctx context.Context, u *config.User, sid string) (*views.Call, *views.CallPage, error) {
+	g, errctx := errgroup.WithContext(ctx)
+	var parent *views.Call
+	var children *views.CallPage
+	g.Go(func() error {
+		var err error
+		parent, err = c.Client.GetCall(errctx, u, sid)
+		if err == config.PermissionDenied || err == config.ErrTooOld {
+			parent = nil
+			return nil
+		}
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		children, err = c.Client.GetChildCalls(errctx, u, sid)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+	return parent, children, nil
+}
+
+// fetchDescendants adds the calls created by leg, and the calls they
+// created, below it, a level at a time, until there are no more or it's
+// fetched maxLegFetches lists.
+func (c *callInstanceServer) fetchDescendants(ctx context.Context, u *config.User, leg *callLeg) error {
+	sid, err := leg.Call.Sid()
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{sid: true}
+	level := []*callLeg{leg}
+	fetches := 0
+	for depth := 0; depth < maxLegDepth && len(level) > 0; depth++ {
+		if len(level) > maxLegFetches-fetches {
+			level = level[:maxLegFetches-fetches]
+		}
+		fetches += len(level)
+		pages := make([]*views.CallPage, len(level))
+		g, errctx := errgroup.WithContext(ctx)
+		for i := range level {
+			i := i
+			g.Go(func() error {
+				sid, err := level[i].Call.Sid()
+				if err != nil {
+					return err
+				}
+				pages[i], err = c.Client.GetChildCalls(errctx, u, sid)
+				return err
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return err
+		}
+		var next []*callLeg
+		for i, parent := range level {
+			for _, child := range pages[i].Calls() {
+				childSid, err := child.Sid()
+				if err != nil {
+					return err
+				}
+				if seen[childSid] {
+					parent.Loop = true
+					continue
+				}
+				seen[childSid] = true
+				l := &callLeg{Call: child}
+				parent.Children = append(parent.Children, l)
+				next = append(next, l)
+			}
+			sort.Sort(legsByDate(parent.Children))
+		}
+		level = next
+	}
+	return nil
+}
+
+// markLoops flags the calls below leg that dialed a number that's already in
+// the chain above them, like a forwarding number that forwards back to the
+// number that was first dialed. numbers are the From and To numbers of the
+// calls above leg. Numbers u can't view are ignored.
+func markLoops(leg *callLeg, numbers map[string]bool) {
+	if to, err := leg.Call.To(); err == nil && to != "" && numbers[string(to)] {
+		leg.Loop = true
+	}
+	if len(leg.Children) == 0 {
+		return
+	}
+	above := make(map[string]bool, len(numbers)+2)
+	for n := range numbers {
+		above[n] = true
+	}
+	if from, err := leg.Call.From(); err == nil && from != "" {
+		above[string(from)] = true
+	}
+	if to, err := leg.Call.To(); err == nil && to != "" {
+		above[string(to)] = true
+	}
+	for _, child := range leg.Children {
+		markLoops(child, above)
+	}
+}
+
+// A callChain compares how long the caller spent on the phone to how long
+// each of the calls they were connected to lasted.
+type callChain struct {
+	// The number of calls in the chain.
+	Legs int
+	// From the start of the first call to the end of the last one; what the
+	// caller experienced. Zero if the user can't view call times.
+	Total time.Duration
+	// The durations of every call, added up.
+	LegTotal time.Duration
+	// The number of calls that were flagged as loops.
+	Loops int
+}
+
+// newCallChain summarizes the calls in the tree starting at root, or returns
+// nil if root is nil.
+func newCallChain(root *callLeg) *callChain {
+	if root == nil {
+		return nil
+	}
+	chain := new(callChain)
+	var first, last time.Time
+	var walk func(*callLeg)
+	walk = func(leg *callLeg) {
+		chain.Legs++
+		if leg.Loop {
+			chain.Loops++
+		}
+		duration, err := leg.Call.Duration()
+		if err == nil {
+			chain.LegTotal += time.Duration(duration)
+		}
+		if start, serr := leg.Call.StartTime(); err == nil && serr == nil && start.Valid {
+			end := start.Time.Add(time.Duration(duration))
+			if first.IsZero() || start.Time.Before(first) {
+				first = start.Time
+			}
+			if end.After(last) {
+				last = end
+			}
+		}
+		for _, child := range leg.Children {
+			walk(child)
+		}
+	}
+	walk(root)
+	if !first.IsZero() {
+		chain.Total = last.Sub(first)
+	}
+	return chain
+}
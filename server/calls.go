@@ -6,13 +6,11 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aristanetworks/goarista/monotime"
 	log "github.com/inconshreveable/log15"
-	types "github.com/kevinburke/go-types"
 	"github.com/kevinburke/rest"
 	"github.com/saintpete/logrole/config"
 	"github.com/saintpete/logrole/services"
@@ -24,7 +22,7 @@ import (
 
 const callPattern = `(?P<sid>CA[a-f0-9]{32})`
 
-var callInstanceRoute = regexp.MustCompile("^/calls/" + callPattern + "$")
+var callInstanceRoute = regexp.MustCompile("^/calls/(?P<sid>CA[a-f0-9]{32}|" + providerIDPattern + ")$")
 
 type callListServer struct {
 	log.Logger
@@ -33,10 +31,15 @@ type callListServer struct {
 	PageSize       uint
 	MaxResourceAge time.Duration
 	secretKey      *[32]byte
-	tpl            *template.Template
+	// Used to filter the list by team. May be nil.
+	Owners *services.OwnerStore
+	// nil if prefetching is disabled.
+	Prefetcher *prefetcher
+	tpl        *template.Template
 }
 
 func newCallListServer(l log.Logger, vc views.Client, lf services.LocationFinder,
+	labels *services.LabelStore, owners *services.OwnerStore,
 	pageSize uint, maxResourceAge time.Duration,
 	secretKey *[32]byte) (*callListServer, error) {
 	cs := &callListServer{
@@ -45,15 +48,23 @@ func newCallListServer(l log.Logger, vc views.Client, lf services.LocationFinder
 		LocationFinder: lf,
 		PageSize:       pageSize,
 		MaxResourceAge: maxResourceAge,
+		Owners:         owners,
 		secretKey:      secretKey,
 	}
+	providers := listProviders(vc)
+	accounts := listAccounts(vc)
 	tpl, err := newTpl(template.FuncMap{
 		"is_our_pn": vc.IsTwilioNumber,
-		"min":       minFunc(cs.MaxResourceAge),
+		"pn_label":  labels.Get,
+		"pn_owner":  owners.Get,
+		"min":       minLoc,
 		"max":       maxLoc,
 		"start_val": cs.StartSearchVal,
 		"end_val":   cs.EndSearchVal,
-	}, base+callListTpl+pagingTpl+phoneTpl+copyScript)
+		"providers": func() []string { return providers },
+		"accounts":  func() []*views.Account { return accounts },
+		"teams":     owners.Teams,
+	}, base+callListTpl+pagingTpl+phoneTpl+copyScript+autoRefreshScript+partialListsScript)
 	if err != nil {
 		return nil, err
 	}
@@ -65,19 +76,32 @@ type callInstanceServer struct {
 	log.Logger
 	Client         views.Client
 	LocationFinder services.LocationFinder
-	tpl            *template.Template
+	// May be nil.
+	Tickets *ticketer
+	// May be nil.
+	Notes *services.NoteStore
+	// Requests forwarded by capture URLs, for the TwiML our webhook
+	// returned. May be nil.
+	Webhooks *services.WebhookStore
+	// Runbook links and notes for error codes. May be nil.
+	Runbooks *config.Runbooks
+	tpl      *template.Template
 }
 
 func newCallInstanceServer(l log.Logger, vc views.Client,
-	lf services.LocationFinder) (*callInstanceServer, error) {
+	lf services.LocationFinder, labels *services.LabelStore, owners *services.OwnerStore,
+	tickets *ticketer) (*callInstanceServer, error) {
 	c := &callInstanceServer{
 		Logger:         l,
 		Client:         vc,
 		LocationFinder: lf,
+		Tickets:        tickets,
 	}
 	tpl, err := newTpl(template.FuncMap{
 		"is_our_pn": vc.IsTwilioNumber,
-	}, base+callInstanceTpl+recordingTpl+phoneTpl+sidTpl+copyScript)
+		"pn_label":  labels.Get,
+		"pn_owner":  owners.Get,
+	}, base+callInstanceTpl+recordingTpl+phoneTpl+sidTpl+ticketsTpl+notesTpl+relatedAlertsTpl+runbookTpl+webhookResponseTpl+copyScript)
 	if err != nil {
 		return nil, err
 	}
@@ -89,8 +113,42 @@ type callInstanceData struct {
 	Call       *views.Call
 	Loc        *time.Location
 	Recordings *recordingResp
-	AlertError error
-	Alerts     *views.AlertPage
+	// nil if the user can't view alerts.
+	Alerts *alertsResp
+	// The tree of calls this call belongs to, or nil if it was not created
+	// by another call and did not create any.
+	Legs      *callLeg
+	LegsError error
+	// How long the caller spent in the calls in Legs. nil if Legs is nil.
+	Chain   *callChain
+	Tickets *ticketData
+	// nil if the user can't view notes.
+	Notes *noteData
+	// What our webhook responded to Twilio's requests for this call, from
+	// capture URLs that forward requests. nil if the user can't view
+	// callback URLs and alert payloads.
+	Responses []*services.CapturedRequest
+}
+
+// A callLeg is one call in a multi-leg call flow, like the two calls created
+// by <Dial>.
+type callLeg struct {
+	Call     *views.Call
+	Current  bool
+	Children []*callLeg
+	// Set if the call dialed a number that's already in the chain above it,
+	// or the chain of parent calls leads back to itself.
+	Loop bool
+}
+
+type legsByDate []*callLeg
+
+func (l legsByDate) Len() int      { return len(l) }
+func (l legsByDate) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l legsByDate) Less(i, j int) bool {
+	a, _ := l[i].Call.DateCreated()
+	b, _ := l[j].Call.DateCreated()
+	return a.Time.Before(b.Time)
 }
 
 type callListData struct {
@@ -100,6 +158,38 @@ type callListData struct {
 	Loc                   *time.Location
 	Query                 url.Values
 	Err                   string
+	// Set if the page was streamed and fetching the results failed.
+	FetchErr string
+	// The age of the oldest call the user can view.
+	MaxResourceAge time.Duration
+	// Show the auto-refresh toggle, on the first page of a list with no end
+	// time.
+	AutoRefresh bool
+	// When the page was rendered, for lists with no calls to compare to.
+	Now time.Time
+	// The business hours of the user's group; dates outside them are
+	// shaded. nil if the group doesn't have any.
+	Hours *config.BusinessHours
+	// Swap in new results when the filters or the page change, instead of
+	// loading the whole page.
+	Partial bool
+	*stream
+}
+
+// NewerURL returns the URL to poll for calls newer than the ones on the page.
+func (c *callListData) NewerURL() string {
+	var newest time.Time
+	if c.Page != nil {
+		for _, call := range c.Page.Calls() {
+			if created, err := call.DateCreated(); err == nil && created.Time.After(newest) {
+				newest = created.Time
+			}
+		}
+	}
+	if newest.IsZero() {
+		newest = c.Now
+	}
+	return newerURL(c.Path(), c.Query, newest)
 }
 
 func (c *callListData) Title() string {
@@ -121,6 +211,12 @@ func (c *callListData) NextQuery() template.URL {
 	if end, ok := c.Query["start-before"]; ok {
 		data.Set("start-before", end[0])
 	}
+	if country, ok := c.Query["country"]; ok {
+		data.Set("country", country[0])
+	}
+	if team, ok := c.Query["team"]; ok {
+		data.Set("team", team[0])
+	}
 	return template.URL(data.Encode())
 }
 
@@ -135,18 +231,24 @@ func (c *callListData) PreviousQuery() template.URL {
 	if end, ok := c.Query["start-before"]; ok {
 		data.Set("start-before", end[0])
 	}
+	if country, ok := c.Query["country"]; ok {
+		data.Set("country", country[0])
+	}
+	if team, ok := c.Query["team"]; ok {
+		data.Set("team", team[0])
+	}
 	return template.URL(data.Encode())
 }
 
-func (s *callListServer) StartSearchVal(query url.Values, loc *time.Location) string {
+func (s *callListServer) StartSearchVal(query url.Values, maxAge time.Duration, loc *time.Location) string {
 	if start, ok := query["start-after"]; ok {
 		return start[0]
 	}
-	if s.MaxResourceAge == config.DefaultMaxResourceAge {
+	if maxAge == config.DefaultMaxResourceAge {
 		// one week ago, arbitrary
 		return minLoc(7*24*time.Hour, loc)
 	} else {
-		return minLoc(s.MaxResourceAge, loc)
+		return minLoc(maxAge, loc)
 	}
 }
 
@@ -158,7 +260,7 @@ func (s *callListServer) EndSearchVal(query url.Values, loc *time.Location) stri
 }
 
 func (s *callListServer) validParams() []string {
-	return []string{"from", "to", "next", "start-after", "start-before"}
+	return []string{"from", "to", "country", "team", "next", "start-after", "start-before", "provider", "account"}
 }
 
 func (s *callListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -181,86 +283,143 @@ func (s *callListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	loc := s.LocationFinder.GetLocationReq(r)
 	// We always set startTime and endTime on the request, though they may end
 	// up just being sentinels
-	startTime, endTime, wroteError := getTimes(w, r, "start-after", "start-before", loc, query, s)
+	maxAge := u.MaxResourceAge(s.MaxResourceAge)
+	startTime, endTime, wroteError := getTimes(w, r, "start-after", "start-before", loc, maxAge, query, s)
 	if wroteError {
 		return
 	}
+	country, countryErr := getCountry(query, u.CanViewCallFrom(), u.CanViewCallTo())
+	if countryErr != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, countryErr)
+		return
+	}
+	team, teamErr := getTeam(query, s.Owners, u.CanViewCallFrom(), u.CanViewCallTo())
+	if teamErr != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, teamErr)
+		return
+	}
 	ctx, cancel := getContext(r.Context(), 3*time.Second)
 	defer cancel()
 	var err error
-	next, nextErr := getNext(query, s.secretKey)
+	scope := pageScope(u, query, "start-after", "start-before")
+	next, nextErr := getNext(query, scope, s.secretKey)
 	if nextErr != nil {
 		err = errors.New("Could not decrypt `next` query parameter: " + nextErr.Error())
 		s.renderError(w, r, http.StatusBadRequest, query, err)
 		return
 	}
-	var page *views.CallPage
-	var cachedAt uint64
-	queryStart := monotime.Now()
+	var data url.Values
 	if next != "" {
 		if !strings.HasPrefix(next, "/"+twilio.APIVersion) {
 			s.Warn("Invalid next page URI", "next", next, "opaque", query.Get("next"))
 			s.renderError(w, r, http.StatusBadRequest, query, errors.New("Invalid next page uri"))
 			return
 		}
-		page, cachedAt, err = s.Client.GetNextCallPageInRange(ctx, u, startTime, endTime, next)
+		s.Prefetcher.Requested("calls", next)
 		setNextPageValsOnQuery(next, query)
 	} else {
 		// valid values: https://www.twilio.com/docs/api/rest/call#list
-		data := url.Values{}
-		data.Set("PageSize", strconv.FormatUint(uint64(s.PageSize), 10))
+		data = url.Values{}
+		data.Set("PageSize", userPageSize(r, u, s.PageSize))
 		if filterErr := setPageFilters(query, data); filterErr != nil {
 			s.renderError(w, r, http.StatusBadRequest, query, filterErr)
 			return
 		}
-		page, cachedAt, err = s.Client.GetCallPageInRange(ctx, u, startTime, endTime, data)
-	}
-	if err == twilio.NoMoreResults {
-		page = new(views.CallPage)
-		err = nil
 	}
-	if err != nil {
-		s.renderError(w, r, http.StatusInternalServerError, query, err)
-		return
-	}
-	// Fetch the next page into the cache
-	go func(u *config.User, n types.NullString, startTime, endTime time.Time) {
-		if n.Valid {
-			if _, _, err := s.Client.GetNextCallPageInRange(context.Background(), u, startTime, endTime, n.String); err != nil {
-				s.Debug("Error fetching next page", "err", err)
+	var page *views.CallPage
+	var cachedAt uint64
+	var fetchErr error
+	var fetchDuration time.Duration
+	st := startStream(w, u, func() {
+		queryStart := monotime.Now()
+		if next != "" {
+			page, cachedAt, fetchErr = s.Client.GetNextCallPageInRange(ctx, u, startTime, endTime, next)
+		} else {
+			page, cachedAt, fetchErr = s.Client.GetCallPageInRange(ctx, u, startTime, endTime, data)
+		}
+		fetchDuration = monotime.Since(queryStart)
+		if fetchErr == twilio.NoMoreResults {
+			page = new(views.CallPage)
+			fetchErr = nil
+		}
+		if fetchErr == nil && country != "" {
+			page = page.Filter(func(c *views.Call) bool {
+				return c.InCountry(country)
+			})
+		}
+		if fetchErr == nil && team != "" {
+			page = page.Filter(func(c *views.Call) bool {
+				return c.OwnedBy(s.Owners, team)
+			})
+		}
+	})
+	ld := &callListData{
+		Loc:            loc,
+		Query:          query,
+		MaxResourceAge: maxAge,
+		AutoRefresh:    next == "" && query.Get("start-before") == "" && u.Feature(config.FeatureAutoRefresh),
+		Now:            time.Now(),
+		Hours:          u.BusinessHours(),
+		Partial:        u.Feature(config.FeaturePartialLists),
+		stream:         st,
+	}
+	bd := &baseData{LF: s.LocationFinder, Data: ld}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if st != nil {
+		st.then = func() {
+			bd.Duration = fetchDuration
+			if fetchErr != nil {
+				s.Warn("Error fetching streamed page", "url", r.URL.String(), "err", fetchErr)
+				ld.Page = new(views.CallPage)
+				ld.FetchErr = cleanError(fetchErr)
+				return
 			}
+			s.setPage(r.Context(), bd, ld, u, page, cachedAt, startTime, endTime, scope)
 		}
-	}(u, page.NextPageURI(), startTime, endTime)
-	data := &baseData{
-		LF:       s.LocationFinder,
-		Duration: monotime.Since(queryStart),
-	}
-	if cachedAt > 0 {
-		data.CachedDuration = monotime.Since(cachedAt)
+		if err := renderStream(w, r, s.tpl, "base", bd); err != nil {
+			s.Error("Error rendering streamed page", "url", r.URL.String(), "err", err)
+		}
+		return
 	}
-	data.Data = &callListData{
-		Page:                  page,
-		Loc:                   loc,
-		Query:                 query,
-		EncryptedNextPage:     getEncryptedPage(page.NextPageURI(), s.secretKey),
-		EncryptedPreviousPage: getEncryptedPage(page.PreviousPageURI(), s.secretKey),
+	if fetchErr != nil {
+		s.renderError(w, r, http.StatusInternalServerError, query, fetchErr)
+		return
 	}
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	bd.Duration = fetchDuration
+	s.setPage(r.Context(), bd, ld, u, page, cachedAt, startTime, endTime, scope)
 	w.WriteHeader(200)
-	if err := render(w, r, s.tpl, "base", data); err != nil {
+	if err := render(w, r, s.tpl, "base", bd); err != nil {
 		rest.ServerError(w, r, err)
 	}
 }
 
+// setPage fills in the results on the page, and fetches the next page into
+// the cache. The links to the other pages are bound to scope.
+func (s *callListServer) setPage(ctx context.Context, bd *baseData, ld *callListData, u *config.User, page *views.CallPage, cachedAt uint64, startTime, endTime time.Time, scope string) {
+	if n := page.NextPageURI(); n.Valid {
+		s.Prefetcher.Prefetch(services.DetachCallBudget(ctx), "calls", n.String, func(ctx context.Context) error {
+			_, _, err := s.Client.GetNextCallPageInRange(ctx, u, startTime, endTime, n.String)
+			return err
+		})
+	}
+	ld.Page = page
+	ld.EncryptedNextPage = getEncryptedPage(page.NextPageURI(), scope, s.secretKey)
+	ld.EncryptedPreviousPage = getEncryptedPage(page.PreviousPageURI(), scope, s.secretKey)
+	if cachedAt > 0 {
+		bd.CachedDuration = monotime.Since(cachedAt)
+	}
+}
+
 func (c *callListServer) renderError(w http.ResponseWriter, r *http.Request, code int, query url.Values, err error) {
 	str := cleanError(err)
 	data := &baseData{
 		LF: c.LocationFinder,
 		Data: &callListData{
-			Err:   str,
-			Loc:   c.LocationFinder.GetLocationReq(r),
-			Query: query,
-			Page:  new(views.CallPage),
+			Err:            str,
+			Loc:            c.LocationFinder.GetLocationReq(r),
+			Query:          query,
+			Page:           new(views.CallPage),
+			MaxResourceAge: userMaxResourceAge(r, c.MaxResourceAge),
 		},
 	}
 	if code >= 500 {
@@ -286,6 +445,8 @@ type recordingResp struct {
 	Recordings           []*views.Recording
 	CanPlayRecording     bool
 	CanViewNumRecordings bool
+	// Set if the user can download every recording as a zip file.
+	DownloadURL string
 }
 
 func (c *callInstanceServer) fetchRecordings(ctx context.Context, sid string, u *config.User, rch chan<- *recordingResp) {
@@ -322,11 +483,40 @@ func (c *callInstanceServer) fetchRecordings(ctx context.Context, sid string, u
 			break
 		}
 	}
-	rch <- &recordingResp{
+	resp := &recordingResp{
 		Recordings:           rs,
 		CanPlayRecording:     canPlayRecording,
 		CanViewNumRecordings: u.CanViewNumRecordings(),
 	}
+	if len(rs) > 0 && u.CanDownloadRecordings() {
+		resp.DownloadURL = "/recordings/download?call=" + url.QueryEscape(sid)
+	}
+	rch <- resp
+}
+
+// fetchLegs gets the calls above and below call in the chain of calls
+// created with <Dial>, along with the other children of each call above it,
+// and arranges them in a tree. The tree starts at the oldest call u can view.
+func (c *callInstanceServer) fetchLegs(ctx context.Context, u *config.User, call *views.Call) (*callLeg, error) {
+	current := &callLeg{Call: call, Current: true}
+	g, errctx := errgroup.WithContext(ctx)
+	var root *callLeg
+	g.Go(func() error {
+		var err error
+		root, err = c.fetchAncestors(errctx, u, current)
+		return err
+	})
+	g.Go(func() error {
+		return c.fetchDescendants(errctx, u, current)
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	if root == current && len(current.Children) == 0 {
+		return nil, nil
+	}
+	markLoops(root, nil)
+	return root, nil
 }
 
 func (c *callInstanceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -373,16 +563,35 @@ func (c *callInstanceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	legs, legsErr := c.fetchLegs(ctx, u, call)
+	if legsErr != nil {
+		c.Warn("Error fetching call legs", "sid", sid, "err", legsErr)
+	}
+	// Without the events, the call just doesn't show machine detection
+	// results or digits.
+	if err := c.Client.LoadCallEvents(ctx, call); err != nil {
+		c.Warn("Error fetching call events", "sid", sid, "err", err)
+	}
 	alertsErr := g.Wait()
 	data := &baseData{
 		LF:       c.LocationFinder,
 		Duration: monotime.Since(start),
 	}
+	loc := c.LocationFinder.GetLocationReq(r)
 	cid := &callInstanceData{
-		Call:       call,
-		Loc:        c.LocationFinder.GetLocationReq(r),
-		AlertError: alertsErr,
-		Alerts:     alerts,
+		Call:      call,
+		Loc:       loc,
+		Legs:      legs,
+		LegsError: legsErr,
+		Chain:     newCallChain(legs),
+		Tickets:   c.Tickets.data("call", r.URL.Path, call, loc),
+		Notes:     notesFor(c.Notes, u, sid, loc),
+	}
+	if call.CanViewCallAlerts() {
+		cid.Alerts = &alertsResp{Noun: "call", Sid: sid, Err: alertsErr, Alerts: alerts, Runbooks: c.Runbooks}
+	}
+	if c.Webhooks != nil && u.CanViewCallbackURLs() && u.CanViewAlertPayloads() {
+		cid.Responses = c.Webhooks.CallResponses(sid, time.Now())
 	}
 	if u.CanViewNumRecordings() {
 		r := <-rch
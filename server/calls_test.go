@@ -1,9 +1,13 @@
 package server
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/saintpete/logrole/config"
 	"github.com/saintpete/logrole/test"
@@ -23,7 +27,7 @@ func TestGetFiltersGeneratesCorrectQuery(t *testing.T) {
 	}))
 	defer s.Close()
 	vc := harness.ViewsClient(harness.ViewHarness{SecretKey: key, TestServer: s})
-	c, err := newCallListServer(dlog, vc, lf, 1, config.DefaultMaxResourceAge, key)
+	c, err := newCallListServer(dlog, vc, lf, nil, nil, 1, config.DefaultMaxResourceAge, key)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -51,7 +55,7 @@ func TestNoEndGeneratesCorrectQuery(t *testing.T) {
 	}))
 	defer s.Close()
 	vc := harness.ViewsClient(harness.ViewHarness{SecretKey: key, TestServer: s})
-	c, err := newCallListServer(dlog, vc, lf, 1, config.DefaultMaxResourceAge, key)
+	c, err := newCallListServer(dlog, vc, lf, nil, nil, 1, config.DefaultMaxResourceAge, key)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -65,3 +69,252 @@ func TestNoEndGeneratesCorrectQuery(t *testing.T) {
 		t.Errorf("expected Code to be 200, got %d", w.Code)
 	}
 }
+
+func TestCallCountryFilter(t *testing.T) {
+	t.Parallel()
+	server := newServerWithResponse(200, test.CallListBody)
+	defer server.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	c, err := newCallListServer(dlog, vc, lf, nil, nil, 50, config.DefaultMaxResourceAge, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid := "CA14b8432d941d883a9b69e2598b0e57ba"
+	req, _ := http.NewRequest("GET", "/calls?country=us", nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), sid) {
+		t.Errorf("expected US calls to be shown, got %s", w.Body.String())
+	}
+	req, _ = http.NewRequest("GET", "/calls?country=GB", nil)
+	req = config.SetUser(req, theUser)
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), sid) {
+		t.Errorf("expected US calls to be filtered out")
+	}
+
+	u := config.NewUser(&config.UserSettings{CanViewCalls: true})
+	req, _ = http.NewRequest("GET", "/calls?country=US", nil)
+	req = config.SetUser(req, u)
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Errorf("expected Code to be 400 for a user who can't view numbers, got %d", w.Code)
+	}
+	req, _ = http.NewRequest("GET", "/calls?country=XX", nil)
+	req = config.SetUser(req, theUser)
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Errorf("expected Code to be 400 for an unknown country, got %d", w.Code)
+	}
+}
+
+const (
+	parentCallSid  = "CA00000000000000000000000000000001"
+	childCallSid   = "CA00000000000000000000000000000002"
+	siblingCallSid = "CA00000000000000000000000000000003"
+)
+
+func callJSON(sid, parentSid, created string) string {
+	parent := "null"
+	if parentSid != "" {
+		parent = `"` + parentSid + `"`
+	}
+	return fmt.Sprintf(`{"sid": %q, "parent_call_sid": %s, "date_created": %q,
+		"start_time": %q, "status": "completed", "duration": "10",
+		"direction": "outbound-dial", "from": "+19253920364", "to": "+16103317238"}`,
+		sid, parent, created, created)
+}
+
+func TestCallInstanceShowsLegs(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/Calls/"+parentCallSid+".json"):
+			w.Write([]byte(callJSON(parentCallSid, "", "Thu, 27 Oct 2016 23:27:03 +0000")))
+		case strings.HasSuffix(r.URL.Path, "/Calls/"+childCallSid+".json"):
+			w.Write([]byte(callJSON(childCallSid, parentCallSid, "Thu, 27 Oct 2016 23:27:05 +0000")))
+		case r.URL.Query().Get("ParentCallSid") == parentCallSid:
+			fmt.Fprintf(w, `{"calls": [%s, %s]}`,
+				callJSON(siblingCallSid, parentCallSid, "Thu, 27 Oct 2016 23:27:06 +0000"),
+				callJSON(childCallSid, parentCallSid, "Thu, 27 Oct 2016 23:27:05 +0000"))
+		case strings.HasSuffix(r.URL.Path, "/Calls.json"):
+			w.Write([]byte(`{"calls": []}`))
+		default:
+			w.Write([]byte(`{"alerts": [], "recordings": []}`))
+		}
+	}))
+	defer server.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	s, err := newCallInstanceServer(dlog, vc, lf, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/calls/"+childCallSid, nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Call Legs") {
+		t.Fatalf("expected call legs to be shown, got %s", body)
+	}
+	parent := strings.Index(body, `href="/calls/`+parentCallSid)
+	current := strings.Index(body, "This call")
+	sibling := strings.Index(body, `href="/calls/`+siblingCallSid)
+	if parent == -1 || current == -1 || sibling == -1 {
+		t.Fatalf("expected parent, current and sibling legs, got %s", body)
+	}
+	if !(parent < current && current < sibling) {
+		t.Errorf("expected legs to be ordered parent, current, sibling")
+	}
+}
+
+func TestCallInstanceShowsForwardingChain(t *testing.T) {
+	t.Parallel()
+	// A customer calls our number, which forwards to one number, which
+	// forwards to another, which forwards back to our number.
+	const (
+		customer = "+14155550100"
+		ours     = "+19253920364"
+		rootSid  = "CA00000000000000000000000000000011"
+		firstSid = "CA00000000000000000000000000000012"
+		curSid   = "CA00000000000000000000000000000013"
+		loopSid  = "CA00000000000000000000000000000014"
+	)
+	leg := func(sid, parentSid, to, start string, duration int) string {
+		parent := "null"
+		if parentSid != "" {
+			parent = `"` + parentSid + `"`
+		}
+		return fmt.Sprintf(`{"sid": %q, "parent_call_sid": %s, "date_created": %q,
+			"start_time": %q, "status": "completed", "duration": "%d",
+			"direction": "outbound-dial", "from": %q, "to": %q}`,
+			sid, parent, start, start, duration, customer, to)
+	}
+	calls := map[string]string{
+		rootSid:  leg(rootSid, "", ours, "Thu, 27 Oct 2016 23:27:00 +0000", 120),
+		firstSid: leg(firstSid, rootSid, "+16103317238", "Thu, 27 Oct 2016 23:27:05 +0000", 100),
+		curSid:   leg(curSid, firstSid, "+16103317239", "Thu, 27 Oct 2016 23:27:10 +0000", 90),
+		loopSid:  leg(loopSid, curSid, ours, "Thu, 27 Oct 2016 23:27:20 +0000", 30),
+	}
+	children := map[string]string{rootSid: firstSid, firstSid: curSid, curSid: loopSid}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		for sid, body := range calls {
+			if strings.HasSuffix(r.URL.Path, "/Calls/"+sid+".json") {
+				w.Write([]byte(body))
+				return
+			}
+		}
+		if strings.HasSuffix(r.URL.Path, "/Calls.json") {
+			if child, ok := children[r.URL.Query().Get("ParentCallSid")]; ok {
+				fmt.Fprintf(w, `{"calls": [%s]}`, calls[child])
+				return
+			}
+			w.Write([]byte(`{"calls": []}`))
+			return
+		}
+		w.Write([]byte(`{"alerts": [], "recordings": []}`))
+	}))
+	defer server.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	s, err := newCallInstanceServer(dlog, vc, lf, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/calls/"+curSid, nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	root := strings.Index(body, `href="/calls/`+rootSid)
+	first := strings.Index(body, `href="/calls/`+firstSid)
+	current := strings.Index(body, "This call")
+	loop := strings.Index(body, `href="/calls/`+loopSid)
+	if root == -1 || first == -1 || current == -1 || loop == -1 {
+		t.Fatalf("expected every call in the chain, got %s", body)
+	}
+	if !(root < first && first < current && current < loop) {
+		t.Errorf("expected calls to be ordered down the chain")
+	}
+	if !strings.Contains(body, "4 calls.") {
+		t.Errorf("expected the number of calls in the chain, got %s", body)
+	}
+	if !strings.Contains(body, "on the line for 2m0s") || !strings.Contains(body, "5m40s") {
+		t.Errorf("expected total and per-call durations, got %s", body)
+	}
+	if n := strings.Count(body, "call-leg-loop"); n != 1 {
+		t.Errorf("expected one call to be flagged as a loop, got %d", n)
+	}
+	if strings.Index(body, "call-leg-loop") < loop {
+		t.Errorf("expected the call back to our number to be flagged as a loop")
+	}
+}
+
+func TestCallListStreamsSlowResults(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	twilioServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(test.CallListBody)
+	}))
+	defer twilioServer.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: twilioServer, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	c, err := newCallListServer(dlog, vc, lf, nil, nil, 50, config.DefaultMaxResourceAge, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.ServeHTTP(w, config.SetUser(r, theUser))
+	}))
+	defer s.Close()
+	resp, err := http.Get(s.URL + "/calls")
+	if err != nil {
+		close(release)
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		close(release)
+		t.Fatalf("expected Code to be 200, got %d", resp.StatusCode)
+	}
+	// The filters should arrive before Twilio responds.
+	var body []byte
+	buf := make([]byte, 4096)
+	for !strings.Contains(string(body), "Export to CSV") {
+		n, err := resp.Body.Read(buf)
+		body = append(body, buf[:n]...)
+		if err != nil {
+			close(release)
+			t.Fatalf("expected the top of the page before the results, got %s (%v)", body, err)
+		}
+	}
+	if strings.Contains(string(body), "CA14b8432d941d883a9b69e2598b0e57ba") {
+		t.Errorf("expected results to arrive after the top of the page")
+	}
+	close(release)
+	remaining, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(remaining), "CA14b8432d941d883a9b69e2598b0e57ba") {
+		t.Errorf("expected results in the rest of the page, got %s", remaining)
+	}
+}
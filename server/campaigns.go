@@ -0,0 +1,504 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+var messagingServiceSid = regexp.MustCompile(`^MG[a-f0-9]{32}$`)
+
+// The most Messaging Services in one summary.
+const maxCampaignServices = 20
+
+// The longest period a summary can cover, and the period it covers if the
+// start isn't set.
+const maxCampaignWindow = 31 * 24 * time.Hour
+const defaultCampaignWindow = 24 * time.Hour
+
+// The period is split into this many slices, which are read at the same
+// time. Each slice stops after maxCampaignPages pages of dashboardPageSize
+// messages, and its counts are shown as lower bounds.
+const campaignSlices = 8
+const maxCampaignPages = 25
+
+// How long to reuse a summary. Statuses change for a while after a campaign
+// is sent, so this is short.
+const campaignTimeout = 5 * time.Minute
+
+// Counting runs in the background, since a big campaign can take minutes.
+const campaignCountTimeout = 5 * time.Minute
+
+// How many error codes to show for each service.
+const campaignTopErrors = 5
+
+// campaignStages are the steps in the delivery funnel, in order.
+var campaignStages = []string{"queued", "sent", "delivered", "undelivered", "failed"}
+
+// campaignStage returns the funnel stage for a message with the given status,
+// or the empty string if it isn't part of the funnel, like an inbound or
+// canceled message.
+func campaignStage(status twilio.Status) string {
+	switch status {
+	case twilio.StatusAccepted, twilio.StatusQueued, twilio.StatusSending, views.StatusScheduled:
+		return "queued"
+	case twilio.StatusSent:
+		return "sent"
+	case twilio.StatusDelivered, views.StatusRead:
+		return "delivered"
+	case twilio.StatusUndelivered:
+		return "undelivered"
+	case twilio.StatusFailed:
+		return "failed"
+	default:
+		return ""
+	}
+}
+
+// campaignCount is the messages sent through one Messaging Service.
+type campaignCount struct {
+	Stages map[string]int
+	Errors map[twilio.Code]int
+}
+
+func newCampaignCount() *campaignCount {
+	return &campaignCount{Stages: make(map[string]int), Errors: make(map[twilio.Code]int)}
+}
+
+func (c *campaignCount) add(other *campaignCount) {
+	for stage, n := range other.Stages {
+		c.Stages[stage] += n
+	}
+	for code, n := range other.Errors {
+		c.Errors[code] += n
+	}
+}
+
+// campaignCounts are cached for each user, list of services and period.
+type campaignCounts struct {
+	// Keyed by Messaging Service sid.
+	Services map[string]*campaignCount
+	// True if we stopped reading a slice before reaching its start.
+	Truncated  bool
+	Err        string
+	ComputedAt time.Time
+}
+
+type campaignStep struct {
+	Stage   string
+	Count   int
+	Percent float64
+}
+
+// Label returns the stage's name for the funnel, like "Delivered".
+func (c *campaignStep) Label() string {
+	return strings.ToUpper(c.Stage[:1]) + c.Stage[1:]
+}
+
+type campaignError struct {
+	Code  twilio.Code
+	Count int
+}
+
+// A campaignFunnel is the summary of one Messaging Service.
+type campaignFunnel struct {
+	Service string
+	Total   int
+	Steps   []*campaignStep
+	Errors  []*campaignError
+}
+
+type campaignServer struct {
+	log.Logger
+	Client         views.Client
+	LocationFinder services.LocationFinder
+	MaxResourceAge time.Duration
+	// Runbook links and notes for error codes. May be nil.
+	Runbooks *config.Runbooks
+	cache    *cache.Cache
+	tpl      *template.Template
+
+	mu sync.Mutex
+	// Keys of summaries being counted right now.
+	running map[string]bool
+}
+
+func newCampaignServer(l log.Logger, vc views.Client, lf services.LocationFinder, maxResourceAge time.Duration) (*campaignServer, error) {
+	s := &campaignServer{
+		Logger:         l,
+		Client:         vc,
+		LocationFinder: lf,
+		MaxResourceAge: maxResourceAge,
+		cache:          cache.NewCache(100, l),
+		running:        make(map[string]bool),
+	}
+	tpl, err := newTpl(template.FuncMap{}, base+campaignTpl+runbookTpl)
+	if err != nil {
+		return nil, err
+	}
+	s.tpl = tpl
+	return s, nil
+}
+
+type campaignData struct {
+	Funnels []*campaignFunnel
+	// The sids, one per line, to put back in the form.
+	Services string
+	Start    time.Time
+	End      time.Time
+	Loc      *time.Location
+	// True while the counts are being computed in the background.
+	Counting   bool
+	Truncated  bool
+	ComputedAt time.Time
+	Err        string
+	Runbooks   *config.Runbooks
+}
+
+func (d *campaignData) Title() string {
+	return "Campaign Summary"
+}
+
+func (d *campaignData) Path() string {
+	return "/messages/campaigns"
+}
+
+func (s *campaignServer) validParams() []string {
+	return []string{"services", "start", "end"}
+}
+
+func (s *campaignServer) renderError(w http.ResponseWriter, r *http.Request, code int, query url.Values, err error) {
+	data := &baseData{
+		LF: s.LocationFinder,
+		Data: &campaignData{
+			Services: query.Get("services"),
+			Loc:      s.LocationFinder.GetLocationReq(r),
+			Err:      cleanError(err),
+		},
+	}
+	s.Warn("Error responding to request", "status", code, "url", r.URL.String(), "err", err)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", data); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// parseCampaignServices splits a pasted list of Messaging Service sids on
+// commas and whitespace, and returns them sorted, without duplicates.
+func parseCampaignServices(val string) ([]string, error) {
+	fields := strings.FieldsFunc(val, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	})
+	seen := make(map[string]bool, len(fields))
+	sids := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if !messagingServiceSid.MatchString(field) {
+			return nil, fmt.Errorf("%q isn't a Messaging Service sid, like MG123", field)
+		}
+		if seen[field] {
+			continue
+		}
+		seen[field] = true
+		sids = append(sids, field)
+	}
+	if len(sids) > maxCampaignServices {
+		return nil, fmt.Errorf("Can't summarize more than %d Messaging Services at once", maxCampaignServices)
+	}
+	sort.Strings(sids)
+	return sids, nil
+}
+
+// campaignWindow fills in a start or end that wasn't set, and checks the
+// period isn't too long to read. start may already be limited by the user's
+// max resource age, even if they didn't set it.
+func campaignWindow(start, end, now time.Time, startSet bool) (time.Time, time.Time, error) {
+	if end.After(now) {
+		end = now
+	}
+	if def := end.Add(-defaultCampaignWindow); !startSet && start.Before(def) {
+		start = def
+	}
+	if !start.Before(end) {
+		return start, end, errors.New("The start of the period has to be before the end")
+	}
+	if end.Sub(start) > maxCampaignWindow {
+		return start, end, fmt.Errorf("Can't summarize more than %d days at once", int(maxCampaignWindow/(24*time.Hour)))
+	}
+	return start, end, nil
+}
+
+// GET /messages/campaigns?services=MG123%0AMG456&start=...&end=...
+//
+// Show a delivery funnel for each Messaging Service - how many of the
+// messages sent through it in the period are queued, sent, delivered,
+// undelivered or failed, and the most common error codes. The API can't
+// filter messages by service, so this reads every message in the period, in
+// the background.
+func (s *campaignServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewMessages() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	query := r.URL.Query()
+	if err := validateParams(s.validParams(), query); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, err)
+		return
+	}
+	sids, err := parseCampaignServices(query.Get("services"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, err)
+		return
+	}
+	loc := s.LocationFinder.GetLocationReq(r)
+	start, end, wroteError := getTimes(w, r, "start", "end", loc, u.MaxResourceAge(s.MaxResourceAge), query, s)
+	if wroteError {
+		return
+	}
+	// Round down to the minute, like the times in the form, so the page can
+	// refresh with the same period until the counts are ready.
+	now := time.Now().Truncate(time.Minute).In(loc)
+	start, end, err = campaignWindow(start, end, now, query.Get("start") != "")
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, err)
+		return
+	}
+	data := &campaignData{
+		Services: strings.Join(sids, "\n"),
+		Start:    start,
+		End:      end,
+		Loc:      loc,
+		Runbooks: s.Runbooks,
+	}
+	if len(sids) > 0 {
+		key := fmt.Sprintf("campaigns:%s:%d:%d:%s", strings.Join(sids, ","), start.Unix(), end.Unix(), u.ID())
+		counts := new(campaignCounts)
+		if _, err := s.cache.Get(key, counts); err != nil {
+			s.start(key, u, sids, start, end)
+			data.Counting = true
+		} else {
+			data.Funnels = buildCampaignFunnels(counts, sids)
+			data.Truncated = counts.Truncated
+			data.ComputedAt = counts.ComputedAt
+			data.Err = counts.Err
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// start counts the summary for key in the background, unless it's already
+// being counted.
+func (s *campaignServer) start(key string, u *config.User, sids []string, start, end time.Time) {
+	s.mu.Lock()
+	if s.running[key] {
+		s.mu.Unlock()
+		return
+	}
+	s.running[key] = true
+	s.mu.Unlock()
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.running, key)
+			s.mu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), campaignCountTimeout)
+		defer cancel()
+		counts := s.count(ctx, u, sids, start, end)
+		if counts.Err != "" {
+			s.Warn("Error counting campaign", "key", key, "err", counts.Err)
+			// Cache the failure briefly, so the page stops refreshing and
+			// shows the error.
+			s.cache.Set(key, counts, 10*time.Second)
+			return
+		}
+		s.cache.Set(key, counts, campaignTimeout)
+	}()
+}
+
+// campaignSliceBounds splits [start, end) into up to campaignSlices equal
+// slices of at least an hour.
+func campaignSliceBounds(start, end time.Time) []time.Time {
+	n := int(end.Sub(start) / time.Hour)
+	if n > campaignSlices {
+		n = campaignSlices
+	}
+	if n < 1 {
+		n = 1
+	}
+	step := end.Sub(start) / time.Duration(n)
+	bounds := make([]time.Time, 0, n+1)
+	for i := 0; i < n; i++ {
+		bounds = append(bounds, start.Add(time.Duration(i)*step))
+	}
+	return append(bounds, end)
+}
+
+// count reads the messages in each slice of the period at the same time, and
+// adds up the ones sent through the services in sids.
+func (s *campaignServer) count(ctx context.Context, u *config.User, sids []string, start, end time.Time) *campaignCounts {
+	wanted := make(map[string]bool, len(sids))
+	for _, sid := range sids {
+		wanted[sid] = true
+	}
+	bounds := campaignSliceBounds(start, end)
+	// Each goroutine writes to its own slice; nothing is read until g.Wait
+	// returns.
+	slices := make([]map[string]*campaignCount, len(bounds)-1)
+	truncated := make([]bool, len(bounds)-1)
+	g, errctx := errgroup.WithContext(ctx)
+	for i := range slices {
+		i := i
+		slices[i] = make(map[string]*campaignCount)
+		g.Go(func() error {
+			var err error
+			truncated[i], err = s.countSlice(errctx, u, bounds[i], bounds[i+1], wanted, slices[i])
+			return err
+		})
+	}
+	counts := &campaignCounts{Services: make(map[string]*campaignCount), ComputedAt: time.Now()}
+	if err := g.Wait(); err != nil {
+		counts.Err = cleanError(err)
+		return counts
+	}
+	for i, slice := range slices {
+		counts.Truncated = counts.Truncated || truncated[i]
+		for sid, count := range slice {
+			if _, ok := counts.Services[sid]; !ok {
+				counts.Services[sid] = newCampaignCount()
+			}
+			counts.Services[sid].add(count)
+		}
+	}
+	return counts
+}
+
+func (s *campaignServer) countSlice(ctx context.Context, u *config.User, start, end time.Time, wanted map[string]bool, counts map[string]*campaignCount) (bool, error) {
+	page, _, err := s.Client.GetMessagePageInRange(ctx, u, start, end, dashboardFilters())
+	for pages := 1; ; pages++ {
+		if err == twilio.NoMoreResults {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, message := range page.Messages() {
+			sid, err := message.MessagingServiceSid()
+			if err != nil || !wanted[sid.String] {
+				continue
+			}
+			status, err := message.Status()
+			if err != nil {
+				continue
+			}
+			stage := campaignStage(status)
+			if stage == "" {
+				continue
+			}
+			count, ok := counts[sid.String]
+			if !ok {
+				count = newCampaignCount()
+				counts[sid.String] = count
+			}
+			count.Stages[stage]++
+			if code, err := message.ErrorCode(); err == nil && code != 0 {
+				count.Errors[code]++
+			}
+		}
+		next := page.NextPageURI()
+		if !next.Valid {
+			return false, nil
+		}
+		if pages >= maxCampaignPages {
+			return true, nil
+		}
+		page, _, err = s.Client.GetNextMessagePageInRange(ctx, u, start, end, next.String)
+	}
+}
+
+type campaignErrorsByCount []*campaignError
+
+func (c campaignErrorsByCount) Len() int      { return len(c) }
+func (c campaignErrorsByCount) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c campaignErrorsByCount) Less(i, j int) bool {
+	if c[i].Count == c[j].Count {
+		return c[i].Code < c[j].Code
+	}
+	return c[i].Count > c[j].Count
+}
+
+// buildCampaignFunnels returns a funnel for each of sids, in order, including
+// services that didn't send anything.
+func buildCampaignFunnels(counts *campaignCounts, sids []string) []*campaignFunnel {
+	funnels := make([]*campaignFunnel, 0, len(sids))
+	for _, sid := range sids {
+		funnel := &campaignFunnel{Service: sid}
+		count, ok := counts.Services[sid]
+		if !ok {
+			count = newCampaignCount()
+		}
+		for _, stage := range campaignStages {
+			funnel.Total += count.Stages[stage]
+		}
+		for _, stage := range campaignStages {
+			step := &campaignStep{Stage: stage, Count: count.Stages[stage]}
+			if funnel.Total > 0 {
+				step.Percent = 100 * float64(step.Count) / float64(funnel.Total)
+			}
+			funnel.Steps = append(funnel.Steps, step)
+		}
+		for code, n := range count.Errors {
+			funnel.Errors = append(funnel.Errors, &campaignError{Code: code, Count: n})
+		}
+		sort.Sort(campaignErrorsByCount(funnel.Errors))
+		if len(funnel.Errors) > campaignTopErrors {
+			funnel.Errors = funnel.Errors[:campaignTopErrors]
+		}
+		funnels = append(funnels, funnel)
+	}
+	return funnels
+}
+
+// MessagesURL returns the list of messages in the summary's period. The list
+// can't be filtered by service.
+func (d *campaignData) MessagesURL() string {
+	data := url.Values{}
+	data.Set("start", d.Start.Format(HTML5DatetimeLocalFormat))
+	data.Set("end", d.End.Format(HTML5DatetimeLocalFormat))
+	return "/messages?" + data.Encode()
+}
+
+// RefreshURL returns this summary with the period filled in, so refreshing
+// while it's counted doesn't move the end of the period.
+func (d *campaignData) RefreshURL() string {
+	data := url.Values{}
+	data.Set("services", d.Services)
+	data.Set("start", d.Start.Format(HTML5DatetimeLocalFormat))
+	data.Set("end", d.End.Format(HTML5DatetimeLocalFormat))
+	return d.Path() + "?" + data.Encode()
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/test/harness"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+const campaignService = "MG0123456789abcdef0123456789abcdef"
+const otherCampaignService = "MGfedcba9876543210fedcba9876543210"
+
+var campaignMessages = []byte(`{"messages": [
+  {"sid": "SM1", "date_created": "Thu, 20 Oct 2016 21:13:02 +0000", "direction": "outbound-api", "status": "delivered", "messaging_service_sid": "` + campaignService + `", "error_code": null, "from": "+14105551234", "to": "+14155551234"},
+  {"sid": "SM2", "date_created": "Thu, 20 Oct 2016 21:13:01 +0000", "direction": "outbound-api", "status": "undelivered", "messaging_service_sid": "` + campaignService + `", "error_code": 30007, "from": "+14105551234", "to": "+14155551235"},
+  {"sid": "SM3", "date_created": "Thu, 20 Oct 2016 21:13:00 +0000", "direction": "outbound-api", "status": "failed", "messaging_service_sid": "` + campaignService + `", "error_code": 30007, "from": "+14105551234", "to": "+14155551236"},
+  {"sid": "SM4", "date_created": "Thu, 20 Oct 2016 21:12:59 +0000", "direction": "outbound-api", "status": "delivered", "messaging_service_sid": "` + otherCampaignService + `", "error_code": null, "from": "+14105551234", "to": "+14155551237"},
+  {"sid": "SM5", "date_created": "Thu, 20 Oct 2016 21:12:58 +0000", "direction": "inbound", "status": "received", "messaging_service_sid": "` + campaignService + `", "error_code": null, "from": "+14155551234", "to": "+14105551234"}
+], "next_page_uri": null}`)
+
+func TestParseCampaignServices(t *testing.T) {
+	t.Parallel()
+	sids, err := parseCampaignServices(otherCampaignService + ",\r\n" + campaignService + "\n" + campaignService + " ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sids) != 2 || sids[0] != campaignService || sids[1] != otherCampaignService {
+		t.Errorf("expected two sorted sids, got %v", sids)
+	}
+	if _, err := parseCampaignServices("MG123"); err == nil {
+		t.Error("expected a bad sid to fail")
+	}
+	if sids, err := parseCampaignServices(""); err != nil || len(sids) != 0 {
+		t.Errorf("expected no sids, got %v, %v", sids, err)
+	}
+}
+
+func TestCampaignWindow(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2016, 10, 21, 12, 0, 0, 0, time.UTC)
+	start, end, err := campaignWindow(twilio.Epoch, twilio.HeatDeath, now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !end.Equal(now) || end.Sub(start) != defaultCampaignWindow {
+		t.Errorf("expected the last day, got %v to %v", start, end)
+	}
+	cutoff := now.Add(-time.Hour)
+	if start, _, _ := campaignWindow(cutoff, twilio.HeatDeath, now, false); !start.Equal(cutoff) {
+		t.Errorf("expected the max resource age to be kept, got %v", start)
+	}
+	if _, _, err := campaignWindow(now.AddDate(0, -2, 0), now, now, true); err == nil {
+		t.Error("expected two months to be too long")
+	}
+	if _, _, err := campaignWindow(now, now.Add(-time.Hour), now, true); err == nil {
+		t.Error("expected a start after the end to fail")
+	}
+}
+
+func TestCampaignSliceBounds(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2016, 10, 20, 0, 0, 0, 0, time.UTC)
+	if bounds := campaignSliceBounds(start, start.Add(30*time.Minute)); len(bounds) != 2 {
+		t.Errorf("expected a short period to be one slice, got %v", bounds)
+	}
+	bounds := campaignSliceBounds(start, start.Add(24*time.Hour))
+	if len(bounds) != campaignSlices+1 || !bounds[1].Equal(start.Add(3*time.Hour)) || !bounds[campaignSlices].Equal(start.Add(24*time.Hour)) {
+		t.Errorf("bad bounds: %v", bounds)
+	}
+}
+
+func TestCampaignCount(t *testing.T) {
+	t.Parallel()
+	server := newServerWithResponse(200, campaignMessages)
+	defer server.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	s, err := newCampaignServer(dlog, vc, lf, 1000*1000*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2016, 10, 20, 12, 0, 0, 0, time.UTC)
+	sids := []string{campaignService}
+	counts := s.count(context.Background(), theUser, sids, start, start.Add(24*time.Hour))
+	if counts.Err != "" {
+		t.Fatal(counts.Err)
+	}
+	funnels := buildCampaignFunnels(counts, sids)
+	if len(funnels) != 1 {
+		t.Fatalf("expected one funnel, got %d", len(funnels))
+	}
+	funnel := funnels[0]
+	if funnel.Total != 3 {
+		t.Errorf("expected 3 outbound messages through the service, got %d", funnel.Total)
+	}
+	for _, step := range funnel.Steps {
+		want := 0
+		switch step.Stage {
+		case "delivered", "undelivered", "failed":
+			want = 1
+		}
+		if step.Count != want {
+			t.Errorf("expected %d %s, got %d", want, step.Stage, step.Count)
+		}
+	}
+	if len(funnel.Errors) != 1 || funnel.Errors[0].Code != 30007 || funnel.Errors[0].Count != 2 {
+		t.Errorf("expected 30007 twice, got %#v", funnel.Errors)
+	}
+}
+
+func TestCampaignServer(t *testing.T) {
+	t.Parallel()
+	vc := harness.ViewsClient(harness.ViewHarness{SecretKey: key})
+	s, err := newCampaignServer(dlog, vc, lf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/messages/campaigns", nil)
+	req = config.SetUser(req, config.NewUser(&config.UserSettings{CanViewCalls: true}))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected users who can't see messages to get 403, got %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/messages/campaigns?services=SM123", nil)
+	req = config.SetUser(req, theUser)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Errorf("expected a message sid to get 400, got %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/messages/campaigns", nil)
+	req = config.SetUser(req, theUser)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	if body := w.Body.String(); strings.Contains(body, "Reading messages") || !strings.Contains(body, `name="services"`) {
+		t.Errorf("expected just the form without any services, got %s", body)
+	}
+}
@@ -6,13 +6,11 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aristanetworks/goarista/monotime"
 	log "github.com/inconshreveable/log15"
-	types "github.com/kevinburke/go-types"
 	"github.com/kevinburke/rest"
 	"github.com/saintpete/logrole/config"
 	"github.com/saintpete/logrole/services"
@@ -32,7 +30,9 @@ type conferenceListServer struct {
 	MaxResourceAge time.Duration
 	LocationFinder services.LocationFinder
 	secretKey      *[32]byte
-	tpl            *template.Template
+	// nil if prefetching is disabled.
+	Prefetcher *prefetcher
+	tpl        *template.Template
 }
 
 type conferenceListData struct {
@@ -42,6 +42,11 @@ type conferenceListData struct {
 	Loc                   *time.Location
 	EncryptedNextPage     string
 	EncryptedPreviousPage string
+	// The age of the oldest conference the user can view.
+	MaxResourceAge time.Duration
+	// Swap in new results when the filters or the page change, instead of
+	// loading the whole page.
+	Partial bool
 }
 
 func (d *conferenceListData) Title() string {
@@ -94,11 +99,11 @@ func newConferenceListServer(l log.Logger, vc views.Client,
 		secretKey:      secretKey,
 	}
 	tpl, err := newTpl(template.FuncMap{
-		"min":       minFunc(s.MaxResourceAge),
+		"min":       minLoc,
 		"max":       maxLoc,
 		"start_val": s.StartSearchVal,
 		"end_val":   s.EndSearchVal,
-	}, base+conferenceListTpl+copyScript+pagingTpl)
+	}, base+conferenceListTpl+copyScript+pagingTpl+partialListsScript)
 	if err != nil {
 		return nil, err
 	}
@@ -134,15 +139,15 @@ func (c *conferenceListData) PreviousQuery() template.URL {
 	return template.URL(data.Encode())
 }
 
-func (s *conferenceListServer) StartSearchVal(query url.Values, loc *time.Location) string {
+func (s *conferenceListServer) StartSearchVal(query url.Values, maxAge time.Duration, loc *time.Location) string {
 	if start, ok := query["created-after"]; ok {
 		return start[0]
 	}
-	if s.MaxResourceAge == config.DefaultMaxResourceAge {
+	if maxAge == config.DefaultMaxResourceAge {
 		// one week ago, arbitrary
 		return minLoc(7*24*time.Hour, loc)
 	} else {
-		return minLoc(s.MaxResourceAge, loc)
+		return minLoc(maxAge, loc)
 	}
 }
 
@@ -158,10 +163,11 @@ func (c *conferenceListServer) renderError(w http.ResponseWriter, r *http.Reques
 	data := &baseData{
 		LF: c.LocationFinder,
 		Data: &conferenceListData{
-			Err:   str,
-			Query: query,
-			Loc:   c.LocationFinder.GetLocationReq(r),
-			Page:  new(views.ConferencePage),
+			Err:            str,
+			Query:          query,
+			Loc:            c.LocationFinder.GetLocationReq(r),
+			Page:           new(views.ConferencePage),
+			MaxResourceAge: userMaxResourceAge(r, c.MaxResourceAge),
 		},
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -195,11 +201,13 @@ func (c *conferenceListServer) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	loc := c.LocationFinder.GetLocationReq(r)
 	// We always set startTime and endTime on the request, though they may end
 	// up just being sentinels
-	startTime, endTime, wroteError := getTimes(w, r, "created-after", "created-before", loc, query, c)
+	maxAge := u.MaxResourceAge(c.MaxResourceAge)
+	startTime, endTime, wroteError := getTimes(w, r, "created-after", "created-before", loc, maxAge, query, c)
 	if wroteError {
 		return
 	}
-	next, nextErr := getNext(query, c.secretKey)
+	scope := pageScope(u, query, "created-after", "created-before")
+	next, nextErr := getNext(query, scope, c.secretKey)
 	if nextErr != nil {
 		err = errors.New("Could not decrypt `next` query parameter: " + nextErr.Error())
 		c.renderError(w, r, http.StatusBadRequest, query, err)
@@ -217,10 +225,11 @@ func (c *conferenceListServer) ServeHTTP(w http.ResponseWriter, r *http.Request)
 			return
 		}
 		page, cachedAt, err = c.Client.GetNextConferencePageInRange(ctx, u, startTime, endTime, next)
+		c.Prefetcher.Requested("conferences", next)
 		setNextPageValsOnQuery(next, query)
 	} else {
 		data := url.Values{}
-		data.Set("PageSize", strconv.FormatUint(uint64(c.PageSize), 10))
+		data.Set("PageSize", userPageSize(r, u, c.PageSize))
 		if filterErr := setPageFilters(query, data); filterErr != nil {
 			c.renderError(w, r, http.StatusBadRequest, query, filterErr)
 			return
@@ -236,13 +245,12 @@ func (c *conferenceListServer) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	// Fetch the next page into the cache
-	go func(u *config.User, n types.NullString, start, end time.Time) {
-		if n.Valid {
-			if _, _, err := c.Client.GetNextConferencePageInRange(context.Background(), u, start, end, n.String); err != nil {
-				c.Debug("Error fetching next page", "err", err)
-			}
-		}
-	}(u, page.NextPageURI(), startTime, endTime)
+	if n := page.NextPageURI(); n.Valid {
+		c.Prefetcher.Prefetch(services.DetachCallBudget(r.Context()), "conferences", n.String, func(ctx context.Context) error {
+			_, _, err := c.Client.GetNextConferencePageInRange(ctx, u, startTime, endTime, n.String)
+			return err
+		})
+	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	data := &baseData{
 		LF:       c.LocationFinder,
@@ -251,8 +259,10 @@ func (c *conferenceListServer) ServeHTTP(w http.ResponseWriter, r *http.Request)
 			Query:                 r.URL.Query(),
 			Page:                  page,
 			Loc:                   loc,
-			EncryptedNextPage:     getEncryptedPage(page.NextPageURI(), c.secretKey),
-			EncryptedPreviousPage: getEncryptedPage(page.PreviousPageURI(), c.secretKey),
+			MaxResourceAge:        maxAge,
+			EncryptedNextPage:     getEncryptedPage(page.NextPageURI(), scope, c.secretKey),
+			EncryptedPreviousPage: getEncryptedPage(page.PreviousPageURI(), scope, c.secretKey),
+			Partial:               u.Feature(config.FeaturePartialLists),
 		},
 	}
 	if cachedAt > 0 {
@@ -302,8 +312,9 @@ func (c *conferenceInstanceServer) ServeHTTP(w http.ResponseWriter, r *http.Requ
 		LF:       c.LocationFinder,
 		Duration: monotime.Since(start),
 		Data: &conferenceInstanceData{
-			Conference: conference,
-			Loc:        c.LocationFinder.GetLocationReq(r),
+			Conference:            conference,
+			Loc:                   c.LocationFinder.GetLocationReq(r),
+			CanDownloadRecordings: u.CanDownloadRecordings(),
 		},
 	}
 	if err := render(w, r, c.tpl, "base", data); err != nil {
@@ -312,8 +323,9 @@ func (c *conferenceInstanceServer) ServeHTTP(w http.ResponseWriter, r *http.Requ
 }
 
 type conferenceInstanceData struct {
-	Conference *views.Conference
-	Loc        *time.Location
+	Conference            *views.Conference
+	Loc                   *time.Location
+	CanDownloadRecordings bool
 }
 
 func (c *conferenceInstanceData) Title() string {
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+)
+
+const conversationPattern = `(?P<sid>CH[a-f0-9]{32})`
+
+var conversationInstanceRoute = regexp.MustCompile("^/conversations/" + conversationPattern + "$")
+
+// conversationListServer lists conversations from the Conversations API, for
+// chat traffic that doesn't show up in the message list.
+type conversationListServer struct {
+	log.Logger
+	Finder         views.ConversationFinder
+	LocationFinder services.LocationFinder
+	PageSize       uint
+	secretKey      *[32]byte
+	tpl            *template.Template
+}
+
+type conversationListData struct {
+	Err                   string
+	Query                 url.Values
+	Page                  *views.ConversationPage
+	Loc                   *time.Location
+	EncryptedNextPage     string
+	EncryptedPreviousPage string
+}
+
+func (d *conversationListData) Title() string {
+	return "Conversations"
+}
+
+func (d *conversationListData) Path() string {
+	return "/conversations"
+}
+
+func (d *conversationListData) States() []string {
+	return views.ConversationStates()
+}
+
+func (d *conversationListData) NextQuery() template.URL {
+	return d.pageQuery(d.EncryptedNextPage)
+}
+
+func (d *conversationListData) PreviousQuery() template.URL {
+	return d.pageQuery(d.EncryptedPreviousPage)
+}
+
+func (d *conversationListData) pageQuery(page string) template.URL {
+	data := url.Values{}
+	if page != "" {
+		data.Set("next", page)
+	}
+	if state := d.Query.Get("state"); state != "" {
+		data.Set("state", state)
+	}
+	return template.URL(data.Encode())
+}
+
+func newConversationListServer(l log.Logger, finder views.ConversationFinder,
+	lf services.LocationFinder, pageSize uint, secretKey *[32]byte) (*conversationListServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+conversationListTpl+pagingTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &conversationListServer{
+		Logger:         l,
+		Finder:         finder,
+		LocationFinder: lf,
+		PageSize:       pageSize,
+		secretKey:      secretKey,
+		tpl:            tpl,
+	}, nil
+}
+
+func (s *conversationListServer) validParams() []string {
+	return []string{"state", "next"}
+}
+
+func (s *conversationListServer) renderError(w http.ResponseWriter, r *http.Request, code int, query url.Values, err error) {
+	data := &baseData{
+		LF: s.LocationFinder,
+		Data: &conversationListData{
+			Err:   cleanError(err),
+			Query: query,
+			Loc:   s.LocationFinder.GetLocationReq(r),
+			Page:  new(views.ConversationPage),
+		},
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", data); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// GET /conversations?state=active
+func (s *conversationListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewMessages() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	query := r.URL.Query()
+	if err := validateParams(s.validParams(), query); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, err)
+		return
+	}
+	scope := pageScope(u, query, "", "")
+	next, err := getNext(query, scope, s.secretKey)
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, errors.New("Could not decrypt `next` query parameter: "+err.Error()))
+		return
+	}
+	if next != "" && !strings.HasPrefix(next, "/v1/Conversations?") {
+		s.Warn("Invalid next page URI", "next", next, "opaque", query.Get("next"))
+		s.renderError(w, r, http.StatusBadRequest, query, errors.New("Invalid next page uri"))
+		return
+	}
+	data := url.Values{}
+	data.Set("PageSize", userPageSize(r, u, s.PageSize))
+	if state := query.Get("state"); state != "" {
+		if !validConversationState(state) {
+			s.renderError(w, r, http.StatusBadRequest, query, fmt.Errorf("Invalid state %q", state))
+			return
+		}
+		data.Set("State", state)
+	}
+	ctx, cancel := getContext(r.Context(), 3*time.Second)
+	defer cancel()
+	start := monotime.Now()
+	page, err := s.Finder.GetConversationPage(ctx, u, data, next)
+	if err != nil {
+		s.Warn("Couldn't fetch conversations", "err", err)
+		s.renderError(w, r, http.StatusBadGateway, query, errors.New("Couldn't load conversations from Twilio: "+err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	bd := &baseData{
+		LF:       s.LocationFinder,
+		Duration: monotime.Since(start),
+		Data: &conversationListData{
+			Query:                 query,
+			Page:                  page,
+			Loc:                   s.LocationFinder.GetLocationReq(r),
+			EncryptedNextPage:     getEncryptedPage(page.NextPageURI(), scope, s.secretKey),
+			EncryptedPreviousPage: getEncryptedPage(page.PreviousPageURI(), scope, s.secretKey),
+		},
+	}
+	if err := render(w, r, s.tpl, "base", bd); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func validConversationState(state string) bool {
+	for _, s := range views.ConversationStates() {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+type conversationInstanceServer struct {
+	log.Logger
+	Finder         views.ConversationFinder
+	LocationFinder services.LocationFinder
+	tpl            *template.Template
+}
+
+type conversationInstanceData struct {
+	Conversation *views.Conversation
+	Loc          *time.Location
+}
+
+func (d *conversationInstanceData) Title() string {
+	return "Conversation Details"
+}
+
+func newConversationInstanceServer(l log.Logger, finder views.ConversationFinder,
+	lf services.LocationFinder) (*conversationInstanceServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+conversationInstanceTpl+sidTpl+copyScript)
+	if err != nil {
+		return nil, err
+	}
+	return &conversationInstanceServer{
+		Logger:         l,
+		Finder:         finder,
+		LocationFinder: lf,
+		tpl:            tpl,
+	}, nil
+}
+
+func (s *conversationInstanceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewMessages() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	sid := conversationInstanceRoute.FindStringSubmatch(r.URL.Path)[1]
+	ctx, cancel := getContext(r.Context(), 5*time.Second)
+	defer cancel()
+	start := monotime.Now()
+	conversation, err := s.Finder.GetConversation(ctx, u, sid)
+	switch err {
+	case nil:
+		break
+	case config.PermissionDenied, config.ErrTooOld:
+		rest.Forbidden(w, r, &rest.Error{Title: err.Error()})
+		return
+	default:
+		if terr, ok := err.(*rest.Error); ok && terr.StatusCode == 404 {
+			rest.NotFound(w, r)
+			return
+		}
+		rest.ServerError(w, r, err)
+		return
+	}
+	data := &baseData{
+		LF:       s.LocationFinder,
+		Duration: monotime.Since(start),
+		Data: &conversationInstanceData{
+			Conversation: conversation,
+			Loc:          s.LocationFinder.GetLocationReq(r),
+		},
+	}
+	if err := render(w, r, s.tpl, "base", data); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+)
+
+const conversationSid = "CH0123456789abcdef0123456789abcdef"
+
+var conversationResponses = map[string]string{
+	"/v1/Conversations":                                      `{"conversations": [{"sid": "` + conversationSid + `", "friendly_name": "Support chat", "state": "active", "date_created": "2016-11-01T17:03:12Z", "date_updated": "2016-11-01T17:05:12Z"}], "meta": {"next_page_url": "https://conversations.twilio.com/v1/Conversations?PageSize=50&Page=1&PageToken=PT123"}}`,
+	"/v1/Conversations/" + conversationSid:                   `{"sid": "` + conversationSid + `", "friendly_name": "Support chat", "state": "active", "date_created": "2016-11-01T17:03:12Z", "date_updated": "2016-11-01T17:05:12Z"}`,
+	"/v1/Conversations/" + conversationSid + "/Participants": `{"participants": [{"sid": "MB1", "messaging_binding": {"type": "sms", "address": "+14105551234", "proxy_address": "+19253920364"}}, {"sid": "MB2", "identity": "agent@example.com"}], "meta": {}}`,
+	"/v1/Conversations/" + conversationSid + "/Messages":     `{"messages": [{"sid": "IM2", "index": 1, "author": "agent@example.com", "body": "How can I help?", "participant_sid": "MB2", "date_created": "2016-11-01T17:05:12Z"}, {"sid": "IM1", "index": 0, "author": "+14105551234", "body": "My order is late", "participant_sid": "MB1", "date_created": "2016-11-01T17:04:12Z"}], "meta": {"next_page_url": null}}`,
+}
+
+func newConversationTestServer() *harness.TwilioServer {
+	ts := harness.NewTwilioServer(&views.Fixtures{})
+	for path, body := range conversationResponses {
+		ts.Respond(path, body)
+	}
+	return ts
+}
+
+func TestConversationPages(t *testing.T) {
+	t.Parallel()
+	ts := newConversationTestServer()
+	defer ts.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: ts.Server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	finder := vc.(views.ConversationFinder)
+	ls, err := newConversationListServer(NullLogger, finder, lf, 50, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/conversations", nil)
+	req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+	w := httptest.NewRecorder()
+	ls.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, want := range []string{"Support chat", "/conversations/" + conversationSid, "btn-next"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("expected list to contain %q, got %s", want, w.Body.String())
+		}
+	}
+
+	is, err := newConversationInstanceServer(NullLogger, finder, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := config.AllUserSettings()
+	us.CanViewMessageFrom = false
+	req, _ = http.NewRequest("GET", "/conversations/"+conversationSid, nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w = httptest.NewRecorder()
+	is.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if i, j := strings.Index(body, "My order is late"), strings.Index(body, "How can I help?"); i == -1 || j == -1 || i > j {
+		t.Errorf("expected both messages, oldest first, got %s", body)
+	}
+	for _, hidden := range []string{"+14105551234", "agent@example.com"} {
+		if strings.Contains(body, hidden) {
+			t.Errorf("expected %q to be hidden without can_view_message_from", hidden)
+		}
+	}
+}
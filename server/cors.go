@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kevinburke/handlers"
+	"github.com/saintpete/logrole/config"
+)
+
+// handle registers h for requests to pattern with the given methods. If GET
+// is allowed, so is HEAD, and h sees HEAD requests as GET requests - the
+// http package drops the body. OPTIONS requests get an Allow header listing
+// the methods.
+func handle(router *handlers.Regexp, pattern *regexp.Regexp, methods []string, h http.Handler) {
+	allowed := make([]string, 0, len(methods)+2)
+	for _, method := range methods {
+		allowed = append(allowed, method)
+		if method == "GET" {
+			allowed = append(allowed, "HEAD")
+		}
+	}
+	allowed = append(allowed, "OPTIONS")
+	allow := strings.Join(allowed, ", ")
+	router.Handle(pattern, allowed, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "OPTIONS":
+			w.Header().Set("Allow", allow)
+			w.WriteHeader(http.StatusNoContent)
+		case "HEAD":
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.Method = "GET"
+			h.ServeHTTP(w, r2)
+		default:
+			h.ServeHTTP(w, r)
+		}
+	}))
+}
+
+var corsMethods = "GET, HEAD, POST"
+
+// withCORS adds CORS headers to responses to requests from the origins
+// allowed by c, and answers their preflight requests before they reach the
+// authenticator, since browsers don't send credentials with preflights.
+func withCORS(h http.Handler, c *config.CORS) http.Handler {
+	if c == nil {
+		return h
+	}
+	allowHeaders := strings.Join(c.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(c.MaxAge / time.Second))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if !c.AllowOrigin(origin) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", corsMethods)
+			if allowHeaders != "" {
+				w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+			}
+			if c.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
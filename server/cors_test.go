@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/handlers"
+	"github.com/saintpete/logrole/config"
+)
+
+func TestHandleOptionsAndHead(t *testing.T) {
+	t.Parallel()
+	r := new(handlers.Regexp)
+	handle(r, regexp.MustCompile(`^/calls$`), []string{"GET"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("expected handler to see a GET request, got %s", r.Method)
+		}
+		w.Write([]byte("hello"))
+	}))
+	req, _ := http.NewRequest("OPTIONS", "/calls", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != 204 {
+		t.Errorf("expected Code to be 204, got %d", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS" {
+		t.Errorf("expected Allow to be GET, HEAD, OPTIONS, got %q", allow)
+	}
+	req, _ = http.NewRequest("HEAD", "/calls", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Errorf("expected Code to be 200, got %d", w.Code)
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	t.Parallel()
+	c, err := config.NewCORS([]string{"https://tools.example.com"}, []string{"X-Requested-With"}, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unauthorized", 401)
+	}), c)
+	req, _ := http.NewRequest("OPTIONS", "/calls", nil)
+	req.Header.Set("Origin", "https://tools.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != 204 {
+		t.Errorf("expected preflight to be answered with 204, got %d", w.Code)
+	}
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "https://tools.example.com" {
+		t.Errorf("expected Allow-Origin header, got %q", origin)
+	}
+	if hdr := w.Header().Get("Access-Control-Allow-Headers"); hdr != "X-Requested-With" {
+		t.Errorf("expected Allow-Headers to be X-Requested-With, got %q", hdr)
+	}
+	if age := w.Header().Get("Access-Control-Max-Age"); age != "600" {
+		t.Errorf("expected Max-Age to be 600, got %q", age)
+	}
+
+	req, _ = http.NewRequest("OPTIONS", "/calls", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != 401 {
+		t.Errorf("expected request from other origin to reach the handler, got %d", w.Code)
+	}
+	if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("expected no Allow-Origin header, got %q", origin)
+	}
+}
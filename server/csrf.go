@@ -0,0 +1,147 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"strings"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"golang.org/x/net/context"
+)
+
+// The cookie that stores the browser's CSRF token. It has no expiry, so the
+// browser forgets the token when it closes.
+const csrfCookie = "csrf"
+
+// Forms send the token in this field; scripts can send it in csrfHeader
+// instead.
+const csrfField = "csrf_token"
+const csrfHeader = "X-CSRF-Token"
+
+// The largest form the CSRF check will read to find the token. Uploads are
+// read here first, so this can't be smaller than any upload limit.
+const csrfMaxFormBytes = 2 * maxPolicyImportBytes
+
+// csrfPlaceholder is rendered by the csrf_field template function, and
+// replaced with the request's token by a pageWriter.
+const csrfPlaceholder = "logrole-csrf-token-placeholder"
+
+var csrfKey ctxVar = 2
+
+// Requests with these methods change something, and need a valid token.
+var csrfMethods = map[string]bool{
+	"POST":   true,
+	"PUT":    true,
+	"PATCH":  true,
+	"DELETE": true,
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func validCSRFToken(token string) bool {
+	if len(token) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(token)
+	return err == nil
+}
+
+// csrfCookieHeader returns a Set-Cookie header for token. It's built by hand
+// because http.Cookie can't set SameSite on the Go versions we support.
+func csrfCookieHeader(token string, secure bool, sameSite string) string {
+	c := &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		Secure:   secure,
+		HttpOnly: true,
+	}
+	switch sameSite {
+	case config.SameSiteStrict:
+		return c.String() + "; SameSite=Strict"
+	case config.SameSiteNone:
+		return c.String() + "; SameSite=None"
+	default:
+		return c.String() + "; SameSite=Lax"
+	}
+}
+
+// withCSRF gives every browser a CSRF token in a cookie, and rejects requests
+// that change something unless they send the same token in the csrf_token
+// form field or the X-CSRF-Token header. Other sites can make a browser send
+// the cookie, but they can't read it, so they can't send the token.
+//
+// Pages read the token with the csrf_field template function.
+func withCSRF(h http.Handler, l log.Logger, allowUnencryptedTraffic bool, sameSite string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		if cookie, err := r.Cookie(csrfCookie); err == nil && validCSRFToken(cookie.Value) {
+			token = cookie.Value
+		} else {
+			var err error
+			token, err = newCSRFToken()
+			if err != nil {
+				rest.ServerError(w, r, err)
+				return
+			}
+			w.Header().Add("Set-Cookie", csrfCookieHeader(token, allowUnencryptedTraffic == false, sameSite))
+		}
+		r = r.WithContext(context.WithValue(r.Context(), csrfKey, token))
+		if csrfMethods[r.Method] {
+			sent := r.Header.Get(csrfHeader)
+			if sent == "" {
+				sent = csrfFormValue(w, r)
+			}
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				l.Warn("Missing or invalid CSRF token", "method", r.Method, "url", r.URL.String())
+				rest.Forbidden(w, r, &rest.Error{Title: "This form has expired. Go back, reload the page and try again."})
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// csrfFormValue parses the request body and returns the csrf_token field, or
+// the empty string if there isn't one. The parsed form stays on r for the
+// next handler.
+func csrfFormValue(w http.ResponseWriter, r *http.Request) string {
+	r.Body = http.MaxBytesReader(w, r.Body, csrfMaxFormBytes)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(csrfMaxFormBytes); err != nil {
+			return ""
+		}
+		if vals := r.MultipartForm.Value[csrfField]; len(vals) > 0 {
+			return vals[0]
+		}
+		return ""
+	}
+	if err := r.ParseForm(); err != nil {
+		return ""
+	}
+	return r.PostForm.Get(csrfField)
+}
+
+// getCSRFToken returns the request's CSRF token, or the empty string if it
+// didn't go through withCSRF.
+func getCSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey).(string)
+	return token
+}
+
+// csrfFormField renders a hidden field with the request's CSRF token, for
+// forms that POST to Logrole.
+func csrfFormField() template.HTML {
+	return template.HTML(`<input type="hidden" name="` + csrfField + `" value="` + csrfPlaceholder + `" />`)
+}
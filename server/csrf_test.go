@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/saintpete/logrole/config"
+)
+
+var csrfOK = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+})
+
+func TestCSRFIssuesToken(t *testing.T) {
+	t.Parallel()
+	h := withCSRF(csrfOK, NullLogger, false, config.SameSiteStrict)
+	req, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != 204 {
+		t.Fatalf("expected Code to be 204, got %d", w.Code)
+	}
+	cookie := w.Header().Get("Set-Cookie")
+	for _, want := range []string{"csrf=", "HttpOnly", "Secure", "SameSite=Strict"} {
+		if !strings.Contains(cookie, want) {
+			t.Errorf("expected cookie to contain %q, got %q", want, cookie)
+		}
+	}
+
+	token := strings.Repeat("0f", 32)
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if cookie := w.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("expected the existing token to be kept, got %q", cookie)
+	}
+}
+
+func csrfPost(h http.Handler, cookie string, form url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/labels", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: cookie})
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestCSRFChecksToken(t *testing.T) {
+	t.Parallel()
+	h := withCSRF(csrfOK, NullLogger, true, config.SameSiteLax)
+	token := strings.Repeat("0f", 32)
+	if w := csrfPost(h, token, url.Values{"label": {"a"}}); w.Code != 403 {
+		t.Errorf("expected a POST without a token to get a 403, got %d", w.Code)
+	}
+	if w := csrfPost(h, "", url.Values{csrfField: {token}}); w.Code != 403 {
+		t.Errorf("expected a POST without a cookie to get a 403, got %d", w.Code)
+	}
+	if w := csrfPost(h, token, url.Values{csrfField: {strings.Repeat("1e", 32)}}); w.Code != 403 {
+		t.Errorf("expected a POST with the wrong token to get a 403, got %d", w.Code)
+	}
+	if w := csrfPost(h, token, url.Values{csrfField: {token}}); w.Code != 204 {
+		t.Errorf("expected a POST with the token to succeed, got %d", w.Code)
+	}
+
+	req, _ := http.NewRequest("DELETE", "/labels", nil)
+	req.Header.Set(csrfHeader, token)
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != 204 {
+		t.Errorf("expected a DELETE with the token in a header to succeed, got %d", w.Code)
+	}
+}
+
+func TestCSRFMultipartForm(t *testing.T) {
+	t.Parallel()
+	var got string
+	h := withCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var buf bytes.Buffer
+		buf.ReadFrom(f)
+		got = buf.String()
+	}), NullLogger, true, config.SameSiteLax)
+	token := strings.Repeat("0f", 32)
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField(csrfField, token)
+	fw, _ := mw.CreateFormFile("file", "labels.csv")
+	fw.Write([]byte("+14105551234,sales\n"))
+	mw.Close()
+	req, _ := http.NewRequest("POST", "/labels/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	if got != "+14105551234,sales\n" {
+		t.Errorf("expected the upload to reach the handler, got %q", got)
+	}
+}
+
+func TestRenderCSRFField(t *testing.T) {
+	t.Parallel()
+	tpl := template.Must(template.New("base").Funcs(funcMap).Parse(`<form method="POST">{{ csrf_field }}</form>`))
+	token := strings.Repeat("0f", 32)
+	var rendered string
+	h := withCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := render(&buf, r, tpl, "base", &baseData{}); err != nil {
+			t.Fatal(err)
+		}
+		rendered = buf.String()
+	}), NullLogger, true, config.SameSiteLax)
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if want := `<input type="hidden" name="csrf_token" value="` + token + `" />`; !strings.Contains(rendered, want) {
+		t.Errorf("expected page to contain %q, got %s", want, rendered)
+	}
+}
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"net/http"
 	"time"
 
+	"github.com/saintpete/logrole/config"
 	"golang.org/x/net/context"
 )
 
@@ -22,3 +24,51 @@ func getContext(ctx context.Context, timeToLeave time.Duration) (context.Context
 	}
 	return context.WithTimeout(ctx, defaultTimeout)
 }
+
+type ctxVar int
+
+var brandingKey ctxVar = 0
+var archiveKey ctxVar = 1
+
+// withBranding sets the Branding in the context of every request, so it's
+// available when rendering the base template.
+func withBranding(h http.Handler, b *config.Branding) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), brandingKey, b))
+		h.ServeHTTP(w, r)
+	})
+}
+
+// getBranding returns the Branding for the request, or DefaultBranding if none
+// was set.
+func getBranding(r *http.Request) *config.Branding {
+	if b, ok := r.Context().Value(brandingKey).(*config.Branding); ok && b != nil {
+		return b
+	}
+	return config.DefaultBranding
+}
+
+// archive describes the archive resources are served from, instead of the
+// Twilio API.
+type archive struct {
+	// The account the archive was exported from. May be empty.
+	AccountSid string
+	// True if the resources are the demo fixtures, not a real archive.
+	Demo bool
+}
+
+// withArchive marks every request as being served from a, so the base
+// template can say the data is archived.
+func withArchive(h http.Handler, a *archive) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), archiveKey, a))
+		h.ServeHTTP(w, r)
+	})
+}
+
+// getArchive returns the archive the request is served from, or nil if it's
+// served from the Twilio API.
+func getArchive(r *http.Request) *archive {
+	a, _ := r.Context().Value(archiveKey).(*archive)
+	return a
+}
@@ -0,0 +1,376 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+// Counting every resource in a range means walking every page in the range,
+// so stop after this many pages and show the count as a lower bound.
+const maxDashboardPages = 20
+
+const dashboardPageSize = 1000
+
+// How long to reuse a comparison before counting everything again.
+const dashboardTimeout = 2 * time.Minute
+
+// Changes smaller than these are never flagged as abnormal. Small counts swing
+// wildly from one day to the next.
+const (
+	dashboardMinVolume     = 20
+	dashboardVolumeChange  = 0.5
+	dashboardErrorIncrease = 0.05
+)
+
+// The periods a user can compare. The previous range is always the same
+// range a week earlier, so "day" compares today with the same weekday last
+// week.
+var dashboardPeriods = map[string]time.Duration{
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+}
+
+const dashboardOffset = 7 * 24 * time.Hour
+
+// A dashboardCount is the number of resources created in a time range, and
+// how many of them failed.
+type dashboardCount struct {
+	Total  int
+	Failed int
+	// True if we stopped counting before reaching the end of the range.
+	Truncated bool
+}
+
+// Count returns the number of resources, with a "+" if the count is a lower
+// bound.
+func (c dashboardCount) Count() string {
+	s := strconv.Itoa(c.Total)
+	if c.Truncated {
+		s += "+"
+	}
+	return s
+}
+
+func (c dashboardCount) errorRate() float64 {
+	if c.Total == 0 {
+		return 0
+	}
+	return float64(c.Failed) / float64(c.Total)
+}
+
+// ErrorRate returns the percentage of resources that failed.
+func (c dashboardCount) ErrorRate() string {
+	if c.Total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*c.errorRate())
+}
+
+// A dashboardRow compares the volume of one type of resource across the two
+// ranges.
+type dashboardRow struct {
+	Name string
+	// What a failure is called for this resource, e.g. "Failed"
+	FailedName string
+	Current    dashboardCount
+	Previous   dashboardCount
+	Err        string
+}
+
+func (d *dashboardRow) change() float64 {
+	return float64(d.Current.Total-d.Previous.Total) / float64(d.Previous.Total)
+}
+
+// Change returns the percentage change in volume from the previous range to
+// the current one.
+func (d *dashboardRow) Change() string {
+	if d.Previous.Total == 0 {
+		if d.Current.Total == 0 {
+			return "0%"
+		}
+		return "New"
+	}
+	return fmt.Sprintf("%+.0f%%", 100*d.change())
+}
+
+// Abnormal returns true if the volume or the error rate changed enough to be
+// worth a closer look.
+func (d *dashboardRow) Abnormal() bool {
+	if d.Err != "" {
+		return false
+	}
+	if d.Current.Total < dashboardMinVolume && d.Previous.Total < dashboardMinVolume {
+		return false
+	}
+	if d.Previous.Total == 0 {
+		return true
+	}
+	if c := d.change(); c >= dashboardVolumeChange || c <= -dashboardVolumeChange {
+		return true
+	}
+	return d.Current.Total >= dashboardMinVolume &&
+		d.Current.errorRate()-d.Previous.errorRate() >= dashboardErrorIncrease
+}
+
+// dashboardStats are cached for each user and period.
+type dashboardStats struct {
+	Rows          []*dashboardRow
+	CurrentStart  time.Time
+	CurrentEnd    time.Time
+	PreviousStart time.Time
+	PreviousEnd   time.Time
+	ComputedAt    time.Time
+}
+
+// complete returns true if every count succeeded. Failed counts shouldn't be
+// cached.
+func (d *dashboardStats) complete() bool {
+	for _, row := range d.Rows {
+		if row.Err != "" {
+			return false
+		}
+	}
+	return true
+}
+
+type dashboardServer struct {
+	log.Logger
+	Client         views.Client
+	LocationFinder services.LocationFinder
+	MaxResourceAge time.Duration
+	cache          *cache.Cache
+	tpl            *template.Template
+}
+
+func newDashboardServer(l log.Logger, vc views.Client, lf services.LocationFinder, maxResourceAge time.Duration) (*dashboardServer, error) {
+	s := &dashboardServer{
+		Logger:         l,
+		Client:         vc,
+		LocationFinder: lf,
+		MaxResourceAge: maxResourceAge,
+		cache:          cache.NewCache(100, l),
+	}
+	tpl, err := newTpl(template.FuncMap{}, base+dashboardTpl)
+	if err != nil {
+		return nil, err
+	}
+	s.tpl = tpl
+	return s, nil
+}
+
+type dashboardData struct {
+	*dashboardStats
+	Period string
+	Loc    *time.Location
+	// True if part of the previous range is older than the user is allowed to
+	// view, so its counts are too low.
+	Partial bool
+	Err     string
+}
+
+func (d *dashboardData) Title() string {
+	return "Dashboard"
+}
+
+func (d *dashboardData) Path() string {
+	return "/dashboard"
+}
+
+func (s *dashboardServer) validParams() []string {
+	return []string{"period"}
+}
+
+func (s *dashboardServer) renderError(w http.ResponseWriter, r *http.Request, code int, query url.Values, err error) {
+	data := &baseData{
+		LF: s.LocationFinder,
+		Data: &dashboardData{
+			dashboardStats: new(dashboardStats),
+			Period:         "day",
+			Loc:            s.LocationFinder.GetLocationReq(r),
+			Err:            cleanError(err),
+		},
+	}
+	s.Warn("Error responding to request", "status", code, "url", r.URL.String(), "err", err)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", data); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (s *dashboardServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewMessages() && !u.CanViewCalls() && !u.CanViewAlerts() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	query := r.URL.Query()
+	if err := validateParams(s.validParams(), query); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, err)
+		return
+	}
+	period := query.Get("period")
+	if period == "" {
+		period = "day"
+	}
+	length, ok := dashboardPeriods[period]
+	if !ok {
+		s.renderError(w, r, http.StatusBadRequest, query, fmt.Errorf(`Unknown period "%s"`, period))
+		return
+	}
+	start := monotime.Now()
+	key := "dashboard:" + period + ":" + u.ID()
+	stats := new(dashboardStats)
+	cachedAt, err := s.cache.Get(key, stats)
+	if err != nil {
+		ctx, cancel := getContext(r.Context(), 25*time.Second)
+		defer cancel()
+		stats = s.compare(ctx, u, time.Now(), length)
+		if stats.complete() {
+			s.cache.Set(key, stats, dashboardTimeout)
+		}
+		cachedAt = 0
+	}
+	data := &baseData{
+		LF:       s.LocationFinder,
+		Duration: monotime.Since(start),
+		Data: &dashboardData{
+			dashboardStats: stats,
+			Period:         period,
+			Loc:            s.LocationFinder.GetLocationReq(r),
+			Partial:        !u.CanViewResource(stats.PreviousStart, s.MaxResourceAge),
+		},
+	}
+	if cachedAt > 0 {
+		data.CachedDuration = monotime.Since(cachedAt)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := render(w, r, s.tpl, "base", data); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// dashboardCounter counts the resources of one type created between start and
+// end.
+type dashboardCounter func(ctx context.Context, u *config.User, start, end time.Time) (dashboardCount, error)
+
+// compare counts each type of resource the user can view in the range of the
+// given length that ends at now, and in the same range a week earlier. All of
+// the counts run concurrently.
+func (s *dashboardServer) compare(ctx context.Context, u *config.User, now time.Time, length time.Duration) *dashboardStats {
+	stats := &dashboardStats{
+		CurrentStart:  now.Add(-length),
+		CurrentEnd:    now,
+		PreviousStart: now.Add(-length - dashboardOffset),
+		PreviousEnd:   now.Add(-dashboardOffset),
+		ComputedAt:    now,
+	}
+	counters := make([]dashboardCounter, 0, 3)
+	if u.CanViewMessages() {
+		stats.Rows = append(stats.Rows, &dashboardRow{Name: "Messages", FailedName: "Failed"})
+		counters = append(counters, s.countMessages)
+	}
+	if u.CanViewCalls() {
+		stats.Rows = append(stats.Rows, &dashboardRow{Name: "Calls", FailedName: "Failed"})
+		counters = append(counters, s.countCalls)
+	}
+	if u.CanViewAlerts() {
+		stats.Rows = append(stats.Rows, &dashboardRow{Name: "Alerts", FailedName: "Errors"})
+		counters = append(counters, s.countAlerts)
+	}
+	// Each goroutine writes to its own row field and error slot; nothing is
+	// read until g.Wait returns.
+	currentErrs := make([]error, len(stats.Rows))
+	previousErrs := make([]error, len(stats.Rows))
+	g, errctx := errgroup.WithContext(ctx)
+	for i := range stats.Rows {
+		i := i
+		row, count := stats.Rows[i], counters[i]
+		g.Go(func() error {
+			row.Current, currentErrs[i] = count(errctx, u, stats.CurrentStart, stats.CurrentEnd)
+			return nil
+		})
+		g.Go(func() error {
+			row.Previous, previousErrs[i] = count(errctx, u, stats.PreviousStart, stats.PreviousEnd)
+			return nil
+		})
+	}
+	g.Wait()
+	for i, row := range stats.Rows {
+		if currentErrs[i] != nil {
+			row.Err = currentErrs[i].Error()
+		} else if previousErrs[i] != nil {
+			row.Err = previousErrs[i].Error()
+		}
+	}
+	return stats
+}
+
+func dashboardFilters() url.Values {
+	data := url.Values{}
+	data.Set("PageSize", strconv.Itoa(dashboardPageSize))
+	return data
+}
+
+func (s *dashboardServer) countMessages(ctx context.Context, u *config.User, start, end time.Time) (dashboardCount, error) {
+	var count dashboardCount
+	iter := views.MessagesInRange(s.Client, u, start, end, dashboardFilters())
+	iter.MaxPages = maxDashboardPages
+	for iter.Next(ctx) {
+		count.Total++
+		status, err := iter.Message().Status()
+		if err == nil && (status == twilio.StatusFailed || status == twilio.StatusUndelivered) {
+			count.Failed++
+		}
+	}
+	count.Truncated = iter.Truncated()
+	return count, iter.Err()
+}
+
+func (s *dashboardServer) countCalls(ctx context.Context, u *config.User, start, end time.Time) (dashboardCount, error) {
+	var count dashboardCount
+	iter := views.CallsInRange(s.Client, u, start, end, dashboardFilters())
+	iter.MaxPages = maxDashboardPages
+	for iter.Next(ctx) {
+		count.Total++
+		if failed, err := iter.Call().Failed(); err == nil && failed {
+			count.Failed++
+		}
+	}
+	count.Truncated = iter.Truncated()
+	return count, iter.Err()
+}
+
+func (s *dashboardServer) countAlerts(ctx context.Context, u *config.User, start, end time.Time) (dashboardCount, error) {
+	var count dashboardCount
+	iter := views.AlertsInRange(s.Client, u, start, end, dashboardFilters())
+	iter.MaxPages = maxDashboardPages
+	for iter.Next(ctx) {
+		count.Total++
+		if level, err := iter.Alert().LogLevel(); err == nil && level == twilio.LogLevelError {
+			count.Failed++
+		}
+	}
+	count.Truncated = iter.Truncated()
+	return count, iter.Err()
+}
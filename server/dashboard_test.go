@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/test"
+	"github.com/saintpete/logrole/test/harness"
+	"golang.org/x/net/context"
+)
+
+var abnormalTests = []struct {
+	current  dashboardCount
+	previous dashboardCount
+	abnormal bool
+	change   string
+}{
+	{dashboardCount{Total: 100}, dashboardCount{Total: 100}, false, "+0%"},
+	{dashboardCount{Total: 200}, dashboardCount{Total: 100}, true, "+100%"},
+	{dashboardCount{Total: 40}, dashboardCount{Total: 100}, true, "-60%"},
+	{dashboardCount{Total: 100, Failed: 10}, dashboardCount{Total: 100, Failed: 1}, true, "+0%"},
+	{dashboardCount{Total: 5}, dashboardCount{Total: 1}, false, "+400%"},
+	{dashboardCount{Total: 50}, dashboardCount{}, true, "New"},
+	{dashboardCount{}, dashboardCount{}, false, "0%"},
+}
+
+func TestDashboardAbnormal(t *testing.T) {
+	t.Parallel()
+	for _, tt := range abnormalTests {
+		row := &dashboardRow{Current: tt.current, Previous: tt.previous}
+		if row.Abnormal() != tt.abnormal {
+			t.Errorf("Abnormal(%v, %v): got %t, want %t", tt.current, tt.previous, row.Abnormal(), tt.abnormal)
+		}
+		if c := row.Change(); c != tt.change {
+			t.Errorf("Change(%v, %v): got %q, want %q", tt.current, tt.previous, c, tt.change)
+		}
+	}
+}
+
+func TestDashboardCountsCalls(t *testing.T) {
+	t.Parallel()
+	server := newServerWithResponse(200, test.CallListBody)
+	defer server.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	s, err := newDashboardServer(dlog, vc, lf, config.DefaultMaxResourceAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2016, 10, 27, 0, 0, 0, 0, time.UTC)
+	count, err := s.countCalls(context.Background(), theUser, start, start.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.Total != 2 || count.Failed != 0 || count.Truncated {
+		t.Errorf("expected to count 2 calls, got %#v", count)
+	}
+}
+
+func TestDashboardForbidden(t *testing.T) {
+	t.Parallel()
+	vc := harness.ViewsClient(harness.ViewHarness{SecretKey: key})
+	s, err := newDashboardServer(dlog, vc, lf, config.DefaultMaxResourceAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/dashboard", nil)
+	req = config.SetUser(req, config.NewUser(&config.UserSettings{CanViewConferences: true}))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+	req, _ = http.NewRequest("GET", "/dashboard?period=year", nil)
+	req = config.SetUser(req, theUser)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Errorf("expected Code to be 400, got %d", w.Code)
+	}
+}
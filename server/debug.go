@@ -0,0 +1,211 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+)
+
+// We don't import net/http/pprof, since it adds unauthenticated handlers to
+// http.DefaultServeMux, which some people serve Logrole from.
+
+// The most seconds a CPU profile or trace can run for. This is less than the
+// server's write timeout.
+const maxProfileSeconds = 50
+
+// The time the process started, for the uptime on /debug/vars.
+var processStart = time.Now()
+
+// runtimeStats is the JSON served at /debug/vars.
+type runtimeStats struct {
+	GoVersion         string `json:"go_version"`
+	Uptime            string `json:"uptime"`
+	Goroutines        int    `json:"goroutines"`
+	GOMAXPROCS        int    `json:"gomaxprocs"`
+	ConfigFingerprint string `json:"config_fingerprint"`
+	Memory            struct {
+		HeapAlloc   uint64 `json:"heap_alloc_bytes"`
+		HeapSys     uint64 `json:"heap_sys_bytes"`
+		HeapObjects uint64 `json:"heap_objects"`
+		Sys         uint64 `json:"sys_bytes"`
+		NumGC       uint32 `json:"num_gc"`
+		PauseTotal  string `json:"gc_pause_total"`
+	} `json:"memory"`
+	// Omitted for archives, which don't cache API responses.
+	APICache *cacheStats `json:"api_cache,omitempty"`
+	// Omitted unless media_cache_dir is set.
+	MediaCacheBytes *int64 `json:"media_cache_bytes,omitempty"`
+	PrefetchQueue   int    `json:"prefetch_queue"`
+	// Omitted unless Twilio requests are limited. Keyed by "total" and by
+	// resource type.
+	TwilioConcurrency map[string]services.ConcurrencyStats `json:"twilio_concurrency,omitempty"`
+	// Omitted unless cache_replication_peers is set.
+	CacheReplication *replicationStats `json:"cache_replication,omitempty"`
+}
+
+type cacheStats struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// runtimeDebugServer serves Go's profiler and runtime stats to users with the
+// can_profile permission. It's only routed if enable_profiling is set.
+type runtimeDebugServer struct {
+	// The client that caches Twilio responses. May be nil.
+	Cache         views.CacheStatter
+	MediaCache    *cache.BlobStore
+	Prefetcher    *prefetcher
+	TwilioLimiter *services.ConcurrencyLimiter
+	Replicator    *cacheReplicator
+	Fingerprint   string
+}
+
+func (s *runtimeDebugServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanProfile() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to profile the server"})
+		return
+	}
+	switch name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/"); {
+	case r.URL.Path == "/debug/vars":
+		s.serveVars(w, r)
+	case name == "":
+		s.serveIndex(w, r)
+	case name == "profile" || name == "trace":
+		s.serveTimed(w, r, name)
+	case pprof.Lookup(name) != nil:
+		debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+		if debug > 0 {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+		}
+		pprof.Lookup(name).WriteTo(w, debug)
+	default:
+		rest.NotFound(w, r)
+	}
+}
+
+var profileIndexTpl = template.Must(template.New("index").Parse(`<!doctype html>
+<title>Profiles</title>
+<p>Use <code>go tool pprof https://this-host/debug/pprof/heap</code>, or add
+<code>?debug=1</code> to read a profile in the browser.</p>
+<ul>
+{{- range . }}
+<li><a href="/debug/pprof/{{ .Name }}?debug=1">{{ .Name }}</a> ({{ .Count }})</li>
+{{- end }}
+<li><a href="/debug/pprof/profile?seconds=30">profile</a> - 30 second CPU profile</li>
+<li><a href="/debug/pprof/trace?seconds=5">trace</a> - 5 second execution trace</li>
+</ul>
+`))
+
+// GET /debug/pprof/
+//
+// List the available profiles.
+func (s *runtimeDebugServer) serveIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := profileIndexTpl.Execute(w, pprof.Profiles()); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// GET /debug/pprof/profile?seconds=30
+// GET /debug/pprof/trace?seconds=5
+//
+// Record a CPU profile or an execution trace for the given number of seconds.
+// Only one of each can run at a time.
+func (s *runtimeDebugServer) serveTimed(w http.ResponseWriter, r *http.Request, name string) {
+	seconds, err := strconv.Atoi(r.URL.Query().Get("seconds"))
+	if err != nil || seconds <= 0 {
+		seconds = 30
+		if name == "trace" {
+			seconds = 1
+		}
+	}
+	if seconds > maxProfileSeconds {
+		rest.BadRequest(w, r, &rest.Error{Title: fmt.Sprintf("Profiles can run for at most %d seconds", maxProfileSeconds)})
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	if name == "trace" {
+		err = trace.Start(w)
+	} else {
+		err = pprof.StartCPUProfile(w)
+	}
+	if err != nil {
+		// Usually because another profile is running.
+		w.Header().Del("Content-Disposition")
+		rest.ServerError(w, r, err)
+		return
+	}
+	select {
+	case <-time.After(time.Duration(seconds) * time.Second):
+	case <-r.Context().Done():
+	}
+	if name == "trace" {
+		trace.Stop()
+	} else {
+		pprof.StopCPUProfile()
+	}
+}
+
+// GET /debug/vars
+//
+// Show goroutine and memory counts, cache sizes and the config fingerprint as
+// JSON.
+func (s *runtimeDebugServer) serveVars(w http.ResponseWriter, r *http.Request) {
+	stats := &runtimeStats{
+		GoVersion:         runtime.Version(),
+		Uptime:            time.Since(processStart).String(),
+		Goroutines:        runtime.NumGoroutine(),
+		GOMAXPROCS:        runtime.GOMAXPROCS(0),
+		ConfigFingerprint: s.Fingerprint,
+		PrefetchQueue:     s.Prefetcher.Report().QueueDepth,
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats.Memory.HeapAlloc = mem.HeapAlloc
+	stats.Memory.HeapSys = mem.HeapSys
+	stats.Memory.HeapObjects = mem.HeapObjects
+	stats.Memory.Sys = mem.Sys
+	stats.Memory.NumGC = mem.NumGC
+	stats.Memory.PauseTotal = time.Duration(mem.PauseTotalNs).String()
+	if s.Cache != nil {
+		entries, size := s.Cache.CacheStats()
+		stats.APICache = &cacheStats{Entries: entries, Bytes: size}
+	}
+	if s.MediaCache != nil {
+		size := s.MediaCache.Size()
+		stats.MediaCacheBytes = &size
+	}
+	if s.TwilioLimiter != nil {
+		stats.TwilioConcurrency = s.TwilioLimiter.Stats()
+	}
+	if s.Replicator != nil {
+		stats.CacheReplication = s.Replicator.Stats()
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(stats)
+}
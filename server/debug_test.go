@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saintpete/logrole/config"
+)
+
+func getRuntimeDebug(s http.Handler, u *config.User, path string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, config.SetUser(req, u))
+	return w
+}
+
+func TestRuntimeDebugRequiresPermission(t *testing.T) {
+	t.Parallel()
+	s := &runtimeDebugServer{}
+	us := config.AllUserSettings()
+	us.CanProfile = false
+	for _, path := range []string{"/debug/vars", "/debug/pprof/", "/debug/pprof/heap"} {
+		if w := getRuntimeDebug(s, config.NewUser(us), path); w.Code != 403 {
+			t.Errorf("%s: expected Code to be 403, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestRuntimeVars(t *testing.T) {
+	t.Parallel()
+	s := &runtimeDebugServer{Fingerprint: "abc123"}
+	w := getRuntimeDebug(s, config.NewUser(config.AllUserSettings()), "/debug/vars")
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	var stats map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats["config_fingerprint"] != "abc123" {
+		t.Errorf("expected the config fingerprint, got %v", stats["config_fingerprint"])
+	}
+	if n, _ := stats["goroutines"].(float64); n < 1 {
+		t.Errorf("expected at least one goroutine, got %v", stats["goroutines"])
+	}
+	if _, ok := stats["api_cache"]; ok {
+		t.Errorf("expected no api_cache without a cache, got %v", stats["api_cache"])
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	t.Parallel()
+	s := &runtimeDebugServer{}
+	u := config.NewUser(config.AllUserSettings())
+	w := getRuntimeDebug(s, u, "/debug/pprof/")
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "goroutine") {
+		t.Errorf("expected the index to list profiles, got %d %s", w.Code, w.Body.String())
+	}
+	w = getRuntimeDebug(s, u, "/debug/pprof/goroutine?debug=1")
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "goroutine profile") {
+		t.Errorf("expected a goroutine profile, got %d %s", w.Code, w.Body.String())
+	}
+	if w := getRuntimeDebug(s, u, "/debug/pprof/unknown"); w.Code != 404 {
+		t.Errorf("expected Code to be 404 for an unknown profile, got %d", w.Code)
+	}
+	if w := getRuntimeDebug(s, u, "/debug/pprof/profile?seconds=3600"); w.Code != 400 {
+		t.Errorf("expected Code to be 400 for a long profile, got %d", w.Code)
+	}
+}
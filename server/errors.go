@@ -5,6 +5,8 @@ import (
 	"html/template"
 	"net/http"
 	"net/mail"
+	"strconv"
+	"time"
 
 	"github.com/kevinburke/handlers"
 	"github.com/kevinburke/rest"
@@ -16,6 +18,8 @@ type errorData struct {
 	Title       string
 	Description string
 	Mailto      *mail.Address
+	// If nonzero, reload the page after this many seconds.
+	Refresh int
 }
 
 type errorServer struct {
@@ -53,9 +57,13 @@ func newErrorServer(mailto *mail.Address, reporter services.ErrorReporter) (*err
 }
 
 func (e *errorServer) Serve403(w http.ResponseWriter, r *http.Request) {
+	description := "You don't have permission to access this page. If you think something is broken, please report a problem."
+	if rerr, ok := rest.CtxErr(r).(*rest.Error); ok && rerr.ID == errReadOnly.ID {
+		description = "This site is in read-only mode, so nothing can be changed from here. Head back to the homepage to keep browsing."
+	}
 	data := &baseData{Data: &errorData{
 		Title:       "Forbidden",
-		Description: "You don't have permission to access this page. If you think something is broken, please report a problem.",
+		Description: description,
 		Mailto:      e.Mailto,
 	}}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -91,7 +99,30 @@ func (e *errorServer) Serve405(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveRateLimited tells the user that Twilio is rate limiting requests, and
+// reloads the page once the limit should have reset.
+func (e *errorServer) serveRateLimited(w http.ResponseWriter, r *http.Request, rerr *services.RateLimitError) {
+	secs := int((rerr.RetryAfter + time.Second - 1) / time.Second)
+	data := &baseData{Data: &errorData{
+		Title:       "Rate Limited by Twilio",
+		Description: fmt.Sprintf("Twilio is limiting how fast Logrole can make requests. This page will reload in %d seconds.", secs),
+		Mailto:      e.Mailto,
+		Refresh:     secs,
+	}}
+	handlers.Logger.Warn("Rate limited by Twilio", "method", r.Method, "path", r.URL.Path, "retry_after", rerr.RetryAfter)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if err := render(w, r, e.tpl, "base", data); err != nil {
+		handlers.Logger.Error("Error rendering error template", "err", err)
+	}
+}
+
 func (e *errorServer) Serve500(w http.ResponseWriter, r *http.Request) {
+	if rerr, ok := services.RateLimited(rest.CtxErr(r)); ok {
+		e.serveRateLimited(w, r, rerr)
+		return
+	}
 	data := &baseData{Data: &errorData{
 		Title:       "Server Error",
 		Description: "We got an unexpected error when serving your request. Please refresh the page and try again. If you think something is broken, report a problem.",
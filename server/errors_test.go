@@ -4,10 +4,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/mail"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/services"
 )
 
 func clearErrorHandlers() {
@@ -33,7 +36,7 @@ func TestErrorsRender(t *testing.T) {
 	if ctype := w.Header().Get("Content-Type"); ctype != "text/html; charset=utf-8" {
 		t.Errorf("expected Content-Type to be text/html, got %s", ctype)
 	}
-	if body := w.Body.String(); !strings.Contains(body, "<h2>Page Not Found</h2>") {
+	if body := w.Body.String(); !strings.Contains(body, "<h1 class=\"h2\">Page Not Found</h1>") {
 		t.Errorf("expected body to contain Not Found, got %s", body)
 	}
 }
@@ -64,3 +67,23 @@ func TestErrorShowsEmail(t *testing.T) {
 		t.Errorf("expected body to contain test@example.com, got %s", body)
 	}
 }
+
+func TestRateLimitedRendersRetryPage(t *testing.T) {
+	t.Parallel()
+	defer clearErrorHandlers()
+	es, _ := newErrorServer(nil, nil)
+	registerErrorHandlers(es)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/messages/SM123", nil)
+	err := &url.Error{Op: "Get", URL: "https://api.twilio.com", Err: &services.RateLimitError{RetryAfter: 1500 * time.Millisecond}}
+	rest.ServerError(w, req, err)
+	if w.Code != 503 {
+		t.Errorf("expected Code to be 503, got %d", w.Code)
+	}
+	if h := w.Header().Get("Retry-After"); h != "2" {
+		t.Errorf("expected Retry-After to be 2, got %q", h)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "Rate Limited by Twilio") || !strings.Contains(body, "reload in 2 seconds") {
+		t.Errorf("expected body to explain the rate limit, got %s", body)
+	}
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+// Twilio error codes are five digits.
+var errorCodeQuery = regexp.MustCompile(`^[1-9][0-9]{4}$`)
+
+// Stop looking for a resource after this many matches, or this many pages of
+// dashboardPageSize, whichever comes first.
+const maxErrorSearchResults = 50
+const maxErrorSearchPages = 10
+
+// Search a day at a time, newest first, so the same search returns the same
+// results even while new resources arrive. Anything older than
+// maxErrorSearchWindows days is searched as one list.
+const errorSearchWindow = 24 * time.Hour
+const maxErrorSearchWindows = 8
+
+// Calls don't have error codes, so they're found through their alerts, and
+// fetched one at a time.
+const maxErrorSearchCalls = 20
+const errorSearchCallConcurrency = 5
+
+// errorSearchSection describes the search of one type of resource.
+type errorSearchSection struct {
+	// The user can't view this type of resource.
+	Hidden bool
+	// Stopped after Limit matches.
+	Full  bool
+	Limit int
+	// Stopped before looking through the whole period.
+	Truncated bool
+	Err       string
+}
+
+func (e *errorSearchSection) setErr(err error) {
+	if err != nil {
+		e.Err = err.Error()
+	}
+}
+
+type errorSearchData struct {
+	Code     twilio.Code
+	Period   string
+	Loc      *time.Location
+	Messages []*views.Message
+	Calls    []*views.Call
+	Alerts   []*views.Alert
+
+	MessageSearch errorSearchSection
+	CallSearch    errorSearchSection
+	AlertSearch   errorSearchSection
+	Err           string
+	// The runbook for Code, if there is one.
+	Runbook *config.Runbook
+}
+
+func (d *errorSearchData) Title() string {
+	if d.Code > 0 {
+		return fmt.Sprintf("Error %d", d.Code)
+	}
+	return "Search by Error Code"
+}
+
+func (d *errorSearchData) Path() string {
+	return "/search/errors"
+}
+
+// errorSearchServer finds the messages, calls and alerts that failed with one
+// Twilio error code. The API can't filter by error code, so it reads through
+// every resource in the period.
+type errorSearchServer struct {
+	log.Logger
+	Client         views.Client
+	LocationFinder services.LocationFinder
+	// Runbook links and notes for error codes. May be nil.
+	Runbooks *config.Runbooks
+	tpl      *template.Template
+}
+
+func newErrorSearchServer(l log.Logger, vc views.Client, lf services.LocationFinder, labels *services.LabelStore, owners *services.OwnerStore) (*errorSearchServer, error) {
+	tpl, err := newTpl(template.FuncMap{
+		"is_our_pn": vc.IsTwilioNumber,
+		"pn_label":  labels.Get,
+		"pn_owner":  owners.Get,
+	}, base+errorSearchTpl+runbookTpl+phoneTpl+copyScript)
+	if err != nil {
+		return nil, err
+	}
+	return &errorSearchServer{
+		Logger:         l,
+		Client:         vc,
+		LocationFinder: lf,
+		tpl:            tpl,
+	}, nil
+}
+
+func (s *errorSearchServer) validParams() []string {
+	return []string{"code", "period"}
+}
+
+func (s *errorSearchServer) render(w http.ResponseWriter, r *http.Request, code int, bd *baseData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", bd); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// GET /search/errors?code=30006&period=week
+func (s *errorSearchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewMessages() && !u.CanViewCalls() && !u.CanViewAlerts() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	start := monotime.Now()
+	query := r.URL.Query()
+	data := &errorSearchData{
+		Period: query.Get("period"),
+		Loc:    s.LocationFinder.GetLocationReq(r),
+	}
+	bd := &baseData{LF: s.LocationFinder, Data: data}
+	if data.Period == "" {
+		data.Period = "day"
+	}
+	err := validateParams(s.validParams(), query)
+	length, ok := dashboardPeriods[data.Period]
+	if err == nil && !ok {
+		err = fmt.Errorf(`Unknown period "%s"`, data.Period)
+	}
+	if err == nil {
+		if code := strings.TrimSpace(query.Get("code")); code != "" {
+			if !errorCodeQuery.MatchString(code) {
+				err = fmt.Errorf(`"%s" isn't a Twilio error code`, code)
+			} else {
+				n, _ := strconv.Atoi(code)
+				data.Code = twilio.Code(n)
+			}
+		}
+	}
+	if err != nil {
+		data.Err = cleanError(err)
+		s.render(w, r, http.StatusBadRequest, bd)
+		return
+	}
+	if data.Code > 0 {
+		data.Runbook = s.Runbooks.Get(data.Code)
+		ctx, cancel := getContext(r.Context(), 3*time.Second)
+		defer cancel()
+		now := time.Now()
+		s.search(ctx, u, data, now.Add(-length), now)
+	}
+	bd.Duration = monotime.Since(start)
+	s.render(w, r, http.StatusOK, bd)
+}
+
+// search looks for data.Code in each type of resource the user can view,
+// concurrently. Errors are recorded on each section, so one failed search
+// doesn't hide the results of the others.
+func (s *errorSearchServer) search(ctx context.Context, u *config.User, data *errorSearchData, start, end time.Time) {
+	data.MessageSearch.Limit = maxErrorSearchResults
+	data.AlertSearch.Limit = maxErrorSearchResults
+	data.CallSearch.Limit = maxErrorSearchCalls
+	var g errgroup.Group
+	if u.CanViewMessages() {
+		g.Go(func() error {
+			var err error
+			data.Messages, err = s.searchMessages(ctx, u, data.Code, start, end, &data.MessageSearch)
+			data.MessageSearch.setErr(err)
+			return nil
+		})
+	} else {
+		data.MessageSearch.Hidden = true
+	}
+	data.CallSearch.Hidden = !u.CanViewCalls()
+	if u.CanViewAlerts() {
+		g.Go(func() error {
+			var err error
+			data.Alerts, err = s.searchAlerts(ctx, u, data.Code, start, end, &data.AlertSearch)
+			data.AlertSearch.setErr(err)
+			if err != nil || data.CallSearch.Hidden {
+				return nil
+			}
+			data.Calls, err = s.alertCalls(ctx, u, data.Alerts, &data.CallSearch)
+			data.CallSearch.setErr(err)
+			return nil
+		})
+	} else {
+		data.AlertSearch.Hidden = true
+		// Without alerts there's no way to find calls.
+		data.CallSearch.Hidden = true
+	}
+	g.Wait()
+}
+
+func (s *errorSearchServer) searchMessages(ctx context.Context, u *config.User, code twilio.Code, start, end time.Time, section *errorSearchSection) ([]*views.Message, error) {
+	var messages []*views.Message
+	iter := views.MessagesInWindows(s.Client, u, start, end, errorSearchWindow, maxErrorSearchWindows, dashboardFilters())
+	iter.MaxPages = maxErrorSearchPages
+	for iter.Next(ctx) {
+		message := iter.Message()
+		if c, err := message.ErrorCode(); err != nil || c != code {
+			continue
+		}
+		messages = append(messages, message)
+		if len(messages) >= maxErrorSearchResults {
+			section.Full = true
+			return messages, nil
+		}
+	}
+	section.Truncated = iter.Truncated()
+	return messages, iter.Err()
+}
+
+func (s *errorSearchServer) searchAlerts(ctx context.Context, u *config.User, code twilio.Code, start, end time.Time, section *errorSearchSection) ([]*views.Alert, error) {
+	var alerts []*views.Alert
+	iter := views.AlertsInWindows(s.Client, u, start, end, errorSearchWindow, maxErrorSearchWindows, dashboardFilters())
+	iter.MaxPages = maxErrorSearchPages
+	for iter.Next(ctx) {
+		alert := iter.Alert()
+		if c, err := alert.ErrorCode(); err != nil || c != code {
+			continue
+		}
+		alerts = append(alerts, alert)
+		if len(alerts) >= maxErrorSearchResults {
+			section.Full = true
+			return alerts, nil
+		}
+	}
+	section.Truncated = iter.Truncated()
+	return alerts, iter.Err()
+}
+
+// alertCalls fetches the calls the alerts are about, in the order of the
+// alerts. Calls the user can't see are skipped.
+func (s *errorSearchServer) alertCalls(ctx context.Context, u *config.User, alerts []*views.Alert, section *errorSearchSection) ([]*views.Call, error) {
+	var sids []string
+	seen := make(map[string]bool)
+	for _, alert := range alerts {
+		sid, err := alert.ResourceSid()
+		if err != nil || !strings.HasPrefix(sid, "CA") || seen[sid] {
+			continue
+		}
+		if len(sids) >= maxErrorSearchCalls {
+			section.Full = true
+			break
+		}
+		seen[sid] = true
+		sids = append(sids, sid)
+	}
+	calls := make([]*views.Call, len(sids))
+	var mu sync.Mutex
+	var firstErr error
+	var g errgroup.Group
+	sem := make(chan struct{}, errorSearchCallConcurrency)
+	for i, sid := range sids {
+		i, sid := i, sid
+		g.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			call, err := s.Client.GetCall(ctx, u, sid)
+			switch {
+			case err == config.PermissionDenied || err == config.ErrTooOld:
+			case err != nil:
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			default:
+				calls[i] = call
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	found := calls[:0]
+	for _, call := range calls {
+		if call != nil {
+			found = append(found, call)
+		}
+	}
+	return found, firstErr
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+// errorSearchFixtures returns two messages, one with error 30006, and two
+// alerts with error 13224 for the same call.
+func errorSearchFixtures() *views.Fixtures {
+	date := func(min int) twilio.TwilioTime {
+		return twilio.TwilioTime{Valid: true, Time: time.Date(2016, 10, 18, 17, min, 0, 0, time.UTC)}
+	}
+	message := func(sid string, code twilio.Code) *twilio.Message {
+		return &twilio.Message{
+			Sid:         sid,
+			From:        "+19253920364",
+			To:          "+14105551234",
+			Status:      twilio.StatusUndelivered,
+			Direction:   twilio.DirectionOutboundAPI,
+			NumSegments: 1,
+			ErrorCode:   code,
+			DateCreated: date(0),
+		}
+	}
+	alert := func(sid string, code twilio.Code, resourceSid string, created twilio.TwilioTime) *twilio.Alert {
+		return &twilio.Alert{
+			Sid:         sid,
+			ErrorCode:   code,
+			LogLevel:    twilio.LogLevelError,
+			ResourceSid: resourceSid,
+			DateCreated: created,
+		}
+	}
+	return &views.Fixtures{
+		Messages: []*twilio.Message{message("SM30006", 30006), message("SM30003", 30003)},
+		Alerts: []*twilio.Alert{
+			alert("NO1", 13224, "CA123", date(0)),
+			alert("NO2", 13224, "CA123", date(1)),
+			alert("NO3", 11200, "CA456", date(2)),
+		},
+		Calls: []*twilio.Call{{
+			Sid:         "CA123",
+			From:        "+19253920364",
+			To:          "+14105551234",
+			Status:      twilio.StatusFailed,
+			Direction:   twilio.DirectionOutboundAPI,
+			DateCreated: date(0),
+		}},
+	}
+}
+
+func newTestErrorSearchServer(t *testing.T) *errorSearchServer {
+	vc := harness.FixtureClient(harness.ViewHarness{MaxResourceAge: 1000 * 1000 * time.Hour}, errorSearchFixtures())
+	s, err := newErrorSearchServer(dlog, vc, lf, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestErrorSearch(t *testing.T) {
+	t.Parallel()
+	s := newTestErrorSearchServer(t)
+	admin := config.NewUser(config.AllUserSettings())
+	start := time.Date(2016, 10, 18, 0, 0, 0, 0, time.UTC)
+
+	data := &errorSearchData{Code: 30006}
+	s.search(context.Background(), admin, data, start, start.Add(24*time.Hour))
+	if len(data.Messages) != 1 || len(data.Alerts) != 0 || len(data.Calls) != 0 {
+		t.Fatalf("expected one message, got %d messages, %d alerts and %d calls", len(data.Messages), len(data.Alerts), len(data.Calls))
+	}
+	if sid, _ := data.Messages[0].Sid(); sid != "SM30006" {
+		t.Errorf("expected SM30006, got %s", sid)
+	}
+
+	data = &errorSearchData{Code: 13224}
+	s.search(context.Background(), admin, data, start, start.Add(24*time.Hour))
+	if len(data.Alerts) != 2 {
+		t.Errorf("expected 2 alerts, got %d", len(data.Alerts))
+	}
+	if len(data.Calls) != 1 || data.CallSearch.Err != "" {
+		t.Fatalf("expected the alerts' call once, got %d calls: %s", len(data.Calls), data.CallSearch.Err)
+	}
+
+	us := config.AllUserSettings()
+	us.CanViewAlerts = false
+	data = &errorSearchData{Code: 13224}
+	s.search(context.Background(), config.NewUser(us), data, start, start.Add(24*time.Hour))
+	if !data.AlertSearch.Hidden || !data.CallSearch.Hidden || len(data.Alerts) != 0 || len(data.Calls) != 0 {
+		t.Errorf("expected alerts and calls to be hidden without can_view_alerts, got %+v", data)
+	}
+}
+
+func TestErrorSearchBadCode(t *testing.T) {
+	t.Parallel()
+	s := newTestErrorSearchServer(t)
+	req, _ := http.NewRequest("GET", "/search/errors?code=abc", nil)
+	req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Errorf("expected Code to be 400, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "isn&#39;t a Twilio error code") {
+		t.Errorf("expected an error message, got %s", w.Body.String())
+	}
+}
+
+func TestErrorSearchRunbook(t *testing.T) {
+	t.Parallel()
+	s := newTestErrorSearchServer(t)
+	runbooks, err := config.NewRunbooks(map[twilio.Code]config.Runbook{
+		30006: {URL: "https://wiki.example.com/runbooks/landline", Note: "The number is a landline"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Runbooks = runbooks
+	req, _ := http.NewRequest("GET", "/search/errors?code=30006", nil)
+	req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `href="https://wiki.example.com/runbooks/landline"`) || !strings.Contains(body, "The number is a landline") {
+		t.Errorf("expected the runbook for 30006, got %s", body)
+	}
+}
@@ -0,0 +1,609 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	types "github.com/kevinburke/go-types"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/jobs"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+// Twilio's maximum page size; fewer pages means fewer API requests.
+const exportPageSize = 1000
+
+// Stop walking pages after this many, so a single export can't hold an
+// unbounded amount of memory.
+const maxExportPages = 250
+
+// Exports walk their range a day at a time, newest first, so resources that
+// arrive or are backfilled while an export runs can't shift the pages it's
+// walking. Anything older than maxExportWindows days is walked as one list.
+const exportWindow = 24 * time.Hour
+const maxExportWindows = 31
+
+// exportPage is one page of resources that can be written to a CSV file.
+type exportPage interface {
+	ShowHeader(string) bool
+	NextPageURI() types.NullString
+	// Rows returns a row for every resource on the page that keep returns
+	// true for, with one value for each of the given columns. keep is passed
+	// the resource's sid and the time the list filters use for it.
+	Rows(columns []string, keep func(sid string, t twilio.TwilioTime) bool) [][]string
+}
+
+// exportFetcher retrieves a page of the resources in w. If next is empty, the
+// first page is retrieved.
+type exportFetcher func(ctx context.Context, w views.Window, next string) (exportPage, error)
+
+// Formats an export can be written in.
+const (
+	exportCSV = "csv"
+	// One JSON object per line, keyed by column name.
+	exportNDJSON = "ndjson"
+)
+
+// exportSpec is what an export was asked for, which is all that's needed to
+// start it again after a restart.
+type exportSpec struct {
+	Resource      string
+	Start         time.Time
+	End           time.Time
+	Filters       url.Values
+	IncludeBodies bool `json:",omitempty"`
+	// How the range is split into windows. Exports started before exports
+	// were windowed have a zero Window, and walk the range as one list.
+	Window     time.Duration `json:",omitempty"`
+	MaxWindows int           `json:",omitempty"`
+}
+
+// exportCheckpoint is the progress of an export after its last page. The
+// sids already written from the current window aren't saved, so if a
+// window's pages shift while the export is stopped, the resumed export can
+// repeat a resource from that window.
+type exportCheckpoint struct {
+	Spec    exportSpec
+	Format  string
+	Created time.Time
+	Window  int `json:",omitempty"`
+	Next    string
+	Pages   int
+	Columns []string
+}
+
+// exportTask walks every page of a list of resources and writes the resources
+// to a CSV or NDJSON file. Only columns the user has permission to view are
+// written. exportTask implements jobs.Resumable; if the queue has a
+// directory, the file is written there and the export picks up at the last
+// page it finished after a restart. Otherwise it's held in memory.
+type exportTask struct {
+	Name    string
+	Columns []string
+	Fetch   exportFetcher
+	// exportCSV or exportNDJSON. Defaults to exportCSV.
+	Format string
+	Spec   exportSpec
+	// When the export was asked for. The file is named after it, so an
+	// export that's resumed gets the same name.
+	Created time.Time
+
+	windows []views.Window
+	window  int
+	// The sids written from the current window.
+	seen    map[string]bool
+	next    string
+	pages   int
+	columns []string
+	buf     bytes.Buffer
+	f       *os.File
+	w       *csv.Writer
+}
+
+// output returns where the export is written.
+func (e *exportTask) output() io.Writer {
+	if e.f != nil {
+		return e.f
+	}
+	return &e.buf
+}
+
+func (e *exportTask) Open(f *os.File) error {
+	e.f = f
+	if e.columns != nil {
+		// Resumed after the header was written.
+		e.w = csv.NewWriter(f)
+	}
+	return nil
+}
+
+func (e *exportTask) Checkpoint() ([]byte, error) {
+	return json.Marshal(&exportCheckpoint{
+		Spec:    e.Spec,
+		Format:  e.Format,
+		Created: e.Created,
+		Window:  e.window,
+		Next:    e.next,
+		Pages:   e.pages,
+		Columns: e.columns,
+	})
+}
+
+// resumeExport recreates the export that saved state as its checkpoint, for
+// u.
+func resumeExport(vc views.Client, u *config.User, state []byte) (*exportTask, error) {
+	cp := new(exportCheckpoint)
+	if err := json.Unmarshal(state, cp); err != nil {
+		return nil, err
+	}
+	spec := cp.Spec
+	var e *exportTask
+	switch spec.Resource {
+	case "messages":
+		e = newMessageExport(vc, u, spec.Start, spec.End, spec.Filters)
+	case "calls":
+		e = newCallExport(vc, u, spec.Start, spec.End, spec.Filters)
+	case "alerts":
+		e = newAlertExport(vc, u, spec.Start, spec.End, spec.Filters, spec.IncludeBodies)
+	default:
+		return nil, fmt.Errorf("Unknown resource to export: %s", spec.Resource)
+	}
+	e.Format = cp.Format
+	e.Created = cp.Created
+	e.Spec.Window = spec.Window
+	e.Spec.MaxWindows = spec.MaxWindows
+	e.window = cp.Window
+	e.next = cp.Next
+	e.pages = cp.Pages
+	e.columns = cp.Columns
+	return e, nil
+}
+
+func exportError(err error) error {
+	switch err {
+	case config.PermissionDenied, config.ErrTooOld:
+		return jobs.Permanent(err)
+	}
+	if rerr, ok := err.(*rest.Error); ok {
+		// 429 Too Many Requests is worth retrying, other client errors aren't
+		if rerr.StatusCode >= 400 && rerr.StatusCode < 500 && rerr.StatusCode != 429 {
+			return jobs.Permanent(err)
+		}
+	}
+	return err
+}
+
+// exportWindows splits the range the export was asked for into the windows
+// it walks. Windowed exports end when they were created, so resources that
+// arrive while they run, or after they're resumed, aren't included.
+func exportWindows(spec exportSpec, created time.Time) []views.Window {
+	if spec.Window <= 0 {
+		return views.Windows(spec.Start, spec.End, 0, 0)
+	}
+	end := spec.End
+	if end.After(created) {
+		end = created
+	}
+	return views.Windows(spec.Start, end, spec.Window, spec.MaxWindows)
+}
+
+func (e *exportTask) Step(ctx context.Context) (int, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if e.windows == nil {
+		e.windows = exportWindows(e.Spec, e.Created)
+	}
+	if e.window >= len(e.windows) {
+		if e.columns == nil {
+			e.writeHeader(nil)
+		}
+		return 0, true, nil
+	}
+	page, err := e.Fetch(ctx, e.windows[e.window], e.next)
+	if err == twilio.NoMoreResults {
+		done := e.endWindow()
+		if done && e.columns == nil {
+			e.writeHeader(nil)
+		}
+		return 0, done, nil
+	}
+	if err != nil {
+		return 0, false, exportError(err)
+	}
+	if e.columns == nil {
+		e.writeHeader(page)
+	}
+	rows := page.Rows(e.columns, e.keep)
+	if err := e.writeRows(rows); err != nil {
+		return 0, false, jobs.Permanent(err)
+	}
+	e.pages++
+	if e.pages >= maxExportPages {
+		return len(rows), true, nil
+	}
+	npuri := page.NextPageURI()
+	if !npuri.Valid {
+		return len(rows), e.endWindow(), nil
+	}
+	e.next = npuri.String
+	return len(rows), false, nil
+}
+
+// endWindow moves on to the next window, and reports whether the export is
+// finished.
+func (e *exportTask) endWindow() bool {
+	e.window++
+	e.next = ""
+	e.seen = nil
+	return e.window >= len(e.windows)
+}
+
+// keep reports whether the resource with the given sid, listed at t, belongs
+// in the export. Twilio can return resources from outside the window it was
+// asked for, or return one twice if its pages shift; each resource is only
+// written from the window it falls in, once.
+func (e *exportTask) keep(sid string, t twilio.TwilioTime) bool {
+	if !e.windows[e.window].Contains(t) {
+		return false
+	}
+	if sid == "" {
+		return true
+	}
+	if e.seen[sid] {
+		return false
+	}
+	if e.seen == nil {
+		e.seen = make(map[string]bool)
+	}
+	e.seen[sid] = true
+	return true
+}
+
+// writeHeader picks the columns the user can view on page and writes them as
+// the first row of the CSV file. NDJSON files don't have a header row.
+func (e *exportTask) writeHeader(page exportPage) {
+	e.w = csv.NewWriter(e.output())
+	e.columns = make([]string, 0, len(e.Columns))
+	for _, col := range e.Columns {
+		if page == nil || page.ShowHeader(col) {
+			e.columns = append(e.columns, col)
+		}
+	}
+	if e.Format == exportNDJSON {
+		return
+	}
+	e.w.Write(e.columns)
+	e.w.Flush()
+}
+
+func (e *exportTask) writeRows(rows [][]string) error {
+	if e.Format != exportNDJSON {
+		return e.w.WriteAll(rows)
+	}
+	enc := json.NewEncoder(e.output())
+	for _, row := range rows {
+		obj := make(map[string]string, len(e.columns))
+		for i, col := range e.columns {
+			obj[col] = row[i]
+		}
+		if err := enc.Encode(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *exportTask) Artifact() (*jobs.Artifact, error) {
+	if e.w == nil {
+		return nil, fmt.Errorf("export %s has no data", e.Name)
+	}
+	e.w.Flush()
+	if err := e.w.Error(); err != nil {
+		return nil, err
+	}
+	data := e.buf.Bytes()
+	if e.f != nil {
+		if _, err := e.f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		var err error
+		data, err = ioutil.ReadAll(e.f)
+		if err != nil {
+			return nil, err
+		}
+	}
+	created := e.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+	filename := e.Name + "-" + created.UTC().Format("20060102-150405")
+	if e.Format == exportNDJSON {
+		return &jobs.Artifact{
+			Filename:    filename + ".ndjson",
+			ContentType: "application/x-ndjson",
+			Data:        data,
+		}, nil
+	}
+	return &jobs.Artifact{
+		Filename:    filename + ".csv",
+		ContentType: "text/csv; charset=utf-8",
+		Data:        data,
+	}, nil
+}
+
+func formatExportTime(t twilio.TwilioTime, err error) string {
+	if err != nil || !t.Valid {
+		return ""
+	}
+	return t.Time.UTC().Format(time.RFC3339)
+}
+
+func formatExportString(s string, err error) string {
+	if err != nil {
+		return ""
+	}
+	return s
+}
+
+var messageExportColumns = []string{"Sid", "DateCreated", "Direction",
+	"Status", "From", "To", "Body", "NumSegments", "NumMedia", "Price",
+	"ErrorCode", "ErrorMessage"}
+
+type messageExportPage struct {
+	*views.MessagePage
+}
+
+func (m *messageExportPage) Rows(columns []string, keep func(string, twilio.TwilioTime) bool) [][]string {
+	rows := make([][]string, 0, len(m.Messages()))
+	for _, msg := range m.Messages() {
+		sid, _ := msg.Sid()
+		created, _ := msg.DateCreated()
+		if !keep(sid, created) {
+			continue
+		}
+		row := make([]string, len(columns))
+		for i, col := range columns {
+			if !msg.CanViewProperty(col) {
+				continue
+			}
+			switch col {
+			case "Sid":
+				row[i] = formatExportString(msg.Sid())
+			case "DateCreated":
+				row[i] = formatExportTime(msg.DateCreated())
+			case "Direction":
+				d, _ := msg.Direction()
+				row[i] = string(d)
+			case "Status":
+				s, _ := msg.Status()
+				row[i] = string(s)
+			case "From":
+				pn, _ := msg.From()
+				row[i] = string(pn)
+			case "To":
+				pn, _ := msg.To()
+				row[i] = string(pn)
+			case "Body":
+				row[i] = formatExportString(msg.Body())
+			case "NumSegments":
+				n, _ := msg.NumSegments()
+				row[i] = strconv.FormatUint(uint64(n), 10)
+			case "NumMedia":
+				n, _ := msg.NumMedia()
+				row[i] = strconv.FormatUint(uint64(n), 10)
+			case "Price":
+				row[i] = formatExportString(msg.FriendlyPrice())
+			case "ErrorCode":
+				if code, _ := msg.ErrorCode(); code > 0 {
+					row[i] = strconv.Itoa(int(code))
+				}
+			case "ErrorMessage":
+				row[i] = formatExportString(msg.ErrorMessage())
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func newMessageExport(vc views.Client, u *config.User, start, end time.Time, data url.Values) *exportTask {
+	return &exportTask{
+		Name:    "messages",
+		Columns: messageExportColumns,
+		Spec: exportSpec{Resource: "messages", Start: start, End: end, Filters: data,
+			Window: exportWindow, MaxWindows: maxExportWindows},
+		Created: time.Now().UTC(),
+		Fetch: func(ctx context.Context, w views.Window, next string) (exportPage, error) {
+			var page *views.MessagePage
+			var err error
+			if next == "" {
+				page, _, err = vc.GetMessagePageInRange(ctx, u, w.Start, w.End, data)
+			} else {
+				page, _, err = vc.GetNextMessagePageInRange(ctx, u, w.Start, w.End, next)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return &messageExportPage{page}, nil
+		},
+	}
+}
+
+var callExportColumns = []string{"Sid", "DateCreated", "StartTime",
+	"Direction", "Status", "From", "To", "Duration", "Price"}
+
+type callExportPage struct {
+	*views.CallPage
+}
+
+func (c *callExportPage) Rows(columns []string, keep func(string, twilio.TwilioTime) bool) [][]string {
+	rows := make([][]string, 0, len(c.Calls()))
+	for _, call := range c.Calls() {
+		sid, _ := call.Sid()
+		listed, _ := call.ListTime()
+		if !keep(sid, listed) {
+			continue
+		}
+		row := make([]string, len(columns))
+		for i, col := range columns {
+			if !call.CanViewProperty(col) {
+				continue
+			}
+			switch col {
+			case "Sid":
+				row[i] = formatExportString(call.Sid())
+			case "DateCreated":
+				row[i] = formatExportTime(call.DateCreated())
+			case "StartTime":
+				row[i] = formatExportTime(call.StartTime())
+			case "Direction":
+				d, _ := call.Direction()
+				row[i] = string(d)
+			case "Status":
+				s, _ := call.Status()
+				row[i] = string(s)
+			case "From":
+				pn, _ := call.From()
+				row[i] = string(pn)
+			case "To":
+				pn, _ := call.To()
+				row[i] = string(pn)
+			case "Duration":
+				d, _ := call.Duration()
+				row[i] = strconv.FormatInt(int64(time.Duration(d)/time.Second), 10)
+			case "Price":
+				row[i] = formatExportString(call.FriendlyPrice())
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func newCallExport(vc views.Client, u *config.User, start, end time.Time, data url.Values) *exportTask {
+	return &exportTask{
+		Name:    "calls",
+		Columns: callExportColumns,
+		Spec: exportSpec{Resource: "calls", Start: start, End: end, Filters: data,
+			Window: exportWindow, MaxWindows: maxExportWindows},
+		Created: time.Now().UTC(),
+		Fetch: func(ctx context.Context, w views.Window, next string) (exportPage, error) {
+			var page *views.CallPage
+			var err error
+			if next == "" {
+				page, _, err = vc.GetCallPageInRange(ctx, u, w.Start, w.End, data)
+			} else {
+				page, _, err = vc.GetNextCallPageInRange(ctx, u, w.Start, w.End, next)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return &callExportPage{page}, nil
+		},
+	}
+}
+
+var alertExportColumns = []string{"Sid", "DateCreated", "LogLevel",
+	"ErrorCode", "Description", "MoreInfo", "ResourceSid", "ServiceSid",
+	"RequestMethod", "RequestURL"}
+
+// Only exported if the user asks for them; they can be large, and may hold
+// customer data.
+var alertExportBodyColumns = []string{"RequestVariables", "ResponseBody"}
+
+type alertExportPage struct {
+	*views.AlertPage
+}
+
+func (a *alertExportPage) Rows(columns []string, keep func(string, twilio.TwilioTime) bool) [][]string {
+	rows := make([][]string, 0, len(a.Alerts()))
+	for _, alert := range a.Alerts() {
+		sid, _ := alert.Sid()
+		created, _ := alert.DateCreated()
+		if !keep(sid, created) {
+			continue
+		}
+		row := make([]string, len(columns))
+		for i, col := range columns {
+			if col == "Description" {
+				row[i] = formatExportString(alert.Description())
+				continue
+			}
+			if !alert.CanViewProperty(col) {
+				continue
+			}
+			switch col {
+			case "Sid":
+				row[i] = formatExportString(alert.Sid())
+			case "DateCreated":
+				row[i] = formatExportTime(alert.DateCreated())
+			case "LogLevel":
+				l, _ := alert.LogLevel()
+				row[i] = string(l)
+			case "ErrorCode":
+				if code, _ := alert.ErrorCode(); code > 0 {
+					row[i] = strconv.Itoa(int(code))
+				}
+			case "MoreInfo":
+				row[i] = formatExportString(alert.MoreInfo())
+			case "ResourceSid":
+				row[i] = formatExportString(alert.ResourceSid())
+			case "ServiceSid":
+				row[i] = formatExportString(alert.ServiceSid())
+			case "RequestMethod":
+				row[i] = formatExportString(alert.RequestMethod())
+			case "RequestURL":
+				row[i] = formatExportString(alert.RequestURL())
+			case "RequestVariables":
+				if vals, err := alert.RequestVariables(); err == nil {
+					row[i] = vals.Encode()
+				}
+			case "ResponseBody":
+				row[i] = formatExportString(alert.ResponseBody())
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// newAlertExport exports the alerts between start and end. If includeBodies
+// is true, request variables and response bodies are exported too, for users
+// that can view them.
+func newAlertExport(vc views.Client, u *config.User, start, end time.Time, data url.Values, includeBodies bool) *exportTask {
+	columns := alertExportColumns
+	if includeBodies {
+		columns = append(append([]string{}, alertExportColumns...), alertExportBodyColumns...)
+	}
+	return &exportTask{
+		Name:    "alerts",
+		Columns: columns,
+		Spec: exportSpec{Resource: "alerts", Start: start, End: end, Filters: data,
+			IncludeBodies: includeBodies, Window: exportWindow, MaxWindows: maxExportWindows},
+		Created: time.Now().UTC(),
+		Fetch: func(ctx context.Context, w views.Window, next string) (exportPage, error) {
+			var page *views.AlertPage
+			var err error
+			if next == "" {
+				page, _, err = vc.GetAlertPageInRange(ctx, u, w.Start, w.End, data)
+			} else {
+				page, _, err = vc.GetNextAlertPageInRange(ctx, u, w.Start, w.End, next)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return &alertExportPage{page}, nil
+		},
+	}
+}
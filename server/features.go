@@ -0,0 +1,37 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+)
+
+// withFeatures turns on or off the features in the features setting for the
+// authenticated user, unless their group overrides them.
+func withFeatures(h http.Handler, features config.Features) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, ok := config.GetUser(r); ok {
+			r = config.SetUser(r, u.WithFeatures(features))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// requireFeature serves a 404 to users who don't have the named feature, as
+// if the page didn't exist.
+func requireFeature(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := config.GetUser(r)
+		if !ok {
+			rest.ServerError(w, r, errors.New("No user available"))
+			return
+		}
+		if !u.Feature(name) {
+			rest.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net/url"
+	"strings"
+)
+
+// filterParams are the query parameters one list page uses for the filters
+// the lists have in common. An empty name means the list can't filter that
+// way, and the value is dropped when switching to it.
+type filterParams struct {
+	From, To, Country, Team, Provider, Account string
+	// The start and end of the time range.
+	Start, End string
+	// A phone number search, which matches any part of a number.
+	Number string
+}
+
+// listFilterParams maps each list page to the names of its filters.
+var listFilterParams = map[string]filterParams{
+	"/messages": {
+		From: "from", To: "to", Country: "country", Team: "team",
+		Provider: "provider", Account: "account", Start: "start", End: "end",
+	},
+	"/calls": {
+		From: "from", To: "to", Country: "country", Team: "team",
+		Provider: "provider", Account: "account", Start: "start-after", End: "start-before",
+	},
+	"/conferences":   {Start: "created-after", End: "created-before"},
+	"/alerts":        {Account: "account", Start: "alert-start", End: "alert-end"},
+	"/phone-numbers": {Number: "phone-number"},
+}
+
+// A listFilter is the filters the user set on a list page, so switching to
+// another list keeps them where that list can use them. Filter by a phone
+// number on the Messages page and the Calls link filters by the same number.
+type listFilter struct {
+	From, To, Country, Team, Provider, Account string
+	// Start and End are HTML5 datetime-local values, in the user's time zone.
+	Start, End string
+}
+
+// parseListFilter reads the filters from the query of the list page at path.
+// It returns nil if path isn't a list page or no filters are set.
+func parseListFilter(path string, query url.Values) *listFilter {
+	params, ok := listFilterParams[path]
+	if !ok {
+		return nil
+	}
+	get := func(name string) string {
+		if name == "" {
+			return ""
+		}
+		return strings.TrimSpace(query.Get(name))
+	}
+	f := &listFilter{
+		From:     get(params.From),
+		To:       get(params.To),
+		Country:  get(params.Country),
+		Team:     get(params.Team),
+		Provider: get(params.Provider),
+		Account:  get(params.Account),
+		Start:    get(params.Start),
+		End:      get(params.End),
+	}
+	if *f == (listFilter{}) {
+		return nil
+	}
+	return f
+}
+
+// URL returns the link to the list page at path with as many of the filters
+// as it supports.
+func (f *listFilter) URL(path string) string {
+	params, ok := listFilterParams[path]
+	if f == nil || !ok {
+		return path
+	}
+	query := url.Values{}
+	set := func(name, val string) {
+		if name != "" && val != "" {
+			query.Set(name, val)
+		}
+	}
+	set(params.From, f.From)
+	set(params.To, f.To)
+	set(params.Country, f.Country)
+	set(params.Team, f.Team)
+	set(params.Provider, f.Provider)
+	set(params.Account, f.Account)
+	set(params.Start, f.Start)
+	set(params.End, f.End)
+	// The phone number list can't tell a number sending from one receiving,
+	// so search for either one.
+	if f.From != "" {
+		set(params.Number, f.From)
+	} else {
+		set(params.Number, f.To)
+	}
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
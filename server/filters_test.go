@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+)
+
+var listFilterTests = []struct {
+	path  string
+	query string
+	to    string
+	want  string
+}{
+	{"/messages", "from=%2B14105551234&start=2016-10-20T12%3A00", "/calls", "/calls?from=%2B14105551234&start-after=2016-10-20T12%3A00"},
+	{"/calls", "to=%2B14105551234&start-before=2016-10-21T00%3A00", "/messages", "/messages?end=2016-10-21T00%3A00&to=%2B14105551234"},
+	{"/messages", "from=%2B14105551234&start=2016-10-20T12%3A00", "/alerts", "/alerts?alert-start=2016-10-20T12%3A00"},
+	{"/messages", "to=%2B14105551234", "/phone-numbers", "/phone-numbers?phone-number=%2B14105551234"},
+	{"/messages", "to=%2B14105551234", "/conferences", "/conferences"},
+	{"/conferences", "status=completed&created-after=2016-10-20T12%3A00", "/calls", "/calls?start-after=2016-10-20T12%3A00"},
+	{"/messages", "next=abc", "/calls", "/calls"},
+	{"/messages/SM123", "from=%2B14105551234", "/calls", "/calls"},
+}
+
+func TestListFilterURL(t *testing.T) {
+	t.Parallel()
+	for _, tt := range listFilterTests {
+		query, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := parseListFilter(tt.path, query)
+		if got := f.URL(tt.to); got != tt.want {
+			t.Errorf("%s?%s to %s: got %q, want %q", tt.path, tt.query, tt.to, got, tt.want)
+		}
+	}
+}
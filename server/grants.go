@@ -0,0 +1,218 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+// The longest reason someone can give for a grant.
+const maxGrantReasonLength = 200
+
+// withGrants gives the authenticated user the permissions from any of their
+// grants that haven't expired. It runs on every request, so a grant stops
+// applying as soon as it expires or is revoked.
+func withGrants(h http.Handler, grants *config.GrantStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, ok := config.GetUser(r); ok {
+			r = config.SetUser(r, grants.Apply(u, time.Now()))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Users with can_debug_permissions can send this header, with any value, to
+// see which permission hides each hidden field.
+const debugPermissionsHeader = "X-Logrole-Debug-Permissions"
+
+// withPermissionDebugging explains hidden fields for requests that ask for it,
+// if the user is allowed to. It runs after withGrants, so the explanations
+// account for grants.
+func withPermissionDebugging(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(debugPermissionsHeader) != "" {
+			if u, ok := config.GetUser(r); ok {
+				r = config.SetUser(r, u.WithPermissionDebugging())
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// grantServer lists, creates and revokes temporary permissions. It requires
+// the can_grant_permissions permission.
+type grantServer struct {
+	log.Logger
+	Grants         *config.GrantStore
+	Audit          *services.AuditLog
+	LocationFinder services.LocationFinder
+	tpl            *template.Template
+}
+
+func newGrantServer(l log.Logger, grants *config.GrantStore, audit *services.AuditLog, lf services.LocationFinder) (*grantServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+grantListTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &grantServer{
+		Logger:         l,
+		Grants:         grants,
+		Audit:          audit,
+		LocationFinder: lf,
+		tpl:            tpl,
+	}, nil
+}
+
+type grantListData struct {
+	Grants []*config.Grant
+	// The permissions the current user can grant.
+	Permissions []string
+	Loc         *time.Location
+	MaxHours    int
+	Err         string
+	// Form values, so they aren't lost after an error.
+	User   string
+	Hours  int
+	Reason string
+}
+
+func (d *grantListData) Title() string {
+	return "Temporary Permissions"
+}
+
+func (s *grantServer) renderList(w http.ResponseWriter, r *http.Request, u *config.User, code int, data *grantListData) {
+	data.Grants = s.Grants.Active(time.Now())
+	data.Loc = s.LocationFinder.GetLocationReq(r)
+	data.MaxHours = int(config.MaxGrantDuration / time.Hour)
+	if data.Hours == 0 {
+		data.Hours = 4
+	}
+	for _, p := range config.GrantablePermissions() {
+		if u.HasPermission(p) {
+			data.Permissions = append(data.Permissions, p)
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (s *grantServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanGrantPermissions() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to grant permissions"})
+		return
+	}
+	switch {
+	case r.Method == "GET":
+		s.renderList(w, r, u, http.StatusOK, &grantListData{})
+	case r.URL.Path == "/admin/grants/revoke":
+		s.revoke(w, r, u)
+	default:
+		s.create(w, r, u)
+	}
+}
+
+// POST /admin/grants
+//
+// Give user the permissions in every permission field for hours hours.
+func (s *grantServer) create(w http.ResponseWriter, r *http.Request, u *config.User) {
+	if err := r.ParseForm(); err != nil {
+		s.renderList(w, r, u, http.StatusBadRequest, &grantListData{Err: err.Error()})
+		return
+	}
+	data := &grantListData{
+		User:   strings.TrimSpace(r.PostForm.Get("user")),
+		Reason: strings.TrimSpace(r.PostForm.Get("reason")),
+	}
+	hours, err := strconv.Atoi(r.PostForm.Get("hours"))
+	data.Hours = hours
+	maxHours := int(config.MaxGrantDuration / time.Hour)
+	switch {
+	case err != nil || hours < 1 || hours > maxHours:
+		data.Err = fmt.Sprintf("Choose a number of hours between 1 and %d", maxHours)
+	case data.Reason == "":
+		data.Err = "Give a reason for the grant; it's recorded in the audit log"
+	case len(data.Reason) > maxGrantReasonLength:
+		data.Err = fmt.Sprintf("Reason is longer than %d characters", maxGrantReasonLength)
+	case data.User == u.ID():
+		data.Err = "You can't grant permissions to yourself"
+	}
+	permissions := r.PostForm["permission"]
+	if data.Err == "" {
+		for _, p := range permissions {
+			if !u.HasPermission(p) {
+				data.Err = fmt.Sprintf("You can't grant %s because you don't have it", p)
+				break
+			}
+		}
+	}
+	if data.Err != "" {
+		s.renderList(w, r, u, http.StatusBadRequest, data)
+		return
+	}
+	g, err := s.Grants.Add(&config.Grant{
+		User:        data.User,
+		Permissions: permissions,
+		Expires:     time.Now().UTC().Add(time.Duration(hours) * time.Hour),
+		Reason:      data.Reason,
+		GrantedBy:   u.ID(),
+	})
+	if err != nil {
+		data.Err = err.Error()
+		s.renderList(w, r, u, http.StatusBadRequest, data)
+		return
+	}
+	s.Audit.Record(&services.AuditEvent{
+		User:     u.ID(),
+		Action:   "grant_permissions",
+		Resource: g.User,
+		Details: map[string]string{
+			"id":          g.ID,
+			"permissions": strings.Join(g.Permissions, ","),
+			"expires":     g.Expires.Format(time.RFC3339),
+			"reason":      g.Reason,
+		},
+	})
+	http.Redirect(w, r, "/admin/grants", http.StatusFound)
+}
+
+// POST /admin/grants/revoke
+//
+// Remove the grant with the given id before it expires.
+func (s *grantServer) revoke(w http.ResponseWriter, r *http.Request, u *config.User) {
+	if err := r.ParseForm(); err != nil {
+		s.renderList(w, r, u, http.StatusBadRequest, &grantListData{Err: err.Error()})
+		return
+	}
+	g, err := s.Grants.Revoke(r.PostForm.Get("id"))
+	if err != nil {
+		s.renderList(w, r, u, http.StatusBadRequest, &grantListData{Err: err.Error()})
+		return
+	}
+	s.Audit.Record(&services.AuditEvent{
+		User:     u.ID(),
+		Action:   "revoke_grant",
+		Resource: g.User,
+		Details: map[string]string{
+			"id":          g.ID,
+			"permissions": strings.Join(g.Permissions, ","),
+		},
+	})
+	http.Redirect(w, r, "/admin/grants", http.StatusFound)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/test/harness"
+)
+
+func newTestGrantServer(t *testing.T) *grantServer {
+	grants, err := config.NewGrantStore("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit, _ := services.NewAuditLog(NullLogger, "")
+	lf, _ := services.NewLocationFinder("America/Los_Angeles")
+	s, err := newGrantServer(NullLogger, grants, audit, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+var grantPolicy = &config.Policy{
+	&config.Group{Name: "admins", Users: []string{"admin@example.com"}, Permissions: config.AllUserSettings()},
+	&config.Group{Name: "support", Users: []string{"support@example.com"}, Permissions: &config.UserSettings{CanViewMessages: true}},
+}
+
+func postGrant(s http.Handler, u *config.User, path string, form url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = config.SetUser(req, u)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func TestGrantElevatesUser(t *testing.T) {
+	t.Parallel()
+	s := newTestGrantServer(t)
+	admin, _, _ := grantPolicy.Lookup("admin@example.com")
+	support, _, _ := grantPolicy.Lookup("support@example.com")
+
+	form := url.Values{
+		"user":       {"support@example.com"},
+		"permission": {"can_view_message_body"},
+		"hours":      {"4"},
+		"reason":     {"SUPPORT-123"},
+	}
+	if w := postGrant(s, support, "/admin/grants", form); w.Code != 403 {
+		t.Errorf("expected users without can_grant_permissions to get a 403, got %d", w.Code)
+	}
+	if w := postGrant(s, admin, "/admin/grants", form); w.Code != 302 {
+		t.Fatalf("expected Code to be 302, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var seen *config.User
+	h := withGrants(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = config.GetUser(r)
+	}), s.Grants)
+	req, _ := http.NewRequest("GET", "/messages", nil)
+	h.ServeHTTP(httptest.NewRecorder(), config.SetUser(req, support))
+	if !seen.CanViewMessageBody() {
+		t.Error("expected grant to let support view message bodies")
+	}
+
+	active := s.Grants.Active(time.Now())
+	if len(active) != 1 {
+		t.Fatalf("expected 1 grant, got %d", len(active))
+	}
+	if w := postGrant(s, admin, "/admin/grants/revoke", url.Values{"id": {active[0].ID}}); w.Code != 302 {
+		t.Fatalf("expected Code to be 302, got %d", w.Code)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), config.SetUser(req, support))
+	if seen.CanViewMessageBody() {
+		t.Error("expected revoked grant not to apply")
+	}
+}
+
+func TestGrantRejectsPermissionsTheAdminLacks(t *testing.T) {
+	t.Parallel()
+	s := newTestGrantServer(t)
+	us := config.AllUserSettings()
+	us.CanPlayRecordings = false
+	p := &config.Policy{&config.Group{Name: "leads", Users: []string{"lead@example.com"}, Permissions: us}}
+	lead, _, _ := p.Lookup("lead@example.com")
+	tests := []url.Values{
+		{"user": {"support@example.com"}, "permission": {"can_play_recordings"}, "hours": {"1"}, "reason": {"test"}},
+		{"user": {"lead@example.com"}, "permission": {"can_view_calls"}, "hours": {"1"}, "reason": {"test"}},
+		{"user": {"support@example.com"}, "permission": {"can_view_calls"}, "hours": {"1000"}, "reason": {"test"}},
+		{"user": {"support@example.com"}, "permission": {"can_view_calls"}, "hours": {"1"}},
+	}
+	for _, form := range tests {
+		if w := postGrant(s, lead, "/admin/grants", form); w.Code != 400 {
+			t.Errorf("expected Code to be 400 for %v, got %d", form, w.Code)
+		}
+	}
+	if active := s.Grants.Active(time.Now()); len(active) != 0 {
+		t.Errorf("expected no grants to be created, got %d", len(active))
+	}
+}
+
+var debugMessageResp = []byte(`{"sid": "SM26b3b00f8def53be77c5697183bfe95e", "date_created": "Tue, 20 Sep 2016 22:41:38 +0000", "date_updated": "Tue, 20 Sep 2016 22:41:38 +0000", "to": "+19253920364", "from": "+19253920364", "body": "Hello", "status": "delivered", "direction": "outbound-api", "num_media": "0", "num_segments": "1", "price": "-0.00750", "price_unit": "USD"}`)
+
+func TestPermissionDebugging(t *testing.T) {
+	t.Parallel()
+	server := newServerWithResponse(200, debugMessageResp)
+	defer server.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	mis, err := newMessageInstanceServer(dlog, vc, lf, nil, nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := withPermissionDebugging(mis)
+	s := config.AllUserSettings()
+	s.CanViewPrices = false
+	u := config.NewUser(s)
+	for _, debug := range []bool{false, true} {
+		req, _ := http.NewRequest("GET", "/messages/SM26b3b00f8def53be77c5697183bfe95e", nil)
+		if debug {
+			req.Header.Set(debugPermissionsHeader, "1")
+		}
+		req = config.SetUser(req, u)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+		}
+		want := `<i>hidden</i> <small class="hidden-reason">(requires can_view_prices (for can_view_message_price))</small>`
+		if got := strings.Contains(w.Body.String(), want); got != debug {
+			t.Errorf("debug %t: expected reason shown to be %t, got %s", debug, debug, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+// How many days the heatmap covers, ending today.
+const heatmapDays = 90
+
+// Stop counting a resource after this many pages, and show its counts as
+// lower bounds.
+const maxHeatmapPages = 100
+
+// How long to reuse a heatmap before counting everything again. Most of the
+// days in it don't change.
+const heatmapTimeout = time.Hour
+
+// Counting runs in the background, since a busy account can take minutes.
+const heatmapCountTimeout = 5 * time.Minute
+
+// heatmapSeries is the number of resources of one type created on each day,
+// keyed by "2006-01-02" in the user's timezone.
+type heatmapSeries struct {
+	Name   string
+	Counts map[string]int
+	// True if we stopped counting before reaching the first day.
+	Truncated bool
+	Err       string
+}
+
+// heatmapCounts are cached for each user, filter and timezone.
+type heatmapCounts struct {
+	Series     []*heatmapSeries
+	ComputedAt time.Time
+}
+
+// A heatmapCell is one day in a heatmap.
+type heatmapCell struct {
+	Date  time.Time
+	Count int
+	// 0 for no traffic, up to 4 for the busiest days.
+	Level int
+	// The list of resources created that day. Empty for days outside the
+	// heatmap, which are drawn as blank cells.
+	URL string
+}
+
+// A heatmapWeek is a column in the heatmap, Sunday through Saturday.
+type heatmapWeek struct {
+	Days []*heatmapCell
+}
+
+type heatmapGrid struct {
+	Name      string
+	Weeks     []*heatmapWeek
+	Total     int
+	Busiest   int
+	Truncated bool
+	Err       string
+}
+
+type heatmapServer struct {
+	log.Logger
+	Client         views.Client
+	LocationFinder services.LocationFinder
+	cache          *cache.Cache
+	tpl            *template.Template
+
+	mu sync.Mutex
+	// Keys of heatmaps being counted right now.
+	running map[string]bool
+}
+
+func newHeatmapServer(l log.Logger, vc views.Client, lf services.LocationFinder) (*heatmapServer, error) {
+	s := &heatmapServer{
+		Logger:         l,
+		Client:         vc,
+		LocationFinder: lf,
+		cache:          cache.NewCache(100, l),
+		running:        make(map[string]bool),
+	}
+	tpl, err := newTpl(template.FuncMap{}, base+heatmapTpl)
+	if err != nil {
+		return nil, err
+	}
+	s.tpl = tpl
+	return s, nil
+}
+
+type heatmapData struct {
+	Grids []*heatmapGrid
+	Query url.Values
+	Loc   *time.Location
+	// True while the counts are being computed in the background.
+	Counting   bool
+	ComputedAt time.Time
+	Err        string
+}
+
+func (d *heatmapData) Title() string {
+	return "Traffic Heatmap"
+}
+
+func (d *heatmapData) Path() string {
+	return "/heatmap"
+}
+
+// Weekdays returns the row labels, starting with Sunday.
+func (d *heatmapData) Weekdays() []string {
+	return []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+}
+
+func (s *heatmapServer) validParams() []string {
+	return []string{"from", "to"}
+}
+
+func (s *heatmapServer) renderError(w http.ResponseWriter, r *http.Request, code int, query url.Values, err error) {
+	data := &baseData{
+		LF: s.LocationFinder,
+		Data: &heatmapData{
+			Query: query,
+			Loc:   s.LocationFinder.GetLocationReq(r),
+			Err:   cleanError(err),
+		},
+	}
+	s.Warn("Error responding to request", "status", code, "url", r.URL.String(), "err", err)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", data); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// GET /heatmap
+// GET /heatmap?from=+14155551234
+// GET /heatmap?to=+14155551234
+//
+// Show how many messages and calls were created on each of the last 90 days.
+// The counts are computed in the background and cached, so the first request
+// shows a placeholder that refreshes until they're ready.
+func (s *heatmapServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewMessages() && !u.CanViewCalls() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	query := r.URL.Query()
+	if err := validateParams(s.validParams(), query); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, err)
+		return
+	}
+	filters := url.Values{}
+	if err := setPageFilters(query, filters); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, err)
+		return
+	}
+	loc := s.LocationFinder.GetLocationReq(r)
+	key := "heatmap:" + filters.Encode() + ":" + loc.String() + ":" + u.ID()
+	counts := new(heatmapCounts)
+	data := &heatmapData{Query: query, Loc: loc}
+	if _, err := s.cache.Get(key, counts); err != nil {
+		s.start(key, u, filters, loc)
+		data.Counting = true
+	} else {
+		data.Grids = buildHeatmap(counts, time.Now().In(loc), query)
+		data.ComputedAt = counts.ComputedAt
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// start counts the heatmap for key in the background, unless it's already
+// being counted.
+func (s *heatmapServer) start(key string, u *config.User, filters url.Values, loc *time.Location) {
+	s.mu.Lock()
+	if s.running[key] {
+		s.mu.Unlock()
+		return
+	}
+	s.running[key] = true
+	s.mu.Unlock()
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.running, key)
+			s.mu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), heatmapCountTimeout)
+		defer cancel()
+		counts := s.count(ctx, u, filters, time.Now().In(loc))
+		for _, series := range counts.Series {
+			if series.Err != "" {
+				s.Warn("Error counting heatmap", "key", key, "series", series.Name, "err", series.Err)
+				// Cache the failure briefly, so the page stops refreshing and
+				// shows the error.
+				s.cache.Set(key, counts, 10*time.Second)
+				return
+			}
+		}
+		s.cache.Set(key, counts, heatmapTimeout)
+	}()
+}
+
+// count counts the messages and calls matching filters on each of the last
+// heatmapDays days, ending with the day containing now.
+func (s *heatmapServer) count(ctx context.Context, u *config.User, filters url.Values, now time.Time) *heatmapCounts {
+	loc := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	first := today.AddDate(0, 0, -(heatmapDays - 1))
+	end := today.AddDate(0, 0, 1)
+	counts := &heatmapCounts{ComputedAt: now}
+	var counters []func(context.Context, *heatmapSeries) (bool, error)
+	data := url.Values{}
+	for k, v := range filters {
+		data[k] = v
+	}
+	data.Set("PageSize", strconv.Itoa(dashboardPageSize))
+	if u.CanViewMessages() {
+		counts.Series = append(counts.Series, &heatmapSeries{Name: "Messages"})
+		counters = append(counters, func(ctx context.Context, series *heatmapSeries) (bool, error) {
+			return s.countMessages(ctx, u, first, end, data, series)
+		})
+	}
+	if u.CanViewCalls() {
+		counts.Series = append(counts.Series, &heatmapSeries{Name: "Calls"})
+		counters = append(counters, func(ctx context.Context, series *heatmapSeries) (bool, error) {
+			return s.countCalls(ctx, u, first, end, data, series)
+		})
+	}
+	// Each goroutine writes to its own series; nothing is read until g.Wait
+	// returns.
+	g, errctx := errgroup.WithContext(ctx)
+	for i := range counts.Series {
+		series, count := counts.Series[i], counters[i]
+		series.Counts = make(map[string]int)
+		g.Go(func() error {
+			var err error
+			series.Truncated, err = count(errctx, series)
+			if err != nil {
+				series.Err = cleanError(err)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	return counts
+}
+
+func (s *heatmapServer) countMessages(ctx context.Context, u *config.User, start, end time.Time, data url.Values, series *heatmapSeries) (bool, error) {
+	loc := start.Location()
+	iter := views.MessagesInRange(s.Client, u, start, end, data)
+	iter.MaxPages = maxHeatmapPages
+	for iter.Next(ctx) {
+		if created, err := iter.Message().DateCreated(); err == nil && created.Valid {
+			series.Counts[created.Time.In(loc).Format("2006-01-02")]++
+		}
+	}
+	return iter.Truncated(), iter.Err()
+}
+
+func (s *heatmapServer) countCalls(ctx context.Context, u *config.User, start, end time.Time, data url.Values, series *heatmapSeries) (bool, error) {
+	loc := start.Location()
+	iter := views.CallsInRange(s.Client, u, start, end, data)
+	iter.MaxPages = maxHeatmapPages
+	for iter.Next(ctx) {
+		if created, err := iter.Call().DateCreated(); err == nil && created.Valid {
+			series.Counts[created.Time.In(loc).Format("2006-01-02")]++
+		}
+	}
+	return iter.Truncated(), iter.Err()
+}
+
+// heatmapLevel buckets count into one of five shades, relative to the
+// busiest day.
+func heatmapLevel(count, busiest int) int {
+	if count == 0 || busiest == 0 {
+		return 0
+	}
+	level := 1 + 4*count/(busiest+1)
+	if level > 4 {
+		level = 4
+	}
+	return level
+}
+
+// heatmapURL returns the list of resources of the given type created on day,
+// with the same filters as the heatmap.
+func heatmapURL(name string, day time.Time, query url.Values) string {
+	data := url.Values{}
+	for _, param := range []string{"from", "to"} {
+		if val := query.Get(param); val != "" {
+			data.Set(param, val)
+		}
+	}
+	start := day.Format(HTML5DatetimeLocalFormat)
+	end := day.AddDate(0, 0, 1).Format(HTML5DatetimeLocalFormat)
+	if name == "Calls" {
+		data.Set("start-after", start)
+		data.Set("start-before", end)
+		return "/calls?" + data.Encode()
+	}
+	data.Set("start", start)
+	data.Set("end", end)
+	return "/messages?" + data.Encode()
+}
+
+// buildHeatmap lays out each series as a calendar, one column per week, with
+// the last column holding the week containing now.
+func buildHeatmap(counts *heatmapCounts, now time.Time, query url.Values) []*heatmapGrid {
+	loc := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	first := today.AddDate(0, 0, -(heatmapDays - 1))
+	gridStart := first.AddDate(0, 0, -int(first.Weekday()))
+	grids := make([]*heatmapGrid, 0, len(counts.Series))
+	for _, series := range counts.Series {
+		grid := &heatmapGrid{Name: series.Name, Truncated: series.Truncated, Err: series.Err}
+		for day := first; !day.After(today); day = day.AddDate(0, 0, 1) {
+			if count := series.Counts[day.Format("2006-01-02")]; count > grid.Busiest {
+				grid.Busiest = count
+			}
+		}
+		var week *heatmapWeek
+		for day := gridStart; !day.After(today); day = day.AddDate(0, 0, 1) {
+			if day.Weekday() == time.Sunday {
+				week = &heatmapWeek{Days: make([]*heatmapCell, 0, 7)}
+				grid.Weeks = append(grid.Weeks, week)
+			}
+			cell := &heatmapCell{Date: day}
+			if !day.Before(first) {
+				cell.Count = series.Counts[day.Format("2006-01-02")]
+				cell.Level = heatmapLevel(cell.Count, grid.Busiest)
+				cell.URL = heatmapURL(series.Name, day, query)
+				grid.Total += cell.Count
+			}
+			week.Days = append(week.Days, cell)
+		}
+		grids = append(grids, grid)
+	}
+	return grids
+}
+
+// Label describes the cell for screen readers and tooltips, e.g. "12 messages
+// on Tue, Oct 18".
+func (c *heatmapCell) Label(name string) string {
+	return fmt.Sprintf("%d %s on %s", c.Count, lowerName(name, c.Count), c.Date.Format("Mon, Jan 2"))
+}
+
+func lowerName(name string, count int) string {
+	switch name {
+	case "Messages":
+		if count == 1 {
+			return "message"
+		}
+		return "messages"
+	default:
+		if count == 1 {
+			return "call"
+		}
+		return "calls"
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/test"
+	"github.com/saintpete/logrole/test/harness"
+	"golang.org/x/net/context"
+)
+
+var heatmapLevelTests = []struct {
+	count   int
+	busiest int
+	level   int
+}{
+	{0, 0, 0},
+	{0, 10, 0},
+	{1, 10, 1},
+	{5, 10, 2},
+	{10, 10, 4},
+	{1, 1, 3},
+}
+
+func TestHeatmapLevel(t *testing.T) {
+	t.Parallel()
+	for _, tt := range heatmapLevelTests {
+		if level := heatmapLevel(tt.count, tt.busiest); level != tt.level {
+			t.Errorf("heatmapLevel(%d, %d): got %d, want %d", tt.count, tt.busiest, level, tt.level)
+		}
+	}
+}
+
+func TestBuildHeatmap(t *testing.T) {
+	t.Parallel()
+	// A Monday; the first day in the heatmap is Wednesday, August 3.
+	now := time.Date(2016, 10, 31, 15, 0, 0, 0, time.UTC)
+	counts := &heatmapCounts{Series: []*heatmapSeries{
+		{Name: "Calls", Counts: map[string]int{"2016-10-27": 4, "2016-10-28": 2, "2016-01-01": 100}},
+	}}
+	query := url.Values{"from": []string{"+14105551234"}}
+	grids := buildHeatmap(counts, now, query)
+	if len(grids) != 1 {
+		t.Fatalf("expected one grid, got %d", len(grids))
+	}
+	grid := grids[0]
+	if grid.Total != 6 {
+		t.Errorf("expected days outside the heatmap not to be counted, got total %d", grid.Total)
+	}
+	first := grid.Weeks[0].Days[0]
+	if first.Date.Weekday() != time.Sunday || first.URL != "" {
+		t.Errorf("expected first cell to be a blank Sunday, got %#v", first)
+	}
+	if aug3 := grid.Weeks[0].Days[3]; aug3.URL == "" {
+		t.Errorf("expected first day in the heatmap to have a URL, got %#v", aug3)
+	}
+	last := grid.Weeks[len(grid.Weeks)-1]
+	if len(last.Days) != 2 {
+		t.Fatalf("expected last week to end on Monday, got %d days", len(last.Days))
+	}
+	thursday := grid.Weeks[len(grid.Weeks)-2].Days[4]
+	if thursday.Count != 4 || thursday.Level != 4 {
+		t.Errorf("expected busiest day to have level 4, got %#v", thursday)
+	}
+	want := "/calls?from=%2B14105551234&start-after=2016-10-27T00%3A00&start-before=2016-10-28T00%3A00"
+	if thursday.URL != want {
+		t.Errorf("expected URL %q, got %q", want, thursday.URL)
+	}
+	if label := thursday.Label(grid.Name); label != "4 calls on Thu, Oct 27" {
+		t.Errorf("bad label: %q", label)
+	}
+}
+
+func TestHeatmapCountsCalls(t *testing.T) {
+	t.Parallel()
+	server := newServerWithResponse(200, test.CallListBody)
+	defer server.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	s, err := newHeatmapServer(dlog, vc, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := config.NewUser(&config.UserSettings{CanViewCalls: true, CanViewNumMedia: true})
+	now := time.Date(2016, 10, 28, 15, 0, 0, 0, time.UTC)
+	counts := s.count(context.Background(), u, url.Values{}, now)
+	if len(counts.Series) != 1 {
+		t.Fatalf("expected only calls to be counted, got %d series", len(counts.Series))
+	}
+	calls := counts.Series[0]
+	if calls.Err != "" {
+		t.Fatal(calls.Err)
+	}
+	if calls.Counts["2016-10-27"] != 2 || calls.Truncated {
+		t.Errorf("expected 2 calls on Oct 27, got %#v", calls)
+	}
+}
+
+func TestHeatmapStartsCounting(t *testing.T) {
+	t.Parallel()
+	vc := harness.ViewsClient(harness.ViewHarness{SecretKey: key})
+	s, err := newHeatmapServer(dlog, vc, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/heatmap", nil)
+	req = config.SetUser(req, config.NewUser(&config.UserSettings{CanViewConferences: true}))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/heatmap?from=notanumber", nil)
+	req = config.SetUser(req, theUser)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Errorf("expected Code to be 400, got %d", w.Code)
+	}
+
+	// Put counts in the cache, so the request doesn't start counting.
+	loc := lf.GetLocationReq(req)
+	filters := url.Values{"From": []string{"+14105551234"}}
+	counts := &heatmapCounts{
+		Series:     []*heatmapSeries{{Name: "Messages", Counts: map[string]int{}}},
+		ComputedAt: time.Now(),
+	}
+	s.cache.Set("heatmap:"+filters.Encode()+":"+loc.String()+":"+theUser.ID(), counts, time.Minute)
+	req, _ = http.NewRequest("GET", "/heatmap?from=%2B14105551234", nil)
+	req = config.SetUser(req, theUser)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	if strings.Contains(body, "Counting traffic") || !strings.Contains(body, "heatmap-level-0") {
+		t.Errorf("expected cached heatmap, got %s", body)
+	}
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+// How long to reuse the home page stats before fetching them again.
+const homeTimeout = time.Minute
+
+// How long to wait for the stats before rendering the page without them. The
+// dashboard has longer to count.
+const homeFetchTimeout = 5 * time.Second
+
+// How many alerts and webhooks to show on the home page.
+const homeListSize = 5
+
+// A homeCount is one of the counts at the top of the home page.
+type homeCount struct {
+	dashboardCount
+	Err string
+}
+
+// A homeAlert is one of the latest alerts. Fields the user can't view are
+// empty.
+type homeAlert struct {
+	Sid         string
+	Code        twilio.Code
+	Description string
+	Created     time.Time
+}
+
+// homeStats are cached for each user and timezone.
+type homeStats struct {
+	// Nil if the user can't view messages or calls.
+	Messages *homeCount
+	Calls    *homeCount
+	// Newest first.
+	Alerts    []*homeAlert
+	AlertsErr string
+	// The webhooks with the most alerts today. Only set if the user can
+	// view callback URLs.
+	Webhooks   []*webhookUptime
+	Since      time.Time
+	ComputedAt time.Time
+}
+
+// complete returns true if every widget loaded. Failed widgets shouldn't be
+// cached.
+func (h *homeStats) complete() bool {
+	return (h.Messages == nil || h.Messages.Err == "") &&
+		(h.Calls == nil || h.Calls.Err == "") && h.AlertsErr == ""
+}
+
+// A homeLink is a quick link to a page the user can view.
+type homeLink struct {
+	Name        string
+	URL         string
+	Description string
+}
+
+type homeData struct {
+	*homeStats
+	// False if there's no API client to fetch stats with.
+	HasStats   bool
+	ShowAlerts bool
+	ShowHooks  bool
+	Links      []*homeLink
+	Loc        *time.Location
+	Runbooks   *config.Runbooks
+}
+
+func (d *homeData) Title() string {
+	return "Homepage"
+}
+
+// homeServer shows today's traffic, the latest alerts and the webhooks that
+// are failing, with links to the pages the user can view. Each widget is
+// only shown to users who can view what it counts.
+type homeServer struct {
+	log.Logger
+	// If nil, the page only shows links.
+	Client         views.Client
+	LocationFinder services.LocationFinder
+	// Counts messages and calls the same way as the dashboard.
+	counter *dashboardServer
+	alerts  *uptimeServer
+	cache   *cache.Cache
+	// Runbook links and notes for error codes. May be nil.
+	Runbooks *config.Runbooks
+	tpl      *template.Template
+}
+
+func newHomeServer(l log.Logger, vc views.Client, lf services.LocationFinder) (*homeServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+indexTpl+runbookTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &homeServer{
+		Logger:         l,
+		Client:         vc,
+		LocationFinder: lf,
+		counter:        &dashboardServer{Logger: l, Client: vc},
+		alerts:         &uptimeServer{Logger: l, Client: vc},
+		cache:          cache.NewCache(100, l),
+		tpl:            tpl,
+	}, nil
+}
+
+// homeLinks returns links to the pages u can view.
+func homeLinks(u *config.User) []*homeLink {
+	links := make([]*homeLink, 0)
+	if u.CanViewMessages() {
+		links = append(links, &homeLink{Name: "Messages", URL: "/messages"})
+	}
+	if u.CanViewCalls() {
+		links = append(links, &homeLink{Name: "Calls", URL: "/calls"})
+	}
+	if u.CanViewConferences() {
+		links = append(links, &homeLink{Name: "Conferences", URL: "/conferences"})
+	}
+	links = append(links, &homeLink{Name: "Phone Numbers", URL: "/phone-numbers"})
+	if u.CanViewAlerts() {
+		links = append(links, &homeLink{Name: "Alerts", URL: "/alerts"})
+	}
+	if u.CanViewMessages() || u.CanViewCalls() || u.CanViewAlerts() {
+		links = append(links, &homeLink{Name: "Dashboard", URL: "/dashboard", Description: "is today normal?"})
+	}
+	if u.CanViewAlerts() && u.CanViewCallbackURLs() {
+		links = append(links, &homeLink{Name: "Webhook Uptime", URL: "/alerts/uptime"})
+	}
+	if u.CanViewMessages() {
+		links = append(links, &homeLink{Name: "Stuck Messages", URL: "/stuck-messages"})
+	}
+	links = append(links,
+		&homeLink{Name: "Phone Number Labels", URL: "/labels"},
+		&homeLink{Name: "Phone Number Owners", URL: "/owners", Description: "who to page"},
+	)
+	return links
+}
+
+func (s *homeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	start := monotime.Now()
+	loc := s.LocationFinder.GetLocationReq(r)
+	data := &homeData{
+		HasStats:   s.Client != nil,
+		ShowAlerts: u.CanViewAlerts(),
+		ShowHooks:  u.CanViewAlerts() && u.CanViewCallbackURLs(),
+		Links:      homeLinks(u),
+		Loc:        loc,
+		Runbooks:   s.Runbooks,
+	}
+	bd := &baseData{LF: s.LocationFinder, Data: data}
+	if s.Client != nil {
+		key := "home:" + loc.String() + ":" + u.ID()
+		stats := new(homeStats)
+		cachedAt, err := s.cache.Get(key, stats)
+		if err != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), homeFetchTimeout)
+			defer cancel()
+			stats = s.fetch(ctx, u, time.Now().In(loc))
+			if stats.complete() {
+				s.cache.Set(key, stats, homeTimeout)
+			}
+			cachedAt = 0
+		}
+		data.homeStats = stats
+		if cachedAt > 0 {
+			bd.CachedDuration = monotime.Since(cachedAt)
+		}
+	}
+	bd.Duration = monotime.Since(start)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := render(w, r, s.tpl, "base", bd); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// fetch counts today's messages and calls, and reads today's alerts, at the
+// same time. A widget that fails or runs out of time has an error instead of
+// stopping the others.
+func (s *homeServer) fetch(ctx context.Context, u *config.User, now time.Time) *homeStats {
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	stats := &homeStats{Since: since, ComputedAt: now}
+	// Each goroutine writes to its own fields; nothing is read until g.Wait
+	// returns.
+	var g errgroup.Group
+	if u.CanViewMessages() {
+		stats.Messages = new(homeCount)
+		g.Go(func() error {
+			count, err := s.counter.countMessages(ctx, u, since, now)
+			stats.Messages.dashboardCount = count
+			stats.Messages.Err = homeError(err)
+			return nil
+		})
+	}
+	if u.CanViewCalls() {
+		stats.Calls = new(homeCount)
+		g.Go(func() error {
+			count, err := s.counter.countCalls(ctx, u, since, now)
+			stats.Calls.dashboardCount = count
+			stats.Calls.Err = homeError(err)
+			return nil
+		})
+	}
+	if u.CanViewAlerts() {
+		g.Go(func() error {
+			alerts, _, err := s.alerts.fetchAlerts(ctx, u, since, now)
+			if err != nil {
+				stats.AlertsErr = homeError(err)
+				return nil
+			}
+			stats.Alerts = latestAlerts(alerts, homeListSize)
+			if u.CanViewCallbackURLs() {
+				webhooks, _ := buildWebhookUptime(alerts, since, now.Sub(since)+time.Second, 1)
+				if len(webhooks) > homeListSize {
+					webhooks = webhooks[:homeListSize]
+				}
+				stats.Webhooks = webhooks
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	return stats
+}
+
+// homeError describes err for a widget, or returns the empty string if err is
+// nil.
+func homeError(err error) string {
+	switch err {
+	case nil:
+		return ""
+	case context.DeadlineExceeded, context.Canceled:
+		return "Timed out. Try the dashboard, which has longer to count."
+	default:
+		return cleanError(err)
+	}
+}
+
+// latestAlerts returns the first n alerts, which are sorted newest first,
+// with the fields the user can view.
+func latestAlerts(alerts []*views.Alert, n int) []*homeAlert {
+	if len(alerts) > n {
+		alerts = alerts[:n]
+	}
+	latest := make([]*homeAlert, 0, len(alerts))
+	for _, alert := range alerts {
+		ha := new(homeAlert)
+		ha.Sid, _ = alert.Sid()
+		ha.Code, _ = alert.ErrorCode()
+		ha.Description, _ = alert.Description()
+		if created, err := alert.DateCreated(); err == nil && created.Valid {
+			ha.Created = created.Time
+		}
+		latest = append(latest, ha)
+	}
+	return latest
+}
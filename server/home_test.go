@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/test"
+	"github.com/saintpete/logrole/test/harness"
+	"golang.org/x/net/context"
+)
+
+func TestHomeLinks(t *testing.T) {
+	t.Parallel()
+	us := config.AllUserSettings()
+	us.CanViewMessages = false
+	us.CanViewCallbackURLs = false
+	links := homeLinks(config.NewUser(us))
+	names := make(map[string]bool, len(links))
+	for _, link := range links {
+		names[link.Name] = true
+	}
+	for _, name := range []string{"Messages", "Stuck Messages", "Webhook Uptime"} {
+		if names[name] {
+			t.Errorf("expected no link to %s", name)
+		}
+	}
+	for _, name := range []string{"Calls", "Alerts", "Dashboard"} {
+		if !names[name] {
+			t.Errorf("expected a link to %s", name)
+		}
+	}
+}
+
+func TestHomeCountsCalls(t *testing.T) {
+	t.Parallel()
+	server := newServerWithResponse(200, test.CallListBody)
+	defer server.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	s, err := newHomeServer(dlog, vc, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := config.AllUserSettings()
+	us.CanViewMessages = false
+	us.CanViewAlerts = false
+	now := time.Date(2016, 10, 27, 23, 59, 0, 0, time.UTC)
+	stats := s.fetch(context.Background(), config.NewUser(us), now)
+	if stats.Messages != nil {
+		t.Error("expected messages not to be counted for a user who can't view them")
+	}
+	if stats.Calls == nil || stats.Calls.Err != "" || stats.Calls.Total != 2 {
+		t.Errorf("expected to count 2 calls, got %#v", stats.Calls)
+	}
+	if !stats.complete() {
+		t.Error("expected stats to be complete")
+	}
+}
+
+func TestHomeWithoutClient(t *testing.T) {
+	t.Parallel()
+	s, err := newHomeServer(dlog, nil, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/", nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `<a href="/calls">Calls</a>`) {
+		t.Errorf("expected a link to calls, got %s", body)
+	}
+	if strings.Contains(body, "Messages today") {
+		t.Errorf("expected no counts without a client")
+	}
+}
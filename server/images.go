@@ -1,28 +1,80 @@
 package server
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"html/template"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/golang/groupcache/lru"
+	log "github.com/inconshreveable/log15"
 	"github.com/kevinburke/handlers"
 	"github.com/kevinburke/rest"
 	twilio "github.com/saintpete/twilio-go"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
 	"github.com/saintpete/logrole/services"
 )
 
 // An imageServer provides an opaque proxy for image requests.
 type imageServer struct {
+	log.Logger
+	// If nil, images are fetched from Twilio on every request.
+	Blobs *cache.BlobStore
+	// If nil, images aren't scanned.
+	Scanner   services.MediaScanner
 	secretKey *[32]byte
+
+	tpl *template.Template
+	mu  sync.Mutex
+	// Scan results, keyed by media cache key.
+	scanned *lru.Cache
+}
+
+// How many scan results to remember, so the same image isn't scanned every
+// time it's viewed.
+const scanCacheSize = 1000
+
+func newImageServer(l log.Logger, blobs *cache.BlobStore, scanner services.MediaScanner, secretKey *[32]byte) (*imageServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+flaggedMediaTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &imageServer{
+		Logger:    l,
+		Blobs:     blobs,
+		Scanner:   scanner,
+		secretKey: secretKey,
+		tpl:       tpl,
+		scanned:   lru.New(scanCacheSize),
+	}, nil
 }
 
 var imageRoute = regexp.MustCompile("^/images/(?P<encrypted>([-_a-zA-Z0-9=]+))$")
 
+// decryptURL decodes a media URL that was created for the user making the
+// request. If it can't, it writes an error and returns true.
 func decryptURL(w http.ResponseWriter, r *http.Request, encoded string, secretKey *[32]byte) (*url.URL, bool) {
-	urlStr, err := services.Unopaque(encoded, secretKey)
+	user, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return nil, true
+	}
+	urlStr, err := services.UnopaqueFor(encoded, user.ID(), secretKey)
+	if err == services.ErrWrongOwner {
+		rest.Forbidden(w, r, &rest.Error{Title: err.Error()})
+		return nil, true
+	}
 	if err != nil {
 		rest.BadRequest(w, r, &rest.Error{
 			Title: err.Error(),
@@ -43,15 +95,30 @@ func decryptURL(w http.ResponseWriter, r *http.Request, encoded string, secretKe
 // GET /images/<encrypted URL>
 //
 // Decode the encrypted URL, then make a request to retrieve the resource in
-// question and forward it to the frontend.
+// question and forward it to the frontend. If a media cache is configured,
+// the response is served from (and stored in) the cache. If a media scanner
+// is configured, flagged images are replaced with a warning, which users
+// with permission can click through by adding "?flagged=show" to the URL.
 //
-// TODO: add some sort of caching layer, since the images are not changing.
+// Browsers can keep responses for mediaMaxAge, and revalidate them with the
+// ETag after that.
 func (i *imageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, ok := config.GetUser(r); ok && !user.CanViewMedia() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
 	encoded := imageRoute.FindStringSubmatch(r.URL.Path)[1]
 	u, wroteError := decryptURL(w, r, encoded, i.secretKey)
 	if wroteError {
 		return
 	}
+	key := mediaCacheKey(u)
+	if i.Blobs != nil {
+		if data, ctype, ok := i.Blobs.Get(key); ok {
+			i.serveScanned(w, r, key, ctype, data)
+			return
+		}
+	}
 	// TODO: only allow images to a defined set of hosts. I'm not sure of all
 	// of the different URLs used by Twilio to host media content.
 	//
@@ -68,21 +135,172 @@ func (i *imageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := getContext(r.Context(), 5*time.Second)
 	defer cancel()
 	req = req.WithContext(ctx)
+	streaming := i.Blobs == nil && i.Scanner == nil
+	if inm := r.Header.Get("If-None-Match"); streaming && inm != "" {
+		// We pass Twilio's ETag through when streaming, so Twilio can say
+		// whether the browser's copy is still good.
+		req.Header.Set("If-None-Match", inm)
+	}
 	resp, err := twilio.MediaClient.Do(req)
 	if err != nil {
 		rest.ServerError(w, r, err)
 		return
 	}
 	defer resp.Body.Close()
+	if streaming && resp.StatusCode == http.StatusNotModified {
+		setMediaCacheHeaders(w, resp.Header.Get("ETag"))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	ctype := resp.Header.Get("Content-Type")
 	if ctype == "" {
 		rest.ServerError(w, r, errors.New("Proxied request had no content-type header"))
 		return
 	}
-	w.Header().Set("Content-Type", ctype)
+	if !streaming && resp.StatusCode == http.StatusOK {
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			rest.ServerError(w, r, err)
+			return
+		}
+		if i.Blobs != nil {
+			if err := i.Blobs.Put(key, ctype, data); err != nil && err != cache.ErrTooLarge {
+				handlers.Logger.Warn("Could not store image in media cache", "err", err)
+			}
+		}
+		i.serveScanned(w, r, key, ctype, data)
+		return
+	}
+	setMediaTypeHeaders(w, ctype)
+	if resp.StatusCode == http.StatusOK {
+		setMediaCacheHeaders(w, resp.Header.Get("ETag"))
+	}
 	w.WriteHeader(http.StatusOK)
 	if _, err := io.Copy(w, resp.Body); err != nil {
 		rest.ServerError(w, r, err)
 		return
 	}
 }
+
+// serveScanned serves data if it passes the media scanner, or the user asked
+// to see it anyway, and renders a warning otherwise.
+func (i *imageServer) serveScanned(w http.ResponseWriter, r *http.Request, key string, ctype string, data []byte) {
+	if i.Scanner == nil {
+		serveCachedMedia(w, r, ctype, data)
+		return
+	}
+	result, err := i.scan(r, key, ctype, data)
+	if err != nil {
+		i.Warn("Could not scan media", "path", r.URL.Path, "err", err)
+		rest.ServerError(w, r, errors.New("Could not scan this attachment, try again later"))
+		return
+	}
+	if !result.Flagged {
+		serveCachedMedia(w, r, ctype, data)
+		return
+	}
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if r.URL.Query().Get("flagged") == "show" {
+		if !u.CanViewFlaggedMedia() {
+			rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+			return
+		}
+		i.Info("Showing flagged media", "user", u.ID(), "path", r.URL.Path, "reason", result.Reason)
+		serveCachedMedia(w, r, ctype, data)
+		return
+	}
+	i.Info("Blocked flagged media", "user", u.ID(), "path", r.URL.Path, "reason", result.Reason)
+	bd := &baseData{
+		Data: &flaggedMediaData{
+			Reason:  result.Reason,
+			CanView: u.CanViewFlaggedMedia(),
+			ShowURL: r.URL.Path + "?flagged=show",
+		},
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	if err := render(w, r, i.tpl, "base", bd); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (i *imageServer) scan(r *http.Request, key string, ctype string, data []byte) (*services.ScanResult, error) {
+	i.mu.Lock()
+	val, ok := i.scanned.Get(key)
+	i.mu.Unlock()
+	if ok {
+		return val.(*services.ScanResult), nil
+	}
+	ctx, cancel := getContext(r.Context(), 10*time.Second)
+	defer cancel()
+	result, err := i.Scanner.Scan(ctx, ctype, data)
+	if err != nil {
+		return nil, err
+	}
+	i.mu.Lock()
+	i.scanned.Add(key, result)
+	i.mu.Unlock()
+	return result, nil
+}
+
+type flaggedMediaData struct {
+	Reason string
+	// Whether the user can click through to the attachment.
+	CanView bool
+	ShowURL string
+}
+
+func (d *flaggedMediaData) Title() string {
+	return "Flagged Media"
+}
+
+func mediaCacheKey(u *url.URL) string {
+	return "media:" + u.String()
+}
+
+// How long browsers can reuse media without asking again. Media never changes
+// once it's been sent, but a user's access to it can be taken away, so don't
+// keep it forever.
+const mediaMaxAge = 24 * time.Hour
+
+// setMediaCacheHeaders lets the browser (but not shared caches, since media
+// URLs are per user) keep media for mediaMaxAge, and revalidate it with etag
+// after that. etag is skipped if it's empty.
+func setMediaCacheHeaders(w http.ResponseWriter, etag string) {
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(mediaMaxAge/time.Second)))
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+}
+
+// setMediaTypeHeaders sets the Content-Type of an attachment. Images, audio
+// and video are shown inline; anything else, like the PDFs and documents
+// people send over WhatsApp, is downloaded, so it can't run as a page on
+// this site.
+func setMediaTypeHeaders(w http.ResponseWriter, ctype string) {
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	mtype := strings.ToLower(strings.TrimSpace(strings.Split(ctype, ";")[0]))
+	if !strings.HasPrefix(mtype, "image/") && !strings.HasPrefix(mtype, "audio/") && !strings.HasPrefix(mtype, "video/") {
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+}
+
+// contentETag returns a strong ETag for data.
+func contentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// serveCachedMedia writes data to w, handling Range requests so browsers can
+// seek in cached recordings, and If-None-Match requests so they don't have
+// to download it again.
+func serveCachedMedia(w http.ResponseWriter, r *http.Request, ctype string, data []byte) {
+	setMediaTypeHeaders(w, ctype)
+	setMediaCacheHeaders(w, contentETag(data))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
@@ -1,11 +1,19 @@
 package server
 
 import (
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
 	"github.com/saintpete/logrole/services"
+	"golang.org/x/net/context"
 )
 
 const imagepath = "/media.twiliocdn.com/AC58f1e8f2b1c6b88ca90a012a4be0c279/10a8a62e659081b0ac370192c3b9fb6b"
@@ -22,11 +30,12 @@ func TestGetImages(t *testing.T) {
 		w.Write([]byte("hello world"))
 	}))
 	key := services.NewRandomKey()
-	u := services.Opaque(s.URL+imagepath, key)
+	u := services.OpaqueFor(s.URL+imagepath, config.DefaultUser.ID(), key)
 	i := &imageServer{
 		secretKey: key,
 	}
 	req, _ := http.NewRequest("GET", "/images/"+u, nil)
+	req = config.SetUser(req, config.DefaultUser)
 	w := httptest.NewRecorder()
 	i.ServeHTTP(w, req)
 	if w.Code != 200 {
@@ -39,3 +48,271 @@ func TestGetImages(t *testing.T) {
 		t.Errorf("expected Content-Type to be %s, got %s", ctype, w.Header().Get("Content-Type"))
 	}
 }
+
+func TestGetImagesCached(t *testing.T) {
+	t.Parallel()
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png data"))
+	}))
+	defer s.Close()
+	dir, err := ioutil.TempDir("", "logrole-media-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blobs, err := cache.NewBlobStore(NullLogger, dir, 1024*1024, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := services.NewRandomKey()
+	path := "/images/" + services.OpaqueFor(s.URL+imagepath, config.DefaultUser.ID(), key)
+	i := &imageServer{Blobs: blobs, secretKey: key}
+	for j := 0; j < 2; j++ {
+		req, _ := http.NewRequest("GET", path, nil)
+		req = config.SetUser(req, config.DefaultUser)
+		w := httptest.NewRecorder()
+		i.ServeHTTP(w, req)
+		if w.Code != 200 || w.Body.String() != "png data" {
+			t.Errorf("expected 200 with png data, got %d %q", w.Code, w.Body.String())
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected 1 request to Twilio, got %d", requests)
+	}
+
+	m := &mediaCacheServer{Logger: NullLogger, Blobs: blobs, secretKey: key}
+	req, _ := http.NewRequest("POST", "/media-cache/purge", strings.NewReader("path="+url.QueryEscape(path)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = config.SetUser(req, config.DefaultUser)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, req)
+	if w.Code != 204 {
+		t.Errorf("expected Code to be 204, got %d", w.Code)
+	}
+	req, _ = http.NewRequest("GET", path, nil)
+	req = config.SetUser(req, config.DefaultUser)
+	i.ServeHTTP(httptest.NewRecorder(), req)
+	if requests != 2 {
+		t.Errorf("expected purged image to be fetched again, got %d requests", requests)
+	}
+}
+
+func TestMediaCachePurgeForbidden(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-media-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blobs, err := cache.NewBlobStore(NullLogger, dir, 1024*1024, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mediaCacheServer{Logger: NullLogger, Blobs: blobs, secretKey: key}
+	u := config.NewUser(&config.UserSettings{CanViewMessages: true, CanViewMedia: true})
+	req, _ := http.NewRequest("POST", "/media-cache/purge", strings.NewReader("all=true"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = config.SetUser(req, u)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected users without can_profile to get a 403, got %d", w.Code)
+	}
+}
+
+type countingScanner struct {
+	result *services.ScanResult
+	scans  int
+}
+
+func (c *countingScanner) Scan(ctx context.Context, contentType string, data []byte) (*services.ScanResult, error) {
+	c.scans++
+	return c.result, nil
+}
+
+func TestFlaggedImages(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png data"))
+	}))
+	defer s.Close()
+	key := services.NewRandomKey()
+	path := "/images/" + services.OpaqueFor(s.URL+imagepath, config.DefaultUser.ID(), key)
+	scanner := &countingScanner{result: &services.ScanResult{Flagged: true, Reason: "Adult content"}}
+	i, err := newImageServer(NullLogger, nil, scanner, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := config.NewUser(config.AllUserSettings())
+	req, _ := http.NewRequest("GET", path, nil)
+	req = config.SetUser(req, admin)
+	w := httptest.NewRecorder()
+	i.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "Adult content") || !strings.Contains(body, "?flagged=show") {
+		t.Errorf("expected warning with the reason and a link to show the image, got %s", body)
+	}
+
+	req, _ = http.NewRequest("GET", path+"?flagged=show", nil)
+	req = config.SetUser(req, admin)
+	w = httptest.NewRecorder()
+	i.ServeHTTP(w, req)
+	if w.Code != 200 || w.Body.String() != "png data" {
+		t.Errorf("expected 200 with png data, got %d %q", w.Code, w.Body.String())
+	}
+	if scanner.scans != 1 {
+		t.Errorf("expected image to be scanned once, got %d", scanner.scans)
+	}
+
+	u := config.NewUser(&config.UserSettings{CanViewMessages: true, CanViewMedia: true})
+	req, _ = http.NewRequest("GET", path+"?flagged=show", nil)
+	req = config.SetUser(req, u)
+	w = httptest.NewRecorder()
+	i.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403 without can_view_flagged_media, got %d", w.Code)
+	}
+}
+
+func TestImageURLsOnlyWorkForTheirUser(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png data"))
+	}))
+	defer s.Close()
+	p := &config.Policy{
+		&config.Group{Name: "support", Users: []string{"support"}, Permissions: config.AllUserSettings()},
+		&config.Group{Name: "finance", Users: []string{"finance"}, Permissions: &config.UserSettings{CanViewMessages: true}},
+	}
+	support, _, _ := p.Lookup("support")
+	finance, _, _ := p.Lookup("finance")
+	key := services.NewRandomKey()
+	i := &imageServer{secretKey: key}
+	path := "/images/" + services.OpaqueFor(s.URL+imagepath, "support", key)
+
+	req, _ := http.NewRequest("GET", path, nil)
+	req = config.SetUser(req, support)
+	w := httptest.NewRecorder()
+	i.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Errorf("expected Code to be 200, got %d", w.Code)
+	}
+
+	// finance can't view media, and the URL wasn't made for them.
+	req, _ = http.NewRequest("GET", path, nil)
+	req = config.SetUser(req, finance)
+	w = httptest.NewRecorder()
+	i.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+
+	other, _, _ := (&config.Policy{&config.Group{Name: "support", Users: []string{"other"}, Permissions: config.AllUserSettings()}}).Lookup("other")
+	req, _ = http.NewRequest("GET", path, nil)
+	req = config.SetUser(req, other)
+	w = httptest.NewRecorder()
+	i.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected a URL made for another user to get a 403, got %d", w.Code)
+	}
+}
+
+func TestImageCacheHeaders(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"twilio-etag"`)
+		if r.Header.Get("If-None-Match") == `"twilio-etag"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png data"))
+	}))
+	defer s.Close()
+	key := services.NewRandomKey()
+	path := "/images/" + services.StableOpaqueFor(s.URL+imagepath, config.DefaultUser.ID(), key)
+
+	// Without a media cache, Twilio's ETag is passed through.
+	i := &imageServer{secretKey: key}
+	req, _ := http.NewRequest("GET", path, nil)
+	req = config.SetUser(req, config.DefaultUser)
+	w := httptest.NewRecorder()
+	i.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Errorf("expected Code to be 200, got %d", w.Code)
+	}
+	if etag := w.Header().Get("ETag"); etag != `"twilio-etag"` {
+		t.Errorf("expected Twilio's ETag, got %q", etag)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "private, max-age=86400" {
+		t.Errorf("expected a private Cache-Control header, got %q", cc)
+	}
+	req, _ = http.NewRequest("GET", path, nil)
+	req.Header.Set("If-None-Match", `"twilio-etag"`)
+	req = config.SetUser(req, config.DefaultUser)
+	w = httptest.NewRecorder()
+	i.ServeHTTP(w, req)
+	if w.Code != 304 || w.Body.Len() != 0 {
+		t.Errorf("expected an empty 304, got %d %q", w.Code, w.Body.String())
+	}
+
+	// With a scanner, the ETag comes from the content.
+	i, err := newImageServer(NullLogger, nil, &countingScanner{result: &services.ScanResult{}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest("GET", path, nil)
+	req = config.SetUser(req, config.DefaultUser)
+	w = httptest.NewRecorder()
+	i.ServeHTTP(w, req)
+	etag := w.Header().Get("ETag")
+	if w.Code != 200 || etag != contentETag([]byte("png data")) {
+		t.Fatalf("expected 200 with a content ETag, got %d %q", w.Code, etag)
+	}
+	req, _ = http.NewRequest("GET", path, nil)
+	req.Header.Set("If-None-Match", etag)
+	req = config.SetUser(req, config.DefaultUser)
+	w = httptest.NewRecorder()
+	i.ServeHTTP(w, req)
+	if w.Code != 304 {
+		t.Errorf("expected Code to be 304, got %d", w.Code)
+	}
+}
+
+var mediaTypeTests = []struct {
+	ctype      string
+	attachment bool
+}{
+	{"image/jpeg", false},
+	{"Image/PNG", false},
+	{"audio/mpeg", false},
+	{"video/mp4; codecs=avc1", false},
+	{"application/pdf", true},
+	{"text/html; charset=utf-8", true},
+	{"", true},
+}
+
+func TestSetMediaTypeHeaders(t *testing.T) {
+	t.Parallel()
+	for _, tt := range mediaTypeTests {
+		w := httptest.NewRecorder()
+		setMediaTypeHeaders(w, tt.ctype)
+		if ct := w.Header().Get("Content-Type"); ct != tt.ctype {
+			t.Errorf("setMediaTypeHeaders(%q): expected Content-Type %q, got %q", tt.ctype, tt.ctype, ct)
+		}
+		if nosniff := w.Header().Get("X-Content-Type-Options"); nosniff != "nosniff" {
+			t.Errorf("setMediaTypeHeaders(%q): expected nosniff, got %q", tt.ctype, nosniff)
+		}
+		attachment := w.Header().Get("Content-Disposition") == "attachment"
+		if attachment != tt.attachment {
+			t.Errorf("setMediaTypeHeaders(%q): expected attachment to be %t, got %t", tt.ctype, tt.attachment, attachment)
+		}
+	}
+}
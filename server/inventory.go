@@ -0,0 +1,294 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+// Give up on a snapshot after this many pages of numbers, rather than record
+// a partial one, which would look like every missing number was released.
+const maxInventoryPages = 50
+const inventoryPageSize = 1000
+const inventoryCheckTimeout = 5 * time.Minute
+
+// inventoryUser is used to list the account's numbers in the background.
+// Numbers on the blocklist are hidden from it, like from everyone else.
+var inventoryUser = config.NewUser(&config.UserSettings{})
+
+// numberInventoryMonitor periodically snapshots every phone number in the
+// account, and sends a notification when numbers were purchased or released
+// since the last snapshot. An unexpected purchase or release may mean someone
+// else has the account's credentials.
+type numberInventoryMonitor struct {
+	log.Logger
+	Client    views.Client
+	Store     *services.InventoryStore
+	Notifier  services.Notifier
+	Blocklist *config.Blocklist
+	Interval  time.Duration
+	// Link to the number changes page, included in notifications.
+	URL string
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newNumberInventoryMonitor(l log.Logger, vc views.Client, store *services.InventoryStore, n services.Notifier, interval time.Duration, url string) *numberInventoryMonitor {
+	return &numberInventoryMonitor{
+		Logger:   l,
+		Client:   vc,
+		Store:    store,
+		Notifier: n,
+		Interval: interval,
+		URL:      url,
+		done:     make(chan struct{}),
+	}
+}
+
+// snapshot lists every number in the account.
+func (m *numberInventoryMonitor) snapshot(ctx context.Context, now time.Time) (*services.InventorySnapshot, error) {
+	data := url.Values{}
+	data.Set("PageSize", strconv.Itoa(inventoryPageSize))
+	numbers := make([]*services.InventoryNumber, 0)
+	page, _, err := m.Client.GetNumberPage(ctx, inventoryUser, data)
+	for pages := 1; ; pages++ {
+		if err == twilio.NoMoreResults {
+			return services.NewInventorySnapshot(now, numbers), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, number := range page.Numbers() {
+			sid, err := number.Sid()
+			if err != nil {
+				continue
+			}
+			pn, err := number.PhoneNumber()
+			if err != nil {
+				continue
+			}
+			in := &services.InventoryNumber{Sid: sid, PhoneNumber: pn}
+			in.FriendlyName, _ = number.FriendlyName()
+			if created, err := number.DateCreated(); err == nil && created.Valid {
+				in.DateCreated = created.Time
+			}
+			numbers = append(numbers, in)
+		}
+		next := page.NextPageURI()
+		if !next.Valid {
+			return services.NewInventorySnapshot(now, numbers), nil
+		}
+		if pages >= maxInventoryPages {
+			return nil, fmt.Errorf("Account has more than %d phone numbers, not taking a snapshot", maxInventoryPages*inventoryPageSize)
+		}
+		page, _, err = m.Client.GetNextNumberPage(ctx, inventoryUser, next.String)
+	}
+}
+
+// ignoreVisibilityChanges removes numbers from c that were only hidden from
+// or shown to the monitor, not purchased or released: numbers that were
+// purchased before the previous snapshot, for example because they were just
+// removed from the blocklist, and numbers that are on the blocklist now.
+func (m *numberInventoryMonitor) ignoreVisibilityChanges(c *services.InventoryChange) {
+	purchased := make([]*services.InventoryNumber, 0, len(c.Purchased))
+	for _, n := range c.Purchased {
+		if n.DateCreated.IsZero() || !n.DateCreated.Before(c.Since) {
+			purchased = append(purchased, n)
+		}
+	}
+	c.Purchased = purchased
+	released := make([]*services.InventoryNumber, 0, len(c.Released))
+	for _, n := range c.Released {
+		// Nobody is exempt from the blocklist without a user.
+		if !m.Blocklist.Hides(nil, string(n.PhoneNumber)) {
+			released = append(released, n)
+		}
+	}
+	c.Released = released
+}
+
+// check takes a snapshot and records the numbers purchased and released
+// since the last one, sending a notification if there were any. The first
+// snapshot is only recorded.
+func (m *numberInventoryMonitor) check(ctx context.Context) error {
+	now := time.Now().UTC()
+	snap, err := m.snapshot(ctx, now)
+	m.mu.Lock()
+	m.checkedAt = now
+	m.err = err
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	prev := m.Store.Latest()
+	if prev == nil {
+		return m.Store.Record(snap, nil)
+	}
+	change := services.DiffInventory(prev, snap)
+	m.ignoreVisibilityChanges(change)
+	if err := m.Store.Record(snap, change); err != nil {
+		return err
+	}
+	if change.Empty() {
+		return nil
+	}
+	subject := fmt.Sprintf("Phone numbers changed: %d purchased, %d released", len(change.Purchased), len(change.Released))
+	body := new(bytes.Buffer)
+	for _, n := range change.Purchased {
+		fmt.Fprintf(body, "Purchased %s %s\n", n.PhoneNumber.Friendly(), n.FriendlyName)
+	}
+	for _, n := range change.Released {
+		fmt.Fprintf(body, "Released %s %s\n", n.PhoneNumber.Friendly(), n.FriendlyName)
+	}
+	if m.URL != "" {
+		fmt.Fprintf(body, "\n%s", m.URL)
+	}
+	if err := m.Notifier.Notify(ctx, subject, body.String()); err != nil {
+		m.Warn("Couldn't send phone number change notification", "err", err)
+	}
+	return nil
+}
+
+// Run takes a snapshot every Interval until Stop is called.
+func (m *numberInventoryMonitor) Run() {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), inventoryCheckTimeout)
+		if err := m.check(ctx); err != nil {
+			m.Warn("Error taking a phone number snapshot", "err", err)
+		}
+		cancel()
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *numberInventoryMonitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.done)
+	})
+}
+
+// LastCheck returns when the monitor last tried to take a snapshot, and any
+// error it encountered.
+func (m *numberInventoryMonitor) LastCheck() (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.checkedAt, m.err
+}
+
+type numberChangesServer struct {
+	log.Logger
+	LocationFinder services.LocationFinder
+	Blocklist      *config.Blocklist
+	// nil if the monitor is disabled.
+	Monitor *numberInventoryMonitor
+	tpl     *template.Template
+}
+
+func newNumberChangesServer(l log.Logger, lf services.LocationFinder, b *config.Blocklist, m *numberInventoryMonitor) (*numberChangesServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+numberChangesTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &numberChangesServer{
+		Logger:         l,
+		LocationFinder: lf,
+		Blocklist:      b,
+		Monitor:        m,
+		tpl:            tpl,
+	}, nil
+}
+
+type numberChangesData struct {
+	Enabled  bool
+	Interval time.Duration
+	// The numbers in the latest snapshot that the user can see.
+	Count      int
+	SnapshotAt time.Time
+	CheckedAt  time.Time
+	Changes    []*services.InventoryChange
+	Loc        *time.Location
+	Err        string
+}
+
+func (d *numberChangesData) Title() string {
+	return "Phone Number Changes"
+}
+
+func (d *numberChangesData) Path() string {
+	return "/phone-numbers"
+}
+
+// visibleNumbers returns the numbers u is allowed to see.
+func (s *numberChangesServer) visibleNumbers(u *config.User, numbers []*services.InventoryNumber) []*services.InventoryNumber {
+	visible := make([]*services.InventoryNumber, 0, len(numbers))
+	for _, n := range numbers {
+		if u.CanViewNumbers(string(n.PhoneNumber)) && !s.Blocklist.Hides(u, string(n.PhoneNumber)) {
+			visible = append(visible, n)
+		}
+	}
+	return visible
+}
+
+func (s *numberChangesServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	data := &numberChangesData{
+		Loc: s.LocationFinder.GetLocationReq(r),
+	}
+	if s.Monitor != nil {
+		data.Enabled = true
+		data.Interval = s.Monitor.Interval
+		checkedAt, err := s.Monitor.LastCheck()
+		data.CheckedAt = checkedAt
+		if err != nil {
+			data.Err = cleanError(err)
+		}
+		if latest := s.Monitor.Store.Latest(); latest != nil {
+			data.SnapshotAt = latest.Time
+			data.Count = len(s.visibleNumbers(u, latest.Numbers))
+		}
+		for _, c := range s.Monitor.Store.Changes() {
+			visible := &services.InventoryChange{
+				Since:     c.Since,
+				Time:      c.Time,
+				Purchased: s.visibleNumbers(u, c.Purchased),
+				Released:  s.visibleNumbers(u, c.Released),
+			}
+			if !visible.Empty() {
+				data.Changes = append(data.Changes, visible)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
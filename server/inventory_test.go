@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/test/harness"
+	"golang.org/x/net/context"
+)
+
+func numberJSON(sid, pn string, created time.Time) string {
+	return fmt.Sprintf(`{"sid": %q, "phone_number": %q, "friendly_name": %q, "date_created": %q}`,
+		sid, pn, pn, created.Format(time.RFC1123Z))
+}
+
+func TestNumberInventoryChanges(t *testing.T) {
+	t.Parallel()
+	now := time.Now().UTC()
+	pn1 := "PN00000000000000000000000000000001"
+	pn2 := "PN00000000000000000000000000000002"
+	store, err := services.NewInventoryStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := services.NewInventorySnapshot(now.Add(-24*time.Hour), []*services.InventoryNumber{
+		{Sid: pn1, PhoneNumber: "+14105550001", DateCreated: now.Add(-100 * 24 * time.Hour)},
+		{Sid: pn2, PhoneNumber: "+14105550002", DateCreated: now.Add(-100 * 24 * time.Hour)},
+	})
+	if err := store.Record(prev, nil); err != nil {
+		t.Fatal(err)
+	}
+	body := fmt.Sprintf(`{"incoming_phone_numbers": [%s, %s, %s]}`,
+		numberJSON(pn1, "+14105550001", now.Add(-100*24*time.Hour)),
+		numberJSON("PN00000000000000000000000000000003", "+14105550003", now.Add(-time.Hour)),
+		// Bought before the last snapshot, so it was only hidden from it.
+		numberJSON("PN00000000000000000000000000000004", "+14105550004", now.Add(-30*24*time.Hour)))
+	server := newServerWithResponse(200, []byte(body))
+	defer server.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	n := new(testNotifier)
+	m := newNumberInventoryMonitor(dlog, vc, store, n, time.Hour, "")
+	if err := m.check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	changes := store.Changes()
+	if len(changes) != 1 {
+		t.Fatalf("expected one change, got %d", len(changes))
+	}
+	if c := changes[0]; len(c.Purchased) != 1 || c.Purchased[0].PhoneNumber != "+14105550003" {
+		t.Errorf("expected only +14105550003 to be purchased, got %v", c.Purchased)
+	}
+	if c := changes[0]; len(c.Released) != 1 || c.Released[0].Sid != pn2 {
+		t.Errorf("expected only %s to be released, got %v", pn2, c.Released)
+	}
+	if len(n.subjects) != 1 || !strings.Contains(n.subjects[0], "1 purchased, 1 released") {
+		t.Errorf("expected one notification about the change, got %v", n.subjects)
+	}
+	if latest := store.Latest(); latest == nil || len(latest.Numbers) != 3 {
+		t.Errorf("expected the new snapshot to have 3 numbers, got %v", latest)
+	}
+
+	s, err := newNumberChangesServer(dlog, lf, nil, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/phone-numbers/changes", nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "/phone-numbers/+14105550003") {
+		t.Errorf("expected purchased number on the page, got %s", w.Body.String())
+	}
+}
+
+func TestNumberInventoryFirstSnapshot(t *testing.T) {
+	t.Parallel()
+	store, _ := services.NewInventoryStore("")
+	body := fmt.Sprintf(`{"incoming_phone_numbers": [%s]}`,
+		numberJSON("PN00000000000000000000000000000001", "+14105550001", time.Now().Add(-time.Hour)))
+	server := newServerWithResponse(200, []byte(body))
+	defer server.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	n := new(testNotifier)
+	m := newNumberInventoryMonitor(dlog, vc, store, n, time.Hour, "")
+	if err := m.check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if store.Latest() == nil {
+		t.Fatal("expected the first snapshot to be recorded")
+	}
+	if len(store.Changes()) != 0 || len(n.subjects) != 0 {
+		t.Errorf("expected the first snapshot to be a baseline, got %d changes and %d notifications", len(store.Changes()), len(n.subjects))
+	}
+}
+
+func TestNumberChangesDisabled(t *testing.T) {
+	t.Parallel()
+	s, err := newNumberChangesServer(dlog, lf, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/phone-numbers/changes", nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "number_inventory") {
+		t.Errorf("expected instructions to turn on snapshots, got %s", w.Body.String())
+	}
+}
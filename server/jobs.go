@@ -0,0 +1,264 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/jobs"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+)
+
+// Settings for the background export queue.
+const (
+	exportWorkers = 2
+	// Leave room for the rest of the site under Twilio's concurrency limits.
+	exportInterval = 250 * time.Millisecond
+	exportTTL      = 24 * time.Hour
+)
+
+var jobDownloadRoute = regexp.MustCompile("^/jobs/(?P<id>[a-f0-9]{32})/download$")
+
+type jobListServer struct {
+	log.Logger
+	Client         views.Client
+	LocationFinder services.LocationFinder
+	Jobs           *jobs.Queue
+	MaxResourceAge time.Duration
+	tpl            *template.Template
+}
+
+func newJobListServer(l log.Logger, vc views.Client, lf services.LocationFinder, q *jobs.Queue, maxResourceAge time.Duration) (*jobListServer, error) {
+	s := &jobListServer{
+		Logger:         l,
+		Client:         vc,
+		LocationFinder: lf,
+		Jobs:           q,
+		MaxResourceAge: maxResourceAge,
+	}
+	tpl, err := newTpl(template.FuncMap{}, base+jobListTpl)
+	if err != nil {
+		return nil, err
+	}
+	s.tpl = tpl
+	return s, nil
+}
+
+type jobListData struct {
+	Jobs []jobs.Job
+	Loc  *time.Location
+	Err  string
+}
+
+func (j *jobListData) Title() string {
+	return "Exports"
+}
+
+func (j *jobListData) Path() string {
+	return "/jobs"
+}
+
+// Running returns true if any of the jobs are still in progress.
+func (j *jobListData) Running() bool {
+	for _, job := range j.Jobs {
+		if !job.Status.Finished() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *jobListServer) renderError(w http.ResponseWriter, r *http.Request, code int, query url.Values, err error) {
+	u, _ := config.GetUser(r)
+	var list []jobs.Job
+	if u != nil {
+		list = s.Jobs.List(u.ID())
+	}
+	data := &baseData{LF: s.LocationFinder,
+		Data: &jobListData{
+			Err:  cleanError(err),
+			Loc:  s.LocationFinder.GetLocationReq(r),
+			Jobs: list,
+		}}
+	if code >= 500 {
+		s.Error("Error responding to request", "status", code, "url", r.URL.String(), "err", err)
+	} else {
+		s.Warn("Error responding to request", "status", code, "url", r.URL.String(), "err", err)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", data); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (s *jobListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if r.Method == "POST" {
+		s.create(w, r, u)
+		return
+	}
+	data := &baseData{
+		LF: s.LocationFinder,
+		Data: &jobListData{
+			Jobs: s.Jobs.List(u.ID()),
+			Loc:  s.LocationFinder.GetLocationReq(r),
+		},
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := render(w, r, s.tpl, "base", data); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (s *jobListServer) validParams() []string {
+	return []string{"resource", "from", "to", "start", "end", "start-after",
+		"start-before", "log-level", "resource-sid", "alert-start", "alert-end",
+		"format", "include-bodies"}
+}
+
+// describe returns a short description of an export, for the job list.
+func describe(resource string, query url.Values) string {
+	desc := strings.Title(resource)
+	filters := make([]string, 0)
+	for _, param := range []string{"from", "to", "start", "start-after", "end",
+		"start-before", "log-level", "resource-sid", "alert-start", "alert-end"} {
+		if val := query.Get(param); val != "" {
+			filters = append(filters, param+" "+val)
+		}
+	}
+	if len(filters) > 0 {
+		desc = desc + " (" + strings.Join(filters, ", ") + ")"
+	}
+	if query.Get("format") == exportNDJSON {
+		desc = desc + ", NDJSON"
+	}
+	return desc
+}
+
+// create starts a new export with the filters in the POST body, and redirects
+// to the job list.
+func (s *jobListServer) create(w http.ResponseWriter, r *http.Request, u *config.User) {
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, nil, err)
+		return
+	}
+	query := r.PostForm
+	if err := validateParams(s.validParams(), query); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, err)
+		return
+	}
+	loc := s.LocationFinder.GetLocationReq(r)
+	data := url.Values{}
+	data.Set("PageSize", strconv.Itoa(exportPageSize))
+	if err := setPageFilters(query, data); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, err)
+		return
+	}
+	format := query.Get("format")
+	if format != "" && format != exportCSV && format != exportNDJSON {
+		s.renderError(w, r, http.StatusBadRequest, query, errors.New("Unknown export format: "+format))
+		return
+	}
+	resource := query.Get("resource")
+	maxAge := u.MaxResourceAge(s.MaxResourceAge)
+	var task *exportTask
+	switch resource {
+	case "messages":
+		if !u.CanViewMessages() {
+			rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+			return
+		}
+		start, end, wroteError := getTimes(w, r, "start", "end", loc, maxAge, query, s)
+		if wroteError {
+			return
+		}
+		task = newMessageExport(s.Client, u, start, end, data)
+	case "calls":
+		if !u.CanViewCalls() {
+			rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+			return
+		}
+		start, end, wroteError := getTimes(w, r, "start-after", "start-before", loc, maxAge, query, s)
+		if wroteError {
+			return
+		}
+		task = newCallExport(s.Client, u, start, end, data)
+	case "alerts":
+		if !u.CanViewAlerts() {
+			rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+			return
+		}
+		start, end, wroteError := getTimes(w, r, "alert-start", "alert-end", loc, maxAge, query, s)
+		if wroteError {
+			return
+		}
+		includeBodies := query.Get("include-bodies") == "true"
+		if includeBodies && !u.CanViewAlertPayloads() {
+			rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to export request bodies"})
+			return
+		}
+		task = newAlertExport(s.Client, u, start, end, data, includeBodies)
+	default:
+		s.renderError(w, r, http.StatusBadRequest, query, errors.New("Unknown resource to export: "+resource))
+		return
+	}
+	task.Format = format
+	job, err := s.Jobs.Submit(u.ID(), describe(resource, query), task)
+	switch {
+	case err == jobs.ErrTooManyJobs:
+		s.renderError(w, r, http.StatusTooManyRequests, query, err)
+		return
+	case err != nil:
+		// Couldn't write the export's checkpoint to disk.
+		s.renderError(w, r, http.StatusInternalServerError, query, err)
+		return
+	}
+	s.Info("Started export", "id", job.ID, "user", u.ID(), "description", job.Description)
+	http.Redirect(w, r, "/jobs", http.StatusFound)
+}
+
+type jobDownloadServer struct {
+	log.Logger
+	Jobs *jobs.Queue
+}
+
+func (s *jobDownloadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	id := jobDownloadRoute.FindStringSubmatch(r.URL.Path)[1]
+	artifact, err := s.Jobs.Artifact(u.ID(), id)
+	switch err {
+	case nil:
+		break
+	case jobs.ErrNotFound:
+		rest.NotFound(w, r)
+		return
+	case jobs.ErrNotFinished:
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error(), ID: "job_not_finished"})
+		return
+	default:
+		rest.ServerError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", artifact.ContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+artifact.Filename+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(artifact.Data)))
+	w.Write(artifact.Data)
+}
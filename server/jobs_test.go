@@ -0,0 +1,362 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	types "github.com/kevinburke/go-types"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/jobs"
+	"github.com/saintpete/logrole/test"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+func newExportRequest(u *config.User, data url.Values) *http.Request {
+	req, _ := http.NewRequest("POST", "/jobs", strings.NewReader(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return config.SetUser(req, u)
+}
+
+func TestCallExport(t *testing.T) {
+	t.Parallel()
+	server := newServerWithResponse(200, test.CallListBody)
+	defer server.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	q := jobs.NewQueue(dlog, 1, 0, time.Hour)
+	s, err := newJobListServer(dlog, vc, lf, q, config.DefaultMaxResourceAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, newExportRequest(theUser, url.Values{"resource": []string{"calls"}}))
+	if w.Code != 302 {
+		t.Fatalf("expected Code to be 302, got %d: %s", w.Code, w.Body.String())
+	}
+	list := q.List(theUser.ID())
+	if len(list) != 1 {
+		t.Fatalf("expected one job, got %d", len(list))
+	}
+	id := list[0].ID
+	timeout := time.After(5 * time.Second)
+	for {
+		j, err := q.Get(theUser.ID(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Status == jobs.StatusComplete {
+			break
+		}
+		if j.Status == jobs.StatusFailed {
+			t.Fatalf("export failed: %s", j.Err)
+		}
+		select {
+		case <-timeout:
+			t.Fatal("export did not finish")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	ds := &jobDownloadServer{Logger: dlog, Jobs: q}
+	req, _ := http.NewRequest("GET", "/jobs/"+id+"/download", nil)
+	req = config.SetUser(req, theUser)
+	w = httptest.NewRecorder()
+	ds.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("expected CSV content type, got %s", ct)
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	// theUser can't view call prices
+	if lines[0] != "Sid,DateCreated,StartTime,Direction,Status,From,To,Duration" {
+		t.Errorf("unexpected CSV header %q", lines[0])
+	}
+	if len(lines) < 2 {
+		t.Errorf("expected CSV to contain calls, got %q", w.Body.String())
+	}
+
+	policy := &config.Policy{&config.Group{Name: "other", Users: []string{"other@example.com"}}}
+	other, _, err := policy.Lookup("other@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest("GET", "/jobs/"+id+"/download", nil)
+	req = config.SetUser(req, other)
+	w = httptest.NewRecorder()
+	ds.ServeHTTP(w, req)
+	if w.Code != 404 {
+		t.Errorf("expected another user's download to 404, got %d", w.Code)
+	}
+}
+
+func TestExportForbidden(t *testing.T) {
+	t.Parallel()
+	vc := harness.ViewsClient(harness.ViewHarness{SecretKey: key})
+	q := jobs.NewQueue(dlog, 1, 0, time.Hour)
+	s, err := newJobListServer(dlog, vc, lf, q, config.DefaultMaxResourceAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := config.NewUser(&config.UserSettings{CanViewCalls: true})
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, newExportRequest(u, url.Values{"resource": []string{"messages"}}))
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, newExportRequest(u, url.Values{"resource": []string{"conferences"}}))
+	if w.Code != 400 {
+		t.Errorf("expected Code to be 400, got %d", w.Code)
+	}
+}
+
+var alertListBody = []byte(`{
+  "alerts": [
+    {"sid": "NO123", "account_sid": "AC123", "error_code": 11200, "log_level": "error",
+     "date_created": "2016-10-18T17:00:00Z", "more_info": "https://www.twilio.com/docs/errors/11200",
+     "request_method": "POST", "request_url": "https://example.com/sms",
+     "request_variables": "Body=hello&From=%2B14105551234", "response_body": "Not Found",
+     "resource_sid": "SM123"}
+  ],
+  "meta": {"next_page_url": null}
+}`)
+
+// waitForJob waits for the job to finish, and fails the test if it didn't
+// complete.
+func waitForJob(t *testing.T, q *jobs.Queue, user, id string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		j, err := q.Get(user, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Status == jobs.StatusComplete {
+			return
+		}
+		if j.Status == jobs.StatusFailed {
+			t.Fatalf("export failed: %s", j.Err)
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("export did not finish: %s %s", j.Status, j.Err)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func TestAlertExport(t *testing.T) {
+	t.Parallel()
+	server := newServerWithResponse(200, alertListBody)
+	defer server.Close()
+	c := twilio.NewClient("AC123", "123", nil)
+	c.Monitor.Base = server.URL
+	vc := harness.ViewsClient(harness.ViewHarness{TwilioClient: c, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	q := jobs.NewQueue(dlog, 1, 0, time.Hour)
+	s, err := newJobListServer(dlog, vc, lf, q, config.DefaultMaxResourceAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := config.NewUser(config.AllUserSettings())
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, newExportRequest(admin, url.Values{
+		"resource":       []string{"alerts"},
+		"format":         []string{"ndjson"},
+		"include-bodies": []string{"true"},
+		"log-level":      []string{"error"},
+	}))
+	if w.Code != 302 {
+		t.Fatalf("expected Code to be 302, got %d: %s", w.Code, w.Body.String())
+	}
+	list := q.List(admin.ID())
+	if len(list) != 1 {
+		t.Fatalf("expected one job, got %d", len(list))
+	}
+	if list[0].Description != "Alerts (log-level error), NDJSON" {
+		t.Errorf("unexpected description %q", list[0].Description)
+	}
+	waitForJob(t, q, admin.ID(), list[0].ID)
+	artifact, err := q.Artifact(admin.ID(), list[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if artifact.ContentType != "application/x-ndjson" || !strings.HasSuffix(artifact.Filename, ".ndjson") {
+		t.Errorf("expected an NDJSON file, got %s (%s)", artifact.Filename, artifact.ContentType)
+	}
+	var row map[string]string
+	if err := json.Unmarshal(bytes.TrimSpace(artifact.Data), &row); err != nil {
+		t.Fatalf("expected one JSON object, got %q: %v", artifact.Data, err)
+	}
+	if row["Sid"] != "NO123" || row["ErrorCode"] != "11200" || row["LogLevel"] != "error" ||
+		row["RequestURL"] != "https://example.com/sms" || row["ResponseBody"] != "Not Found" {
+		t.Errorf("unexpected alert row %v", row)
+	}
+	if vals, _ := url.ParseQuery(row["RequestVariables"]); vals.Get("From") != "+14105551234" {
+		t.Errorf("expected request variables in the export, got %q", row["RequestVariables"])
+	}
+
+	us := config.AllUserSettings()
+	us.CanViewAlertPayloads = false
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, newExportRequest(config.NewUser(us), url.Values{
+		"resource":       []string{"alerts"},
+		"include-bodies": []string{"true"},
+	}))
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403 for bodies without permission, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, newExportRequest(admin, url.Values{
+		"resource": []string{"alerts"},
+		"format":   []string{"xml"},
+	}))
+	if w.Code != 400 {
+		t.Errorf("expected Code to be 400 for an unknown format, got %d", w.Code)
+	}
+}
+
+func TestResumeExport(t *testing.T) {
+	t.Parallel()
+	server := newServerWithResponse(200, test.CallListBody)
+	defer server.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	f, err := ioutil.TempFile("", "logrole-export-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	e := newCallExport(vc, theUser, twilio.Epoch, twilio.HeatDeath, url.Values{"From": []string{"+14105551234"}})
+	e.Open(f)
+	if _, _, err := e.Step(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	state, err := e.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := resumeExport(vc, theUser, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Spec.Resource != "calls" || resumed.Spec.Filters.Get("From") != "+14105551234" {
+		t.Errorf("expected the export's filters to be saved, got %#v", resumed.Spec)
+	}
+	if resumed.pages != 1 || len(resumed.columns) != len(e.columns) {
+		t.Errorf("expected the progress to be saved, got %d pages and columns %v", resumed.pages, resumed.columns)
+	}
+	resumed.Open(f)
+	want, _ := e.Artifact()
+	got, err := resumed.Artifact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Filename != want.Filename {
+		t.Errorf("expected a resumed export to have the same name, got %s and %s", got.Filename, want.Filename)
+	}
+	if !strings.HasPrefix(string(got.Data), "Sid,DateCreated,StartTime") {
+		t.Errorf("expected the file written before the restart, got %q", got.Data)
+	}
+}
+
+// windowExportPage is a page of resources with a sid and a time, exported as
+// one column.
+type windowExportPage struct {
+	sids  []string
+	times []time.Time
+	next  types.NullString
+}
+
+func (p *windowExportPage) ShowHeader(string) bool        { return true }
+func (p *windowExportPage) NextPageURI() types.NullString { return p.next }
+
+func (p *windowExportPage) Rows(columns []string, keep func(string, twilio.TwilioTime) bool) [][]string {
+	var rows [][]string
+	for i, sid := range p.sids {
+		if keep(sid, twilio.TwilioTime{Valid: true, Time: p.times[i]}) {
+			rows = append(rows, []string{sid})
+		}
+	}
+	return rows
+}
+
+func TestExportWindows(t *testing.T) {
+	t.Parallel()
+	end := time.Date(2016, 10, 18, 13, 0, 0, 0, time.UTC)
+	// Newest first, with resources on every boundary, and two that arrive
+	// after the export starts.
+	offsets := []time.Duration{
+		-15 * time.Minute, -30 * time.Minute, -31 * time.Minute, -time.Hour,
+		-time.Hour - time.Minute, -2 * time.Hour, -2*time.Hour - time.Minute,
+		-3 * time.Hour, -3*time.Hour - time.Minute,
+	}
+	e := &exportTask{
+		Name:    "test",
+		Columns: []string{"Sid"},
+		Spec:    exportSpec{Start: end.Add(-3 * time.Hour), End: end, Window: time.Hour, MaxWindows: 10},
+		Created: end.Add(-30 * time.Minute),
+		// Serve pages of two from everything between the window's start and
+		// end, including end, and start each page after the first with the
+		// last resource on the page before it, as if the list shifted.
+		Fetch: func(ctx context.Context, w views.Window, next string) (exportPage, error) {
+			page := &windowExportPage{}
+			for i, offset := range offsets {
+				created := end.Add(offset)
+				if !created.Before(w.Start) && !created.After(w.End) {
+					page.sids = append(page.sids, "SM"+strconv.Itoa(i))
+					page.times = append(page.times, created)
+				}
+			}
+			i := 0
+			if next != "" {
+				i, _ = strconv.Atoi(next)
+			}
+			first := 2 * i
+			if i > 0 {
+				first--
+			}
+			if first >= len(page.sids) {
+				return nil, twilio.NoMoreResults
+			}
+			last := first + 2
+			if last < len(page.sids) {
+				page.next = types.NullString{Valid: true, String: strconv.Itoa(i + 1)}
+			} else {
+				last = len(page.sids)
+			}
+			page.sids, page.times = page.sids[first:last], page.times[first:last]
+			return page, nil
+		},
+	}
+	for {
+		_, done, err := e.Step(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done {
+			break
+		}
+	}
+	a, err := e.Artifact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SM0 and SM1 arrived after the export started and SM8 is outside the
+	// range; every other resource is written once.
+	want := "Sid\nSM2\nSM3\nSM4\nSM5\nSM6\nSM7\n"
+	if string(a.Data) != want {
+		t.Errorf("expected %q, got %q", want, a.Data)
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// Reject label imports larger than this.
+const maxLabelImportBytes = 1024 * 1024
+
+// labelServer lists, searches, edits, imports and exports the names assigned
+// to phone numbers. Anyone can view labels; changing them requires the
+// can_manage_labels permission.
+type labelServer struct {
+	log.Logger
+	Labels         *services.LabelStore
+	LocationFinder services.LocationFinder
+	tpl            *template.Template
+}
+
+func newLabelServer(l log.Logger, labels *services.LabelStore, lf services.LocationFinder) (*labelServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+labelListTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &labelServer{
+		Logger:         l,
+		Labels:         labels,
+		LocationFinder: lf,
+		tpl:            tpl,
+	}, nil
+}
+
+type labelListData struct {
+	Labels    []*services.Label
+	Query     string
+	CanManage bool
+	// Set after an import, to say how many labels were imported.
+	Imported int
+	Err      string
+}
+
+func (l *labelListData) Title() string {
+	return "Phone Number Labels"
+}
+
+func (l *labelListData) Path() string {
+	return "/labels"
+}
+
+func (s *labelServer) renderList(w http.ResponseWriter, r *http.Request, u *config.User, code int, data *labelListData) {
+	data.Labels = s.Labels.Search(data.Query)
+	data.CanManage = u.CanManageLabels()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (s *labelServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	switch {
+	case r.URL.Path == "/labels/export":
+		s.export(w, r)
+	case r.Method == "GET":
+		s.renderList(w, r, u, http.StatusOK, &labelListData{Query: r.URL.Query().Get("q")})
+	case !u.CanManageLabels():
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to change labels"})
+	case r.URL.Path == "/labels/import":
+		s.importCSV(w, r, u)
+	default:
+		s.update(w, r, u)
+	}
+}
+
+// POST /labels
+//
+// Set the label for phone_number to label, or delete it if delete is "true".
+func (s *labelServer) update(w http.ResponseWriter, r *http.Request, u *config.User) {
+	if err := r.ParseForm(); err != nil {
+		s.renderList(w, r, u, http.StatusBadRequest, &labelListData{Err: err.Error()})
+		return
+	}
+	pn := r.PostForm.Get("phone_number")
+	if r.PostForm.Get("delete") == "true" {
+		num, err := twilio.NewPhoneNumber(pn)
+		if err == nil {
+			err = s.Labels.Delete(num)
+		}
+		if err != nil {
+			s.renderList(w, r, u, http.StatusBadRequest, &labelListData{Err: err.Error()})
+			return
+		}
+		s.Info("Deleted phone number label", "user", u.ID(), "pn", num)
+	} else {
+		label, err := s.Labels.Set(pn, r.PostForm.Get("label"))
+		if err != nil {
+			s.renderList(w, r, u, http.StatusBadRequest, &labelListData{Err: err.Error()})
+			return
+		}
+		s.Info("Set phone number label", "user", u.ID(), "pn", label.PhoneNumber, "label", label.Name)
+	}
+	http.Redirect(w, r, "/labels?q="+url.QueryEscape(r.PostForm.Get("q")), http.StatusFound)
+}
+
+// POST /labels/import
+//
+// Add the labels in the uploaded CSV file.
+func (s *labelServer) importCSV(w http.ResponseWriter, r *http.Request, u *config.User) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLabelImportBytes)
+	f, _, err := r.FormFile("file")
+	if err != nil {
+		s.renderList(w, r, u, http.StatusBadRequest, &labelListData{Err: "Choose a CSV file to import"})
+		return
+	}
+	defer f.Close()
+	n, err := s.Labels.Import(f)
+	if err != nil {
+		s.renderList(w, r, u, http.StatusBadRequest, &labelListData{Err: fmt.Sprintf("Couldn't import labels: %v", err)})
+		return
+	}
+	s.Info("Imported phone number labels", "user", u.ID(), "count", n)
+	s.renderList(w, r, u, http.StatusOK, &labelListData{Imported: n})
+}
+
+// GET /labels/export
+func (s *labelServer) export(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="labels.csv"`)
+	if err := s.Labels.Export(w); err != nil {
+		s.Warn("Error exporting labels", "err", err)
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+func TestLabelsRequirePermission(t *testing.T) {
+	t.Parallel()
+	labels, _ := services.NewLabelStore("")
+	lf, _ := services.NewLocationFinder("America/Los_Angeles")
+	s, err := newLabelServer(dlog, labels, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := url.Values{"phone_number": {"+14155551234"}, "label": {"Main support line"}}.Encode()
+
+	us := config.AllUserSettings()
+	us.CanManageLabels = false
+	req, _ := http.NewRequest("POST", "/labels", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = config.SetUser(req, config.NewUser(us))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/labels", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = config.SetUser(req, config.DefaultUser)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 302 {
+		t.Errorf("expected Code to be 302, got %d", w.Code)
+	}
+	if label := labels.Get("+14155551234"); label != "Main support line" {
+		t.Errorf("expected label to be saved, got %q", label)
+	}
+
+	req, _ = http.NewRequest("GET", "/labels?q=support", nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Errorf("expected Code to be 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Main support line") {
+		t.Error("expected label in the list")
+	}
+	if strings.Contains(w.Body.String(), "Save label") {
+		t.Error("expected users who can't manage labels not to see the form")
+	}
+}
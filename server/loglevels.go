@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/logging"
+	"github.com/saintpete/logrole/services"
+)
+
+// logLevelServer shows and changes the level each module logs at, without a
+// restart. It requires the can_reload_config permission. Reloading the config
+// goes back to the levels in the file.
+type logLevelServer struct {
+	log.Logger
+	Levels *logging.Levels
+	Audit  *services.AuditLog
+}
+
+type logLevelsResponse struct {
+	Default string `json:"default"`
+	// Every module's level, whether it has its own or uses the default.
+	Modules map[string]string `json:"modules"`
+	// The modules with their own level.
+	Overrides []string `json:"overrides"`
+}
+
+func (s *logLevelServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanReloadConfig() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	if s.Levels == nil {
+		rest.NotFound(w, r)
+		return
+	}
+	if r.Method == "POST" {
+		if !s.set(w, r, u) {
+			return
+		}
+	}
+	resp := &logLevelsResponse{
+		Default:   logging.LevelName(s.Levels.Default()),
+		Modules:   make(map[string]string),
+		Overrides: s.Levels.Overrides(),
+	}
+	for _, m := range logging.Modules() {
+		lvl, _ := s.Levels.Get(m)
+		resp.Modules[m] = logging.LevelName(lvl)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(resp)
+}
+
+// POST /admin/log-levels
+//
+// Set module to level, like module=cache&level=debug. An empty module changes
+// the default level, and an empty level makes the module use the default
+// again. Responds with the new levels, like GET.
+func (s *logLevelServer) set(w http.ResponseWriter, r *http.Request, u *config.User) bool {
+	if err := r.ParseForm(); err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return false
+	}
+	module := r.PostForm.Get("module")
+	level := r.PostForm.Get("level")
+	if level == "" && module != "" {
+		s.Levels.Reset(module)
+	} else {
+		lvl, err := logging.ParseLevel(level)
+		if err == nil {
+			err = s.Levels.Set(module, lvl)
+		}
+		if err != nil {
+			rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+			return false
+		}
+	}
+	if module == "" {
+		module = "default"
+	}
+	s.Info("Changed log level", "user", u.ID(), "log_module", module, "level", level)
+	s.Audit.Record(&services.AuditEvent{
+		User:     u.ID(),
+		Action:   "set_log_level",
+		Resource: module,
+		Details:  map[string]string{"level": level},
+	})
+	return true
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/logging"
+)
+
+func TestLogLevels(t *testing.T) {
+	t.Parallel()
+	s := &logLevelServer{
+		Logger: NullLogger,
+		Levels: logging.NewLevels(log.LvlInfo, nil),
+	}
+	u := config.NewUser(config.AllUserSettings())
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/admin/log-levels", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = config.SetUser(req, u)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+	w := post("module=cache&level=debug")
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	resp := new(logLevelsResponse)
+	if err := json.NewDecoder(w.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Modules["cache"] != "debug" || resp.Modules["server"] != "info" || resp.Default != "info" {
+		t.Errorf("expected only the cache to log at debug, got %+v", resp)
+	}
+	if lvl, _ := s.Levels.Get("cache"); lvl != log.LvlDebug {
+		t.Errorf("expected cache level to be debug, got %v", lvl)
+	}
+
+	if w := post("module=cache&level="); w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	if _, ok := s.Levels.Get("cache"); ok {
+		t.Error("expected an empty level to reset the module")
+	}
+	if w := post("module=nope&level=debug"); w.Code != 400 {
+		t.Errorf("expected an unknown module to be rejected, got %d", w.Code)
+	}
+	if w := post("level=loud"); w.Code != 400 {
+		t.Errorf("expected an unknown level to be rejected, got %d", w.Code)
+	}
+
+	us := config.AllUserSettings()
+	us.CanReloadConfig = false
+	req, _ := http.NewRequest("GET", "/admin/log-levels", nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
+)
+
+// mediaCacheServer lets users remove media from the on-disk cache, so it's
+// fetched from Twilio again the next time it's viewed. It requires the
+// can_profile permission.
+type mediaCacheServer struct {
+	log.Logger
+	// nil if the media cache is disabled.
+	Blobs     *cache.BlobStore
+	secretKey *[32]byte
+}
+
+// POST /media-cache/purge
+//
+// With path=/images/<encrypted URL> or path=/audio/<encrypted URL>, removes
+// that item from the cache. With all=true, empties the cache.
+func (m *mediaCacheServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanProfile() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	if m.Blobs == nil {
+		rest.BadRequest(w, r, &rest.Error{Title: "The media cache is not enabled"})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: "Could not parse form"})
+		return
+	}
+	if r.PostForm.Get("all") == "true" {
+		m.Info("Purging media cache", "size", m.Blobs.Size())
+		m.Blobs.PurgeAll()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	path := r.PostForm.Get("path")
+	var encoded string
+	if match := imageRoute.FindStringSubmatch(path); match != nil {
+		encoded = match[1]
+	} else if match := audioRoute.FindStringSubmatch(path); match != nil {
+		encoded = match[1]
+	} else {
+		rest.BadRequest(w, r, &rest.Error{
+			Title: "Provide the /images or /audio path to purge, or all=true",
+		})
+		return
+	}
+	mediaURL, wroteError := decryptURL(w, r, encoded, m.secretKey)
+	if wroteError {
+		return
+	}
+	m.Blobs.Purge(mediaCacheKey(mediaURL))
+	w.WriteHeader(http.StatusNoContent)
+}
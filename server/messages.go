@@ -6,13 +6,11 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aristanetworks/goarista/monotime"
 	log "github.com/inconshreveable/log15"
-	types "github.com/kevinburke/go-types"
 	"github.com/kevinburke/rest"
 	"github.com/saintpete/logrole/config"
 	"github.com/saintpete/logrole/services"
@@ -23,26 +21,53 @@ import (
 
 const messagePattern = `(?P<sid>(MM|SM)[a-f0-9]{32})`
 
-var messageInstanceRoute = regexp.MustCompile("^/messages/" + messagePattern + "$")
+// Messages and calls from providers other than Twilio have UUIDs for IDs.
+const providerIDPattern = `[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`
+
+var messageInstanceRoute = regexp.MustCompile("^/messages/(?P<sid>(MM|SM)[a-f0-9]{32}|" + providerIDPattern + ")$")
 
 type messageInstanceServer struct {
 	log.Logger
 	Client             views.Client
 	LocationFinder     services.LocationFinder
 	ShowMediaByDefault bool
-	tpl                *template.Template
+	// Show a resend button on failed messages. False for archives and in
+	// read-only mode, where messages can't be sent.
+	AllowResend bool
+	AllowCancel bool
+	// Link messages that failed because of A2P 10DLC registration to /a2p.
+	// False for archives, which can't look up registrations.
+	AllowA2P bool
+	// May be nil.
+	Tickets *ticketer
+	// Indexes the text in attachments of messages people view. nil if
+	// attachment text isn't indexed.
+	Indexer *attachmentIndexer
+	// Hashes the bodies of messages people view. nil unless body hashing is
+	// enabled.
+	Bodies *bodyIndexer
+	// May be nil.
+	Notes *services.NoteStore
+	// Runbook links and notes for error codes. May be nil.
+	Runbooks *config.Runbooks
+	// Translates message bodies. nil unless translation is configured.
+	Translator *translator
+	tpl        *template.Template
 }
 
-func newMessageInstanceServer(l log.Logger, vc views.Client, lf services.LocationFinder, smbd bool) (*messageInstanceServer, error) {
+func newMessageInstanceServer(l log.Logger, vc views.Client, lf services.LocationFinder, labels *services.LabelStore, owners *services.OwnerStore, tickets *ticketer, smbd bool) (*messageInstanceServer, error) {
 	s := &messageInstanceServer{
 		Logger:             l,
 		Client:             vc,
 		LocationFinder:     lf,
 		ShowMediaByDefault: smbd,
+		Tickets:            tickets,
 	}
 	tpl, err := newTpl(template.FuncMap{
 		"is_our_pn": vc.IsTwilioNumber,
-	}, base+messageInstanceTpl+phoneTpl+sidTpl+copyScript)
+		"pn_label":  labels.Get,
+		"pn_owner":  owners.Get,
+	}, base+messageInstanceTpl+phoneTpl+sidTpl+ticketsTpl+notesTpl+relatedAlertsTpl+runbookTpl+copyScript)
 	if err != nil {
 		return nil, err
 	}
@@ -55,6 +80,23 @@ type messageInstanceData struct {
 	Loc                *time.Location
 	Media              *mediaResp
 	ShowMediaByDefault bool
+	CanResend          bool
+	CanCancel          bool
+	// Set if the message failed because of A2P 10DLC registration, and the
+	// user can see the number it was sent from.
+	A2PNumber string
+	Tickets   *ticketData
+	// nil if the user can't view notes.
+	Notes *noteData
+	// nil if the user can't view alerts.
+	Alerts   *alertsResp
+	Runbooks *config.Runbooks
+	// Show a button to translate the body.
+	CanTranslate        bool
+	TranslationLanguage string
+	// The body's translation, if someone has already translated it. nil if
+	// the user can't translate messages.
+	Translation *services.Translation
 }
 
 func (m *messageInstanceData) Title() string {
@@ -85,6 +127,16 @@ func (s *messageInstanceServer) ServeHTTP(w http.ResponseWriter, r *http.Request
 		}
 		close(rch)
 	}(sid)
+	ach := make(chan *alertsResp, 1)
+	if u.CanViewAlerts() {
+		go func(sid string) {
+			alerts, err := s.Client.GetMessageAlerts(ctx, u, sid)
+			ach <- &alertsResp{Noun: "message", Sid: sid, Err: err, Alerts: alerts, Runbooks: s.Runbooks}
+			close(ach)
+		}(sid)
+	} else {
+		close(ach)
+	}
 	message, err := s.Client.GetMessage(ctx, u, sid)
 	switch err {
 	case nil:
@@ -114,10 +166,26 @@ func (s *messageInstanceServer) ServeHTTP(w http.ResponseWriter, r *http.Request
 		LF:       s.LocationFinder,
 		Duration: monotime.Since(start),
 	}
+	loc := s.LocationFinder.GetLocationReq(r)
 	data := &messageInstanceData{
 		Message:            message,
-		Loc:                s.LocationFinder.GetLocationReq(r),
+		Loc:                loc,
 		ShowMediaByDefault: s.ShowMediaByDefault,
+		CanResend:          s.AllowResend && u.Feature(config.FeatureResendMessages) && message.CanResend(),
+		CanCancel:          s.AllowCancel && u.Feature(config.FeatureScheduledMessages) && message.CanCancel(),
+		Tickets:            s.Tickets.data("message", r.URL.Path, message, loc),
+		Notes:              notesFor(s.Notes, u, sid, loc),
+		Runbooks:           s.Runbooks,
+	}
+	if s.Translator != nil && message.CanTranslate() {
+		data.CanTranslate = true
+		data.TranslationLanguage = s.Translator.Language
+		data.Translation = s.Translator.Cached(sid)
+	}
+	if s.AllowA2P && u.Feature(config.FeatureA2P) && message.A2PError() {
+		if from, err := message.From(); err == nil {
+			data.A2PNumber = string(from)
+		}
 	}
 	numMedia, err := message.NumMedia()
 	switch {
@@ -126,7 +194,12 @@ func (s *messageInstanceServer) ServeHTTP(w http.ResponseWriter, r *http.Request
 	case numMedia > 0:
 		r := <-rch
 		data.Media = r
+		if r.Err == nil && u.CanViewMedia() {
+			s.Indexer.Index(u, sid, r.URLs)
+		}
 	}
+	s.Bodies.Index([]*views.Message{message})
+	data.Alerts = <-ach
 	baseData.Data = data
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := render(w, r, s.tpl, "base", baseData); err != nil {
@@ -141,18 +214,24 @@ type messageListServer struct {
 	PageSize       uint
 	secretKey      *[32]byte
 	MaxResourceAge time.Duration
-	tpl            *template.Template
+	// Used to filter the list by team. May be nil.
+	Owners *services.OwnerStore
+	// nil if prefetching is disabled.
+	Prefetcher *prefetcher
+	// nil unless body hashing is enabled.
+	Bodies *bodyIndexer
+	tpl    *template.Template
 }
 
-func (s *messageListServer) StartSearchVal(query url.Values, loc *time.Location) string {
+func (s *messageListServer) StartSearchVal(query url.Values, maxAge time.Duration, loc *time.Location) string {
 	if start, ok := query["start"]; ok {
 		return start[0]
 	}
-	if s.MaxResourceAge == config.DefaultMaxResourceAge {
+	if maxAge == config.DefaultMaxResourceAge {
 		// one week ago, arbitrary
 		return minLoc(7*24*time.Hour, loc)
 	} else {
-		return minLoc(s.MaxResourceAge, loc)
+		return minLoc(maxAge, loc)
 	}
 }
 
@@ -163,22 +242,30 @@ func (s *messageListServer) EndSearchVal(query url.Values, loc *time.Location) s
 	return maxLoc(loc)
 }
 
-func newMessageListServer(l log.Logger, vc views.Client, lf services.LocationFinder, pageSize uint, maxResourceAge time.Duration, secretKey *[32]byte) (*messageListServer, error) {
+func newMessageListServer(l log.Logger, vc views.Client, lf services.LocationFinder, labels *services.LabelStore, owners *services.OwnerStore, pageSize uint, maxResourceAge time.Duration, secretKey *[32]byte) (*messageListServer, error) {
 	s := &messageListServer{
 		Logger:         l,
 		Client:         vc,
 		LocationFinder: lf,
 		PageSize:       pageSize,
 		MaxResourceAge: maxResourceAge,
+		Owners:         owners,
 		secretKey:      secretKey,
 	}
+	providers := listProviders(vc)
+	accounts := listAccounts(vc)
 	tpl, err := newTpl(template.FuncMap{
 		"is_our_pn": vc.IsTwilioNumber,
-		"min":       minFunc(s.MaxResourceAge),
+		"pn_label":  labels.Get,
+		"pn_owner":  owners.Get,
+		"min":       minLoc,
 		"max":       maxLoc,
 		"start_val": s.StartSearchVal,
 		"end_val":   s.EndSearchVal,
-	}, base+messageListTpl+messageStatusTpl+pagingTpl+phoneTpl+copyScript)
+		"providers": func() []string { return providers },
+		"accounts":  func() []*views.Account { return accounts },
+		"teams":     owners.Teams,
+	}, base+messageListTpl+messageStatusTpl+pagingTpl+phoneTpl+copyScript+autoRefreshScript+partialListsScript)
 	if err != nil {
 		return nil, err
 	}
@@ -193,7 +280,38 @@ type messageListData struct {
 	Loc                   *time.Location
 	Query                 url.Values
 	Err                   string
-	MaxResourceAge        time.Duration
+	// Set if the page was streamed and fetching the results failed.
+	FetchErr       string
+	MaxResourceAge time.Duration
+	// Show the auto-refresh toggle. Only the first page of a list with no
+	// end time can have anything newer.
+	AutoRefresh bool
+	// When the page was rendered, for lists with no messages to compare to.
+	Now time.Time
+	// The business hours of the user's group; dates outside them are
+	// shaded. nil if the group doesn't have any.
+	Hours *config.BusinessHours
+	// Swap in new results when the filters or the page change, instead of
+	// loading the whole page.
+	Partial bool
+	*stream
+}
+
+// NewerURL returns the URL to poll for messages newer than the ones on the
+// page.
+func (m *messageListData) NewerURL() string {
+	var newest time.Time
+	if m.Page != nil {
+		for _, msg := range m.Page.Messages() {
+			if created, err := msg.DateCreated(); err == nil && created.Time.After(newest) {
+				newest = created.Time
+			}
+		}
+	}
+	if newest.IsZero() {
+		newest = m.Now
+	}
+	return newerURL(m.Path(), m.Query, newest)
 }
 
 func (m *messageListData) Title() string {
@@ -215,6 +333,15 @@ func (m *messageListData) NextQuery() template.URL {
 	if start, ok := m.Query["start"]; ok {
 		data.Set("start", start[0])
 	}
+	if country, ok := m.Query["country"]; ok {
+		data.Set("country", country[0])
+	}
+	if team, ok := m.Query["team"]; ok {
+		data.Set("team", team[0])
+	}
+	if channel, ok := m.Query["channel"]; ok {
+		data.Set("channel", channel[0])
+	}
 	return template.URL(data.Encode())
 }
 
@@ -229,6 +356,15 @@ func (m *messageListData) PreviousQuery() template.URL {
 	if start, ok := m.Query["start"]; ok {
 		data.Set("start", start[0])
 	}
+	if country, ok := m.Query["country"]; ok {
+		data.Set("country", country[0])
+	}
+	if team, ok := m.Query["team"]; ok {
+		data.Set("team", team[0])
+	}
+	if channel, ok := m.Query["channel"]; ok {
+		data.Set("channel", channel[0])
+	}
 	return template.URL(data.Encode())
 }
 
@@ -240,7 +376,7 @@ func (s *messageListServer) renderError(w http.ResponseWriter, r *http.Request,
 			Loc:            s.LocationFinder.GetLocationReq(r),
 			Query:          query,
 			Page:           new(views.MessagePage),
-			MaxResourceAge: s.MaxResourceAge,
+			MaxResourceAge: userMaxResourceAge(r, s.MaxResourceAge),
 		}}
 	if code >= 500 {
 		s.Error("Error responding to request", "status", code, "url", r.URL.String(), "err", err)
@@ -256,7 +392,7 @@ func (s *messageListServer) renderError(w http.ResponseWriter, r *http.Request,
 }
 
 func (s *messageListServer) validParams() []string {
-	return []string{"start", "end", "next", "to", "from"}
+	return []string{"start", "end", "next", "to", "from", "country", "team", "channel", "provider", "account"}
 }
 
 func (s *messageListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -278,84 +414,152 @@ func (s *messageListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	loc := s.LocationFinder.GetLocationReq(r)
 	var err error
-	startTime, endTime, wroteError := getTimes(w, r, "start", "end", loc, query, s)
+	maxAge := u.MaxResourceAge(s.MaxResourceAge)
+	startTime, endTime, wroteError := getTimes(w, r, "start", "end", loc, maxAge, query, s)
 	if wroteError {
 		return
 	}
+	country, countryErr := getCountry(query, u.CanViewMessageFrom(), u.CanViewMessageTo())
+	if countryErr != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, countryErr)
+		return
+	}
+	team, teamErr := getTeam(query, s.Owners, u.CanViewMessageFrom(), u.CanViewMessageTo())
+	if teamErr != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, teamErr)
+		return
+	}
+	channel, channelErr := getChannel(query)
+	if channelErr != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, channelErr)
+		return
+	}
 	ctx, cancel := getContext(r.Context(), 3*time.Second)
 	defer cancel()
-	next, nextErr := getNext(query, s.secretKey)
+	scope := pageScope(u, query, "start", "end")
+	next, nextErr := getNext(query, scope, s.secretKey)
 	if nextErr != nil {
 		err = errors.New("Could not decrypt `next` query parameter: " + nextErr.Error())
 		s.renderError(w, r, http.StatusBadRequest, query, err)
 		return
 	}
-	var page *views.MessagePage
-	var cachedAt uint64
-	start := monotime.Now()
+	var data url.Values
 	if next != "" {
 		if !strings.HasPrefix(next, "/"+twilio.APIVersion) {
 			s.Warn("Invalid next page URI", "next", next, "opaque", query.Get("next"))
 			s.renderError(w, r, http.StatusBadRequest, query, errors.New("Invalid next page uri"))
 			return
 		}
-		page, cachedAt, err = s.Client.GetNextMessagePageInRange(ctx, u, startTime, endTime, next)
+		s.Prefetcher.Requested("messages", next)
 		setNextPageValsOnQuery(next, query)
 	} else {
 		// valid values: https://www.twilio.com/docs/api/rest/message#list
-		data := url.Values{}
-		data.Set("PageSize", strconv.FormatUint(uint64(s.PageSize), 10))
+		data = url.Values{}
+		data.Set("PageSize", userPageSize(r, u, s.PageSize))
 		if filterErr := setPageFilters(query, data); filterErr != nil {
 			s.renderError(w, r, http.StatusBadRequest, query, filterErr)
 			return
 		}
-		page, cachedAt, err = s.Client.GetMessagePageInRange(ctx, u, startTime, endTime, data)
 	}
-	if err == twilio.NoMoreResults {
-		page = new(views.MessagePage)
-		err = nil
+	var page *views.MessagePage
+	var cachedAt uint64
+	var fetchErr error
+	var fetchDuration time.Duration
+	st := startStream(w, u, func() {
+		start := monotime.Now()
+		if next != "" {
+			page, cachedAt, fetchErr = s.Client.GetNextMessagePageInRange(ctx, u, startTime, endTime, next)
+		} else {
+			page, cachedAt, fetchErr = s.Client.GetMessagePageInRange(ctx, u, startTime, endTime, data)
+		}
+		fetchDuration = monotime.Since(start)
+		if fetchErr == twilio.NoMoreResults {
+			page = new(views.MessagePage)
+			fetchErr = nil
+		}
+		if fetchErr == nil && country != "" {
+			page = page.Filter(func(m *views.Message) bool {
+				return m.InCountry(country)
+			})
+		}
+		if fetchErr == nil && team != "" {
+			page = page.Filter(func(m *views.Message) bool {
+				return m.OwnedBy(s.Owners, team)
+			})
+		}
+		if fetchErr == nil && channel != "" {
+			page = page.Filter(func(m *views.Message) bool {
+				c, err := m.Channel()
+				return err == nil && c == channel
+			})
+		}
+	})
+	ld := &messageListData{
+		Loc:            loc,
+		Query:          query,
+		MaxResourceAge: maxAge,
+		AutoRefresh:    next == "" && query.Get("end") == "" && u.Feature(config.FeatureAutoRefresh),
+		Now:            time.Now(),
+		Hours:          u.BusinessHours(),
+		Partial:        u.Feature(config.FeaturePartialLists),
+		stream:         st,
+	}
+	bd := &baseData{LF: s.LocationFinder, Data: ld}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if st != nil {
+		st.then = func() {
+			bd.Duration = fetchDuration
+			if fetchErr != nil {
+				s.Warn("Error fetching streamed page", "url", r.URL.String(), "err", fetchErr)
+				ld.Page = new(views.MessagePage)
+				ld.FetchErr = cleanError(fetchErr)
+				return
+			}
+			s.setPage(r.Context(), bd, ld, u, page, cachedAt, startTime, endTime, scope)
+		}
+		if err := renderStream(w, r, s.tpl, "base", bd); err != nil {
+			s.Error("Error rendering streamed page", "url", r.URL.String(), "err", err)
+		}
+		return
 	}
-	if err != nil {
-		switch terr := err.(type) {
+	if fetchErr != nil {
+		switch terr := fetchErr.(type) {
 		case *rest.Error:
 			switch terr.StatusCode {
 			case 400:
-				s.renderError(w, r, http.StatusBadRequest, query, err)
+				s.renderError(w, r, http.StatusBadRequest, query, fetchErr)
 			case 404:
 				rest.NotFound(w, r)
 			default:
 				rest.ServerError(w, r, terr)
 			}
 		default:
-			rest.ServerError(w, r, err)
+			rest.ServerError(w, r, fetchErr)
 		}
 		return
 	}
-	// Fetch the next page into the cache
-	go func(u *config.User, n types.NullString, start, end time.Time) {
-		if n.Valid {
-			if _, _, err := s.Client.GetNextMessagePageInRange(context.Background(), u, start, end, n.String); err != nil {
-				s.Debug("Error fetching next page", "err", err)
-			}
-		}
-	}(u, page.NextPageURI(), startTime, endTime)
-	data := &baseData{
-		LF:       s.LocationFinder,
-		Duration: monotime.Since(start),
-		Data: &messageListData{
-			Page:                  page,
-			Loc:                   loc,
-			Query:                 query,
-			MaxResourceAge:        s.MaxResourceAge,
-			EncryptedPreviousPage: getEncryptedPage(page.PreviousPageURI(), s.secretKey),
-			EncryptedNextPage:     getEncryptedPage(page.NextPageURI(), s.secretKey),
-		}}
-	if cachedAt > 0 {
-		data.CachedDuration = monotime.Since(cachedAt)
-	}
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := render(w, r, s.tpl, "base", data); err != nil {
+	bd.Duration = fetchDuration
+	s.setPage(r.Context(), bd, ld, u, page, cachedAt, startTime, endTime, scope)
+	if err := render(w, r, s.tpl, "base", bd); err != nil {
 		s.renderError(w, r, http.StatusInternalServerError, query, err)
 		return
 	}
 }
+
+// setPage fills in the results on the page, and fetches the next page into
+// the cache. The links to the other pages are bound to scope.
+func (s *messageListServer) setPage(ctx context.Context, bd *baseData, ld *messageListData, u *config.User, page *views.MessagePage, cachedAt uint64, start, end time.Time, scope string) {
+	if n := page.NextPageURI(); n.Valid {
+		s.Prefetcher.Prefetch(services.DetachCallBudget(ctx), "messages", n.String, func(ctx context.Context) error {
+			_, _, err := s.Client.GetNextMessagePageInRange(ctx, u, start, end, n.String)
+			return err
+		})
+	}
+	s.Bodies.Index(page.Messages())
+	ld.Page = page
+	ld.EncryptedPreviousPage = getEncryptedPage(page.PreviousPageURI(), scope, s.secretKey)
+	ld.EncryptedNextPage = getEncryptedPage(page.NextPageURI(), scope, s.secretKey)
+	if cachedAt > 0 {
+		bd.CachedDuration = monotime.Since(cachedAt)
+	}
+}
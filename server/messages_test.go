@@ -2,18 +2,23 @@ package server
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
 	"strings"
 	"testing"
 	"time"
 
 	log "github.com/inconshreveable/log15"
+	types "github.com/kevinburke/go-types"
 	twilio "github.com/saintpete/twilio-go"
 	"github.com/saintpete/logrole/config"
 	"github.com/saintpete/logrole/services"
 	"github.com/saintpete/logrole/test"
 	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
 )
 
 var dlog = log.New()
@@ -32,11 +37,11 @@ func init() {
 func TestInvalidNext(t *testing.T) {
 	t.Parallel()
 	vc := harness.ViewsClient(harness.ViewHarness{SecretKey: key})
-	s, err := newMessageListServer(dlog, vc, lf, 50, time.Hour, key)
+	s, err := newMessageListServer(dlog, vc, lf, nil, nil, 50, time.Hour, key)
 	if err != nil {
 		t.Fatal(err)
 	}
-	enc := services.Opaque("invalid", key)
+	enc := services.OpaqueFor("invalid", pageScope(theUser, nil, "start", "end"), key)
 	req, _ := http.NewRequest("GET", "/messages?next="+enc, nil)
 	req.SetBasicAuth("test", "test")
 	req = config.SetUser(req, theUser)
@@ -50,6 +55,30 @@ func TestInvalidNext(t *testing.T) {
 	}
 }
 
+func TestNextScope(t *testing.T) {
+	t.Parallel()
+	p := &config.Policy{
+		{Name: "support", Users: []string{"a@example.com", "b@example.com"}},
+		{Name: "finance", Users: []string{"c@example.com"}},
+	}
+	a, _, _ := p.Lookup("a@example.com")
+	b, _, _ := p.Lookup("b@example.com")
+	c, _, _ := p.Lookup("c@example.com")
+	query := url.Values{"start": []string{"2016-10-18T00:00"}}
+	enc := getEncryptedPage(types.NullString{Valid: true, String: "/page2"}, pageScope(a, query, "start", "end"), key)
+	query.Set("next", enc)
+	if next, err := getNext(query, pageScope(b, query, "start", "end"), key); err != nil || next != "/page2" {
+		t.Errorf("expected someone in the same group to use the token, got %q, %v", next, err)
+	}
+	if _, err := getNext(query, pageScope(c, query, "start", "end"), key); err != errNextScope {
+		t.Errorf("expected a token from another group to be rejected, got %v", err)
+	}
+	query.Set("start", "2016-10-01T00:00")
+	if _, err := getNext(query, pageScope(a, query, "start", "end"), key); err != errNextScope {
+		t.Errorf("expected a token for another time range to be rejected, got %v", err)
+	}
+}
+
 // invalid status here on purpose to check we use a different one.
 var notFoundResp = []byte("{\"code\": 20404, \"message\": \"The requested resource /2010-04-01/Accounts/AC58f1e8f2b1c6b88ca90a012a4be0c279/Calls/unknown.json was not found\", \"more_info\": \"https://www.twilio.com/docs/errors/20404\", \"status\": 428}")
 
@@ -80,7 +109,7 @@ func Test404OnResource404(t *testing.T) {
 
 var uris = []string{
 	"/messages",
-	"/messages?next=" + services.Opaque("/2010-04-01/Accounts/AC58f1e8f2b1c6b88ca90a012a4be0c279/Messages.json?PageSize=50&Page=1&PageToken=PASM0ea5868a88542cc21fd0f85c4daa6c33", key),
+	"/messages?next=" + services.OpaqueFor("/2010-04-01/Accounts/AC58f1e8f2b1c6b88ca90a012a4be0c279/Messages.json?PageSize=50&Page=1&PageToken=PASM0ea5868a88542cc21fd0f85c4daa6c33", pageScope(theUser, nil, "start", "end"), key),
 }
 
 func TestNoResultsIfAllResultsOld(t *testing.T) {
@@ -94,7 +123,7 @@ func TestNoResultsIfAllResultsOld(t *testing.T) {
 	hrns := harness.ViewHarness{TestServer: server, SecretKey: key, MaxResourceAge: age}
 	vc := harness.ViewsClient(hrns)
 	lf, _ := services.NewLocationFinder("America/Los_Angeles")
-	s, err := newMessageListServer(dlog, vc, lf, 50, time.Hour, key)
+	s, err := newMessageListServer(dlog, vc, lf, nil, nil, 50, time.Hour, key)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -111,3 +140,194 @@ func TestNoResultsIfAllResultsOld(t *testing.T) {
 		}
 	}
 }
+
+func TestGetTimesClampsStartToMaxAge(t *testing.T) {
+	t.Parallel()
+	loc := time.UTC
+	req, _ := http.NewRequest("GET", "/messages?start=2016-01-01T00:00", nil)
+	query := req.URL.Query()
+	w := httptest.NewRecorder()
+	start, _, wroteError := getTimes(w, req, "start", "end", loc, 24*time.Hour, query, nil)
+	if wroteError {
+		t.Fatal("unexpected error")
+	}
+	if cutoff := time.Now().Add(-25 * time.Hour); start.Before(cutoff) {
+		t.Errorf("expected start to be moved forward to the max age, got %v", start)
+	}
+	start, _, _ = getTimes(w, req, "start", "end", loc, config.DefaultMaxResourceAge, query, nil)
+	if want := time.Date(2016, 1, 1, 0, 0, 0, 0, loc); !start.Equal(want) {
+		t.Errorf("expected start %v, got %v", want, start)
+	}
+}
+
+func TestMessageListShowsArchiveBanner(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vc, err := views.NewArchiveClient(dlog, dir, "AC123", config.NewPermission(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newMessageListServer(dlog, vc, lf, nil, nil, 50, time.Hour, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/messages", nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	withArchive(s, &archive{AccountSid: "AC123"}).ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if body := w.Body.String(); !strings.Contains(body, "Archived data") || !strings.Contains(body, "AC123") {
+		t.Errorf("expected archive banner, got %s", body)
+	}
+}
+
+func TestMessageListFromFixtures(t *testing.T) {
+	t.Parallel()
+	f := views.DemoFixtures(time.Now())
+	vc := harness.FixtureClient(harness.ViewHarness{}, f)
+	s, err := newMessageListServer(dlog, vc, lf, nil, nil, 10, 720*time.Hour, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/messages?from="+url.QueryEscape(string(f.Messages[0].From)), nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	withArchive(s, &archive{AccountSid: views.DemoAccountSid, Demo: true}).ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "<strong>Demo.</strong>") || strings.Contains(body, "Archived data") {
+		t.Errorf("expected the demo banner, got %s", body)
+	}
+	if !strings.Contains(body, `href="/messages/`+f.Messages[0].Sid+`"`) {
+		t.Errorf("expected the newest message from %s, got %s", f.Messages[0].From, body)
+	}
+	// An inbound message, to one of our numbers.
+	if strings.Contains(body, f.Messages[1].Sid) {
+		t.Errorf("expected messages from other numbers to be filtered out, got %s", body)
+	}
+	if !strings.Contains(body, `rel="next"`) {
+		t.Errorf("expected a link to the next page, got %s", body)
+	}
+}
+
+func TestMessageListResultsFragment(t *testing.T) {
+	t.Parallel()
+	f := views.DemoFixtures(time.Now())
+	vc := harness.FixtureClient(harness.ViewHarness{}, f)
+	s, err := newMessageListServer(dlog, vc, lf, nil, nil, 10, 720*time.Hour, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/messages", nil)
+	req.Header.Set(fragmentHeader, resultsFragment)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if strings.Contains(body, "<html") || strings.Contains(body, "row-search") {
+		t.Errorf("expected only the results, got %s", body)
+	}
+	if !strings.Contains(body, `href="/messages/`+f.Messages[0].Sid+`"`) || !strings.Contains(body, `rel="next"`) {
+		t.Errorf("expected the results table and paging links, got %s", body)
+	}
+	if vary := w.Header().Get("Vary"); vary != fragmentHeader {
+		t.Errorf("expected Vary: %s, got %q", fragmentHeader, vary)
+	}
+
+	req, _ = http.NewRequest("GET", "/messages", nil)
+	req = config.SetUser(req, theUser)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if body := w.Body.String(); !strings.Contains(body, "row-search") || !strings.Contains(body, `<div id="results">`) {
+		t.Errorf("expected the whole page without the header, got %s", body)
+	}
+}
+
+func TestMessageInstanceFromFixtures(t *testing.T) {
+	t.Parallel()
+	f := views.DemoFixtures(time.Now())
+	vc := harness.FixtureClient(harness.ViewHarness{}, f)
+	s, err := newMessageInstanceServer(dlog, vc, lf, nil, nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Undelivered, with an alert.
+	sid := f.Alerts[0].ResourceSid
+	req, _ := http.NewRequest("GET", "/messages/"+sid, nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if body := w.Body.String(); !strings.Contains(body, `href="/alerts/`+f.Alerts[0].Sid+`"`) {
+		t.Errorf("expected a link to the message's alert, got %s", body)
+	}
+
+	req, _ = http.NewRequest("GET", "/messages/SM00000000000000000000000000000404", nil)
+	req = config.SetUser(req, theUser)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 404 {
+		t.Errorf("expected Code to be 404, got %d", w.Code)
+	}
+}
+
+func TestMessageInstanceShowsAlerts(t *testing.T) {
+	t.Parallel()
+	sid := "SM30006000000000000000000000000000"
+	f := errorSearchFixtures()
+	f.Messages[0].Sid = sid
+	f.Alerts = append(f.Alerts, &twilio.Alert{
+		Sid:         "NO30006000000000000000000000000000",
+		ErrorCode:   30006,
+		LogLevel:    twilio.LogLevelError,
+		ResourceSid: sid,
+		DateCreated: f.Messages[0].DateCreated,
+	})
+	vc := harness.FixtureClient(harness.ViewHarness{MaxResourceAge: 1000 * 1000 * time.Hour}, f)
+	s, err := newMessageInstanceServer(dlog, vc, lf, nil, nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/messages/"+sid, nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	for _, want := range []string{"Alerts and Warnings", `href="/alerts/NO30006000000000000000000000000000"`, `href="/alerts?resource-sid=`+sid+`"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected body to contain %q, got %s", want, body)
+		}
+	}
+	if strings.Contains(body, `href="/alerts/NO1"`) {
+		t.Errorf("expected only the message's alerts, got %s", body)
+	}
+
+	us := config.AllUserSettings()
+	us.CanViewAlerts = false
+	req, _ = http.NewRequest("GET", "/messages/"+sid, nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "Alerts and Warnings") {
+		t.Error("expected alerts to be hidden from users who can't view them")
+	}
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"golang.org/x/net/context"
+)
+
+// Sections of the nav a page can be linked from.
+const (
+	// The left side of the navbar.
+	navMain = "main"
+	// The right side of the navbar.
+	navTools = "tools"
+	// The footer, for pages only some users can see, like /admin/grants.
+	navAdmin = "admin"
+)
+
+var sitemapKey ctxVar = 5
+
+// A sitePage is a page users can browse to. The navbar and the sitemap at
+// /nav only list the pages a user is allowed to see.
+type sitePage struct {
+	Name string
+	// The page's path, or a pattern like /messages/{sid} for pages about a
+	// single resource.
+	Path string
+	// Where the page is linked from, or empty if it's only in the sitemap.
+	Section string
+	// List pages keep the filters from the list being viewed.
+	List bool
+	// The feature that has to be on for the user, if any.
+	Feature string
+	// Reports whether u can see the page. If nil, every user can.
+	Allowed func(u *config.User) bool
+}
+
+func (p *sitePage) allows(u *config.User) bool {
+	if p.Feature != "" && !u.Feature(p.Feature) {
+		return false
+	}
+	return p.Allowed == nil || p.Allowed(u)
+}
+
+// sitemap is the pages newServer registered, in the order they're linked.
+type sitemap struct {
+	pages []*sitePage
+}
+
+func (s *sitemap) add(p *sitePage) {
+	s.pages = append(s.pages, p)
+}
+
+// For returns the pages u is allowed to see. A nil sitemap or user has none.
+func (s *sitemap) For(u *config.User) []*sitePage {
+	if s == nil || u == nil {
+		return nil
+	}
+	pages := make([]*sitePage, 0, len(s.pages))
+	for _, p := range s.pages {
+		if p.allows(u) {
+			pages = append(pages, p)
+		}
+	}
+	return pages
+}
+
+// withSitemap sets s in the context of every request, so the base template
+// can build the nav from it.
+func withSitemap(h http.Handler, s *sitemap) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), sitemapKey, s))
+		h.ServeHTTP(w, r)
+	})
+}
+
+// getSitemap returns the sitemap for the request, or nil if there isn't one.
+func getSitemap(r *http.Request) *sitemap {
+	s, _ := r.Context().Value(sitemapKey).(*sitemap)
+	return s
+}
+
+// A navLink is a link in one section of the nav.
+type navLink struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// True if the link is to the page being viewed.
+	Active bool `json:"-"`
+}
+
+type navSection struct {
+	Name  string     `json:"name"`
+	Links []*navLink `json:"links"`
+}
+
+type navRoute struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type navResponse struct {
+	// Paths are relative to this URL.
+	BaseURL string        `json:"base_url"`
+	Nav     []*navSection `json:"nav"`
+	// Every page the user can see, including the ones that aren't in the
+	// nav, like /messages/{sid}.
+	Routes []*navRoute `json:"routes"`
+}
+
+// navServer returns the nav and the pages the current user can see as JSON,
+// for portals that link to Logrole and want to leave out links their users
+// would get a 403 for.
+type navServer struct {
+	Sitemap *sitemap
+	BaseURL string
+}
+
+func (s *navServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	resp := &navResponse{
+		BaseURL: s.BaseURL,
+		Nav:     make([]*navSection, 0),
+		Routes:  make([]*navRoute, 0),
+	}
+	sections := make(map[string]*navSection)
+	for _, p := range s.Sitemap.For(u) {
+		resp.Routes = append(resp.Routes, &navRoute{Name: p.Name, Path: p.Path})
+		if p.Section == "" {
+			continue
+		}
+		section, ok := sections[p.Section]
+		if !ok {
+			section = &navSection{Name: p.Section, Links: make([]*navLink, 0)}
+			sections[p.Section] = section
+			resp.Nav = append(resp.Nav, section)
+		}
+		section.Links = append(section.Links, &navLink{Name: p.Name, URL: p.Path})
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(resp)
+}
+
+// siteOptions says which of the optional pages newServer registered.
+type siteOptions struct {
+	Conversations bool
+	A2P           bool
+	Scheduled     bool
+	Duplicates    bool
+	Profiling     bool
+	BreakGlass    *config.BreakGlass
+	Blocklist     *config.Blocklist
+	CacheHistory  bool
+}
+
+func canViewTraffic(u *config.User) bool {
+	return u.CanViewMessages() || u.CanViewCalls()
+}
+
+func canViewAny(u *config.User) bool {
+	return u.CanViewMessages() || u.CanViewCalls() || u.CanViewAlerts()
+}
+
+// newSitemap returns the pages users can browse to. Each page's Allowed
+// matches the check in its handler, so users aren't linked to pages that
+// would be forbidden.
+func newSitemap(o siteOptions) *sitemap {
+	s := new(sitemap)
+	s.add(&sitePage{Name: "Calls", Path: "/calls", Section: navMain, List: true, Allowed: (*config.User).CanViewCalls})
+	s.add(&sitePage{Name: "Conferences", Path: "/conferences", Section: navMain, List: true, Allowed: (*config.User).CanViewConferences})
+	s.add(&sitePage{Name: "Messages", Path: "/messages", Section: navMain, List: true, Allowed: (*config.User).CanViewMessages})
+	if o.Conversations {
+		s.add(&sitePage{Name: "Conversations", Path: "/conversations", Section: navMain, Feature: config.FeatureConversations, Allowed: (*config.User).CanViewMessages})
+	}
+	s.add(&sitePage{Name: "Phone Numbers", Path: "/phone-numbers", Section: navMain, List: true})
+	s.add(&sitePage{Name: "Alerts", Path: "/alerts", Section: navMain, List: true, Allowed: (*config.User).CanViewAlerts})
+
+	s.add(&sitePage{Name: "Dashboard", Path: "/dashboard", Section: navTools, Allowed: canViewAny})
+	s.add(&sitePage{Name: "Heatmap", Path: "/heatmap", Section: navTools, Allowed: canViewTraffic})
+	s.add(&sitePage{Name: "Traffic", Path: "/traffic", Section: navTools, Feature: config.FeatureTraffic, Allowed: canViewTraffic})
+	s.add(&sitePage{Name: "Campaigns", Path: "/messages/campaigns", Section: navTools, Feature: config.FeatureCampaigns, Allowed: (*config.User).CanViewMessages})
+	s.add(&sitePage{Name: "Queues", Path: "/queues", Section: navTools, Feature: config.FeatureQueues, Allowed: (*config.User).CanViewCalls})
+	if o.A2P {
+		s.add(&sitePage{Name: "A2P", Path: "/a2p", Section: navTools, Feature: config.FeatureA2P, Allowed: (*config.User).CanViewMessages})
+	}
+	s.add(&sitePage{Name: "Exports", Path: "/jobs", Section: navTools, Allowed: canViewAny})
+	s.add(&sitePage{Name: "Emergency access", Path: "/break-glass", Section: navTools, Allowed: func(u *config.User) bool {
+		return canBreakGlass(o.BreakGlass, u)
+	}})
+
+	s.add(&sitePage{Name: "Grants", Path: "/admin/grants", Section: navAdmin, Allowed: (*config.User).CanGrantPermissions})
+	s.add(&sitePage{Name: "Permissions", Path: "/admin/permissions", Section: navAdmin, Allowed: (*config.User).CanGrantPermissions})
+	s.add(&sitePage{Name: "Sessions", Path: "/admin/sessions", Section: navAdmin, Allowed: (*config.User).CanManageSessions})
+	s.add(&sitePage{Name: "View as", Path: "/admin/view-as", Section: navAdmin, Allowed: (*config.User).CanDebugPermissions})
+	if o.Blocklist != nil {
+		s.add(&sitePage{Name: "Blocklist", Path: "/admin/blocklist", Section: navAdmin, Allowed: o.Blocklist.Exempt})
+	}
+	s.add(&sitePage{Name: "Log levels", Path: "/admin/log-levels", Section: navAdmin, Allowed: (*config.User).CanReloadConfig})
+	if o.CacheHistory {
+		s.add(&sitePage{Name: "Cache history", Path: "/admin/cache", Section: navAdmin, Allowed: (*config.User).CanProfile})
+	}
+	if o.Profiling {
+		s.add(&sitePage{Name: "Runtime stats", Path: "/debug/vars", Section: navAdmin, Allowed: (*config.User).CanProfile})
+	}
+
+	s.add(&sitePage{Name: "Message", Path: "/messages/{sid}", Allowed: (*config.User).CanViewMessages})
+	s.add(&sitePage{Name: "Stuck Messages", Path: "/stuck-messages", Allowed: (*config.User).CanViewMessages})
+	if o.Scheduled {
+		s.add(&sitePage{Name: "Scheduled Messages", Path: "/messages/scheduled", Feature: config.FeatureScheduledMessages, Allowed: (*config.User).CanViewMessages})
+	}
+	if o.Duplicates {
+		s.add(&sitePage{Name: "Duplicate Messages", Path: "/messages/duplicates", Allowed: (*config.User).CanViewMessages})
+	}
+	if o.Conversations {
+		s.add(&sitePage{Name: "Conversation", Path: "/conversations/{sid}", Feature: config.FeatureConversations, Allowed: (*config.User).CanViewMessages})
+	}
+	s.add(&sitePage{Name: "Call", Path: "/calls/{sid}", Allowed: (*config.User).CanViewCalls})
+	s.add(&sitePage{Name: "Conference", Path: "/conferences/{sid}", Allowed: (*config.User).CanViewConferences})
+	s.add(&sitePage{Name: "Phone Number", Path: "/phone-numbers/{number}"})
+	s.add(&sitePage{Name: "Phone Number History", Path: "/phone-numbers/{number}/history"})
+	s.add(&sitePage{Name: "Phone Number Timeline", Path: "/phone-numbers/{number}/timeline", Allowed: canViewTraffic})
+	s.add(&sitePage{Name: "Phone Number Changes", Path: "/phone-numbers/changes"})
+	s.add(&sitePage{Name: "Alert", Path: "/alerts/{sid}", Allowed: (*config.User).CanViewAlerts})
+	s.add(&sitePage{Name: "Alert Trend", Path: "/alerts/trend", Allowed: (*config.User).CanViewAlerts})
+	s.add(&sitePage{Name: "Webhook Uptime", Path: "/alerts/uptime", Allowed: func(u *config.User) bool {
+		return u.CanViewAlerts() && u.CanViewCallbackURLs()
+	}})
+	s.add(&sitePage{Name: "Labels", Path: "/labels"})
+	s.add(&sitePage{Name: "Owners", Path: "/owners"})
+	s.add(&sitePage{Name: "Webhook Debugger", Path: "/debug/webhook", Allowed: (*config.User).CanViewCallbackURLs})
+	return s
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+func TestNavOnlyListsAllowedPages(t *testing.T) {
+	t.Parallel()
+	s := &navServer{Sitemap: newSitemap(siteOptions{}), BaseURL: "https://logrole.example.com"}
+	u := config.NewUser(&config.UserSettings{CanViewMessages: true})
+	req, _ := http.NewRequest("GET", "/nav", nil)
+	req = config.SetUser(req, u)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	resp := new(navResponse)
+	if err := json.NewDecoder(w.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.BaseURL != "https://logrole.example.com" {
+		t.Errorf("expected base URL to be set, got %q", resp.BaseURL)
+	}
+	routes := make(map[string]bool)
+	for _, r := range resp.Routes {
+		routes[r.Path] = true
+	}
+	for _, path := range []string{"/messages", "/messages/{sid}", "/phone-numbers"} {
+		if !routes[path] {
+			t.Errorf("expected %s in the routes, got %v", path, routes)
+		}
+	}
+	for _, path := range []string{"/calls", "/alerts", "/admin/grants", "/a2p"} {
+		if routes[path] {
+			t.Errorf("expected %s to be left out of the routes", path)
+		}
+	}
+	if len(resp.Nav) == 0 || resp.Nav[0].Name != navMain {
+		t.Fatalf("expected the main section first, got %v", resp.Nav)
+	}
+	for _, section := range resp.Nav {
+		if section.Name == navAdmin {
+			t.Errorf("expected no admin section for a user without admin permissions, got %v", section.Links)
+		}
+	}
+}
+
+func TestNavFeatureFlag(t *testing.T) {
+	t.Parallel()
+	s := newSitemap(siteOptions{Conversations: true})
+	u := config.NewUser(config.AllUserSettings())
+	found := false
+	for _, p := range s.For(u) {
+		found = found || p.Path == "/conversations"
+	}
+	if !found {
+		t.Errorf("expected conversations to be listed when the feature is on")
+	}
+	u = u.WithFeatures(config.Features{config.FeatureConversations: false})
+	for _, p := range s.For(u) {
+		if p.Path == "/conversations" {
+			t.Errorf("expected conversations to be left out when the feature is off")
+		}
+	}
+}
+
+func TestNavbarUsesSitemap(t *testing.T) {
+	t.Parallel()
+	settings := &config.Settings{
+		AllowUnencryptedTraffic: true,
+		Authenticator:           &config.NoopAuthenticator{User: config.NewUser(&config.UserSettings{CanViewCalls: true})},
+		SecretKey:               services.NewRandomKey(),
+		Logger:                  NullLogger,
+	}
+	s, err := NewServer(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "http://localhost:12345/", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	body := w.Body.String()
+	if !strings.Contains(body, `<a href="/calls">Calls</a>`) {
+		t.Errorf("expected a link to the call list, got %s", body)
+	}
+	if strings.Contains(body, `>Messages</a>`) {
+		t.Errorf("expected no link to messages for a user who can't view them, got %s", body)
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+// Show at most this many notes that match a search.
+const maxNoteSearchResults = 100
+
+// Searches shorter than this match too many notes to be useful.
+const minNoteQueryLength = 3
+
+// noteResourcePath returns the path of the message, call or alert with the
+// given sid, or the empty string if sid isn't one of those or u can't view
+// it.
+func noteResourcePath(u *config.User, sid string) string {
+	switch {
+	case smsSid.MatchString(sid):
+		if u.CanViewMessages() {
+			return "/messages/" + sid
+		}
+	case callSid.MatchString(sid):
+		if u.CanViewCalls() {
+			return "/calls/" + sid
+		}
+	case notificationSid.MatchString(sid):
+		if u.CanViewAlerts() {
+			return "/alerts/" + sid
+		}
+	}
+	return ""
+}
+
+type noteData struct {
+	Sid   string
+	Notes []*services.Note
+	Loc   *time.Location
+}
+
+// notesFor returns the notes on sid, or nil if u can't view notes.
+func notesFor(store *services.NoteStore, u *config.User, sid string, loc *time.Location) *noteData {
+	if store == nil || !u.CanViewNotes() {
+		return nil
+	}
+	return &noteData{Sid: sid, Notes: store.Get(sid), Loc: loc}
+}
+
+type noteServer struct {
+	log.Logger
+	Store *services.NoteStore
+	Audit *services.AuditLog
+}
+
+// POST /notes
+//
+// Attach a note to the message, call or alert with the given sid, then
+// redirect back to it. Notes are recorded in the audit log.
+func (s *noteServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewNotes() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to add notes"})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: "Could not parse form"})
+		return
+	}
+	sid := r.PostForm.Get("sid")
+	if !smsSid.MatchString(sid) && !callSid.MatchString(sid) && !notificationSid.MatchString(sid) {
+		rest.BadRequest(w, r, &rest.Error{Title: "Notes can only be added to messages, calls and alerts"})
+		return
+	}
+	path := noteResourcePath(u, sid)
+	if path == "" {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	note, err := s.Store.Add(sid, r.PostForm.Get("text"), u.ID())
+	if err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	s.Info("Added note", "sid", sid, "user", u.ID())
+	s.Audit.Record(&services.AuditEvent{
+		User:     u.ID(),
+		Action:   "add_note",
+		Resource: sid,
+		Details: map[string]string{
+			"note": note.Text,
+		},
+	})
+	http.Redirect(w, r, path, http.StatusFound)
+}
+
+type noteSearchServer struct {
+	log.Logger
+	Store          *services.NoteStore
+	LocationFinder services.LocationFinder
+	tpl            *template.Template
+}
+
+func newNoteSearchServer(l log.Logger, store *services.NoteStore, lf services.LocationFinder) (*noteSearchServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+noteSearchTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &noteSearchServer{
+		Logger:         l,
+		Store:          store,
+		LocationFinder: lf,
+		tpl:            tpl,
+	}, nil
+}
+
+type noteMatch struct {
+	*services.NoteMatch
+	// Where the note is attached, like "/messages/SM123".
+	Path string
+}
+
+type noteSearchData struct {
+	Query   string
+	Loc     *time.Location
+	Matches []*noteMatch
+	// More notes matched than are shown.
+	Full bool
+	Err  string
+}
+
+func (d *noteSearchData) Title() string {
+	return "Search Notes"
+}
+
+// GET /search/notes?q=<text>
+//
+// Find notes that contain the text, on messages, calls and alerts the user
+// can view.
+func (s *noteSearchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewNotes() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to view notes"})
+		return
+	}
+	data := &noteSearchData{
+		Query: strings.TrimSpace(r.URL.Query().Get("q")),
+		Loc:   s.LocationFinder.GetLocationReq(r),
+	}
+	code := http.StatusOK
+	switch {
+	case data.Query == "":
+	case len(data.Query) < minNoteQueryLength:
+		data.Err = fmt.Sprintf("Search for at least %d characters", minNoteQueryLength)
+		code = http.StatusBadRequest
+	default:
+		for _, match := range s.Store.Search(data.Query, maxNoteSearchResults+1) {
+			path := noteResourcePath(u, match.Sid)
+			if path == "" {
+				continue
+			}
+			if len(data.Matches) == maxNoteSearchResults {
+				data.Full = true
+				break
+			}
+			data.Matches = append(data.Matches, &noteMatch{NoteMatch: match, Path: path})
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
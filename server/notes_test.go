@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+func TestNoteServer(t *testing.T) {
+	t.Parallel()
+	store, _ := services.NewNoteStore("")
+	s := &noteServer{Logger: dlog, Store: store}
+	var tests = []struct {
+		sid  string
+		text string
+		user *config.User
+		code int
+	}{
+		{mms, "Escalated to carrier", config.DefaultUser, 302},
+		{mms, "   ", config.DefaultUser, 400},
+		{call, "Escalated to carrier", config.NewUser(&config.UserSettings{CanViewMessages: true, CanViewNotes: true}), 403},
+		{call, "Escalated to carrier", config.NewUser(&config.UserSettings{CanViewCalls: true}), 403},
+		{conference, "Escalated to carrier", config.DefaultUser, 400},
+	}
+	for _, tt := range tests {
+		body := url.Values{"sid": {tt.sid}, "text": {tt.text}}.Encode()
+		req, _ := http.NewRequest("POST", "/notes", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = config.SetUser(req, tt.user)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s %q: expected Code to be %d, got %d", tt.sid, tt.text, tt.code, w.Code)
+		}
+	}
+	if notes := store.Get(mms); len(notes) != 1 || notes[0].Text != "Escalated to carrier" {
+		t.Errorf("expected note to be recorded for %s, got %v", mms, notes)
+	}
+	if notes := store.Get(call); len(notes) != 0 {
+		t.Errorf("expected no note for %s, got %v", call, notes)
+	}
+}
+
+func TestNoteSearchHidesResourcesUserCantView(t *testing.T) {
+	t.Parallel()
+	store, _ := services.NewNoteStore("")
+	store.Add(mms, "Customer says it never arrived", "test")
+	store.Add(call, "Customer says the call dropped", "test")
+	s, err := newNoteSearchServer(dlog, store, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/search/notes?q=customer", nil)
+	req = config.SetUser(req, config.NewUser(&config.UserSettings{CanViewMessages: true, CanViewNotes: true}))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "/messages/"+mms) {
+		t.Errorf("expected a link to %s, got %s", mms, body)
+	}
+	if strings.Contains(body, call) || strings.Contains(body, "call dropped") {
+		t.Errorf("expected the note on %s to be hidden, got %s", call, body)
+	}
+
+	req, _ = http.NewRequest("GET", "/search/notes?q=customer", nil)
+	req = config.SetUser(req, config.NewUser(&config.UserSettings{CanViewMessages: true}))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected users who can't view notes to get 403, got %d", w.Code)
+	}
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+var numberHistoryRoute = regexp.MustCompile("^/phone-numbers/" + numberInstancePattern + "/history$")
+
+// How many days of message and call volume the history page shows.
+const numberHistoryDays = 14
+
+// Counting a busy number's traffic means walking a lot of pages, so stop
+// after this many pages in each direction and show the counts as lower
+// bounds.
+const maxNumberHistoryPages = 5
+
+// How long to reuse a number's volume before counting it again.
+const numberHistoryTimeout = 2 * time.Minute
+
+// A numberVolumeDay is the number of messages and calls to and from a number
+// on one day.
+type numberVolumeDay struct {
+	Date     time.Time
+	Messages int
+	Calls    int
+	// Percentages of the busiest day, for drawing bars.
+	MessagesWidth int
+	CallsWidth    int
+}
+
+// numberVolume is cached for each user, number and timezone.
+type numberVolume struct {
+	// Newest first.
+	Days []*numberVolumeDay
+	// True if we stopped counting before reaching the first day.
+	Truncated   bool
+	MessagesErr string
+	CallsErr    string
+}
+
+type numberHistoryServer struct {
+	log.Logger
+	Client         views.Client
+	LocationFinder services.LocationFinder
+	cache          *cache.Cache
+	tpl            *template.Template
+}
+
+func newNumberHistoryServer(l log.Logger, vc views.Client, lf services.LocationFinder) (*numberHistoryServer, error) {
+	s := &numberHistoryServer{
+		Logger:         l,
+		Client:         vc,
+		LocationFinder: lf,
+		cache:          cache.NewCache(100, l),
+	}
+	tpl, err := newTpl(template.FuncMap{}, base+numberHistoryTpl)
+	if err != nil {
+		return nil, err
+	}
+	s.tpl = tpl
+	return s, nil
+}
+
+type numberHistoryData struct {
+	PhoneNumber twilio.PhoneNumber
+	// Nil if the number isn't in this account.
+	Number    *views.IncomingNumber
+	Events    []*views.Event
+	EventsErr string
+	Volume    *numberVolume
+	Loc       *time.Location
+}
+
+func (d *numberHistoryData) Title() string {
+	return "History for " + d.PhoneNumber.Friendly()
+}
+
+// GET /phone-numbers/<number>/history
+//
+// Show when the number was purchased, changes to its configuration, and how
+// many messages and calls it has sent and received recently.
+func (s *numberHistoryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	pn := numberHistoryRoute.FindStringSubmatch(r.URL.Path)[1]
+	loc := s.LocationFinder.GetLocationReq(r)
+	start := monotime.Now()
+	ctx, cancel := getContext(r.Context(), 25*time.Second)
+	defer cancel()
+	number, err := s.Client.GetIncomingNumberByPN(ctx, u, pn)
+	switch err {
+	case nil:
+		break
+	case config.PermissionDenied, config.ErrTooOld:
+		rest.Forbidden(w, r, &rest.Error{Title: err.Error()})
+		return
+	default:
+		if rerr, ok := err.(*rest.Error); !ok || rerr.StatusCode != 404 {
+			rest.ServerError(w, r, err)
+			return
+		}
+		// A customer's number; there's no purchase date or configuration,
+		// but we can still count messages and calls.
+		number = nil
+	}
+	data := &numberHistoryData{
+		PhoneNumber: twilio.PhoneNumber(pn),
+		Number:      number,
+		Loc:         loc,
+	}
+	g, errctx := errgroup.WithContext(ctx)
+	if number != nil {
+		g.Go(func() error {
+			sid, err := number.Sid()
+			if err == nil {
+				data.Events, err = s.Client.GetResourceEvents(errctx, u, sid)
+			}
+			if err != nil {
+				data.EventsErr = cleanError(err)
+			}
+			return nil
+		})
+	}
+	var cachedAt uint64
+	g.Go(func() error {
+		key := "number-history:" + pn + ":" + loc.String() + ":" + u.ID()
+		volume := new(numberVolume)
+		var err error
+		cachedAt, err = s.cache.Get(key, volume)
+		if err != nil {
+			volume = s.countVolume(errctx, u, pn, time.Now().In(loc))
+			if volume.MessagesErr == "" && volume.CallsErr == "" {
+				s.cache.Set(key, volume, numberHistoryTimeout)
+			}
+			cachedAt = 0
+		}
+		data.Volume = volume
+		return nil
+	})
+	g.Wait()
+	bd := &baseData{
+		LF:       s.LocationFinder,
+		Duration: monotime.Since(start),
+		Data:     data,
+	}
+	if cachedAt > 0 {
+		bd.CachedDuration = monotime.Since(cachedAt)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := render(w, r, s.tpl, "base", bd); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// countVolume counts the messages and calls to and from pn on each of the
+// last numberHistoryDays days, ending with the day containing now.
+func (s *numberHistoryServer) countVolume(ctx context.Context, u *config.User, pn string, now time.Time) *numberVolume {
+	loc := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	first := today.AddDate(0, 0, -(numberHistoryDays - 1))
+	end := today.AddDate(0, 0, 1)
+	dayOf := func(t time.Time) string {
+		return t.In(loc).Format("2006-01-02")
+	}
+	// Each goroutine writes to its own map and truncated flag; nothing is
+	// read until g.Wait returns.
+	type direction struct {
+		field     string
+		messages  bool
+		counts    map[string]int
+		truncated bool
+		err       error
+	}
+	directions := make([]*direction, 0, 4)
+	if u.CanViewMessages() {
+		directions = append(directions,
+			&direction{field: "From", messages: true},
+			&direction{field: "To", messages: true})
+	}
+	if u.CanViewCalls() {
+		directions = append(directions,
+			&direction{field: "From"},
+			&direction{field: "To"})
+	}
+	g, errctx := errgroup.WithContext(ctx)
+	for _, d := range directions {
+		d := d
+		d.counts = make(map[string]int)
+		add := func(t time.Time) { d.counts[dayOf(t)]++ }
+		data := url.Values{}
+		data.Set(d.field, pn)
+		data.Set("PageSize", strconv.Itoa(dashboardPageSize))
+		g.Go(func() error {
+			if d.messages {
+				d.truncated, d.err = s.countMessages(errctx, u, first, end, data, add)
+			} else {
+				d.truncated, d.err = s.countCalls(errctx, u, first, end, data, add)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	volume := &numberVolume{Days: make([]*numberVolumeDay, 0, numberHistoryDays)}
+	for day := today; !day.Before(first); day = day.AddDate(0, 0, -1) {
+		volume.Days = append(volume.Days, &numberVolumeDay{Date: day})
+	}
+	for _, d := range directions {
+		if d.err != nil {
+			if d.messages {
+				volume.MessagesErr = cleanError(d.err)
+			} else {
+				volume.CallsErr = cleanError(d.err)
+			}
+			continue
+		}
+		volume.Truncated = volume.Truncated || d.truncated
+		for _, day := range volume.Days {
+			if d.messages {
+				day.Messages += d.counts[dayOf(day.Date)]
+			} else {
+				day.Calls += d.counts[dayOf(day.Date)]
+			}
+		}
+	}
+	busiest := 0
+	for _, day := range volume.Days {
+		if day.Messages > busiest {
+			busiest = day.Messages
+		}
+		if day.Calls > busiest {
+			busiest = day.Calls
+		}
+	}
+	if busiest > 0 {
+		for _, day := range volume.Days {
+			day.MessagesWidth = 100 * day.Messages / busiest
+			day.CallsWidth = 100 * day.Calls / busiest
+		}
+	}
+	return volume
+}
+
+func (s *numberHistoryServer) countMessages(ctx context.Context, u *config.User, start, end time.Time, data url.Values, add func(time.Time)) (bool, error) {
+	page, _, err := s.Client.GetMessagePageInRange(ctx, u, start, end, data)
+	for pages := 1; ; pages++ {
+		if err == twilio.NoMoreResults {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, message := range page.Messages() {
+			if created, err := message.DateCreated(); err == nil && created.Valid {
+				add(created.Time)
+			}
+		}
+		next := page.NextPageURI()
+		if !next.Valid {
+			return false, nil
+		}
+		if pages >= maxNumberHistoryPages {
+			return true, nil
+		}
+		page, _, err = s.Client.GetNextMessagePageInRange(ctx, u, start, end, next.String)
+	}
+}
+
+func (s *numberHistoryServer) countCalls(ctx context.Context, u *config.User, start, end time.Time, data url.Values, add func(time.Time)) (bool, error) {
+	page, _, err := s.Client.GetCallPageInRange(ctx, u, start, end, data)
+	for pages := 1; ; pages++ {
+		if err == twilio.NoMoreResults {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, call := range page.Calls() {
+			if created, err := call.DateCreated(); err == nil && created.Valid {
+				add(created.Time)
+			}
+		}
+		next := page.NextPageURI()
+		if !next.Valid {
+			return false, nil
+		}
+		if pages >= maxNumberHistoryPages {
+			return true, nil
+		}
+		page, _, err = s.Client.GetNextCallPageInRange(ctx, u, start, end, next.String)
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+func TestNumberHistoryCountsVolume(t *testing.T) {
+	t.Parallel()
+	created := twilio.TwilioTime{Valid: true, Time: time.Now().UTC()}
+	vc := harness.FixtureClient(harness.ViewHarness{}, &views.Fixtures{Messages: []*twilio.Message{
+		{Sid: "SM123", DateCreated: created, Status: twilio.StatusDelivered, From: "+14105551234", To: "+19253920364"},
+		{Sid: "SM124", DateCreated: created, Status: twilio.StatusReceived, From: "+19253920364", To: "+14105551234"},
+	}})
+	s, err := newNumberHistoryServer(NullLogger, vc, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/phone-numbers/+14105551234/history", nil)
+	req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "isn't in this account") {
+		t.Errorf("expected customer number to have no purchase history")
+	}
+	// One message to the number and one from it, both today.
+	if !strings.Contains(body, `<td>2<div class="volume-bar volume-bar-messages" style="width: 100%"></div></td>`) {
+		t.Errorf("expected today's message count in the volume table, got %s", body)
+	}
+}
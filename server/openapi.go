@@ -0,0 +1,205 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+)
+
+// An apiOperation is a GET endpoint that answers with JSON. The OpenAPI
+// document at /openapi.json describes the ones a user is allowed to call.
+type apiOperation struct {
+	// Name is the summary, and Path, Feature and Allowed match the handler's
+	// route and checks, like they do for the sitemap.
+	sitePage
+	// The operationId, and the name of the method in the client package.
+	ID     string
+	Params []apiParam
+	// A value of the type the handler encodes. The response schema is built
+	// from its fields and their json tags.
+	Response interface{}
+}
+
+type apiParam struct {
+	Name        string
+	Description string
+	Required    bool
+	// "date-time" for RFC 3339 times, otherwise empty.
+	Format string
+}
+
+var newerParams = []apiParam{
+	{Name: "after", Description: "Count resources created after this time.", Required: true, Format: "date-time"},
+	{Name: "from", Description: "Only count resources from this phone number."},
+	{Name: "to", Description: "Only count resources to this phone number."},
+	{Name: "country", Description: "Only count resources to or from this country, e.g. US."},
+	{Name: "team", Description: "Only count resources to or from numbers this team owns."},
+	{Name: "channel", Description: "Only count messages sent over this channel, e.g. whatsapp."},
+	{Name: "provider", Description: "Only count resources from this provider."},
+	{Name: "account", Description: "Only count resources in this subaccount."},
+}
+
+// newAPIOperations returns the JSON endpoints newServer registers. Each
+// operation's Allowed matches the check in its handler.
+func newAPIOperations() []*apiOperation {
+	return []*apiOperation{{
+		sitePage: sitePage{Name: "The pages the user can see", Path: "/nav"},
+		ID:       "Nav",
+		Response: navResponse{},
+	}, {
+		sitePage: sitePage{Name: "Alerts created in each part of a window", Path: "/alerts/trend", Allowed: (*config.User).CanViewAlerts},
+		ID:       "AlertTrend",
+		Params: []apiParam{
+			{Name: "log-level", Description: "Only count alerts with this log level, e.g. error."},
+			{Name: "resource-sid", Description: "Only count alerts about this call or message."},
+			{Name: "alert-start", Description: "The start of the window in the user's timezone, e.g. 2016-10-18T00:00. Defaults to three days before the end."},
+			{Name: "alert-end", Description: "The end of the window in the user's timezone. Defaults to now."},
+		},
+		Response: alertTrendResponse{},
+	}, {
+		sitePage: sitePage{Name: "Count messages newer than a time", Path: "/messages/newer", Feature: config.FeatureAutoRefresh, Allowed: (*config.User).CanViewMessages},
+		ID:       "NewerMessages",
+		Params:   newerParams,
+		Response: newerResponse{},
+	}, {
+		sitePage: sitePage{Name: "Count calls newer than a time", Path: "/calls/newer", Feature: config.FeatureAutoRefresh, Allowed: (*config.User).CanViewCalls},
+		ID:       "NewerCalls",
+		Params:   newerParams,
+		Response: newerResponse{},
+	}}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonSchema describes the JSON encoding of t. Types it doesn't know how to
+// describe get an empty schema, which allows any value.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		required := make([]string, 0)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			name := parts[0]
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = jsonSchema(f.Type)
+			if len(parts) == 1 || parts[1] != "omitempty" {
+				required = append(required, name)
+			}
+		}
+		return map[string]interface{}{"type": "object", "properties": props, "required": required}
+	}
+	return map[string]interface{}{}
+}
+
+func (o *apiOperation) document() map[string]interface{} {
+	params := make([]interface{}, len(o.Params))
+	for i, p := range o.Params {
+		schema := map[string]interface{}{"type": "string"}
+		if p.Format != "" {
+			schema["format"] = p.Format
+		}
+		params[i] = map[string]interface{}{
+			"name":        p.Name,
+			"in":          "query",
+			"description": p.Description,
+			"required":    p.Required,
+			"schema":      schema,
+		}
+	}
+	return map[string]interface{}{
+		"operationId": o.ID,
+		"summary":     o.Name,
+		"parameters":  params,
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "OK",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": jsonSchema(reflect.TypeOf(o.Response)),
+					},
+				},
+			},
+			"400": map[string]interface{}{"description": "A parameter is invalid"},
+			"403": map[string]interface{}{"description": "The user isn't allowed to call this operation"},
+		},
+	}
+}
+
+// openAPIDocument returns an OpenAPI 3 document describing ops. Paths are
+// relative to baseURL, if it's set.
+func openAPIDocument(ops []*apiOperation, baseURL string) map[string]interface{} {
+	paths := make(map[string]interface{})
+	for _, o := range ops {
+		paths[o.Path] = map[string]interface{}{"get": o.document()}
+	}
+	doc := map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":   "Logrole",
+			"version": Version,
+		},
+		"paths": paths,
+	}
+	if baseURL != "" {
+		doc["servers"] = []interface{}{map[string]interface{}{"url": baseURL}}
+	}
+	return doc
+}
+
+// openAPIServer returns an OpenAPI document for the JSON endpoints the
+// current user can call. The client package has a method for each
+// operation, named after its ID.
+type openAPIServer struct {
+	Operations []*apiOperation
+	BaseURL    string
+}
+
+func (s *openAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	ops := make([]*apiOperation, 0, len(s.Operations))
+	for _, o := range s.Operations {
+		if o.allows(u) {
+			ops = append(ops, o)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(openAPIDocument(ops, s.BaseURL))
+}
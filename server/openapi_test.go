@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/saintpete/logrole/client"
+	"github.com/saintpete/logrole/config"
+)
+
+func TestOpenAPIOnlyListsAllowedOperations(t *testing.T) {
+	t.Parallel()
+	s := &openAPIServer{Operations: newAPIOperations(), BaseURL: "https://logrole.example.com"}
+	u := config.NewUser(&config.UserSettings{CanViewMessages: true})
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	req = config.SetUser(req, u)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	var doc struct {
+		OpenAPI string                     `json:"openapi"`
+		Servers []struct{ URL string }     `json:"servers"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.0" {
+		t.Errorf("expected an OpenAPI 3 document, got %q", doc.OpenAPI)
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != "https://logrole.example.com" {
+		t.Errorf("expected the base URL in the servers, got %v", doc.Servers)
+	}
+	for _, path := range []string{"/nav", "/messages/newer"} {
+		if _, ok := doc.Paths[path]; !ok {
+			t.Errorf("expected %s in the paths, got %v", path, doc.Paths)
+		}
+	}
+	for _, path := range []string{"/alerts/trend", "/calls/newer"} {
+		if _, ok := doc.Paths[path]; ok {
+			t.Errorf("expected %s to be left out of the paths", path)
+		}
+	}
+}
+
+// The client's types have to encode the same way as the handlers' responses,
+// and it needs a method for each operation.
+func TestOpenAPIMatchesClient(t *testing.T) {
+	t.Parallel()
+	types := map[string]interface{}{
+		"Nav":           client.Nav{},
+		"AlertTrend":    client.AlertTrend{},
+		"NewerMessages": client.Newer{},
+		"NewerCalls":    client.Newer{},
+	}
+	c := reflect.TypeOf(new(client.Client))
+	for _, o := range newAPIOperations() {
+		if _, ok := c.MethodByName(o.ID); !ok {
+			t.Errorf("client has no method for %s", o.ID)
+		}
+		v, ok := types[o.ID]
+		if !ok {
+			t.Errorf("no client type for %s", o.ID)
+			continue
+		}
+		want := jsonSchema(reflect.TypeOf(o.Response))
+		if got := jsonSchema(reflect.TypeOf(v)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: client type doesn't match the response:\ngot  %v\nwant %v", o.ID, got, want)
+		}
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	t.Parallel()
+	schema := jsonSchema(reflect.TypeOf(navLink{}))
+	props := schema["properties"].(map[string]interface{})
+	if _, ok := props["Active"]; ok {
+		t.Error("expected fields tagged json:\"-\" to be left out")
+	}
+	if len(props) != 2 || props["url"] == nil {
+		t.Errorf("expected name and url properties, got %v", props)
+	}
+	schema = jsonSchema(reflect.TypeOf(alertTrendResponse{}))
+	for _, name := range schema["required"].([]string) {
+		if name == "bucket" || name == "error" {
+			t.Errorf("expected omitempty field %s not to be required", name)
+		}
+	}
+	props = schema["properties"].(map[string]interface{})
+	if f := props["computed_at"].(map[string]interface{})["format"]; f != "date-time" {
+		t.Errorf("expected times to be date-time strings, got %v", f)
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// ownerServer lists and edits the team and on-call rotation that own each
+// phone number. Owners are edited alongside labels, so changing them
+// requires the can_manage_labels permission.
+type ownerServer struct {
+	log.Logger
+	Owners         *services.OwnerStore
+	LocationFinder services.LocationFinder
+	tpl            *template.Template
+}
+
+func newOwnerServer(l log.Logger, owners *services.OwnerStore, lf services.LocationFinder) (*ownerServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+ownerListTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &ownerServer{
+		Logger:         l,
+		Owners:         owners,
+		LocationFinder: lf,
+		tpl:            tpl,
+	}, nil
+}
+
+type ownerListData struct {
+	Owners    []*services.Owner
+	Teams     []string
+	Query     string
+	CanManage bool
+	// Set after an import, to say how many owners were imported.
+	Imported int
+	Err      string
+}
+
+func (o *ownerListData) Title() string {
+	return "Phone Number Owners"
+}
+
+func (o *ownerListData) Path() string {
+	return "/owners"
+}
+
+func (s *ownerServer) renderList(w http.ResponseWriter, r *http.Request, u *config.User, code int, data *ownerListData) {
+	data.Owners = s.Owners.Search(data.Query)
+	data.Teams = s.Owners.Teams()
+	data.CanManage = u.CanManageLabels()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (s *ownerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	switch {
+	case r.URL.Path == "/owners/export":
+		s.export(w, r)
+	case r.Method == "GET":
+		s.renderList(w, r, u, http.StatusOK, &ownerListData{Query: r.URL.Query().Get("q")})
+	case !u.CanManageLabels():
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to change owners"})
+	case r.URL.Path == "/owners/import":
+		s.importCSV(w, r, u)
+	default:
+		s.update(w, r, u)
+	}
+}
+
+// POST /owners
+//
+// Make team the owner of phone_number, paged through rotation, or remove its
+// owner if delete is "true".
+func (s *ownerServer) update(w http.ResponseWriter, r *http.Request, u *config.User) {
+	if err := r.ParseForm(); err != nil {
+		s.renderList(w, r, u, http.StatusBadRequest, &ownerListData{Err: err.Error()})
+		return
+	}
+	pn := r.PostForm.Get("phone_number")
+	if r.PostForm.Get("delete") == "true" {
+		num, err := twilio.NewPhoneNumber(pn)
+		if err == nil {
+			err = s.Owners.Delete(num)
+		}
+		if err != nil {
+			s.renderList(w, r, u, http.StatusBadRequest, &ownerListData{Err: err.Error()})
+			return
+		}
+		s.Info("Deleted phone number owner", "user", u.ID(), "pn", num)
+	} else {
+		owner, err := s.Owners.Set(pn, r.PostForm.Get("team"), r.PostForm.Get("rotation"))
+		if err != nil {
+			s.renderList(w, r, u, http.StatusBadRequest, &ownerListData{Err: err.Error()})
+			return
+		}
+		s.Info("Set phone number owner", "user", u.ID(), "pn", owner.PhoneNumber, "team", owner.Team, "rotation", owner.Rotation)
+	}
+	http.Redirect(w, r, "/owners?q="+url.QueryEscape(r.PostForm.Get("q")), http.StatusFound)
+}
+
+// POST /owners/import
+//
+// Add the owners in the uploaded CSV file.
+func (s *ownerServer) importCSV(w http.ResponseWriter, r *http.Request, u *config.User) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLabelImportBytes)
+	f, _, err := r.FormFile("file")
+	if err != nil {
+		s.renderList(w, r, u, http.StatusBadRequest, &ownerListData{Err: "Choose a CSV file to import"})
+		return
+	}
+	defer f.Close()
+	n, err := s.Owners.Import(f)
+	if err != nil {
+		s.renderList(w, r, u, http.StatusBadRequest, &ownerListData{Err: fmt.Sprintf("Couldn't import owners: %v", err)})
+		return
+	}
+	s.Info("Imported phone number owners", "user", u.ID(), "count", n)
+	s.renderList(w, r, u, http.StatusOK, &ownerListData{Imported: n})
+}
+
+// GET /owners/export
+func (s *ownerServer) export(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="owners.csv"`)
+	if err := s.Owners.Export(w); err != nil {
+		s.Warn("Error exporting owners", "err", err)
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/test/harness"
+)
+
+func TestOwnersRequirePermission(t *testing.T) {
+	t.Parallel()
+	owners, _ := services.NewOwnerStore("")
+	s, err := newOwnerServer(dlog, owners, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := url.Values{"phone_number": {"+14155551234"}, "team": {"Support"}, "rotation": {"support-primary"}}.Encode()
+
+	us := config.AllUserSettings()
+	us.CanManageLabels = false
+	req, _ := http.NewRequest("POST", "/owners", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = config.SetUser(req, config.NewUser(us))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/owners", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = config.SetUser(req, config.DefaultUser)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 302 {
+		t.Errorf("expected Code to be 302, got %d", w.Code)
+	}
+	if o := owners.Get("+14155551234"); o == nil || o.Team != "Support" || o.Rotation != "support-primary" {
+		t.Errorf("expected owner to be saved, got %#v", o)
+	}
+
+	req, _ = http.NewRequest("GET", "/owners", nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Errorf("expected Code to be 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "support-primary") {
+		t.Error("expected rotation in the list")
+	}
+	if strings.Contains(w.Body.String(), "Save owner") {
+		t.Error("expected users who can't manage owners not to see the form")
+	}
+}
+
+func TestMessageListTeamFilter(t *testing.T) {
+	t.Parallel()
+	vc := harness.FixtureClient(harness.ViewHarness{MaxResourceAge: 1000 * 1000 * time.Hour}, errorSearchFixtures())
+	owners, _ := services.NewOwnerStore("")
+	owners.Set("+14105551234", "Support", "https://example.pagerduty.com/schedules/P123")
+	owners.Set("+14155550000", "Billing", "")
+	s, err := newMessageListServer(dlog, vc, lf, nil, owners, 50, 1000*1000*time.Hour, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(uri string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", uri, nil)
+		req = config.SetUser(req, theUser)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/messages?team=support")
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Owned by Support") || !strings.Contains(body, "https://example.pagerduty.com/schedules/P123") {
+		t.Errorf("expected the owner and rotation next to the number, got %s", body)
+	}
+	if !strings.Contains(body, `<option value="Support" selected>`) {
+		t.Errorf("expected the team filter to be selected, got %s", body)
+	}
+	if !strings.Contains(body, "SM30006") {
+		t.Errorf("expected messages to Support's number in the list, got %s", body)
+	}
+
+	w = get("/messages?team=Billing")
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "SM30006") {
+		t.Error("expected messages not involving Billing's numbers to be filtered out")
+	}
+
+	w = get("/messages?team=nobody")
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "No phone numbers are owned by team") {
+		t.Errorf("expected a 400 for an unknown team, got %d", w.Code)
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -9,29 +10,64 @@ import (
 
 	types "github.com/kevinburke/go-types"
 	twilio "github.com/saintpete/twilio-go"
+	"github.com/saintpete/logrole/config"
 	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
 )
 
 const HTML5DatetimeLocalFormat = "2006-01-02T15:04"
 
 // Code that's shared across list views
 
-func getEncryptedPage(npuri types.NullString, secretKey *[32]byte) string {
+// errNextScope is returned for a `next` token that was made for a different
+// group, or for a search over a different time range.
+var errNextScope = errors.New("This page link was made for a different group or time range. Go back to the first page of the search")
+
+// pageScope returns what the encrypted `next` tokens on a list are bound to:
+// the user's permission group, or the user if they aren't in one, and the
+// time range of the search, from the startParam and endParam query
+// parameters. getNext only decrypts a token for the scope it was made for, so
+// a token can't be replayed by someone in another group, or against a
+// different time range. Lists without a time range pass "" for both params.
+func pageScope(u *config.User, query url.Values, startParam, endParam string) string {
+	owner := "group:" + u.Group()
+	if u.Group() == "" {
+		owner = "user:" + u.ID()
+	}
+	var start, end string
+	if startParam != "" {
+		start = query.Get(startParam)
+	}
+	if endParam != "" {
+		end = query.Get(endParam)
+	}
+	return owner + "\n" + start + "\n" + end
+}
+
+// getEncryptedPage encrypts the URI of another page of results, bound to
+// scope.
+func getEncryptedPage(npuri types.NullString, scope string, secretKey *[32]byte) string {
 	if !npuri.Valid {
 		return ""
 	}
-	return services.Opaque(npuri.String, secretKey)
+	return services.OpaqueFor(npuri.String, scope, secretKey)
 }
 
-func getNext(query url.Values, secretKey *[32]byte) (string, error) {
+// getNext decrypts the `next` query parameter, which must have been made for
+// scope.
+func getNext(query url.Values, scope string, secretKey *[32]byte) (string, error) {
 	if query == nil {
 		return "", nil
 	}
-	if opaqueNext := query.Get("next"); opaqueNext == "" {
+	opaqueNext := query.Get("next")
+	if opaqueNext == "" {
 		return "", nil
-	} else {
-		return services.Unopaque(opaqueNext, secretKey)
 	}
+	next, err := services.UnopaqueFor(opaqueNext, scope, secretKey)
+	if err == services.ErrWrongOwner {
+		return "", errNextScope
+	}
+	return next, err
 }
 
 type errorRenderer interface {
@@ -42,6 +78,13 @@ func cleanError(err error) string {
 	if err == nil {
 		panic("called renderError with a nil error")
 	}
+	// The URL has the Account Sid in it.
+	if uerr, ok := err.(*url.Error); ok && (uerr.Err == services.ErrCallBudgetExceeded || uerr.Err == services.ErrTwilioBusy) {
+		err = uerr.Err
+	}
+	if rerr, ok := services.RateLimited(err); ok {
+		err = rerr
+	}
 	str := strings.Replace(err.Error(), "twilio: ", "", 1)
 	if strings.Contains(strings.ToLower(str), "aftersid is required for paging") {
 		str = str + " See https://github.com/saintpete/logrole/issues/2"
@@ -49,7 +92,20 @@ func cleanError(err error) string {
 	return str
 }
 
-func getTimes(w http.ResponseWriter, r *http.Request, startVal, endVal string, loc *time.Location, query url.Values, renderer errorRenderer) (time.Time, time.Time, bool) {
+// userMaxResourceAge returns the age of the oldest resource the user making r
+// can view, or globalMaxAge if there's no user.
+func userMaxResourceAge(r *http.Request, globalMaxAge time.Duration) time.Duration {
+	u, ok := config.GetUser(r)
+	if !ok {
+		return globalMaxAge
+	}
+	return u.MaxResourceAge(globalMaxAge)
+}
+
+// getTimes parses the start and end of the range to search. maxAge is the age
+// of the oldest resource the user can view; a start time before that is moved
+// forward, so we don't page through resources that would be hidden anyway.
+func getTimes(w http.ResponseWriter, r *http.Request, startVal, endVal string, loc *time.Location, maxAge time.Duration, query url.Values, renderer errorRenderer) (time.Time, time.Time, bool) {
 	var startTime, endTime time.Time
 	var err error
 	start := query.Get(startVal)
@@ -74,6 +130,14 @@ func getTimes(w http.ResponseWriter, r *http.Request, startVal, endVal string, l
 		}
 		endTime = endTime.In(loc)
 	}
+	if maxAge != 0 && maxAge < config.DefaultMaxResourceAge {
+		// Truncate so the start time, which is part of the cache key, only
+		// changes once an hour.
+		cutoff := time.Now().Add(-maxAge).Truncate(time.Hour)
+		if startTime.Before(cutoff) {
+			startTime = cutoff.In(loc)
+		}
+	}
 	return startTime, endTime, false
 }
 
@@ -91,6 +155,70 @@ func validateParams(params []string, query url.Values) error {
 	return nil
 }
 
+// getCountry validates the "country" query parameter, which limits a list to
+// resources with a From or To number in that country. Twilio can't filter by
+// country, so the filtering happens here, after each page is retrieved. The
+// country is derived from the numbers, so users who can't view either number
+// can't use the filter.
+func getCountry(query url.Values, canViewFrom, canViewTo bool) (string, error) {
+	country := strings.ToUpper(strings.TrimSpace(query.Get("country")))
+	if country == "" {
+		query.Del("country")
+		return "", nil
+	}
+	if !canViewFrom && !canViewTo {
+		query.Del("country")
+		return "", errors.New("You don't have permission to filter by country")
+	}
+	if !services.ValidCountryCode(country) {
+		query.Del("country")
+		return "", fmt.Errorf(`Unknown country code "%s", use a two letter code like "US"`, country)
+	}
+	query.Set("country", country)
+	return country, nil
+}
+
+// getTeam returns the team in the "team" query parameter, spelled the way it
+// is in owners, or the empty string if there isn't one.
+func getTeam(query url.Values, owners *services.OwnerStore, canViewFrom, canViewTo bool) (string, error) {
+	team := strings.TrimSpace(query.Get("team"))
+	if team == "" {
+		query.Del("team")
+		return "", nil
+	}
+	if !canViewFrom && !canViewTo {
+		query.Del("team")
+		return "", errors.New("You don't have permission to filter by team")
+	}
+	for _, t := range owners.Teams() {
+		if strings.EqualFold(t, team) {
+			query.Set("team", t)
+			return t, nil
+		}
+	}
+	query.Del("team")
+	return "", fmt.Errorf(`No phone numbers are owned by team "%s"`, team)
+}
+
+// getChannel returns the channel to filter messages by, like
+// services.ChannelWhatsApp, or the empty string if the query doesn't
+// specify one.
+func getChannel(query url.Values) (string, error) {
+	channel := strings.TrimSpace(query.Get("channel"))
+	if channel == "" {
+		query.Del("channel")
+		return "", nil
+	}
+	for _, c := range []string{services.ChannelSMS, services.ChannelWhatsApp} {
+		if strings.EqualFold(c, channel) {
+			query.Set("channel", c)
+			return c, nil
+		}
+	}
+	query.Del("channel")
+	return "", fmt.Errorf(`Unknown channel "%s", use SMS or WhatsApp`, channel)
+}
+
 // setNextPageValsOnQuery takes query values that have been sent to the Twilio
 // API, and sets them on the provided query object. We use this to populate the
 // search fields on the message/call search pages.
@@ -118,6 +246,12 @@ func setNextPageValsOnQuery(nextpageuri string, query url.Values) {
 	if level := nq.Get("LogLevel"); level != "" {
 		query.Set("log-level", level)
 	}
+	if provider := nq.Get("Provider"); provider != "" {
+		query.Set("provider", provider)
+	}
+	if account := nq.Get("Account"); account != "" {
+		query.Set("account", account)
+	}
 }
 
 // Reverse of the function above, with validation. Every list filter calls this
@@ -159,5 +293,31 @@ func setPageFilters(query url.Values, pageFilters url.Values) error {
 	if level := query.Get("log-level"); level != "" {
 		pageFilters.Set("LogLevel", level)
 	}
+	// for messages and calls from other providers
+	if provider := query.Get("provider"); provider != "" {
+		pageFilters.Set("Provider", provider)
+	}
+	// for messages, calls and alerts from subaccounts
+	if account := query.Get("account"); account != "" {
+		pageFilters.Set("Account", account)
+	}
+	return nil
+}
+
+// listAccounts returns the Twilio accounts vc can list messages, calls and
+// alerts from, or nil if there's only one.
+func listAccounts(vc views.Client) []*views.Account {
+	if al, ok := vc.(views.AccountLister); ok {
+		return al.Accounts()
+	}
+	return nil
+}
+
+// listProviders returns the providers vc can list messages and calls from,
+// or nil if they all come from Twilio.
+func listProviders(vc views.Client) []string {
+	if pl, ok := vc.(views.ProviderLister); ok {
+		return pl.Providers()
+	}
 	return nil
 }
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+// Reject permission files larger than this.
+const maxPolicyImportBytes = 1024 * 1024
+
+// permissionsServer exports the policy - every group, its users and what
+// they can do - and imports a new one, after showing what would change. It
+// requires the can_grant_permissions permission.
+type permissionsServer struct {
+	log.Logger
+	Policy *config.Policy
+	// Imported policies are written here. If empty, they can be previewed but
+	// not applied.
+	PolicyFile     string
+	Reloader       *Reloader
+	Audit          *services.AuditLog
+	LocationFinder services.LocationFinder
+	tpl            *template.Template
+}
+
+func newPermissionsServer(l log.Logger, policy *config.Policy, policyFile string, rl *Reloader, audit *services.AuditLog, lf services.LocationFinder) (*permissionsServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+permissionsTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &permissionsServer{
+		Logger:         l,
+		Policy:         policy,
+		PolicyFile:     policyFile,
+		Reloader:       rl,
+		Audit:          audit,
+		LocationFinder: lf,
+		tpl:            tpl,
+	}, nil
+}
+
+type permissionsData struct {
+	Groups []*config.Group
+	// Whether an import can be applied, or only previewed.
+	CanApply bool
+	Err      string
+
+	// Set when previewing an import.
+	Preview  bool
+	Changes  []string
+	Document string
+	// A hash of the policy the changes were worked out against, so they
+	// aren't applied if it changes in the meantime.
+	Base string
+	// Set if the import would take away the importer's ability to manage
+	// permissions.
+	Warning string
+}
+
+func (d *permissionsData) Title() string {
+	return "Permissions"
+}
+
+// policyHash identifies the policy p, so an import can tell whether it
+// changed since the import was previewed.
+func policyHash(p *config.Policy) (string, error) {
+	data, err := config.ExportPolicy(p)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (s *permissionsServer) render(w http.ResponseWriter, r *http.Request, code int, data *permissionsData) {
+	if s.Policy != nil {
+		data.Groups = *s.Policy
+	}
+	data.CanApply = s.PolicyFile != "" && s.Reloader != nil
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (s *permissionsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanGrantPermissions() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to manage permissions"})
+		return
+	}
+	switch r.URL.Path {
+	case "/admin/permissions/export":
+		s.export(w, r, u)
+	case "/admin/permissions/import":
+		s.preview(w, r, u)
+	case "/admin/permissions/apply":
+		s.apply(w, r, u)
+	default:
+		s.render(w, r, http.StatusOK, &permissionsData{})
+	}
+}
+
+// GET /admin/permissions/export
+//
+// Download the policy as a versioned YAML file.
+func (s *permissionsServer) export(w http.ResponseWriter, r *http.Request, u *config.User) {
+	data, err := config.ExportPolicy(s.Policy)
+	if err != nil {
+		rest.ServerError(w, r, err)
+		return
+	}
+	s.Info("Exported permissions", "user", u.ID())
+	w.Header().Set("Content-Type", "application/x-yaml; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="logrole-permissions.yml"`)
+	w.Write(data)
+}
+
+// POST /admin/permissions/import
+//
+// Validate the uploaded permission file and show how it differs from the
+// current policy, without changing anything.
+func (s *permissionsServer) preview(w http.ResponseWriter, r *http.Request, u *config.User) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxPolicyImportBytes)
+	f, _, err := r.FormFile("file")
+	if err != nil {
+		s.render(w, r, http.StatusBadRequest, &permissionsData{Err: "Choose a permission file to import"})
+		return
+	}
+	defer f.Close()
+	doc, err := ioutil.ReadAll(f)
+	if err != nil {
+		s.render(w, r, http.StatusBadRequest, &permissionsData{Err: err.Error()})
+		return
+	}
+	policy, err := config.ParsePolicyExport(doc)
+	if err != nil {
+		s.render(w, r, http.StatusBadRequest, &permissionsData{Err: err.Error()})
+		return
+	}
+	hash, err := policyHash(s.Policy)
+	if err != nil {
+		rest.ServerError(w, r, err)
+		return
+	}
+	data := &permissionsData{
+		Preview:  true,
+		Changes:  config.DiffPolicies(s.Policy, policy),
+		Document: string(doc),
+		Base:     hash,
+	}
+	if nu, _, err := policy.Lookup(u.ID()); err != nil || !nu.CanGrantPermissions() {
+		data.Warning = "After this import you won't be able to manage permissions. Someone will have to edit the policy file to give them back."
+	}
+	s.render(w, r, http.StatusOK, data)
+}
+
+// POST /admin/permissions/apply
+//
+// Write the previewed permission file over the policy file and reload the
+// config.
+func (s *permissionsServer) apply(w http.ResponseWriter, r *http.Request, u *config.User) {
+	if !u.CanReloadConfig() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You need can_reload_config to apply permissions"})
+		return
+	}
+	if s.PolicyFile == "" || s.Reloader == nil {
+		s.render(w, r, http.StatusBadRequest, &permissionsData{Err: "Permissions can only be imported if the policy is in a policy_file"})
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 2*maxPolicyImportBytes)
+	if err := r.ParseForm(); err != nil {
+		s.render(w, r, http.StatusBadRequest, &permissionsData{Err: err.Error()})
+		return
+	}
+	policy, err := config.ParsePolicyExport([]byte(r.PostForm.Get("document")))
+	if err != nil {
+		s.render(w, r, http.StatusBadRequest, &permissionsData{Err: err.Error()})
+		return
+	}
+	hash, err := policyHash(s.Policy)
+	if err != nil {
+		rest.ServerError(w, r, err)
+		return
+	}
+	if r.PostForm.Get("base") != hash {
+		s.render(w, r, http.StatusConflict, &permissionsData{Err: "The permissions changed since you previewed this import. Import the file again to see the new changes."})
+		return
+	}
+	changes := config.DiffPolicies(s.Policy, policy)
+	if err := config.WritePolicyFile(s.PolicyFile, policy); err != nil {
+		s.Warn("Couldn't write policy file", "path", s.PolicyFile, "err", err)
+		rest.ServerError(w, r, err)
+		return
+	}
+	s.Audit.Record(&services.AuditEvent{
+		User:     u.ID(),
+		Action:   "import_permissions",
+		Resource: s.PolicyFile,
+		Details: map[string]string{
+			"changes": strconv.Itoa(len(changes)),
+			"summary": strings.Join(changes, "; "),
+		},
+	})
+	if err := s.Reloader.Reload(); err != nil {
+		s.render(w, r, http.StatusBadRequest, &permissionsData{Err: "Wrote the policy file, but couldn't reload the config: " + err.Error()})
+		return
+	}
+	http.Redirect(w, r, "/admin/permissions", http.StatusFound)
+}
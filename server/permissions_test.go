@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+var permissionsAdmin = config.NewUser(config.AllUserSettings())
+
+func newTestPermissionsServer(t *testing.T, policyFile string, reloads *int) *permissionsServer {
+	policy := &config.Policy{
+		&config.Group{Name: "support", Users: []string{"alice"}, Permissions: config.AllUserSettings()},
+	}
+	var rl *Reloader
+	if reloads != nil {
+		dir, err := ioutil.TempDir("", "logrole-permissions-archive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		settings := &config.Settings{
+			AllowUnencryptedTraffic: true,
+			Authenticator:           &config.NoopAuthenticator{},
+			SecretKey:               key,
+			Logger:                  NullLogger,
+			Client:                  twilio.NewClient("AC123", "123", nil),
+			ArchiveDir:              dir,
+		}
+		rl, err = NewReloader(NullLogger, settings, func() (*config.Settings, error) {
+			*reloads++
+			return settings, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := newPermissionsServer(NullLogger, policy, policyFile, rl, nil, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func uploadPolicy(s *permissionsServer, doc string) *httptest.ResponseRecorder {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	fw, _ := mw.CreateFormFile("file", "permissions.yml")
+	fw.Write([]byte(doc))
+	mw.Close()
+	req, _ := http.NewRequest("POST", "/admin/permissions/import", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req = config.SetUser(req, permissionsAdmin)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func applyPolicy(s *permissionsServer, doc, base string) *httptest.ResponseRecorder {
+	data := url.Values{}
+	data.Set("document", doc)
+	data.Set("base", base)
+	req, _ := http.NewRequest("POST", "/admin/permissions/apply", strings.NewReader(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = config.SetUser(req, permissionsAdmin)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func TestPermissionsExportAndImport(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-permissions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.yml")
+	reloads := 0
+	s := newTestPermissionsServer(t, path, &reloads)
+
+	req, _ := http.NewRequest("GET", "/admin/permissions/export", nil)
+	req = config.SetUser(req, permissionsAdmin)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	doc := w.Body.String()
+	if !strings.Contains(doc, "version: 1") || !strings.Contains(doc, "alice") {
+		t.Fatalf("expected a versioned export with the users, got %s", doc)
+	}
+
+	doc = "version: 1\npolicy:\n  - name: support\n    users: [alice, bob]\n"
+	w = uploadPolicy(s, doc)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if body := w.Body.String(); !strings.Contains(body, "Add bob to group support") || !strings.Contains(body, "Apply Changes") {
+		t.Fatalf("expected a preview of the changes, got %s", body)
+	}
+	if reloads != 0 {
+		t.Errorf("expected a preview not to reload the config")
+	}
+
+	if w := applyPolicy(s, doc, "stale"); w.Code != http.StatusConflict {
+		t.Errorf("expected an outdated preview to get a 409, got %d", w.Code)
+	}
+	hash, err := policyHash(s.Policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = applyPolicy(s, doc, hash)
+	if w.Code != 302 {
+		t.Fatalf("expected Code to be 302, got %d: %s", w.Code, w.Body.String())
+	}
+	if reloads != 1 {
+		t.Errorf("expected the config to be reloaded once, got %d", reloads)
+	}
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := config.ParsePolicyExport(written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := policy.Lookup("bob"); !found {
+		t.Errorf("expected bob to be in the written policy file, got %s", written)
+	}
+}
+
+func TestPermissionsImportErrors(t *testing.T) {
+	t.Parallel()
+	s := newTestPermissionsServer(t, "", nil)
+	w := uploadPolicy(s, "policy:\n  - name: support\n")
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "no version") {
+		t.Errorf("expected a 400 for a file without a version, got %d: %s", w.Code, w.Body.String())
+	}
+	w = uploadPolicy(s, "version: 1\npolicy:\n  - name: support\n    users: [alice]\n  - name: billing\n    users: [alice]\n")
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "appears twice") {
+		t.Errorf("expected a 400 for an invalid policy, got %d: %s", w.Code, w.Body.String())
+	}
+	w = uploadPolicy(s, "version: 1\npolicy:\n  - name: support\n    users: [alice, bob]\n")
+	if w.Code != 200 || strings.Contains(w.Body.String(), "Apply Changes") {
+		t.Errorf("expected a preview that can't be applied without a policy file, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := applyPolicy(s, "version: 1", ""); w.Code != 400 {
+		t.Errorf("expected Code to be 400 without a policy file, got %d", w.Code)
+	}
+
+	us := config.AllUserSettings()
+	us.CanGrantPermissions = false
+	req, _ := http.NewRequest("GET", "/admin/permissions/export", nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+}
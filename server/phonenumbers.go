@@ -1,24 +1,22 @@
 package server
 
 import (
-	"context"
 	"errors"
 	"html/template"
 	"net/http"
 	"net/url"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aristanetworks/goarista/monotime"
 	log "github.com/inconshreveable/log15"
-	types "github.com/kevinburke/go-types"
 	"github.com/kevinburke/rest"
 	"github.com/saintpete/logrole/config"
 	"github.com/saintpete/logrole/services"
 	"github.com/saintpete/logrole/views"
 	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -39,7 +37,9 @@ type numberListServer struct {
 	MaxResourceAge time.Duration
 	LocationFinder services.LocationFinder
 	secretKey      *[32]byte
-	tpl            *template.Template
+	// nil if prefetching is disabled.
+	Prefetcher *prefetcher
+	tpl        *template.Template
 }
 
 func newNumberListServer(l log.Logger, vc views.Client,
@@ -132,7 +132,8 @@ func (s *numberListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := getContext(r.Context(), 3*time.Second)
 	defer cancel()
 	var err error
-	next, nextErr := getNext(query, s.secretKey)
+	scope := pageScope(u, query, "", "")
+	next, nextErr := getNext(query, scope, s.secretKey)
 	if nextErr != nil {
 		err = errors.New("Could not decrypt `next` query parameter: " + nextErr.Error())
 		s.renderError(w, r, http.StatusBadRequest, query, err)
@@ -148,10 +149,11 @@ func (s *numberListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		page, cachedAt, err = s.Client.GetNextNumberPage(ctx, u, next)
+		s.Prefetcher.Requested("phone-numbers", next)
 		setNextPageValsOnQuery(next, query)
 	} else {
 		vals := url.Values{}
-		vals.Set("PageSize", strconv.FormatUint(uint64(s.PageSize), 10))
+		vals.Set("PageSize", userPageSize(r, u, s.PageSize))
 		if filterErr := setPageFilters(query, vals); filterErr != nil {
 			s.renderError(w, r, http.StatusBadRequest, query, filterErr)
 			return
@@ -178,13 +180,12 @@ func (s *numberListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	go func(u *config.User, n types.NullString) {
-		if n.Valid {
-			if _, _, err := s.Client.GetNextNumberPage(context.Background(), u, n.String); err != nil {
-				s.Debug("Error fetching next page", "err", err)
-			}
-		}
-	}(u, page.NextPageURI())
+	if n := page.NextPageURI(); n.Valid {
+		s.Prefetcher.Prefetch(services.DetachCallBudget(r.Context()), "phone-numbers", n.String, func(ctx context.Context) error {
+			_, _, err := s.Client.GetNextNumberPage(ctx, u, n.String)
+			return err
+		})
+	}
 	data := &baseData{
 		LF:       s.LocationFinder,
 		Duration: monotime.Since(start),
@@ -192,8 +193,8 @@ func (s *numberListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			Page:                  page,
 			Query:                 query,
 			Loc:                   loc,
-			EncryptedNextPage:     getEncryptedPage(page.NextPageURI(), s.secretKey),
-			EncryptedPreviousPage: getEncryptedPage(page.PreviousPageURI(), s.secretKey),
+			EncryptedNextPage:     getEncryptedPage(page.NextPageURI(), scope, s.secretKey),
+			EncryptedPreviousPage: getEncryptedPage(page.PreviousPageURI(), scope, s.secretKey),
 		}}
 	if cachedAt > 0 {
 		data.CachedDuration = monotime.Since(cachedAt)
@@ -212,7 +213,7 @@ type numberInstanceServer struct {
 	tpl            *template.Template
 }
 
-func newNumberInstanceServer(l log.Logger, vc views.Client, lf services.LocationFinder) (*numberInstanceServer, error) {
+func newNumberInstanceServer(l log.Logger, vc views.Client, lf services.LocationFinder, labels *services.LabelStore, owners *services.OwnerStore) (*numberInstanceServer, error) {
 	s := &numberInstanceServer{
 		Logger:         l,
 		Client:         vc,
@@ -220,6 +221,8 @@ func newNumberInstanceServer(l log.Logger, vc views.Client, lf services.Location
 	}
 	tpl, err := newTpl(template.FuncMap{
 		"is_our_pn": vc.IsTwilioNumber,
+		"pn_label":  labels.Get,
+		"pn_owner":  owners.Get,
 	}, base+messageStatusTpl+messageSummaryTpl+callSummaryTpl+phoneTpl+
 		numberInstanceTpl+sidTpl+copyScript)
 	if err != nil {
@@ -247,6 +250,7 @@ type callPageLoc struct {
 }
 
 type numberInstanceData struct {
+	PhoneNumber  string
 	Number       *views.IncomingNumber
 	OwnNumber    bool
 	Loc          *time.Location
@@ -319,7 +323,8 @@ func (s *numberInstanceServer) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	g, errctx := errgroup.WithContext(ctx)
 	loc := s.LocationFinder.GetLocationReq(r)
 	innerData := &numberInstanceData{
-		Loc: loc,
+		PhoneNumber: pn,
+		Loc:         loc,
 	}
 	start := monotime.Now()
 	number, err := s.Client.GetIncomingNumberByPN(ctx, u, pn)
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+var messagesNewerRoute = regexp.MustCompile(`^/messages/newer$`)
+var callsNewerRoute = regexp.MustCompile(`^/calls/newer$`)
+
+// Hold a request open this long waiting for something new, then tell the
+// browser to ask again. Less than most proxies' idle timeouts.
+const newerTimeout = 25 * time.Second
+
+// How often to check for new resources while holding a request open. The
+// first page of a list is cached for longer than this, so most checks don't
+// reach Twilio.
+const newerInterval = 5 * time.Second
+
+// Only the top of the list matters.
+const newerPageSize = 20
+
+// newerServer tells list pages whether anything newer than the top of the
+// list exists, so they can refresh the table only when it's changed.
+type newerServer struct {
+	log.Logger
+	Client views.Client
+	// "messages" or "calls"
+	Resource string
+	// Used to filter by team. May be nil.
+	Owners   *services.OwnerStore
+	Timeout  time.Duration
+	Interval time.Duration
+}
+
+type newerResponse struct {
+	Newer bool `json:"newer"`
+	Count int  `json:"count"`
+}
+
+func newNewerServer(l log.Logger, vc views.Client, resource string, owners *services.OwnerStore) *newerServer {
+	return &newerServer{
+		Logger:   l,
+		Client:   vc,
+		Resource: resource,
+		Owners:   owners,
+		Timeout:  newerTimeout,
+		Interval: newerInterval,
+	}
+}
+
+func (s *newerServer) validParams() []string {
+	return []string{"after", "to", "from", "country", "team", "channel", "provider", "account"}
+}
+
+// count returns the number of resources matching data, country and team that
+// were created after after. Calls don't have a channel, so channel only
+// filters messages.
+func (s *newerServer) count(ctx context.Context, u *config.User, after time.Time, data url.Values, country, team, channel string) (int, error) {
+	var created []twilio.TwilioTime
+	if s.Resource == "calls" {
+		page, _, err := s.Client.GetCallPageInRange(ctx, u, after, twilio.HeatDeath, data)
+		if err != nil {
+			return 0, err
+		}
+		for _, c := range page.Calls() {
+			if t, err := c.DateCreated(); err == nil && (country == "" || c.InCountry(country)) && (team == "" || c.OwnedBy(s.Owners, team)) {
+				created = append(created, t)
+			}
+		}
+	} else {
+		page, _, err := s.Client.GetMessagePageInRange(ctx, u, after, twilio.HeatDeath, data)
+		if err != nil {
+			return 0, err
+		}
+		for _, m := range page.Messages() {
+			if channel != "" {
+				if c, err := m.Channel(); err != nil || c != channel {
+					continue
+				}
+			}
+			if t, err := m.DateCreated(); err == nil && (country == "" || m.InCountry(country)) && (team == "" || m.OwnedBy(s.Owners, team)) {
+				created = append(created, t)
+			}
+		}
+	}
+	count := 0
+	for _, t := range created {
+		if t.Time.After(after) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GET /messages/newer?after=<RFC3339 time>
+// GET /calls/newer?after=<RFC3339 time>
+//
+// Wait until there's a resource matching the list filters that was created
+// after "after", or the timeout passes, and say how many there are as JSON.
+func (s *newerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	canView, canViewFrom, canViewTo := u.CanViewMessages(), u.CanViewMessageFrom(), u.CanViewMessageTo()
+	if s.Resource == "calls" {
+		canView, canViewFrom, canViewTo = u.CanViewCalls(), u.CanViewCallFrom(), u.CanViewCallTo()
+	}
+	if !canView {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	query := r.URL.Query()
+	if err := validateParams(s.validParams(), query); err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	after, err := time.Parse(time.RFC3339, query.Get("after"))
+	if err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: "Invalid after time: " + strconv.Quote(query.Get("after"))})
+		return
+	}
+	country, err := getCountry(query, canViewFrom, canViewTo)
+	if err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	team, err := getTeam(query, s.Owners, canViewFrom, canViewTo)
+	if err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	channel, err := getChannel(query)
+	if err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	data := url.Values{}
+	data.Set("PageSize", strconv.Itoa(newerPageSize))
+	if err := setPageFilters(query, data); err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	resp := new(newerResponse)
+	for {
+		n, err := s.count(ctx, u, after, data, country, team, channel)
+		if err == twilio.NoMoreResults {
+			n, err = 0, nil
+		}
+		if err != nil && ctx.Err() == nil {
+			s.Warn("Error checking for newer resources", "url", r.URL.String(), "err", err)
+			rest.ServerError(w, r, err)
+			return
+		}
+		if n > 0 {
+			resp.Newer = true
+			resp.Count = n
+			break
+		}
+		timer := time.NewTimer(s.Interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+			continue
+		}
+		break
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// newerURL returns the URL a list page at path polls to find out if there's
+// anything newer than newest that matches the filters in query.
+func newerURL(path string, query url.Values, newest time.Time) string {
+	data := url.Values{}
+	for _, k := range []string{"from", "to", "country", "team", "channel", "provider", "account"} {
+		if v := query.Get(k); v != "" {
+			data.Set(k, v)
+		}
+	}
+	data.Set("after", newest.UTC().Format(time.RFC3339))
+	return path + "/newer?" + data.Encode()
+}
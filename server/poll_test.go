@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/test"
+	"github.com/saintpete/logrole/test/harness"
+)
+
+func newTestNewerServer(t *testing.T) (*newerServer, func()) {
+	server := newServerWithResponse(200, test.MessageBody)
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	s := newNewerServer(dlog, vc, "messages", nil)
+	s.Timeout = 50 * time.Millisecond
+	s.Interval = 10 * time.Millisecond
+	return s, server.Close
+}
+
+func getNewer(s *newerServer, after string, u *config.User) (*httptest.ResponseRecorder, *newerResponse) {
+	req, _ := http.NewRequest("GET", "/messages/newer?after="+url.QueryEscape(after), nil)
+	req = config.SetUser(req, u)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	resp := new(newerResponse)
+	if w.Code == 200 {
+		json.NewDecoder(w.Body).Decode(resp)
+	}
+	return w, resp
+}
+
+func TestNewerMessages(t *testing.T) {
+	t.Parallel()
+	s, cleanup := newTestNewerServer(t)
+	defer cleanup()
+	// The two newest messages in the response were created at 21:13:02.
+	w, resp := getNewer(s, "2016-10-20T21:13:01Z", theUser)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !resp.Newer || resp.Count != 2 {
+		t.Errorf("expected 2 newer messages, got %#v", resp)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("expected the response not to be cached, got %q", cc)
+	}
+
+	w, resp = getNewer(s, "2016-10-20T21:13:02Z", theUser)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if resp.Newer || resp.Count != 0 {
+		t.Errorf("expected nothing newer, got %#v", resp)
+	}
+}
+
+func TestNewerErrors(t *testing.T) {
+	t.Parallel()
+	s, cleanup := newTestNewerServer(t)
+	defer cleanup()
+	w, _ := getNewer(s, "yesterday", theUser)
+	if w.Code != 400 {
+		t.Errorf("expected a bad after time to get a 400, got %d", w.Code)
+	}
+	us := config.AllUserSettings()
+	us.CanViewMessages = false
+	w, _ = getNewer(s, "2016-10-20T21:13:01Z", config.NewUser(us))
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+}
+
+func TestNewerURL(t *testing.T) {
+	t.Parallel()
+	query := url.Values{}
+	query.Set("from", "+19253920364")
+	query.Set("start", "2016-10-01")
+	newest := time.Date(2016, 10, 20, 14, 13, 2, 0, time.FixedZone("PDT", -7*3600))
+	want := "/messages/newer?after=2016-10-20T21%3A13%3A02Z&from=%2B19253920364"
+	if u := newerURL("/messages", query, newest); u != want {
+		t.Errorf("newerURL: got %q, want %q", u, want)
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/saintpete/logrole/config"
+)
+
+// The cookie that stores the user's theme.
+const themeCookie = "theme"
+
+// The themes a user can choose from. The first one is the default.
+var themes = []string{"default", "high-contrast"}
+
+func validTheme(theme string) bool {
+	for _, t := range themes {
+		if t == theme {
+			return true
+		}
+	}
+	return false
+}
+
+// getTheme returns the theme the user chose, or the default theme if they
+// haven't chosen one.
+func getTheme(r *http.Request) string {
+	cookie, err := r.Cookie(themeCookie)
+	if err != nil || !validTheme(cookie.Value) {
+		return themes[0]
+	}
+	return cookie.Value
+}
+
+// The cookie that stores how many rows the user wants on a list page.
+const pageSizeCookie = "page_size"
+
+// The page sizes a user can choose from.
+var pageSizes = []uint{25, 50, 100}
+
+func validPageSize(size uint) bool {
+	for _, s := range pageSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// getPageSize returns the page size the user chose, or 0 if they haven't
+// chosen one.
+func getPageSize(r *http.Request) uint {
+	cookie, err := r.Cookie(pageSizeCookie)
+	if err != nil {
+		return 0
+	}
+	size, err := strconv.ParseUint(cookie.Value, 10, 64)
+	if err != nil || !validPageSize(uint(size)) {
+		return 0
+	}
+	return uint(size)
+}
+
+// userPageSize returns the number of rows to ask Twilio for on a list page:
+// the page size the user chose, or def if they haven't chosen one, lowered to
+// their group's max_page_size and to Twilio's maximum.
+func userPageSize(r *http.Request, u *config.User, def uint) string {
+	size := getPageSize(r)
+	if size == 0 {
+		size = def
+	}
+	if u != nil && u.MaxPageSize() > 0 && size > u.MaxPageSize() {
+		size = u.MaxPageSize()
+	}
+	if size > config.MaxPageSize {
+		size = config.MaxPageSize
+	}
+	return strconv.FormatUint(uint64(size), 10)
+}
+
+// pageSizeChoices returns the page sizes u can choose from.
+func pageSizeChoices(u *config.User) []uint {
+	choices := make([]uint, 0, len(pageSizes))
+	for _, size := range pageSizes {
+		if u == nil || u.MaxPageSize() == 0 || size <= u.MaxPageSize() {
+			choices = append(choices, size)
+		}
+	}
+	return choices
+}
+
+// preferencesServer saves display preferences in a cookie, the same way
+// tzServer saves the timezone.
+type preferencesServer struct {
+	log.Logger
+	AllowUnencryptedTraffic bool
+}
+
+// POST /preferences
+//
+// Set theme to one of the values in themes, or page_size to one of the
+// values in pageSizes, and redirect back to the page in g. An empty page_size
+// goes back to the default.
+func (p *preferencesServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		p.Warn("Error parsing form on preferences page", "err", err)
+		http.Redirect(w, r, "/", 302)
+		return
+	}
+	if _, ok := r.PostForm["theme"]; ok {
+		if theme := r.PostForm.Get("theme"); validTheme(theme) {
+			p.setCookie(w, themeCookie, theme)
+		} else {
+			p.Warn("Could not set theme on request", "theme", theme)
+		}
+	}
+	if _, ok := r.PostForm["page_size"]; ok {
+		val := r.PostForm.Get("page_size")
+		size, err := strconv.ParseUint(val, 10, 64)
+		switch {
+		case val == "":
+			http.SetCookie(w, &http.Cookie{Name: pageSizeCookie, Path: "/", MaxAge: -1})
+		case err == nil && validPageSize(uint(size)):
+			p.setCookie(w, pageSizeCookie, val)
+		default:
+			p.Warn("Could not set page size on request", "page_size", val)
+		}
+	}
+	u, err := url.Parse(r.PostForm.Get("g"))
+	if err == nil && u.Path != "" {
+		http.Redirect(w, r, u.Path, 302)
+		return
+	}
+	http.Redirect(w, r, "/", 302)
+}
+
+func (p *preferencesServer) setCookie(w http.ResponseWriter, name, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Secure:   p.AllowUnencryptedTraffic == false,
+		HttpOnly: true,
+		MaxAge:   60 * 60 * 24 * 365,
+	})
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+func TestSetTheme(t *testing.T) {
+	t.Parallel()
+	p := &preferencesServer{Logger: NullLogger}
+	req, _ := http.NewRequest("POST", "/preferences", strings.NewReader("theme=high-contrast&g=/calls"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	if w.Code != 302 {
+		t.Fatalf("expected Code to be 302, got %d", w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "/calls" {
+		t.Errorf("expected redirect to /calls, got %q", loc)
+	}
+	cookie := w.Header().Get("Set-Cookie")
+	if !strings.HasPrefix(cookie, "theme=high-contrast;") || !strings.Contains(cookie, "Secure") {
+		t.Errorf("expected a secure theme cookie, got %q", cookie)
+	}
+
+	req, _ = http.NewRequest("POST", "/preferences", strings.NewReader("theme=neon"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	if cookie := w.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("expected an unknown theme not to be saved, got %q", cookie)
+	}
+}
+
+func TestAccessiblePage(t *testing.T) {
+	t.Parallel()
+	settings := &config.Settings{
+		AllowUnencryptedTraffic: true,
+		Authenticator:           &config.NoopAuthenticator{},
+		SecretKey:               services.NewRandomKey(),
+		Logger:                  NullLogger,
+	}
+	s, err := NewServer(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "http://localhost:12345/", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	body := w.Body.String()
+	for _, want := range []string{
+		`<html class="no-js" lang="en">`,
+		`<a class="skip-link" href="#main-content">`,
+		`<main id="main-content"`,
+		`href="/" aria-current="page"`,
+		`aria-pressed="false"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected body to contain %q, got %s", want, body)
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "http://localhost:12345/", nil)
+	req.AddCookie(&http.Cookie{Name: themeCookie, Value: "high-contrast"})
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	body = w.Body.String()
+	if !strings.Contains(body, `<html class="no-js theme-high-contrast" lang="en">`) {
+		t.Errorf("expected high contrast theme from cookie, got %s", body)
+	}
+	if !strings.Contains(body, `aria-pressed="true"`) {
+		t.Errorf("expected theme toggle to be pressed, got %s", body)
+	}
+}
+
+func TestSetPageSize(t *testing.T) {
+	t.Parallel()
+	p := &preferencesServer{Logger: NullLogger}
+	req, _ := http.NewRequest("POST", "/preferences", strings.NewReader("page_size=100&g=/messages"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	if w.Code != 302 {
+		t.Fatalf("expected Code to be 302, got %d", w.Code)
+	}
+	if cookie := w.Header().Get("Set-Cookie"); !strings.HasPrefix(cookie, "page_size=100;") {
+		t.Errorf("expected a page_size cookie, got %q", cookie)
+	}
+
+	req, _ = http.NewRequest("POST", "/preferences", strings.NewReader("page_size=7"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	if cookie := w.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("expected an unknown page size not to be saved, got %q", cookie)
+	}
+
+	req, _ = http.NewRequest("POST", "/preferences", strings.NewReader("page_size="))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	if cookie := w.Header().Get("Set-Cookie"); !strings.Contains(cookie, "Max-Age=0") {
+		t.Errorf("expected an empty page size to clear the cookie, got %q", cookie)
+	}
+}
+
+func TestUserPageSize(t *testing.T) {
+	t.Parallel()
+	us := config.AllUserSettings()
+	us.MaxPageSize = 50
+	limited := config.NewUser(us)
+	all := config.NewUser(config.AllUserSettings())
+	tests := []struct {
+		cookie string
+		u      *config.User
+		want   string
+	}{
+		{"", all, "20"},
+		{"100", all, "100"},
+		{"100", limited, "50"},
+		{"25", limited, "25"},
+		{"garbage", all, "20"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/messages", nil)
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: pageSizeCookie, Value: tt.cookie})
+		}
+		if got := userPageSize(req, tt.u, 20); got != tt.want {
+			t.Errorf("userPageSize(%q): got %s, want %s", tt.cookie, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"golang.org/x/net/context"
+)
+
+// Prefetches beyond this many wait for no one; they're dropped, and the page
+// is fetched when someone asks for it.
+const prefetchQueueSize = 100
+
+// How long a prefetched page can wait to be requested before it counts as a
+// miss. Next pages stay in the API cache about this long.
+const prefetchHitWindow = 5 * time.Minute
+
+// The most prefetched pages we remember while waiting to see if they're
+// requested.
+const maxPrefetchPending = 1000
+
+const prefetchTimeout = 30 * time.Second
+
+type pendingPrefetch struct {
+	Resource  string
+	FetchedAt time.Time
+}
+
+type prefetchTask struct {
+	Resource string
+	NextPage string
+	// Not canceled when the request that queued the task finishes.
+	ctx   context.Context
+	fetch func(context.Context) error
+}
+
+// prefetchStats are the counts for one kind of resource.
+type prefetchStats struct {
+	Queued  int64 `json:"queued"`
+	Dropped int64 `json:"dropped"`
+	Fetched int64 `json:"fetched"`
+	Failed  int64 `json:"failed"`
+	// Prefetched pages that were requested within prefetchHitWindow.
+	Hits int64 `json:"hits"`
+	// Prefetched pages that weren't.
+	Misses int64 `json:"misses"`
+	// Prefetched pages that might still be requested.
+	Pending int `json:"pending"`
+	// Hits / (Hits + Misses), or zero if there haven't been any.
+	HitRate float64 `json:"hit_rate"`
+}
+
+type prefetchReport struct {
+	Enabled       bool                      `json:"enabled"`
+	Workers       int                       `json:"workers"`
+	QueueDepth    int                       `json:"queue_depth"`
+	MaxQueueDepth int                       `json:"max_queue_depth"`
+	Resources     map[string]*prefetchStats `json:"resources"`
+}
+
+// prefetcher fetches the next page of a list into the cache with a fixed
+// number of workers, and keeps track of whether anyone wanted the page. A nil
+// prefetcher drops every task, which is how prefetching is disabled.
+type prefetcher struct {
+	log.Logger
+	Workers int
+
+	queue chan *prefetchTask
+
+	mu       sync.Mutex
+	maxDepth int
+	stats    map[string]*prefetchStats
+	// Prefetched pages that haven't been requested yet, keyed by resource
+	// and next page.
+	pending map[string]pendingPrefetch
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newPrefetcher(l log.Logger, workers int) *prefetcher {
+	return &prefetcher{
+		Logger:  l,
+		Workers: workers,
+		queue:   make(chan *prefetchTask, prefetchQueueSize),
+		stats:   make(map[string]*prefetchStats),
+		pending: make(map[string]pendingPrefetch),
+		done:    make(chan struct{}),
+	}
+}
+
+func pendingKey(resource, nextPage string) string {
+	return resource + "|" + nextPage
+}
+
+func (p *prefetcher) statsFor(resource string) *prefetchStats {
+	st, ok := p.stats[resource]
+	if !ok {
+		st = new(prefetchStats)
+		p.stats[resource] = st
+	}
+	return st
+}
+
+// Prefetch queues fetch to load nextPage of resource into the cache. It never
+// blocks; if the queue is full, the page isn't prefetched. ctx should outlive
+// the request - see services.DetachCallBudget.
+func (p *prefetcher) Prefetch(ctx context.Context, resource, nextPage string, fetch func(context.Context) error) {
+	if p == nil {
+		return
+	}
+	t := &prefetchTask{Resource: resource, NextPage: nextPage, ctx: ctx, fetch: fetch}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := p.statsFor(resource)
+	select {
+	case p.queue <- t:
+		st.Queued++
+		if depth := len(p.queue); depth > p.maxDepth {
+			p.maxDepth = depth
+		}
+	default:
+		st.Dropped++
+	}
+}
+
+// Requested records that someone asked for nextPage of resource, so a
+// prefetch of that page counts as a hit.
+func (p *prefetcher) Requested(resource, nextPage string) {
+	if p == nil {
+		return
+	}
+	key := pendingKey(resource, nextPage)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.pending[key]; ok {
+		delete(p.pending, key)
+		p.statsFor(resource).Hits++
+	}
+}
+
+// expire counts pages that have waited longer than prefetchHitWindow as
+// misses. Call it with p.mu held.
+func (p *prefetcher) expire(now time.Time) {
+	for key, pp := range p.pending {
+		if now.Sub(pp.FetchedAt) > prefetchHitWindow {
+			delete(p.pending, key)
+			p.statsFor(pp.Resource).Misses++
+		}
+	}
+}
+
+func (p *prefetcher) run(t *prefetchTask) {
+	ctx, cancel := context.WithTimeout(t.ctx, prefetchTimeout)
+	defer cancel()
+	err := t.fetch(ctx)
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := p.statsFor(t.Resource)
+	if err != nil {
+		st.Failed++
+		p.Debug("Error fetching next page", "resource", t.Resource, "err", err)
+		return
+	}
+	st.Fetched++
+	p.expire(now)
+	if len(p.pending) < maxPrefetchPending {
+		p.pending[pendingKey(t.Resource, t.NextPage)] = pendingPrefetch{Resource: t.Resource, FetchedAt: now}
+	}
+}
+
+// Run starts the workers, and returns when Stop is called. Tasks still in the
+// queue are dropped.
+func (p *prefetcher) Run() {
+	var wg sync.WaitGroup
+	for i := 0; i < p.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-p.done:
+					return
+				case t := <-p.queue:
+					p.run(t)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *prefetcher) Stop() {
+	p.stopOnce.Do(func() { close(p.done) })
+}
+
+// Report returns the current queue depth and the counts for each resource.
+func (p *prefetcher) Report() *prefetchReport {
+	report := &prefetchReport{Resources: make(map[string]*prefetchStats)}
+	if p == nil {
+		return report
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expire(time.Now())
+	report.Enabled = true
+	report.Workers = p.Workers
+	report.QueueDepth = len(p.queue)
+	report.MaxQueueDepth = p.maxDepth
+	for resource, st := range p.stats {
+		st2 := *st
+		report.Resources[resource] = &st2
+	}
+	for _, pp := range p.pending {
+		report.Resources[pp.Resource].Pending++
+	}
+	for _, st := range report.Resources {
+		if st.Hits+st.Misses > 0 {
+			st.HitRate = float64(st.Hits) / float64(st.Hits+st.Misses)
+		}
+	}
+	return report
+}
+
+type prefetchServer struct {
+	Prefetcher *prefetcher
+}
+
+// GET /debug/prefetch
+//
+// Show the prefetch queue depth and hit rates as JSON. Requires can_profile.
+func (s *prefetchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanProfile() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to profile the server"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s.Prefetcher.Report())
+}
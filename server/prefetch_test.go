@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"golang.org/x/net/context"
+)
+
+func TestPrefetchHitRate(t *testing.T) {
+	t.Parallel()
+	p := newPrefetcher(NullLogger, 1)
+	fetch := func(ctx context.Context) error { return nil }
+	p.Prefetch(context.Background(), "messages", "/page/2", fetch)
+	p.Prefetch(context.Background(), "messages", "/page/3", fetch)
+	p.Prefetch(context.Background(), "calls", "/page/2", func(ctx context.Context) error {
+		return errors.New("boom")
+	})
+	if depth := p.Report().QueueDepth; depth != 3 {
+		t.Errorf("expected 3 queued tasks, got %d", depth)
+	}
+	for i := 0; i < 3; i++ {
+		p.run(<-p.queue)
+	}
+	p.Requested("messages", "/page/2")
+	// Not prefetched, so it's neither a hit nor a miss.
+	p.Requested("messages", "/page/9")
+	// Pretend /page/3 was prefetched long ago.
+	p.mu.Lock()
+	key := pendingKey("messages", "/page/3")
+	pp := p.pending[key]
+	pp.FetchedAt = pp.FetchedAt.Add(-2 * prefetchHitWindow)
+	p.pending[key] = pp
+	p.mu.Unlock()
+
+	report := p.Report()
+	msgs := report.Resources["messages"]
+	if msgs.Queued != 2 || msgs.Fetched != 2 || msgs.Hits != 1 || msgs.Misses != 1 || msgs.Pending != 0 {
+		t.Errorf("unexpected message stats: %+v", msgs)
+	}
+	if msgs.HitRate != 0.5 {
+		t.Errorf("expected hit rate of 0.5, got %f", msgs.HitRate)
+	}
+	if calls := report.Resources["calls"]; calls.Failed != 1 || calls.Fetched != 0 {
+		t.Errorf("unexpected call stats: %+v", calls)
+	}
+	if report.MaxQueueDepth != 3 {
+		t.Errorf("expected max queue depth of 3, got %d", report.MaxQueueDepth)
+	}
+}
+
+func TestPrefetchDropsWhenFull(t *testing.T) {
+	t.Parallel()
+	p := newPrefetcher(NullLogger, 1)
+	fetch := func(ctx context.Context) error { return nil }
+	for i := 0; i < prefetchQueueSize+5; i++ {
+		p.Prefetch(context.Background(), "alerts", "/next", fetch)
+	}
+	st := p.Report().Resources["alerts"]
+	if st.Queued != prefetchQueueSize || st.Dropped != 5 {
+		t.Errorf("expected %d queued and 5 dropped, got %+v", prefetchQueueSize, st)
+	}
+}
+
+func TestPrefetchRun(t *testing.T) {
+	t.Parallel()
+	p := newPrefetcher(NullLogger, 2)
+	done := make(chan struct{})
+	go func() {
+		p.Run()
+		close(done)
+	}()
+	fetched := make(chan string, 1)
+	p.Prefetch(context.Background(), "conferences", "/next", func(ctx context.Context) error {
+		fetched <- "conferences"
+		return nil
+	})
+	select {
+	case <-fetched:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for prefetch")
+	}
+	p.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after Stop")
+	}
+}
+
+func TestPrefetchDisabled(t *testing.T) {
+	t.Parallel()
+	var p *prefetcher
+	p.Prefetch(context.Background(), "messages", "/next", func(ctx context.Context) error {
+		t.Error("disabled prefetcher shouldn't fetch anything")
+		return nil
+	})
+	p.Requested("messages", "/next")
+	s := &prefetchServer{Prefetcher: p}
+	req, _ := http.NewRequest("GET", "/debug/prefetch", nil)
+	req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	var report prefetchReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Enabled {
+		t.Error("expected prefetching to be reported as disabled")
+	}
+}
+
+func TestPrefetchServerForbidden(t *testing.T) {
+	t.Parallel()
+	us := config.AllUserSettings()
+	us.CanProfile = false
+	s := &prefetchServer{Prefetcher: newPrefetcher(NullLogger, 1)}
+	req, _ := http.NewRequest("GET", "/debug/prefetch", nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected users without can_profile to get a 403, got %d", w.Code)
+	}
+}
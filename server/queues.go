@@ -0,0 +1,397 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+var queueCallbackRoute = regexp.MustCompile(`^/webhooks/queues$`)
+
+// How long to reuse queue analytics. New events arrive all the time, so this
+// is short.
+const queueStatsTimeout = time.Minute
+
+// How many recently abandoned calls to list.
+const maxAbandonedCalls = 20
+
+// The periods a user can look at, ending now.
+var queuePeriods = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+// Upper bounds of the wait time histogram buckets. Waits longer than the last
+// go in one more bucket.
+var queueWaitBuckets = []time.Duration{
+	30 * time.Second,
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+}
+
+// queueCallbackServer records the results Twilio sends to an <Enqueue> action
+// URL. Like capture URLs, it's not behind authentication, so it only accepts
+// requests signed with the account's auth token.
+type queueCallbackServer struct {
+	log.Logger
+	Store     *services.QueueStore
+	AuthToken string
+	// Scheme and host Twilio sends callbacks to. If empty, the host of the
+	// request is used.
+	BaseURL string
+}
+
+// POST /webhooks/queues
+//
+// Record a caller leaving a queue. If the URL has a "next" parameter, the call
+// is redirected there, so the action URL can still return TwiML.
+func (s *queueCallbackServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	signedURL := requestBaseURL(r, s.BaseURL) + r.URL.RequestURI()
+	sig := services.CheckTwilioSignature(s.AuthToken, signedURL, r.PostForm, r.Header.Get("X-Twilio-Signature"))
+	if sig != services.SignatureValid {
+		s.Warn("Rejected queue callback", "signature", sig)
+		rest.Forbidden(w, r, &rest.Error{Title: "Invalid X-Twilio-Signature"})
+		return
+	}
+	e, err := services.NewQueueEvent(r.PostForm, time.Now())
+	if err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	if err := s.Store.Add(e); err != nil {
+		s.Error("Couldn't save queue event", "call", e.CallSid, "err", err)
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	next := r.URL.Query().Get("next")
+	if u, err := url.Parse(next); next == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		io.WriteString(w, emptyTwiML)
+		return
+	}
+	io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Response><Redirect>`)
+	template.HTMLEscape(w, []byte(next))
+	io.WriteString(w, `</Redirect></Response>`)
+}
+
+// queueStats summarize the callers who left one queue, or every queue.
+type queueStats struct {
+	QueueSid string
+	// Every caller who tried to join the queue.
+	Entered int
+	// Callers who were connected to someone.
+	Answered int
+	// Callers who hung up while waiting.
+	Abandoned int
+	// Callers who left with <Leave> or were redirected.
+	Left int
+	// Callers who never joined, because the queue was full or there was an
+	// error.
+	Rejected int
+
+	AvgAnswerWait    time.Duration
+	MedianAnswerWait time.Duration
+	P90AnswerWait    time.Duration
+	MaxAnswerWait    time.Duration
+	AvgAbandonWait   time.Duration
+	// Answered callers in each of queueWaitBuckets, plus one more for longer
+	// waits.
+	WaitHistogram []int
+}
+
+// AbandonmentRate returns the percentage of callers who joined the queue and
+// hung up before they were answered.
+func (q queueStats) AbandonmentRate() string {
+	waited := q.Answered + q.Abandoned + q.Left
+	if waited == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(q.Abandoned)/float64(waited))
+}
+
+// A queuePeriod is one row of the breakdown over time - an hour or a day.
+type queuePeriod struct {
+	Start time.Time
+	Stats queueStats
+}
+
+// queueReport is cached for each user, period, queue and timezone.
+type queueReport struct {
+	Total      queueStats
+	Queues     []*queueStats
+	Breakdown  []*queuePeriod
+	Abandoned  []*services.QueueEvent
+	Start      time.Time
+	End        time.Time
+	ComputedAt time.Time
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+
+type queuesByEntered []*queueStats
+
+func (q queuesByEntered) Len() int      { return len(q) }
+func (q queuesByEntered) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q queuesByEntered) Less(i, j int) bool {
+	if q[i].Entered != q[j].Entered {
+		return q[i].Entered > q[j].Entered
+	}
+	return q[i].QueueSid < q[j].QueueSid
+}
+
+// summarizeQueue fills in stats from the events for one queue, or period.
+func summarizeQueue(stats *queueStats, events []*services.QueueEvent) {
+	var answerWaits durations
+	var abandonWait time.Duration
+	stats.WaitHistogram = make([]int, len(queueWaitBuckets)+1)
+	for _, e := range events {
+		stats.Entered++
+		switch e.Result {
+		case services.QueueResultBridged:
+			stats.Answered++
+			answerWaits = append(answerWaits, e.Wait)
+			bucket := sort.Search(len(queueWaitBuckets), func(i int) bool {
+				return e.Wait < queueWaitBuckets[i]
+			})
+			stats.WaitHistogram[bucket]++
+		case services.QueueResultHangup:
+			stats.Abandoned++
+			abandonWait += e.Wait
+		case services.QueueResultLeave, services.QueueResultRedirected:
+			stats.Left++
+		default:
+			stats.Rejected++
+		}
+	}
+	if stats.Abandoned > 0 {
+		stats.AvgAbandonWait = abandonWait / time.Duration(stats.Abandoned)
+	}
+	if len(answerWaits) == 0 {
+		return
+	}
+	sort.Sort(answerWaits)
+	var total time.Duration
+	for _, d := range answerWaits {
+		total += d
+	}
+	stats.AvgAnswerWait = total / time.Duration(len(answerWaits))
+	stats.MedianAnswerWait = answerWaits[len(answerWaits)/2]
+	stats.P90AnswerWait = answerWaits[len(answerWaits)*9/10]
+	stats.MaxAnswerWait = answerWaits[len(answerWaits)-1]
+}
+
+// buildQueueReport aggregates events, which must be sorted oldest first, into
+// totals, per-queue stats and a breakdown by step, starting at start.
+func buildQueueReport(events []*services.QueueEvent, start, end time.Time, step time.Duration, now time.Time) *queueReport {
+	report := &queueReport{Start: start, End: end, ComputedAt: now}
+	summarizeQueue(&report.Total, events)
+	byQueue := make(map[string][]*services.QueueEvent)
+	for _, e := range events {
+		byQueue[e.QueueSid] = append(byQueue[e.QueueSid], e)
+	}
+	for sid, queueEvents := range byQueue {
+		stats := &queueStats{QueueSid: sid}
+		summarizeQueue(stats, queueEvents)
+		report.Queues = append(report.Queues, stats)
+	}
+	sort.Sort(queuesByEntered(report.Queues))
+	i := 0
+	for periodStart := start; periodStart.Before(end); periodStart = periodStart.Add(step) {
+		periodEnd := periodStart.Add(step)
+		j := i
+		for j < len(events) && events[j].Time.Before(periodEnd) {
+			j++
+		}
+		period := &queuePeriod{Start: periodStart}
+		summarizeQueue(&period.Stats, events[i:j])
+		report.Breakdown = append(report.Breakdown, period)
+		i = j
+	}
+	for k := len(events) - 1; k >= 0 && len(report.Abandoned) < maxAbandonedCalls; k-- {
+		if events[k].Result == services.QueueResultHangup {
+			report.Abandoned = append(report.Abandoned, events[k])
+		}
+	}
+	return report
+}
+
+// queueServer shows wait times and abandonment rates for the account's
+// queues. It requires the can_view_calls permission.
+type queueServer struct {
+	log.Logger
+	Store          *services.QueueStore
+	LocationFinder services.LocationFinder
+	MaxResourceAge time.Duration
+	// Whether queue callbacks can be received at all.
+	Enabled bool
+	cache   *cache.Cache
+	tpl     *template.Template
+}
+
+func newQueueServer(l log.Logger, store *services.QueueStore, lf services.LocationFinder, maxResourceAge time.Duration, enabled bool) (*queueServer, error) {
+	tpl, err := newTpl(template.FuncMap{
+		"wait": friendlyWait,
+	}, base+queueTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &queueServer{
+		Logger:         l,
+		Store:          store,
+		LocationFinder: lf,
+		MaxResourceAge: maxResourceAge,
+		Enabled:        enabled,
+		cache:          cache.NewCache(100, l),
+		tpl:            tpl,
+	}, nil
+}
+
+// friendlyWait rounds d to the second, like "2m5s".
+func friendlyWait(d time.Duration) string {
+	return (d / time.Second * time.Second).String()
+}
+
+type queueData struct {
+	*queueReport
+	Period string
+	Queue  string
+	Loc    *time.Location
+	// Whether each breakdown row is an hour, instead of a day.
+	Hourly     bool
+	Enabled    bool
+	WaitLabels []string
+	Err        string
+}
+
+func (d *queueData) Title() string {
+	return "Queue Wait Times"
+}
+
+func (d *queueData) Path() string {
+	return "/queues"
+}
+
+func (s *queueServer) validParams() []string {
+	return []string{"period", "queue"}
+}
+
+func waitLabels() []string {
+	labels := make([]string, 0, len(queueWaitBuckets)+1)
+	lower := "0s"
+	for _, b := range queueWaitBuckets {
+		labels = append(labels, lower+" - "+b.String())
+		lower = b.String()
+	}
+	return append(labels, "over "+lower)
+}
+
+func (s *queueServer) render(w http.ResponseWriter, r *http.Request, code int, bd *baseData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", bd); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (s *queueServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewCalls() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	start := monotime.Now()
+	loc := s.LocationFinder.GetLocationReq(r)
+	query := r.URL.Query()
+	data := &queueData{
+		queueReport: new(queueReport),
+		Period:      query.Get("period"),
+		Queue:       query.Get("queue"),
+		Loc:         loc,
+		Enabled:     s.Enabled,
+		WaitLabels:  waitLabels(),
+	}
+	if data.Period == "" {
+		data.Period = "day"
+	}
+	length, ok := queuePeriods[data.Period]
+	err := validateParams(s.validParams(), query)
+	if err == nil && !ok {
+		err = fmt.Errorf(`Unknown period "%s"`, data.Period)
+	}
+	if err != nil {
+		data.Err = cleanError(err)
+		s.render(w, r, http.StatusBadRequest, &baseData{LF: s.LocationFinder, Data: data})
+		return
+	}
+	data.Hourly = length <= 24*time.Hour
+	key := "queues:" + data.Period + ":" + data.Queue + ":" + loc.String() + ":" + u.ID()
+	if _, err := s.cache.Get(key, data.queueReport); err != nil {
+		data.queueReport = s.report(u, data.Queue, time.Now().In(loc), length)
+		s.cache.Set(key, data.queueReport, queueStatsTimeout)
+	}
+	s.render(w, r, http.StatusOK, &baseData{
+		LF:       s.LocationFinder,
+		Duration: monotime.Since(start),
+		Data:     data,
+	})
+}
+
+// report aggregates the events u can see for the length of time before now,
+// for one queue, or every queue if queueSid is empty.
+func (s *queueServer) report(u *config.User, queueSid string, now time.Time, length time.Duration) *queueReport {
+	loc := now.Location()
+	step := 24 * time.Hour
+	var start time.Time
+	if length <= 24*time.Hour {
+		step = time.Hour
+		start = now.Truncate(time.Hour).Add(time.Hour - length)
+	} else {
+		days := int(length / (24 * time.Hour))
+		start = time.Date(now.Year(), now.Month(), now.Day()-days+1, 0, 0, 0, 0, loc)
+	}
+	var events []*services.QueueEvent
+	for _, e := range s.Store.Between(start, now) {
+		if queueSid != "" && e.QueueSid != queueSid {
+			continue
+		}
+		if !u.CanViewResource(e.Time, s.MaxResourceAge) {
+			continue
+		}
+		events = append(events, e)
+	}
+	// Break the range into days in the user's timezone, which aren't always
+	// 24 hours long.
+	report := buildQueueReport(events, start, now, step, now)
+	if step == 24*time.Hour {
+		for i, period := range report.Breakdown {
+			period.Start = time.Date(start.Year(), start.Month(), start.Day()+i, 0, 0, 0, 0, loc)
+		}
+	}
+	return report
+}
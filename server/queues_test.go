@@ -0,0 +1,120 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+func TestQueueCallback(t *testing.T) {
+	t.Parallel()
+	store, _ := services.NewQueueStore("")
+	s := &queueCallbackServer{Logger: NullLogger, Store: store, AuthToken: "12345", BaseURL: "https://logrole.example.com"}
+	form := url.Values{"CallSid": {"CA123"}, "QueueSid": {"QU123"}, "QueueResult": {"bridged"}, "QueueTime": {"42"}}
+	path := "/webhooks/queues?next=" + url.QueryEscape("https://example.com/after?a=1&b=2")
+	sig := services.TwilioSignature("12345", "https://logrole.example.com"+path, form)
+	for _, signature := range []string{"bogus", sig} {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Twilio-Signature", signature)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if signature == "bogus" {
+			if w.Code != 403 {
+				t.Errorf("expected an invalid signature to be rejected, got %d", w.Code)
+			}
+			continue
+		}
+		if w.Code != 200 {
+			t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), "<Redirect>https://example.com/after?a=1&amp;b=2</Redirect>") {
+			t.Errorf("expected a redirect to next, got %s", w.Body.String())
+		}
+	}
+	if store.Len() != 1 {
+		t.Errorf("expected one queue event, got %d", store.Len())
+	}
+}
+
+func TestBuildQueueReport(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2016, 11, 1, 0, 0, 0, 0, time.UTC)
+	event := func(hour int, queue, result string, wait time.Duration) *services.QueueEvent {
+		return &services.QueueEvent{Time: start.Add(time.Duration(hour) * time.Hour), CallSid: "CA" + queue, QueueSid: queue, Result: result, Wait: wait}
+	}
+	events := []*services.QueueEvent{
+		event(0, "QU1", services.QueueResultBridged, 10*time.Second),
+		event(0, "QU1", services.QueueResultBridged, 50*time.Second),
+		event(1, "QU1", services.QueueResultHangup, 3*time.Minute),
+		event(1, "QU2", services.QueueResultBridged, 12*time.Minute),
+		event(2, "QU2", services.QueueResultQueueFull, 0),
+	}
+	r := buildQueueReport(events, start, start.Add(3*time.Hour), time.Hour, start.Add(3*time.Hour))
+	total := r.Total
+	if total.Entered != 5 || total.Answered != 3 || total.Abandoned != 1 || total.Rejected != 1 {
+		t.Errorf("unexpected totals: %+v", total)
+	}
+	if rate := total.AbandonmentRate(); rate != "25.0%" {
+		t.Errorf("expected abandonment rate of 25.0%%, got %s", rate)
+	}
+	if total.MedianAnswerWait != 50*time.Second || total.MaxAnswerWait != 12*time.Minute || total.AvgAbandonWait != 3*time.Minute {
+		t.Errorf("unexpected waits: %+v", total)
+	}
+	if want := []int{1, 1, 0, 0, 0, 1}; len(total.WaitHistogram) != len(want) || total.WaitHistogram[0] != 1 || total.WaitHistogram[1] != 1 || total.WaitHistogram[5] != 1 {
+		t.Errorf("expected histogram %v, got %v", want, total.WaitHistogram)
+	}
+	if len(r.Queues) != 2 || r.Queues[0].QueueSid != "QU1" || r.Queues[0].Entered != 3 {
+		t.Errorf("expected QU1 first with 3 callers, got %+v", r.Queues)
+	}
+	if len(r.Breakdown) != 3 || r.Breakdown[0].Stats.Entered != 2 || r.Breakdown[1].Stats.Entered != 2 || r.Breakdown[2].Stats.Rejected != 1 {
+		t.Errorf("unexpected hourly breakdown: %+v", r.Breakdown)
+	}
+	if len(r.Abandoned) != 1 || r.Abandoned[0].CallSid != "CAQU1" {
+		t.Errorf("expected one abandoned call, got %v", r.Abandoned)
+	}
+}
+
+func TestQueuePage(t *testing.T) {
+	t.Parallel()
+	store, _ := services.NewQueueStore("")
+	store.Add(&services.QueueEvent{Time: time.Now().Add(-time.Minute), CallSid: "CA999", QueueSid: "QU1", Result: services.QueueResultHangup, Wait: 2 * time.Minute})
+	s, err := newQueueServer(NullLogger, store, lf, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/queues?period=week", nil)
+	req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if body := w.Body.String(); !strings.Contains(body, `href="/calls/CA999"`) || !strings.Contains(body, "100.0%") {
+		t.Errorf("expected the abandoned call and rate, got %s", body)
+	}
+
+	req, _ = http.NewRequest("GET", "/queues?period=year", nil)
+	req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Errorf("expected an unknown period to be a 400, got %d", w.Code)
+	}
+
+	us := config.AllUserSettings()
+	us.CanViewCalls = false
+	req, _ = http.NewRequest("GET", "/queues", nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	"golang.org/x/net/context"
+)
+
+var recordingDownloadRoute = regexp.MustCompile(`^/recordings/download$`)
+
+// The most recordings a single download can include. Narrow the date range
+// to get more.
+const maxDownloadRecordings = 500
+
+// How long to wait for each recording. The download as a whole isn't bound
+// by the request timeout, since it can take several minutes to stream.
+const recordingDownloadTimeout = 30 * time.Second
+
+// recordingFetcher returns the contents and Content-Type of the recording at
+// u.
+type recordingFetcher func(ctx context.Context, u *url.URL) ([]byte, string, error)
+
+// recordingDownloadServer streams every recording for a call, conference or
+// date range to the user as a zip file.
+type recordingDownloadServer struct {
+	log.Logger
+	Client         views.Client
+	Fetch          recordingFetcher
+	Audit          *services.AuditLog
+	LocationFinder services.LocationFinder
+	// Stop adding recordings once the zip holds this many bytes of them.
+	MaxBytes  int64
+	secretKey *[32]byte
+}
+
+// recordingManifest is written to manifest.json at the end of the zip file.
+type recordingManifest struct {
+	Scope       string                    `json:"scope"`
+	User        string                    `json:"user"`
+	GeneratedAt time.Time                 `json:"generated_at"`
+	Recordings  []*recordingManifestEntry `json:"recordings"`
+	// Recordings left out because the download hit the size cap.
+	Skipped   []string `json:"skipped,omitempty"`
+	Truncated bool     `json:"truncated"`
+}
+
+type recordingManifestEntry struct {
+	Sid         string    `json:"sid"`
+	CallSid     string    `json:"call_sid"`
+	DateCreated time.Time `json:"date_created"`
+	// In seconds.
+	Duration int    `json:"duration"`
+	File     string `json:"file,omitempty"`
+	Bytes    int    `json:"bytes"`
+	SHA256   string `json:"sha256,omitempty"`
+	// Set if the recording couldn't be downloaded.
+	Error string `json:"error,omitempty"`
+}
+
+// GET /recordings/download?call=CA123
+// GET /recordings/download?conference=CF123
+// GET /recordings/download?start=2016-10-01&end=2016-10-07
+//
+// Download the recordings as a zip file, along with a manifest.json listing
+// each recording's sid, duration and checksum. Dates are in the user's
+// timezone, and both days are included.
+func (s *recordingDownloadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanDownloadRecordings() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to download recordings"})
+		return
+	}
+	query := r.URL.Query()
+	if err := validateParams([]string{"call", "conference", "start", "end"}, query); err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	data, scope, filter, err := s.recordingFilter(query, s.LocationFinder.GetLocationReq(r))
+	if err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	ctx, cancel := getContext(r.Context(), 20*time.Second)
+	recordings, err := s.listRecordings(ctx, u, data, filter)
+	cancel()
+	if err != nil {
+		switch err {
+		case config.PermissionDenied, config.ErrTooOld:
+			rest.Forbidden(w, r, &rest.Error{Title: err.Error()})
+		default:
+			rest.ServerError(w, r, err)
+		}
+		return
+	}
+	if len(recordings) == 0 {
+		rest.NotFound(w, r)
+		return
+	}
+	if len(recordings) > maxDownloadRecordings {
+		rest.BadRequest(w, r, &rest.Error{
+			Title: fmt.Sprintf("More than %d recordings match, choose a shorter date range", maxDownloadRecordings),
+		})
+		return
+	}
+	s.Audit.Record(&services.AuditEvent{
+		User:     u.ID(),
+		Action:   "download_recordings",
+		Resource: scope,
+		Details:  map[string]string{"count": strconv.Itoa(len(recordings))},
+	})
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="recordings-%s.zip"`, strings.Replace(scope, ":", "-", -1)))
+	manifest := &recordingManifest{
+		Scope:       scope,
+		User:        u.ID(),
+		GeneratedAt: time.Now().UTC(),
+		Recordings:  make([]*recordingManifestEntry, 0, len(recordings)),
+	}
+	if err := s.writeZip(w, u, manifest, recordings); err != nil {
+		// The headers are gone, so all we can do is stop; the client sees a
+		// truncated zip file.
+		s.Warn("Error streaming recordings", "scope", scope, "user", u.ID(), "err", err)
+	}
+}
+
+// recordingFilter returns the parameters to list recordings with, a short
+// description of what's being downloaded, and a func that reports whether a
+// recording should be included.
+func (s *recordingDownloadServer) recordingFilter(query url.Values, loc *time.Location) (url.Values, string, func(time.Time) bool, error) {
+	data := url.Values{}
+	data.Set("PageSize", "100")
+	all := func(time.Time) bool { return true }
+	call, conference := query.Get("call"), query.Get("conference")
+	switch {
+	case call != "" && conference != "":
+		return nil, "", nil, errors.New("Choose a call or a conference, not both")
+	case call != "":
+		if !strings.HasPrefix(call, "CA") {
+			return nil, "", nil, errors.New("Invalid call sid")
+		}
+		data.Set("CallSid", call)
+		return data, "call:" + call, all, nil
+	case conference != "":
+		if !strings.HasPrefix(conference, "CF") {
+			return nil, "", nil, errors.New("Invalid conference sid")
+		}
+		data.Set("ConferenceSid", conference)
+		return data, "conference:" + conference, all, nil
+	}
+	start, err := time.ParseInLocation("2006-01-02", query.Get("start"), loc)
+	if err != nil {
+		return nil, "", nil, errors.New("Choose a call, a conference, or a start and end date like 2016-10-01")
+	}
+	end, err := time.ParseInLocation("2006-01-02", query.Get("end"), loc)
+	if err != nil {
+		return nil, "", nil, errors.New("Choose an end date like 2016-10-07")
+	}
+	end = end.AddDate(0, 0, 1)
+	if !end.After(start) {
+		return nil, "", nil, errors.New("The end date is before the start date")
+	}
+	// Twilio only filters by day, in UTC; the exact range is checked for each
+	// recording.
+	data.Set("DateCreated>=", start.UTC().Format("2006-01-02"))
+	data.Set("DateCreated<=", end.UTC().Format("2006-01-02"))
+	inRange := func(t time.Time) bool {
+		return !t.Before(start) && t.Before(end)
+	}
+	scope := "dates:" + query.Get("start") + ":" + query.Get("end")
+	return data, scope, inRange, nil
+}
+
+// listRecordings returns the recordings matching data and filter, stopping
+// once there are more than maxDownloadRecordings.
+func (s *recordingDownloadServer) listRecordings(ctx context.Context, u *config.User, data url.Values, filter func(time.Time) bool) ([]*views.Recording, error) {
+	page, err := s.Client.GetRecordingPage(ctx, u, data)
+	var recordings []*views.Recording
+	for {
+		if err != nil {
+			return nil, err
+		}
+		for _, recording := range page.Recordings() {
+			created, err := recording.DateCreated()
+			if err != nil {
+				return nil, err
+			}
+			if filter(created.Time) {
+				recordings = append(recordings, recording)
+			}
+		}
+		next := page.NextPageURI()
+		if !next.Valid || len(recordings) > maxDownloadRecordings {
+			return recordings, nil
+		}
+		page, err = s.Client.GetNextRecordingPage(ctx, u, next.String)
+	}
+}
+
+// writeZip fetches each recording and writes it to w, followed by the
+// manifest. Once the recordings add up to s.MaxBytes, the rest are listed in
+// the manifest as skipped.
+func (s *recordingDownloadServer) writeZip(w http.ResponseWriter, u *config.User, manifest *recordingManifest, recordings []*views.Recording) error {
+	zw := zip.NewWriter(w)
+	var total int64
+	for _, recording := range recordings {
+		entry, err := s.manifestEntry(recording)
+		if err != nil {
+			return err
+		}
+		if manifest.Truncated {
+			manifest.Skipped = append(manifest.Skipped, entry.Sid)
+			continue
+		}
+		body, err := s.fetchRecording(u, recording)
+		if err != nil {
+			entry.Error = cleanError(err)
+			manifest.Recordings = append(manifest.Recordings, entry)
+			continue
+		}
+		if total+int64(len(body)) > s.MaxBytes {
+			manifest.Truncated = true
+			manifest.Skipped = append(manifest.Skipped, entry.Sid)
+			continue
+		}
+		total += int64(len(body))
+		entry.File = entry.Sid + ".wav"
+		entry.Bytes = len(body)
+		sum := sha256.Sum256(body)
+		entry.SHA256 = hex.EncodeToString(sum[:])
+		// Audio doesn't compress well, so don't spend time trying.
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: entry.File, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(body); err != nil {
+			return err
+		}
+		manifest.Recordings = append(manifest.Recordings, entry)
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	f, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (s *recordingDownloadServer) manifestEntry(recording *views.Recording) (*recordingManifestEntry, error) {
+	sid, err := recording.Sid()
+	if err != nil {
+		return nil, err
+	}
+	callSid, err := recording.CallSid()
+	if err != nil {
+		return nil, err
+	}
+	created, err := recording.DateCreated()
+	if err != nil {
+		return nil, err
+	}
+	duration, err := recording.Duration()
+	if err != nil {
+		return nil, err
+	}
+	return &recordingManifestEntry{
+		Sid:         sid,
+		CallSid:     callSid,
+		DateCreated: created.Time.UTC(),
+		Duration:    int(time.Duration(duration) / time.Second),
+	}, nil
+}
+
+func (s *recordingDownloadServer) fetchRecording(user *config.User, recording *views.Recording) ([]byte, error) {
+	opaque, err := recording.URL()
+	if err != nil {
+		return nil, err
+	}
+	urlStr, err := services.UnopaqueFor(strings.TrimPrefix(opaque, "/audio/"), user.ID(), s.secretKey)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), recordingDownloadTimeout)
+	defer cancel()
+	body, _, err := s.Fetch(ctx, u)
+	return body, err
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+	"golang.org/x/net/context"
+)
+
+const recordingListBody = `{
+  "recordings": [
+    {"sid": "RE111", "call_sid": "CA123", "account_sid": "AC123", "duration": "12", "date_created": "Tue, 18 Oct 2016 17:00:00 +0000"},
+    {"sid": "RE222", "call_sid": "CA123", "account_sid": "AC123", "duration": "30", "date_created": "Tue, 18 Oct 2016 17:05:00 +0000"},
+    {"sid": "RE333", "call_sid": "CA123", "account_sid": "AC123", "duration": "5", "date_created": "Tue, 18 Oct 2016 17:10:00 +0000"}
+  ],
+  "next_page_uri": null
+}`
+
+func newRecordingTwilioServer() *harness.TwilioServer {
+	ts := harness.NewTwilioServer(&views.Fixtures{})
+	ts.Respond("/Recordings.json", recordingListBody)
+	return ts
+}
+
+func newTestRecordingDownloadServer(ts *harness.TwilioServer, maxBytes int64) *recordingDownloadServer {
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: ts.Server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	audit, _ := services.NewAuditLog(NullLogger, "")
+	return &recordingDownloadServer{
+		Logger: NullLogger,
+		Client: vc,
+		Fetch: func(ctx context.Context, u *url.URL) ([]byte, string, error) {
+			if strings.Contains(u.Path, "RE333") {
+				return nil, "", errors.New("Twilio returned status 404 for recording")
+			}
+			return []byte("RIFF" + strings.Repeat("x", 96)), "audio/x-wav", nil
+		},
+		Audit:          audit,
+		LocationFinder: lf,
+		MaxBytes:       maxBytes,
+		secretKey:      key,
+	}
+}
+
+func readRecordingZip(t *testing.T, body []byte) (map[string][]byte, *recordingManifest) {
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = data
+	}
+	manifest := new(recordingManifest)
+	if err := json.Unmarshal(files["manifest.json"], manifest); err != nil {
+		t.Fatal(err)
+	}
+	return files, manifest
+}
+
+func TestDownloadCallRecordings(t *testing.T) {
+	t.Parallel()
+	ts := newRecordingTwilioServer()
+	defer ts.Close()
+	s := newTestRecordingDownloadServer(ts, 1024*1024)
+	req, _ := http.NewRequest("GET", "/recordings/download?call=CA123", nil)
+	req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if requests := ts.Requests(); len(requests) == 0 || requests[0].Query().Get("CallSid") != "CA123" {
+		t.Errorf("expected recordings to be filtered by call, got %v", requests)
+	}
+	if ctype := w.Header().Get("Content-Type"); ctype != "application/zip" {
+		t.Errorf("expected a zip file, got %q", ctype)
+	}
+	files, manifest := readRecordingZip(t, w.Body.Bytes())
+	if len(files["RE111.wav"]) != 100 || len(files["RE222.wav"]) != 100 {
+		t.Errorf("expected both recordings in the zip, got %d files", len(files))
+	}
+	if len(manifest.Recordings) != 3 {
+		t.Fatalf("expected 3 recordings in the manifest, got %d", len(manifest.Recordings))
+	}
+	first := manifest.Recordings[0]
+	if first.Sid != "RE111" || first.CallSid != "CA123" || first.Duration != 12 || len(first.SHA256) != 64 {
+		t.Errorf("unexpected manifest entry %#v", first)
+	}
+	if manifest.Recordings[2].Error == "" || manifest.Recordings[2].File != "" {
+		t.Errorf("expected failed recording to be listed with an error, got %#v", manifest.Recordings[2])
+	}
+	if manifest.Truncated {
+		t.Error("expected manifest not to be truncated")
+	}
+}
+
+func TestDownloadRecordingsSizeCap(t *testing.T) {
+	t.Parallel()
+	ts := newRecordingTwilioServer()
+	defer ts.Close()
+	s := newTestRecordingDownloadServer(ts, 150)
+	req, _ := http.NewRequest("GET", "/recordings/download?call=CA123", nil)
+	req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	files, manifest := readRecordingZip(t, w.Body.Bytes())
+	if _, ok := files["RE222.wav"]; ok {
+		t.Error("expected the second recording to be left out of the zip")
+	}
+	if !manifest.Truncated || len(manifest.Skipped) != 2 || manifest.Skipped[0] != "RE222" {
+		t.Errorf("expected the rest of the recordings to be skipped, got %v", manifest.Skipped)
+	}
+}
+
+func TestDownloadRecordingsRequiresPermission(t *testing.T) {
+	t.Parallel()
+	ts := newRecordingTwilioServer()
+	defer ts.Close()
+	s := newTestRecordingDownloadServer(ts, 1024*1024)
+	us := config.AllUserSettings()
+	us.CanDownloadRecordings = false
+	req, _ := http.NewRequest("GET", "/recordings/download?call=CA123", nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+	for _, path := range []string{
+		"/recordings/download",
+		"/recordings/download?call=CA123&conference=CF123",
+		"/recordings/download?start=2016-10-07&end=2016-10-01",
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != 400 {
+			t.Errorf("%s: expected Code to be 400, got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/jobs"
+	"github.com/saintpete/logrole/views"
+)
+
+// A Reloader serves requests with a Server built from the config file, and
+// can rebuild the Server from the file while it's running. Requests that
+// started before a reload finish on the old Server; new requests go to the
+// new one.
+//
+// The port and TLS settings can't change without a restart, since the
+// listener is created outside of the Server.
+type Reloader struct {
+	log.Logger
+	load func() (*config.Settings, error)
+	// Export jobs survive reloads, so they can still be downloaded. The
+	// queue's directory can't change without a restart. Other data that's
+	// only kept in memory, like webhook captures, grants and notes without a
+	// file, and the API cache, is taken over by each new Server.
+	queue *jobs.Queue
+
+	mu     sync.Mutex // held while reloading
+	server atomic.Value
+}
+
+// NewReloader starts a Server with the given settings, and returns a Reloader
+// that serves requests with it. load is called to get new settings every time
+// the config is reloaded.
+func NewReloader(l log.Logger, settings *config.Settings, load func() (*config.Settings, error)) (*Reloader, error) {
+	rl := &Reloader{
+		Logger: l,
+		load:   load,
+		queue:  jobs.NewQueue(l, exportWorkers, exportInterval, exportTTL),
+	}
+	rl.queue.Dir = settings.ExportsDir
+	s, err := rl.start(settings)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := s.ResumeExports(); err != nil {
+		l.Warn("Couldn't resume exports", "dir", settings.ExportsDir, "err", err)
+	} else if n > 0 {
+		l.Info("Resumed exports", "count", n)
+	}
+	rl.server.Store(s)
+	return rl, nil
+}
+
+func (rl *Reloader) start(settings *config.Settings) (*Server, error) {
+	s, err := newServer(settings, rl)
+	if err != nil {
+		return nil, err
+	}
+	s.CacheCommonQueries()
+	s.MonitorStuckMessages()
+	s.MonitorNumberInventory()
+	s.SaveCacheSnapshots()
+	s.ReplicateCache()
+	s.PrefetchNextPages()
+	s.PurgeExpiredData()
+	s.ReconcileStatuses()
+	s.IndexAttachments()
+	s.HashBodies()
+	s.RekeyMediaCache()
+	return s, nil
+}
+
+// Reload re-reads the config and swaps in a new Server. If the config can't
+// be loaded, the current Server keeps running and the error is returned.
+func (rl *Reloader) Reload() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	settings, err := rl.load()
+	if err != nil {
+		rl.Warn("Couldn't reload config", "err", err)
+		return err
+	}
+	s, err := rl.start(settings)
+	if err != nil {
+		rl.Warn("Couldn't reload config", "err", err)
+		return err
+	}
+	old := rl.server.Load().(*Server)
+	rl.server.Store(s)
+	// Only stops background work; in-flight requests don't depend on it.
+	old.Close()
+	rl.Info("Reloaded config")
+	return nil
+}
+
+// keepAPICache copies the cached API responses in old to c, so pages are
+// still fast right after a reload.
+func keepAPICache(l log.Logger, old views.Snapshotter, c views.Snapshotter) {
+	var buf bytes.Buffer
+	if _, err := old.SnapshotCache(&buf, 0); err != nil {
+		l.Warn("Couldn't copy the API cache", "err", err)
+		return
+	}
+	n, err := c.RestoreCache(&buf)
+	if err != nil {
+		l.Warn("Couldn't copy the API cache", "err", err)
+		return
+	}
+	l.Info("Kept cached API responses", "count", n)
+}
+
+func (rl *Reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rl.server.Load().(*Server).ServeHTTP(w, r)
+}
+
+type reloadServer struct {
+	log.Logger
+	Reloader *Reloader
+}
+
+// POST /admin/reload
+//
+// Re-read the config file and start serving requests with it.
+func (s *reloadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanReloadConfig() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	s.Info("Reloading config", "user", u.ID())
+	if err := s.Reloader.Reload(); err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: "Couldn't reload config: " + err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+	// Serve from an empty archive so the Servers don't call Twilio.
+	dir, err := ioutil.TempDir("", "logrole-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	settingsFor := func(name string) *config.Settings {
+		return &config.Settings{
+			AllowUnencryptedTraffic: true,
+			Authenticator:           &config.NoopAuthenticator{},
+			SecretKey:               key,
+			Logger:                  NullLogger,
+			Client:                  twilio.NewClient("AC123", "123", nil),
+			ArchiveDir:              dir,
+			Branding:                &config.Branding{ProductName: name},
+		}
+	}
+	var loadErr error
+	rl, err := NewReloader(NullLogger, settingsFor("Before"), func() (*config.Settings, error) {
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return settingsFor("After"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() string {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.SetBasicAuth("test", "test")
+		w := httptest.NewRecorder()
+		rl.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+	reload := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/admin/reload", nil)
+		req.SetBasicAuth("test", "test")
+		w := httptest.NewRecorder()
+		rl.ServeHTTP(w, req)
+		return w
+	}
+	if body := get(); !strings.Contains(body, "Before") {
+		t.Fatalf("expected initial settings to be used, got %s", body)
+	}
+	if w := reload(); w.Code != 204 {
+		t.Fatalf("expected Code to be 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if body := get(); !strings.Contains(body, "After") {
+		t.Errorf("expected reloaded settings to be used, got %s", body)
+	}
+
+	loadErr = errors.New("bad config")
+	if w := reload(); w.Code != 400 || !strings.Contains(w.Body.String(), "bad config") {
+		t.Errorf("expected a 400 with the config error, got %d: %s", w.Code, w.Body.String())
+	}
+	if body := get(); !strings.Contains(body, "After") {
+		t.Errorf("expected the old settings to keep working after a failed reload")
+	}
+}
+
+func TestReloadKeepsMemoryStores(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var loaded []*config.Settings
+	load := func() (*config.Settings, error) {
+		settings := &config.Settings{
+			AllowUnencryptedTraffic: true,
+			Authenticator:           &config.NoopAuthenticator{},
+			SecretKey:               key,
+			Logger:                  NullLogger,
+			Client:                  twilio.NewClient("AC123", "123", nil),
+			ArchiveDir:              dir,
+		}
+		loaded = append(loaded, settings)
+		return settings, nil
+	}
+	before, _ := load()
+	rl, err := NewReloader(NullLogger, before, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := before.Grants.Add(&config.Grant{
+		User:        "test",
+		Permissions: []string{"can_view_message_price"},
+		Expires:     time.Now().Add(time.Hour),
+		Reason:      "billing dispute",
+		GrantedBy:   "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := before.AuditLog.Record(&services.AuditEvent{User: "admin", Action: "grant_permission", Resource: g.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := before.Notes.Add("SM123", "Escalated to carrier", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	after := loaded[len(loaded)-1]
+	if after == before {
+		t.Fatal("expected Reload to load new settings")
+	}
+	if active := after.Grants.Active(time.Now()); len(active) != 1 || active[0].ID != g.ID {
+		t.Errorf("expected the grant to survive a reload, got %v", active)
+	}
+	if after.AuditLog != before.AuditLog {
+		t.Error("expected the audit log to survive a reload")
+	}
+	if notes := after.Notes.Get("SM123"); len(notes) != 1 {
+		t.Errorf("expected the note to survive a reload, got %v", notes)
+	}
+}
+
+func TestReloadForbidden(t *testing.T) {
+	t.Parallel()
+	us := config.AllUserSettings()
+	us.CanReloadConfig = false
+	s := &reloadServer{Logger: NullLogger}
+	req, _ := http.NewRequest("POST", "/admin/reload", nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+}
@@ -13,7 +13,9 @@ import (
 	"github.com/aristanetworks/goarista/monotime"
 	"github.com/kevinburke/handlers"
 	"github.com/saintpete/logrole/assets"
+	"github.com/saintpete/logrole/config"
 	"github.com/saintpete/logrole/services"
+	twilio "github.com/saintpete/twilio-go"
 )
 
 var base, phoneTpl, copyScript, sidTpl, messageInstanceTpl, messageListTpl,
@@ -21,12 +23,19 @@ var base, phoneTpl, copyScript, sidTpl, messageInstanceTpl, messageListTpl,
 	alertListTpl, alertInstanceTpl, numberListTpl, numberInstanceTpl,
 	indexTpl, loginTpl, recordingTpl, pagingTpl, openSearchTpl,
 	messageStatusTpl, messageSummaryTpl, callSummaryTpl, openSourceTpl,
-	errorTpl string
+	errorTpl, jobListTpl, dashboardTpl, stuckTpl, labelListTpl, ticketsTpl,
+	flaggedMediaTpl, numberHistoryTpl, grantListTpl, sessionListTpl, webhookListTpl,
+	webhookInstanceTpl, heatmapTpl, resendTpl, scheduledTpl, queueTpl, a2pTpl, errorSearchTpl, attachmentSearchTpl, viewAsTpl,
+	autoRefreshScript, partialListsScript, relatedAlertsTpl, permissionsTpl, ownerListTpl,
+	conversationListTpl, conversationInstanceTpl, uptimeTpl, numberTimelineTpl,
+	campaignTpl, duplicateTpl, notesTpl, noteSearchTpl, webhookResponseTpl, runbookTpl, trafficTpl, breakGlassTpl, blocklistTpl, numberChangesTpl, cacheHistoryTpl string
 
 func init() {
 	base = assets.MustAssetString("templates/base.html")
 	phoneTpl = assets.MustAssetString("templates/snippets/phonenumber.html")
 	copyScript = assets.MustAssetString("templates/snippets/copy-phonenumber.js")
+	autoRefreshScript = assets.MustAssetString("templates/snippets/auto-refresh.js")
+	partialListsScript = assets.MustAssetString("templates/snippets/partial-lists.js")
 	sidTpl = assets.MustAssetString("templates/snippets/sid.html")
 	pagingTpl = assets.MustAssetString("templates/snippets/paging.html")
 	messageStatusTpl = assets.MustAssetString("templates/snippets/message-status.html")
@@ -40,6 +49,10 @@ func init() {
 	conferenceListTpl = assets.MustAssetString("templates/conferences/list.html")
 	numberListTpl = assets.MustAssetString("templates/phone-numbers/list.html")
 	numberInstanceTpl = assets.MustAssetString("templates/phone-numbers/instance.html")
+	numberHistoryTpl = assets.MustAssetString("templates/phone-numbers/history.html")
+	numberTimelineTpl = assets.MustAssetString("templates/phone-numbers/timeline.html")
+	numberChangesTpl = assets.MustAssetString("templates/phone-numbers/changes.html")
+	cacheHistoryTpl = assets.MustAssetString("templates/admin/cache.html")
 	alertListTpl = assets.MustAssetString("templates/alerts/list.html")
 	alertInstanceTpl = assets.MustAssetString("templates/alerts/instance.html")
 	indexTpl = assets.MustAssetString("templates/index.html")
@@ -48,6 +61,39 @@ func init() {
 	openSearchTpl = assets.MustAssetString("templates/opensearch.xml")
 	errorTpl = assets.MustAssetString("templates/errors.html")
 	openSourceTpl = assets.MustAssetString("templates/opensource.html")
+	jobListTpl = assets.MustAssetString("templates/jobs/list.html")
+	dashboardTpl = assets.MustAssetString("templates/dashboard.html")
+	heatmapTpl = assets.MustAssetString("templates/heatmap.html")
+	trafficTpl = assets.MustAssetString("templates/traffic.html")
+	breakGlassTpl = assets.MustAssetString("templates/break-glass.html")
+	uptimeTpl = assets.MustAssetString("templates/alerts/uptime.html")
+	stuckTpl = assets.MustAssetString("templates/messages/stuck.html")
+	flaggedMediaTpl = assets.MustAssetString("templates/messages/flagged-media.html")
+	resendTpl = assets.MustAssetString("templates/messages/resend.html")
+	scheduledTpl = assets.MustAssetString("templates/messages/scheduled.html")
+	campaignTpl = assets.MustAssetString("templates/messages/campaigns.html")
+	duplicateTpl = assets.MustAssetString("templates/messages/duplicates.html")
+	queueTpl = assets.MustAssetString("templates/queues.html")
+	a2pTpl = assets.MustAssetString("templates/a2p.html")
+	conversationListTpl = assets.MustAssetString("templates/conversations/list.html")
+	conversationInstanceTpl = assets.MustAssetString("templates/conversations/instance.html")
+	errorSearchTpl = assets.MustAssetString("templates/search/errors.html")
+	attachmentSearchTpl = assets.MustAssetString("templates/search/attachments.html")
+	noteSearchTpl = assets.MustAssetString("templates/search/notes.html")
+	viewAsTpl = assets.MustAssetString("templates/admin/view-as.html")
+	permissionsTpl = assets.MustAssetString("templates/admin/permissions.html")
+	labelListTpl = assets.MustAssetString("templates/labels/list.html")
+	ownerListTpl = assets.MustAssetString("templates/owners/list.html")
+	ticketsTpl = assets.MustAssetString("templates/snippets/tickets.html")
+	notesTpl = assets.MustAssetString("templates/snippets/notes.html")
+	relatedAlertsTpl = assets.MustAssetString("templates/snippets/related-alerts.html")
+	grantListTpl = assets.MustAssetString("templates/admin/grants.html")
+	blocklistTpl = assets.MustAssetString("templates/admin/blocklist.html")
+	sessionListTpl = assets.MustAssetString("templates/admin/sessions.html")
+	webhookListTpl = assets.MustAssetString("templates/debug/webhooks.html")
+	webhookInstanceTpl = assets.MustAssetString("templates/debug/webhook-instance.html")
+	webhookResponseTpl = assets.MustAssetString("templates/snippets/webhook-response.html")
+	runbookTpl = assets.MustAssetString("templates/snippets/runbook.html")
 }
 
 // newTpl creates a new Template with the given base and common set of
@@ -75,16 +121,41 @@ var funcMap = template.FuncMap{
 	"duration":      services.Duration,
 	"render":        renderTime,
 	"truncate_sid":  services.TruncateSid,
-	"prefix_strip":  stripPrefix("+1 "),
+	"format_pn":     formatPhoneNumber,
+	"bare_pn":       services.BareNumber,
+	"country":       countryCode,
+	"flag":          services.CountryFlag,
 	"tztime":        tzTime,
+	"hidden":        hiddenField,
+	"static":        staticURL,
+	"csrf_field":    csrfFormField,
+	"csp_nonce":     func() string { return cspNoncePlaceholder },
 }
 
-// stripPrefix strips the prefix from a phone number - in this case we strip
-// the US prefix "+1 " from numbers. We could make this configurable.
-func stripPrefix(pfx string) func(string) string {
-	return func(val string) string {
-		return strings.TrimPrefix(val, pfx)
+// A hider is a view that can explain why one of its properties is hidden.
+type hider interface {
+	HiddenReason(property string) string
+}
+
+// hiddenField renders the placeholder for a property the user can't view,
+// followed by the permission that hides it if the user is debugging
+// permissions.
+func hiddenField(v hider, property string) template.HTML {
+	reason := v.HiddenReason(property)
+	if reason == "" {
+		return template.HTML("<i>hidden</i>")
 	}
+	return template.HTML(`<i>hidden</i> <small class="hidden-reason">(` + template.HTMLEscapeString(reason) + `)</small>`)
+}
+
+// formatPhoneNumber formats a number the way it's written in its own country,
+// so "+14155551234" becomes "(415) 555-1234".
+func formatPhoneNumber(pn twilio.PhoneNumber) string {
+	return services.FormatPhoneNumber(string(pn))
+}
+
+func countryCode(pn twilio.PhoneNumber) string {
+	return services.CountryCode(string(pn))
 }
 
 var templatePool = sync.Pool{
@@ -102,6 +173,26 @@ type baseData struct {
 	LoggedOut      bool
 	TZ             string
 	LF             services.LocationFinder
+	// The theme from the user's preferences, "default" or "high-contrast".
+	Theme string
+	// The page size from the user's preferences, or 0 if they haven't chosen
+	// one.
+	PageSize uint
+	// The name, logo and colors of the site. Set from the request when the
+	// template is rendered.
+	Brand *config.Branding
+	// Set if resources come from an archive instead of the Twilio API.
+	Archive *archive
+	// The user viewing the page, if any. Set from the request.
+	user *config.User
+	// The filters on the list page being viewed, if any, so the links to
+	// the other lists can keep them.
+	filter *listFilter
+	// The emergency access users can give themselves, if any. Set from the
+	// request.
+	breakGlass *config.BreakGlass
+	// The pages the nav links to. Set from the request.
+	sitemap *sitemap
 	// Whatever data gets sent to the child template. Should have a Title
 	// property or Title() function.
 	Data interface{}
@@ -111,6 +202,70 @@ func (bd *baseData) Version() string {
 	return Version
 }
 
+// ViewingAs returns the user an admin is viewing the page as, or nil if
+// they're viewing it as themselves.
+func (bd *baseData) ViewingAs() *config.User {
+	if bd.user == nil || bd.user.Viewer() == nil {
+		return nil
+	}
+	return bd.user
+}
+
+// BreakGlass returns the emergency access the user viewing the page has
+// given themselves, or nil if they haven't.
+func (bd *baseData) BreakGlass() *config.Grant {
+	if bd.user == nil || bd.user.Viewer() != nil {
+		return nil
+	}
+	return bd.user.BreakGlass()
+}
+
+// canBreakGlass reports whether u can give themselves emergency access that
+// they don't already have.
+func canBreakGlass(b *config.BreakGlass, u *config.User) bool {
+	if u == nil || u.Viewer() != nil || u.BreakGlass() != nil {
+		return false
+	}
+	return b.Allowed(u) && len(b.Missing(u)) > 0
+}
+
+// Nav returns the links in the named section of the nav that the user
+// viewing the page is allowed to follow.
+func (bd *baseData) Nav(section string) []*navLink {
+	links := make([]*navLink, 0)
+	for _, p := range bd.sitemap.For(bd.user) {
+		if p.Section != section {
+			continue
+		}
+		link := &navLink{Name: p.Name, URL: p.Path, Active: bd.Path == p.Path}
+		if p.List {
+			link.URL = bd.ListURL(p.Path)
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// ListURL returns the link to the list page at path, keeping the filters
+// from the list being viewed that it supports.
+func (bd *baseData) ListURL(path string) string {
+	return bd.filter.URL(path)
+}
+
+// PageSizes returns the page sizes the user viewing the page can choose from.
+func (bd *baseData) PageSizes() []uint {
+	return pageSizeChoices(bd.user)
+}
+
+// Feature reports whether the named feature is on for the user viewing the
+// page.
+func (bd *baseData) Feature(name string) bool {
+	if bd.user == nil {
+		return config.DefaultUser.Feature(name)
+	}
+	return bd.user.Feature(name)
+}
+
 func tzTime(now time.Time, lf services.LocationFinder, loc string) string {
 	l := lf.GetLocation(loc)
 	return services.FriendlyDate(now.In(l))
@@ -124,14 +279,6 @@ func minLoc(age time.Duration, l *time.Location) string {
 	return time.Now().In(l).Add(-age).Truncate(time.Hour).Format(HTML5DatetimeLocalFormat)
 }
 
-// minFunc returns a function that, when called, returns the minimum acceptable
-// age for a resource, formatted using the HTML5 Datetime format.
-func minFunc(age time.Duration) func(*time.Location) string {
-	return func(loc *time.Location) string {
-		return minLoc(age, loc)
-	}
-}
-
 func maxLoc(l *time.Location) string {
 	return time.Now().In(l).Add(1 * time.Hour).Truncate(time.Hour).Format(HTML5DatetimeLocalFormat)
 }
@@ -142,19 +289,14 @@ func maxLoc(l *time.Location) string {
 //
 // data should inherit from baseData
 func render(w io.Writer, r *http.Request, tpl *template.Template, name string, data *baseData) error {
-	data.Start = monotime.Now()
-	data.Now = time.Now().UTC()
-	data.Path = r.URL.Path
-	data.ReqDuration = handlers.GetDuration(r.Context())
-	if data.LF != nil {
-		data.TZ = data.LF.GetLocationReq(r).String()
-	}
+	setBaseData(r, data)
 	b := templatePool.Get().(*bytes.Buffer)
 	defer func(buf *bytes.Buffer) {
 		buf.Reset()
 		templatePool.Put(buf)
 	}(b)
-	if err := tpl.ExecuteTemplate(b, name, data); err != nil {
+	name, dot := pageTemplate(w, r, tpl, name, data)
+	if err := tpl.ExecuteTemplate(newPageWriter(b, r), name, dot); err != nil {
 		return err
 	}
 	if b.Len() == 0 {
@@ -163,3 +305,86 @@ func render(w io.Writer, r *http.Request, tpl *template.Template, name string, d
 	_, writeErr := io.Copy(w, b)
 	return writeErr
 }
+
+// renderStream renders the template straight to w, so the browser gets the
+// top of the page while the template waits on a stream for the rest. If
+// rendering fails partway through, the status code and the start of the page
+// have already been sent.
+func renderStream(w io.Writer, r *http.Request, tpl *template.Template, name string, data *baseData) error {
+	setBaseData(r, data)
+	name, dot := pageTemplate(w, r, tpl, name, data)
+	return tpl.ExecuteTemplate(newPageWriter(w, r), name, dot)
+}
+
+// fragmentHeader asks for part of a page instead of all of it. The only part
+// a page can send on its own is resultsFragment.
+const fragmentHeader = "Logrole-Fragment"
+
+// resultsFragment is the template for the results of a list page: the table,
+// the paging links and anything else that changes with the filters. List
+// pages that define it can swap in new results without loading the whole
+// page again.
+const resultsFragment = "results"
+
+// pageTemplate returns the template to render for r, and the data to render
+// it with. That's name and data, unless r asks for the results of a page that
+// has them on their own, in which case it's just the results, with the page's
+// own data.
+func pageTemplate(w io.Writer, r *http.Request, tpl *template.Template, name string, data *baseData) (string, interface{}) {
+	if name != "base" || tpl.Lookup(resultsFragment) == nil {
+		return name, data
+	}
+	if rw, ok := w.(http.ResponseWriter); ok {
+		// So a browser doesn't show cached results as the whole page.
+		rw.Header().Add("Vary", fragmentHeader)
+	}
+	if r.Header.Get(fragmentHeader) != resultsFragment {
+		return name, data
+	}
+	return resultsFragment, data.Data
+}
+
+// A pageWriter fills in the values that belong to the request, which
+// template functions can't see: they render a placeholder instead, and the
+// pageWriter replaces it with the CSRF token or CSP nonce. Templates write
+// the result of a function call at once, so a placeholder is never split
+// across writes.
+type pageWriter struct {
+	w        io.Writer
+	replacer *strings.Replacer
+}
+
+func newPageWriter(w io.Writer, r *http.Request) *pageWriter {
+	return &pageWriter{
+		w:        w,
+		replacer: strings.NewReplacer(csrfPlaceholder, getCSRFToken(r), cspNoncePlaceholder, getCSPNonce(r)),
+	}
+}
+
+func (pw *pageWriter) Write(p []byte) (int, error) {
+	if !bytes.Contains(p, []byte(csrfPlaceholder)) && !bytes.Contains(p, []byte(cspNoncePlaceholder)) {
+		return pw.w.Write(p)
+	}
+	if _, err := io.WriteString(pw.w, pw.replacer.Replace(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func setBaseData(r *http.Request, data *baseData) {
+	data.Start = monotime.Now()
+	data.Now = time.Now().UTC()
+	data.Path = r.URL.Path
+	data.ReqDuration = handlers.GetDuration(r.Context())
+	data.Brand = getBranding(r)
+	data.Archive = getArchive(r)
+	data.Theme = getTheme(r)
+	data.PageSize = getPageSize(r)
+	data.user, _ = config.GetUser(r)
+	data.filter = parseListFilter(r.URL.Path, r.URL.Query())
+	data.breakGlass = getBreakGlass(r)
+	data.sitemap = getSitemap(r)
+	if data.LF != nil {
+		data.TZ = data.LF.GetLocationReq(r).String()
+	}
+}
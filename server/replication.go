@@ -0,0 +1,266 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+)
+
+// Other instances send cache entries to this path.
+const cacheReplicationPath = "/cache/replicate"
+
+// How often queued entries are sent to peers.
+const cacheReplicationInterval = time.Second
+
+// The most entries waiting to be sent. Entries cached while the queue is full
+// aren't sent; a peer that needs them fetches them from Twilio itself.
+const cacheReplicationQueueSize = 10000
+
+// Batches sent longer ago than this are rejected, so a batch that was
+// captured on the way can't be replayed later to put stale data in a cache.
+const maxCacheReplicationAge = time.Minute
+
+// Room in a batch for everything but the cached data: the keys, the JSON
+// around the entries, and encryption.
+const cacheReplicationOverhead = 64 * 1024
+
+// The version of replicationBatch peers send. Bump it if the format changes
+// in a way older versions can't read.
+const replicationBatchVersion = 1
+
+// A replicationBatch is the entries sent to peers in one request. Batches are
+// encoded with cache.JSON, so peers running different versions of Logrole
+// can read each other's, and encrypted with the replication key.
+type replicationBatch struct {
+	Version int            `json:"version"`
+	Sent    time.Time      `json:"sent"`
+	Entries []*cache.Entry `json:"entries"`
+}
+
+// replicationStats are served at /debug/vars.
+type replicationStats struct {
+	Peers     int   `json:"peers"`
+	Queued    int   `json:"queued"`
+	Sent      int64 `json:"sent_entries"`
+	SentBytes int64 `json:"sent_bytes"`
+	// Entries that weren't sent because the queue was full, or that were
+	// bigger than a second's worth of bandwidth.
+	Dropped     int64 `json:"dropped_entries"`
+	FailedSends int64 `json:"failed_sends"`
+	Received    int64 `json:"received_entries"`
+	// Received entries that were newer than what was cached here.
+	Stored int64 `json:"stored_entries"`
+}
+
+// cacheReplicator sends the API responses this instance caches to its peers,
+// and caches the ones they send it, so a standby instance is warm when it
+// takes over. Peers are sent at most Bandwidth bytes of cached data every
+// second; entries wait in a queue until there's room.
+type cacheReplicator struct {
+	log.Logger
+	Cache     views.Replicator
+	Peers     []string
+	Bandwidth int64
+	Client    *http.Client
+	key       *[32]byte
+
+	queue    chan *cache.Entry
+	done     chan struct{}
+	stopOnce sync.Once
+	// An entry taken from the queue that didn't fit in the last batch.
+	pending *cache.Entry
+
+	sent, sentBytes, dropped, failed, received, stored int64
+}
+
+func newCacheReplicator(l log.Logger, c views.Replicator, peers []string, bandwidth int64, key *[32]byte) *cacheReplicator {
+	r := &cacheReplicator{
+		Logger:    l,
+		Cache:     c,
+		Peers:     peers,
+		Bandwidth: bandwidth,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		key:       key,
+		queue:     make(chan *cache.Entry, cacheReplicationQueueSize),
+		done:      make(chan struct{}),
+	}
+	c.OnCacheSet(r.enqueue)
+	return r
+}
+
+// enqueue adds e to the entries waiting to be sent, or drops it if the queue
+// is full. It's called every time the cache is set, so it can't block.
+func (r *cacheReplicator) enqueue(e *cache.Entry) {
+	select {
+	case r.queue <- e:
+	default:
+		atomic.AddInt64(&r.dropped, 1)
+	}
+}
+
+// next returns the entries to send, up to a second's worth of bandwidth.
+func (r *cacheReplicator) next() []*cache.Entry {
+	var entries []*cache.Entry
+	var size int64
+	for {
+		e := r.pending
+		r.pending = nil
+		if e == nil {
+			select {
+			case e = <-r.queue:
+			default:
+				return entries
+			}
+		}
+		esize := int64(len(e.Key) + len(e.Bits))
+		if esize > r.Bandwidth {
+			// It would never fit.
+			atomic.AddInt64(&r.dropped, 1)
+			continue
+		}
+		if size+esize > r.Bandwidth {
+			r.pending = e
+			return entries
+		}
+		entries = append(entries, e)
+		size += esize
+	}
+}
+
+// send sends entries to every peer.
+func (r *cacheReplicator) send(entries []*cache.Entry) {
+	var buf bytes.Buffer
+	batch := &replicationBatch{Version: replicationBatchVersion, Sent: time.Now(), Entries: entries}
+	if err := cache.JSON.Encode(&buf, batch); err != nil {
+		r.Warn("Couldn't encode cache entries", "err", err)
+		return
+	}
+	body := services.OpaqueByte(buf.Bytes(), r.key)
+	var wg sync.WaitGroup
+	for _, peer := range r.Peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			if err := r.post(peer, body); err != nil {
+				atomic.AddInt64(&r.failed, 1)
+				r.Warn("Couldn't send cache entries to peer", "peer", peer, "entries", len(entries), "err", err)
+				return
+			}
+			atomic.AddInt64(&r.sent, int64(len(entries)))
+			atomic.AddInt64(&r.sentBytes, int64(len(body)))
+		}(peer)
+	}
+	wg.Wait()
+}
+
+func (r *cacheReplicator) post(peer string, body string) error {
+	req, err := http.NewRequest("POST", peer+cacheReplicationPath, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("User-Agent", "logrole/"+Version)
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("peer returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (r *cacheReplicator) Run() {
+	ticker := time.NewTicker(cacheReplicationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			if entries := r.next(); len(entries) > 0 {
+				r.send(entries)
+			}
+		}
+	}
+}
+
+// Stop stops sending entries. Entries still in the queue aren't sent.
+func (r *cacheReplicator) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.done)
+	})
+}
+
+func (r *cacheReplicator) Stats() *replicationStats {
+	return &replicationStats{
+		Peers:       len(r.Peers),
+		Queued:      len(r.queue),
+		Sent:        atomic.LoadInt64(&r.sent),
+		SentBytes:   atomic.LoadInt64(&r.sentBytes),
+		Dropped:     atomic.LoadInt64(&r.dropped),
+		FailedSends: atomic.LoadInt64(&r.failed),
+		Received:    atomic.LoadInt64(&r.received),
+		Stored:      atomic.LoadInt64(&r.stored),
+	}
+}
+
+// maxBatch returns the largest batch a peer can send. Cached data is base64
+// encoded twice on the way, once in the JSON and once when it's encrypted, so
+// a second's worth takes up to twice the bandwidth. Peers should all have the
+// same bandwidth setting.
+func (r *cacheReplicator) maxBatch() int64 {
+	return 2*r.Bandwidth + cacheReplicationOverhead
+}
+
+// POST /cache/replicate
+//
+// Cache the entries in a batch sent by a peer. The batch has to decrypt with
+// the replication key, so there's no other authentication. Requests are
+// only accepted from the replication subnets.
+func (r *cacheReplicator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	max := r.maxBatch()
+	if req.ContentLength > max {
+		rest.BadRequest(w, req, &rest.Error{Title: "The batch is too large"})
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, max))
+	if err != nil {
+		rest.BadRequest(w, req, &rest.Error{Title: "Couldn't read the batch: " + err.Error()})
+		return
+	}
+	data, err := services.UnopaqueByte(string(body), r.key)
+	if err != nil {
+		r.Warn("Rejected cache entries that didn't decrypt", "remote_addr", req.RemoteAddr)
+		rest.Forbidden(w, req, &rest.Error{Title: "Couldn't decrypt the batch"})
+		return
+	}
+	batch := new(replicationBatch)
+	if err := cache.JSON.Decode(bytes.NewReader(data), batch); err != nil {
+		rest.BadRequest(w, req, &rest.Error{Title: "Invalid batch: " + err.Error()})
+		return
+	}
+	if batch.Version > replicationBatchVersion {
+		rest.BadRequest(w, req, &rest.Error{Title: fmt.Sprintf("The batch is version %d, but this server only reads version %d or older. Upgrade Logrole", batch.Version, replicationBatchVersion)})
+		return
+	}
+	if age := time.Since(batch.Sent); age > maxCacheReplicationAge || age < -maxCacheReplicationAge {
+		rest.BadRequest(w, req, &rest.Error{Title: "The batch was sent too long ago, or the clocks are too far apart"})
+		return
+	}
+	stored := r.Cache.ApplyCacheEntries(batch.Entries)
+	atomic.AddInt64(&r.received, int64(len(batch.Entries)))
+	atomic.AddInt64(&r.stored, int64(stored))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/services"
+)
+
+// cacheReplica is a views.Replicator backed by a plain cache.
+type cacheReplica struct {
+	*cache.Cache
+}
+
+func (c *cacheReplica) OnCacheSet(fn func(*cache.Entry)) {
+	c.OnSet(fn)
+}
+
+func (c *cacheReplica) ApplyCacheEntries(entries []*cache.Entry) int {
+	return c.Apply(entries)
+}
+
+func TestCacheReplication(t *testing.T) {
+	t.Parallel()
+	standby := &cacheReplica{cache.NewCache(10, dlog)}
+	sr := newCacheReplicator(dlog, standby, nil, 1024, key)
+	ts := httptest.NewServer(sr)
+	defer ts.Close()
+
+	primary := &cacheReplica{cache.NewCache(10, dlog)}
+	pr := newCacheReplicator(dlog, primary, []string{ts.URL}, 1024, key)
+	primary.Set("page", "hello", time.Hour)
+	pr.send(pr.next())
+	var val string
+	if _, err := standby.Get("page", &val); err != nil || val != "hello" {
+		t.Fatalf("expected the standby to be warm, got %q, %v", val, err)
+	}
+	if len(sr.queue) != 0 {
+		t.Errorf("expected replicated entries not to be sent back, got %d queued", len(sr.queue))
+	}
+	if stats := pr.Stats(); stats.Sent != 1 || stats.FailedSends != 0 {
+		t.Errorf("expected 1 entry sent, got %#v", stats)
+	}
+	if stats := sr.Stats(); stats.Received != 1 || stats.Stored != 1 {
+		t.Errorf("expected 1 entry stored, got %#v", stats)
+	}
+}
+
+func TestCacheReplicationBandwidth(t *testing.T) {
+	t.Parallel()
+	c := &cacheReplica{cache.NewCache(10, dlog)}
+	r := newCacheReplicator(dlog, c, nil, 80, key)
+	// Cached values are compressed, so these take up about 50 bytes each.
+	c.Set("a", strings.Repeat("a", 20), time.Hour)
+	c.Set("b", strings.Repeat("b", 20), time.Hour)
+	huge := make([]int, 100)
+	for i := range huge {
+		huge[i] = i * 7919
+	}
+	c.Set("huge", huge, time.Hour)
+	if entries := r.next(); len(entries) != 1 || entries[0].Key != "a" {
+		t.Fatalf("expected one entry to fit in a second's bandwidth, got %d", len(entries))
+	}
+	if entries := r.next(); len(entries) != 1 || entries[0].Key != "b" {
+		t.Fatalf("expected the entry that didn't fit to be sent next, got %d", len(entries))
+	}
+	if entries := r.next(); len(entries) != 0 {
+		t.Errorf("expected an entry bigger than the bandwidth to be dropped, got %d", len(entries))
+	}
+	if dropped := r.Stats().Dropped; dropped != 1 {
+		t.Errorf("expected 1 dropped entry, got %d", dropped)
+	}
+}
+
+func TestCacheReplicationRejectsOtherKeys(t *testing.T) {
+	t.Parallel()
+	c := &cacheReplica{cache.NewCache(10, dlog)}
+	r := newCacheReplicator(dlog, c, nil, 1024, key)
+	body := services.OpaqueByte([]byte("anything"), services.NewRandomKey())
+	req, _ := http.NewRequest("POST", cacheReplicationPath, bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected a batch encrypted with another key to get 403, got %d", w.Code)
+	}
+}
+
+func TestCacheReplicationRejectsLargeBatches(t *testing.T) {
+	t.Parallel()
+	c := &cacheReplica{cache.NewCache(10, dlog)}
+	r := newCacheReplicator(dlog, c, nil, 1024, key)
+	body := strings.Repeat("a", int(r.maxBatch())+1)
+	req, _ := http.NewRequest("POST", cacheReplicationPath, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Errorf("expected a batch bigger than the bandwidth allows to get 400, got %d", w.Code)
+	}
+}
+
+func TestCacheReplicationRejectsNewerVersions(t *testing.T) {
+	t.Parallel()
+	c := &cacheReplica{cache.NewCache(10, dlog)}
+	r := newCacheReplicator(dlog, c, nil, 1024, key)
+	var buf bytes.Buffer
+	batch := &replicationBatch{Version: replicationBatchVersion + 1, Sent: time.Now()}
+	if err := cache.JSON.Encode(&buf, batch); err != nil {
+		t.Fatal(err)
+	}
+	body := services.OpaqueByte(buf.Bytes(), key)
+	req, _ := http.NewRequest("POST", cacheReplicationPath, bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Errorf("expected a newer batch version to get 400, got %d", w.Code)
+	}
+	if want := fmt.Sprintf("version %d", replicationBatchVersion+1); !strings.Contains(w.Body.String(), want) {
+		t.Errorf("expected the error to mention %q, got %s", want, w.Body.String())
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+)
+
+var messageResendRoute = regexp.MustCompile("^/messages/" + messagePattern + "/resend$")
+
+// resendServer sends failed messages again. GET shows what will be sent and
+// asks for confirmation; POST sends it. It requires the can_resend_messages
+// permission.
+type resendServer struct {
+	log.Logger
+	Client         views.Client
+	Resender       views.Resender
+	Audit          *services.AuditLog
+	LocationFinder services.LocationFinder
+	tpl            *template.Template
+
+	mu sync.Mutex
+	// Original message sid => sid of the message that replaced it. Kept in
+	// memory, so it's cleared when the server restarts or reloads.
+	resent map[string]string
+	// Messages that are being resent right now.
+	sending map[string]bool
+}
+
+func newResendServer(l log.Logger, vc views.Client, rs views.Resender, audit *services.AuditLog, lf services.LocationFinder, labels *services.LabelStore, owners *services.OwnerStore) (*resendServer, error) {
+	tpl, err := newTpl(template.FuncMap{
+		"is_our_pn": vc.IsTwilioNumber,
+		"pn_label":  labels.Get,
+		"pn_owner":  owners.Get,
+	}, base+resendTpl+phoneTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &resendServer{
+		Logger:         l,
+		Client:         vc,
+		Resender:       rs,
+		Audit:          audit,
+		LocationFinder: lf,
+		tpl:            tpl,
+		resent:         make(map[string]string),
+		sending:        make(map[string]bool),
+	}, nil
+}
+
+type resendData struct {
+	Message *views.Message
+	Loc     *time.Location
+	// Sid of the message this one was already resent as, if any.
+	ResentAs string
+	Err      string
+}
+
+func (d *resendData) Title() string {
+	return "Resend Message"
+}
+
+func (s *resendServer) render(w http.ResponseWriter, r *http.Request, code int, data *resendData) {
+	data.Loc = s.LocationFinder.GetLocationReq(r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (s *resendServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanResendMessages() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to resend messages"})
+		return
+	}
+	sid := messageResendRoute.FindStringSubmatch(r.URL.Path)[1]
+	ctx, cancel := getContext(r.Context(), 5*time.Second)
+	defer cancel()
+	message, err := s.Client.GetMessage(ctx, u, sid)
+	switch err {
+	case nil:
+		break
+	case config.PermissionDenied, config.ErrTooOld:
+		rest.Forbidden(w, r, &rest.Error{Title: err.Error()})
+		return
+	default:
+		if terr, ok := err.(*rest.Error); ok && terr.StatusCode == 404 {
+			rest.NotFound(w, r)
+			return
+		}
+		rest.ServerError(w, r, err)
+		return
+	}
+	data := &resendData{Message: message}
+	if !message.Resendable() {
+		data.Err = views.ErrNotResendable.Error()
+		s.render(w, r, http.StatusBadRequest, data)
+		return
+	}
+	s.mu.Lock()
+	data.ResentAs = s.resent[sid]
+	s.mu.Unlock()
+	if r.Method == "GET" {
+		s.render(w, r, http.StatusOK, data)
+		return
+	}
+	s.resend(w, r, u, sid, data)
+}
+
+// POST /messages/:sid/resend
+//
+// Send the message again, unless it's already been resent or is being resent
+// right now - a double click, or two people looking at the same failure,
+// shouldn't send it twice.
+func (s *resendServer) resend(w http.ResponseWriter, r *http.Request, u *config.User, sid string, data *resendData) {
+	s.mu.Lock()
+	if newSid, ok := s.resent[sid]; ok {
+		s.mu.Unlock()
+		data.ResentAs = newSid
+		data.Err = "This message was already resent"
+		s.render(w, r, http.StatusConflict, data)
+		return
+	}
+	if s.sending[sid] {
+		s.mu.Unlock()
+		data.Err = "This message is being resent right now"
+		s.render(w, r, http.StatusConflict, data)
+		return
+	}
+	s.sending[sid] = true
+	s.mu.Unlock()
+
+	ctx, cancel := getContext(r.Context(), 10*time.Second)
+	defer cancel()
+	sent, err := s.Resender.ResendMessage(ctx, u, sid)
+	var newSid string
+	if err == nil {
+		newSid, err = sent.Sid()
+	}
+	s.mu.Lock()
+	delete(s.sending, sid)
+	if err == nil {
+		s.resent[sid] = newSid
+	}
+	s.mu.Unlock()
+	if err != nil {
+		s.Warn("Couldn't resend message", "sid", sid, "user", u.ID(), "err", err)
+		data.Err = "Couldn't resend message: " + cleanError(err)
+		s.render(w, r, http.StatusBadGateway, data)
+		return
+	}
+	s.Info("Resent message", "sid", sid, "new_sid", newSid, "user", u.ID())
+	s.Audit.Record(&services.AuditEvent{
+		User:     u.ID(),
+		Action:   "resend_message",
+		Resource: sid,
+		Details: map[string]string{
+			"new_sid": newSid,
+		},
+	})
+	http.Redirect(w, r, "/messages/"+newSid, http.StatusFound)
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+const failedSid = "SM11111111111111111111111111111111"
+
+// resendFixtures returns a message that failed to send.
+func resendFixtures() *views.Fixtures {
+	return &views.Fixtures{Messages: []*twilio.Message{{
+		Sid:          failedSid,
+		Body:         "Your code is 1234",
+		From:         "+19253920364",
+		To:           "+14105551234",
+		Status:       twilio.StatusFailed,
+		Direction:    twilio.DirectionOutboundAPI,
+		NumSegments:  1,
+		ErrorCode:    30003,
+		ErrorMessage: "Unreachable destination handset",
+		DateCreated:  twilio.TwilioTime{Valid: true, Time: time.Now().UTC()},
+	}}}
+}
+
+func newTestResendServer(t *testing.T, ts *harness.TwilioServer) *resendServer {
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: ts.Server, SecretKey: key})
+	audit, _ := services.NewAuditLog(NullLogger, "")
+	s, err := newResendServer(NullLogger, vc, vc.(views.Resender), audit, lf, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestResendForbidden(t *testing.T) {
+	t.Parallel()
+	ts := harness.NewTwilioServer(resendFixtures())
+	defer ts.Close()
+	s := newTestResendServer(t, ts)
+	us := config.AllUserSettings()
+	us.CanResendMessages = false
+	req, _ := http.NewRequest("POST", "/messages/"+failedSid+"/resend", nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected 403, got %d", w.Code)
+	}
+	if posts := ts.Posts(); len(posts) != 0 {
+		t.Errorf("expected no messages to be sent, got %d", len(posts))
+	}
+}
+
+func TestResendConfirmation(t *testing.T) {
+	t.Parallel()
+	ts := harness.NewTwilioServer(resendFixtures())
+	defer ts.Close()
+	s := newTestResendServer(t, ts)
+	req, _ := http.NewRequest("GET", "/messages/"+failedSid+"/resend", nil)
+	req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `action="/messages/`+failedSid+`/resend"`) {
+		t.Errorf("expected a confirmation form, got %s", body)
+	}
+	if posts := ts.Posts(); len(posts) != 0 {
+		t.Errorf("expected GET not to send anything, got %d messages", len(posts))
+	}
+}
+
+func TestResendOnlyOnce(t *testing.T) {
+	t.Parallel()
+	ts := harness.NewTwilioServer(resendFixtures())
+	defer ts.Close()
+	s := newTestResendServer(t, ts)
+	u := config.NewUser(config.AllUserSettings())
+	req, _ := http.NewRequest("POST", "/messages/"+failedSid+"/resend", nil)
+	req = config.SetUser(req, u)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 302 {
+		t.Fatalf("expected 302, got %d: %s", w.Code, w.Body.String())
+	}
+	created := ts.Created()
+	if len(created) != 1 {
+		t.Fatalf("expected one message to be sent, got %d", len(created))
+	}
+	resentSid := created[0].Sid
+	if loc := w.Header().Get("Location"); loc != "/messages/"+resentSid {
+		t.Errorf("expected redirect to the new message, got %q", loc)
+	}
+	form := ts.Posts()[0]
+	if form.Get("From") != "+19253920364" || form.Get("To") != "+14105551234" || form.Get("Body") != "Your code is 1234" {
+		t.Errorf("expected the original from, to and body, got %v", form)
+	}
+
+	req, _ = http.NewRequest("POST", "/messages/"+failedSid+"/resend", nil)
+	req = config.SetUser(req, u)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 409 {
+		t.Errorf("expected a second resend to conflict, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), resentSid) {
+		t.Errorf("expected a link to the first resend, got %s", w.Body.String())
+	}
+	if created := ts.Created(); len(created) != 1 {
+		t.Errorf("expected the message to be sent once, got %d", len(created))
+	}
+}
+
+func TestResendFeatureOff(t *testing.T) {
+	t.Parallel()
+	ts := harness.NewTwilioServer(resendFixtures())
+	defer ts.Close()
+	s := newTestResendServer(t, ts)
+	h := requireFeature(config.FeatureResendMessages, s)
+	u := config.NewUser(config.AllUserSettings()).WithFeatures(config.Features{
+		config.FeatureResendMessages: false,
+	})
+	req, _ := http.NewRequest("POST", "/messages/"+failedSid+"/resend", nil)
+	req = config.SetUser(req, u)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != 404 {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+	if posts := ts.Posts(); len(posts) != 0 {
+		t.Errorf("expected no messages to be sent, got %d", len(posts))
+	}
+}
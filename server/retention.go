@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+// retentionPurger purges expired local data when it starts, and every
+// Interval after that.
+type retentionPurger struct {
+	log.Logger
+	Manager  *services.RetentionManager
+	Interval time.Duration
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newRetentionPurger(l log.Logger, m *services.RetentionManager, interval time.Duration) *retentionPurger {
+	return &retentionPurger{
+		Logger:   l,
+		Manager:  m,
+		Interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+func (p *retentionPurger) Run() {
+	p.Manager.Purge(time.Now())
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.Manager.Purge(time.Now())
+		}
+	}
+}
+
+// Stop stops purging data. A purge that's running finishes.
+func (p *retentionPurger) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.done)
+	})
+}
+
+type retentionServer struct {
+	Manager *services.RetentionManager
+}
+
+// GET /debug/retention
+//
+// Show what each retention policy has purged, as JSON. Requires can_profile.
+func (s *retentionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanProfile() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to profile the server"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s.Manager.Report())
+}
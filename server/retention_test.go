@@ -0,0 +1,23 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saintpete/logrole/config"
+)
+
+func TestRetentionServerForbidden(t *testing.T) {
+	t.Parallel()
+	us := config.AllUserSettings()
+	us.CanProfile = false
+	s := &retentionServer{}
+	req, _ := http.NewRequest("GET", "/debug/retention", nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected users without can_profile to get a 403, got %d", w.Code)
+	}
+}
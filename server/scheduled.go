@@ -0,0 +1,155 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+)
+
+var scheduledRoute = regexp.MustCompile(`^/messages/scheduled$`)
+var messageCancelRoute = regexp.MustCompile("^/messages/" + messagePattern + "/cancel$")
+
+// scheduledServer lists the messages Messaging Services will send later, and
+// cancels them. Canceling requires the can_cancel_messages permission.
+type scheduledServer struct {
+	log.Logger
+	Scheduler      views.Scheduler
+	Audit          *services.AuditLog
+	LocationFinder services.LocationFinder
+	// False in read-only mode.
+	AllowCancel bool
+	tpl         *template.Template
+}
+
+func newScheduledServer(l log.Logger, vc views.Client, sc views.Scheduler, audit *services.AuditLog, lf services.LocationFinder, labels *services.LabelStore, owners *services.OwnerStore) (*scheduledServer, error) {
+	tpl, err := newTpl(template.FuncMap{
+		"is_our_pn": vc.IsTwilioNumber,
+		"pn_label":  labels.Get,
+		"pn_owner":  owners.Get,
+	}, base+scheduledTpl+phoneTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &scheduledServer{
+		Logger:         l,
+		Scheduler:      sc,
+		Audit:          audit,
+		LocationFinder: lf,
+		AllowCancel:    true,
+		tpl:            tpl,
+	}, nil
+}
+
+type scheduledData struct {
+	Messages    []*views.ScheduledMessage
+	Loc         *time.Location
+	AllowCancel bool
+	// Sid of the message that was just canceled, if any.
+	Canceled string
+	Err      string
+}
+
+func (d *scheduledData) Title() string {
+	return "Scheduled Messages"
+}
+
+func (d *scheduledData) Path() string {
+	return "/messages/scheduled"
+}
+
+func (s *scheduledServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewMessages() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	if r.Method == "POST" {
+		s.cancel(w, r, u)
+		return
+	}
+	data := &scheduledData{}
+	if sid := r.URL.Query().Get("canceled"); sid != "" && smsSid.MatchString(sid) {
+		data.Canceled = sid
+	}
+	s.render(w, r, u, http.StatusOK, data)
+}
+
+// render fetches the scheduled messages and shows them, along with any error
+// in data.
+func (s *scheduledServer) render(w http.ResponseWriter, r *http.Request, u *config.User, code int, data *scheduledData) {
+	data.Loc = s.LocationFinder.GetLocationReq(r)
+	data.AllowCancel = s.AllowCancel
+	ctx, cancel := getContext(r.Context(), 10*time.Second)
+	defer cancel()
+	messages, err := s.Scheduler.GetScheduledMessages(ctx, u)
+	if err != nil {
+		if data.Err == "" {
+			data.Err = "Couldn't find scheduled messages: " + cleanError(err)
+		}
+		if code == http.StatusOK {
+			code = http.StatusBadGateway
+		}
+	}
+	data.Messages = messages
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// POST /messages/:sid/cancel
+//
+// Stop Twilio from sending a scheduled message, then go back to the list.
+func (s *scheduledServer) cancel(w http.ResponseWriter, r *http.Request, u *config.User) {
+	if !s.AllowCancel {
+		rest.Forbidden(w, r, &rest.Error{Title: "Messages can't be canceled in read-only mode"})
+		return
+	}
+	if !u.CanCancelMessages() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to cancel messages"})
+		return
+	}
+	sid := messageCancelRoute.FindStringSubmatch(r.URL.Path)[1]
+	ctx, cancel := getContext(r.Context(), 10*time.Second)
+	defer cancel()
+	_, err := s.Scheduler.CancelScheduledMessage(ctx, u, sid)
+	switch err {
+	case nil:
+		break
+	case config.PermissionDenied, config.ErrTooOld:
+		rest.Forbidden(w, r, &rest.Error{Title: err.Error()})
+		return
+	case views.ErrNotScheduled:
+		s.render(w, r, u, http.StatusBadRequest, &scheduledData{Err: err.Error()})
+		return
+	default:
+		if terr, ok := err.(*rest.Error); ok && terr.StatusCode == 404 {
+			rest.NotFound(w, r)
+			return
+		}
+		s.Warn("Couldn't cancel message", "sid", sid, "user", u.ID(), "err", err)
+		s.render(w, r, u, http.StatusBadGateway, &scheduledData{Err: "Couldn't cancel message: " + cleanError(err)})
+		return
+	}
+	s.Info("Canceled scheduled message", "sid", sid, "user", u.ID())
+	s.Audit.Record(&services.AuditEvent{
+		User:     u.ID(),
+		Action:   "cancel_message",
+		Resource: sid,
+	})
+	http.Redirect(w, r, "/messages/scheduled?canceled="+url.QueryEscape(sid), http.StatusFound)
+}
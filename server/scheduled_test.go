@@ -0,0 +1,135 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	types "github.com/kevinburke/go-types"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+const scheduledSid = "SM33333333333333333333333333333333"
+const deliveredSid = "SM44444444444444444444444444444444"
+
+// scheduledFixtures returns a delivered message and one a Messaging Service
+// will send later, with the given status.
+func scheduledFixtures(status twilio.Status) *views.Fixtures {
+	now := twilio.TwilioTime{Valid: true, Time: time.Now().UTC()}
+	message := func(sid string, status twilio.Status) *twilio.Message {
+		return &twilio.Message{
+			Sid:                 sid,
+			Body:                "Your appointment is tomorrow",
+			To:                  "+14105551234",
+			Status:              status,
+			Direction:           twilio.DirectionOutboundAPI,
+			NumSegments:         1,
+			MessagingServiceSid: types.NullString{Valid: true, String: "MG123"},
+			DateCreated:         now,
+		}
+	}
+	return &views.Fixtures{Messages: []*twilio.Message{
+		message(deliveredSid, twilio.StatusDelivered),
+		message(scheduledSid, status),
+	}}
+}
+
+func newTestScheduledServer(t *testing.T, ts *harness.TwilioServer) *scheduledServer {
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: ts.Server, SecretKey: key})
+	audit, _ := services.NewAuditLog(NullLogger, "")
+	s, err := newScheduledServer(NullLogger, vc, vc.(views.Scheduler), audit, lf, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestScheduledList(t *testing.T) {
+	t.Parallel()
+	ts := harness.NewTwilioServer(scheduledFixtures(views.StatusScheduled))
+	defer ts.Close()
+	s := newTestScheduledServer(t, ts)
+	req, _ := http.NewRequest("GET", "/messages/scheduled", nil)
+	req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `action="/messages/`+scheduledSid+`/cancel"`) {
+		t.Errorf("expected a cancel form for the scheduled message, got %s", body)
+	}
+	if strings.Contains(body, deliveredSid) {
+		t.Errorf("expected the delivered message not to be listed, got %s", body)
+	}
+}
+
+func TestCancelScheduled(t *testing.T) {
+	t.Parallel()
+	ts := harness.NewTwilioServer(scheduledFixtures(views.StatusScheduled))
+	defer ts.Close()
+	s := newTestScheduledServer(t, ts)
+	req, _ := http.NewRequest("POST", "/messages/"+scheduledSid+"/cancel", nil)
+	req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 302 {
+		t.Fatalf("expected 302, got %d: %s", w.Code, w.Body.String())
+	}
+	if loc := w.Header().Get("Location"); loc != "/messages/scheduled?canceled="+scheduledSid {
+		t.Errorf("expected redirect to the scheduled messages, got %q", loc)
+	}
+	updated := ts.Posts()
+	if len(updated) != 1 {
+		t.Fatalf("expected the message to be updated once, got %d", len(updated))
+	}
+	if status := updated[0].Get("Status"); status != "canceled" {
+		t.Errorf("expected Status to be canceled, got %q", status)
+	}
+}
+
+func TestCancelScheduledForbidden(t *testing.T) {
+	t.Parallel()
+	ts := harness.NewTwilioServer(scheduledFixtures(views.StatusScheduled))
+	defer ts.Close()
+	s := newTestScheduledServer(t, ts)
+	us := config.AllUserSettings()
+	us.CanCancelMessages = false
+	req, _ := http.NewRequest("POST", "/messages/"+scheduledSid+"/cancel", nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected 403, got %d", w.Code)
+	}
+	if posts := ts.Posts(); len(posts) != 0 {
+		t.Errorf("expected no messages to be canceled, got %d", len(posts))
+	}
+}
+
+func TestCancelSentMessage(t *testing.T) {
+	t.Parallel()
+	ts := harness.NewTwilioServer(scheduledFixtures(twilio.StatusSent))
+	defer ts.Close()
+	s := newTestScheduledServer(t, ts)
+	req, _ := http.NewRequest("POST", "/messages/"+scheduledSid+"/cancel", nil)
+	req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Only scheduled messages") {
+		t.Errorf("expected an error saying the message isn't scheduled, got %s", w.Body.String())
+	}
+	if posts := ts.Posts(); len(posts) != 0 {
+		t.Errorf("expected no messages to be canceled, got %d", len(posts))
+	}
+}
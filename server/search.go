@@ -3,15 +3,25 @@ package server
 import (
 	"html/template"
 	"net/http"
+	"net/url"
 	"regexp"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
 	twilio "github.com/saintpete/twilio-go"
 )
 
 type searchServer struct {
 	log.Logger
+	// Queries that don't look like a sid or phone number are matched
+	// against these labels. May be nil.
+	Labels *services.LabelStore
+	// Queries that match nothing else are matched against notes, then the
+	// text in attachments. May be nil.
+	Notes          *services.NoteStore
+	AttachmentText *services.AttachmentTextStore
 }
 
 var smsSid = regexp.MustCompile("^" + messagePattern + "$")
@@ -47,9 +57,35 @@ func (s *searchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/phone-numbers/"+q, http.StatusMovedPermanently)
 		return
 	}
+	// Five digit short codes look the same; the results page links to the
+	// number too.
+	if errorCodeQuery.MatchString(q) {
+		http.Redirect(w, r, "/search/errors?code="+q, http.StatusFound)
+		return
+	}
 	num, err := twilio.NewPhoneNumber(q)
 	if err == nil && len(num) > 3 {
 		http.Redirect(w, r, "/phone-numbers/"+string(num), http.StatusFound)
+		return
+	}
+	if s.Labels != nil {
+		labels := s.Labels.Search(q)
+		if len(labels) == 1 {
+			http.Redirect(w, r, "/phone-numbers/"+string(labels[0].PhoneNumber), http.StatusFound)
+			return
+		}
+		if len(labels) > 1 {
+			http.Redirect(w, r, "/labels?q="+url.QueryEscape(q), http.StatusFound)
+			return
+		}
+	}
+	if u, ok := config.GetUser(r); ok && u.CanViewNotes() && len(q) >= minNoteQueryLength && len(s.Notes.Search(q, 1)) > 0 {
+		http.Redirect(w, r, "/search/notes?q="+url.QueryEscape(q), http.StatusFound)
+		return
+	}
+	if u, ok := config.GetUser(r); ok && u.CanViewMedia() && len(q) >= minAttachmentQueryLength && len(s.AttachmentText.Search(q, 1)) > 0 {
+		http.Redirect(w, r, "/search/attachments?q="+url.QueryEscape(q), http.StatusFound)
+		return
 	}
 	s.Warn("Unknown search query", "q", q)
 	http.Redirect(w, r, "/", http.StatusFound)
@@ -3,7 +3,10 @@ package server
 import (
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+
+	"github.com/saintpete/logrole/services"
 )
 
 const mms = "MM89a8c4a6891c53054e9cd604922bfb61"
@@ -22,11 +25,12 @@ var searchTests = []struct {
 	{"/search?q=" + alert, 301, "/alerts/" + alert},
 	{"/search?", 302, "/"},
 	{"/search?q=unknown", 302, "/"},
+	{"/search?q=30006", 302, "/search/errors?code=30006"},
 }
 
 func TestSearchRedirects(t *testing.T) {
 	t.Parallel()
-	s := &searchServer{dlog}
+	s := &searchServer{Logger: dlog}
 	for _, tt := range searchTests {
 		req, _ := http.NewRequest("GET", tt.in, nil)
 		w := httptest.NewRecorder()
@@ -39,3 +43,30 @@ func TestSearchRedirects(t *testing.T) {
 		}
 	}
 }
+
+func TestSearchLabels(t *testing.T) {
+	t.Parallel()
+	labels, err := services.NewLabelStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels.Set("+14155551234", "Main support line")
+	labels.Set("+14155556789", "Support overflow")
+	s := &searchServer{Logger: dlog, Labels: labels}
+	var tests = []struct {
+		q        string
+		location string
+	}{
+		{"main support", "/phone-numbers/+14155551234"},
+		{"support", "/labels?q=support"},
+		{"nothing", "/"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/search?q="+url.QueryEscape(tt.q), nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if location := w.Header().Get("Location"); location != tt.location {
+			t.Errorf("search %q: expected Location to equal %s, got %s", tt.q, tt.location, location)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"golang.org/x/net/context"
+)
+
+// cspNoncePlaceholder is rendered by the csp_nonce template function, and
+// replaced with the request's nonce by a pageWriter.
+const cspNoncePlaceholder = "logrole-csp-nonce-placeholder"
+
+var cspNonceKey ctxVar = 3
+
+// The largest CSP report we'll read. Browsers send a few hundred bytes.
+const maxCSPReportBytes = 16 * 1024
+
+// How many CSP reports to keep for /debug/csp.
+const cspReportsKept = 50
+
+func newCSPNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// getCSPNonce returns the nonce that inline scripts need to run, or the empty
+// string if the request didn't go through withSecurityHeaders.
+func getCSPNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceKey).(string)
+	return nonce
+}
+
+// withSecurityHeaders sends the Content-Security-Policy and the other headers
+// in s with every response. Each request gets a new nonce, which inline
+// scripts put in their nonce attribute with the csp_nonce template function.
+func withSecurityHeaders(h http.Handler, s *config.SecurityHeaders) http.Handler {
+	if s == nil {
+		s = config.DefaultSecurityHeaders
+	}
+	cspHeader := "Content-Security-Policy"
+	if s.ReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	frameOptions := s.FrameOptions()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce, err := newCSPNonce()
+		if err != nil {
+			rest.ServerError(w, r, err)
+			return
+		}
+		w.Header().Set(cspHeader, s.ContentSecurityPolicy(nonce))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", s.ReferrerPolicy)
+		if frameOptions != "" {
+			w.Header().Set("X-Frame-Options", frameOptions)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), cspNonceKey, nonce))
+		h.ServeHTTP(w, r)
+	})
+}
+
+// A cspReport is something a browser refused to load or run on one of our
+// pages.
+type cspReport struct {
+	Received           time.Time `json:"received"`
+	DocumentURI        string    `json:"document-uri"`
+	ViolatedDirective  string    `json:"violated-directive"`
+	EffectiveDirective string    `json:"effective-directive"`
+	BlockedURI         string    `json:"blocked-uri"`
+	SourceFile         string    `json:"source-file"`
+	LineNumber         int       `json:"line-number"`
+}
+
+// cspReportServer collects the reports browsers send when the
+// Content-Security-Policy blocks something, and keeps the latest ones.
+type cspReportServer struct {
+	log.Logger
+	mu      sync.Mutex
+	total   int
+	reports []*cspReport
+}
+
+// keepReports copies the reports old has collected to s, so reloading the
+// config doesn't lose them.
+func (s *cspReportServer) keepReports(old *cspReportServer) {
+	old.mu.Lock()
+	total := old.total
+	reports := append([]*cspReport{}, old.reports...)
+	old.mu.Unlock()
+	s.mu.Lock()
+	s.total = total
+	s.reports = reports
+	s.mu.Unlock()
+}
+
+// stripQuery removes the query string and fragment from uri, since they can
+// have phone numbers in them.
+func stripQuery(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// POST /csp-report
+//
+// Browsers post reports here without credentials, so this doesn't need
+// authentication.
+//
+// GET /debug/csp
+//
+// Show the number of reports and the latest ones as JSON. Reports can have
+// message and call sids in them, so this requires can_profile.
+func (s *cspReportServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		u, ok := config.GetUser(r)
+		if !ok {
+			rest.ServerError(w, r, errors.New("No user available"))
+			return
+		}
+		if !u.CanProfile() {
+			rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to profile the server"})
+			return
+		}
+		s.mu.Lock()
+		data := struct {
+			Total   int          `json:"total"`
+			Reports []*cspReport `json:"reports"`
+		}{s.total, s.reports}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(data)
+		s.mu.Unlock()
+		return
+	}
+	var body struct {
+		Report *cspReport `json:"csp-report"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCSPReportBytes)).Decode(&body); err != nil || body.Report == nil {
+		rest.BadRequest(w, r, &rest.Error{Title: "Couldn't parse the CSP report"})
+		return
+	}
+	report := body.Report
+	report.Received = time.Now().UTC()
+	report.DocumentURI = stripQuery(report.DocumentURI)
+	report.BlockedURI = stripQuery(report.BlockedURI)
+	report.SourceFile = stripQuery(report.SourceFile)
+	s.Warn("Content-Security-Policy violation", "page", report.DocumentURI,
+		"directive", report.ViolatedDirective, "blocked", report.BlockedURI)
+	s.mu.Lock()
+	s.total++
+	s.reports = append(s.reports, report)
+	if len(s.reports) > cspReportsKept {
+		s.reports = s.reports[len(s.reports)-cspReportsKept:]
+	}
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saintpete/logrole/config"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	t.Parallel()
+	tpl := template.Must(template.New("base").Funcs(funcMap).Parse(`<script nonce="{{ csp_nonce }}"></script>`))
+	var rendered string
+	h := withSecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := render(&buf, r, tpl, "base", &baseData{}); err != nil {
+			t.Fatal(err)
+		}
+		rendered = buf.String()
+	}), nil)
+	req, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	csp := w.Header().Get("Content-Security-Policy")
+	i := strings.Index(csp, "'nonce-")
+	if i < 0 {
+		t.Fatalf("expected a nonce in the policy, got %q", csp)
+	}
+	nonce := csp[i+len("'nonce-"):]
+	nonce = nonce[:strings.Index(nonce, "'")]
+	if want := `<script nonce="` + nonce + `">`; !strings.Contains(rendered, want) {
+		t.Errorf("expected page to contain %q, got %s", want, rendered)
+	}
+	if h := w.Header().Get("X-Content-Type-Options"); h != "nosniff" {
+		t.Errorf("expected nosniff, got %q", h)
+	}
+	if h := w.Header().Get("X-Frame-Options"); h != "DENY" {
+		t.Errorf("expected X-Frame-Options DENY, got %q", h)
+	}
+	if h := w.Header().Get("Referrer-Policy"); h != "same-origin" {
+		t.Errorf("expected Referrer-Policy same-origin, got %q", h)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Header().Get("Content-Security-Policy") == csp {
+		t.Errorf("expected a new nonce for each request")
+	}
+}
+
+func TestCSPReport(t *testing.T) {
+	t.Parallel()
+	s := &cspReportServer{Logger: NullLogger}
+	body := `{"csp-report": {"document-uri": "https://logrole.example.com/messages?to=%2B14105551234", "violated-directive": "script-src", "blocked-uri": "inline"}}`
+	req, _ := http.NewRequest("POST", "/csp-report", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/csp-report")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 204 {
+		t.Fatalf("expected Code to be 204, got %d", w.Code)
+	}
+	req, _ = http.NewRequest("POST", "/csp-report", strings.NewReader("not json"))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Errorf("expected a bad report to get a 400, got %d", w.Code)
+	}
+
+	us := config.AllUserSettings()
+	us.CanProfile = false
+	req, _ = http.NewRequest("GET", "/debug/csp", nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected users without can_profile to get a 403, got %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/debug/csp", nil)
+	req = config.SetUser(req, config.NewUser(config.AllUserSettings()))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	out := w.Body.String()
+	if !strings.Contains(out, `"total": 1`) || !strings.Contains(out, `"document-uri": "https://logrole.example.com/messages"`) {
+		t.Errorf("expected one report without its query string, got %s", out)
+	}
+	if strings.Contains(out, "4105551234") {
+		t.Errorf("expected the phone number to be removed, got %s", out)
+	}
+}
@@ -19,7 +19,9 @@ import (
 	"github.com/kevinburke/handlers"
 	"github.com/kevinburke/rest"
 	"github.com/saintpete/logrole/assets"
+	"github.com/saintpete/logrole/cache"
 	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/jobs"
 	"github.com/saintpete/logrole/services"
 	"github.com/saintpete/logrole/views"
 )
@@ -73,6 +75,49 @@ func whitelistIPs(h http.Handler, l log.Logger, nets []*net.IPNet) http.Handler
 	})
 }
 
+// readOnlyRoutes accept POST requests, but only change a user's own settings
+// and jobs, or the ticket references and notes people attach to a message or
+// call, so they're still available in read-only mode. Admin changes aren't in
+// the list: reloading the config, granting permissions, breaking glass,
+// revoking sessions, purging the media cache, and editing or importing labels
+// and owners.
+var readOnlyRoutes = []*regexp.Regexp{
+	regexp.MustCompile(`^/tz$`),
+	regexp.MustCompile(`^/preferences$`),
+	regexp.MustCompile(`^/debug/webhook$`),
+	regexp.MustCompile(`^/jobs$`),
+	regexp.MustCompile(`^/traffic$`),
+	regexp.MustCompile(`^/tickets$`),
+	regexp.MustCompile(`^/notes$`),
+	messageTranslateRoute,
+}
+
+var errReadOnly = &rest.Error{
+	Title: "This site is in read-only mode",
+	ID:    "read_only",
+}
+
+// readOnly rejects every request that could change data - anything other than
+// a GET, HEAD or OPTIONS request to a route that's not in allowed - before it
+// reaches a handler, so no user can make changes regardless of their
+// permissions.
+func readOnly(h http.Handler, l log.Logger, allowed []*regexp.Regexp) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		for _, route := range allowed {
+			if route.MatchString(r.URL.Path) {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		l.Info("Rejecting request in read-only mode", "method", r.Method, "path", r.URL.Path)
+		rest.Forbidden(w, r, errReadOnly)
+	})
+}
+
 func UpgradeInsecureHandler(h http.Handler, allowUnencryptedTraffic bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if allowUnencryptedTraffic == false {
@@ -94,48 +139,44 @@ func UpgradeInsecureHandler(h http.Handler, allowUnencryptedTraffic bool) http.H
 
 // Static file HTTP server; all assets are packaged up in the assets directory
 // with go-bindata.
-type static struct {
-	modTime time.Time
+// staticURL returns the URL for a static file, with a hash of its contents
+// in the name - see assets.HashedName.
+func staticURL(path string) string {
+	return "/" + assets.HashedName(strings.TrimPrefix(path, "/"))
 }
 
+// Hashed URLs change whenever the file does, so browsers never need to check
+// them again.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+type static struct{}
+
+// ServeHTTP serves static files. Files requested by their hashed name are
+// cached forever. Files requested by their plain name, or by a hash from an
+// older build, get the current contents and must be revalidated with the
+// ETag.
 func (s *static) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path == "/favicon.ico" {
-		r.URL.Path = "/static/favicon.ico"
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if name == "favicon.ico" {
+		name = "static/favicon.ico"
+	}
+	cacheControl := "no-cache"
+	if unhashed, ok := assets.Unhash(name); ok {
+		name = unhashed
+		cacheControl = immutableCacheControl
+	} else if unhashed, ok := assets.StripHash(name); ok {
+		name = unhashed
 	}
-	bits, err := assets.Asset(strings.TrimPrefix(r.URL.Path, "/"))
+	bits, err := assets.Asset(name)
 	if err != nil {
 		rest.NotFound(w, r)
 		return
 	}
-	http.ServeContent(w, r, r.URL.Path, s.modTime, bytes.NewReader(bits))
-}
-
-type indexServer struct {
-	tpl *template.Template
-}
-
-func newIndexServer() (*indexServer, error) {
-	indexTemplate, err := newTpl(template.FuncMap{}, base+indexTpl)
-	if err != nil {
-		return nil, err
-	}
-	return &indexServer{tpl: indexTemplate}, nil
-}
-
-type indexData struct {
-	baseData
-}
-
-func (i *indexData) Title() string {
-	return "Homepage"
-}
-
-func (i *indexServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	data := &baseData{Data: &indexData{}}
-	if err := render(w, r, i.tpl, "base", data); err != nil {
-		rest.ServerError(w, r, err)
+	w.Header().Set("Cache-Control", cacheControl)
+	if hash, ok := assets.Hash(name); ok {
+		w.Header().Set("ETag", `"`+hash+`"`)
 	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(bits))
 }
 
 type openSourceServer struct {
@@ -172,13 +213,181 @@ type Server struct {
 	vc       views.Client
 	DoneChan chan bool
 	PageSize uint
+	// nil unless settings.StuckMessageThreshold is set.
+	stuck *stuckMonitor
+	// nil unless settings.NumberInventory is set.
+	inventory *numberInventoryMonitor
+	// nil unless settings.CacheSnapshotFile is set.
+	snapshots *cacheSnapshotter
+	// nil unless settings.ReplicationPeers is set.
+	replicator *cacheReplicator
+	// nil if settings.DisablePrefetch is set.
+	prefetch *prefetcher
+	// nil unless settings.Retention has a policy for a store.
+	purger *retentionPurger
+	// nil if there's no auth token to check status callbacks with.
+	reconciler *statusReconciler
+	// nil unless settings.TextExtractor is set.
+	indexer *attachmentIndexer
+	// nil unless settings.BodyHashes is set.
+	bodies *bodyIndexer
+	// nil unless settings.MediaCacheDir is set.
+	mediaCache *cache.BlobStore
+	exports    *jobs.Queue
+	// Used to look up the owners of resumed exports. May be nil.
+	policy *config.Policy
+
+	// Data kept in memory, which the next Server takes over when the config
+	// is reloaded. apiCache is nil for archived accounts.
+	webhooks   *services.WebhookStore
+	cspReports *cspReportServer
+	grants     *config.GrantStore
+	notes      *services.NoteStore
+	audit      *services.AuditLog
+	apiCache   views.Snapshotter
+	accountSid string
 }
 
 func (s *Server) Close() error {
+	if s.stuck != nil {
+		s.stuck.Stop()
+	}
+	if s.inventory != nil {
+		s.inventory.Stop()
+	}
+	if s.snapshots != nil {
+		s.snapshots.Stop()
+	}
+	if s.replicator != nil {
+		s.replicator.Stop()
+	}
+	if s.prefetch != nil {
+		s.prefetch.Stop()
+	}
+	if s.purger != nil {
+		s.purger.Stop()
+	}
+	if s.reconciler != nil {
+		s.reconciler.Stop()
+	}
+	if s.indexer != nil {
+		s.indexer.Stop()
+	}
+	if s.bodies != nil {
+		s.bodies.Stop()
+	}
 	s.DoneChan <- true
 	return nil
 }
 
+// MonitorStuckMessages starts checking for stuck messages in the background,
+// if a stuck message threshold is configured.
+func (s *Server) MonitorStuckMessages() {
+	if s.stuck != nil {
+		go s.stuck.Run()
+	}
+}
+
+// MonitorNumberInventory starts taking snapshots of the account's phone
+// numbers in the background, if number_inventory is set.
+func (s *Server) MonitorNumberInventory() {
+	if s.inventory != nil {
+		go s.inventory.Run()
+	}
+}
+
+// SaveCacheSnapshots starts saving the API cache to disk in the background,
+// if a snapshot file is configured.
+func (s *Server) SaveCacheSnapshots() {
+	if s.snapshots != nil {
+		go s.snapshots.Run()
+	}
+}
+
+// ReplicateCache starts sending cached API responses to the other instances
+// in the background, if any are configured.
+func (s *Server) ReplicateCache() {
+	if s.replicator != nil {
+		go s.replicator.Run()
+	}
+}
+
+// PrefetchNextPages starts the workers that fetch the next page of each list
+// into the cache, unless prefetching is disabled.
+func (s *Server) PrefetchNextPages() {
+	if s.prefetch != nil {
+		go s.prefetch.Run()
+	}
+}
+
+// PurgeExpiredData starts deleting local data that's older than its
+// retention policy in the background, if any policy is configured.
+func (s *Server) PurgeExpiredData() {
+	if s.purger != nil {
+		go s.purger.Run()
+	}
+}
+
+// ReconcileStatuses starts re-fetching messages that haven't had a status
+// callback in a while, if status callbacks are enabled.
+func (s *Server) ReconcileStatuses() {
+	if s.reconciler != nil {
+		go s.reconciler.Run()
+	}
+}
+
+// IndexAttachments starts extracting the text in attachments of the messages
+// people view in the background, if a text extractor is configured.
+func (s *Server) IndexAttachments() {
+	if s.indexer != nil {
+		go s.indexer.Run()
+	}
+}
+
+// HashBodies starts hashing the bodies of the messages people view in the
+// background, if body hashing is enabled.
+func (s *Server) HashBodies() {
+	if s.bodies != nil {
+		go s.bodies.Run()
+	}
+}
+
+// RekeyMediaCache encrypts cached media that isn't encrypted with the current
+// media cache key with it in the background, so old keys can be removed from
+// the config.
+func (s *Server) RekeyMediaCache() {
+	if s.mediaCache == nil {
+		return
+	}
+	go func() {
+		n, err := s.mediaCache.Rekey()
+		if err != nil {
+			s.mediaCache.Warn("Couldn't encrypt cached media with the current key", "err", err)
+			return
+		}
+		if n > 0 {
+			s.mediaCache.Info("Encrypted cached media with the current key", "count", n)
+		}
+	}()
+}
+
+// ResumeExports restarts the exports that hadn't finished when the server last
+// stopped, if settings.ExportsDir is set. Each export runs as its owner, with
+// the permissions the policy gives them now. Only call it once, at startup.
+func (s *Server) ResumeExports() (int, error) {
+	return s.exports.Resume(func(owner string, state []byte) (jobs.Resumable, error) {
+		u := config.DefaultUser
+		if s.policy != nil {
+			var err error
+			u, _, err = s.policy.Lookup(owner)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return resumeExport(s.vc, u, state)
+	})
+}
+
 func (s *Server) CacheCommonQueries() {
 	go s.vc.CacheCommonQueries(s.PageSize, s.DoneChan)
 }
@@ -228,8 +437,7 @@ func (ls *loginServer) Serve(w http.ResponseWriter, r *http.Request, URL string)
 // authentication is successful, we set the User in the request context and
 // continue.
 func AddAuthenticator(h http.Handler, ls *loginServer, a config.Authenticator) http.Handler {
-	// TODO
-	o, ok := a.(*config.GoogleAuthenticator)
+	o, ok := a.(config.OAuthAuthenticator)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		u, err := a.Authenticate(w, r)
 		if err == config.MustLogin {
@@ -250,6 +458,17 @@ func AddAuthenticator(h http.Handler, ls *loginServer, a config.Authenticator) h
 
 // NewServer returns a new Handler that can serve the website.
 func NewServer(settings *config.Settings) (*Server, error) {
+	return newServer(settings, nil)
+}
+
+// newServer returns a new Server. If rl is not nil, the Server shares its
+// export queue, serves /admin/reload, and takes over the data the Server it
+// replaces only kept in memory.
+func newServer(settings *config.Settings, rl *Reloader) (*Server, error) {
+	var prev *Server
+	if rl != nil {
+		prev, _ = rl.server.Load().(*Server)
+	}
 	if settings.Reporter == nil {
 		settings.Reporter = services.GetReporter("noop", "")
 	}
@@ -269,23 +488,135 @@ func NewServer(settings *config.Settings) (*Server, error) {
 	if settings.Logger == nil {
 		return nil, errors.New("Please configure a non-nil Logger")
 	}
-	permission := config.NewPermission(settings.MaxResourceAge)
-	vc := views.NewClient(settings.Logger, settings.Client, settings.SecretKey, permission)
-	mls, err := newMessageListServer(settings.Logger, vc, settings.LocationFinder,
+	if settings.PageSize > config.MaxPageSize {
+		settings.Logger.Warn("Page size is larger than Twilio allows, using the maximum", "page_size", settings.PageSize, "max", config.MaxPageSize)
+		settings.PageSize = config.MaxPageSize
+	}
+	permission := config.NewPermission(settings.MaxResourceAge).
+		WithAlertRedactor(settings.AlertRedactor).
+		WithBlocklist(settings.Blocklist)
+	var vc views.Client
+	var arch *archive
+	if settings.ArchiveDir != "" {
+		arch = &archive{AccountSid: settings.Client.AccountSid}
+		var err error
+		vc, err = views.NewArchiveClient(settings.Logger, settings.ArchiveDir, arch.AccountSid, permission)
+		if err != nil {
+			return nil, err
+		}
+	} else if settings.Demo {
+		arch = &archive{AccountSid: views.DemoAccountSid, Demo: true}
+		vc = views.NewFixtureClient(settings.Logger, arch.AccountSid, permission, views.DemoFixtures(time.Now()))
+	} else {
+		vc = views.NewClient(settings.Logger, settings.Client, settings.SecretKey, permission)
+	}
+	// Snapshots, resending, scheduling, A2P registrations and conversations
+	// only apply to Twilio, so look for them on the Twilio client, not the one
+	// that includes other providers. They only use the main account.
+	twilioClient := vc
+	if arch == nil && len(settings.Subaccounts) > 0 {
+		subaccounts := make([]*views.Account, len(settings.Subaccounts))
+		for i, sub := range settings.Subaccounts {
+			subaccounts[i] = &views.Account{
+				Sid:    sub.Sid,
+				Name:   sub.Name,
+				Alerts: sub.OwnKey,
+				Client: views.NewClient(settings.Logger, sub.Client, settings.SecretKey, permission),
+			}
+		}
+		vc = views.NewAccountsClient(&views.Account{
+			Sid:    settings.Client.AccountSid,
+			Name:   settings.AccountName,
+			Alerts: true,
+			Client: vc,
+		}, subaccounts...)
+	}
+	vc = views.NewProviderClient(vc, settings.SecretKey, permission, views.NewProviders(settings.Providers)...)
+	var snapshots *cacheSnapshotter
+	if settings.CacheSnapshotFile != "" {
+		interval := settings.CacheSnapshotInterval
+		if interval <= 0 {
+			interval = config.DefaultCacheSnapshotInterval
+		}
+		if sn, ok := twilioClient.(views.Snapshotter); ok {
+			snapshots = newCacheSnapshotter(settings.Logger, sn, settings.CacheSnapshotFile,
+				interval, settings.MaxCacheSnapshot)
+			snapshots.Restore()
+		} else {
+			settings.Logger.Info("Archived accounts don't use the API cache, ignoring cache_snapshot_file")
+		}
+	}
+	var apiCache views.Snapshotter
+	var accountSid string
+	if arch == nil && settings.Client != nil {
+		apiCache, _ = twilioClient.(views.Snapshotter)
+		accountSid = settings.Client.AccountSid
+	}
+	if prev != nil && prev.apiCache != nil && apiCache != nil && prev.accountSid == accountSid {
+		keepAPICache(settings.Logger, prev.apiCache, apiCache)
+	}
+	var replicator *cacheReplicator
+	if len(settings.ReplicationPeers) > 0 {
+		bandwidth := settings.ReplicationBandwidth
+		if bandwidth <= 0 {
+			bandwidth = config.DefaultCacheReplicationKBPerSecond * 1024
+		}
+		if settings.ReplicationKey == nil {
+			return nil, errors.New("Cache replication needs a ReplicationKey")
+		}
+		if rp, ok := twilioClient.(views.Replicator); ok {
+			replicator = newCacheReplicator(settings.Logger, rp, settings.ReplicationPeers,
+				bandwidth, settings.ReplicationKey)
+		} else {
+			settings.Logger.Info("Archived accounts don't use the API cache, ignoring cache_replication_peers")
+		}
+	}
+	var chs *cacheHistoryServer
+	if ch, ok := twilioClient.(views.CacheHistorian); ok {
+		ch.KeepCacheGenerations(settings.CacheGenerations)
+		var err error
+		chs, err = newCacheHistoryServer(settings.Logger, ch, settings.LocationFinder, settings.CacheGenerations)
+		if err != nil {
+			return nil, err
+		}
+	} else if settings.CacheGenerations > 1 {
+		settings.Logger.Info("Archived accounts don't use the API cache, ignoring cache_generations")
+	}
+	var prefetch *prefetcher
+	if !settings.DisablePrefetch {
+		workers := settings.PrefetchWorkers
+		if workers <= 0 {
+			workers = config.DefaultPrefetchWorkers
+		}
+		prefetch = newPrefetcher(settings.Logger, workers)
+	}
+	var tickets *ticketer
+	if settings.TicketLinks != nil {
+		scheme := "https://"
+		if settings.AllowUnencryptedTraffic {
+			scheme = "http://"
+		}
+		tickets = &ticketer{
+			Links:   settings.TicketLinks,
+			Store:   settings.Tickets,
+			BaseURL: scheme + settings.PublicHost,
+		}
+	}
+	mls, err := newMessageListServer(settings.Logger, vc, settings.LocationFinder, settings.Labels, settings.Owners,
 		settings.PageSize, settings.MaxResourceAge, settings.SecretKey)
 	if err != nil {
 		return nil, err
 	}
-	mis, err := newMessageInstanceServer(settings.Logger, vc, settings.LocationFinder, settings.ShowMediaByDefault)
+	mis, err := newMessageInstanceServer(settings.Logger, vc, settings.LocationFinder, settings.Labels, settings.Owners, tickets, settings.ShowMediaByDefault)
 	if err != nil {
 		return nil, err
 	}
-	cls, err := newCallListServer(settings.Logger, vc, settings.LocationFinder,
+	cls, err := newCallListServer(settings.Logger, vc, settings.LocationFinder, settings.Labels, settings.Owners,
 		settings.PageSize, settings.MaxResourceAge, settings.SecretKey)
 	if err != nil {
 		return nil, err
 	}
-	cis, err := newCallInstanceServer(settings.Logger, vc, settings.LocationFinder)
+	cis, err := newCallInstanceServer(settings.Logger, vc, settings.LocationFinder, settings.Labels, settings.Owners, tickets)
 	if err != nil {
 		return nil, err
 	}
@@ -306,6 +637,7 @@ func NewServer(settings *config.Settings) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
+	alts := newAlertTrendServer(settings.Logger, vc, settings.LocationFinder, settings.MaxResourceAge)
 	ais, err := newAlertInstanceServer(settings.Logger, vc, settings.LocationFinder)
 	if err != nil {
 		return nil, err
@@ -315,40 +647,239 @@ func NewServer(settings *config.Settings) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	nis, err := newNumberInstanceServer(settings.Logger, vc, settings.LocationFinder)
+	mls.Prefetcher = prefetch
+	cls.Prefetcher = prefetch
+	confs.Prefetcher = prefetch
+	als.Prefetcher = prefetch
+	ns.Prefetcher = prefetch
+	nis, err := newNumberInstanceServer(settings.Logger, vc, settings.LocationFinder, settings.Labels, settings.Owners)
+	if err != nil {
+		return nil, err
+	}
+	nhs, err := newNumberHistoryServer(settings.Logger, vc, settings.LocationFinder)
+	if err != nil {
+		return nil, err
+	}
+	nts, err := newNumberTimelineServer(settings.Logger, vc, settings.LocationFinder, settings.Labels, settings.Owners, settings.Aliases, settings.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+	var indexer *attachmentIndexer
+	if settings.TextExtractor != nil {
+		indexer = newAttachmentIndexer(settings.Logger, settings.TextExtractor, settings.AttachmentText,
+			settings.MediaCache, settings.MediaScanner, settings.SecretKey)
+	}
+	mis.Indexer = indexer
+	var bodies *bodyIndexer
+	if settings.BodyHashes != nil {
+		bodies = newBodyIndexer(settings.Logger, settings.BodyHashes)
+	}
+	mis.Bodies = bodies
+	mls.Bodies = bodies
+	if settings.Translator != nil {
+		mis.Translator = newTranslator(settings.Translator, settings.TranslationLanguage, settings.Logger)
+	}
+	dups, err := newDuplicateServer(settings.Logger, vc, settings.BodyHashes, settings.LocationFinder)
 	if err != nil {
 		return nil, err
 	}
 	ss := &searchServer{
+		Logger:         settings.Logger,
+		Labels:         settings.Labels,
+		AttachmentText: settings.AttachmentText,
+	}
+	ats, err := newAttachmentSearchServer(settings.Logger, vc, settings.AttachmentText, settings.LocationFinder, settings.Labels, settings.Owners)
+	if err != nil {
+		return nil, err
+	}
+	ess, err := newErrorSearchServer(settings.Logger, vc, settings.LocationFinder, settings.Labels, settings.Owners)
+	if err != nil {
+		return nil, err
+	}
+	ts := &ticketServer{
 		Logger: settings.Logger,
+		Store:  settings.Tickets,
+	}
+	lbs, err := newLabelServer(settings.Logger, settings.Labels, settings.LocationFinder)
+	if err != nil {
+		return nil, err
+	}
+	ows, err := newOwnerServer(settings.Logger, settings.Owners, settings.LocationFinder)
+	if err != nil {
+		return nil, err
+	}
+	if settings.Grants == nil {
+		settings.Grants, err = config.NewGrantStore("", nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if settings.AuditLog == nil {
+		settings.AuditLog, err = services.NewAuditLog(settings.Logger, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if settings.Notes == nil {
+		settings.Notes, err = services.NewNoteStore("")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if prev != nil {
+		settings.Grants.KeepGrants(prev.grants)
+		settings.Notes.KeepNotes(prev.notes)
+		// An audit log that isn't saved anywhere has nothing to copy, but
+		// keep using the same one.
+		if !settings.AuditLog.Saved() && !prev.audit.Saved() {
+			settings.AuditLog = prev.audit
+		}
+	}
+	mis.Notes = settings.Notes
+	cis.Notes = settings.Notes
+	var tss *translateServer
+	if mis.Translator != nil {
+		tss = &translateServer{
+			Logger:     settings.Logger,
+			Client:     vc,
+			Translator: mis.Translator,
+			Audit:      settings.AuditLog,
+		}
+	}
+	ais.Notes = settings.Notes
+	notes := &noteServer{
+		Logger: settings.Logger,
+		Store:  settings.Notes,
+		Audit:  settings.AuditLog,
+	}
+	nss, err := newNoteSearchServer(settings.Logger, settings.Notes, settings.LocationFinder)
+	if err != nil {
+		return nil, err
+	}
+	ss.Notes = settings.Notes
+	mis.Runbooks = settings.Runbooks
+	cis.Runbooks = settings.Runbooks
+	als.Runbooks = settings.Runbooks
+	ais.Runbooks = settings.Runbooks
+	ess.Runbooks = settings.Runbooks
+	gs, err := newGrantServer(settings.Logger, settings.Grants, settings.AuditLog, settings.LocationFinder)
+	if err != nil {
+		return nil, err
+	}
+	var grantsURL string
+	if settings.PublicHost != "" {
+		scheme := "https://"
+		if settings.AllowUnencryptedTraffic {
+			scheme = "http://"
+		}
+		grantsURL = scheme + settings.PublicHost + "/admin/grants"
+	}
+	bls, err := newBlocklistServer(settings.Logger, settings.Blocklist, settings.AuditLog, settings.LocationFinder)
+	if err != nil {
+		return nil, err
+	}
+	bgs, err := newBreakGlassServer(settings.Logger, settings.BreakGlass, settings.Grants, settings.AuditLog, settings.Notifier, settings.LocationFinder, grantsURL)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := newSessionServer(settings.Logger, settings.Sessions, settings.AuditLog, settings.LocationFinder)
+	if err != nil {
+		return nil, err
+	}
+	pms, err := newPermissionsServer(settings.Logger, settings.Policy, settings.PolicyFile, rl, settings.AuditLog, settings.LocationFinder)
+	if err != nil {
+		return nil, err
+	}
+	vas, err := newViewAsServer(settings.Logger, settings.Policy, settings.AuditLog, settings.LocationFinder, settings.AllowUnencryptedTraffic)
+	if err != nil {
+		return nil, err
+	}
+	var rs *resendServer
+	if resender, ok := twilioClient.(views.Resender); ok {
+		rs, err = newResendServer(settings.Logger, vc, resender, settings.AuditLog, settings.LocationFinder, settings.Labels, settings.Owners)
+		if err != nil {
+			return nil, err
+		}
+		mis.AllowResend = !settings.ReadOnly
+	}
+	var scs *scheduledServer
+	if scheduler, ok := twilioClient.(views.Scheduler); ok {
+		scs, err = newScheduledServer(settings.Logger, vc, scheduler, settings.AuditLog, settings.LocationFinder, settings.Labels, settings.Owners)
+		if err != nil {
+			return nil, err
+		}
+		scs.AllowCancel = !settings.ReadOnly
+		mis.AllowCancel = !settings.ReadOnly
+	}
+	var a2ps *a2pServer
+	if finder, ok := twilioClient.(views.A2PFinder); ok {
+		a2ps, err = newA2PServer(settings.Logger, finder, settings.LocationFinder)
+		if err != nil {
+			return nil, err
+		}
+		mis.AllowA2P = true
+	}
+	var convs *conversationListServer
+	var convInstance *conversationInstanceServer
+	if finder, ok := twilioClient.(views.ConversationFinder); ok {
+		convs, err = newConversationListServer(settings.Logger, finder, settings.LocationFinder, settings.PageSize, settings.SecretKey)
+		if err != nil {
+			return nil, err
+		}
+		convInstance, err = newConversationInstanceServer(settings.Logger, finder, settings.LocationFinder)
+		if err != nil {
+			return nil, err
+		}
 	}
 	o, err := newOpenSearchServer(settings.PublicHost, settings.AllowUnencryptedTraffic)
 	if err != nil {
 		return nil, err
 	}
-	index, err := newIndexServer()
+	// Without a Twilio client, like in some tests, there's nothing to count,
+	// so the home page only shows links.
+	var homeClient views.Client
+	if settings.Client != nil {
+		homeClient = vc
+	}
+	index, err := newHomeServer(settings.Logger, homeClient, settings.LocationFinder)
 	if err != nil {
 		return nil, err
 	}
+	index.Runbooks = settings.Runbooks
 	openSource, err := newOpenSourceServer()
 	if err != nil {
 		return nil, err
 	}
-	image := &imageServer{
-		secretKey: settings.SecretKey,
+	image, err := newImageServer(settings.Logger, settings.MediaCache, settings.MediaScanner, settings.SecretKey)
+	if err != nil {
+		return nil, err
 	}
-	proxy, err := newAudioReverseProxy()
+	proxy, err := newAudioReverseProxy(settings.TwilioTransport)
 	if err != nil {
 		return nil, err
 	}
 	audio := &audioServer{
 		Client:    vc,
 		Proxy:     proxy,
+		Blobs:     settings.MediaCache,
+		Transport: settings.TwilioTransport,
 		secretKey: settings.SecretKey,
 	}
-	staticServer := &static{
-		modTime: time.Now().UTC(),
+	rds := &recordingDownloadServer{
+		Logger:         settings.Logger,
+		Client:         vc,
+		Fetch:          audio.load,
+		Audit:          settings.AuditLog,
+		LocationFinder: settings.LocationFinder,
+		MaxBytes:       settings.MaxRecordingDownload,
+		secretKey:      settings.SecretKey,
+	}
+	mcs := &mediaCacheServer{
+		Logger:    settings.Logger,
+		Blobs:     settings.MediaCache,
+		secretKey: settings.SecretKey,
 	}
+	staticServer := &static{}
 	logout := &logoutServer{
 		Authenticator: settings.Authenticator,
 	}
@@ -361,6 +892,172 @@ func NewServer(settings *config.Settings) (*Server, error) {
 		AllowUnencryptedTraffic: settings.AllowUnencryptedTraffic,
 		LocationFinder:          settings.LocationFinder,
 	}
+	var authToken string
+	if settings.Client != nil {
+		authToken = settings.Client.AuthToken
+	}
+	var webhooks *services.WebhookStore
+	if prev != nil {
+		webhooks = prev.webhooks
+	} else {
+		webhooks = services.NewWebhookStore()
+	}
+	cis.Webhooks = webhooks
+	webhookCapture := &webhookCaptureServer{
+		Logger:    settings.Logger,
+		Store:     webhooks,
+		AuthToken: authToken,
+	}
+	var webhookBaseURL string
+	if settings.PublicHost != "" {
+		webhookBaseURL = "https://" + settings.PublicHost
+		if settings.AllowUnencryptedTraffic {
+			webhookBaseURL = "http://" + settings.PublicHost
+		}
+	}
+	wds, err := newWebhookDebugServer(settings.Logger, webhooks, settings.LocationFinder, webhookBaseURL, authToken != "")
+	if err != nil {
+		return nil, err
+	}
+	queueEvents := settings.QueueEvents
+	if queueEvents == nil {
+		queueEvents, _ = services.NewQueueStore("")
+	}
+	queueCallback := &queueCallbackServer{
+		Logger:    settings.Logger,
+		Store:     queueEvents,
+		AuthToken: authToken,
+		BaseURL:   webhookBaseURL,
+	}
+	messageStatuses := settings.MessageStatuses
+	if messageStatuses == nil {
+		messageStatuses, _ = services.NewStatusStore("")
+	}
+	statusCallback := &statusCallbackServer{
+		Logger:    settings.Logger,
+		Store:     messageStatuses,
+		AuthToken: authToken,
+		BaseURL:   webhookBaseURL,
+	}
+	var reconciler *statusReconciler
+	// Archived and demo messages don't change status.
+	if authToken != "" && arch == nil {
+		interval := settings.StatusReconcileInterval
+		if interval == 0 {
+			interval = config.DefaultStatusReconcileInterval
+		}
+		reconciler = newStatusReconciler(settings.Logger, vc, messageStatuses, interval)
+	}
+	qs, err := newQueueServer(settings.Logger, queueEvents, settings.LocationFinder, settings.MaxResourceAge, authToken != "")
+	if err != nil {
+		return nil, err
+	}
+	prefs := &preferencesServer{
+		Logger:                  settings.Logger,
+		AllowUnencryptedTraffic: settings.AllowUnencryptedTraffic,
+	}
+
+	dash, err := newDashboardServer(settings.Logger, vc, settings.LocationFinder, settings.MaxResourceAge)
+	if err != nil {
+		return nil, err
+	}
+	hms, err := newHeatmapServer(settings.Logger, vc, settings.LocationFinder)
+	if err != nil {
+		return nil, err
+	}
+	cmps, err := newCampaignServer(settings.Logger, vc, settings.LocationFinder, settings.MaxResourceAge)
+	if err != nil {
+		return nil, err
+	}
+	cmps.Runbooks = settings.Runbooks
+	ups, err := newUptimeServer(settings.Logger, vc, settings.LocationFinder)
+	if err != nil {
+		return nil, err
+	}
+
+	var stuck *stuckMonitor
+	// Archived and demo messages are never going to be delivered.
+	if settings.StuckMessageThreshold > 0 && arch == nil {
+		notifier := settings.Notifier
+		if notifier == nil {
+			notifier = &services.NoopNotifier{}
+		}
+		scheme := "https://"
+		if settings.AllowUnencryptedTraffic {
+			scheme = "http://"
+		}
+		stuck = newStuckMonitor(settings.Logger, vc, notifier,
+			settings.StuckMessageThreshold, settings.StuckMessageInterval,
+			scheme+settings.PublicHost+"/stuck-messages")
+	}
+	sts, err := newStuckServer(settings.Logger, settings.LocationFinder, settings.MaxResourceAge, stuck)
+	if err != nil {
+		return nil, err
+	}
+	var inventory *numberInventoryMonitor
+	// Nobody buys or releases archived or demo numbers.
+	if settings.NumberInventory != nil && arch == nil {
+		notifier := settings.Notifier
+		if notifier == nil {
+			notifier = &services.NoopNotifier{}
+		}
+		scheme := "https://"
+		if settings.AllowUnencryptedTraffic {
+			scheme = "http://"
+		}
+		inventory = newNumberInventoryMonitor(settings.Logger, vc, settings.NumberInventory,
+			notifier, settings.NumberInventoryInterval,
+			scheme+settings.PublicHost+"/phone-numbers/changes")
+		inventory.Blocklist = settings.Blocklist
+	}
+	ncs, err := newNumberChangesServer(settings.Logger, settings.LocationFinder, settings.Blocklist, inventory)
+	if err != nil {
+		return nil, err
+	}
+
+	var queue *jobs.Queue
+	if rl != nil {
+		queue = rl.queue
+	} else {
+		queue = jobs.NewQueue(settings.Logger, exportWorkers, exportInterval, exportTTL)
+		queue.Dir = settings.ExportsDir
+	}
+	jls, err := newJobListServer(settings.Logger, vc, settings.LocationFinder, queue, settings.MaxResourceAge)
+	if err != nil {
+		return nil, err
+	}
+	jds := &jobDownloadServer{
+		Logger: settings.Logger,
+		Jobs:   queue,
+	}
+	trs, err := newTrafficServer(settings.Logger, vc, settings.LocationFinder, queue, settings.MaxResourceAge)
+	if err != nil {
+		return nil, err
+	}
+
+	retention := services.NewRetentionManager(settings.Logger, settings.RetentionDryRun)
+	retention.Add(services.RetentionAuditLog, settings.Retention[services.RetentionAuditLog], settings.AuditLog)
+	retention.Add(services.RetentionQueueEvents, settings.Retention[services.RetentionQueueEvents], queueEvents)
+	retention.Add(services.RetentionStatuses, settings.Retention[services.RetentionStatuses], messageStatuses)
+	retention.Add(services.RetentionExports, settings.Retention[services.RetentionExports], queue)
+	retention.Add(services.RetentionNotes, settings.Retention[services.RetentionNotes], settings.Notes)
+	if settings.Tickets != nil {
+		retention.Add(services.RetentionTickets, settings.Retention[services.RetentionTickets], settings.Tickets)
+	}
+	if settings.AttachmentText != nil {
+		retention.Add(services.RetentionAttachmentText, settings.Retention[services.RetentionAttachmentText], settings.AttachmentText)
+	}
+	if settings.MediaCache != nil {
+		retention.Add(services.RetentionMediaCache, settings.Retention[services.RetentionMediaCache], settings.MediaCache)
+	}
+	var purger *retentionPurger
+	if retention.Len() > 0 {
+		interval := settings.RetentionInterval
+		if interval <= 0 {
+			interval = config.DefaultRetentionInterval
+		}
+		purger = newRetentionPurger(settings.Logger, retention, interval)
+	}
 
 	e, err := newErrorServer(settings.Mailto, settings.Reporter)
 	if err != nil {
@@ -368,36 +1065,196 @@ func NewServer(settings *config.Settings) (*Server, error) {
 	}
 	registerErrorHandlers(e)
 
+	site := newSitemap(siteOptions{
+		Conversations: convs != nil,
+		A2P:           a2ps != nil,
+		Scheduled:     scs != nil,
+		Duplicates:    bodies != nil,
+		Profiling:     settings.EnableProfiling,
+		BreakGlass:    settings.BreakGlass,
+		Blocklist:     settings.Blocklist,
+		CacheHistory:  chs != nil,
+	})
+
 	authR := new(handlers.Regexp)
-	authR.Handle(regexp.MustCompile(`^/$`), []string{"GET"}, index)
-	authR.Handle(imageRoute, []string{"GET"}, image)
-	authR.Handle(audioRoute, []string{"GET"}, audio)
-	authR.Handle(regexp.MustCompile(`^/search$`), []string{"GET"}, ss)
-	authR.Handle(regexp.MustCompile(`^/calls$`), []string{"GET"}, cls)
-	authR.Handle(regexp.MustCompile(`^/conferences$`), []string{"GET"}, confs)
-	authR.Handle(regexp.MustCompile(`^/phone-numbers$`), []string{"GET"}, ns)
-	authR.Handle(regexp.MustCompile(`^/messages$`), []string{"GET"}, mls)
-	authR.Handle(regexp.MustCompile(`^/alerts$`), []string{"GET"}, als)
-	authR.Handle(regexp.MustCompile(`^/tz$`), []string{"POST"}, tz)
-	authR.Handle(alertInstanceRoute, []string{"GET"}, ais)
-	authR.Handle(numberInstanceRoute, []string{"GET"}, nis)
-	authR.Handle(conferenceInstanceRoute, []string{"GET"}, confInstance)
-	authR.Handle(callInstanceRoute, []string{"GET"}, cis)
-	authR.Handle(messageInstanceRoute, []string{"GET"}, mis)
-	authH := AddAuthenticator(authR, ls, settings.Authenticator)
+	handle(authR, regexp.MustCompile(`^/$`), []string{"GET"}, index)
+	handle(authR, imageRoute, []string{"GET"}, image)
+	handle(authR, audioRoute, []string{"GET"}, audio)
+	handle(authR, recordingDownloadRoute, []string{"GET"}, rds)
+	handle(authR, regexp.MustCompile(`^/media-cache/purge$`), []string{"POST"}, mcs)
+	handle(authR, regexp.MustCompile(`^/nav$`), []string{"GET"}, &navServer{
+		Sitemap: site,
+		BaseURL: webhookBaseURL,
+	})
+	handle(authR, regexp.MustCompile(`^/openapi\.json$`), []string{"GET"}, &openAPIServer{
+		Operations: newAPIOperations(),
+		BaseURL:    webhookBaseURL,
+	})
+	handle(authR, regexp.MustCompile(`^/search$`), []string{"GET"}, ss)
+	handle(authR, regexp.MustCompile(`^/search/errors$`), []string{"GET"}, ess)
+	handle(authR, regexp.MustCompile(`^/search/notes$`), []string{"GET"}, nss)
+	handle(authR, regexp.MustCompile(`^/notes$`), []string{"POST"}, notes)
+	if indexer != nil {
+		handle(authR, regexp.MustCompile(`^/search/attachments$`), []string{"GET"}, ats)
+	}
+	handle(authR, regexp.MustCompile(`^/calls$`), []string{"GET"}, cls)
+	handle(authR, regexp.MustCompile(`^/conferences$`), []string{"GET"}, confs)
+	handle(authR, regexp.MustCompile(`^/phone-numbers$`), []string{"GET"}, ns)
+	handle(authR, regexp.MustCompile(`^/messages$`), []string{"GET"}, mls)
+	handle(authR, regexp.MustCompile(`^/stuck-messages$`), []string{"GET"}, sts)
+	handle(authR, regexp.MustCompile(`^/labels$`), []string{"GET", "POST"}, lbs)
+	handle(authR, regexp.MustCompile(`^/labels/import$`), []string{"POST"}, lbs)
+	handle(authR, regexp.MustCompile(`^/labels/export$`), []string{"GET"}, lbs)
+	handle(authR, regexp.MustCompile(`^/owners$`), []string{"GET", "POST"}, ows)
+	handle(authR, regexp.MustCompile(`^/owners/import$`), []string{"POST"}, ows)
+	handle(authR, regexp.MustCompile(`^/owners/export$`), []string{"GET"}, ows)
+	if tickets != nil {
+		handle(authR, regexp.MustCompile(`^/tickets$`), []string{"POST"}, ts)
+	}
+	if rl != nil {
+		handle(authR, regexp.MustCompile(`^/admin/reload$`), []string{"POST"}, &reloadServer{
+			Logger:   settings.Logger,
+			Reloader: rl,
+		})
+	}
+	handle(authR, regexp.MustCompile(`^/admin/log-levels$`), []string{"GET", "POST"}, &logLevelServer{
+		Logger: settings.Logger,
+		Levels: settings.LogLevels,
+		Audit:  settings.AuditLog,
+	})
+	if chs != nil {
+		handle(authR, regexp.MustCompile(`^/admin/cache$`), []string{"GET"}, chs)
+	}
+	handle(authR, regexp.MustCompile(`^/admin/grants$`), []string{"GET", "POST"}, gs)
+	handle(authR, regexp.MustCompile(`^/admin/grants/revoke$`), []string{"POST"}, gs)
+	handle(authR, regexp.MustCompile(`^/admin/blocklist$`), []string{"GET", "POST"}, bls)
+	handle(authR, regexp.MustCompile(`^/admin/blocklist/remove$`), []string{"POST"}, bls)
+	handle(authR, regexp.MustCompile(`^/break-glass$`), []string{"GET", "POST"}, bgs)
+	handle(authR, regexp.MustCompile(`^/break-glass/end$`), []string{"POST"}, bgs)
+	handle(authR, regexp.MustCompile(`^/admin/sessions$`), []string{"GET"}, sess)
+	handle(authR, regexp.MustCompile(`^/admin/sessions/revoke$`), []string{"POST"}, sess)
+	handle(authR, regexp.MustCompile(`^/admin/permissions(/export)?$`), []string{"GET"}, pms)
+	handle(authR, regexp.MustCompile(`^/admin/permissions/(import|apply)$`), []string{"POST"}, pms)
+	handle(authR, regexp.MustCompile(`^/admin/view-as$`), []string{"GET", "POST"}, vas)
+	handle(authR, regexp.MustCompile(`^/admin/view-as/stop$`), []string{"POST"}, vas)
+	handle(authR, regexp.MustCompile(`^/alerts$`), []string{"GET"}, als)
+	handle(authR, regexp.MustCompile(`^/alerts/uptime$`), []string{"GET"}, ups)
+	handle(authR, regexp.MustCompile(`^/alerts/trend$`), []string{"GET"}, alts)
+	handle(authR, regexp.MustCompile(`^/tz$`), []string{"POST"}, tz)
+	handle(authR, regexp.MustCompile(`^/preferences$`), []string{"POST"}, prefs)
+	handle(authR, regexp.MustCompile(`^/dashboard$`), []string{"GET"}, dash)
+	handle(authR, regexp.MustCompile(`^/heatmap$`), []string{"GET"}, hms)
+	handle(authR, regexp.MustCompile(`^/messages/campaigns$`), []string{"GET"}, requireFeature(config.FeatureCampaigns, cmps))
+	handle(authR, regexp.MustCompile(`^/traffic$`), []string{"GET", "POST"}, requireFeature(config.FeatureTraffic, trs))
+	if bodies != nil {
+		handle(authR, regexp.MustCompile(`^/messages/duplicates$`), []string{"GET"}, dups)
+	}
+	handle(authR, regexp.MustCompile(`^/queues$`), []string{"GET"}, requireFeature(config.FeatureQueues, qs))
+	if a2ps != nil {
+		handle(authR, regexp.MustCompile(`^/a2p$`), []string{"GET"}, requireFeature(config.FeatureA2P, a2ps))
+	}
+	if convs != nil {
+		handle(authR, regexp.MustCompile(`^/conversations$`), []string{"GET"}, requireFeature(config.FeatureConversations, convs))
+		handle(authR, conversationInstanceRoute, []string{"GET"}, requireFeature(config.FeatureConversations, convInstance))
+	}
+	handle(authR, regexp.MustCompile(`^/debug/webhook$`), []string{"GET", "POST"}, wds)
+	handle(authR, regexp.MustCompile(`^/debug/prefetch$`), []string{"GET"}, &prefetchServer{Prefetcher: prefetch})
+	cspReports := &cspReportServer{Logger: settings.Logger}
+	if prev != nil {
+		cspReports.keepReports(prev.cspReports)
+	}
+	handle(authR, regexp.MustCompile(`^/debug/csp$`), []string{"GET"}, cspReports)
+	handle(authR, regexp.MustCompile(`^/debug/retention$`), []string{"GET"}, &retentionServer{Manager: retention})
+	if reconciler != nil {
+		handle(authR, regexp.MustCompile(`^/debug/reconcile$`), []string{"GET"}, &reconcileServer{Reconciler: reconciler})
+	}
+	if settings.EnableProfiling {
+		rds := &runtimeDebugServer{
+			MediaCache:    settings.MediaCache,
+			Prefetcher:    prefetch,
+			TwilioLimiter: settings.TwilioLimiter,
+			Replicator:    replicator,
+			Fingerprint:   settings.ConfigFingerprint,
+		}
+		if cs, ok := twilioClient.(views.CacheStatter); ok {
+			rds.Cache = cs
+		}
+		handle(authR, regexp.MustCompile(`^/debug/(vars|pprof/.*)$`), []string{"GET"}, rds)
+	}
+	handle(authR, webhookInstanceRoute, []string{"GET"}, wds)
+	handle(authR, regexp.MustCompile(`^/jobs$`), []string{"GET", "POST"}, jls)
+	handle(authR, jobDownloadRoute, []string{"GET"}, jds)
+	handle(authR, alertInstanceRoute, []string{"GET"}, ais)
+	handle(authR, regexp.MustCompile(`^/phone-numbers/changes$`), []string{"GET"}, ncs)
+	handle(authR, numberHistoryRoute, []string{"GET"}, nhs)
+	handle(authR, numberTimelineRoute, []string{"GET"}, nts)
+	handle(authR, numberInstanceRoute, []string{"GET"}, nis)
+	handle(authR, conferenceInstanceRoute, []string{"GET"}, confInstance)
+	handle(authR, messagesNewerRoute, []string{"GET"}, requireFeature(config.FeatureAutoRefresh, newNewerServer(settings.Logger, vc, "messages", settings.Owners)))
+	handle(authR, callsNewerRoute, []string{"GET"}, requireFeature(config.FeatureAutoRefresh, newNewerServer(settings.Logger, vc, "calls", settings.Owners)))
+	handle(authR, callInstanceRoute, []string{"GET"}, cis)
+	if rs != nil {
+		handle(authR, messageResendRoute, []string{"GET", "POST"}, requireFeature(config.FeatureResendMessages, rs))
+	}
+	if scs != nil {
+		handle(authR, scheduledRoute, []string{"GET"}, requireFeature(config.FeatureScheduledMessages, scs))
+		handle(authR, messageCancelRoute, []string{"POST"}, requireFeature(config.FeatureScheduledMessages, scs))
+	}
+	if tss != nil {
+		handle(authR, messageTranslateRoute, []string{"POST"}, tss)
+	}
+	handle(authR, messageInstanceRoute, []string{"GET"}, mis)
+	// Inside readOnly, so requests it blocks get its error instead.
+	var routes http.Handler = withCSRF(authR, settings.Logger, settings.AllowUnencryptedTraffic, settings.CSRFSameSite)
+	if settings.ReadOnly {
+		routes = readOnly(routes, settings.Logger, readOnlyRoutes)
+	}
+	routes = withPermissionDebugging(routes)
+	routes = withBreakGlass(routes, settings.BreakGlass, settings.AuditLog)
+	routes = withViewAs(routes, settings.Logger, settings.Policy, settings.Grants, settings.Features)
+	routes = withGrants(routes, settings.Grants)
+	routes = withFeatures(routes, settings.Features)
+	authH := AddAuthenticator(routes, ls, settings.Authenticator)
 	authH = handlers.WithLogger(authH, settings.Logger)
 	if len(settings.IPSubnets) > 0 {
 		authH = whitelistIPs(authH, settings.Logger, settings.IPSubnets)
 	}
 
 	r := new(handlers.Regexp)
-	r.Handle(regexp.MustCompile(`(^/static|^/favicon.ico$)`), []string{"GET"}, handlers.GZip(staticServer))
-	r.Handle(regexp.MustCompile(`^/open-source$`), []string{"GET"}, openSource)
-	r.Handle(regexp.MustCompile(`^/opensearch.xml$`), []string{"GET"}, o)
-	r.Handle(regexp.MustCompile(`^/auth/logout$`), []string{"POST"}, logout)
+	handle(r, regexp.MustCompile(`(^/static|^/favicon.ico$)`), []string{"GET"}, handlers.GZip(staticServer))
+	handle(r, regexp.MustCompile(`^/open-source$`), []string{"GET"}, openSource)
+	handle(r, regexp.MustCompile(`^/opensearch.xml$`), []string{"GET"}, o)
+	handle(r, regexp.MustCompile(`^/auth/logout$`), []string{"POST"}, withCSRF(logout, settings.Logger, settings.AllowUnencryptedTraffic, settings.CSRFSameSite))
+	handle(r, regexp.MustCompile(`^`+config.CSPReportPath+`$`), []string{"POST"}, cspReports)
+	// Twilio has to be able to reach capture URLs, so they skip
+	// authentication and the IP whitelist.
+	handle(r, webhookCaptureRoute, []string{"GET", "POST"}, webhookCapture)
+	if authToken != "" {
+		handle(r, queueCallbackRoute, []string{"POST"}, queueCallback)
+		handle(r, statusCallbackRoute, []string{"POST"}, statusCallback)
+	}
+	// Peers authenticate by encrypting batches with the replication key,
+	// since there's no user to log in as, and have to be in the replication
+	// subnets.
+	if replicator != nil {
+		handle(r, regexp.MustCompile(`^`+cacheReplicationPath+`$`), []string{"POST"},
+			whitelistIPs(replicator, settings.Logger, settings.ReplicationSubnets))
+	}
 	// todo awkward using HTTP methods here
-	r.Handle(regexp.MustCompile(`^/`), []string{"GET", "POST", "PUT", "DELETE"}, authH)
-	h := UpgradeInsecureHandler(r, settings.AllowUnencryptedTraffic)
+	r.Handle(regexp.MustCompile(`^/`), []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"}, authH)
+	branding := settings.Branding
+	if branding == nil {
+		branding = config.DefaultBranding
+	}
+	h := withBranding(r, branding)
+	h = withSitemap(h, site)
+	if arch != nil {
+		h = withArchive(h, arch)
+	}
+	h = withCallBudget(h, settings.Logger, settings.MaxTwilioCalls)
+	h = withCORS(h, settings.CORS)
+	h = withSecurityHeaders(h, settings.SecurityHeaders)
+	h = UpgradeInsecureHandler(h, settings.AllowUnencryptedTraffic)
 
 	// Innermost handlers are first.
 	h = handlers.Server(h, "logrole/"+Version)
@@ -408,9 +1265,28 @@ func NewServer(settings *config.Settings) (*Server, error) {
 	h = settings.Reporter.ReportPanics(h)
 	h = handlers.Duration(h)
 	return &Server{
-		Handler:  h,
-		PageSize: settings.PageSize,
-		vc:       vc,
-		DoneChan: make(chan bool, 1),
+		Handler:    h,
+		PageSize:   settings.PageSize,
+		vc:         vc,
+		DoneChan:   make(chan bool, 1),
+		stuck:      stuck,
+		inventory:  inventory,
+		snapshots:  snapshots,
+		replicator: replicator,
+		prefetch:   prefetch,
+		purger:     purger,
+		reconciler: reconciler,
+		indexer:    indexer,
+		bodies:     bodies,
+		mediaCache: settings.MediaCache,
+		exports:    queue,
+		policy:     settings.Policy,
+		webhooks:   webhooks,
+		cspReports: cspReports,
+		grants:     settings.Grants,
+		notes:      settings.Notes,
+		audit:      settings.AuditLog,
+		apiCache:   apiCache,
+		accountSid: accountSid,
 	}, nil
 }
@@ -129,3 +129,133 @@ func TestStaticPagesAvailableNoAuth(t *testing.T) {
 		t.Errorf("expected Code to be 200, got %d", w.Code)
 	}
 }
+
+func TestStaticCaching(t *testing.T) {
+	t.Parallel()
+	s := &static{}
+	hashed := staticURL("/static/css/all.css")
+	if hashed == "/static/css/all.css" {
+		t.Fatalf("expected a hashed URL, got %s", hashed)
+	}
+	tests := []struct {
+		path         string
+		cacheControl string
+	}{
+		{hashed, immutableCacheControl},
+		{"/static/css/all.css", "no-cache"},
+		// A hash from an older build still gets the current file.
+		{"/static/css/all.0123456789.css", "no-cache"},
+		{"/favicon.ico", "no-cache"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Errorf("%s: expected Code to be 200, got %d", tt.path, w.Code)
+			continue
+		}
+		if cc := w.Header().Get("Cache-Control"); cc != tt.cacheControl {
+			t.Errorf("%s: expected Cache-Control %q, got %q", tt.path, tt.cacheControl, cc)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/static/css/all.css", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	etag := w.Header().Get("ETag")
+	req, _ = http.NewRequest("GET", "/static/css/all.css", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 304 {
+		t.Errorf("expected a matching ETag to get a 304, got %d", w.Code)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	t.Parallel()
+	settings := &config.Settings{
+		AllowUnencryptedTraffic: true,
+		Authenticator:           &config.NoopAuthenticator{},
+		SecretKey:               services.NewRandomKey(),
+		Logger:                  NullLogger,
+		LocationFinder:          lf,
+		ReadOnly:                true,
+	}
+	s, err := NewServer(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("DELETE", "http://localhost:12345/messages", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "read-only") {
+		t.Errorf("expected body to mention read-only mode, got %s", w.Body.String())
+	}
+	req, _ = http.NewRequest("GET", "http://localhost:12345/", nil)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Errorf("expected Code to be 200, got %d", w.Code)
+	}
+	token := strings.Repeat("ab", 32)
+	req, _ = http.NewRequest("POST", "http://localhost:12345/tz", strings.NewReader("tz=UTC&csrf_token="+token))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "csrf", Value: token})
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code == 403 {
+		t.Errorf("expected timezone changes to be allowed in read-only mode, got 403")
+	}
+	for _, path := range []string{"/admin/reload", "/admin/log-levels", "/admin/grants", "/admin/sessions/revoke", "/admin/view-as", "/admin/permissions/apply", "/admin/blocklist",
+		"/media-cache/purge", "/labels", "/labels/import", "/owners", "/owners/import", "/break-glass", "/break-glass/end"} {
+		req, _ = http.NewRequest("POST", "http://localhost:12345"+path, strings.NewReader("csrf_token="+token))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "csrf", Value: token})
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != 403 || !strings.Contains(w.Body.String(), "read-only") {
+			t.Errorf("POST %s: expected admin changes to be rejected in read-only mode, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestBranding(t *testing.T) {
+	t.Parallel()
+	branding, err := config.NewBranding("Support Logs", "/static/logo.png", "#1d5fa8", []config.FooterLink{
+		{Text: "Runbook", URL: "https://wiki.example.com/twilio"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := &config.Settings{
+		AllowUnencryptedTraffic: true,
+		Authenticator:           &config.NoopAuthenticator{},
+		SecretKey:               services.NewRandomKey(),
+		Logger:                  NullLogger,
+		Branding:                branding,
+	}
+	s, err := NewServer(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "http://localhost:12345/", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{"<title>Homepage - Support Logs</title>",
+		`<img class="brand-logo" src="/static/logo.png"`,
+		"background-color: #1d5fa8",
+		`<a href="https://wiki.example.com/twilio">Runbook</a>`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected body to contain %q, got %s", want, body)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+// sessionServer lists the logins stored on the server and revokes them. It
+// requires the can_manage_sessions permission.
+type sessionServer struct {
+	log.Logger
+	// If nil, sessions aren't stored on the server, and the page says how
+	// to turn them on.
+	Sessions       config.SessionStore
+	Audit          *services.AuditLog
+	LocationFinder services.LocationFinder
+	tpl            *template.Template
+}
+
+func newSessionServer(l log.Logger, sessions config.SessionStore, audit *services.AuditLog, lf services.LocationFinder) (*sessionServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+sessionListTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionServer{
+		Logger:         l,
+		Sessions:       sessions,
+		Audit:          audit,
+		LocationFinder: lf,
+		tpl:            tpl,
+	}, nil
+}
+
+// userSessions are the active sessions for one user.
+type userSessions struct {
+	User     string
+	Sessions []*config.Session
+}
+
+type sessionListData struct {
+	Enabled bool
+	// Sorted by the user's most recent session first.
+	Users []*userSessions
+	Loc   *time.Location
+	Err   string
+}
+
+func (d *sessionListData) Title() string {
+	return "Sessions"
+}
+
+// groupSessions groups sessions, which are sorted most recently used first,
+// by user.
+func groupSessions(sessions []*config.Session) []*userSessions {
+	users := make([]*userSessions, 0)
+	byUser := make(map[string]*userSessions)
+	for _, s := range sessions {
+		us, ok := byUser[s.User]
+		if !ok {
+			us = &userSessions{User: s.User}
+			byUser[s.User] = us
+			users = append(users, us)
+		}
+		us.Sessions = append(us.Sessions, s)
+	}
+	return users
+}
+
+func (s *sessionServer) renderList(w http.ResponseWriter, r *http.Request, code int, data *sessionListData) {
+	data.Loc = s.LocationFinder.GetLocationReq(r)
+	if s.Sessions != nil {
+		data.Enabled = true
+		data.Users = groupSessions(s.Sessions.Active(time.Now()))
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (s *sessionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanManageSessions() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to manage sessions"})
+		return
+	}
+	if r.Method == "GET" {
+		s.renderList(w, r, http.StatusOK, &sessionListData{})
+		return
+	}
+	s.revoke(w, r, u)
+}
+
+// POST /admin/sessions/revoke
+//
+// End the session with the given id, or every session for user, right away.
+func (s *sessionServer) revoke(w http.ResponseWriter, r *http.Request, u *config.User) {
+	if s.Sessions == nil {
+		s.renderList(w, r, http.StatusBadRequest, &sessionListData{Err: "Sessions aren't stored on the server, set a sessions_file"})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.renderList(w, r, http.StatusBadRequest, &sessionListData{Err: err.Error()})
+		return
+	}
+	id := r.PostForm.Get("id")
+	user := r.PostForm.Get("user")
+	event := &services.AuditEvent{User: u.ID()}
+	switch {
+	case id != "":
+		session, err := s.Sessions.Revoke(id)
+		if err != nil {
+			s.renderList(w, r, http.StatusBadRequest, &sessionListData{Err: err.Error()})
+			return
+		}
+		event.Action = "revoke_session"
+		event.Resource = session.User
+		event.Details = map[string]string{"id": session.ID}
+	case user != "":
+		count, err := s.Sessions.RevokeUser(user)
+		if err != nil {
+			s.renderList(w, r, http.StatusBadRequest, &sessionListData{Err: err.Error()})
+			return
+		}
+		event.Action = "revoke_user_sessions"
+		event.Resource = user
+		event.Details = map[string]string{"count": strconv.Itoa(count)}
+	default:
+		s.renderList(w, r, http.StatusBadRequest, &sessionListData{Err: "Choose a session or a user to log out"})
+		return
+	}
+	s.Audit.Record(event)
+	http.Redirect(w, r, "/admin/sessions", http.StatusFound)
+}
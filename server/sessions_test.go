@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+func TestRevokeSessions(t *testing.T) {
+	t.Parallel()
+	sessions, _ := config.NewFileSessionStore("")
+	audit, _ := services.NewAuditLog(NullLogger, "")
+	lf, _ := services.NewLocationFinder("America/Los_Angeles")
+	s, err := newSessionServer(NullLogger, sessions, audit, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour)
+	first, _ := sessions.Create("support@example.com", expires, "Firefox")
+	sessions.Create("support@example.com", expires, "Chrome")
+	sessions.Create("admin@example.com", expires, "Safari")
+
+	admin, _, _ := grantPolicy.Lookup("admin@example.com")
+	support, _, _ := grantPolicy.Lookup("support@example.com")
+	if w := postGrant(s, support, "/admin/sessions/revoke", url.Values{"id": {first.ID}}); w.Code != 403 {
+		t.Errorf("expected users without can_manage_sessions to get a 403, got %d", w.Code)
+	}
+	req, _ := http.NewRequest("GET", "/admin/sessions", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, config.SetUser(req, admin))
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "support@example.com") || !strings.Contains(body, "Chrome") {
+		t.Errorf("expected sessions to be listed, got %s", body)
+	}
+
+	if w := postGrant(s, admin, "/admin/sessions/revoke", url.Values{"id": {first.ID}}); w.Code != 302 {
+		t.Fatalf("expected Code to be 302, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := sessions.Get(first.ID, time.Now()); ok {
+		t.Errorf("expected session to be revoked")
+	}
+	if w := postGrant(s, admin, "/admin/sessions/revoke", url.Values{"user": {"support@example.com"}}); w.Code != 302 {
+		t.Fatalf("expected Code to be 302, got %d: %s", w.Code, w.Body.String())
+	}
+	active := sessions.Active(time.Now())
+	if len(active) != 1 || active[0].User != "admin@example.com" {
+		t.Errorf("expected only the admin's session to be left, got %v", active)
+	}
+}
+
+func TestSessionsNotStored(t *testing.T) {
+	t.Parallel()
+	lf, _ := services.NewLocationFinder("America/Los_Angeles")
+	s, err := newSessionServer(NullLogger, nil, nil, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, _, _ := grantPolicy.Lookup("admin@example.com")
+	req, _ := http.NewRequest("GET", "/admin/sessions", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, config.SetUser(req, admin))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "sessions_file") {
+		t.Errorf("expected a page explaining how to store sessions, got %d", w.Code)
+	}
+	if w := postGrant(s, admin, "/admin/sessions/revoke", url.Values{"user": {"support@example.com"}}); w.Code != 400 {
+		t.Errorf("expected Code to be 400 without a session store, got %d", w.Code)
+	}
+}
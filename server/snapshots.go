@@ -0,0 +1,100 @@
+package server
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/saintpete/logrole/store"
+	"github.com/saintpete/logrole/views"
+)
+
+// cacheSnapshotter saves the API cache to a file every Interval, and when it's
+// stopped, so the next server to start can load it and start warm.
+type cacheSnapshotter struct {
+	log.Logger
+	Cache    views.Snapshotter
+	Path     string
+	Interval time.Duration
+	// Snapshots keep the newest entries that fit in this many bytes.
+	MaxBytes int64
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newCacheSnapshotter(l log.Logger, c views.Snapshotter, path string, interval time.Duration, maxBytes int64) *cacheSnapshotter {
+	return &cacheSnapshotter{
+		Logger:   l,
+		Cache:    c,
+		Path:     path,
+		Interval: interval,
+		MaxBytes: maxBytes,
+		done:     make(chan struct{}),
+	}
+}
+
+// Restore loads the last snapshot into the cache. It's not an error if there
+// isn't one yet. A snapshot that fails its integrity check is logged and
+// ignored; the next Save replaces it.
+func (s *cacheSnapshotter) Restore() {
+	f, err := os.Open(s.Path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		s.Warn("Couldn't open cache snapshot", "path", s.Path, "err", err)
+		return
+	}
+	defer f.Close()
+	start := time.Now()
+	count, err := s.Cache.RestoreCache(f)
+	if err != nil {
+		s.Warn("Couldn't restore cache snapshot", "path", s.Path, "err", err)
+		return
+	}
+	s.Info("Restored cache snapshot", "path", s.Path, "entries", count, "duration", time.Since(start))
+}
+
+// Save writes a snapshot to a temporary file and renames it over the last
+// one, so a crash while saving doesn't leave a truncated snapshot behind.
+func (s *cacheSnapshotter) Save() error {
+	var count int
+	err := store.WriteFileFunc(s.Path, func(w io.Writer) error {
+		var err error
+		count, err = s.Cache.SnapshotCache(w, s.MaxBytes)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.Debug("Saved cache snapshot", "path", s.Path, "entries", count)
+	return nil
+}
+
+func (s *cacheSnapshotter) Run() {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			if err := s.Save(); err != nil {
+				s.Warn("Couldn't save cache snapshot", "path", s.Path, "err", err)
+			}
+			return
+		case <-ticker.C:
+			if err := s.Save(); err != nil {
+				s.Warn("Couldn't save cache snapshot", "path", s.Path, "err", err)
+			}
+		}
+	}
+}
+
+// Stop saves a final snapshot and stops saving snapshots.
+func (s *cacheSnapshotter) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.done)
+	})
+}
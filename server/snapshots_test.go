@@ -0,0 +1,59 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/cache"
+)
+
+type testSnapshotter struct {
+	*cache.Cache
+}
+
+func (t *testSnapshotter) SnapshotCache(w io.Writer, maxBytes int64) (int, error) {
+	return t.Snapshot(w, maxBytes)
+}
+
+func (t *testSnapshotter) RestoreCache(r io.Reader) (int, error) {
+	return t.Restore(r)
+}
+
+func TestCacheSnapshotter(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-snapshots-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.snapshot")
+
+	c := &testSnapshotter{cache.NewCache(10, NullLogger)}
+	c.Set("/Messages.json", "page", time.Hour)
+	s := newCacheSnapshotter(NullLogger, c, path, time.Hour, 0)
+	// No snapshot yet; this shouldn't do anything.
+	s.Restore()
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c2 := &testSnapshotter{cache.NewCache(10, NullLogger)}
+	newCacheSnapshotter(NullLogger, c2, path, time.Hour, 0).Restore()
+	var val string
+	if _, err := c2.Get("/Messages.json", &val); err != nil || val != "page" {
+		t.Errorf("expected restored value, got %q, %v", val, err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c3 := &testSnapshotter{cache.NewCache(10, NullLogger)}
+	newCacheSnapshotter(NullLogger, c3, path, time.Hour, 0).Restore()
+	if _, err := c3.Get("/Messages.json", &val); err == nil {
+		t.Error("expected a corrupt snapshot to be ignored")
+	}
+}
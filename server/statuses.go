@@ -0,0 +1,237 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	"golang.org/x/net/context"
+)
+
+var statusCallbackRoute = regexp.MustCompile(`^/webhooks/messages$`)
+
+// Give Twilio this long to send a callback before re-fetching the message.
+const reconcileAfter = 10 * time.Minute
+
+// Messages that still haven't reached a final status after this long, like
+// ones that stay "sent" because the carrier never reports delivery, are left
+// alone.
+const maxReconcileAge = 72 * time.Hour
+
+// Don't re-fetch more than this many messages per run; the rest wait for the
+// next one.
+const maxReconcilePerRun = 200
+const reconcileTimeout = 2 * time.Minute
+
+// statusCallbackServer records the statuses Twilio posts to a message's
+// StatusCallback URL. Like the queue callback, it's not behind
+// authentication, so it only accepts requests signed with the account's auth
+// token.
+type statusCallbackServer struct {
+	log.Logger
+	Store     *services.StatusStore
+	AuthToken string
+	// Scheme and host Twilio sends callbacks to. If empty, the host of the
+	// request is used.
+	BaseURL string
+}
+
+// POST /webhooks/messages
+//
+// Record the status of a message.
+func (s *statusCallbackServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	signedURL := requestBaseURL(r, s.BaseURL) + r.URL.RequestURI()
+	sig := services.CheckTwilioSignature(s.AuthToken, signedURL, r.PostForm, r.Header.Get("X-Twilio-Signature"))
+	if sig != services.SignatureValid {
+		s.Warn("Rejected status callback", "signature", sig)
+		rest.Forbidden(w, r, &rest.Error{Title: "Invalid X-Twilio-Signature"})
+		return
+	}
+	st, err := services.NewMessageStatus(r.PostForm, time.Now())
+	if err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	if _, err := s.Store.Update(st); err != nil {
+		s.Error("Couldn't save message status", "sid", st.Sid, "err", err)
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	io.WriteString(w, emptyTwiML)
+}
+
+// reconcileStats count what the reconciler has done since the server
+// started.
+type reconcileStats struct {
+	Runs int64 `json:"runs"`
+	// Messages re-fetched from Twilio.
+	Checked int64 `json:"checked"`
+	// Messages whose status had changed without a callback telling us.
+	Fixed int64 `json:"fixed"`
+	// Messages that couldn't be fetched.
+	Failed int64 `json:"failed"`
+	// Messages waiting for a final status after the last run, including ones
+	// that aren't due to be checked yet.
+	Pending      int           `json:"pending"`
+	LastRun      time.Time     `json:"last_run"`
+	LastDuration time.Duration `json:"last_duration"`
+	LastChecked  int           `json:"last_checked"`
+	LastFixed    int           `json:"last_fixed"`
+	LastErr      string        `json:"last_err,omitempty"`
+}
+
+// statusReconciler re-fetches messages that were last seen in a non-terminal
+// status, like "queued" or "sent", and records their current status, so the
+// StatusStore doesn't drift when Twilio can't reach the callback URL.
+type statusReconciler struct {
+	log.Logger
+	Client   views.Client
+	Store    *services.StatusStore
+	Interval time.Duration
+
+	mu    sync.Mutex
+	stats reconcileStats
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newStatusReconciler(l log.Logger, vc views.Client, store *services.StatusStore, interval time.Duration) *statusReconciler {
+	return &statusReconciler{
+		Logger:   l,
+		Client:   vc,
+		Store:    store,
+		Interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+// reconcile re-fetches the messages that have been waiting for a callback for
+// longer than reconcileAfter.
+func (rc *statusReconciler) reconcile(ctx context.Context, now time.Time) error {
+	start := time.Now()
+	pending := rc.Store.Pending(now.Add(-maxReconcileAge), now.Add(-reconcileAfter))
+	if len(pending) > maxReconcilePerRun {
+		pending = pending[:maxReconcilePerRun]
+	}
+	checked, fixed, failed := 0, 0, 0
+	var lastErr error
+	for _, old := range pending {
+		if ctx.Err() != nil {
+			lastErr = ctx.Err()
+			break
+		}
+		message, err := rc.Client.GetMessage(ctx, stuckUser, old.Sid)
+		if err != nil {
+			// Messages that can't be fetched are tried again on the next
+			// run, until they're older than maxReconcileAge.
+			failed++
+			lastErr = err
+			continue
+		}
+		checked++
+		status, err := message.Status()
+		if err != nil || status == old.Status {
+			continue
+		}
+		st := &services.MessageStatus{
+			Sid:    old.Sid,
+			Status: status,
+			Time:   now,
+			Source: services.StatusSourceReconciled,
+		}
+		if code, err := message.ErrorCode(); err == nil {
+			st.ErrorCode = int(code)
+		}
+		updated, err := rc.Store.Update(st)
+		if err != nil {
+			lastErr = err
+		}
+		if updated {
+			fixed++
+			rc.Info("Reconciled message status", "sid", st.Sid, "old", old.Status, "new", st.Status)
+		}
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.stats.Runs++
+	rc.stats.Checked += int64(checked)
+	rc.stats.Fixed += int64(fixed)
+	rc.stats.Failed += int64(failed)
+	rc.stats.Pending = len(rc.Store.Pending(now.Add(-maxReconcileAge), now.Add(time.Second)))
+	rc.stats.LastRun = now
+	rc.stats.LastDuration = time.Since(start)
+	rc.stats.LastChecked = checked
+	rc.stats.LastFixed = fixed
+	rc.stats.LastErr = ""
+	if lastErr != nil {
+		rc.stats.LastErr = lastErr.Error()
+	}
+	return lastErr
+}
+
+// Run reconciles message statuses every Interval until Stop is called.
+func (rc *statusReconciler) Run() {
+	ticker := time.NewTicker(rc.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rc.done:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+		if err := rc.reconcile(ctx, time.Now().UTC()); err != nil {
+			rc.Warn("Error reconciling message statuses", "err", err)
+		}
+		cancel()
+	}
+}
+
+func (rc *statusReconciler) Stop() {
+	rc.stopOnce.Do(func() {
+		close(rc.done)
+	})
+}
+
+// Stats returns what the reconciler has done so far.
+func (rc *statusReconciler) Stats() reconcileStats {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.stats
+}
+
+type reconcileServer struct {
+	Reconciler *statusReconciler
+}
+
+// GET /debug/reconcile
+//
+// Show what the status reconciler has done, as JSON. Requires can_profile.
+func (s *reconcileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanProfile() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to profile the server"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s.Reconciler.Stats())
+}
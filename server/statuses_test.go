@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+func TestStatusCallback(t *testing.T) {
+	t.Parallel()
+	store, _ := services.NewStatusStore("")
+	s := &statusCallbackServer{Logger: NullLogger, Store: store, AuthToken: "12345", BaseURL: "https://logrole.example.com"}
+	form := url.Values{"MessageSid": {"SM123"}, "MessageStatus": {"undelivered"}, "ErrorCode": {"30006"}}
+	sig := services.TwilioSignature("12345", "https://logrole.example.com/webhooks/messages", form)
+	for _, signature := range []string{"bogus", sig} {
+		req, _ := http.NewRequest("POST", "/webhooks/messages", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Twilio-Signature", signature)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if signature == "bogus" {
+			if w.Code != 403 {
+				t.Errorf("expected an invalid signature to be rejected, got %d", w.Code)
+			}
+			continue
+		}
+		if w.Code != 200 {
+			t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	st := store.Get("SM123")
+	if st == nil || st.Status != twilio.StatusUndelivered || st.ErrorCode != 30006 || st.Source != services.StatusSourceCallback {
+		t.Errorf("expected the status to be recorded, got %#v", st)
+	}
+}
+
+func TestReconcileStatuses(t *testing.T) {
+	t.Parallel()
+	message := func(sid string, status twilio.Status) *twilio.Message {
+		return &twilio.Message{
+			Sid:         sid,
+			Status:      status,
+			Direction:   twilio.DirectionOutboundAPI,
+			NumSegments: 1,
+			DateCreated: twilio.TwilioTime{Valid: true, Time: time.Date(2016, 10, 18, 17, 0, 0, 0, time.UTC)},
+		}
+	}
+	// Twilio doesn't have SM3.
+	vc := harness.FixtureClient(harness.ViewHarness{MaxResourceAge: 1000 * 1000 * time.Hour}, &views.Fixtures{
+		Messages: []*twilio.Message{message("SM1", twilio.StatusDelivered), message("SM2", twilio.StatusSent)},
+	})
+	store, _ := services.NewStatusStore("")
+	now := time.Now().UTC()
+	for _, st := range []*services.MessageStatus{
+		{Sid: "SM1", Status: twilio.StatusSent, Time: now.Add(-time.Hour)},
+		{Sid: "SM2", Status: twilio.StatusSent, Time: now.Add(-time.Hour)},
+		{Sid: "SM3", Status: twilio.StatusQueued, Time: now.Add(-time.Hour)},
+		// Too recent to check; the callback may still be on its way.
+		{Sid: "SM4", Status: twilio.StatusQueued, Time: now.Add(-time.Minute)},
+		// Too old to bother with.
+		{Sid: "SM5", Status: twilio.StatusSent, Time: now.Add(-maxReconcileAge - time.Hour)},
+	} {
+		store.Update(st)
+	}
+	rc := newStatusReconciler(NullLogger, vc, store, time.Minute)
+	if err := rc.reconcile(context.Background(), now); err == nil {
+		t.Error("expected the message Twilio couldn't find to be reported")
+	}
+	if st := store.Get("SM1"); st.Status != twilio.StatusDelivered || st.Source != services.StatusSourceReconciled {
+		t.Errorf("expected SM1 to be reconciled, got %#v", st)
+	}
+	if st := store.Get("SM2"); st.Status != twilio.StatusSent || st.Source == services.StatusSourceReconciled {
+		t.Errorf("expected SM2 to be unchanged, got %#v", st)
+	}
+	stats := rc.Stats()
+	if stats.Runs != 1 || stats.Checked != 2 || stats.Fixed != 1 || stats.Failed != 1 {
+		t.Errorf("unexpected stats: %#v", stats)
+	}
+	// SM2, SM3 and SM4 are still waiting.
+	if stats.Pending != 3 {
+		t.Errorf("expected 3 pending messages, got %d", stats.Pending)
+	}
+}
+
+func TestReconcileServerForbidden(t *testing.T) {
+	t.Parallel()
+	us := config.AllUserSettings()
+	us.CanProfile = false
+	s := &reconcileServer{}
+	req, _ := http.NewRequest("GET", "/debug/reconcile", nil)
+	req = config.SetUser(req, config.NewUser(us))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected users without can_profile to get a 403, got %d", w.Code)
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+)
+
+// streamAfter is how long a list page waits for Twilio before it sends the
+// top of the page (the navigation and the search filters) to the browser.
+// Cached and fast responses are rendered in one piece, with the right status
+// code; slow ones get the rest of the page when the results arrive.
+var streamAfter = 200 * time.Millisecond
+
+// A stream is a list page that's being sent to the browser while its results
+// are still being fetched. Embed it in the page data; the template calls
+// Wait before it renders the results.
+type stream struct {
+	flusher http.Flusher
+	done    chan struct{}
+	// Called by Wait once the fetch finishes, to fill in the page data.
+	then func()
+}
+
+// startStream runs fetch in the background. If fetch finishes within
+// streamAfter, w can't be flushed, or the stream_lists feature is off for the
+// user, startStream waits for it to finish and returns nil, and the page
+// should be rendered as usual. Otherwise the page should be rendered with
+// renderStream and the returned stream.
+func startStream(w http.ResponseWriter, u *config.User, fetch func()) *stream {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fetch()
+	}()
+	flusher, ok := w.(http.Flusher)
+	if !ok || !u.Feature(config.FeatureStreamLists) {
+		<-done
+		return nil
+	}
+	timer := time.NewTimer(streamAfter)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return &stream{flusher: flusher, done: done}
+	}
+}
+
+// Wait sends everything rendered so far to the browser, then waits for the
+// results. It renders nothing, and returns immediately if the page isn't
+// being streamed.
+func (s *stream) Wait() string {
+	if s == nil {
+		return ""
+	}
+	s.flusher.Flush()
+	<-s.done
+	if s.then != nil {
+		s.then()
+		s.then = nil
+	}
+	return ""
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+// How far back to look for stuck messages. Messages older than this are
+// assumed to have been dealt with.
+const stuckLookback = 24 * time.Hour
+
+// Don't fetch more than this many pages of messages per check.
+const maxStuckPages = 5
+const stuckPageSize = 1000
+const stuckCheckTimeout = 1 * time.Minute
+
+// stuckUser is used to fetch messages in the background. It can only see
+// the Sid, status and dates of each message.
+var stuckUser = config.NewUser(&config.UserSettings{CanViewMessages: true})
+
+type stuckMessage struct {
+	Sid         string
+	Status      twilio.Status
+	DateCreated time.Time
+	// How long the message had been stuck when we last checked.
+	StuckFor time.Duration
+}
+
+// FriendlyStuckFor returns StuckFor, rounded down to the minute.
+func (s *stuckMessage) FriendlyStuckFor() string {
+	return (s.StuckFor / time.Minute * time.Minute).String()
+}
+
+// stuckMonitor periodically looks at recent messages and keeps a list of the
+// ones that have been queued or sending for longer than Threshold.
+type stuckMonitor struct {
+	log.Logger
+	Client    views.Client
+	Notifier  services.Notifier
+	Threshold time.Duration
+	Interval  time.Duration
+	// Link to the stuck messages page, included in notifications.
+	URL string
+
+	mu        sync.Mutex
+	messages  []*stuckMessage
+	checkedAt time.Time
+	err       error
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newStuckMonitor(l log.Logger, vc views.Client, n services.Notifier, threshold, interval time.Duration, url string) *stuckMonitor {
+	return &stuckMonitor{
+		Logger:    l,
+		Client:    vc,
+		Notifier:  n,
+		Threshold: threshold,
+		Interval:  interval,
+		URL:       url,
+		done:      make(chan struct{}),
+	}
+}
+
+func isStuckStatus(status twilio.Status) bool {
+	return status == twilio.StatusQueued || status == twilio.StatusSending
+}
+
+// find returns the messages created in the last day that have been queued or
+// sending for longer than m.Threshold.
+func (m *stuckMonitor) find(ctx context.Context, now time.Time) ([]*stuckMessage, error) {
+	data := url.Values{}
+	data.Set("PageSize", strconv.Itoa(stuckPageSize))
+	start := now.Add(-stuckLookback)
+	stuck := make([]*stuckMessage, 0)
+	page, _, err := m.Client.GetMessagePageInRange(ctx, stuckUser, start, now, data)
+	for pages := 1; ; pages++ {
+		if err == twilio.NoMoreResults {
+			return stuck, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, message := range page.Messages() {
+			status, err := message.Status()
+			if err != nil || !isStuckStatus(status) {
+				continue
+			}
+			created, err := message.DateCreated()
+			if err != nil || !created.Valid || now.Sub(created.Time) < m.Threshold {
+				continue
+			}
+			sid, err := message.Sid()
+			if err != nil {
+				continue
+			}
+			stuck = append(stuck, &stuckMessage{
+				Sid:         sid,
+				Status:      status,
+				DateCreated: created.Time,
+				StuckFor:    now.Sub(created.Time),
+			})
+		}
+		next := page.NextPageURI()
+		if !next.Valid || pages >= maxStuckPages {
+			return stuck, nil
+		}
+		page, _, err = m.Client.GetNextMessagePageInRange(ctx, stuckUser, start, now, next.String)
+	}
+}
+
+// check updates the list of stuck messages, and sends a notification if any
+// messages are stuck that weren't the last time we checked.
+func (m *stuckMonitor) check(ctx context.Context) error {
+	now := time.Now().UTC()
+	stuck, err := m.find(ctx, now)
+	m.mu.Lock()
+	m.checkedAt = now
+	m.err = err
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	seen := make(map[string]bool, len(m.messages))
+	for _, message := range m.messages {
+		seen[message.Sid] = true
+	}
+	m.messages = stuck
+	m.mu.Unlock()
+
+	newSids := make([]string, 0)
+	for _, message := range stuck {
+		if !seen[message.Sid] {
+			newSids = append(newSids, message.Sid)
+		}
+	}
+	if len(newSids) == 0 {
+		return nil
+	}
+	subject := fmt.Sprintf("%d messages have been queued or sending for more than %s", len(newSids), m.Threshold)
+	if len(newSids) == 1 {
+		subject = fmt.Sprintf("1 message has been queued or sending for more than %s", m.Threshold)
+	}
+	body := strings.Join(newSids, "\n")
+	if m.URL != "" {
+		body += "\n\n" + m.URL
+	}
+	if err := m.Notifier.Notify(ctx, subject, body); err != nil {
+		m.Warn("Couldn't send stuck message notification", "err", err)
+	}
+	return nil
+}
+
+// Run checks for stuck messages every Interval until Stop is called.
+func (m *stuckMonitor) Run() {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), stuckCheckTimeout)
+		if err := m.check(ctx); err != nil {
+			m.Warn("Error checking for stuck messages", "err", err)
+		}
+		cancel()
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *stuckMonitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.done)
+	})
+}
+
+// Results returns the stuck messages found by the last check, when it
+// happened, and any error it encountered.
+func (m *stuckMonitor) Results() ([]*stuckMessage, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.messages, m.checkedAt, m.err
+}
+
+type stuckServer struct {
+	log.Logger
+	LocationFinder services.LocationFinder
+	MaxResourceAge time.Duration
+	// nil if the monitor is disabled.
+	Monitor *stuckMonitor
+	tpl     *template.Template
+}
+
+func newStuckServer(l log.Logger, lf services.LocationFinder, maxResourceAge time.Duration, m *stuckMonitor) (*stuckServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+stuckTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &stuckServer{
+		Logger:         l,
+		LocationFinder: lf,
+		MaxResourceAge: maxResourceAge,
+		Monitor:        m,
+		tpl:            tpl,
+	}, nil
+}
+
+type stuckData struct {
+	Enabled   bool
+	Messages  []*stuckMessage
+	CheckedAt time.Time
+	Threshold time.Duration
+	Loc       *time.Location
+	Err       string
+}
+
+func (s *stuckData) Title() string {
+	return "Stuck Messages"
+}
+
+func (s *stuckData) Path() string {
+	return "/stuck-messages"
+}
+
+func (s *stuckServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewMessages() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	data := &stuckData{
+		Loc: s.LocationFinder.GetLocationReq(r),
+	}
+	if s.Monitor != nil {
+		messages, checkedAt, err := s.Monitor.Results()
+		data.Enabled = true
+		data.Threshold = s.Monitor.Threshold
+		data.CheckedAt = checkedAt
+		if err != nil {
+			data.Err = cleanError(err)
+		}
+		for _, message := range messages {
+			// The monitor may be able to see older messages than this user.
+			if u.CanViewResource(message.DateCreated, s.MaxResourceAge) {
+				data.Messages = append(data.Messages, message)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/test/harness"
+	"golang.org/x/net/context"
+)
+
+type testNotifier struct {
+	subjects []string
+}
+
+func (t *testNotifier) Notify(ctx context.Context, subject string, body string) error {
+	t.subjects = append(t.subjects, subject)
+	return nil
+}
+
+func messageJSON(sid, status string, created time.Time) string {
+	return fmt.Sprintf(`{"sid": %q, "status": %q, "date_created": %q,
+		"direction": "outbound-api", "from": "+19253920364", "to": "+16103317238",
+		"num_media": "0", "body": "hello"}`,
+		sid, status, created.Format(time.RFC1123Z))
+}
+
+func TestStuckMessages(t *testing.T) {
+	t.Parallel()
+	now := time.Now().UTC()
+	stuckSid := "SM00000000000000000000000000000001"
+	body := fmt.Sprintf(`{"messages": [%s, %s, %s]}`,
+		messageJSON(stuckSid, "queued", now.Add(-time.Hour)),
+		messageJSON("SM00000000000000000000000000000002", "sending", now.Add(-time.Minute)),
+		messageJSON("SM00000000000000000000000000000003", "delivered", now.Add(-2*time.Hour)))
+	server := newServerWithResponse(200, []byte(body))
+	defer server.Close()
+	vc := harness.ViewsClient(harness.ViewHarness{TestServer: server, SecretKey: key, MaxResourceAge: 1000 * 1000 * time.Hour})
+	n := new(testNotifier)
+	m := newStuckMonitor(dlog, vc, n, 15*time.Minute, time.Minute, "")
+	if err := m.check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	messages, _, _ := m.Results()
+	if len(messages) != 1 || messages[0].Sid != stuckSid {
+		t.Fatalf("expected only %s to be stuck, got %v", stuckSid, messages)
+	}
+	if len(n.subjects) != 1 {
+		t.Fatalf("expected one notification, got %d", len(n.subjects))
+	}
+	if err := m.check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.subjects) != 1 {
+		t.Errorf("expected message to be reported only once, got %d notifications", len(n.subjects))
+	}
+
+	s, err := newStuckServer(dlog, lf, 0, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/stuck-messages", nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), stuckSid) {
+		t.Errorf("expected stuck message on the page, got %s", w.Body.String())
+	}
+}
+
+func TestStuckMessagesDisabled(t *testing.T) {
+	t.Parallel()
+	s, err := newStuckServer(dlog, lf, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/stuck-messages", nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "stuck_message_threshold") {
+		t.Errorf("expected page to explain how to enable the monitor")
+	}
+	u := config.NewUser(&config.UserSettings{CanViewCalls: true})
+	req = config.SetUser(req, u)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// ticketResource is implemented by views.Message and views.Call.
+type ticketResource interface {
+	Sid() (string, error)
+	DateCreated() (twilio.TwilioTime, error)
+	Status() (twilio.Status, error)
+	From() (twilio.PhoneNumber, error)
+	To() (twilio.PhoneNumber, error)
+}
+
+// ticketer shows "Create ticket" links on message and call pages, and keeps
+// track of the tickets people created.
+type ticketer struct {
+	Links *config.TicketLinks
+	Store *services.TicketStore
+	// Used to link back to Logrole from the ticket, like
+	// "https://logrole.example.com".
+	BaseURL string
+}
+
+type ticketData struct {
+	Sid   string
+	Links []*config.RenderedTicketLink
+	Refs  []*services.TicketRef
+	Loc   *time.Location
+}
+
+// data returns the ticket links and references for res, which the user can
+// see at path. Properties the user can't see are left out of the links. A
+// nil ticketer returns nil.
+func (t *ticketer) data(resource string, path string, res ticketResource, loc *time.Location) *ticketData {
+	if t == nil {
+		return nil
+	}
+	sid, err := res.Sid()
+	if err != nil {
+		return nil
+	}
+	f := &config.TicketFields{
+		Sid:      sid,
+		Resource: resource,
+		Link:     t.BaseURL + path,
+	}
+	if created, err := res.DateCreated(); err == nil && created.Valid {
+		f.DateCreated = created.Time.UTC().Format(time.RFC3339)
+	}
+	if status, err := res.Status(); err == nil {
+		f.Status = string(status)
+	}
+	if from, err := res.From(); err == nil {
+		f.From = services.MaskPhoneNumber(string(from))
+	}
+	if to, err := res.To(); err == nil {
+		f.To = services.MaskPhoneNumber(string(to))
+	}
+	return &ticketData{
+		Sid:   sid,
+		Links: t.Links.Render(f),
+		Refs:  t.Store.Get(sid),
+		Loc:   loc,
+	}
+}
+
+type ticketServer struct {
+	log.Logger
+	Store *services.TicketStore
+}
+
+// POST /tickets
+//
+// Record the ticket ref for the message or call with the given sid, then
+// redirect back to it.
+func (s *ticketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: "Could not parse form"})
+		return
+	}
+	sid := r.PostForm.Get("sid")
+	var path string
+	switch {
+	case smsSid.MatchString(sid):
+		if !u.CanViewMessages() {
+			rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+			return
+		}
+		path = "/messages/" + sid
+	case callSid.MatchString(sid):
+		if !u.CanViewCalls() {
+			rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+			return
+		}
+		path = "/calls/" + sid
+	default:
+		rest.BadRequest(w, r, &rest.Error{Title: "Tickets can only be recorded for messages and calls"})
+		return
+	}
+	ref, err := s.Store.Add(sid, r.PostForm.Get("ref"), u.ID())
+	if err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	s.Info("Recorded ticket", "sid", sid, "ref", ref.Ref, "user", u.ID())
+	http.Redirect(w, r, path, http.StatusFound)
+}
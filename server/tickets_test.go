@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+func TestTicketServer(t *testing.T) {
+	t.Parallel()
+	store, _ := services.NewTicketStore("")
+	s := &ticketServer{Logger: dlog, Store: store}
+	var tests = []struct {
+		sid  string
+		user *config.User
+		code int
+	}{
+		{mms, config.DefaultUser, 302},
+		{call, config.NewUser(&config.UserSettings{CanViewMessages: true}), 403},
+		{conference, config.DefaultUser, 400},
+	}
+	for _, tt := range tests {
+		body := url.Values{"sid": {tt.sid}, "ref": {"SUPPORT-1"}}.Encode()
+		req, _ := http.NewRequest("POST", "/tickets", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = config.SetUser(req, tt.user)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s: expected Code to be %d, got %d", tt.sid, tt.code, w.Code)
+		}
+	}
+	if refs := store.Get(mms); len(refs) != 1 || refs[0].Ref != "SUPPORT-1" {
+		t.Errorf("expected ticket to be recorded for %s, got %v", mms, refs)
+	}
+	if refs := store.Get(call); len(refs) != 0 {
+		t.Errorf("expected no ticket for %s, got %v", call, refs)
+	}
+}
+
+func TestCallInstanceShowsTicketLinks(t *testing.T) {
+	t.Parallel()
+	created := twilio.TwilioTime{Valid: true, Time: time.Date(2016, 10, 27, 23, 27, 3, 0, time.UTC)}
+	vc := harness.FixtureClient(harness.ViewHarness{MaxResourceAge: 1000 * 1000 * time.Hour}, &views.Fixtures{
+		Calls: []*twilio.Call{{
+			Sid:         parentCallSid,
+			From:        "+19253920364",
+			To:          "+16103317238",
+			Status:      twilio.StatusCompleted,
+			Direction:   twilio.DirectionOutboundDial,
+			Duration:    twilio.TwilioDuration(10 * time.Second),
+			DateCreated: created,
+			StartTime:   created,
+		}},
+	})
+	links, err := config.NewTicketLinks([]config.TicketLink{
+		{Text: "Create Jira issue", URL: "https://example.atlassian.net/create?summary={{ .Sid }}&from={{ .From }}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, _ := services.NewTicketStore("")
+	store.Add(parentCallSid, "SUPPORT-1", "test")
+	tickets := &ticketer{Links: links, Store: store, BaseURL: "https://logrole.example.com"}
+	s, err := newCallInstanceServer(dlog, vc, lf, nil, nil, tickets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/calls/"+parentCallSid, nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	wantURL := "https://example.atlassian.net/create?summary=" + parentCallSid + "&amp;from=%2B%2A%2A%2A%2A%2A%2A%2A0364"
+	if !strings.Contains(body, wantURL) {
+		t.Errorf("expected ticket link %s in body, got %s", wantURL, body)
+	}
+	if !strings.Contains(body, "SUPPORT-1") {
+		t.Error("expected recorded ticket in body")
+	}
+}
@@ -0,0 +1,415 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+var numberTimelineRoute = regexp.MustCompile("^/phone-numbers/" + numberInstancePattern + "/timeline$")
+
+// How many messages and calls to show on each page of the timeline.
+const timelinePageSize = 50
+
+// Stop reading a stream after this many pages. Pages are only read past the
+// first when most of a page is newer than the cursor.
+const maxTimelinePages = 3
+
+// A timelineEntry is a message or a call; exactly one of them is set.
+type timelineEntry struct {
+	Time    time.Time
+	Sid     string
+	Message *views.Message
+	Call    *views.Call
+}
+
+// A timelineCursor is the last entry on a page. The next page starts with the
+// entry right after it, newest first.
+type timelineCursor struct {
+	Time time.Time
+	Sid  string
+}
+
+// before reports whether e comes after the cursor in the timeline, which is
+// sorted by time and then sid, both descending.
+func (c *timelineCursor) before(e *timelineEntry) bool {
+	if c == nil {
+		return true
+	}
+	if !e.Time.Equal(c.Time) {
+		return e.Time.Before(c.Time)
+	}
+	return e.Sid < c.Sid
+}
+
+func (c *timelineCursor) String() string {
+	return strconv.FormatInt(c.Time.UnixNano(), 10) + "," + c.Sid
+}
+
+func parseTimelineCursor(s string) (*timelineCursor, error) {
+	parts := strings.SplitN(s, ",", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, errors.New("Invalid next page")
+	}
+	ns, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid next page")
+	}
+	return &timelineCursor{Time: time.Unix(0, ns).UTC(), Sid: parts[1]}, nil
+}
+
+// entriesByTime sorts a timeline newest first.
+type entriesByTime []*timelineEntry
+
+func (e entriesByTime) Len() int      { return len(e) }
+func (e entriesByTime) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e entriesByTime) Less(i, j int) bool {
+	if !e[i].Time.Equal(e[j].Time) {
+		return e[i].Time.After(e[j].Time)
+	}
+	return e[i].Sid > e[j].Sid
+}
+
+// A timelineStream is one query to the messages or calls API, like "calls
+// from the number".
+type timelineStream struct {
+	Messages bool
+	Filters  url.Values
+}
+
+// timelineStreams returns the queries that together find every message and
+// call between any of pns and any of others, or to and from any of pns if
+// others is empty. pns and others are a number and its aliases.
+func timelineStreams(u *config.User, pns, others []twilio.PhoneNumber) []*timelineStream {
+	var pairs [][2]string
+	for _, pn := range pns {
+		if len(others) == 0 {
+			pairs = append(pairs, [2]string{string(pn), ""}, [2]string{"", string(pn)})
+		}
+		for _, other := range others {
+			pairs = append(pairs, [2]string{string(pn), string(other)}, [2]string{string(other), string(pn)})
+		}
+	}
+	var streams []*timelineStream
+	for _, messages := range []bool{true, false} {
+		if messages && !u.CanViewMessages() || !messages && !u.CanViewCalls() {
+			continue
+		}
+		for _, pair := range pairs {
+			data := url.Values{}
+			data.Set("PageSize", strconv.Itoa(timelinePageSize))
+			if pair[0] != "" {
+				data.Set("From", pair[0])
+			}
+			if pair[1] != "" {
+				data.Set("To", pair[1])
+			}
+			streams = append(streams, &timelineStream{Messages: messages, Filters: data})
+		}
+	}
+	return streams
+}
+
+// mergeTimeline sorts the entries from every stream newest first, dropping
+// duplicates, like a message a number sent to itself. It returns the first
+// timelinePageSize entries and whether there are more.
+func mergeTimeline(streams [][]*timelineEntry, exhausted bool) ([]*timelineEntry, bool) {
+	seen := make(map[string]bool)
+	var entries []*timelineEntry
+	for _, stream := range streams {
+		for _, e := range stream {
+			if seen[e.Sid] {
+				continue
+			}
+			seen[e.Sid] = true
+			entries = append(entries, e)
+		}
+	}
+	sort.Sort(entriesByTime(entries))
+	if len(entries) > timelinePageSize {
+		return entries[:timelinePageSize], true
+	}
+	return entries, !exhausted
+}
+
+type numberTimelineServer struct {
+	log.Logger
+	Client         views.Client
+	LocationFinder services.LocationFinder
+	// Traffic to a number's aliases is shown with the number's. May be nil.
+	Aliases   *services.NumberAliases
+	secretKey *[32]byte
+	tpl       *template.Template
+}
+
+func newNumberTimelineServer(l log.Logger, vc views.Client, lf services.LocationFinder, labels *services.LabelStore, owners *services.OwnerStore, aliases *services.NumberAliases, secretKey *[32]byte) (*numberTimelineServer, error) {
+	tpl, err := newTpl(template.FuncMap{
+		"is_our_pn": vc.IsTwilioNumber,
+		"pn_label":  labels.Get,
+		"pn_owner":  owners.Get,
+	}, base+numberTimelineTpl+phoneTpl+copyScript)
+	if err != nil {
+		return nil, err
+	}
+	return &numberTimelineServer{
+		Logger:         l,
+		Client:         vc,
+		LocationFinder: lf,
+		Aliases:        aliases,
+		secretKey:      secretKey,
+		tpl:            tpl,
+	}, nil
+}
+
+type numberTimelineData struct {
+	PhoneNumber twilio.PhoneNumber
+	// The other side of the conversation, or empty for all of the number's
+	// traffic.
+	Other twilio.PhoneNumber
+	// The other numbers in PhoneNumber's and Other's alias groups, whose
+	// traffic is included.
+	Aliases      []twilio.PhoneNumber
+	OtherAliases []twilio.PhoneNumber
+	Entries      []*timelineEntry
+	Loc          *time.Location
+	// Opaque cursor for the next page, or empty if this is the last page.
+	EncryptedNextPage string
+	Err               string
+}
+
+func (d *numberTimelineData) Title() string {
+	if d.Other != "" {
+		return "Timeline for " + d.PhoneNumber.Friendly() + " and " + d.Other.Friendly()
+	}
+	return "Timeline for " + d.PhoneNumber.Friendly()
+}
+
+// NextQuery returns the query string for the next page.
+func (d *numberTimelineData) NextQuery() template.URL {
+	data := url.Values{}
+	if d.Other != "" {
+		data.Set("other", string(d.Other))
+	}
+	data.Set("next", d.EncryptedNextPage)
+	return template.URL(data.Encode())
+}
+
+func (s *numberTimelineServer) validParams() []string {
+	return []string{"other", "next"}
+}
+
+func (s *numberTimelineServer) render(w http.ResponseWriter, r *http.Request, code int, bd *baseData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", bd); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// GET /phone-numbers/<number>/timeline
+// GET /phone-numbers/<number>/timeline?other=+14155551234
+//
+// Show the messages and calls to and from a number, or between two numbers,
+// newest first in a single list.
+func (s *numberTimelineServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewMessages() && !u.CanViewCalls() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	start := monotime.Now()
+	query := r.URL.Query()
+	data := &numberTimelineData{
+		PhoneNumber: twilio.PhoneNumber(numberTimelineRoute.FindStringSubmatch(r.URL.Path)[1]),
+		Loc:         s.LocationFinder.GetLocationReq(r),
+	}
+	bd := &baseData{LF: s.LocationFinder, Data: data}
+	err := validateParams(s.validParams(), query)
+	if err == nil {
+		if other := strings.TrimSpace(query.Get("other")); other != "" {
+			data.Other, err = twilio.NewPhoneNumber(other)
+		}
+	}
+	var cursor *timelineCursor
+	if err == nil {
+		var next string
+		next, err = getNext(query, pageScope(u, query, "", ""), s.secretKey)
+		if err == nil && next != "" {
+			cursor, err = parseTimelineCursor(next)
+		}
+	}
+	if err != nil {
+		data.Err = cleanError(err)
+		s.render(w, r, http.StatusBadRequest, bd)
+		return
+	}
+	ctx, cancel := getContext(r.Context(), 5*time.Second)
+	defer cancel()
+	var more bool
+	pns := s.Aliases.Of(data.PhoneNumber)
+	data.Aliases = pns[1:]
+	var others []twilio.PhoneNumber
+	if data.Other != "" {
+		others = s.Aliases.Of(data.Other)
+		data.OtherAliases = others[1:]
+	}
+	data.Entries, more, err = s.fetch(ctx, u, timelineStreams(u, pns, others), cursor)
+	if err != nil {
+		data.Err = cleanError(err)
+		s.render(w, r, http.StatusInternalServerError, bd)
+		return
+	}
+	if more && len(data.Entries) > 0 {
+		last := data.Entries[len(data.Entries)-1]
+		next := &timelineCursor{Time: last.Time, Sid: last.Sid}
+		data.EncryptedNextPage = services.OpaqueFor(next.String(), pageScope(u, query, "", ""), s.secretKey)
+	}
+	bd.Duration = monotime.Since(start)
+	s.render(w, r, http.StatusOK, bd)
+}
+
+// fetch reads every stream concurrently and merges them into one page that
+// starts after cursor. It returns true if there are more entries after the
+// page.
+func (s *numberTimelineServer) fetch(ctx context.Context, u *config.User, streams []*timelineStream, cursor *timelineCursor) ([]*timelineEntry, bool, error) {
+	end := twilio.HeatDeath
+	if cursor != nil {
+		// Entries created in the same second as the cursor might not have
+		// been shown yet; before() drops the ones that were.
+		end = cursor.Time.Add(time.Second)
+	}
+	results := make([][]*timelineEntry, len(streams))
+	exhausted := make([]bool, len(streams))
+	g, errctx := errgroup.WithContext(ctx)
+	for i := range streams {
+		i := i
+		g.Go(func() error {
+			var err error
+			if streams[i].Messages {
+				results[i], exhausted[i], err = s.readMessages(errctx, u, end, streams[i].Filters, cursor)
+			} else {
+				results[i], exhausted[i], err = s.readCalls(errctx, u, end, streams[i].Filters, cursor)
+			}
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, false, err
+	}
+	allExhausted := true
+	for _, e := range exhausted {
+		allExhausted = allExhausted && e
+	}
+	entries, more := mergeTimeline(results, allExhausted)
+	return entries, more, nil
+}
+
+// readMessages returns up to timelinePageSize messages after cursor, newest
+// first, and true if there aren't any more.
+func (s *numberTimelineServer) readMessages(ctx context.Context, u *config.User, end time.Time, data url.Values, cursor *timelineCursor) ([]*timelineEntry, bool, error) {
+	var entries []*timelineEntry
+	page, _, err := s.Client.GetMessagePageInRange(ctx, u, twilio.Epoch, end, data)
+	for pages := 1; ; pages++ {
+		if err == twilio.NoMoreResults {
+			return entries, true, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		for _, message := range page.Messages() {
+			sid, err := message.Sid()
+			if err != nil {
+				continue
+			}
+			created, err := message.DateCreated()
+			if err != nil || !created.Valid {
+				continue
+			}
+			e := &timelineEntry{Time: created.Time, Sid: sid, Message: message}
+			if cursor.before(e) {
+				entries = append(entries, e)
+			}
+		}
+		next := page.NextPageURI()
+		if !next.Valid {
+			return entries, true, nil
+		}
+		if len(entries) >= timelinePageSize || pages >= maxTimelinePages {
+			return entries, false, nil
+		}
+		page, _, err = s.Client.GetNextMessagePageInRange(ctx, u, twilio.Epoch, end, next.String)
+	}
+}
+
+// readCalls returns up to timelinePageSize calls after cursor, newest first,
+// and true if there aren't any more.
+func (s *numberTimelineServer) readCalls(ctx context.Context, u *config.User, end time.Time, data url.Values, cursor *timelineCursor) ([]*timelineEntry, bool, error) {
+	var entries []*timelineEntry
+	page, _, err := s.Client.GetCallPageInRange(ctx, u, twilio.Epoch, end, data)
+	for pages := 1; ; pages++ {
+		if err == twilio.NoMoreResults {
+			return entries, true, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		for _, call := range page.Calls() {
+			sid, err := call.Sid()
+			if err != nil {
+				continue
+			}
+			created, err := call.DateCreated()
+			if err != nil || !created.Valid {
+				continue
+			}
+			e := &timelineEntry{Time: created.Time, Sid: sid, Call: call}
+			if cursor.before(e) {
+				entries = append(entries, e)
+			}
+		}
+		next := page.NextPageURI()
+		if !next.Valid {
+			return entries, true, nil
+		}
+		if len(entries) >= timelinePageSize || pages >= maxTimelinePages {
+			return entries, false, nil
+		}
+		page, _, err = s.Client.GetNextCallPageInRange(ctx, u, twilio.Epoch, end, next.String)
+	}
+}
+
+// Kind returns "Message" or "Call".
+func (e *timelineEntry) Kind() string {
+	if e.Message != nil {
+		return "Message"
+	}
+	return "Call"
+}
+
+// URL returns the page for the message or call.
+func (e *timelineEntry) URL() string {
+	if e.Message != nil {
+		return "/messages/" + e.Sid
+	}
+	return "/calls/" + e.Sid
+}
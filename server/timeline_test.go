@@ -0,0 +1,165 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+func TestTimelineCursor(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2016, 10, 18, 17, 0, 0, 0, time.UTC)
+	c := &timelineCursor{Time: now, Sid: "SM5"}
+	parsed, err := parseTimelineCursor(c.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Time.Equal(now) || parsed.Sid != "SM5" {
+		t.Errorf("cursor didn't round trip: %#v", parsed)
+	}
+	if _, err := parseTimelineCursor("notacursor"); err == nil {
+		t.Error("expected an error parsing an invalid cursor")
+	}
+	tests := []struct {
+		e    *timelineEntry
+		want bool
+	}{
+		{&timelineEntry{Time: now.Add(-time.Second), Sid: "SM9"}, true},
+		{&timelineEntry{Time: now, Sid: "SM4"}, true},
+		{&timelineEntry{Time: now, Sid: "SM5"}, false},
+		{&timelineEntry{Time: now, Sid: "SM6"}, false},
+		{&timelineEntry{Time: now.Add(time.Second), Sid: "SM1"}, false},
+	}
+	for _, tt := range tests {
+		if got := c.before(tt.e); got != tt.want {
+			t.Errorf("before(%v, %s): got %t, want %t", tt.e.Time, tt.e.Sid, got, tt.want)
+		}
+	}
+}
+
+func TestMergeTimeline(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2016, 10, 18, 17, 0, 0, 0, time.UTC)
+	messages := []*timelineEntry{
+		{Time: now, Sid: "SM2"},
+		{Time: now.Add(-2 * time.Minute), Sid: "SM1"},
+	}
+	calls := []*timelineEntry{
+		{Time: now.Add(-time.Minute), Sid: "CA1"},
+		// A message to itself shows up in both directions.
+		{Time: now, Sid: "SM2"},
+	}
+	entries, more := mergeTimeline([][]*timelineEntry{messages, calls}, true)
+	if more {
+		t.Error("expected no more entries")
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	for i, sid := range []string{"SM2", "CA1", "SM1"} {
+		if entries[i].Sid != sid {
+			t.Errorf("entry %d: got %s, want %s", i, entries[i].Sid, sid)
+		}
+	}
+	if _, more := mergeTimeline([][]*timelineEntry{messages}, false); !more {
+		t.Error("expected more entries when a stream isn't exhausted")
+	}
+}
+
+func TestTimelineStreams(t *testing.T) {
+	t.Parallel()
+	u := config.NewUser(&config.UserSettings{CanViewCalls: true})
+	streams := timelineStreams(u, []twilio.PhoneNumber{"+14105551234"}, []twilio.PhoneNumber{"+19253920364"})
+	if len(streams) != 2 {
+		t.Fatalf("expected only call streams, got %d", len(streams))
+	}
+	if streams[0].Messages || streams[0].Filters.Get("From") != "+14105551234" || streams[0].Filters.Get("To") != "+19253920364" {
+		t.Errorf("bad first stream: %#v", streams[0])
+	}
+	if streams[1].Filters.Get("From") != "+19253920364" || streams[1].Filters.Get("To") != "+14105551234" {
+		t.Errorf("bad second stream: %#v", streams[1])
+	}
+}
+
+func TestTimelineStreamsAliases(t *testing.T) {
+	t.Parallel()
+	u := config.NewUser(&config.UserSettings{CanViewMessages: true})
+	pns := []twilio.PhoneNumber{"+14105551234", "+14105559876"}
+	streams := timelineStreams(u, pns, []twilio.PhoneNumber{"+19253920364"})
+	if len(streams) != 4 {
+		t.Fatalf("expected a stream each way for each alias, got %d", len(streams))
+	}
+	if streams[2].Filters.Get("From") != "+14105559876" || streams[2].Filters.Get("To") != "+19253920364" {
+		t.Errorf("bad stream for the alias: %#v", streams[2])
+	}
+	if streams := timelineStreams(u, pns, nil); len(streams) != 4 || streams[3].Filters.Get("To") != "+14105559876" {
+		t.Errorf("expected traffic to and from both numbers, got %d streams", len(streams))
+	}
+}
+
+func TestNumberTimeline(t *testing.T) {
+	t.Parallel()
+	vc := harness.FixtureClient(harness.ViewHarness{MaxResourceAge: 1000 * 1000 * time.Hour}, &views.Fixtures{
+		Messages: []*twilio.Message{{
+			Sid:         "SM1",
+			From:        "+14105551234",
+			To:          "+19253920364",
+			Status:      twilio.StatusDelivered,
+			Direction:   twilio.DirectionOutboundAPI,
+			NumSegments: 1,
+			DateCreated: twilio.TwilioTime{Valid: true, Time: time.Date(2016, 10, 18, 17, 0, 0, 0, time.UTC)},
+		}},
+		Calls: []*twilio.Call{{
+			Sid:         "CA1",
+			From:        "+19253920364",
+			To:          "+14105551234",
+			Status:      twilio.StatusCompleted,
+			Direction:   twilio.DirectionInbound,
+			DateCreated: twilio.TwilioTime{Valid: true, Time: time.Date(2016, 10, 18, 17, 5, 0, 0, time.UTC)},
+		}},
+	})
+	s, err := newNumberTimelineServer(dlog, vc, lf, nil, nil, nil, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/phone-numbers/+14105551234/timeline?other=notanumber", nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 400 {
+		t.Errorf("expected Code to be 400, got %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/phone-numbers/+14105551234/timeline", nil)
+	req = config.SetUser(req, theUser)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	call, message := strings.Index(body, "/calls/CA1"), strings.Index(body, "/messages/SM1")
+	if call < 0 || message < 0 || call > message {
+		t.Errorf("expected the newer call before the message")
+	}
+
+	next := services.OpaqueFor((&timelineCursor{Time: time.Date(2016, 10, 18, 17, 5, 0, 0, time.UTC), Sid: "CA1"}).String(), pageScope(theUser, nil, "", ""), key)
+	req, _ = http.NewRequest("GET", "/phone-numbers/+14105551234/timeline?next="+next, nil)
+	req = config.SetUser(req, theUser)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if body := w.Body.String(); strings.Contains(body, "/calls/CA1") || !strings.Contains(body, "/messages/SM1") {
+		t.Errorf("expected the second page to start after the call")
+	}
+}
@@ -0,0 +1,730 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/jobs"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+// The longest period that's counted while the user waits. Longer periods are
+// counted by a background job, like an export.
+const maxInlineTrafficWindow = 7 * 24 * time.Hour
+
+// The longest period a report can cover, and the period it covers if the
+// start isn't set.
+const maxTrafficWindow = 366 * 24 * time.Hour
+const defaultTrafficWindow = 7 * 24 * time.Hour
+
+// The most phone numbers in one report. Each number is two lists to read, the
+// traffic from it and the traffic to it.
+const maxTrafficNumbers = 10
+
+// Stop reading a list after this many pages, and show the counts as lower
+// bounds. Reports counted while the user waits read pages of
+// dashboardPageSize resources; background jobs read pages of exportPageSize.
+const maxTrafficPages = 25
+const maxTrafficJobPages = 250
+
+// How long to reuse a report counted while the user waits. Reports counted by
+// a job are kept as long as the job's download.
+const trafficTimeout = 15 * time.Minute
+
+// Counting runs in the background, since a busy week can take minutes.
+const trafficCountTimeout = 5 * time.Minute
+
+var trafficJobID = regexp.MustCompile("^[a-f0-9]{32}$")
+
+// trafficCounts are the messages and calls created in each hour of the week,
+// indexed by weekday, starting with Sunday, and hour, in the user's timezone.
+type trafficCounts struct {
+	Messages       [7][24]int
+	FailedMessages [7][24]int
+	Calls          [7][24]int
+	FailedCalls    [7][24]int
+	// True if we stopped reading a list before reaching the start of the
+	// period.
+	Truncated  bool
+	Err        string
+	ComputedAt time.Time
+}
+
+// trafficMessageFailed reports whether a message with the given status counts
+// toward the failure rate.
+func trafficMessageFailed(status twilio.Status) bool {
+	return status == twilio.StatusFailed || status == twilio.StatusUndelivered
+}
+
+// trafficCallFailed reports whether a call with the given status counts toward
+// the failure rate.
+func trafficCallFailed(status twilio.Status) bool {
+	return status == twilio.StatusFailed || status == twilio.StatusBusy || status == twilio.StatusNoAnswer
+}
+
+// A trafficSource is one list read for a report.
+type trafficSource struct {
+	// "messages" or "calls"
+	Resource string
+	Filters  url.Values
+	// Resources from these numbers are skipped, because they were counted
+	// when the traffic from them was read.
+	SkipFrom map[string]bool
+}
+
+// trafficSources returns the lists to read for a report on numbers, or on the
+// whole account if numbers is empty. Traffic between two of the numbers is
+// only counted once.
+func trafficSources(u *config.User, numbers []string, pageSize int) []*trafficSource {
+	filters := make([]url.Values, 0, 2*len(numbers)+1)
+	var skipFrom map[string]bool
+	if len(numbers) == 0 {
+		filters = append(filters, url.Values{})
+	} else {
+		skipFrom = make(map[string]bool, len(numbers))
+		for _, pn := range numbers {
+			skipFrom[pn] = true
+			filters = append(filters, url.Values{"From": []string{pn}})
+		}
+		for _, pn := range numbers {
+			filters = append(filters, url.Values{"To": []string{pn}})
+		}
+	}
+	resources := make([]string, 0, 2)
+	if u.CanViewMessages() {
+		resources = append(resources, "messages")
+	}
+	if u.CanViewCalls() {
+		resources = append(resources, "calls")
+	}
+	sources := make([]*trafficSource, 0, len(resources)*len(filters))
+	for _, resource := range resources {
+		for _, f := range filters {
+			data := url.Values{}
+			data.Set("PageSize", strconv.Itoa(pageSize))
+			for k, v := range f {
+				data[k] = v
+			}
+			src := &trafficSource{Resource: resource, Filters: data}
+			if f.Get("To") != "" {
+				src.SkipFrom = skipFrom
+			}
+			sources = append(sources, src)
+		}
+	}
+	return sources
+}
+
+// trafficTask reads each of its sources in turn, a page per Step, and adds up
+// the resources created in each hour of the week. It runs in a goroutine for
+// short periods, and in the export queue for long ones; when it runs as a
+// job, its counts are cached for the report page when it finishes, and its
+// artifact is the counts as a CSV file.
+type trafficTask struct {
+	Client  views.Client
+	User    *config.User
+	Start   time.Time
+	End     time.Time
+	Sources []*trafficSource
+	// How many pages to read from each source.
+	MaxPages int
+	Counts   *trafficCounts
+	// Where to store Counts when the job finishes.
+	Cache    *cache.Cache
+	CacheKey string
+
+	source int
+	pages  int
+	next   string
+}
+
+func newTrafficTask(vc views.Client, u *config.User, numbers []string, start, end time.Time, pageSize, maxPages int) *trafficTask {
+	return &trafficTask{
+		Client:   vc,
+		User:     u,
+		Start:    start,
+		End:      end,
+		Sources:  trafficSources(u, numbers, pageSize),
+		MaxPages: maxPages,
+		Counts:   new(trafficCounts),
+	}
+}
+
+// add counts a resource in the hour of the week it was created, and as a
+// failure if failed is true.
+func (t *trafficTask) add(created twilio.TwilioTime, err error, counts, failures *[7][24]int, failed bool) {
+	if err != nil || !created.Valid {
+		return
+	}
+	c := created.Time.In(t.Start.Location())
+	day, hour := int(c.Weekday()), c.Hour()
+	counts[day][hour]++
+	if failed {
+		failures[day][hour]++
+	}
+}
+
+func (t *trafficTask) readMessages(ctx context.Context, src *trafficSource) (int, string, error) {
+	var page *views.MessagePage
+	var err error
+	if t.next == "" {
+		page, _, err = t.Client.GetMessagePageInRange(ctx, t.User, t.Start, t.End, src.Filters)
+	} else {
+		page, _, err = t.Client.GetNextMessagePageInRange(ctx, t.User, t.Start, t.End, t.next)
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	messages := page.Messages()
+	for _, message := range messages {
+		if from, err := message.From(); err == nil && src.SkipFrom[string(from)] {
+			continue
+		}
+		status, err := message.Status()
+		failed := err == nil && trafficMessageFailed(status)
+		created, err := message.DateCreated()
+		t.add(created, err, &t.Counts.Messages, &t.Counts.FailedMessages, failed)
+	}
+	next := page.NextPageURI()
+	return len(messages), next.String, nil
+}
+
+func (t *trafficTask) readCalls(ctx context.Context, src *trafficSource) (int, string, error) {
+	var page *views.CallPage
+	var err error
+	if t.next == "" {
+		page, _, err = t.Client.GetCallPageInRange(ctx, t.User, t.Start, t.End, src.Filters)
+	} else {
+		page, _, err = t.Client.GetNextCallPageInRange(ctx, t.User, t.Start, t.End, t.next)
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	calls := page.Calls()
+	for _, call := range calls {
+		if from, err := call.From(); err == nil && src.SkipFrom[string(from)] {
+			continue
+		}
+		status, err := call.Status()
+		failed := err == nil && trafficCallFailed(status)
+		created, err := call.DateCreated()
+		t.add(created, err, &t.Counts.Calls, &t.Counts.FailedCalls, failed)
+	}
+	next := page.NextPageURI()
+	return len(calls), next.String, nil
+}
+
+// Step reads the next page of the current source.
+func (t *trafficTask) Step(ctx context.Context) (int, bool, error) {
+	if t.source >= len(t.Sources) {
+		return 0, true, nil
+	}
+	src := t.Sources[t.source]
+	var n int
+	var next string
+	var err error
+	if src.Resource == "calls" {
+		n, next, err = t.readCalls(ctx, src)
+	} else {
+		n, next, err = t.readMessages(ctx, src)
+	}
+	if err == twilio.NoMoreResults {
+		err = nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	t.pages++
+	if next != "" && t.pages >= t.MaxPages {
+		t.Counts.Truncated = true
+		next = ""
+	}
+	t.next = next
+	if next == "" {
+		t.source++
+		t.pages = 0
+	}
+	return n, t.source >= len(t.Sources), nil
+}
+
+// run calls Step until the task is done, for reports that aren't counted in
+// the export queue.
+func (t *trafficTask) run(ctx context.Context) {
+	for {
+		_, done, err := t.Step(ctx)
+		if err != nil {
+			t.Counts.Err = cleanError(err)
+			return
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// Artifact caches the counts for the report page, and returns them as a CSV
+// file.
+func (t *trafficTask) Artifact() (*jobs.Artifact, error) {
+	t.Counts.ComputedAt = time.Now()
+	if t.Cache != nil {
+		t.Cache.Set(t.CacheKey, t.Counts, exportTTL)
+	}
+	buf := new(bytes.Buffer)
+	if err := writeTrafficCSV(buf, t.Counts, t.User); err != nil {
+		return nil, err
+	}
+	return &jobs.Artifact{
+		Filename:    "traffic-by-hour-" + t.Start.Format("20060102") + "-" + t.End.Format("20060102") + ".csv",
+		ContentType: "text/csv; charset=utf-8",
+		Data:        buf.Bytes(),
+	}, nil
+}
+
+var trafficWeekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// writeTrafficCSV writes a row for each hour of the week, with the columns u
+// can view.
+func writeTrafficCSV(buf *bytes.Buffer, counts *trafficCounts, u *config.User) error {
+	w := csv.NewWriter(buf)
+	header := []string{"weekday", "hour"}
+	if u.CanViewMessages() {
+		header = append(header, "messages", "failed_messages")
+	}
+	if u.CanViewCalls() {
+		header = append(header, "calls", "failed_calls")
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for day := range trafficWeekdays {
+		for hour := 0; hour < 24; hour++ {
+			row := []string{trafficWeekdays[day], strconv.Itoa(hour)}
+			if u.CanViewMessages() {
+				row = append(row, strconv.Itoa(counts.Messages[day][hour]), strconv.Itoa(counts.FailedMessages[day][hour]))
+			}
+			if u.CanViewCalls() {
+				row = append(row, strconv.Itoa(counts.Calls[day][hour]), strconv.Itoa(counts.FailedCalls[day][hour]))
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// A trafficCell is the traffic in one hour of the week, or a total.
+type trafficCell struct {
+	Count  int
+	Failed int
+	// 0 for no traffic, up to 4 for the busiest hours.
+	Level int
+}
+
+// FailureRate returns the percentage of Count that failed.
+func (c *trafficCell) FailureRate() float64 {
+	if c.Count == 0 {
+		return 0
+	}
+	return 100 * float64(c.Failed) / float64(c.Count)
+}
+
+type trafficRow struct {
+	Day   string
+	Hours []*trafficCell
+	Total *trafficCell
+}
+
+// A trafficGrid is the traffic of one type, with a row for each day of the
+// week and a column for each hour.
+type trafficGrid struct {
+	Name string
+	Rows []*trafficRow
+	// Each hour, across every day of the week.
+	Hours []*trafficCell
+	Total *trafficCell
+}
+
+func buildTrafficGrid(name string, counts, failed *[7][24]int) *trafficGrid {
+	busiest := 0
+	for day := range counts {
+		for hour := range counts[day] {
+			if counts[day][hour] > busiest {
+				busiest = counts[day][hour]
+			}
+		}
+	}
+	grid := &trafficGrid{Name: name, Hours: make([]*trafficCell, 24), Total: new(trafficCell)}
+	for hour := range grid.Hours {
+		grid.Hours[hour] = new(trafficCell)
+	}
+	for day := range counts {
+		row := &trafficRow{Day: trafficWeekdays[day], Hours: make([]*trafficCell, 24), Total: new(trafficCell)}
+		for hour := range counts[day] {
+			cell := &trafficCell{
+				Count:  counts[day][hour],
+				Failed: failed[day][hour],
+				Level:  heatmapLevel(counts[day][hour], busiest),
+			}
+			row.Hours[hour] = cell
+			for _, total := range []*trafficCell{row.Total, grid.Hours[hour], grid.Total} {
+				total.Count += cell.Count
+				total.Failed += cell.Failed
+			}
+		}
+		grid.Rows = append(grid.Rows, row)
+	}
+	return grid
+}
+
+// buildTrafficGrids returns a grid for each type of resource u can view.
+func buildTrafficGrids(counts *trafficCounts, u *config.User) []*trafficGrid {
+	grids := make([]*trafficGrid, 0, 2)
+	if u.CanViewMessages() {
+		grids = append(grids, buildTrafficGrid("Messages", &counts.Messages, &counts.FailedMessages))
+	}
+	if u.CanViewCalls() {
+		grids = append(grids, buildTrafficGrid("Calls", &counts.Calls, &counts.FailedCalls))
+	}
+	return grids
+}
+
+// parseTrafficNumbers splits a pasted list of phone numbers on commas and
+// newlines, and returns them in E.164 format, sorted, without duplicates.
+func parseTrafficNumbers(val string) ([]string, error) {
+	fields := strings.FieldsFunc(val, func(r rune) bool {
+		return r == ',' || r == '\r' || r == '\n'
+	})
+	seen := make(map[string]bool, len(fields))
+	numbers := make([]string, 0, len(fields))
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		pn, err := twilio.NewPhoneNumber(field)
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't a phone number: %v", field, err)
+		}
+		if seen[string(pn)] {
+			continue
+		}
+		seen[string(pn)] = true
+		numbers = append(numbers, string(pn))
+	}
+	if len(numbers) > maxTrafficNumbers {
+		return nil, fmt.Errorf("Can't report on more than %d phone numbers at once", maxTrafficNumbers)
+	}
+	sort.Strings(numbers)
+	return numbers, nil
+}
+
+// trafficWindow fills in a start or end that wasn't set, and checks the
+// period isn't too long to read. start may already be limited by the user's
+// max resource age, even if they didn't set it.
+func trafficWindow(start, end, now time.Time, startSet bool) (time.Time, time.Time, error) {
+	if end.After(now) {
+		end = now
+	}
+	if def := end.Add(-defaultTrafficWindow); !startSet && start.Before(def) {
+		start = def
+	}
+	if !start.Before(end) {
+		return start, end, errors.New("The start of the period has to be before the end")
+	}
+	if end.Sub(start) > maxTrafficWindow {
+		return start, end, fmt.Errorf("Can't report on more than %d days at once", int(maxTrafficWindow/(24*time.Hour)))
+	}
+	return start, end, nil
+}
+
+type trafficServer struct {
+	log.Logger
+	Client         views.Client
+	LocationFinder services.LocationFinder
+	MaxResourceAge time.Duration
+	// Counts reports for long periods.
+	Jobs  *jobs.Queue
+	cache *cache.Cache
+	tpl   *template.Template
+
+	mu sync.Mutex
+	// Keys of reports being counted right now, outside the export queue.
+	running map[string]bool
+}
+
+func newTrafficServer(l log.Logger, vc views.Client, lf services.LocationFinder, q *jobs.Queue, maxResourceAge time.Duration) (*trafficServer, error) {
+	s := &trafficServer{
+		Logger:         l,
+		Client:         vc,
+		LocationFinder: lf,
+		MaxResourceAge: maxResourceAge,
+		Jobs:           q,
+		cache:          cache.NewCache(100, l),
+		running:        make(map[string]bool),
+	}
+	tpl, err := newTpl(template.FuncMap{}, base+trafficTpl)
+	if err != nil {
+		return nil, err
+	}
+	s.tpl = tpl
+	return s, nil
+}
+
+type trafficData struct {
+	Grids []*trafficGrid
+	// The numbers, one per line, to put back in the form.
+	Numbers string
+	Start   time.Time
+	End     time.Time
+	Loc     *time.Location
+	// True while the counts are being computed in the background.
+	Counting bool
+	// True if the period is too long to count while the user waits, and
+	// there isn't a job counting it yet.
+	NeedsJob bool
+	// The job counting the report, if there is one.
+	Job        *jobs.Job
+	Truncated  bool
+	ComputedAt time.Time
+	Err        string
+}
+
+func (d *trafficData) Title() string {
+	return "Traffic by Hour"
+}
+
+func (d *trafficData) Path() string {
+	return "/traffic"
+}
+
+// Hours returns the column labels, "00" through "23".
+func (d *trafficData) Hours() []string {
+	hours := make([]string, 24)
+	for i := range hours {
+		hours[i] = fmt.Sprintf("%02d", i)
+	}
+	return hours
+}
+
+// RefreshURL returns this report with the period filled in, so refreshing
+// while it's counted doesn't move the end of the period.
+func (d *trafficData) RefreshURL() string {
+	data := url.Values{}
+	if d.Numbers != "" {
+		data.Set("numbers", d.Numbers)
+	}
+	data.Set("start", d.Start.Format(HTML5DatetimeLocalFormat))
+	data.Set("end", d.End.Format(HTML5DatetimeLocalFormat))
+	if d.Job != nil {
+		data.Set("job", d.Job.ID)
+	}
+	return d.Path() + "?" + data.Encode()
+}
+
+func (s *trafficServer) validParams() []string {
+	return []string{"numbers", "start", "end", "job"}
+}
+
+func (s *trafficServer) renderError(w http.ResponseWriter, r *http.Request, code int, query url.Values, err error) {
+	data := &baseData{
+		LF: s.LocationFinder,
+		Data: &trafficData{
+			Numbers: query.Get("numbers"),
+			Loc:     s.LocationFinder.GetLocationReq(r),
+			Err:     cleanError(err),
+		},
+	}
+	s.Warn("Error responding to request", "status", code, "url", r.URL.String(), "err", err)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", data); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func trafficKey(u *config.User, numbers []string, start, end time.Time) string {
+	return fmt.Sprintf("traffic:%s:%d:%d:%s:%s", strings.Join(numbers, ","), start.Unix(), end.Unix(), start.Location().String(), u.ID())
+}
+
+// parse reads the numbers and period from query, and writes an error if
+// they're invalid.
+func (s *trafficServer) parse(w http.ResponseWriter, r *http.Request, u *config.User, query url.Values) (*trafficData, []string, bool) {
+	if err := validateParams(s.validParams(), query); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, err)
+		return nil, nil, true
+	}
+	numbers, err := parseTrafficNumbers(query.Get("numbers"))
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, err)
+		return nil, nil, true
+	}
+	loc := s.LocationFinder.GetLocationReq(r)
+	start, end, wroteError := getTimes(w, r, "start", "end", loc, u.MaxResourceAge(s.MaxResourceAge), query, s)
+	if wroteError {
+		return nil, nil, true
+	}
+	// Round down to the minute, like the times in the form, so the page can
+	// refresh with the same period until the counts are ready.
+	now := time.Now().Truncate(time.Minute).In(loc)
+	start, end, err = trafficWindow(start, end, now, query.Get("start") != "")
+	if err != nil {
+		s.renderError(w, r, http.StatusBadRequest, query, err)
+		return nil, nil, true
+	}
+	data := &trafficData{
+		Numbers: strings.Join(numbers, "\n"),
+		Start:   start,
+		End:     end,
+		Loc:     loc,
+	}
+	return data, numbers, false
+}
+
+// GET /traffic?numbers=%2B14155551234&start=...&end=...
+//
+// Show how many messages and calls were created in each hour of each day of
+// the week, and how many of them failed, for the whole account or a set of
+// numbers. Periods up to a week are counted in the background while the page
+// refreshes; longer ones are counted by a job in the export queue.
+//
+// POST /traffic
+//
+// Start a job to count a long period, and redirect to its report.
+func (s *trafficServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewMessages() && !u.CanViewCalls() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	if r.Method == "POST" {
+		s.submit(w, r, u)
+		return
+	}
+	query := r.URL.Query()
+	data, numbers, wroteError := s.parse(w, r, u, query)
+	if wroteError {
+		return
+	}
+	key := trafficKey(u, numbers, data.Start, data.End)
+	counts := new(trafficCounts)
+	if _, err := s.cache.Get(key, counts); err == nil {
+		data.Grids = buildTrafficGrids(counts, u)
+		data.Truncated = counts.Truncated
+		data.ComputedAt = counts.ComputedAt
+		data.Err = counts.Err
+	} else if data.End.Sub(data.Start) <= maxInlineTrafficWindow {
+		s.start(key, u, numbers, data.Start, data.End)
+		data.Counting = true
+	} else if id := query.Get("job"); trafficJobID.MatchString(id) {
+		job, err := s.Jobs.Get(u.ID(), id)
+		if err == nil {
+			data.Job = &job
+		}
+		// If the job failed, or finished so long ago its counts are gone
+		// from the cache, it can be started again.
+		data.NeedsJob = err != nil || job.Status.Finished()
+	} else {
+		data.NeedsJob = true
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// start counts the report for key in the background, unless it's already
+// being counted.
+func (s *trafficServer) start(key string, u *config.User, numbers []string, start, end time.Time) {
+	s.mu.Lock()
+	if s.running[key] {
+		s.mu.Unlock()
+		return
+	}
+	s.running[key] = true
+	s.mu.Unlock()
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.running, key)
+			s.mu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), trafficCountTimeout)
+		defer cancel()
+		task := newTrafficTask(s.Client, u, numbers, start, end, dashboardPageSize, maxTrafficPages)
+		task.run(ctx)
+		task.Counts.ComputedAt = time.Now()
+		if task.Counts.Err != "" {
+			s.Warn("Error counting traffic", "key", key, "err", task.Counts.Err)
+			// Cache the failure briefly, so the page stops refreshing and
+			// shows the error.
+			s.cache.Set(key, task.Counts, 10*time.Second)
+			return
+		}
+		s.cache.Set(key, task.Counts, trafficTimeout)
+	}()
+}
+
+// describeTraffic returns a short description of a report, for the job list.
+func describeTraffic(numbers []string, start, end time.Time) string {
+	desc := "Traffic by hour (" + start.Format("Jan 2, 2006") + " to " + end.Format("Jan 2, 2006")
+	if len(numbers) > 0 {
+		desc = desc + ", " + strings.Join(numbers, ", ")
+	}
+	return desc + ")"
+}
+
+func (s *trafficServer) submit(w http.ResponseWriter, r *http.Request, u *config.User) {
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, http.StatusBadRequest, nil, err)
+		return
+	}
+	query := r.PostForm
+	data, numbers, wroteError := s.parse(w, r, u, query)
+	if wroteError {
+		return
+	}
+	if data.End.Sub(data.Start) <= maxInlineTrafficWindow {
+		// Short periods don't need a job.
+		http.Redirect(w, r, data.RefreshURL(), http.StatusFound)
+		return
+	}
+	task := newTrafficTask(s.Client, u, numbers, data.Start, data.End, exportPageSize, maxTrafficJobPages)
+	task.Cache = s.cache
+	task.CacheKey = trafficKey(u, numbers, data.Start, data.End)
+	job, err := s.Jobs.Submit(u.ID(), describeTraffic(numbers, data.Start, data.End), task)
+	switch {
+	case err == jobs.ErrTooManyJobs:
+		s.renderError(w, r, http.StatusTooManyRequests, query, err)
+		return
+	case err != nil:
+		s.renderError(w, r, http.StatusInternalServerError, query, err)
+		return
+	}
+	s.Info("Started traffic report", "id", job.ID, "user", u.ID(), "description", job.Description)
+	data.Job = &job
+	http.Redirect(w, r, data.RefreshURL(), http.StatusFound)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/test/harness"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+// newTestTrafficServer serves three messages. SM2 is between two numbers in
+// the report.
+func newTestTrafficServer(t *testing.T) *trafficServer {
+	message := func(sid string, from, to twilio.PhoneNumber, status twilio.Status, created time.Time) *twilio.Message {
+		return &twilio.Message{
+			Sid:         sid,
+			From:        from,
+			To:          to,
+			Status:      status,
+			Direction:   twilio.DirectionOutboundAPI,
+			NumSegments: 1,
+			DateCreated: twilio.TwilioTime{Valid: true, Time: created},
+		}
+	}
+	f := &views.Fixtures{Messages: []*twilio.Message{
+		message("SM1", "+14105551234", "+19253920364", twilio.StatusDelivered, time.Date(2016, 10, 18, 17, 5, 0, 0, time.UTC)),
+		message("SM2", "+14105551234", "+14105555678", twilio.StatusUndelivered, time.Date(2016, 10, 18, 17, 40, 0, 0, time.UTC)),
+		message("SM3", "+19253920364", "+14105555678", twilio.StatusFailed, time.Date(2016, 10, 19, 9, 0, 0, 0, time.UTC)),
+	}}
+	vc := harness.FixtureClient(harness.ViewHarness{MaxResourceAge: 1000 * 1000 * time.Hour}, f)
+	s, err := newTrafficServer(dlog, vc, lf, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParseTrafficNumbers(t *testing.T) {
+	t.Parallel()
+	numbers, err := parseTrafficNumbers("+19253920364, +14105551234\n\n+14105551234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(numbers, ",") != "+14105551234,+19253920364" {
+		t.Errorf("expected sorted numbers without duplicates, got %v", numbers)
+	}
+	if _, err := parseTrafficNumbers("+14105551234, notanumber"); err == nil || !strings.Contains(err.Error(), "notanumber") {
+		t.Errorf("expected an error for a bad number, got %v", err)
+	}
+	many := make([]string, maxTrafficNumbers+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("+1410555%04d", i)
+	}
+	if _, err := parseTrafficNumbers(strings.Join(many, "\n")); err == nil {
+		t.Error("expected an error for too many numbers")
+	}
+}
+
+func TestTrafficWindow(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2016, 10, 31, 15, 0, 0, 0, time.UTC)
+	start, end, err := trafficWindow(twilio.Epoch, twilio.HeatDeath, now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !end.Equal(now) || !start.Equal(now.Add(-defaultTrafficWindow)) {
+		t.Errorf("expected the last week, got %v to %v", start, end)
+	}
+	if _, _, err := trafficWindow(now.AddDate(-2, 0, 0), now, now, true); err == nil {
+		t.Error("expected an error for a period longer than a year")
+	}
+}
+
+func TestTrafficTaskCountsNumbers(t *testing.T) {
+	t.Parallel()
+	s := newTestTrafficServer(t)
+	u := config.NewUser(&config.UserSettings{CanViewMessages: true, CanViewMessageFrom: true, CanViewMessageTo: true, CanViewNumMedia: true})
+	start := time.Date(2016, 10, 16, 0, 0, 0, 0, time.UTC)
+	numbers := []string{"+14105551234", "+14105555678"}
+	task := newTrafficTask(s.Client, u, numbers, start, start.Add(7*24*time.Hour), dashboardPageSize, maxTrafficPages)
+	if len(task.Sources) != 4 {
+		t.Fatalf("expected a list from and to each number, got %d", len(task.Sources))
+	}
+	task.run(context.Background())
+	counts := task.Counts
+	if counts.Err != "" {
+		t.Fatal(counts.Err)
+	}
+	tuesday, wednesday := int(time.Tuesday), int(time.Wednesday)
+	if counts.Messages[tuesday][17] != 2 || counts.FailedMessages[tuesday][17] != 1 {
+		t.Errorf("expected SM2 to be counted once, got %d messages and %d failures", counts.Messages[tuesday][17], counts.FailedMessages[tuesday][17])
+	}
+	if counts.Messages[wednesday][9] != 1 || counts.FailedMessages[wednesday][9] != 1 {
+		t.Errorf("expected SM3 at 9am Wednesday, got %d", counts.Messages[wednesday][9])
+	}
+
+	grids := buildTrafficGrids(counts, u)
+	if len(grids) != 1 {
+		t.Fatalf("expected only a grid for messages, got %d", len(grids))
+	}
+	grid := grids[0]
+	if grid.Total.Count != 3 || grid.Total.Failed != 2 || grid.Hours[17].Count != 2 || grid.Rows[tuesday].Total.Count != 2 {
+		t.Errorf("bad totals: %#v", grid.Total)
+	}
+	if cell := grid.Rows[tuesday].Hours[17]; cell.Level != 3 || cell.FailureRate() != 50 {
+		t.Errorf("expected the busiest hour to be level 3 with half failed, got %#v", cell)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := writeTrafficCSV(buf, counts, u); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "weekday,hour,messages,failed_messages\n") || !strings.Contains(buf.String(), "\nTue,17,2,1\n") {
+		t.Errorf("bad CSV: %s", buf.String())
+	}
+}
+
+func TestTrafficLongPeriodNeedsJob(t *testing.T) {
+	t.Parallel()
+	s := newTestTrafficServer(t)
+	req, _ := http.NewRequest("GET", "/traffic?start=2016-08-01T00%3A00&end=2016-10-01T00%3A00", nil)
+	req = config.SetUser(req, theUser)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Count it in the background") || strings.Contains(body, "Counting traffic") {
+		t.Errorf("expected a button to start a job, got %s", body)
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/cache"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	"golang.org/x/net/context"
+)
+
+var messageTranslateRoute = regexp.MustCompile("^/messages/" + messagePattern + "/translate$")
+
+// A message body doesn't change after it's sent, so a translation can be
+// kept until it's evicted.
+const translationTimeout = 24 * time.Hour
+
+// translator translates message bodies into Language, and caches the
+// translations, so viewing a message again doesn't send the body to the
+// translation service again. A nil translator can't translate anything,
+// which is how translation is disabled.
+type translator struct {
+	Translator services.Translator
+	Language   string
+	cache      *cache.Cache
+}
+
+func newTranslator(t services.Translator, lang string, l log.Logger) *translator {
+	return &translator{
+		Translator: t,
+		Language:   lang,
+		cache:      cache.NewCache(500, l),
+	}
+}
+
+func (t *translator) key(sid string) string {
+	return "translation:" + t.Language + ":" + sid
+}
+
+// Cached returns the translation of the message with the given sid, or nil
+// if it hasn't been translated.
+func (t *translator) Cached(sid string) *services.Translation {
+	if t == nil {
+		return nil
+	}
+	tr := new(services.Translation)
+	if _, err := t.cache.Get(t.key(sid), tr); err != nil {
+		return nil
+	}
+	return tr
+}
+
+// Translate returns the translation of body, the body of the message with
+// the given sid, and whether it was already cached.
+func (t *translator) Translate(ctx context.Context, sid string, body string) (*services.Translation, bool, error) {
+	if tr := t.Cached(sid); tr != nil {
+		return tr, true, nil
+	}
+	tr, err := t.Translator.Translate(ctx, body, t.Language)
+	if err != nil {
+		return nil, false, err
+	}
+	t.cache.Set(t.key(sid), tr, translationTimeout)
+	return tr, false, nil
+}
+
+// translateServer translates the body of a message and sends the user back to
+// the message, which shows the translation. Translating requires the
+// can_translate_messages permission.
+type translateServer struct {
+	log.Logger
+	Client     views.Client
+	Translator *translator
+	Audit      *services.AuditLog
+}
+
+// POST /messages/:sid/translate
+func (s *translateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanTranslateMessages() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to translate messages"})
+		return
+	}
+	sid := messageTranslateRoute.FindStringSubmatch(r.URL.Path)[1]
+	ctx, cancel := getContext(r.Context(), 10*time.Second)
+	defer cancel()
+	message, err := s.Client.GetMessage(ctx, u, sid)
+	switch err {
+	case nil:
+		break
+	case config.PermissionDenied, config.ErrTooOld:
+		rest.Forbidden(w, r, &rest.Error{Title: err.Error()})
+		return
+	default:
+		if terr, ok := err.(*rest.Error); ok && terr.StatusCode == 404 {
+			rest.NotFound(w, r)
+			return
+		}
+		rest.ServerError(w, r, err)
+		return
+	}
+	if !message.CanViewProperty("Body") {
+		rest.Forbidden(w, r, &rest.Error{Title: "Cannot view this message's body"})
+		return
+	}
+	body, err := message.Body()
+	if err != nil || body == "" {
+		rest.BadRequest(w, r, &rest.Error{Title: "Message has no body to translate"})
+		return
+	}
+	tr, cached, err := s.Translator.Translate(ctx, sid, body)
+	if err != nil {
+		s.Warn("Couldn't translate message", "sid", sid, "user", u.ID(), "err", err)
+		rest.ServerError(w, r, err)
+		return
+	}
+	s.Info("Translated message", "sid", sid, "user", u.ID(), "cached", cached)
+	s.Audit.Record(&services.AuditEvent{
+		User:     u.ID(),
+		Action:   "translate_message",
+		Resource: sid,
+		Details: map[string]string{
+			"language":        tr.TargetLanguage,
+			"source_language": tr.SourceLanguage,
+			"cached":          strconv.FormatBool(cached),
+		},
+	})
+	http.Redirect(w, r, "/messages/"+sid+"#translation", http.StatusFound)
+}
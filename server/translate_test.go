@@ -0,0 +1,122 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/test/harness"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+type testTranslator struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (tt *testTranslator) Translate(ctx context.Context, text string, target string) (*services.Translation, error) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.calls++
+	return &services.Translation{Text: "[" + target + "] " + text, SourceLanguage: "es", TargetLanguage: target}, nil
+}
+
+func TestTranslateMessage(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-translate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	vc := harness.FixtureClient(harness.ViewHarness{}, scheduledFixtures(twilio.StatusDelivered))
+	audit, _ := services.NewAuditLog(NullLogger, filepath.Join(dir, "audit.log"))
+	tt := new(testTranslator)
+	tr := newTranslator(tt, "fr", NullLogger)
+	s := &translateServer{Logger: NullLogger, Client: vc, Translator: tr, Audit: audit}
+	u := config.NewUser(config.AllUserSettings())
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "/messages/"+deliveredSid+"/translate", nil)
+		req = config.SetUser(req, u)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != 302 {
+			t.Fatalf("expected 302, got %d: %s", w.Code, w.Body.String())
+		}
+		if loc := w.Header().Get("Location"); loc != "/messages/"+deliveredSid+"#translation" {
+			t.Errorf("expected redirect to the translation, got %q", loc)
+		}
+	}
+	if tt.calls != 1 {
+		t.Errorf("expected the second translation to be cached, got %d calls", tt.calls)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := strings.Count(string(data), "translate_message"); c != 2 {
+		t.Errorf("expected both translations to be audited, got %d: %s", c, data)
+	}
+
+	mis, err := newMessageInstanceServer(dlog, vc, lf, nil, nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mis.Translator = tr
+	req, _ := http.NewRequest("GET", "/messages/"+deliveredSid, nil)
+	req = config.SetUser(req, u)
+	w := httptest.NewRecorder()
+	mis.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "[fr] Your appointment is tomorrow") {
+		t.Errorf("expected the translation on the message page, got %s", body)
+	}
+	if strings.Contains(body, "/translate") {
+		t.Errorf("expected no translate button once the message is translated, got %s", body)
+	}
+}
+
+func TestTranslateForbidden(t *testing.T) {
+	t.Parallel()
+	vc := harness.FixtureClient(harness.ViewHarness{}, scheduledFixtures(twilio.StatusDelivered))
+	audit, _ := services.NewAuditLog(NullLogger, "")
+	tt := new(testTranslator)
+	tr := newTranslator(tt, "fr", NullLogger)
+	s := &translateServer{Logger: NullLogger, Client: vc, Translator: tr, Audit: audit}
+	us := config.AllUserSettings()
+	us.CanTranslateMessages = false
+	u := config.NewUser(us)
+	req, _ := http.NewRequest("POST", "/messages/"+deliveredSid+"/translate", nil)
+	req = config.SetUser(req, u)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected 403, got %d", w.Code)
+	}
+	if tt.calls != 0 {
+		t.Errorf("expected nothing to be translated, got %d calls", tt.calls)
+	}
+
+	mis, err := newMessageInstanceServer(dlog, vc, lf, nil, nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mis.Translator = tr
+	req, _ = http.NewRequest("GET", "/messages/"+deliveredSid, nil)
+	req = config.SetUser(req, u)
+	w = httptest.NewRecorder()
+	mis.ServeHTTP(w, req)
+	if body := w.Body.String(); strings.Contains(body, "/translate") {
+		t.Errorf("expected no translate button for a user who can't translate, got %s", body)
+	}
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+// Stop reading alerts after this many pages of dashboardPageSize, and mark the
+// failure counts as lower bounds.
+const maxUptimePages = 10
+
+// Each period is split into this many buckets; a webhook is "up" during a
+// bucket if Twilio didn't raise an alert for it.
+var uptimeBuckets = map[string]int{
+	"day":  24,
+	"week": 7,
+}
+
+// An uptimeBucket is one slice of the period.
+type uptimeBucket struct {
+	Start    time.Time
+	Failures int
+	// 0 for no failures, up to 4 for the worst bucket on the page.
+	Level int
+}
+
+// webhookUptime is the alert history of one of our webhook URLs.
+type webhookUptime struct {
+	URL      string
+	Failures int
+	// Oldest first.
+	Buckets     []*uptimeBucket
+	LastFailure time.Time
+	// The error code Twilio reported most often for this URL.
+	TopCode twilio.Code
+}
+
+// Uptime returns the percentage of buckets without any failures.
+func (w *webhookUptime) Uptime() float64 {
+	if len(w.Buckets) == 0 {
+		return 100
+	}
+	up := 0
+	for _, b := range w.Buckets {
+		if b.Failures == 0 {
+			up++
+		}
+	}
+	return 100 * float64(up) / float64(len(w.Buckets))
+}
+
+// FriendlyUptime returns the uptime rounded to a tenth of a percent, like
+// "95.8%".
+func (w *webhookUptime) FriendlyUptime() string {
+	return fmt.Sprintf("%.1f%%", w.Uptime())
+}
+
+type uptimeData struct {
+	Period   string
+	Loc      *time.Location
+	Webhooks []*webhookUptime
+	// Alerts without a request URL, usually ones that aren't about a webhook.
+	Skipped int
+	// Stopped before reading every alert in the period.
+	Truncated bool
+	Err       string
+}
+
+func (d *uptimeData) Title() string {
+	return "Webhook Uptime"
+}
+
+func (d *uptimeData) Path() string {
+	return "/alerts/uptime"
+}
+
+// BucketFormat is the time layout for the start of each bucket.
+func (d *uptimeData) BucketFormat() string {
+	if d.Period == "week" {
+		return "Mon, Jan 2"
+	}
+	return "Jan 2, 3PM"
+}
+
+// uptimeServer shows how often Twilio failed to reach each of our webhook
+// URLs, from the alerts it raised. Alerts are only raised for failures, so a
+// URL that never failed doesn't appear.
+type uptimeServer struct {
+	log.Logger
+	Client         views.Client
+	LocationFinder services.LocationFinder
+	tpl            *template.Template
+}
+
+func newUptimeServer(l log.Logger, vc views.Client, lf services.LocationFinder) (*uptimeServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+uptimeTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &uptimeServer{
+		Logger:         l,
+		Client:         vc,
+		LocationFinder: lf,
+		tpl:            tpl,
+	}, nil
+}
+
+func (s *uptimeServer) validParams() []string {
+	return []string{"period"}
+}
+
+func (s *uptimeServer) render(w http.ResponseWriter, r *http.Request, code int, bd *baseData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", bd); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+// GET /alerts/uptime?period=week
+func (s *uptimeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	// Grouping by URL needs the request URL, which is a callback URL.
+	if !u.CanViewAlerts() || !u.CanViewCallbackURLs() {
+		rest.Forbidden(w, r, &rest.Error{Title: "Access denied"})
+		return
+	}
+	start := monotime.Now()
+	query := r.URL.Query()
+	data := &uptimeData{
+		Period: query.Get("period"),
+		Loc:    s.LocationFinder.GetLocationReq(r),
+	}
+	bd := &baseData{LF: s.LocationFinder, Data: data}
+	if data.Period == "" {
+		data.Period = "day"
+	}
+	err := validateParams(s.validParams(), query)
+	length, ok := dashboardPeriods[data.Period]
+	if err == nil && !ok {
+		err = fmt.Errorf(`Unknown period "%s"`, data.Period)
+	}
+	if err != nil {
+		data.Err = cleanError(err)
+		s.render(w, r, http.StatusBadRequest, bd)
+		return
+	}
+	ctx, cancel := getContext(r.Context(), 5*time.Second)
+	defer cancel()
+	end := time.Now().In(data.Loc)
+	alerts, truncated, err := s.fetchAlerts(ctx, u, end.Add(-length), end)
+	if err != nil {
+		switch err {
+		case context.DeadlineExceeded, context.Canceled:
+			data.Err = "Timed out reading alerts. Try again, or view a shorter period."
+		default:
+			data.Err = cleanError(err)
+		}
+		s.render(w, r, http.StatusInternalServerError, bd)
+		return
+	}
+	data.Truncated = truncated
+	data.Webhooks, data.Skipped = buildWebhookUptime(alerts, end.Add(-length), length/time.Duration(uptimeBuckets[data.Period]), uptimeBuckets[data.Period])
+	bd.Duration = monotime.Since(start)
+	s.render(w, r, http.StatusOK, bd)
+}
+
+// fetchAlerts reads the alerts created between start and end, up to
+// maxUptimePages pages. It returns true if there were more.
+func (s *uptimeServer) fetchAlerts(ctx context.Context, u *config.User, start, end time.Time) ([]*views.Alert, bool, error) {
+	var alerts []*views.Alert
+	iter := views.AlertsInRange(s.Client, u, start, end, dashboardFilters())
+	iter.MaxPages = maxUptimePages
+	for iter.Next(ctx) {
+		alerts = append(alerts, iter.Alert())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, false, err
+	}
+	return alerts, iter.Truncated(), nil
+}
+
+// webhookKey groups alerts for the same endpoint: the query string usually
+// holds per-request values, so it's dropped.
+func webhookKey(requestURL string) string {
+	u, err := url.Parse(requestURL)
+	if err != nil || u.Host == "" {
+		return requestURL
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	u.User = nil
+	return u.String()
+}
+
+// buildWebhookUptime groups alerts by webhook URL and counts them in n buckets
+// of length size, starting at start. Webhooks are sorted by the number of
+// failures, most first. It also returns the number of alerts that didn't have
+// a request URL.
+func buildWebhookUptime(alerts []*views.Alert, start time.Time, size time.Duration, n int) ([]*webhookUptime, int) {
+	byURL := make(map[string]*webhookUptime)
+	codes := make(map[string]map[twilio.Code]int)
+	skipped := 0
+	for _, alert := range alerts {
+		requestURL, err := alert.RequestURL()
+		if err != nil || requestURL == "" {
+			skipped++
+			continue
+		}
+		created, err := alert.DateCreated()
+		if err != nil || !created.Valid {
+			skipped++
+			continue
+		}
+		i := int(created.Time.Sub(start) / size)
+		if i < 0 || i >= n {
+			continue
+		}
+		key := webhookKey(requestURL)
+		wu, ok := byURL[key]
+		if !ok {
+			wu = &webhookUptime{URL: key, Buckets: make([]*uptimeBucket, n)}
+			for j := range wu.Buckets {
+				wu.Buckets[j] = &uptimeBucket{Start: start.Add(time.Duration(j) * size)}
+			}
+			byURL[key] = wu
+			codes[key] = make(map[twilio.Code]int)
+		}
+		wu.Failures++
+		wu.Buckets[i].Failures++
+		if created.Time.After(wu.LastFailure) {
+			wu.LastFailure = created.Time
+		}
+		if code, err := alert.ErrorCode(); err == nil {
+			codes[key][code]++
+		}
+	}
+	busiest := 0
+	webhooks := make([]*webhookUptime, 0, len(byURL))
+	for key, wu := range byURL {
+		for code, count := range codes[key] {
+			if count > codes[key][wu.TopCode] || (count == codes[key][wu.TopCode] && code < wu.TopCode) {
+				wu.TopCode = code
+			}
+		}
+		for _, b := range wu.Buckets {
+			if b.Failures > busiest {
+				busiest = b.Failures
+			}
+		}
+		webhooks = append(webhooks, wu)
+	}
+	for _, wu := range webhooks {
+		for _, b := range wu.Buckets {
+			b.Level = heatmapLevel(b.Failures, busiest)
+		}
+	}
+	sort.Sort(webhooksByFailures(webhooks))
+	return webhooks, skipped
+}
+
+// webhooksByFailures sorts webhooks with the most failures first.
+type webhooksByFailures []*webhookUptime
+
+func (w webhooksByFailures) Len() int      { return len(w) }
+func (w webhooksByFailures) Swap(i, j int) { w[i], w[j] = w[j], w[i] }
+func (w webhooksByFailures) Less(i, j int) bool {
+	if w[i].Failures != w[j].Failures {
+		return w[i].Failures > w[j].Failures
+	}
+	return w[i].URL < w[j].URL
+}
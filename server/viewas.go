@@ -0,0 +1,250 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+// The cookie that stores who an admin is viewing the site as, like
+// "user:support@example.com" or "group:support".
+const viewAsCookie = "view_as"
+
+// An admin stops viewing as someone after this long, in case they forget.
+const viewAsMaxAge = time.Hour
+
+// viewAsRoutes accept POST requests while an admin is viewing the site as
+// someone else. Everything else that could make a change is rejected, since
+// the admin would be making it with someone else's permissions.
+var viewAsRoutes = []*regexp.Regexp{
+	regexp.MustCompile(`^/tz$`),
+	regexp.MustCompile(`^/preferences$`),
+	regexp.MustCompile(`^/admin/view-as(/stop)?$`),
+}
+
+var errViewingAs = &rest.Error{
+	Title: "You're viewing the site as someone else. Stop viewing as them to make changes.",
+	ID:    "viewing_as",
+}
+
+// lookupViewAs finds the user or group named in a view_as cookie.
+func lookupViewAs(p *config.Policy, value string) (*config.User, error) {
+	switch {
+	case strings.HasPrefix(value, "user:"):
+		u, found, err := p.Lookup(strings.TrimPrefix(value, "user:"))
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, errors.New("User is not in a group in the policy")
+		}
+		return u, nil
+	case strings.HasPrefix(value, "group:"):
+		return p.GroupUser(strings.TrimPrefix(value, "group:"))
+	default:
+		return nil, errors.New("Unknown view_as value")
+	}
+}
+
+// withViewAs shows the site as another user or group to users who can debug
+// permissions and have chosen someone to view as. The user or group gets
+// their own grants and features, not the admin's. It runs after withGrants,
+// so an admin whose permission comes from a grant can use it.
+func withViewAs(h http.Handler, l log.Logger, policy *config.Policy, grants *config.GrantStore, features config.Features) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(viewAsCookie)
+		if err != nil || cookie.Value == "" || policy == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		u, ok := config.GetUser(r)
+		if !ok || !u.CanDebugPermissions() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		target, err := lookupViewAs(policy, cookie.Value)
+		if err != nil {
+			// The policy changed since the cookie was set.
+			l.Warn("Ignoring view_as cookie", "user", u.ID(), "value", cookie.Value, "err", err)
+			h.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != "GET" && r.Method != "HEAD" && r.Method != "OPTIONS" {
+			allowed := false
+			for _, route := range viewAsRoutes {
+				if route.MatchString(r.URL.Path) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				rest.Forbidden(w, r, errViewingAs)
+				return
+			}
+		}
+		target = grants.Apply(target, time.Now()).WithFeatures(features).ViewedBy(u)
+		h.ServeHTTP(w, config.SetUser(r, target))
+	})
+}
+
+// viewAsServer lets users with can_debug_permissions see the site as a user
+// or group in the policy would see it. Starting and stopping are recorded in
+// the audit log.
+type viewAsServer struct {
+	log.Logger
+	Policy                  *config.Policy
+	Audit                   *services.AuditLog
+	LocationFinder          services.LocationFinder
+	AllowUnencryptedTraffic bool
+	tpl                     *template.Template
+}
+
+func newViewAsServer(l log.Logger, policy *config.Policy, audit *services.AuditLog, lf services.LocationFinder, allowUnencryptedTraffic bool) (*viewAsServer, error) {
+	tpl, err := newTpl(template.FuncMap{}, base+viewAsTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &viewAsServer{
+		Logger:                  l,
+		Policy:                  policy,
+		Audit:                   audit,
+		LocationFinder:          lf,
+		AllowUnencryptedTraffic: allowUnencryptedTraffic,
+		tpl:                     tpl,
+	}, nil
+}
+
+type viewAsData struct {
+	Groups []*config.Group
+	// The user being viewed as, if any.
+	Viewing *config.User
+	Err     string
+	// Form value, so it isn't lost after an error.
+	User string
+}
+
+func (d *viewAsData) Title() string {
+	return "View As"
+}
+
+func (s *viewAsServer) render(w http.ResponseWriter, r *http.Request, code int, data *viewAsData) {
+	if s.Policy != nil {
+		data.Groups = *s.Policy
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.tpl, "base", &baseData{LF: s.LocationFinder, Data: data}); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (s *viewAsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	var viewing *config.User
+	if viewer := u.Viewer(); viewer != nil {
+		viewing = u
+		u = viewer
+	}
+	if !u.CanDebugPermissions() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to view the site as someone else"})
+		return
+	}
+	switch {
+	case r.Method == "GET":
+		data := &viewAsData{Viewing: viewing}
+		if s.Policy == nil {
+			data.Err = "No policy is configured, so everyone sees the same thing"
+		}
+		s.render(w, r, http.StatusOK, data)
+	case r.URL.Path == "/admin/view-as/stop":
+		s.stop(w, r, u, viewing)
+	default:
+		s.start(w, r, u, viewing)
+	}
+}
+
+func (s *viewAsServer) setCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     viewAsCookie,
+		Value:    value,
+		Path:     "/",
+		Secure:   s.AllowUnencryptedTraffic == false,
+		HttpOnly: true,
+		MaxAge:   maxAge,
+	})
+}
+
+// POST /admin/view-as
+//
+// View the site as the user in "user", or the group in "group".
+func (s *viewAsServer) start(w http.ResponseWriter, r *http.Request, u *config.User, viewing *config.User) {
+	if err := r.ParseForm(); err != nil {
+		s.render(w, r, http.StatusBadRequest, &viewAsData{Viewing: viewing, Err: err.Error()})
+		return
+	}
+	data := &viewAsData{
+		Viewing: viewing,
+		User:    strings.TrimSpace(r.PostForm.Get("user")),
+	}
+	group := r.PostForm.Get("group")
+	var value string
+	switch {
+	case s.Policy == nil:
+		data.Err = "No policy is configured, so everyone sees the same thing"
+	case data.User != "" && data.User == u.ID():
+		data.Err = "You're already viewing the site as yourself"
+	case data.User != "":
+		value = "user:" + data.User
+	case group != "":
+		value = "group:" + group
+	default:
+		data.Err = "Choose a user or a group to view the site as"
+	}
+	if data.Err == "" {
+		if _, err := lookupViewAs(s.Policy, value); err != nil {
+			data.Err = err.Error()
+		}
+	}
+	if data.Err != "" {
+		s.render(w, r, http.StatusBadRequest, data)
+		return
+	}
+	s.setCookie(w, value, int(viewAsMaxAge/time.Second))
+	s.Audit.Record(&services.AuditEvent{
+		User:     u.ID(),
+		Action:   "start_view_as",
+		Resource: value,
+	})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// POST /admin/view-as/stop
+//
+// Go back to viewing the site as yourself.
+func (s *viewAsServer) stop(w http.ResponseWriter, r *http.Request, u *config.User, viewing *config.User) {
+	s.setCookie(w, "", -1)
+	if viewing != nil {
+		resource := "group:" + viewing.Group()
+		if viewing.ID() != "" {
+			resource = "user:" + viewing.ID()
+		}
+		s.Audit.Record(&services.AuditEvent{
+			User:     u.ID(),
+			Action:   "stop_view_as",
+			Resource: resource,
+		})
+	}
+	http.Redirect(w, r, "/admin/view-as", http.StatusFound)
+}
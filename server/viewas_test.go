@@ -0,0 +1,122 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+func viewAsRequest(method, path, value string, u *config.User) *http.Request {
+	req, _ := http.NewRequest(method, path, nil)
+	req.AddCookie(&http.Cookie{Name: viewAsCookie, Value: value})
+	return config.SetUser(req, u)
+}
+
+func TestViewAsShowsTargetPermissions(t *testing.T) {
+	t.Parallel()
+	grants, _ := config.NewGrantStore("", nil)
+	admin, _, _ := grantPolicy.Lookup("admin@example.com")
+	support, _, _ := grantPolicy.Lookup("support@example.com")
+	var seen *config.User
+	h := withViewAs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = config.GetUser(r)
+	}), NullLogger, grantPolicy, grants, nil)
+
+	h.ServeHTTP(httptest.NewRecorder(), viewAsRequest("GET", "/messages", "user:support@example.com", admin))
+	if seen.ID() != "support@example.com" || seen.CanViewMessageBody() {
+		t.Errorf("expected to see the site as support, got %s", seen.ID())
+	}
+	if seen.Viewer() != admin {
+		t.Error("expected the admin to be the viewer")
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), viewAsRequest("GET", "/messages", "group:support", admin))
+	if seen.Group() != "support" || seen.ID() != "" || seen.CanViewCalls() {
+		t.Errorf("expected to see the site as the support group, got %q", seen.Group())
+	}
+
+	// Users who can't debug permissions can't view as anyone else.
+	h.ServeHTTP(httptest.NewRecorder(), viewAsRequest("GET", "/messages", "user:admin@example.com", support))
+	if seen != support {
+		t.Errorf("expected the cookie to be ignored, got %s", seen.ID())
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, viewAsRequest("POST", "/messages/SM123/resend", "user:support@example.com", admin))
+	if w.Code != 403 {
+		t.Errorf("expected changes to be rejected while viewing as someone else, got %d", w.Code)
+	}
+}
+
+func TestViewAsStartAndStop(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-viewas-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	audit, _ := services.NewAuditLog(NullLogger, path)
+	s, err := newViewAsServer(NullLogger, grantPolicy, audit, lf, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, _, _ := grantPolicy.Lookup("admin@example.com")
+	support, _, _ := grantPolicy.Lookup("support@example.com")
+
+	if w := postGrant(s, support, "/admin/view-as", url.Values{"group": {"admins"}}); w.Code != 403 {
+		t.Errorf("expected users without can_debug_permissions to get a 403, got %d", w.Code)
+	}
+	if w := postGrant(s, admin, "/admin/view-as", url.Values{"user": {"nobody@example.com"}}); w.Code != 400 {
+		t.Errorf("expected a user who isn't in the policy to be rejected, got %d", w.Code)
+	}
+	w := postGrant(s, admin, "/admin/view-as", url.Values{"user": {"support@example.com"}})
+	if w.Code != 302 {
+		t.Fatalf("expected Code to be 302, got %d: %s", w.Code, w.Body.String())
+	}
+	if c := w.Header().Get("Set-Cookie"); !strings.Contains(c, "view_as=user:support@example.com") {
+		t.Errorf("expected a view_as cookie, got %q", c)
+	}
+
+	viewing := support.ViewedBy(admin)
+	w = postGrant(s, viewing, "/admin/view-as/stop", nil)
+	if w.Code != 302 {
+		t.Fatalf("expected Code to be 302, got %d", w.Code)
+	}
+	if c := w.Header().Get("Set-Cookie"); !strings.Contains(c, "Max-Age=0") {
+		t.Errorf("expected the view_as cookie to be cleared, got %q", c)
+	}
+	data, _ := ioutil.ReadFile(path)
+	for _, action := range []string{"start_view_as", "stop_view_as"} {
+		if !strings.Contains(string(data), action) {
+			t.Errorf("expected %s in the audit log, got %s", action, data)
+		}
+	}
+}
+
+func TestViewAsBanner(t *testing.T) {
+	t.Parallel()
+	admin, _, _ := grantPolicy.Lookup("admin@example.com")
+	support, _, _ := grantPolicy.Lookup("support@example.com")
+	s, err := newViewAsServer(NullLogger, grantPolicy, nil, lf, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/admin/view-as", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, config.SetUser(req, support.ViewedBy(admin)))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "Viewing as support@example.com (group support)") {
+		t.Errorf("expected a banner, got %s", body)
+	}
+}
@@ -0,0 +1,332 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+)
+
+var webhookCaptureRoute = regexp.MustCompile("^/webhooks/(?P<id>[a-f0-9]{32})$")
+var webhookInstanceRoute = regexp.MustCompile("^/debug/webhook/(?P<id>[a-f0-9]{32})$")
+
+// Headers that shouldn't be shown to anyone looking at a captured request.
+var hiddenWebhookHeaders = []string{"Authorization", "Cookie"}
+
+const emptyTwiML = `<?xml version="1.0" encoding="UTF-8"?><Response></Response>`
+
+// Twilio gives up on a webhook after 15 seconds, so there's no point waiting
+// longer for the one we forward to.
+const webhookForwardTimeout = 15 * time.Second
+
+var webhookForwardClient = &http.Client{Timeout: webhookForwardTimeout}
+
+// Headers that aren't copied to a forwarded request. X-Twilio-Signature is
+// replaced with one for the target URL.
+var unforwardedWebhookHeaders = []string{"Authorization", "Cookie", "X-Twilio-Signature", "Content-Length", "Connection", "Accept-Encoding"}
+
+// requestBaseURL returns baseURL, or if it's empty, the scheme and host the
+// request was sent to.
+func requestBaseURL(r *http.Request, baseURL string) string {
+	if baseURL != "" {
+		return baseURL
+	}
+	scheme := "https://"
+	if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+		scheme = "http://"
+	}
+	return scheme + r.Host
+}
+
+// webhookCaptureServer records requests sent to a capture URL. It's not
+// behind authentication, since Twilio has to be able to reach it.
+type webhookCaptureServer struct {
+	log.Logger
+	Store *services.WebhookStore
+	// Used to check X-Twilio-Signature. If empty, signatures aren't checked.
+	AuthToken string
+	// Used to forward requests. If nil, webhookForwardClient is used.
+	Client *http.Client
+}
+
+func (s *webhookCaptureServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := webhookCaptureRoute.FindStringSubmatch(r.URL.Path)[1]
+	now := time.Now().UTC()
+	captureURL, target, err := s.Store.URL(id, now)
+	if err != nil {
+		rest.NotFound(w, r)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, services.MaxWebhookBody+1))
+	if err != nil {
+		rest.BadRequest(w, r, &rest.Error{Title: err.Error()})
+		return
+	}
+	req := &services.CapturedRequest{
+		Time:       now,
+		Method:     r.Method,
+		URL:        r.URL.RequestURI(),
+		RemoteAddr: getRemoteIP(r),
+		Header:     make(http.Header, len(r.Header)),
+	}
+	for k, v := range r.Header {
+		req.Header[k] = v
+	}
+	for _, k := range hiddenWebhookHeaders {
+		req.Header.Del(k)
+	}
+	if len(body) > services.MaxWebhookBody {
+		body = body[:services.MaxWebhookBody]
+		req.Truncated = true
+	}
+	req.Body = body
+	// Twilio signs the URL it was given, including the query string, followed
+	// by the POST parameters.
+	signedURL := captureURL
+	if r.URL.RawQuery != "" {
+		signedURL = signedURL + "?" + r.URL.RawQuery
+	}
+	var form url.Values
+	if r.Method == "POST" && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") && !req.Truncated {
+		form, _ = url.ParseQuery(string(body))
+	}
+	req.Signature = services.CheckTwilioSignature(s.AuthToken, signedURL, form, r.Header.Get("X-Twilio-Signature"))
+	req.CallSid = form.Get("CallSid")
+	if target != "" {
+		req.Response = s.forward(r, req, target, form)
+	}
+	if err := s.Store.Record(id, req); err != nil {
+		rest.NotFound(w, r)
+		return
+	}
+	s.Info("Captured webhook request", "id", id, "method", r.Method, "signature", req.Signature)
+	if resp := req.Response; resp != nil {
+		if resp.Err != "" {
+			http.Error(w, resp.Err, http.StatusBadGateway)
+			return
+		}
+		if ctype := resp.Header.Get("Content-Type"); ctype != "" {
+			w.Header().Set("Content-Type", ctype)
+		}
+		w.WriteHeader(resp.StatusCode)
+		w.Write(resp.Body)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	io.WriteString(w, emptyTwiML)
+}
+
+// forward sends the captured request to target, the way Twilio would have,
+// and returns what target responded with. The request is only signed for
+// target if Twilio's signature was valid, so a capture URL can't be used to
+// sign arbitrary requests.
+func (s *webhookCaptureServer) forward(r *http.Request, req *services.CapturedRequest, target string, form url.Values) *services.CapturedResponse {
+	targetURL := target
+	if r.URL.RawQuery != "" {
+		if strings.Contains(targetURL, "?") {
+			targetURL = targetURL + "&" + r.URL.RawQuery
+		} else {
+			targetURL = targetURL + "?" + r.URL.RawQuery
+		}
+	}
+	if req.Truncated {
+		return &services.CapturedResponse{URL: targetURL, Err: "The request was too large to forward"}
+	}
+	fwd, err := http.NewRequest(r.Method, targetURL, bytes.NewReader(req.Body))
+	if err != nil {
+		return &services.CapturedResponse{URL: targetURL, Err: err.Error()}
+	}
+	for k, v := range req.Header {
+		fwd.Header[k] = v
+	}
+	for _, k := range unforwardedWebhookHeaders {
+		fwd.Header.Del(k)
+	}
+	if req.Signature == services.SignatureValid {
+		fwd.Header.Set("X-Twilio-Signature", services.TwilioSignature(s.AuthToken, targetURL, form))
+	}
+	client := s.Client
+	if client == nil {
+		client = webhookForwardClient
+	}
+	resp, err := client.Do(fwd)
+	if err != nil {
+		s.Warn("Couldn't forward webhook request", "target", target, "err", err)
+		return &services.CapturedResponse{URL: targetURL, Err: "Couldn't reach " + target + ": " + cleanError(err)}
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, services.MaxWebhookBody+1))
+	if err != nil {
+		return &services.CapturedResponse{URL: targetURL, Err: "Couldn't read the response from " + target + ": " + cleanError(err)}
+	}
+	captured := &services.CapturedResponse{
+		URL:        targetURL,
+		StatusCode: resp.StatusCode,
+		Header:     make(http.Header, len(resp.Header)),
+		Body:       body,
+	}
+	for k, v := range resp.Header {
+		captured.Header[k] = v
+	}
+	for _, k := range hiddenWebhookHeaders {
+		captured.Header.Del(k)
+	}
+	captured.Header.Del("Set-Cookie")
+	if len(body) > services.MaxWebhookBody {
+		captured.Body = body[:services.MaxWebhookBody]
+		captured.Truncated = true
+	}
+	return captured
+}
+
+// webhookDebugServer lets a user create capture URLs and see the requests
+// sent to them. It requires the can_view_callback_urls permission, since the
+// requests hold the same data as the callbacks Twilio sends.
+type webhookDebugServer struct {
+	log.Logger
+	Store          *services.WebhookStore
+	LocationFinder services.LocationFinder
+	// Scheme and host for capture URLs, like "https://logrole.example.com". If
+	// empty, the host of the request that creates the capture is used.
+	BaseURL            string
+	CanCheckSignatures bool
+	listTpl            *template.Template
+	instanceTpl        *template.Template
+}
+
+func newWebhookDebugServer(l log.Logger, store *services.WebhookStore, lf services.LocationFinder, baseURL string, canCheckSignatures bool) (*webhookDebugServer, error) {
+	listTpl, err := newTpl(template.FuncMap{}, base+webhookListTpl)
+	if err != nil {
+		return nil, err
+	}
+	instanceTpl, err := newTpl(template.FuncMap{}, base+webhookInstanceTpl+webhookResponseTpl)
+	if err != nil {
+		return nil, err
+	}
+	return &webhookDebugServer{
+		Logger:             l,
+		Store:              store,
+		LocationFinder:     lf,
+		BaseURL:            baseURL,
+		CanCheckSignatures: canCheckSignatures,
+		listTpl:            listTpl,
+		instanceTpl:        instanceTpl,
+	}, nil
+}
+
+type webhookListData struct {
+	Captures    []*services.WebhookCapture
+	Loc         *time.Location
+	TTL         time.Duration
+	MaxRequests int
+	Err         string
+}
+
+func (d *webhookListData) Title() string {
+	return "Webhook Debugger"
+}
+
+type webhookInstanceData struct {
+	Capture            *services.WebhookCapture
+	Loc                *time.Location
+	CanCheckSignatures bool
+}
+
+func (d *webhookInstanceData) Title() string {
+	return "Captured Webhooks"
+}
+
+func (s *webhookDebugServer) renderList(w http.ResponseWriter, r *http.Request, u *config.User, code int, errMsg string) {
+	data := &baseData{
+		LF: s.LocationFinder,
+		Data: &webhookListData{
+			Captures:    s.Store.List(u.ID(), time.Now()),
+			Loc:         s.LocationFinder.GetLocationReq(r),
+			TTL:         services.WebhookCaptureTTL,
+			MaxRequests: services.MaxWebhookRequests,
+			Err:         errMsg,
+		},
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := render(w, r, s.listTpl, "base", data); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
+
+func (s *webhookDebugServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := config.GetUser(r)
+	if !ok {
+		rest.ServerError(w, r, errors.New("No user available"))
+		return
+	}
+	if !u.CanViewCallbackURLs() {
+		rest.Forbidden(w, r, &rest.Error{Title: "You don't have permission to debug webhooks"})
+		return
+	}
+	if match := webhookInstanceRoute.FindStringSubmatch(r.URL.Path); match != nil {
+		s.serveCapture(w, r, u, match[1])
+		return
+	}
+	if r.Method == "POST" {
+		s.create(w, r, u)
+		return
+	}
+	s.renderList(w, r, u, http.StatusOK, "")
+}
+
+// POST /debug/webhook
+//
+// Create a capture URL and redirect to it. If target is set, requests to the
+// capture URL are forwarded there.
+func (s *webhookDebugServer) create(w http.ResponseWriter, r *http.Request, u *config.User) {
+	target := strings.TrimSpace(r.PostFormValue("target"))
+	if target != "" {
+		tu, err := url.Parse(target)
+		if err != nil || (tu.Scheme != "http" && tu.Scheme != "https") || tu.Host == "" {
+			s.renderList(w, r, u, http.StatusBadRequest, "Enter an http or https URL to forward requests to")
+			return
+		}
+	}
+	c, err := s.Store.Create(u.ID(), requestBaseURL(r, s.BaseURL), target, time.Now().UTC())
+	if err != nil {
+		s.renderList(w, r, u, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.Info("Created webhook capture", "id", c.ID, "user", u.ID(), "target", target)
+	http.Redirect(w, r, "/debug/webhook/"+c.ID, http.StatusFound)
+}
+
+// GET /debug/webhook/<id>
+//
+// Show the requests sent to a capture URL.
+func (s *webhookDebugServer) serveCapture(w http.ResponseWriter, r *http.Request, u *config.User, id string) {
+	c, err := s.Store.Get(u.ID(), id, time.Now())
+	if err != nil {
+		rest.NotFound(w, r)
+		return
+	}
+	data := &baseData{
+		LF: s.LocationFinder,
+		Data: &webhookInstanceData{
+			Capture:            c,
+			Loc:                s.LocationFinder.GetLocationReq(r),
+			CanCheckSignatures: s.CanCheckSignatures,
+		},
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := render(w, r, s.instanceTpl, "base", data); err != nil {
+		rest.ServerError(w, r, err)
+	}
+}
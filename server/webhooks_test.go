@@ -0,0 +1,155 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	"github.com/saintpete/logrole/test/harness"
+)
+
+func TestWebhookCapture(t *testing.T) {
+	t.Parallel()
+	store := services.NewWebhookStore()
+	ds, err := newWebhookDebugServer(NullLogger, store, lf, "https://logrole.example.com", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := &webhookCaptureServer{Logger: NullLogger, Store: store, AuthToken: "12345"}
+	admin := config.NewUser(config.AllUserSettings())
+
+	req, _ := http.NewRequest("POST", "/debug/webhook", nil)
+	req = config.SetUser(req, admin)
+	w := httptest.NewRecorder()
+	ds.ServeHTTP(w, req)
+	if w.Code != 302 {
+		t.Fatalf("expected Code to be 302, got %d: %s", w.Code, w.Body.String())
+	}
+	captures := store.List(admin.ID(), time.Now())
+	if len(captures) != 1 {
+		t.Fatalf("expected one capture, got %d", len(captures))
+	}
+	c := captures[0]
+
+	form := url.Values{"MessageSid": {"SM123"}, "Body": {"hello"}}
+	sig := services.TwilioSignature("12345", c.URL+"?debug=1", form)
+	for _, signature := range []string{sig, "bogus"} {
+		req, _ = http.NewRequest("POST", "/webhooks/"+c.ID+"?debug=1", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Twilio-Signature", signature)
+		req.Header.Set("Cookie", "session=secret")
+		w = httptest.NewRecorder()
+		cs.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("expected Code to be 200, got %d", w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "<Response></Response>") {
+			t.Errorf("expected empty TwiML, got %s", w.Body.String())
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/debug/webhook/"+c.ID, nil)
+	req = config.SetUser(req, admin)
+	w = httptest.NewRecorder()
+	ds.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected Code to be 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{"Body=hello", "label-success", "label-danger", "X-Twilio-Signature"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected page to contain %q, got %s", want, body)
+		}
+	}
+	if strings.Contains(body, "session=secret") {
+		t.Error("expected cookies not to be shown")
+	}
+
+	// Other users can't see the capture, and unknown captures 404.
+	policy := &config.Policy{&config.Group{Name: "support", Users: []string{"support@example.com"}, Permissions: config.AllUserSettings()}}
+	other, _, _ := policy.Lookup("support@example.com")
+	req, _ = http.NewRequest("GET", "/debug/webhook/"+c.ID, nil)
+	req = config.SetUser(req, other)
+	w = httptest.NewRecorder()
+	ds.ServeHTTP(w, req)
+	if w.Code != 404 {
+		t.Errorf("expected another user to get a 404, got %d", w.Code)
+	}
+	req, _ = http.NewRequest("POST", "/webhooks/"+strings.Repeat("a", 32), nil)
+	w = httptest.NewRecorder()
+	cs.ServeHTTP(w, req)
+	if w.Code != 404 {
+		t.Errorf("expected unknown capture to 404, got %d", w.Code)
+	}
+	req, _ = http.NewRequest("GET", "/debug/webhook", nil)
+	req = config.SetUser(req, config.NewUser(&config.UserSettings{CanViewMessages: true}))
+	w = httptest.NewRecorder()
+	ds.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected Code to be 403, got %d", w.Code)
+	}
+}
+
+func TestWebhookCaptureForwards(t *testing.T) {
+	t.Parallel()
+	target := harness.NewWebhookTarget("12345")
+	defer target.Close()
+	store := services.NewWebhookStore()
+	ds, err := newWebhookDebugServer(NullLogger, store, lf, "https://logrole.example.com", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := &webhookCaptureServer{Logger: NullLogger, Store: store, AuthToken: "12345"}
+	admin := config.NewUser(config.AllUserSettings())
+
+	for _, bad := range []string{"ftp://example.com", "/twilio/voice"} {
+		req, _ := http.NewRequest("POST", "/debug/webhook", strings.NewReader(url.Values{"target": {bad}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = config.SetUser(req, admin)
+		w := httptest.NewRecorder()
+		ds.ServeHTTP(w, req)
+		if w.Code != 400 {
+			t.Errorf("%s: expected Code to be 400, got %d", bad, w.Code)
+		}
+	}
+	req, _ := http.NewRequest("POST", "/debug/webhook", strings.NewReader(url.Values{"target": {target.URL + "/voice"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = config.SetUser(req, admin)
+	w := httptest.NewRecorder()
+	ds.ServeHTTP(w, req)
+	if w.Code != 302 {
+		t.Fatalf("expected Code to be 302, got %d: %s", w.Code, w.Body.String())
+	}
+	c := store.List(admin.ID(), time.Now())[0]
+
+	form := url.Values{"CallSid": {call}, "From": {"+14105551234"}}
+	sig := services.TwilioSignature("12345", c.URL+"?step=1", form)
+	for _, signature := range []string{sig, "bogus"} {
+		req, _ = http.NewRequest("POST", "/webhooks/"+c.ID+"?step=1", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Twilio-Signature", signature)
+		w = httptest.NewRecorder()
+		cs.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("expected Code to be 200, got %d", w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "<Say>Hello "+call+"</Say>") {
+			t.Errorf("expected the target's TwiML to be returned, got %s", w.Body.String())
+		}
+	}
+	if signatures := target.Signatures(); len(signatures) != 2 || signatures[0] != services.SignatureValid || signatures[1] != services.SignatureMissing {
+		t.Errorf("expected only the request with a valid signature to be re-signed, got %v", signatures)
+	}
+	responses := store.CallResponses(call, time.Now())
+	if len(responses) != 2 || responses[0].Response.StatusCode != 200 {
+		t.Fatalf("expected 2 responses to be recorded, got %d", len(responses))
+	}
+	if want := target.URL + "/voice?step=1"; responses[0].Response.URL != want {
+		t.Errorf("expected the response to be from %s, got %s", want, responses[0].Response.URL)
+	}
+}
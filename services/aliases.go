@@ -0,0 +1,77 @@
+package services
+
+import (
+	"fmt"
+
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// MaxAliases is the most numbers an alias group can have. Each number is
+// another query for every page of a timeline, so groups are kept small.
+const MaxAliases = 3
+
+// NumberAliases are groups of phone numbers that belong to the same customer
+// or line - a customer who changed their number, or a sending number we
+// replaced - so traffic to any of them can be shown as one thread.
+type NumberAliases struct {
+	groups map[twilio.PhoneNumber][]twilio.PhoneNumber
+}
+
+// NewNumberAliases creates NumberAliases from groups of numbers. Numbers can
+// be in any format twilio.NewPhoneNumber understands. A number can only be in
+// one group.
+func NewNumberAliases(groups [][]string) (*NumberAliases, error) {
+	na := &NumberAliases{groups: make(map[twilio.PhoneNumber][]twilio.PhoneNumber)}
+	for i, group := range groups {
+		if len(group) < 2 {
+			return nil, fmt.Errorf("Alias group %d needs at least two numbers", i+1)
+		}
+		if len(group) > MaxAliases {
+			return nil, fmt.Errorf("Alias group %d has %d numbers, the most a group can have is %d", i+1, len(group), MaxAliases)
+		}
+		pns := make([]twilio.PhoneNumber, 0, len(group))
+		for _, s := range group {
+			pn, err := twilio.NewPhoneNumber(s)
+			if err != nil {
+				return nil, fmt.Errorf("Alias group %d: invalid phone number %q", i+1, s)
+			}
+			if _, ok := na.groups[pn]; ok {
+				return nil, fmt.Errorf("Alias group %d: %s is listed more than once", i+1, pn)
+			}
+			na.groups[pn] = pns
+			pns = append(pns, pn)
+		}
+		for _, pn := range pns {
+			na.groups[pn] = pns
+		}
+	}
+	return na, nil
+}
+
+// Of returns pn and every alias of it, with pn first. A nil NumberAliases has
+// no aliases.
+func (na *NumberAliases) Of(pn twilio.PhoneNumber) []twilio.PhoneNumber {
+	if na == nil {
+		return []twilio.PhoneNumber{pn}
+	}
+	group, ok := na.groups[pn]
+	if !ok {
+		return []twilio.PhoneNumber{pn}
+	}
+	aliases := make([]twilio.PhoneNumber, 1, len(group))
+	aliases[0] = pn
+	for _, alias := range group {
+		if alias != pn {
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases
+}
+
+// Len returns the number of numbers that have aliases.
+func (na *NumberAliases) Len() int {
+	if na == nil {
+		return 0
+	}
+	return len(na.groups)
+}
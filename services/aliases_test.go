@@ -0,0 +1,43 @@
+package services
+
+import (
+	"testing"
+
+	twilio "github.com/saintpete/twilio-go"
+)
+
+func TestNumberAliases(t *testing.T) {
+	t.Parallel()
+	na, err := NewNumberAliases([][]string{{"+14105551234", "+19253920364"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliases := na.Of("+19253920364")
+	if len(aliases) != 2 || aliases[0] != "+19253920364" || aliases[1] != "+14105551234" {
+		t.Errorf("expected the number and then its alias, got %v", aliases)
+	}
+	if aliases := na.Of("+18005550100"); len(aliases) != 1 {
+		t.Errorf("expected a number without aliases to be alone, got %v", aliases)
+	}
+	var none *NumberAliases
+	if aliases := none.Of("+14105551234"); len(aliases) != 1 || aliases[0] != twilio.PhoneNumber("+14105551234") {
+		t.Errorf("expected nil aliases to return the number, got %v", aliases)
+	}
+}
+
+func TestNumberAliasesErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		groups [][]string
+		err    string
+	}{
+		{[][]string{{"+14105551234"}}, "Alias group 1 needs at least two numbers"},
+		{[][]string{{"+14105551234", "+19253920364"}, {"+14105551234", "+18005550100"}}, "Alias group 2: +14105551234 is listed more than once"},
+		{[][]string{{"+14105551234", "+19253920364", "+18005550100", "+18005550101"}}, "Alias group 1 has 4 numbers, the most a group can have is 3"},
+	}
+	for _, tt := range tests {
+		if _, err := NewNumberAliases(tt.groups); err == nil || err.Error() != tt.err {
+			t.Errorf("NewNumberAliases(%v): got %v, want %q", tt.groups, err, tt.err)
+		}
+	}
+}
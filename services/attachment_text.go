@@ -0,0 +1,286 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/saintpete/logrole/store"
+)
+
+// MaxAttachmentTextLength is the most text kept for a message's attachments,
+// in bytes. Longer text is cut off; the start of a document is usually what
+// people search for.
+const MaxAttachmentTextLength = 4000
+
+// MaxAttachmentTexts is the most messages an AttachmentTextStore holds. Adding
+// more removes the ones indexed longest ago.
+const MaxAttachmentTexts = 20000
+
+// How much text to show on either side of a match in a Snippet.
+const snippetContext = 60
+
+// AttachmentText is the text found in a message's attachments.
+type AttachmentText struct {
+	MessageSid string `json:"message_sid"`
+	// The text of each attachment, separated by blank lines.
+	Text string `json:"text"`
+	// When the text was extracted.
+	Indexed time.Time `json:"indexed"`
+}
+
+// Snippet returns the part of the text around the first match for q, ignoring
+// case, or the start of the text if it doesn't contain q.
+func (at *AttachmentText) Snippet(q string) string {
+	lower := strings.ToLower(at.Text)
+	i := strings.Index(lower, strings.ToLower(strings.TrimSpace(q)))
+	// Lowercasing can change the length of some characters, so i is only
+	// close to the match in those.
+	if i < 0 || i > len(at.Text) {
+		i = 0
+	}
+	start := i - snippetContext
+	prefix := "…"
+	if start <= 0 {
+		start = 0
+		prefix = ""
+	}
+	end := i + len(q) + snippetContext
+	suffix := "…"
+	if end >= len(at.Text) {
+		end = len(at.Text)
+		suffix = ""
+	}
+	// Don't cut a character in half.
+	for start > 0 && !utf8.RuneStart(at.Text[start]) {
+		start--
+	}
+	for end < len(at.Text) && !utf8.RuneStart(at.Text[end]) {
+		end++
+	}
+	return prefix + strings.TrimSpace(at.Text[start:end]) + suffix
+}
+
+type attachmentTextsByIndexed []*AttachmentText
+
+func (a attachmentTextsByIndexed) Len() int           { return len(a) }
+func (a attachmentTextsByIndexed) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a attachmentTextsByIndexed) Less(i, j int) bool { return a[i].Indexed.Before(a[j].Indexed) }
+
+// truncateText cuts s to at most n bytes, without cutting a character in
+// half.
+func truncateText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// AttachmentTextStore is a local index of the text in MMS attachments, so
+// messages can be found by what their attachments say. The text never leaves
+// Logrole. If the store has a path, each message's text is appended to that
+// file as a line of JSON, and the file is read on startup; the last line for a
+// message wins.
+type AttachmentTextStore struct {
+	path  string
+	mu    sync.RWMutex
+	texts map[string]*AttachmentText
+}
+
+// NewAttachmentTextStore creates an AttachmentTextStore, loading the text in
+// the file at path. The file doesn't need to exist yet. If path is empty, the
+// text is only kept in memory.
+func NewAttachmentTextStore(path string) (*AttachmentTextStore, error) {
+	s := &AttachmentTextStore{
+		path:  path,
+		texts: make(map[string]*AttachmentText),
+	}
+	if path == "" {
+		return s, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	lines := 0
+	for scanner.Scan() {
+		lines++
+		at := new(AttachmentText)
+		if err := json.Unmarshal(scanner.Bytes(), at); err != nil {
+			return nil, fmt.Errorf("Couldn't read attachment text from %s, line %d: %v", path, lines, err)
+		}
+		s.texts[at.MessageSid] = at
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	s.evict()
+	// Drop the lines that were replaced or evicted, so the file doesn't
+	// grow forever.
+	if lines > len(s.texts) {
+		if err := s.rewrite(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Has reports whether the attachments of the message with the given sid have
+// been indexed.
+func (s *AttachmentTextStore) Has(messageSid string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.texts[messageSid]
+	return ok
+}
+
+func (at *AttachmentText) normalize() error {
+	if at.MessageSid == "" {
+		return errors.New("Missing message sid")
+	}
+	at.Text = truncateText(strings.TrimSpace(at.Text), MaxAttachmentTextLength)
+	at.Indexed = at.Indexed.UTC()
+	return nil
+}
+
+// Add records at as the text of its message's attachments, replacing any
+// text that was already indexed for the message. Messages with no text
+// should be added too, so they aren't extracted again.
+func (s *AttachmentTextStore) Add(at *AttachmentText) error {
+	if err := at.normalize(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.texts[at.MessageSid] = at
+	s.evict()
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(at)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// evict removes the texts indexed longest ago until there are at most
+// MaxAttachmentTexts. s.mu must be held.
+func (s *AttachmentTextStore) evict() {
+	if len(s.texts) <= MaxAttachmentTexts {
+		return
+	}
+	all := make([]*AttachmentText, 0, len(s.texts))
+	for _, at := range s.texts {
+		all = append(all, at)
+	}
+	sort.Sort(attachmentTextsByIndexed(all))
+	for _, at := range all[:len(all)-MaxAttachmentTexts] {
+		delete(s.texts, at.MessageSid)
+	}
+}
+
+// Search returns up to limit messages whose attachment text contains q,
+// ignoring case, the most recently indexed first.
+func (s *AttachmentTextStore) Search(q string, limit int) []*AttachmentText {
+	q = strings.ToLower(strings.TrimSpace(q))
+	if s == nil || q == "" {
+		return nil
+	}
+	s.mu.RLock()
+	matches := make([]*AttachmentText, 0)
+	for _, at := range s.texts {
+		if strings.Contains(strings.ToLower(at.Text), q) {
+			matches = append(matches, at)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Sort(sort.Reverse(attachmentTextsByIndexed(matches)))
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// PurgeBefore removes the text indexed before cutoff, rewriting the file if
+// there is one, and returns how many messages it removed. If dryRun is true,
+// it only counts them.
+func (s *AttachmentTextStore) PurgeBefore(cutoff time.Time, dryRun bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	purged := 0
+	for _, at := range s.texts {
+		if at.Indexed.Before(cutoff) {
+			purged++
+		}
+	}
+	if dryRun || purged == 0 {
+		return purged, nil
+	}
+	texts := make(map[string]*AttachmentText, len(s.texts)-purged)
+	for sid, at := range s.texts {
+		if !at.Indexed.Before(cutoff) {
+			texts[sid] = at
+		}
+	}
+	s.texts = texts
+	return purged, s.rewrite()
+}
+
+// rewrite replaces the file with the texts in memory, oldest first. s.mu must
+// be held, or s not shared yet.
+func (s *AttachmentTextStore) rewrite() error {
+	if s.path == "" {
+		return nil
+	}
+	all := make([]*AttachmentText, 0, len(s.texts))
+	for _, at := range s.texts {
+		all = append(all, at)
+	}
+	sort.Sort(attachmentTextsByIndexed(all))
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for _, at := range all {
+		if err := enc.Encode(at); err != nil {
+			return err
+		}
+	}
+	return store.WriteFile(s.path, buf.Bytes())
+}
+
+// Len returns the number of messages in the store.
+func (s *AttachmentTextStore) Len() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.texts)
+}
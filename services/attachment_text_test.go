@@ -0,0 +1,78 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAttachmentTextStore(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-attachment-text-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "attachment-text.json")
+	s, err := NewAttachmentTextStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2016, 10, 18, 17, 0, 0, 0, time.UTC)
+	s.Add(&AttachmentText{MessageSid: "MM1", Text: "Old text", Indexed: now})
+	s.Add(&AttachmentText{MessageSid: "MM1", Text: "INVOICE #4471\nTotal due: $120", Indexed: now.Add(time.Second)})
+	s.Add(&AttachmentText{MessageSid: "MM2", Text: "", Indexed: now})
+	s.Add(&AttachmentText{MessageSid: "MM3", Text: "Second invoice", Indexed: now.Add(2 * time.Second)})
+
+	s2, err := NewAttachmentTextStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s2.Has("MM2") {
+		t.Error("expected a message with no text to be recorded")
+	}
+	results := s2.Search("invoice", 10)
+	if len(results) != 2 || results[0].MessageSid != "MM3" || results[1].MessageSid != "MM1" {
+		t.Fatalf("expected MM3 then MM1, got %v", results)
+	}
+	if results[1].Text != "INVOICE #4471\nTotal due: $120" {
+		t.Errorf("expected the last text for MM1 to win, got %q", results[1].Text)
+	}
+	if results := s2.Search("old text", 10); len(results) != 0 {
+		t.Errorf("expected replaced text not to match, got %v", results)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("expected loading to drop the replaced line, got %d lines", lines)
+	}
+
+	if n, err := s2.PurgeBefore(now.Add(2*time.Second), false); err != nil || n != 2 {
+		t.Errorf("expected to purge MM1 and MM2, got %d, %v", n, err)
+	}
+	s3, err := NewAttachmentTextStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s3.Len() != 1 || !s3.Has("MM3") {
+		t.Errorf("expected the purge to rewrite the file, got %d messages", s3.Len())
+	}
+}
+
+func TestAttachmentTextSnippet(t *testing.T) {
+	t.Parallel()
+	at := &AttachmentText{Text: strings.Repeat("a", 100) + " Invoice #4471 " + strings.Repeat("b", 100)}
+	snippet := at.Snippet("invoice")
+	if !strings.HasPrefix(snippet, "…") || !strings.HasSuffix(snippet, "…") {
+		t.Errorf("expected a cut off snippet, got %q", snippet)
+	}
+	if !strings.Contains(snippet, "Invoice #4471") {
+		t.Errorf("expected snippet to contain the match, got %q", snippet)
+	}
+	at = &AttachmentText{Text: "Total due: $120"}
+	if snippet := at.Snippet("total"); snippet != "Total due: $120" {
+		t.Errorf("expected the whole text, got %q", snippet)
+	}
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/saintpete/logrole/store"
+)
+
+// The store.Store bucket audit events are kept in, keyed by the time of the
+// event, so the keys sort in the order the events happened.
+const auditBucket = "audit_log"
+
+// An AuditEvent records something a user did that someone may need to
+// account for later, like granting permissions or viewing flagged media.
+type AuditEvent struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	Action string    `json:"action"`
+	// The thing that was acted on, like a URL path or a user id.
+	Resource string            `json:"resource,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// AuditLog logs every AuditEvent it records. If it has a path, events are
+// also appended to that file, one JSON object per line, so they survive
+// restarts and can be shipped elsewhere. If it has a store.Store, events are
+// saved there instead.
+type AuditLog struct {
+	log.Logger
+	path string
+	db   store.Store
+	mu   sync.Mutex
+	// Tells apart events recorded at the same time.
+	seq uint64
+}
+
+// NewAuditLog creates an AuditLog that writes to l and, if path is not empty,
+// the file at path. The file is created if it doesn't exist.
+func NewAuditLog(l log.Logger, path string) (*AuditLog, error) {
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return &AuditLog{Logger: l, path: path}, nil
+}
+
+// NewAuditLogWith creates an AuditLog that writes to l and saves events in s.
+func NewAuditLogWith(l log.Logger, s store.Store) *AuditLog {
+	return &AuditLog{Logger: l, db: s}
+}
+
+// Saved reports whether events are saved to a file or a store.Store, rather
+// than only logged.
+func (a *AuditLog) Saved() bool {
+	return a != nil && (a.path != "" || a.db != nil)
+}
+
+// Record writes e to the log. If e.Time is zero, it's set to the current
+// time. A nil AuditLog records nothing.
+func (a *AuditLog) Record(e *AuditEvent) error {
+	if a == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	ctx := []interface{}{"audit", e.Action, "user", e.User}
+	if e.Resource != "" {
+		ctx = append(ctx, "resource", e.Resource)
+	}
+	keys := make([]string, 0, len(e.Details))
+	for k := range e.Details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		ctx = append(ctx, k, e.Details[k])
+	}
+	a.Info("Audit event", ctx...)
+	if a.path == "" && a.db == nil {
+		return nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.db != nil {
+		a.seq++
+		key := fmt.Sprintf("%s-%d", e.Time.UTC().Format("2006-01-02T15:04:05.000000000Z"), a.seq)
+		if err := a.db.Put(auditBucket, key, data); err != nil {
+			a.Error("Couldn't write audit event", "err", err)
+			return err
+		}
+		return nil
+	}
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		a.Error("Couldn't write audit event", "path", a.path, "err", err)
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		a.Error("Couldn't write audit event", "path", a.path, "err", err)
+		return err
+	}
+	return f.Close()
+}
+
+// PurgeBefore removes the events that happened before cutoff from the file,
+// and returns how many it removed. If dryRun is true, it only counts them.
+// Lines that can't be parsed are kept. An AuditLog without a file or a
+// store.Store has nothing to purge.
+func (a *AuditLog) PurgeBefore(cutoff time.Time, dryRun bool) (int, error) {
+	if a == nil || (a.path == "" && a.db == nil) {
+		return 0, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.db != nil {
+		return a.purgeStore(cutoff, dryRun)
+	}
+	data, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		return 0, err
+	}
+	kept := new(bytes.Buffer)
+	purged := 0
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		e := new(AuditEvent)
+		if err := json.Unmarshal(line, e); err == nil && e.Time.Before(cutoff) {
+			purged++
+			continue
+		}
+		kept.Write(line)
+		kept.WriteByte('\n')
+	}
+	if dryRun || purged == 0 {
+		return purged, nil
+	}
+	if err := store.WriteFile(a.path, kept.Bytes()); err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// purgeStore removes the events that happened before cutoff from a.db. a.mu
+// must be held.
+func (a *AuditLog) purgeStore(cutoff time.Time, dryRun bool) (int, error) {
+	all, err := a.db.All(auditBucket)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for key, data := range all {
+		e := new(AuditEvent)
+		if err := json.Unmarshal(data, e); err != nil || !e.Time.Before(cutoff) {
+			continue
+		}
+		if !dryRun {
+			if err := a.db.Delete(auditBucket, key); err != nil {
+				return purged, err
+			}
+		}
+		purged++
+	}
+	return purged, nil
+}
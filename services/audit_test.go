@@ -0,0 +1,68 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/inconshreveable/log15"
+)
+
+func TestAuditLogAppendsEvents(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-audit-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	a, err := NewAuditLog(log.New(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Record(&AuditEvent{User: "admin", Action: "grant_permissions", Resource: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	// A new AuditLog appends to the existing file.
+	a, err = NewAuditLog(log.New(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Record(&AuditEvent{User: "admin", Action: "revoke_grant", Details: map[string]string{"id": "abc"}}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var events []*AuditEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := new(AuditEvent)
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].Action != "grant_permissions" || events[0].Time.IsZero() {
+		t.Errorf("unexpected first event %#v", events[0])
+	}
+	if events[1].Details["id"] != "abc" {
+		t.Errorf("expected details to be saved, got %#v", events[1].Details)
+	}
+}
+
+func TestNilAuditLog(t *testing.T) {
+	t.Parallel()
+	var a *AuditLog
+	if err := a.Record(&AuditEvent{Action: "test"}); err != nil {
+		t.Fatal(err)
+	}
+}
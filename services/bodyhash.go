@@ -0,0 +1,263 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MaxBodyHashes is the most distinct bodies a BodyHashStore counts. Adding
+// more removes the ones seen longest ago.
+const MaxBodyHashes = 10000
+
+// MaxBodyHashMessages is the most messages a BodyHashStore remembers having
+// counted, so a message seen twice is counted once.
+const MaxBodyHashMessages = 200000
+
+// Keep at most this many recent messages for each body to look for bursts
+// in.
+const maxBodyHashSends = 2000
+
+// Stop counting the numbers a body went to after this many.
+const maxBodyHashRecipients = 100000
+
+// Defaults for BodyHashStore.Recipients and BodyHashStore.Window.
+const DefaultDuplicateBodyRecipients = 20
+const DefaultDuplicateBodyWindow = 10 * time.Minute
+
+// NormalizeBody returns the body with invisible characters, like zero width
+// spaces, removed, runs of whitespace collapsed to a single space and
+// letters lowercased, so bodies that only differ in those ways are counted
+// together.
+func NormalizeBody(body string) string {
+	body = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, body)
+	return strings.Join(strings.Fields(body), " ")
+}
+
+// HashBody returns a hash of the normalized body. The body itself can't be
+// recovered from it.
+func HashBody(body string) string {
+	sum := sha256.Sum256([]byte(NormalizeBody(body)))
+	return hex.EncodeToString(sum[:12])
+}
+
+// BodyHash is what a BodyHashStore knows about one body.
+type BodyHash struct {
+	Hash string
+	// How many messages had the body, and how many numbers they went to.
+	Messages   int
+	Recipients int
+	First      time.Time
+	Last       time.Time
+	// The message with the body that was sent most recently, to show what
+	// it says.
+	SampleSid string
+	// The most numbers the body went to within the store's window, and when
+	// that window started.
+	Burst   int
+	BurstAt time.Time
+	// Burst reached the store's threshold.
+	Flagged bool
+}
+
+type bodySend struct {
+	to      string
+	created time.Time
+}
+
+type bodyHashEntry struct {
+	hash       string
+	messages   int
+	recipients map[string]bool
+	first      time.Time
+	last       time.Time
+	sampleSid  string
+	// The most recent sends, oldest first.
+	sends []bodySend
+}
+
+// burst returns the most distinct numbers the body went to within window,
+// and when that window started.
+func (e *bodyHashEntry) burst(window time.Duration) (int, time.Time) {
+	counts := make(map[string]int)
+	best, bestAt := 0, time.Time{}
+	start := 0
+	for _, s := range e.sends {
+		counts[s.to]++
+		for e.sends[start].created.Before(s.created.Add(-window)) {
+			old := e.sends[start].to
+			counts[old]--
+			if counts[old] == 0 {
+				delete(counts, old)
+			}
+			start++
+		}
+		if len(counts) > best {
+			best, bestAt = len(counts), e.sends[start].created
+		}
+	}
+	return best, bestAt
+}
+
+// BodyHashStore counts messages by a hash of their body, to find the same
+// content going to lots of numbers at once, which could be spam or a
+// compromised account. It never holds the bodies themselves, and it's kept
+// in memory, so the counts start over when the server restarts.
+type BodyHashStore struct {
+	// Flag bodies that go to at least Recipients numbers within Window.
+	Recipients int
+	Window     time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*bodyHashEntry
+	// Messages that have been counted, and the order they were counted in,
+	// so the oldest can be forgotten.
+	seen  map[string]bool
+	order []string
+}
+
+// NewBodyHashStore creates a BodyHashStore. If recipients or window are
+// zero, the defaults are used.
+func NewBodyHashStore(recipients int, window time.Duration) *BodyHashStore {
+	if recipients <= 0 {
+		recipients = DefaultDuplicateBodyRecipients
+	}
+	if window <= 0 {
+		window = DefaultDuplicateBodyWindow
+	}
+	return &BodyHashStore{
+		Recipients: recipients,
+		Window:     window,
+		entries:    make(map[string]*bodyHashEntry),
+		seen:       make(map[string]bool),
+	}
+}
+
+// Has reports whether the message with the given sid has been counted.
+func (s *BodyHashStore) Has(messageSid string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seen[messageSid]
+}
+
+// Add counts a message with the given body hash, sent to the given number.
+// Messages that have already been counted are ignored.
+func (s *BodyHashStore) Add(messageSid, hash, to string, created time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen[messageSid] {
+		return
+	}
+	s.seen[messageSid] = true
+	s.order = append(s.order, messageSid)
+	if len(s.order) > MaxBodyHashMessages {
+		delete(s.seen, s.order[0])
+		s.order = s.order[1:]
+	}
+	e, ok := s.entries[hash]
+	if !ok {
+		e = &bodyHashEntry{hash: hash, recipients: make(map[string]bool), first: created}
+		s.entries[hash] = e
+	}
+	e.messages++
+	if len(e.recipients) < maxBodyHashRecipients {
+		e.recipients[to] = true
+	}
+	if created.Before(e.first) {
+		e.first = created
+	}
+	if !created.Before(e.last) {
+		e.last = created
+		e.sampleSid = messageSid
+	}
+	// Pages of messages are newest first, so most sends go at the front.
+	i := sort.Search(len(e.sends), func(i int) bool { return e.sends[i].created.After(created) })
+	e.sends = append(e.sends, bodySend{})
+	copy(e.sends[i+1:], e.sends[i:])
+	e.sends[i] = bodySend{to: to, created: created}
+	if len(e.sends) > maxBodyHashSends {
+		e.sends = e.sends[len(e.sends)-maxBodyHashSends:]
+	}
+	if !ok && len(s.entries) > MaxBodyHashes {
+		s.evict()
+	}
+}
+
+// evict removes the body seen longest ago. s.mu must be held.
+func (s *BodyHashStore) evict() {
+	var oldest *bodyHashEntry
+	for _, e := range s.entries {
+		if oldest == nil || e.last.Before(oldest.last) {
+			oldest = e
+		}
+	}
+	delete(s.entries, oldest.hash)
+}
+
+func (s *BodyHashStore) bodyHash(e *bodyHashEntry) *BodyHash {
+	bh := &BodyHash{
+		Hash:       e.hash,
+		Messages:   e.messages,
+		Recipients: len(e.recipients),
+		First:      e.first,
+		Last:       e.last,
+		SampleSid:  e.sampleSid,
+	}
+	// A body can't go to more numbers in the window than it went to in all.
+	if bh.Recipients >= s.Recipients {
+		bh.Burst, bh.BurstAt = e.burst(s.Window)
+		bh.Flagged = bh.Burst >= s.Recipients
+	}
+	return bh
+}
+
+type bodyHashesByRecipients []*BodyHash
+
+func (b bodyHashesByRecipients) Len() int      { return len(b) }
+func (b bodyHashesByRecipients) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b bodyHashesByRecipients) Less(i, j int) bool {
+	if b[i].Flagged != b[j].Flagged {
+		return b[i].Flagged
+	}
+	if b[i].Recipients != b[j].Recipients {
+		return b[i].Recipients > b[j].Recipients
+	}
+	return b[i].Hash < b[j].Hash
+}
+
+// Duplicates returns up to max bodies that went to more than one number,
+// flagged bodies first, then the ones that went to the most numbers.
+func (s *BodyHashStore) Duplicates(max int) []*BodyHash {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dups := make([]*BodyHash, 0)
+	for _, e := range s.entries {
+		if len(e.recipients) > 1 {
+			dups = append(dups, s.bodyHash(e))
+		}
+	}
+	sort.Sort(bodyHashesByRecipients(dups))
+	if len(dups) > max {
+		dups = dups[:max]
+	}
+	return dups
+}
+
+// Len returns the number of messages the store has counted.
+func (s *BodyHashStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.order)
+}
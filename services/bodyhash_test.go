@@ -0,0 +1,58 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHashBodyNormalizes(t *testing.T) {
+	t.Parallel()
+	a := HashBody("Claim your  PRIZE\nnow")
+	b := HashBody("claim your prize now\u200b ")
+	if a != b {
+		t.Errorf("expected bodies that differ in case and whitespace to match, got %s and %s", a, b)
+	}
+	if c := HashBody("Claim your prize later"); c == a {
+		t.Error("expected different bodies to have different hashes")
+	}
+}
+
+func TestBodyHashStoreBursts(t *testing.T) {
+	t.Parallel()
+	s := NewBodyHashStore(3, 10*time.Minute)
+	start := time.Date(2016, 10, 20, 12, 0, 0, 0, time.UTC)
+	spam := HashBody("Claim your prize")
+	// Three numbers an hour apart, then three numbers a minute apart, added
+	// newest first, the way pages of messages are.
+	for i := 5; i >= 0; i-- {
+		created := start.Add(time.Duration(i) * time.Hour)
+		if i >= 3 {
+			created = start.Add(3*time.Hour + time.Duration(i)*time.Minute)
+		}
+		s.Add(fmt.Sprintf("SM%d", i), spam, fmt.Sprintf("+1415555000%d", i), created)
+	}
+	s.Add("SM5", spam, "+14155550005", start)
+	s.Add("SM6", HashBody("Your order shipped"), "+14155550001", start)
+	s.Add("SM7", HashBody("Your order shipped"), "+14155550002", start.Add(2*time.Hour))
+	s.Add("SM8", HashBody("Only once"), "+14155550001", start)
+	if s.Len() != 9 {
+		t.Errorf("expected 9 messages to be counted, got %d", s.Len())
+	}
+	dups := s.Duplicates(10)
+	if len(dups) != 2 {
+		t.Fatalf("expected 2 duplicate bodies, got %d", len(dups))
+	}
+	if dups[0].Hash != spam || !dups[0].Flagged || dups[0].Burst != 3 || dups[0].Messages != 6 || dups[0].Recipients != 6 {
+		t.Errorf("expected the spam to be flagged first, got %#v", dups[0])
+	}
+	if want := start.Add(3*time.Hour + 3*time.Minute); !dups[0].BurstAt.Equal(want) {
+		t.Errorf("expected the burst to start at %v, got %v", want, dups[0].BurstAt)
+	}
+	if dups[0].SampleSid != "SM5" {
+		t.Errorf("expected the most recent message as the sample, got %s", dups[0].SampleSid)
+	}
+	if dups[1].Flagged || dups[1].Recipients != 2 {
+		t.Errorf("expected the order body not to be flagged, got %#v", dups[1])
+	}
+}
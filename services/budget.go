@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ErrCallBudgetExceeded is returned for Twilio API requests made after an
+// HTTP request has used up its CallBudget.
+var ErrCallBudgetExceeded = errors.New("This page made too many requests to the Twilio API, try a narrower search")
+
+// A CallBudget counts the Twilio API requests made on behalf of a single HTTP
+// request, and how long they took. It's safe for concurrent use.
+type CallBudget struct {
+	// The maximum number of requests. If zero, there's no limit.
+	max      int64
+	calls    int64
+	denied   int64
+	duration int64
+}
+
+// NewCallBudget returns a CallBudget that allows max requests, or any number
+// of requests if max is zero.
+func NewCallBudget(max int) *CallBudget {
+	return &CallBudget{max: int64(max)}
+}
+
+// Calls returns the number of requests made so far.
+func (b *CallBudget) Calls() int {
+	return int(atomic.LoadInt64(&b.calls))
+}
+
+// Denied returns the number of requests that weren't made because the budget
+// was used up.
+func (b *CallBudget) Denied() int {
+	return int(atomic.LoadInt64(&b.denied))
+}
+
+// Duration returns the total time spent on requests so far. Requests made
+// concurrently are added together.
+func (b *CallBudget) Duration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&b.duration))
+}
+
+func (b *CallBudget) take() bool {
+	if n := atomic.AddInt64(&b.calls, 1); b.max > 0 && n > b.max {
+		atomic.AddInt64(&b.calls, -1)
+		atomic.AddInt64(&b.denied, 1)
+		return false
+	}
+	return true
+}
+
+type callBudgetKey struct{}
+
+// WithCallBudget returns a copy of ctx that counts Twilio API requests
+// against b.
+func WithCallBudget(ctx context.Context, b *CallBudget) context.Context {
+	return context.WithValue(ctx, callBudgetKey{}, b)
+}
+
+// GetCallBudget returns the CallBudget for ctx, or nil if there isn't one.
+func GetCallBudget(ctx context.Context) *CallBudget {
+	b, _ := ctx.Value(callBudgetKey{}).(*CallBudget)
+	return b
+}
+
+// DetachCallBudget returns a context for background work started by a
+// request, like fetching the next page into the cache. It isn't canceled when
+// ctx is, but its requests still count against the budget for ctx.
+func DetachCallBudget(ctx context.Context) context.Context {
+	if b := GetCallBudget(ctx); b != nil {
+		return WithCallBudget(context.Background(), b)
+	}
+	return context.Background()
+}
+
+type callBudgetTransport struct {
+	rt http.RoundTripper
+}
+
+// NewCallBudgetTransport returns a RoundTripper that counts each request
+// against the CallBudget in the request's context, and fails requests with
+// ErrCallBudgetExceeded once the budget is used up. Requests without a
+// CallBudget are passed to rt unchanged.
+func NewCallBudgetTransport(rt http.RoundTripper) http.RoundTripper {
+	return &callBudgetTransport{rt: rt}
+}
+
+func (t *callBudgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := GetCallBudget(req.Context())
+	if b == nil {
+		return t.rt.RoundTrip(req)
+	}
+	if !b.take() {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrCallBudgetExceeded
+	}
+	start := time.Now()
+	resp, err := t.rt.RoundTrip(req)
+	atomic.AddInt64(&b.duration, int64(time.Since(start)))
+	return resp, err
+}
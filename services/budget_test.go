@@ -0,0 +1,68 @@
+package services
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+type countingTransport int
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	*c++
+	return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader("{}"))}, nil
+}
+
+func TestCallBudgetTransport(t *testing.T) {
+	t.Parallel()
+	ct := new(countingTransport)
+	rt := NewCallBudgetTransport(ct)
+	b := NewCallBudget(2)
+	ctx := WithCallBudget(context.Background(), b)
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "https://api.twilio.com/2010-04-01/Accounts/AC123/Messages.json", nil)
+		_, err := rt.RoundTrip(req.WithContext(ctx))
+		if i < 2 && err != nil {
+			t.Fatal(err)
+		}
+		if i == 2 && err != ErrCallBudgetExceeded {
+			t.Errorf("expected ErrCallBudgetExceeded, got %v", err)
+		}
+	}
+	if *ct != 2 {
+		t.Errorf("expected 2 requests to be made, got %d", *ct)
+	}
+	if b.Calls() != 2 || b.Denied() != 1 {
+		t.Errorf("expected 2 calls and 1 denied, got %d and %d", b.Calls(), b.Denied())
+	}
+}
+
+func TestCallBudgetTransportNoBudget(t *testing.T) {
+	t.Parallel()
+	ct := new(countingTransport)
+	rt := NewCallBudgetTransport(ct)
+	req, _ := http.NewRequest("GET", "https://api.twilio.com/2010-04-01/Accounts/AC123/Messages.json", nil)
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if *ct != 1 {
+		t.Errorf("expected 1 request to be made, got %d", *ct)
+	}
+}
+
+func TestDetachCallBudget(t *testing.T) {
+	t.Parallel()
+	b := NewCallBudget(0)
+	ctx, cancel := context.WithCancel(WithCallBudget(context.Background(), b))
+	cancel()
+	detached := DetachCallBudget(ctx)
+	if detached.Err() != nil {
+		t.Errorf("expected detached context not to be canceled, got %v", detached.Err())
+	}
+	if GetCallBudget(detached) != b {
+		t.Errorf("expected detached context to keep the budget")
+	}
+}
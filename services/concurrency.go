@@ -0,0 +1,235 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ErrTwilioBusy is returned for Twilio API requests that waited too long for
+// other requests to Twilio to finish.
+var ErrTwilioBusy = errors.New("Too many requests to Twilio are in progress, try again in a few seconds")
+
+// A concurrencyLimit is a semaphore with counters for /debug/vars.
+type concurrencyLimit struct {
+	sem      chan struct{}
+	waiting  int64
+	requests int64
+	timedOut int64
+	waitTime int64
+}
+
+// ConcurrencyStats are the counters for one limit, since the server started.
+type ConcurrencyStats struct {
+	Limit    int   `json:"limit"`
+	InFlight int   `json:"in_flight"`
+	Waiting  int64 `json:"waiting"`
+	Requests int64 `json:"requests"`
+	// Requests that failed with ErrTwilioBusy.
+	TimedOut int64 `json:"timed_out"`
+	// The total time requests spent waiting for a slot.
+	WaitTime string `json:"wait_time"`
+}
+
+func (c *concurrencyLimit) stats() ConcurrencyStats {
+	return ConcurrencyStats{
+		Limit:    cap(c.sem),
+		InFlight: len(c.sem),
+		Waiting:  atomic.LoadInt64(&c.waiting),
+		Requests: atomic.LoadInt64(&c.requests),
+		TimedOut: atomic.LoadInt64(&c.timedOut),
+		WaitTime: time.Duration(atomic.LoadInt64(&c.waitTime)).String(),
+	}
+}
+
+// acquire takes a slot, waiting until one is free, timeout fires or ctx is
+// canceled.
+func (c *concurrencyLimit) acquire(ctx context.Context, timeout <-chan time.Time) error {
+	atomic.AddInt64(&c.requests, 1)
+	select {
+	case c.sem <- struct{}{}:
+		return nil
+	default:
+	}
+	start := time.Now()
+	atomic.AddInt64(&c.waiting, 1)
+	defer func() {
+		atomic.AddInt64(&c.waiting, -1)
+		atomic.AddInt64(&c.waitTime, int64(time.Since(start)))
+	}()
+	select {
+	case c.sem <- struct{}{}:
+		return nil
+	case <-timeout:
+		atomic.AddInt64(&c.timedOut, 1)
+		return ErrTwilioBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *concurrencyLimit) release() {
+	<-c.sem
+}
+
+// A ConcurrencyLimiter limits how many requests to the Twilio API are in
+// progress at once, across every user and background job, so Logrole stays
+// under the concurrency limit of the account. It can also limit each type of
+// resource, like recordings, so slow requests for one type can't use up the
+// limit for the rest. Requests wait in line for a slot, for up to a timeout.
+type ConcurrencyLimiter struct {
+	global *concurrencyLimit
+	// Keyed by resource type, like "messages" or "recordings".
+	limits  map[string]*concurrencyLimit
+	timeout time.Duration
+}
+
+// NewConcurrencyLimiter returns a ConcurrencyLimiter that allows max requests
+// at once, or any number if max is zero, and limits[resource] requests for
+// each resource type in limits. Requests that wait for longer than timeout
+// fail with ErrTwilioBusy; if timeout is zero they wait until their context
+// is canceled.
+func NewConcurrencyLimiter(max int, limits map[string]int, timeout time.Duration) (*ConcurrencyLimiter, error) {
+	if max < 0 || timeout < 0 {
+		return nil, errors.New("The concurrency limit and timeout can't be negative")
+	}
+	c := &ConcurrencyLimiter{
+		limits:  make(map[string]*concurrencyLimit, len(limits)),
+		timeout: timeout,
+	}
+	if max > 0 {
+		c.global = &concurrencyLimit{sem: make(chan struct{}, max)}
+	}
+	for resource, limit := range limits {
+		if limit <= 0 {
+			return nil, fmt.Errorf("The concurrency limit for %s has to be at least 1", resource)
+		}
+		if max > 0 && limit > max {
+			return nil, fmt.Errorf("The concurrency limit for %s is %d, which is more than the overall limit of %d", resource, limit, max)
+		}
+		c.limits[strings.ToLower(resource)] = &concurrencyLimit{sem: make(chan struct{}, limit)}
+	}
+	return c, nil
+}
+
+// acquire takes a slot for the resource type and one from the overall limit,
+// and returns a func to give them back.
+func (c *ConcurrencyLimiter) acquire(ctx context.Context, resource string) (func(), error) {
+	var timeout <-chan time.Time
+	if c.timeout > 0 {
+		timer := time.NewTimer(c.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	// Wait for the resource's slot first, so a request doesn't hold one of the
+	// overall slots while it waits for a busy resource type.
+	limit := c.limits[resource]
+	if limit != nil {
+		if err := limit.acquire(ctx, timeout); err != nil {
+			return nil, err
+		}
+	}
+	if c.global != nil {
+		if err := c.global.acquire(ctx, timeout); err != nil {
+			if limit != nil {
+				limit.release()
+			}
+			return nil, err
+		}
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if c.global != nil {
+				c.global.release()
+			}
+			if limit != nil {
+				limit.release()
+			}
+		})
+	}, nil
+}
+
+// Stats returns the counters for the overall limit, keyed by "total", and for
+// each resource type with a limit.
+func (c *ConcurrencyLimiter) Stats() map[string]ConcurrencyStats {
+	stats := make(map[string]ConcurrencyStats, len(c.limits)+1)
+	if c.global != nil {
+		stats["total"] = c.global.stats()
+	}
+	for resource, limit := range c.limits {
+		stats[resource] = limit.stats()
+	}
+	return stats
+}
+
+var sidRx = regexp.MustCompile(`^[A-Z]{2}[0-9a-f]{32}$`)
+
+// resourceType returns the type of resource a Twilio API path is for - the
+// last part of the path that isn't a sid, without its extension, like
+// "messages" for /2010-04-01/Accounts/AC123/Messages.json or "media" for
+// /2010-04-01/Accounts/AC123/Messages/MM123/Media/ME123.
+func resourceType(p string) string {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		part := strings.TrimSuffix(parts[i], path.Ext(parts[i]))
+		if part != "" && !sidRx.MatchString(part) {
+			return strings.ToLower(part)
+		}
+	}
+	return ""
+}
+
+type concurrencyTransport struct {
+	rt http.RoundTripper
+	c  *ConcurrencyLimiter
+}
+
+// NewConcurrencyTransport returns a RoundTripper that holds a slot from c for
+// each request, from when it's sent until its response body is closed.
+// Requests that can't get a slot in time fail with ErrTwilioBusy. If c is
+// nil, rt is returned.
+func NewConcurrencyTransport(rt http.RoundTripper, c *ConcurrencyLimiter) http.RoundTripper {
+	if c == nil {
+		return rt
+	}
+	return &concurrencyTransport{rt: rt, c: c}
+}
+
+func (t *concurrencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.c.acquire(req.Context(), resourceType(req.URL.Path))
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releaseBody gives back a request's slot when its body is closed.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
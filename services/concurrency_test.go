@@ -0,0 +1,121 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const recordingsURL = "https://api.twilio.com/2010-04-01/Accounts/AC123/Recordings/RE0123456789abcdef0123456789abcdef.mp3"
+const messagesURL = "https://api.twilio.com/2010-04-01/Accounts/AC123/Messages.json"
+
+func roundTrip(t *testing.T, rt http.RoundTripper, u string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt.RoundTrip(req)
+}
+
+func TestConcurrencyTransport(t *testing.T) {
+	t.Parallel()
+	c, err := NewConcurrencyLimiter(1, nil, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := NewConcurrencyTransport(new(countingTransport), c)
+	resp, err := roundTrip(t, rt, messagesURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := roundTrip(t, rt, messagesURL); err != ErrTwilioBusy {
+		t.Errorf("expected ErrTwilioBusy while the first body is open, got %v", err)
+	}
+	resp.Body.Close()
+	resp, err = roundTrip(t, rt, messagesURL)
+	if err != nil {
+		t.Fatalf("expected closing the body to free the slot, got %v", err)
+	}
+	resp.Body.Close()
+	stats := c.Stats()["total"]
+	if stats.Requests != 3 || stats.TimedOut != 1 || stats.InFlight != 0 {
+		t.Errorf("expected 3 requests, 1 timed out and none in flight, got %#v", stats)
+	}
+}
+
+func TestConcurrencyTransportResourceLimit(t *testing.T) {
+	t.Parallel()
+	c, err := NewConcurrencyLimiter(2, map[string]int{"Recordings": 1}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := NewConcurrencyTransport(new(countingTransport), c)
+	resp, err := roundTrip(t, rt, recordingsURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := roundTrip(t, rt, recordingsURL); err != ErrTwilioBusy {
+		t.Errorf("expected a second recording to wait, got %v", err)
+	}
+	resp2, err := roundTrip(t, rt, messagesURL)
+	if err != nil {
+		t.Fatalf("expected other resources to have room, got %v", err)
+	}
+	resp2.Body.Close()
+	if stats := c.Stats(); stats["total"].InFlight != 1 || stats["recordings"].TimedOut != 1 {
+		t.Errorf("expected the recording to hold one slot and the second to time out, got %#v", stats)
+	}
+}
+
+func TestConcurrencyTransportCanceled(t *testing.T) {
+	t.Parallel()
+	c, _ := NewConcurrencyLimiter(1, nil, 0)
+	rt := NewConcurrencyTransport(new(countingTransport), c)
+	resp, err := roundTrip(t, rt, messagesURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", messagesURL, nil)
+	if _, err := rt.RoundTrip(req.WithContext(ctx)); err != context.DeadlineExceeded {
+		t.Errorf("expected the request to wait until its deadline, got %v", err)
+	}
+}
+
+func TestNewConcurrencyLimiterErrors(t *testing.T) {
+	t.Parallel()
+	if _, err := NewConcurrencyLimiter(2, map[string]int{"recordings": 3}, 0); err == nil {
+		t.Error("expected a resource limit over the overall limit to fail")
+	}
+	if _, err := NewConcurrencyLimiter(2, map[string]int{"recordings": 0}, 0); err == nil {
+		t.Error("expected a zero resource limit to fail")
+	}
+	if _, err := NewConcurrencyLimiter(-1, nil, 0); err == nil {
+		t.Error("expected a negative limit to fail")
+	}
+}
+
+var resourceTypeTests = []struct {
+	path string
+	want string
+}{
+	{"/2010-04-01/Accounts/AC123/Messages.json", "messages"},
+	{"/2010-04-01/Accounts/AC123/Messages/MM0123456789abcdef0123456789abcdef/Media/ME0123456789abcdef0123456789abcdef", "media"},
+	{"/2010-04-01/Accounts/AC123/Calls/CA0123456789abcdef0123456789abcdef/Recordings.json", "recordings"},
+	{"/v1/Alerts", "alerts"},
+	{"/", ""},
+}
+
+func TestResourceType(t *testing.T) {
+	t.Parallel()
+	for _, tt := range resourceTypeTests {
+		if got := resourceType(tt.path); got != tt.want {
+			t.Errorf("resourceType(%q): got %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
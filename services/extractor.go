@@ -0,0 +1,57 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// A TextExtractor finds the text in an MMS attachment - with OCR for a photo
+// of a document, for example - so messages can be found by what their
+// attachments say.
+type TextExtractor interface {
+	Extract(ctx context.Context, contentType string, data []byte) (string, error)
+}
+
+type extractResult struct {
+	Text string `json:"text"`
+}
+
+// WebhookExtractor POSTs each attachment to a URL, with the attachment's
+// Content-Type, and reads the text from the "text" field of the JSON
+// response.
+type WebhookExtractor struct {
+	URL string
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (we *WebhookExtractor) Extract(ctx context.Context, contentType string, data []byte) (string, error) {
+	req, err := http.NewRequest("POST", we.URL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	client := we.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("Text extractor returned status %d", resp.StatusCode)
+	}
+	result := new(extractResult)
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return "", fmt.Errorf("Could not parse text extractor response: %v", err)
+	}
+	return result.Text, nil
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestWebhookExtractor(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ctype := r.Header.Get("Content-Type"); ctype != "application/pdf" {
+			t.Errorf("expected Content-Type to be application/pdf, got %q", ctype)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != "pdf data" {
+			t.Errorf("unexpected body %q", body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"text": "Invoice #4471"}`))
+	}))
+	defer s.Close()
+	we := &WebhookExtractor{URL: s.URL}
+	text, err := we.Extract(context.Background(), "application/pdf", []byte("pdf data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Invoice #4471" {
+		t.Errorf("expected the extracted text, got %q", text)
+	}
+}
+
+func TestWebhookExtractorError(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer s.Close()
+	we := &WebhookExtractor{URL: s.URL}
+	if _, err := we.Extract(context.Background(), "image/png", []byte("png data")); err == nil {
+		t.Error("expected error for a 503 response")
+	}
+}
@@ -31,12 +31,20 @@ type GoogleUser struct {
 }
 
 func GetGoogleUserData(ctx context.Context, client *http.Client) (*GoogleUser, error) {
+	return GetUserInfo(ctx, client, UserDataBase+UserDataPath)
+}
+
+// GetUserInfo gets data about the logged in user from the OpenID Connect
+// userinfo endpoint at userInfoURL. client should add the user's access token
+// to requests. An error is returned if the user doesn't have a verified email
+// address.
+func GetUserInfo(ctx context.Context, client *http.Client, userInfoURL string) (*GoogleUser, error) {
 	if client == nil {
 		client = http.DefaultClient
 	}
-	rc := rest.NewClient("", "", UserDataBase)
+	rc := rest.NewClient("", "", userInfoURL)
 	rc.Client = client
-	req, err := rc.NewRequest("GET", UserDataPath, nil)
+	req, err := rc.NewRequest("GET", "", nil)
 	if err != nil {
 		return nil, err
 	}
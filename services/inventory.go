@@ -0,0 +1,178 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/saintpete/logrole/store"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// Keep at most this many inventory changes; older ones are dropped.
+const maxInventoryChanges = 1000
+
+// An InventoryNumber is a phone number in the account when a snapshot was
+// taken.
+type InventoryNumber struct {
+	Sid          string             `json:"sid"`
+	PhoneNumber  twilio.PhoneNumber `json:"phone_number"`
+	FriendlyName string             `json:"friendly_name"`
+	// When the number was purchased, according to Twilio.
+	DateCreated time.Time `json:"date_created"`
+}
+
+// An InventorySnapshot is every phone number in the account at one time.
+type InventorySnapshot struct {
+	Time time.Time `json:"time"`
+	// Sorted by phone number.
+	Numbers []*InventoryNumber `json:"numbers"`
+}
+
+// NewInventorySnapshot creates a snapshot of numbers taken at t.
+func NewInventorySnapshot(t time.Time, numbers []*InventoryNumber) *InventorySnapshot {
+	sorted := make([]*InventoryNumber, len(numbers))
+	copy(sorted, numbers)
+	sort.Sort(inventoryByNumber(sorted))
+	return &InventorySnapshot{Time: t.UTC(), Numbers: sorted}
+}
+
+// An InventoryChange is the numbers purchased and released between two
+// snapshots.
+type InventoryChange struct {
+	// When the earlier and later snapshots were taken.
+	Since time.Time `json:"since"`
+	Time  time.Time `json:"time"`
+
+	Purchased []*InventoryNumber `json:"purchased"`
+	Released  []*InventoryNumber `json:"released"`
+}
+
+// Empty reports whether no numbers were purchased or released.
+func (c *InventoryChange) Empty() bool {
+	return c == nil || (len(c.Purchased) == 0 && len(c.Released) == 0)
+}
+
+// DiffInventory returns the numbers in next that aren't in prev, and the
+// numbers in prev that aren't in next. Numbers are matched by their sid, so a
+// number that's released and bought again shows up as both.
+func DiffInventory(prev, next *InventorySnapshot) *InventoryChange {
+	c := &InventoryChange{
+		Since:     prev.Time,
+		Time:      next.Time,
+		Purchased: make([]*InventoryNumber, 0),
+		Released:  make([]*InventoryNumber, 0),
+	}
+	before := make(map[string]bool, len(prev.Numbers))
+	for _, n := range prev.Numbers {
+		before[n.Sid] = true
+	}
+	after := make(map[string]bool, len(next.Numbers))
+	for _, n := range next.Numbers {
+		after[n.Sid] = true
+		if !before[n.Sid] {
+			c.Purchased = append(c.Purchased, n)
+		}
+	}
+	for _, n := range prev.Numbers {
+		if !after[n.Sid] {
+			c.Released = append(c.Released, n)
+		}
+	}
+	return c
+}
+
+type inventoryByNumber []*InventoryNumber
+
+func (n inventoryByNumber) Len() int           { return len(n) }
+func (n inventoryByNumber) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n inventoryByNumber) Less(i, j int) bool { return n[i].PhoneNumber < n[j].PhoneNumber }
+
+type inventoryFile struct {
+	Latest  *InventorySnapshot `json:"latest"`
+	Changes []*InventoryChange `json:"changes"`
+}
+
+// InventoryStore holds the latest snapshot of the account's phone numbers,
+// and every change between snapshots, oldest first. If it has a path, the
+// store is rewritten to that file after each snapshot, and read on startup.
+type InventoryStore struct {
+	path    string
+	mu      sync.RWMutex
+	latest  *InventorySnapshot
+	changes []*InventoryChange
+}
+
+// NewInventoryStore creates an InventoryStore, loading the snapshot and
+// changes in the file at path. The file doesn't need to exist yet. If path is
+// empty, they're only kept in memory.
+func NewInventoryStore(path string) (*InventoryStore, error) {
+	is := &InventoryStore{path: path}
+	if path == "" {
+		return is, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return is, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := new(inventoryFile)
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("Couldn't read number inventory from %s: %v", path, err)
+	}
+	is.latest = f.Latest
+	is.changes = f.Changes
+	return is, nil
+}
+
+// Latest returns the last snapshot recorded, or nil if there isn't one.
+func (is *InventoryStore) Latest() *InventorySnapshot {
+	if is == nil {
+		return nil
+	}
+	is.mu.RLock()
+	defer is.mu.RUnlock()
+	return is.latest
+}
+
+// Record replaces the latest snapshot with snap, and saves change if any
+// numbers were purchased or released.
+func (is *InventoryStore) Record(snap *InventorySnapshot, change *InventoryChange) error {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	is.latest = snap
+	if !change.Empty() {
+		is.changes = append(is.changes, change)
+		if len(is.changes) > maxInventoryChanges {
+			is.changes = is.changes[len(is.changes)-maxInventoryChanges:]
+		}
+	}
+	if is.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(&inventoryFile{Latest: is.latest, Changes: is.changes})
+	if err != nil {
+		return err
+	}
+	return store.WriteFile(is.path, data)
+}
+
+// Changes returns every change recorded, newest first.
+func (is *InventoryStore) Changes() []*InventoryChange {
+	if is == nil {
+		return nil
+	}
+	is.mu.RLock()
+	changes := make([]*InventoryChange, len(is.changes))
+	for i, c := range is.changes {
+		changes[len(is.changes)-1-i] = c
+	}
+	is.mu.RUnlock()
+	return changes
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiffInventory(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2016, 10, 18, 17, 0, 0, 0, time.UTC)
+	prev := NewInventorySnapshot(now, []*InventoryNumber{
+		{Sid: "PN1", PhoneNumber: "+14105550001"},
+		{Sid: "PN2", PhoneNumber: "+14105550002"},
+	})
+	next := NewInventorySnapshot(now.Add(24*time.Hour), []*InventoryNumber{
+		{Sid: "PN3", PhoneNumber: "+14105550003"},
+		{Sid: "PN1", PhoneNumber: "+14105550001"},
+	})
+	c := DiffInventory(prev, next)
+	if len(c.Purchased) != 1 || c.Purchased[0].Sid != "PN3" {
+		t.Errorf("expected PN3 to be purchased, got %v", c.Purchased)
+	}
+	if len(c.Released) != 1 || c.Released[0].Sid != "PN2" {
+		t.Errorf("expected PN2 to be released, got %v", c.Released)
+	}
+	if !c.Since.Equal(now) || !c.Time.Equal(now.Add(24*time.Hour)) {
+		t.Errorf("expected change between the two snapshots, got %v to %v", c.Since, c.Time)
+	}
+	if c := DiffInventory(prev, prev); !c.Empty() {
+		t.Errorf("expected no change between identical snapshots, got %#v", c)
+	}
+}
+
+func TestInventoryStoreRecord(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-inventory-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "inventory.json")
+	is, err := NewInventoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if is.Latest() != nil {
+		t.Fatalf("expected no snapshot in a new store, got %v", is.Latest())
+	}
+	now := time.Date(2016, 10, 18, 17, 0, 0, 0, time.UTC)
+	first := NewInventorySnapshot(now, []*InventoryNumber{{Sid: "PN1", PhoneNumber: "+14105550001"}})
+	if err := is.Record(first, nil); err != nil {
+		t.Fatal(err)
+	}
+	second := NewInventorySnapshot(now.Add(time.Hour), nil)
+	if err := is.Record(second, DiffInventory(first, second)); err != nil {
+		t.Fatal(err)
+	}
+	third := NewInventorySnapshot(now.Add(2*time.Hour), nil)
+	if err := is.Record(third, DiffInventory(second, third)); err != nil {
+		t.Fatal(err)
+	}
+
+	is2, err := NewInventoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest := is2.Latest(); latest == nil || !latest.Time.Equal(third.Time) {
+		t.Errorf("expected the third snapshot to be loaded, got %v", latest)
+	}
+	changes := is2.Changes()
+	if len(changes) != 1 {
+		t.Fatalf("expected only the release to be recorded, got %d changes", len(changes))
+	}
+	if len(changes[0].Released) != 1 || changes[0].Released[0].Sid != "PN1" {
+		t.Errorf("expected PN1 to be released, got %v", changes[0].Released)
+	}
+}
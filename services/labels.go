@@ -0,0 +1,256 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/saintpete/logrole/store"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// MaxLabelLength is the longest label a LabelStore will accept.
+const MaxLabelLength = 100
+
+// The store.Store bucket labels are kept in, keyed by phone number.
+const labelsBucket = "labels"
+
+// A Label is a name for a phone number, like "Main support line".
+type Label struct {
+	PhoneNumber twilio.PhoneNumber
+	Name        string
+}
+
+type labelsByNumber []*Label
+
+func (l labelsByNumber) Len() int           { return len(l) }
+func (l labelsByNumber) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l labelsByNumber) Less(i, j int) bool { return l[i].PhoneNumber < l[j].PhoneNumber }
+
+// LabelStore holds labels for phone numbers. If it has a path, labels are
+// saved to that file as CSV after every change, and loaded from it on
+// startup. If it has a store.Store, labels are saved there instead.
+type LabelStore struct {
+	path   string
+	db     store.Store
+	mu     sync.RWMutex
+	labels map[twilio.PhoneNumber]string
+}
+
+// NewLabelStore creates a LabelStore, loading any labels in the CSV file at
+// path. The file doesn't need to exist yet. If path is empty, labels are only
+// kept in memory.
+func NewLabelStore(path string) (*LabelStore, error) {
+	ls := &LabelStore{
+		path:   path,
+		labels: make(map[twilio.PhoneNumber]string),
+	}
+	if path == "" {
+		return ls, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return ls, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	labels, err := ReadLabels(f)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read labels from %s: %v", path, err)
+	}
+	for _, label := range labels {
+		ls.labels[label.PhoneNumber] = label.Name
+	}
+	return ls, nil
+}
+
+// NewLabelStoreWith creates a LabelStore that saves labels in s, loading the
+// labels already there.
+func NewLabelStoreWith(s store.Store) (*LabelStore, error) {
+	ls := &LabelStore{
+		db:     s,
+		labels: make(map[twilio.PhoneNumber]string),
+	}
+	all, err := s.All(labelsBucket)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read labels: %v", err)
+	}
+	for pn, name := range all {
+		ls.labels[twilio.PhoneNumber(pn)] = string(name)
+	}
+	return ls, nil
+}
+
+// Get returns the label for pn, or the empty string if it doesn't have one.
+// A nil LabelStore has no labels. WhatsApp addresses have the same label as
+// their number.
+func (ls *LabelStore) Get(pn twilio.PhoneNumber) string {
+	if ls == nil {
+		return ""
+	}
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	return ls.labels[BareNumber(pn)]
+}
+
+func normalizeLabel(pn string, name string) (*Label, error) {
+	num, err := twilio.NewPhoneNumber(strings.TrimSpace(pn))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid phone number %q: %v", pn, err)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("Missing label for %s", num)
+	}
+	if len(name) > MaxLabelLength {
+		return nil, fmt.Errorf("Label for %s is longer than %d characters", num, MaxLabelLength)
+	}
+	return &Label{PhoneNumber: num, Name: name}, nil
+}
+
+// Set labels the phone number pn with name, replacing any existing label.
+func (ls *LabelStore) Set(pn string, name string) (*Label, error) {
+	label, err := normalizeLabel(pn, name)
+	if err != nil {
+		return nil, err
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.labels[label.PhoneNumber] = label.Name
+	return label, ls.save(label.PhoneNumber)
+}
+
+// Delete removes the label for pn, if there is one.
+func (ls *LabelStore) Delete(pn twilio.PhoneNumber) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if _, ok := ls.labels[pn]; !ok {
+		return nil
+	}
+	delete(ls.labels, pn)
+	return ls.save(pn)
+}
+
+// Search returns the labels whose phone number or name contains q, ignoring
+// case, sorted by phone number. If q is empty, every label is returned.
+func (ls *LabelStore) Search(q string) []*Label {
+	q = strings.ToLower(strings.TrimSpace(q))
+	ls.mu.RLock()
+	labels := make([]*Label, 0)
+	for pn, name := range ls.labels {
+		if q == "" || strings.Contains(string(pn), q) || strings.Contains(strings.ToLower(name), q) {
+			labels = append(labels, &Label{PhoneNumber: pn, Name: name})
+		}
+	}
+	ls.mu.RUnlock()
+	sort.Sort(labelsByNumber(labels))
+	return labels
+}
+
+// Import adds the labels in the CSV data in r, replacing the existing labels
+// for any numbers in the file. If any row is invalid, no labels are changed.
+// Import returns the number of labels in the file.
+func (ls *LabelStore) Import(r io.Reader) (int, error) {
+	labels, err := ReadLabels(r)
+	if err != nil {
+		return 0, err
+	}
+	if len(labels) == 0 {
+		return 0, errors.New("No labels found")
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	pns := make([]twilio.PhoneNumber, len(labels))
+	for i, label := range labels {
+		ls.labels[label.PhoneNumber] = label.Name
+		pns[i] = label.PhoneNumber
+	}
+	return len(labels), ls.save(pns...)
+}
+
+// Export writes every label to w as CSV, in the format read by Import.
+func (ls *LabelStore) Export(w io.Writer) error {
+	return WriteLabels(w, ls.Search(""))
+}
+
+// save writes the labels for the given numbers to ls.db, or every label to
+// ls.path. ls.mu must be held.
+func (ls *LabelStore) save(pns ...twilio.PhoneNumber) error {
+	if ls.db != nil {
+		for _, pn := range pns {
+			name, ok := ls.labels[pn]
+			var err error
+			if ok {
+				err = ls.db.Put(labelsBucket, string(pn), []byte(name))
+			} else {
+				err = ls.db.Delete(labelsBucket, string(pn))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if ls.path == "" {
+		return nil
+	}
+	labels := make([]*Label, 0, len(ls.labels))
+	for pn, name := range ls.labels {
+		labels = append(labels, &Label{PhoneNumber: pn, Name: name})
+	}
+	sort.Sort(labelsByNumber(labels))
+	return store.WriteFileFunc(ls.path, func(w io.Writer) error {
+		return WriteLabels(w, labels)
+	})
+}
+
+var labelHeader = []string{"phone_number", "label"}
+
+// ReadLabels parses CSV data with a phone number and a label on each row. A
+// "phone_number,label" header row is optional.
+func ReadLabels(r io.Reader) ([]*Label, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+	labels := make([]*Label, 0)
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && strings.EqualFold(record[0], labelHeader[0]) {
+			continue
+		}
+		label, err := normalizeLabel(record[0], record[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
+// WriteLabels writes labels to w as CSV, with a header row.
+func WriteLabels(w io.Writer, labels []*Label) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(labelHeader); err != nil {
+		return err
+	}
+	for _, label := range labels {
+		if err := cw.Write([]string{string(label.PhoneNumber), label.Name}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLabelStorePersists(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-labels-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "labels.csv")
+	ls, err := NewLabelStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ls.Set("+1 415 555 1234", "Main support line"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ls.Set("+14155556789", "Fraud, test number"); err != nil {
+		t.Fatal(err)
+	}
+	ls2, err := NewLabelStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if label := ls2.Get("+14155551234"); label != "Main support line" {
+		t.Errorf("expected label to be loaded from disk, got %q", label)
+	}
+	if label := ls2.Get("+14155556789"); label != "Fraud, test number" {
+		t.Errorf("expected label with a comma to round trip, got %q", label)
+	}
+	if err := ls2.Delete("+14155551234"); err != nil {
+		t.Fatal(err)
+	}
+	ls3, err := NewLabelStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if label := ls3.Get("+14155551234"); label != "" {
+		t.Errorf("expected deleted label to stay deleted, got %q", label)
+	}
+}
+
+func TestLabelStoreImportExport(t *testing.T) {
+	t.Parallel()
+	ls, _ := NewLabelStore("")
+	ls.Set("+14155551234", "Old name")
+	n, err := ls.Import(strings.NewReader("phone_number,label\n+14155551234,Main support line\n+14155556789,Fraud test number\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected to import 2 labels, got %d", n)
+	}
+	if label := ls.Get("+14155551234"); label != "Main support line" {
+		t.Errorf("expected import to replace label, got %q", label)
+	}
+	if _, err := ls.Import(strings.NewReader("+14155550000,Good\nnot a number,Bad\n")); err == nil {
+		t.Error("expected import with an invalid number to fail")
+	}
+	if label := ls.Get("+14155550000"); label != "" {
+		t.Errorf("expected failed import not to change labels, got %q", label)
+	}
+	buf := new(bytes.Buffer)
+	if err := ls.Export(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "phone_number,label\n+14155551234,Main support line\n+14155556789,Fraud test number\n"
+	if buf.String() != want {
+		t.Errorf("unexpected export:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestLabelStoreSearch(t *testing.T) {
+	t.Parallel()
+	ls, _ := NewLabelStore("")
+	ls.Set("+14155551234", "Main support line")
+	ls.Set("+14155556789", "Fraud test number")
+	if labels := ls.Search("SUPPORT"); len(labels) != 1 || labels[0].PhoneNumber != "+14155551234" {
+		t.Errorf("expected case-insensitive match on the label, got %v", labels)
+	}
+	if labels := ls.Search("6789"); len(labels) != 1 || labels[0].Name != "Fraud test number" {
+		t.Errorf("expected match on the number, got %v", labels)
+	}
+	if labels := ls.Search(""); len(labels) != 2 {
+		t.Errorf("expected empty query to return every label, got %d", len(labels))
+	}
+}
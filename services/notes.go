@@ -0,0 +1,235 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saintpete/logrole/store"
+)
+
+// MaxNoteLength is the longest note a NoteStore will accept, in bytes.
+const MaxNoteLength = 1000
+
+// The store.Store bucket notes are kept in, keyed by sid.
+const notesBucket = "notes"
+
+// A Note is a short internal note someone attached to a message, call or
+// alert, like "Customer says this never arrived, escalated to carrier".
+type Note struct {
+	Text      string    `json:"text"`
+	User      string    `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// A NoteMatch is a note that matched a search, and the sid of the resource
+// it's attached to.
+type NoteMatch struct {
+	Sid string
+	*Note
+}
+
+type noteMatchesByCreated []*NoteMatch
+
+func (n noteMatchesByCreated) Len() int      { return len(n) }
+func (n noteMatchesByCreated) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+func (n noteMatchesByCreated) Less(i, j int) bool {
+	if !n[i].CreatedAt.Equal(n[j].CreatedAt) {
+		return n[i].CreatedAt.After(n[j].CreatedAt)
+	}
+	return n[i].Sid < n[j].Sid
+}
+
+// NoteStore holds notes for resources, keyed by sid. Notes never leave
+// Logrole. If the store has a path, the notes are saved to that file as JSON
+// after every change, and loaded from it on startup. If it has a store.Store,
+// each resource's notes are saved there instead.
+type NoteStore struct {
+	path  string
+	db    store.Store
+	mu    sync.RWMutex
+	notes map[string][]*Note
+}
+
+// NewNoteStore creates a NoteStore, loading any notes in the file at path.
+// The file doesn't need to exist yet. If path is empty, notes are only kept
+// in memory.
+func NewNoteStore(path string) (*NoteStore, error) {
+	ns := &NoteStore{
+		path:  path,
+		notes: make(map[string][]*Note),
+	}
+	if path == "" {
+		return ns, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ns, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &ns.notes); err != nil {
+		return nil, fmt.Errorf("Couldn't read notes from %s: %v", path, err)
+	}
+	return ns, nil
+}
+
+// NewNoteStoreWith creates a NoteStore that saves notes in s, loading the
+// notes already there.
+func NewNoteStoreWith(s store.Store) (*NoteStore, error) {
+	ns := &NoteStore{
+		db:    s,
+		notes: make(map[string][]*Note),
+	}
+	all, err := s.All(notesBucket)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read notes: %v", err)
+	}
+	for sid, data := range all {
+		var notes []*Note
+		if err := json.Unmarshal(data, &notes); err != nil {
+			return nil, fmt.Errorf("Couldn't read notes for %s: %v", sid, err)
+		}
+		ns.notes[sid] = notes
+	}
+	return ns, nil
+}
+
+// Get returns the notes for sid, oldest first. A nil NoteStore has no notes.
+func (ns *NoteStore) Get(sid string) []*Note {
+	if ns == nil {
+		return nil
+	}
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+	notes := make([]*Note, len(ns.notes[sid]))
+	copy(notes, ns.notes[sid])
+	return notes
+}
+
+// Add attaches a note with the given text, written by user, to sid.
+func (ns *NoteStore) Add(sid string, text string, user string) (*Note, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, errors.New("Enter a note")
+	}
+	if len(text) > MaxNoteLength {
+		return nil, fmt.Errorf("Note is longer than %d characters", MaxNoteLength)
+	}
+	n := &Note{Text: text, User: user, CreatedAt: time.Now().UTC()}
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	ns.notes[sid] = append(ns.notes[sid], n)
+	if err := ns.save(sid); err != nil {
+		ns.notes[sid] = ns.notes[sid][:len(ns.notes[sid])-1]
+		if len(ns.notes[sid]) == 0 {
+			delete(ns.notes, sid)
+		}
+		return nil, err
+	}
+	return n, nil
+}
+
+// KeepNotes copies the notes in old to ns, if neither of them saves notes to a
+// file or a store, so reloading the config doesn't lose them.
+func (ns *NoteStore) KeepNotes(old *NoteStore) {
+	if ns == nil || old == nil || ns == old {
+		return
+	}
+	if ns.path != "" || ns.db != nil || old.path != "" || old.db != nil {
+		return
+	}
+	old.mu.RLock()
+	notes := make(map[string][]*Note, len(old.notes))
+	for sid, list := range old.notes {
+		notes[sid] = append([]*Note{}, list...)
+	}
+	old.mu.RUnlock()
+	ns.mu.Lock()
+	ns.notes = notes
+	ns.mu.Unlock()
+}
+
+// Search returns up to max notes that contain q, ignoring case, newest
+// first. A nil NoteStore has no notes.
+func (ns *NoteStore) Search(q string, max int) []*NoteMatch {
+	q = strings.ToLower(strings.TrimSpace(q))
+	if ns == nil || q == "" {
+		return nil
+	}
+	ns.mu.RLock()
+	matches := make([]*NoteMatch, 0)
+	for sid, notes := range ns.notes {
+		for _, n := range notes {
+			if strings.Contains(strings.ToLower(n.Text), q) {
+				matches = append(matches, &NoteMatch{Sid: sid, Note: n})
+			}
+		}
+	}
+	ns.mu.RUnlock()
+	sort.Sort(noteMatchesByCreated(matches))
+	if len(matches) > max {
+		matches = matches[:max]
+	}
+	return matches
+}
+
+// PurgeBefore removes the notes created before cutoff, and returns how many
+// it removed. If dryRun is true, it only counts them.
+func (ns *NoteStore) PurgeBefore(cutoff time.Time, dryRun bool) (int, error) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	purged := 0
+	kept := make(map[string][]*Note, len(ns.notes))
+	changed := make([]string, 0)
+	for sid, notes := range ns.notes {
+		for _, n := range notes {
+			if n.CreatedAt.Before(cutoff) {
+				purged++
+				continue
+			}
+			kept[sid] = append(kept[sid], n)
+		}
+		if len(kept[sid]) < len(notes) {
+			changed = append(changed, sid)
+		}
+	}
+	if dryRun || purged == 0 {
+		return purged, nil
+	}
+	old := ns.notes
+	ns.notes = kept
+	if err := ns.save(changed...); err != nil {
+		ns.notes = old
+		return 0, err
+	}
+	return purged, nil
+}
+
+// save writes the notes for the given sids to ns.db, or every note to
+// ns.path. ns.mu must be held.
+func (ns *NoteStore) save(sids ...string) error {
+	if ns.db != nil {
+		for _, sid := range sids {
+			if err := putJSON(ns.db, notesBucket, sid, ns.notes[sid]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if ns.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(ns.notes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFile(ns.path, data)
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNoteStore(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-notes-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "notes.json")
+	ns, err := NewNoteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ns.Add("SM123", "  ", "test@example.com"); err == nil {
+		t.Error("expected an empty note to fail")
+	}
+	if _, err := ns.Add("SM123", strings.Repeat("a", MaxNoteLength+1), "test@example.com"); err == nil {
+		t.Error("expected a long note to fail")
+	}
+	if _, err := ns.Add("SM123", "Escalated to the carrier", "test@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ns.Add("CA123", "Customer says the call dropped, carrier ticket open", "other@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	ns2, err := NewNoteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := ns2.Get("SM123")
+	if len(notes) != 1 || notes[0].Text != "Escalated to the carrier" || notes[0].User != "test@example.com" {
+		t.Errorf("expected the note to be loaded from the file, got %#v", notes)
+	}
+	matches := ns2.Search("CARRIER", 10)
+	if len(matches) != 2 || matches[0].Sid != "CA123" || matches[1].Sid != "SM123" {
+		t.Errorf("expected both notes, newest first, got %#v", matches)
+	}
+	if matches := ns2.Search("carrier", 1); len(matches) != 1 {
+		t.Errorf("expected one note, got %d", len(matches))
+	}
+
+	n, err := ns2.PurgeBefore(time.Now().Add(time.Hour), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(ns2.Get("SM123")) != 0 {
+		t.Errorf("expected both notes to be purged, purged %d", n)
+	}
+}
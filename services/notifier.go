@@ -0,0 +1,68 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// A Notifier tells the people running Logrole that something needs their
+// attention.
+type Notifier interface {
+	Notify(ctx context.Context, subject string, body string) error
+}
+
+// NoopNotifier drops all notifications.
+type NoopNotifier struct{}
+
+func (n *NoopNotifier) Notify(ctx context.Context, subject string, body string) error {
+	return nil
+}
+
+// WebhookNotifier POSTs notifications as JSON to a URL. The payload has a
+// "text" field, so a Slack or Mattermost incoming webhook URL can be used
+// directly.
+type WebhookNotifier struct {
+	URL string
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+type webhookPayload struct {
+	Text    string `json:"text"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+func (wn *WebhookNotifier) Notify(ctx context.Context, subject string, body string) error {
+	data, err := json.Marshal(&webhookPayload{
+		Text:    subject + "\n" + body,
+		Subject: subject,
+		Body:    body,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", wn.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	client := wn.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Notification webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	t.Parallel()
+	var got webhookPayload
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer s.Close()
+	n := &WebhookNotifier{URL: s.URL}
+	if err := n.Notify(context.Background(), "Subject", "Body"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Text != "Subject\nBody" {
+		t.Errorf("unexpected text %q", got.Text)
+	}
+}
+
+func TestWebhookNotifierError(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer s.Close()
+	n := &WebhookNotifier{URL: s.URL}
+	if err := n.Notify(context.Background(), "Subject", "Body"); err == nil {
+		t.Error("expected error for a 500 response")
+	}
+}
@@ -0,0 +1,315 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/saintpete/logrole/store"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// MaxTeamLength is the longest team name an OwnerStore will accept. Rotations
+// can be as long as a label.
+const MaxTeamLength = 50
+
+// The store.Store bucket owners are kept in, keyed by phone number.
+const ownersBucket = "owners"
+
+// An Owner is the team responsible for a phone number, and the on-call
+// rotation to page when something goes wrong with it.
+type Owner struct {
+	PhoneNumber twilio.PhoneNumber
+	Team        string
+	// The rotation to page, like "support-primary" or a link to a PagerDuty
+	// schedule. May be empty.
+	Rotation string
+}
+
+// RotationURL returns the rotation if it's a link, or the empty string.
+func (o *Owner) RotationURL() string {
+	u, err := url.Parse(o.Rotation)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return o.Rotation
+}
+
+type ownersByNumber []*Owner
+
+func (o ownersByNumber) Len() int           { return len(o) }
+func (o ownersByNumber) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o ownersByNumber) Less(i, j int) bool { return o[i].PhoneNumber < o[j].PhoneNumber }
+
+// OwnerStore holds the owning team for phone numbers. Like a LabelStore, it's
+// saved to a CSV file after every change if it has a path, or to a
+// store.Store if it has one.
+type OwnerStore struct {
+	path   string
+	db     store.Store
+	mu     sync.RWMutex
+	owners map[twilio.PhoneNumber]*Owner
+}
+
+// NewOwnerStore creates an OwnerStore, loading any owners in the CSV file at
+// path. The file doesn't need to exist yet. If path is empty, owners are only
+// kept in memory.
+func NewOwnerStore(path string) (*OwnerStore, error) {
+	s := &OwnerStore{
+		path:   path,
+		owners: make(map[twilio.PhoneNumber]*Owner),
+	}
+	if path == "" {
+		return s, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	owners, err := ReadOwners(f)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read owners from %s: %v", path, err)
+	}
+	for _, owner := range owners {
+		s.owners[owner.PhoneNumber] = owner
+	}
+	return s, nil
+}
+
+// NewOwnerStoreWith creates an OwnerStore that saves owners in db, loading
+// the owners already there.
+func NewOwnerStoreWith(db store.Store) (*OwnerStore, error) {
+	s := &OwnerStore{
+		db:     db,
+		owners: make(map[twilio.PhoneNumber]*Owner),
+	}
+	all, err := db.All(ownersBucket)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read owners: %v", err)
+	}
+	for pn, data := range all {
+		owner := new(Owner)
+		if err := json.Unmarshal(data, owner); err != nil {
+			return nil, fmt.Errorf("Couldn't read owner of %s: %v", pn, err)
+		}
+		s.owners[owner.PhoneNumber] = owner
+	}
+	return s, nil
+}
+
+// Get returns the owner of pn, or nil if it doesn't have one. A nil
+// OwnerStore has no owners.
+func (s *OwnerStore) Get(pn twilio.PhoneNumber) *Owner {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.owners[BareNumber(pn)]
+}
+
+// OwnedBy reports whether team owns pn, ignoring case.
+func (s *OwnerStore) OwnedBy(pn twilio.PhoneNumber, team string) bool {
+	o := s.Get(pn)
+	return o != nil && strings.EqualFold(o.Team, team)
+}
+
+// Teams returns the name of every team that owns a number, sorted.
+func (s *OwnerStore) Teams() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	seen := make(map[string]bool)
+	teams := make([]string, 0)
+	for _, o := range s.owners {
+		if !seen[o.Team] {
+			seen[o.Team] = true
+			teams = append(teams, o.Team)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Strings(teams)
+	return teams
+}
+
+func normalizeOwner(pn, team, rotation string) (*Owner, error) {
+	num, err := twilio.NewPhoneNumber(strings.TrimSpace(pn))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid phone number %q: %v", pn, err)
+	}
+	team = strings.TrimSpace(team)
+	if team == "" {
+		return nil, fmt.Errorf("Missing team for %s", num)
+	}
+	if len(team) > MaxTeamLength {
+		return nil, fmt.Errorf("Team for %s is longer than %d characters", num, MaxTeamLength)
+	}
+	rotation = strings.TrimSpace(rotation)
+	if len(rotation) > MaxLabelLength {
+		return nil, fmt.Errorf("Rotation for %s is longer than %d characters", num, MaxLabelLength)
+	}
+	return &Owner{PhoneNumber: num, Team: team, Rotation: rotation}, nil
+}
+
+// Set makes team the owner of pn, paged through rotation, replacing any
+// existing owner.
+func (s *OwnerStore) Set(pn, team, rotation string) (*Owner, error) {
+	owner, err := normalizeOwner(pn, team, rotation)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.owners[owner.PhoneNumber] = owner
+	return owner, s.save(owner.PhoneNumber)
+}
+
+// Delete removes the owner of pn, if there is one.
+func (s *OwnerStore) Delete(pn twilio.PhoneNumber) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.owners[pn]; !ok {
+		return nil
+	}
+	delete(s.owners, pn)
+	return s.save(pn)
+}
+
+// Search returns the owners whose phone number, team or rotation contains q,
+// ignoring case, sorted by phone number. If q is empty, every owner is
+// returned.
+func (s *OwnerStore) Search(q string) []*Owner {
+	q = strings.ToLower(strings.TrimSpace(q))
+	s.mu.RLock()
+	owners := make([]*Owner, 0)
+	for pn, o := range s.owners {
+		if q == "" || strings.Contains(string(pn), q) ||
+			strings.Contains(strings.ToLower(o.Team), q) ||
+			strings.Contains(strings.ToLower(o.Rotation), q) {
+			owners = append(owners, o)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Sort(ownersByNumber(owners))
+	return owners
+}
+
+// Import adds the owners in the CSV data in r, replacing the existing owners
+// of any numbers in the file. If any row is invalid, no owners are changed.
+// Import returns the number of owners in the file.
+func (s *OwnerStore) Import(r io.Reader) (int, error) {
+	owners, err := ReadOwners(r)
+	if err != nil {
+		return 0, err
+	}
+	if len(owners) == 0 {
+		return 0, errors.New("No owners found")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pns := make([]twilio.PhoneNumber, len(owners))
+	for i, owner := range owners {
+		s.owners[owner.PhoneNumber] = owner
+		pns[i] = owner.PhoneNumber
+	}
+	return len(owners), s.save(pns...)
+}
+
+// Export writes every owner to w as CSV, in the format read by Import.
+func (s *OwnerStore) Export(w io.Writer) error {
+	return WriteOwners(w, s.Search(""))
+}
+
+// save writes the owners of the given numbers to s.db, or every owner to
+// s.path. s.mu must be held.
+func (s *OwnerStore) save(pns ...twilio.PhoneNumber) error {
+	if s.db != nil {
+		for _, pn := range pns {
+			var err error
+			if owner, ok := s.owners[pn]; ok {
+				err = putJSON(s.db, ownersBucket, string(pn), owner)
+			} else {
+				err = s.db.Delete(ownersBucket, string(pn))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if s.path == "" {
+		return nil
+	}
+	owners := make([]*Owner, 0, len(s.owners))
+	for _, o := range s.owners {
+		owners = append(owners, o)
+	}
+	sort.Sort(ownersByNumber(owners))
+	return store.WriteFileFunc(s.path, func(w io.Writer) error {
+		return WriteOwners(w, owners)
+	})
+}
+
+var ownerHeader = []string{"phone_number", "team", "rotation"}
+
+// ReadOwners parses CSV data with a phone number, a team and optionally a
+// rotation on each row. A "phone_number,team,rotation" header row is
+// optional.
+func ReadOwners(r io.Reader) ([]*Owner, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	owners := make([]*Owner, 0)
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) != 2 && len(record) != 3 {
+			return nil, fmt.Errorf("line %d: expected 2 or 3 fields, got %d", line, len(record))
+		}
+		if line == 1 && strings.EqualFold(record[0], ownerHeader[0]) {
+			continue
+		}
+		rotation := ""
+		if len(record) == 3 {
+			rotation = record[2]
+		}
+		owner, err := normalizeOwner(record[0], record[1], rotation)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		owners = append(owners, owner)
+	}
+	return owners, nil
+}
+
+// WriteOwners writes owners to w as CSV, with a header row.
+func WriteOwners(w io.Writer, owners []*Owner) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(ownerHeader); err != nil {
+		return err
+	}
+	for _, o := range owners {
+		if err := cw.Write([]string{string(o.PhoneNumber), o.Team, o.Rotation}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOwnerStorePersists(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-owners-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "owners.csv")
+	s, err := NewOwnerStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Set("+1 415 555 1234", "Support", "https://example.pagerduty.com/schedules/P123"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Set("+14155556789", "Billing", ""); err != nil {
+		t.Fatal(err)
+	}
+	s2, err := NewOwnerStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := s2.Get("+14155551234")
+	if o == nil || o.Team != "Support" || o.RotationURL() != "https://example.pagerduty.com/schedules/P123" {
+		t.Errorf("expected owner to be loaded from disk, got %#v", o)
+	}
+	if o := s2.Get("+14155556789"); o == nil || o.Rotation != "" || o.RotationURL() != "" {
+		t.Errorf("expected owner without a rotation to round trip, got %#v", o)
+	}
+	if !s2.OwnedBy("+14155556789", "billing") {
+		t.Error("expected OwnedBy to ignore case")
+	}
+	if teams := s2.Teams(); !reflect.DeepEqual(teams, []string{"Billing", "Support"}) {
+		t.Errorf("expected sorted teams, got %v", teams)
+	}
+	if err := s2.Delete("+14155551234"); err != nil {
+		t.Fatal(err)
+	}
+	s3, err := NewOwnerStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := s3.Get("+14155551234"); o != nil {
+		t.Errorf("expected deleted owner to stay deleted, got %#v", o)
+	}
+}
+
+func TestOwnerStoreImportExport(t *testing.T) {
+	t.Parallel()
+	s, _ := NewOwnerStore("")
+	s.Set("+14155551234", "Old team", "")
+	n, err := s.Import(strings.NewReader("phone_number,team,rotation\n+14155551234,Support,support-primary\n+14155556789,Billing\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected to import 2 owners, got %d", n)
+	}
+	if o := s.Get("+14155551234"); o == nil || o.Team != "Support" {
+		t.Errorf("expected import to replace owner, got %#v", o)
+	}
+	if _, err := s.Import(strings.NewReader("+14155550000,Good\n+14155550001,\n")); err == nil {
+		t.Error("expected import with a missing team to fail")
+	}
+	if o := s.Get("+14155550000"); o != nil {
+		t.Errorf("expected failed import not to change owners, got %#v", o)
+	}
+	buf := new(bytes.Buffer)
+	if err := s.Export(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "phone_number,team,rotation\n+14155551234,Support,support-primary\n+14155556789,Billing,\n"
+	if buf.String() != want {
+		t.Errorf("unexpected export:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"strings"
+
+	twilio "github.com/saintpete/twilio-go"
+	"github.com/ttacon/libphonenumber"
+)
+
+// Channels a message can be sent over. WhatsApp addresses have a
+// "whatsapp:" prefix, like "whatsapp:+14155551234".
+const (
+	ChannelSMS      = "SMS"
+	ChannelWhatsApp = "WhatsApp"
+)
+
+const whatsAppPrefix = "whatsapp:"
+
+// SplitChannel returns the channel of a message address and the phone number
+// without its channel prefix, so "whatsapp:+14155551234" returns
+// ChannelWhatsApp and "+14155551234". Addresses without a prefix are SMS.
+func SplitChannel(addr string) (channel string, number string) {
+	if len(addr) > len(whatsAppPrefix) && strings.EqualFold(addr[:len(whatsAppPrefix)], whatsAppPrefix) {
+		return ChannelWhatsApp, addr[len(whatsAppPrefix):]
+	}
+	return ChannelSMS, addr
+}
+
+// BareNumber returns pn without a channel prefix.
+func BareNumber(pn twilio.PhoneNumber) twilio.PhoneNumber {
+	_, number := SplitChannel(string(pn))
+	return twilio.PhoneNumber(number)
+}
+
+// CountryCode returns the two letter ISO 3166-1 region code for the given
+// E.164 phone number, like "US" or "GB", or the empty string if the number
+// can't be parsed. Client identifiers ("client:alice") and short codes don't
+// have a country.
+func CountryCode(pn string) string {
+	_, pn = SplitChannel(pn)
+	num, err := libphonenumber.Parse(pn, "")
+	if err != nil {
+		return ""
+	}
+	return libphonenumber.GetRegionCodeForNumber(num)
+}
+
+// ValidCountryCode returns true if code is a region code that phone numbers
+// can belong to. The input is case-sensitive.
+func ValidCountryCode(code string) bool {
+	return libphonenumber.GetSupportedRegions()[code]
+}
+
+// CountryFlag returns the flag emoji for a two letter region code, or the
+// empty string if code is not two uppercase letters.
+func CountryFlag(code string) string {
+	if len(code) != 2 {
+		return ""
+	}
+	flag := make([]rune, 0, 2)
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return ""
+		}
+		// Regional indicator symbols start at U+1F1E6, for "A".
+		flag = append(flag, 0x1F1E6+(c-'A'))
+	}
+	return string(flag)
+}
+
+// FormatPhoneNumber formats pn the way it's written in its own country, for
+// example "(415) 555-1234" or "020 7123 4567". A channel prefix, like
+// "whatsapp:", is dropped. If pn can't be parsed, it's returned unchanged.
+func FormatPhoneNumber(pn string) string {
+	_, bare := SplitChannel(pn)
+	num, err := libphonenumber.Parse(bare, "")
+	if err != nil {
+		return pn
+	}
+	return strings.TrimSpace(libphonenumber.Format(num, libphonenumber.NATIONAL))
+}
+
+// MaskPhoneNumber replaces every digit in pn except the last four with "*",
+// so "+14155551234" becomes "+*******1234". Values that aren't phone
+// numbers, like client identifiers, are masked completely. A channel prefix,
+// like "whatsapp:", is kept.
+func MaskPhoneNumber(pn string) string {
+	if channel, bare := SplitChannel(pn); channel != ChannelSMS {
+		return pn[:len(pn)-len(bare)] + MaskPhoneNumber(bare)
+	}
+	if !strings.HasPrefix(pn, "+") {
+		return strings.Repeat("*", len(pn))
+	}
+	b := []byte(pn)
+	keep := 4
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < '0' || b[i] > '9' {
+			continue
+		}
+		if keep > 0 {
+			keep--
+			continue
+		}
+		b[i] = '*'
+	}
+	return string(b)
+}
@@ -0,0 +1,90 @@
+package services
+
+import "testing"
+
+var countryTests = []struct {
+	in  string
+	out string
+}{
+	{"+14105551234", "US"},
+	{"+442071234567", "GB"},
+	{"+61291234567", "AU"},
+	{"client:alice", ""},
+	{"", ""},
+}
+
+func TestCountryCode(t *testing.T) {
+	t.Parallel()
+	for _, tt := range countryTests {
+		if out := CountryCode(tt.in); out != tt.out {
+			t.Errorf("CountryCode(%q): got %q, want %q", tt.in, out, tt.out)
+		}
+	}
+}
+
+func TestCountryFlag(t *testing.T) {
+	t.Parallel()
+	if f := CountryFlag("US"); f != "\U0001F1FA\U0001F1F8" {
+		t.Errorf("wrong flag for US: %q", f)
+	}
+	for _, code := range []string{"", "us", "USA", "1A"} {
+		if f := CountryFlag(code); f != "" {
+			t.Errorf("expected no flag for %q, got %q", code, f)
+		}
+	}
+}
+
+func TestFormatPhoneNumberUnparseable(t *testing.T) {
+	t.Parallel()
+	if out := FormatPhoneNumber("client:alice"); out != "client:alice" {
+		t.Errorf("expected client identifier to be unchanged, got %q", out)
+	}
+}
+
+var maskTests = []struct {
+	in  string
+	out string
+}{
+	{"+14155551234", "+*******1234"},
+	{"+123", "+123"},
+	{"client:alice", "************"},
+	{"", ""},
+}
+
+func TestMaskPhoneNumber(t *testing.T) {
+	t.Parallel()
+	for _, tt := range maskTests {
+		if out := MaskPhoneNumber(tt.in); out != tt.out {
+			t.Errorf("MaskPhoneNumber(%q): got %q, want %q", tt.in, out, tt.out)
+		}
+	}
+}
+
+var channelTests = []struct {
+	in      string
+	channel string
+	number  string
+}{
+	{"+14155551234", ChannelSMS, "+14155551234"},
+	{"whatsapp:+14155551234", ChannelWhatsApp, "+14155551234"},
+	{"WhatsApp:+14155551234", ChannelWhatsApp, "+14155551234"},
+	{"whatsapp:", ChannelSMS, "whatsapp:"},
+	{"client:alice", ChannelSMS, "client:alice"},
+}
+
+func TestSplitChannel(t *testing.T) {
+	t.Parallel()
+	for _, tt := range channelTests {
+		channel, number := SplitChannel(tt.in)
+		if channel != tt.channel || number != tt.number {
+			t.Errorf("SplitChannel(%q): got (%q, %q), want (%q, %q)", tt.in, channel, number, tt.channel, tt.number)
+		}
+	}
+}
+
+func TestMaskWhatsAppNumber(t *testing.T) {
+	t.Parallel()
+	if out := MaskPhoneNumber("whatsapp:+14155551234"); out != "whatsapp:+*******1234" {
+		t.Errorf("expected prefix to be kept, got %q", out)
+	}
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/saintpete/logrole/store"
+)
+
+// The QueueResult values Twilio sends to an <Enqueue> action URL.
+const (
+	// The caller was connected to someone who dequeued them.
+	QueueResultBridged = "bridged"
+	// The caller hung up while they were waiting.
+	QueueResultHangup = "hangup"
+	// The caller was sent elsewhere with <Leave>.
+	QueueResultLeave = "leave"
+	// The call was redirected with the REST API while it was waiting.
+	QueueResultRedirected = "redirected"
+	// The queue was full, so the caller never joined it.
+	QueueResultQueueFull = "queue-full"
+	QueueResultError     = "error"
+	QueueResultSystem    = "system-error"
+)
+
+// QueueEventRetention is how long a QueueStore keeps events.
+const QueueEventRetention = 90 * 24 * time.Hour
+
+// MaxQueueEvents is the most events a QueueStore keeps in memory. The oldest
+// are dropped first.
+const MaxQueueEvents = 200000
+
+// A QueueEvent records a caller leaving a queue, as reported to the <Enqueue>
+// action URL.
+type QueueEvent struct {
+	// When the caller left the queue.
+	Time     time.Time     `json:"time"`
+	CallSid  string        `json:"call_sid"`
+	QueueSid string        `json:"queue_sid"`
+	Result   string        `json:"result"`
+	Wait     time.Duration `json:"wait"`
+}
+
+// NewQueueEvent parses the parameters Twilio sends to an <Enqueue> action
+// URL.
+func NewQueueEvent(form url.Values, now time.Time) (*QueueEvent, error) {
+	e := &QueueEvent{
+		Time:     now.UTC(),
+		CallSid:  form.Get("CallSid"),
+		QueueSid: form.Get("QueueSid"),
+		Result:   form.Get("QueueResult"),
+	}
+	if e.CallSid == "" || e.Result == "" {
+		return nil, errors.New("Missing CallSid or QueueResult")
+	}
+	if qt := form.Get("QueueTime"); qt != "" {
+		secs, err := strconv.Atoi(qt)
+		if err != nil || secs < 0 {
+			return nil, fmt.Errorf("Invalid QueueTime %q", qt)
+		}
+		e.Wait = time.Duration(secs) * time.Second
+	}
+	return e, nil
+}
+
+// QueueStore holds recent QueueEvents, oldest first. If it has a path, each
+// event is appended to that file as a line of JSON, and the file is read on
+// startup.
+type QueueStore struct {
+	path   string
+	mu     sync.RWMutex
+	events []*QueueEvent
+}
+
+// NewQueueStore creates a QueueStore, loading the events in the file at path
+// that are newer than QueueEventRetention. The file doesn't need to exist
+// yet. If path is empty, events are only kept in memory.
+func NewQueueStore(path string) (*QueueStore, error) {
+	qs := &QueueStore{path: path}
+	if path == "" {
+		return qs, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return qs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cutoff := time.Now().Add(-QueueEventRetention)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		e := new(QueueEvent)
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("Couldn't read queue events from %s, line %d: %v", path, line, err)
+		}
+		if e.Time.After(cutoff) {
+			qs.events = append(qs.events, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	qs.trim(time.Now())
+	return qs, nil
+}
+
+// trim drops events that are too old, or too many. qs.mu must be held.
+func (qs *QueueStore) trim(now time.Time) {
+	cutoff := now.Add(-QueueEventRetention)
+	i := 0
+	for i < len(qs.events) && (qs.events[i].Time.Before(cutoff) || len(qs.events)-i > MaxQueueEvents) {
+		i++
+	}
+	if i > 0 {
+		qs.events = append([]*QueueEvent(nil), qs.events[i:]...)
+	}
+}
+
+// Add records e. Events should be added in the order they happened.
+func (qs *QueueStore) Add(e *QueueEvent) error {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	qs.events = append(qs.events, e)
+	qs.trim(time.Now())
+	if qs.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(qs.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// PurgeBefore removes the events that happened before cutoff, rewriting the
+// file if there is one, and returns how many it removed. If dryRun is true, it
+// only counts them.
+func (qs *QueueStore) PurgeBefore(cutoff time.Time, dryRun bool) (int, error) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	purged := 0
+	for purged < len(qs.events) && qs.events[purged].Time.Before(cutoff) {
+		purged++
+	}
+	if dryRun || purged == 0 {
+		return purged, nil
+	}
+	events := append([]*QueueEvent(nil), qs.events[purged:]...)
+	if qs.path != "" {
+		buf := new(bytes.Buffer)
+		enc := json.NewEncoder(buf)
+		for _, e := range events {
+			if err := enc.Encode(e); err != nil {
+				return 0, err
+			}
+		}
+		if err := store.WriteFile(qs.path, buf.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	qs.events = events
+	return purged, nil
+}
+
+// Between returns the events from start up to, but not including, end,
+// oldest first.
+func (qs *QueueStore) Between(start, end time.Time) []*QueueEvent {
+	if qs == nil {
+		return nil
+	}
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
+	var events []*QueueEvent
+	for _, e := range qs.events {
+		if !e.Time.Before(start) && e.Time.Before(end) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Len returns the number of events in the store.
+func (qs *QueueStore) Len() int {
+	if qs == nil {
+		return 0
+	}
+	qs.mu.RLock()
+	defer qs.mu.RUnlock()
+	return len(qs.events)
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewQueueEvent(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2016, 11, 1, 17, 3, 12, 0, time.UTC)
+	form := url.Values{"CallSid": {"CA123"}, "QueueSid": {"QU123"}, "QueueResult": {"hangup"}, "QueueTime": {"95"}}
+	e, err := NewQueueEvent(form, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Result != QueueResultHangup || e.Wait != 95*time.Second || e.QueueSid != "QU123" || !e.Time.Equal(now) {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	for _, bad := range []url.Values{
+		{"QueueResult": {"bridged"}},
+		{"CallSid": {"CA123"}, "QueueResult": {"bridged"}, "QueueTime": {"-1"}},
+	} {
+		if _, err := NewQueueEvent(bad, now); err == nil {
+			t.Errorf("expected an error parsing %v", bad)
+		}
+	}
+}
+
+func TestQueueStoreFile(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-queues")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queue-events.json")
+	qs, err := NewQueueStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	old := &QueueEvent{Time: now.Add(-QueueEventRetention - time.Hour), CallSid: "CA1", Result: QueueResultBridged}
+	recent := &QueueEvent{Time: now.Add(-time.Hour), CallSid: "CA2", Result: QueueResultHangup, Wait: time.Minute}
+	for _, e := range []*QueueEvent{old, recent} {
+		if err := qs.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if qs.Len() != 1 {
+		t.Errorf("expected the old event to be dropped, got %d events", qs.Len())
+	}
+	qs2, err := NewQueueStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := qs2.Between(now.Add(-2*time.Hour), now)
+	if len(events) != 1 || events[0].CallSid != "CA2" || events[0].Wait != time.Minute {
+		t.Errorf("expected to reload the recent event, got %v", events)
+	}
+	if events := qs2.Between(now.Add(-30*time.Minute), now); len(events) != 0 {
+		t.Errorf("expected no events in the last 30 minutes, got %d", len(events))
+	}
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// The names of the stores a retention policy can apply to, as they appear in
+// the retention setting.
+const (
+	RetentionAuditLog       = "audit_log"
+	RetentionQueueEvents    = "queue_events"
+	RetentionTickets        = "tickets"
+	RetentionMediaCache     = "media_cache"
+	RetentionExports        = "exports"
+	RetentionStatuses       = "message_statuses"
+	RetentionAttachmentText = "attachment_text"
+	RetentionNotes          = "notes"
+)
+
+// RetentionStores are the names of every store a retention policy can apply
+// to, in alphabetical order.
+var RetentionStores = []string{
+	RetentionAttachmentText,
+	RetentionAuditLog,
+	RetentionExports,
+	RetentionMediaCache,
+	RetentionStatuses,
+	RetentionNotes,
+	RetentionQueueEvents,
+	RetentionTickets,
+}
+
+// A Purger deletes data that's older than a cutoff. If dryRun is true, it
+// counts what it would delete, and deletes nothing.
+type Purger interface {
+	PurgeBefore(cutoff time.Time, dryRun bool) (int, error)
+}
+
+// RetentionStats are the counts for one store.
+type RetentionStats struct {
+	MaxAge time.Duration `json:"max_age"`
+	Runs   int64         `json:"runs"`
+	Failed int64         `json:"failed"`
+	// Total number of items deleted. Always zero in dry run mode.
+	Purged int64 `json:"purged"`
+	// Items deleted, or that would have been in dry run mode, by the last
+	// run.
+	LastPurged int       `json:"last_purged"`
+	LastRun    time.Time `json:"last_run"`
+	LastErr    string    `json:"last_err,omitempty"`
+}
+
+type RetentionReport struct {
+	DryRun  bool                       `json:"dry_run"`
+	LastRun time.Time                  `json:"last_run"`
+	Stores  map[string]*RetentionStats `json:"stores"`
+}
+
+type retentionPolicy struct {
+	name   string
+	maxAge time.Duration
+	store  Purger
+}
+
+// RetentionManager deletes data from each store once it's older than the
+// store's maximum age, and keeps counts of what it deleted.
+type RetentionManager struct {
+	log.Logger
+	// Count what would be deleted, without deleting anything.
+	DryRun bool
+
+	mu       sync.Mutex
+	policies []*retentionPolicy
+	stats    map[string]*RetentionStats
+	lastRun  time.Time
+}
+
+func NewRetentionManager(l log.Logger, dryRun bool) *RetentionManager {
+	return &RetentionManager{
+		Logger: l,
+		DryRun: dryRun,
+		stats:  make(map[string]*RetentionStats),
+	}
+}
+
+// Add purges data older than maxAge from store. Stores with a nil store or a
+// maxAge of zero are ignored, so data is kept forever.
+func (m *RetentionManager) Add(name string, maxAge time.Duration, store Purger) {
+	if store == nil || maxAge <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.policies = append(m.policies, &retentionPolicy{name: name, maxAge: maxAge, store: store})
+	m.stats[name] = &RetentionStats{MaxAge: maxAge}
+}
+
+// Len returns the number of stores with a retention policy.
+func (m *RetentionManager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.policies)
+}
+
+// Purge deletes expired data from every store, as of now. A store that fails
+// is logged and counted, and doesn't stop the others from being purged.
+func (m *RetentionManager) Purge(now time.Time) {
+	m.mu.Lock()
+	policies := m.policies
+	m.mu.Unlock()
+	for _, p := range policies {
+		n, err := p.store.PurgeBefore(now.Add(-p.maxAge), m.DryRun)
+		m.mu.Lock()
+		st := m.stats[p.name]
+		st.Runs++
+		st.LastRun = now
+		st.LastPurged = n
+		st.LastErr = ""
+		if err != nil {
+			st.Failed++
+			st.LastErr = err.Error()
+		} else if !m.DryRun {
+			st.Purged += int64(n)
+		}
+		m.mu.Unlock()
+		switch {
+		case err != nil:
+			m.Warn("Couldn't purge expired data", "store", p.name, "err", err)
+		case m.DryRun && n > 0:
+			m.Info("Would purge expired data (dry run)", "store", p.name, "count", n, "max_age", p.maxAge)
+		case n > 0:
+			m.Info("Purged expired data", "store", p.name, "count", n, "max_age", p.maxAge)
+		}
+	}
+	m.mu.Lock()
+	m.lastRun = now
+	m.mu.Unlock()
+}
+
+// Report returns a copy of the counts for each store.
+func (m *RetentionManager) Report() *RetentionReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := &RetentionReport{
+		DryRun:  m.DryRun,
+		LastRun: m.lastRun,
+		Stores:  make(map[string]*RetentionStats, len(m.stats)),
+	}
+	for name, st := range m.stats {
+		cp := *st
+		r.Stores[name] = &cp
+	}
+	return r
+}
+
+// IsRetentionStore reports whether name is one of the RetentionStores.
+func IsRetentionStore(name string) bool {
+	i := sort.SearchStrings(RetentionStores, name)
+	return i < len(RetentionStores) && RetentionStores[i] == name
+}
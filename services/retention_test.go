@@ -0,0 +1,144 @@
+package services
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
+
+type fakePurger struct {
+	cutoff time.Time
+	dryRun bool
+	n      int
+	err    error
+}
+
+func (f *fakePurger) PurgeBefore(cutoff time.Time, dryRun bool) (int, error) {
+	f.cutoff = cutoff
+	f.dryRun = dryRun
+	return f.n, f.err
+}
+
+func nullLogger() log.Logger {
+	l := log.New()
+	l.SetHandler(log.DiscardHandler())
+	return l
+}
+
+func TestRetentionManagerPurges(t *testing.T) {
+	t.Parallel()
+	m := NewRetentionManager(nullLogger(), false)
+	audit := &fakePurger{n: 3}
+	broken := &fakePurger{err: errors.New("disk full")}
+	m.Add(RetentionAuditLog, 24*time.Hour, audit)
+	m.Add(RetentionExports, time.Hour, broken)
+	// No policy, so it's never purged.
+	m.Add(RetentionTickets, 0, &fakePurger{})
+	if m.Len() != 2 {
+		t.Fatalf("expected 2 policies, got %d", m.Len())
+	}
+	now := time.Date(2016, 11, 4, 12, 0, 0, 0, time.UTC)
+	m.Purge(now)
+	m.Purge(now)
+	if want := now.Add(-24 * time.Hour); !audit.cutoff.Equal(want) {
+		t.Errorf("expected cutoff %v, got %v", want, audit.cutoff)
+	}
+	r := m.Report()
+	if r.DryRun || !r.LastRun.Equal(now) {
+		t.Errorf("bad report: %#v", r)
+	}
+	st := r.Stores[RetentionAuditLog]
+	if st.Runs != 2 || st.Purged != 6 || st.LastPurged != 3 || st.Failed != 0 {
+		t.Errorf("bad audit log stats: %#v", st)
+	}
+	st = r.Stores[RetentionExports]
+	if st.Failed != 2 || st.LastErr != "disk full" {
+		t.Errorf("bad exports stats: %#v", st)
+	}
+	if _, ok := r.Stores[RetentionTickets]; ok {
+		t.Error("expected no stats for a store without a policy")
+	}
+}
+
+func TestRetentionManagerDryRun(t *testing.T) {
+	t.Parallel()
+	m := NewRetentionManager(nullLogger(), true)
+	p := &fakePurger{n: 5}
+	m.Add(RetentionMediaCache, time.Hour, p)
+	m.Purge(time.Now())
+	if !p.dryRun {
+		t.Error("expected the store to be purged in dry run mode")
+	}
+	st := m.Report().Stores[RetentionMediaCache]
+	if st.Purged != 0 || st.LastPurged != 5 {
+		t.Errorf("expected 5 items to be counted but not purged, got %#v", st)
+	}
+}
+
+func TestAuditLogPurgeBefore(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-audit-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	a, err := NewAuditLog(nullLogger(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	a.Record(&AuditEvent{Time: now.Add(-48 * time.Hour), User: "admin", Action: "grant_permissions"})
+	a.Record(&AuditEvent{Time: now.Add(-time.Hour), User: "admin", Action: "revoke_grant"})
+	cutoff := now.Add(-24 * time.Hour)
+	n, err := a.PurgeBefore(cutoff, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected dry run to count 1 event, got %d", n)
+	}
+	before, _ := ioutil.ReadFile(path)
+	if n, err = a.PurgeBefore(cutoff, false); err != nil || n != 1 {
+		t.Fatalf("expected to purge 1 event, got %d, %v", n, err)
+	}
+	after, _ := ioutil.ReadFile(path)
+	if len(after) == 0 || len(after) >= len(before) {
+		t.Errorf("expected the file to shrink, went from %q to %q", before, after)
+	}
+	if n, _ = a.PurgeBefore(cutoff, false); n != 0 {
+		t.Errorf("expected nothing left to purge, got %d", n)
+	}
+}
+
+func TestQueueStorePurgeBefore(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-queues-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queue-events.json")
+	qs, err := NewQueueStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	qs.Add(&QueueEvent{Time: now.Add(-48 * time.Hour), CallSid: "CA1", Result: QueueResultHangup})
+	qs.Add(&QueueEvent{Time: now.Add(-time.Hour), CallSid: "CA2", Result: QueueResultBridged})
+	if n, err := qs.PurgeBefore(now.Add(-24*time.Hour), false); err != nil || n != 1 {
+		t.Fatalf("expected to purge 1 event, got %d, %v", n, err)
+	}
+	qs2, err := NewQueueStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qs2.Len() != 1 {
+		t.Errorf("expected 1 event in the file after purging, got %d", qs2.Len())
+	}
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How many times a rate limited request is retried before giving up.
+const maxRetries = 3
+
+// The longest a request will wait for Twilio's rate limit to reset. Requests
+// that would have to wait longer fail with a RateLimitError instead.
+const maxRetryWait = 10 * time.Second
+
+// The wait before the first retry, if Twilio doesn't send a Retry-After
+// header. It doubles for each retry after that.
+var retryBaseDelay = 250 * time.Millisecond
+
+// A RateLimitError is returned when Twilio is rate limiting requests, and
+// the limit won't reset soon enough to retry the request.
+type RateLimitError struct {
+	// How long until Twilio should accept requests again.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	secs := int((e.RetryAfter + time.Second - 1) / time.Second)
+	return fmt.Sprintf("Rate limited by Twilio, retrying. Try again in %d seconds", secs)
+}
+
+// RateLimited returns the RateLimitError in err, if there is one. Errors
+// from an http.Client are wrapped in a *url.Error.
+func RateLimited(err error) (*RateLimitError, bool) {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	rerr, ok := err.(*RateLimitError)
+	return rerr, ok
+}
+
+type retryTransport struct {
+	rt http.RoundTripper
+
+	mu sync.Mutex
+	// Don't send any requests before this time; Twilio told us to wait.
+	pausedUntil time.Time
+	rand        *rand.Rand
+}
+
+// NewRetryTransport returns a RoundTripper that retries GET and HEAD requests
+// when Twilio responds with 429 Too Many Requests. It waits for the time in
+// the Retry-After header, or backs off exponentially with jitter if there
+// isn't one. While Twilio is rate limiting, every other request waits too,
+// instead of adding to the problem. Requests that can't wait long enough,
+// because of their context's deadline or maxRetryWait, fail with a
+// RateLimitError.
+func NewRetryTransport(rt http.RoundTripper) http.RoundTripper {
+	return &retryTransport{
+		rt:   rt,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	idempotent := req.Method == "GET" || req.Method == "HEAD"
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(req); err != nil {
+			return nil, err
+		}
+		resp, err := t.rt.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || !idempotent {
+			return resp, err
+		}
+		wait := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if wait <= 0 {
+			wait = t.backoff(attempt)
+		}
+		t.pause(wait)
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if attempt >= maxRetries {
+			return nil, &RateLimitError{RetryAfter: wait}
+		}
+	}
+}
+
+// wait blocks until Twilio should accept requests again, or returns a
+// RateLimitError if req can't wait that long.
+func (t *retryTransport) wait(req *http.Request) error {
+	t.mu.Lock()
+	wait := t.pausedUntil.Sub(time.Now())
+	t.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	ctx := req.Context()
+	if deadline, ok := ctx.Deadline(); wait > maxRetryWait || (ok && time.Now().Add(wait).After(deadline)) {
+		return &RateLimitError{RetryAfter: wait}
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *retryTransport) pause(wait time.Duration) {
+	until := time.Now().Add(wait)
+	t.mu.Lock()
+	if until.After(t.pausedUntil) {
+		t.pausedUntil = until
+	}
+	t.mu.Unlock()
+}
+
+// backoff returns a random duration between half of and the full exponential
+// delay for the given attempt, so requests that were rate limited together
+// don't all retry at once.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	d := retryBaseDelay << uint(attempt)
+	t.mu.Lock()
+	jitter := time.Duration(t.rand.Int63n(int64(d/2) + 1))
+	t.mu.Unlock()
+	return d/2 + jitter
+}
+
+// retryAfter parses a Retry-After header, which is either a number of seconds
+// or an HTTP date. It returns zero if the header is missing or invalid.
+func retryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type rateLimitedTransport struct {
+	limited    int
+	retryAfter string
+	requests   int
+}
+
+func (rt *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.requests++
+	resp := &http.Response{StatusCode: 200, Header: make(http.Header), Body: ioutil.NopCloser(strings.NewReader("{}"))}
+	if rt.requests <= rt.limited {
+		resp.StatusCode = http.StatusTooManyRequests
+		if rt.retryAfter != "" {
+			resp.Header.Set("Retry-After", rt.retryAfter)
+		}
+	}
+	return resp, nil
+}
+
+func init() {
+	retryBaseDelay = time.Millisecond
+}
+
+func TestRetryTransportRetries(t *testing.T) {
+	t.Parallel()
+	rt := &rateLimitedTransport{limited: 2}
+	req, _ := http.NewRequest("GET", "https://api.twilio.com/2010-04-01/Accounts/AC123/Messages.json", nil)
+	resp, err := NewRetryTransport(rt).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("expected Code to be 200, got %d", resp.StatusCode)
+	}
+	if rt.requests != 3 {
+		t.Errorf("expected 3 requests, got %d", rt.requests)
+	}
+}
+
+func TestRetryTransportGivesUp(t *testing.T) {
+	t.Parallel()
+	rt := &rateLimitedTransport{limited: 100}
+	req, _ := http.NewRequest("GET", "https://api.twilio.com/2010-04-01/Accounts/AC123/Messages.json", nil)
+	_, err := NewRetryTransport(rt).RoundTrip(req)
+	if _, ok := RateLimited(err); !ok {
+		t.Errorf("expected RateLimitError, got %v", err)
+	}
+	if rt.requests != maxRetries+1 {
+		t.Errorf("expected %d requests, got %d", maxRetries+1, rt.requests)
+	}
+}
+
+func TestRetryTransportShedsPastDeadline(t *testing.T) {
+	t.Parallel()
+	rt := &rateLimitedTransport{limited: 1, retryAfter: "5"}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, _ := http.NewRequest("GET", "https://api.twilio.com/2010-04-01/Accounts/AC123/Messages.json", nil)
+	_, err := NewRetryTransport(rt).RoundTrip(req.WithContext(ctx))
+	rerr, ok := RateLimited(err)
+	if !ok {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+	if rerr.RetryAfter <= 4*time.Second {
+		t.Errorf("expected RetryAfter to come from the header, got %v", rerr.RetryAfter)
+	}
+	if rt.requests != 1 {
+		t.Errorf("expected 1 request, got %d", rt.requests)
+	}
+}
+
+func TestRetryTransportDoesNotRetryPost(t *testing.T) {
+	t.Parallel()
+	rt := &rateLimitedTransport{limited: 1}
+	req, _ := http.NewRequest("POST", "https://api.twilio.com/2010-04-01/Accounts/AC123/Messages.json", nil)
+	resp, err := NewRetryTransport(rt).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests || rt.requests != 1 {
+		t.Errorf("expected a single 429 response, got %d after %d requests", resp.StatusCode, rt.requests)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"-1", 0},
+		{"Sun, 01 Jan 2017 00:00:10 GMT", 10 * time.Second},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.header, now); got != tt.want {
+			t.Errorf("retryAfter(%q): got %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// A MediaScanner checks MMS attachments before they're shown to users, for
+// viruses or explicit images, for example.
+type MediaScanner interface {
+	Scan(ctx context.Context, contentType string, data []byte) (*ScanResult, error)
+}
+
+// ScanResult is a MediaScanner's verdict on an attachment.
+type ScanResult struct {
+	// Flagged attachments are shown behind a warning.
+	Flagged bool `json:"flagged"`
+	// Why the attachment was flagged, like "Adult content". Shown to users.
+	Reason string `json:"reason"`
+}
+
+// WebhookScanner POSTs each attachment to a URL, with the attachment's
+// Content-Type, and reads a ScanResult from the JSON response.
+type WebhookScanner struct {
+	URL string
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (ws *WebhookScanner) Scan(ctx context.Context, contentType string, data []byte) (*ScanResult, error) {
+	req, err := http.NewRequest("POST", ws.URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	client := ws.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("Media scanner returned status %d", resp.StatusCode)
+	}
+	result := new(ScanResult)
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("Could not parse media scanner response: %v", err)
+	}
+	return result, nil
+}
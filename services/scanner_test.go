@@ -0,0 +1,46 @@
+package services
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestWebhookScanner(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ctype := r.Header.Get("Content-Type"); ctype != "image/png" {
+			t.Errorf("expected Content-Type to be image/png, got %q", ctype)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != "png data" {
+			t.Errorf("unexpected body %q", body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"flagged": true, "reason": "Adult content"}`))
+	}))
+	defer s.Close()
+	ws := &WebhookScanner{URL: s.URL}
+	result, err := ws.Scan(context.Background(), "image/png", []byte("png data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Flagged || result.Reason != "Adult content" {
+		t.Errorf("unexpected result %#v", result)
+	}
+}
+
+func TestWebhookScannerError(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer s.Close()
+	ws := &WebhookScanner{URL: s.URL}
+	if _, err := ws.Scan(context.Background(), "image/png", []byte("png data")); err == nil {
+		t.Error("expected error for a 503 response")
+	}
+}
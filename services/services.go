@@ -2,10 +2,13 @@
 package services
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"io"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/nacl/secretbox"
@@ -70,6 +73,50 @@ func UnopaqueByte(compressed string, secretKey *[32]byte) ([]byte, error) {
 	return decrypted, nil
 }
 
+// ErrWrongOwner is returned by UnopaqueFor when the value was created for a
+// different user.
+var ErrWrongOwner = errors.New("This link was created for a different user")
+
+// User ids can't contain a NUL byte, so it can't be confused with part of
+// the owner.
+const ownerSep = "\x00"
+
+// OpaqueFor is like Opaque, but binds the result to owner, usually a user's
+// id. UnopaqueFor only decodes it for the same owner, so a link copied from
+// one user's page doesn't work for anyone else.
+func OpaqueFor(s string, owner string, secretKey *[32]byte) string {
+	return Opaque(owner+ownerSep+s, secretKey)
+}
+
+// StableOpaqueFor is like OpaqueFor, but always returns the same string for
+// the same s, owner and secretKey, so browsers can cache whatever is at the
+// URL it's part of. The nonce is derived from the input instead of being
+// random, which reveals when two values are the same; only use it where
+// that's fine, like media URLs.
+func StableOpaqueFor(s string, owner string, secretKey *[32]byte) string {
+	b := []byte(owner + ownerSep + s)
+	mac := hmac.New(sha256.New, secretKey[:])
+	mac.Write(b)
+	nonce := new([24]byte)
+	copy(nonce[:], mac.Sum(nil))
+	encrypted := secretbox.Seal(nonce[:], b, nonce, secretKey)
+	return base64.URLEncoding.EncodeToString(encrypted)
+}
+
+// UnopaqueFor decodes a value created by OpaqueFor, or returns ErrWrongOwner
+// if it was created for someone other than owner.
+func UnopaqueFor(compressed string, owner string, secretKey *[32]byte) (string, error) {
+	s, err := Unopaque(compressed, secretKey)
+	if err != nil {
+		return "", err
+	}
+	idx := strings.Index(s, ownerSep)
+	if idx < 0 || s[:idx] != owner {
+		return "", ErrWrongOwner
+	}
+	return s[idx+len(ownerSep):], nil
+}
+
 // Duration returns a friendly duration (with the insignificant bits rounded
 // off).
 func Duration(d time.Duration) string {
@@ -23,6 +23,44 @@ func TestOpaque(t *testing.T) {
 	}
 }
 
+func TestOpaqueFor(t *testing.T) {
+	t.Parallel()
+	key := NewRandomKey()
+	out := OpaqueFor(npurl, "alice", key)
+	exp, err := UnopaqueFor(out, "alice", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp != npurl {
+		t.Fatalf("expected UnopaqueFor(OpaqueFor(%v)) to be the same, got %v", npurl, exp)
+	}
+	if _, err := UnopaqueFor(out, "bob", key); err != ErrWrongOwner {
+		t.Errorf("expected ErrWrongOwner for another user, got %v", err)
+	}
+	if _, err := UnopaqueFor(Opaque(npurl, key), "", key); err != ErrWrongOwner {
+		t.Errorf("expected ErrWrongOwner for an unbound value, got %v", err)
+	}
+}
+
+func TestStableOpaqueFor(t *testing.T) {
+	t.Parallel()
+	key := NewRandomKey()
+	out := StableOpaqueFor(npurl, "alice", key)
+	if again := StableOpaqueFor(npurl, "alice", key); again != out {
+		t.Errorf("expected the same value twice, got %q and %q", out, again)
+	}
+	if other := StableOpaqueFor(npurl, "bob", key); other == out {
+		t.Error("expected different owners to get different values")
+	}
+	exp, err := UnopaqueFor(out, "alice", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp != npurl {
+		t.Fatalf("expected UnopaqueFor(StableOpaqueFor(%v)) to be the same, got %v", npurl, exp)
+	}
+}
+
 func TestTruncateSid(t *testing.T) {
 	t.Parallel()
 	if TruncateSid("MM1234567") != "MM123456" {
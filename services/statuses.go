@@ -0,0 +1,221 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/saintpete/logrole/store"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// Where a MessageStatus came from.
+const (
+	// Twilio posted it to the message's status callback.
+	StatusSourceCallback = "callback"
+	// The reconciler fetched the message because it hadn't heard back.
+	StatusSourceReconciled = "reconciled"
+)
+
+// IsTerminalStatus reports whether a message with status s won't change
+// status again.
+func IsTerminalStatus(s twilio.Status) bool {
+	switch s {
+	case twilio.StatusDelivered, twilio.StatusUndelivered, twilio.StatusFailed,
+		twilio.StatusReceived, twilio.Status("read"), twilio.Status("canceled"):
+		return true
+	}
+	return false
+}
+
+// A MessageStatus is the last status we know of for a message.
+type MessageStatus struct {
+	Sid       string        `json:"sid"`
+	Status    twilio.Status `json:"status"`
+	ErrorCode int           `json:"error_code,omitempty"`
+	// When the status was recorded.
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+}
+
+// NewMessageStatus parses the parameters Twilio sends to a message status
+// callback.
+func NewMessageStatus(form url.Values, now time.Time) (*MessageStatus, error) {
+	st := &MessageStatus{
+		Sid:    form.Get("MessageSid"),
+		Status: twilio.Status(form.Get("MessageStatus")),
+		Time:   now.UTC(),
+		Source: StatusSourceCallback,
+	}
+	if st.Sid == "" || st.Status == "" {
+		return nil, errors.New("Missing MessageSid or MessageStatus")
+	}
+	if code := form.Get("ErrorCode"); code != "" {
+		n, err := strconv.Atoi(code)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ErrorCode %q", code)
+		}
+		st.ErrorCode = n
+	}
+	return st, nil
+}
+
+type statusesByTime []*MessageStatus
+
+func (s statusesByTime) Len() int           { return len(s) }
+func (s statusesByTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s statusesByTime) Less(i, j int) bool { return s[i].Time.Before(s[j].Time) }
+
+// StatusStore holds the latest status of each message we've heard about from
+// a status callback. If it has a path, each update is appended to that file
+// as a line of JSON, and the file is read on startup; the last line for a
+// message wins.
+type StatusStore struct {
+	path     string
+	mu       sync.RWMutex
+	statuses map[string]*MessageStatus
+}
+
+// NewStatusStore creates a StatusStore, loading the statuses in the file at
+// path. The file doesn't need to exist yet. If path is empty, statuses are
+// only kept in memory.
+func NewStatusStore(path string) (*StatusStore, error) {
+	ss := &StatusStore{
+		path:     path,
+		statuses: make(map[string]*MessageStatus),
+	}
+	if path == "" {
+		return ss, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return ss, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		st := new(MessageStatus)
+		if err := json.Unmarshal(scanner.Bytes(), st); err != nil {
+			return nil, fmt.Errorf("Couldn't read message statuses from %s, line %d: %v", path, line, err)
+		}
+		ss.statuses[st.Sid] = st
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// Get returns the last status recorded for sid, or nil if there isn't one.
+func (ss *StatusStore) Get(sid string) *MessageStatus {
+	if ss == nil {
+		return nil
+	}
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.statuses[sid]
+}
+
+// Update records st as the latest status of its message. Callbacks can
+// arrive out of order, so a terminal status is never replaced by one that
+// isn't; Update reports whether st was recorded.
+func (ss *StatusStore) Update(st *MessageStatus) (bool, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if old, ok := ss.statuses[st.Sid]; ok && IsTerminalStatus(old.Status) && !IsTerminalStatus(st.Status) {
+		return false, nil
+	}
+	ss.statuses[st.Sid] = st
+	if ss.path == "" {
+		return true, nil
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return true, err
+	}
+	f, err := os.OpenFile(ss.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return true, err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return true, err
+	}
+	return true, f.Close()
+}
+
+// Pending returns the messages whose last status isn't terminal and was
+// recorded between since and before, oldest first.
+func (ss *StatusStore) Pending(since, before time.Time) []*MessageStatus {
+	if ss == nil {
+		return nil
+	}
+	ss.mu.RLock()
+	pending := make([]*MessageStatus, 0)
+	for _, st := range ss.statuses {
+		if !IsTerminalStatus(st.Status) && !st.Time.Before(since) && st.Time.Before(before) {
+			pending = append(pending, st)
+		}
+	}
+	ss.mu.RUnlock()
+	sort.Sort(statusesByTime(pending))
+	return pending
+}
+
+// PurgeBefore removes the statuses recorded before cutoff, rewriting the file
+// if there is one, and returns how many it removed. If dryRun is true, it
+// only counts them.
+func (ss *StatusStore) PurgeBefore(cutoff time.Time, dryRun bool) (int, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	keep := make([]*MessageStatus, 0, len(ss.statuses))
+	for _, st := range ss.statuses {
+		if !st.Time.Before(cutoff) {
+			keep = append(keep, st)
+		}
+	}
+	purged := len(ss.statuses) - len(keep)
+	if dryRun || purged == 0 {
+		return purged, nil
+	}
+	sort.Sort(statusesByTime(keep))
+	if ss.path != "" {
+		buf := new(bytes.Buffer)
+		enc := json.NewEncoder(buf)
+		for _, st := range keep {
+			if err := enc.Encode(st); err != nil {
+				return 0, err
+			}
+		}
+		if err := store.WriteFile(ss.path, buf.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	statuses := make(map[string]*MessageStatus, len(keep))
+	for _, st := range keep {
+		statuses[st.Sid] = st
+	}
+	ss.statuses = statuses
+	return purged, nil
+}
+
+// Len returns the number of messages in the store.
+func (ss *StatusStore) Len() int {
+	if ss == nil {
+		return 0
+	}
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return len(ss.statuses)
+}
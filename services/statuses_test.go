@@ -0,0 +1,56 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	twilio "github.com/saintpete/twilio-go"
+)
+
+func TestStatusStoreKeepsTerminalStatus(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-statuses-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "statuses.json")
+	ss, err := NewStatusStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2016, 10, 18, 17, 0, 0, 0, time.UTC)
+	ss.Update(&MessageStatus{Sid: "SM1", Status: twilio.StatusSent, Time: now})
+	ss.Update(&MessageStatus{Sid: "SM1", Status: twilio.StatusDelivered, Time: now.Add(time.Second)})
+	// Arrives late, after the message was delivered.
+	if updated, err := ss.Update(&MessageStatus{Sid: "SM1", Status: twilio.StatusSent, Time: now.Add(2 * time.Second)}); err != nil || updated {
+		t.Errorf("expected a late non-terminal status to be ignored, got %t, %v", updated, err)
+	}
+	ss.Update(&MessageStatus{Sid: "SM2", Status: twilio.StatusQueued, Time: now})
+
+	ss2, err := NewStatusStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := ss2.Get("SM1"); st == nil || st.Status != twilio.StatusDelivered {
+		t.Errorf("expected the last recorded status to be loaded, got %#v", st)
+	}
+	pending := ss2.Pending(now.Add(-time.Hour), now.Add(time.Hour))
+	if len(pending) != 1 || pending[0].Sid != "SM2" {
+		t.Errorf("expected SM2 to be pending, got %v", pending)
+	}
+
+	if n, err := ss2.PurgeBefore(now.Add(time.Millisecond), false); err != nil || n != 1 {
+		t.Errorf("expected to purge SM2, got %d, %v", n, err)
+	}
+	ss3, err := NewStatusStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss3.Len() != 1 || ss3.Get("SM2") != nil {
+		t.Errorf("expected the purge to rewrite the file, got %d statuses", ss3.Len())
+	}
+}
@@ -0,0 +1,20 @@
+package services
+
+import (
+	"encoding/json"
+
+	"github.com/saintpete/logrole/store"
+)
+
+// putJSON saves v as JSON under key in bucket. A nil slice or map deletes the
+// key instead, so buckets don't fill up with empty lists.
+func putJSON(s store.Store, bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if string(data) == "null" {
+		return s.Delete(bucket, key)
+	}
+	return s.Put(bucket, key, data)
+}
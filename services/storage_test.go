@@ -0,0 +1,96 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/store"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+func TestStoresReloadFromStore(t *testing.T) {
+	t.Parallel()
+	s := store.NewMemory()
+	notes, err := NewNoteStoreWith(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := notes.Add("SM123", "Escalated to carrier", "test"); err != nil {
+		t.Fatal(err)
+	}
+	tickets, err := NewTicketStoreWith(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tickets.Add("CA123", "SUPPORT-1", "test"); err != nil {
+		t.Fatal(err)
+	}
+	labels, err := NewLabelStoreWith(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := labels.Import(strings.NewReader("+14105551234,Main line\n+14105551235,Backup line\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := labels.Delete("+14105551235"); err != nil {
+		t.Fatal(err)
+	}
+	owners, err := NewOwnerStoreWith(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := owners.Set("+14105551234", "Support", "support-primary"); err != nil {
+		t.Fatal(err)
+	}
+
+	notes, _ = NewNoteStoreWith(s)
+	if n := notes.Get("SM123"); len(n) != 1 || n[0].Text != "Escalated to carrier" {
+		t.Errorf("expected the note to be reloaded, got %v", n)
+	}
+	tickets, _ = NewTicketStoreWith(s)
+	if refs := tickets.Get("CA123"); len(refs) != 1 || refs[0].Ref != "SUPPORT-1" {
+		t.Errorf("expected the ticket to be reloaded, got %v", refs)
+	}
+	labels, _ = NewLabelStoreWith(s)
+	if l := labels.Get(twilio.PhoneNumber("+14105551234")); l != "Main line" {
+		t.Errorf("expected the label to be reloaded, got %q", l)
+	}
+	if l := labels.Get(twilio.PhoneNumber("+14105551235")); l != "" {
+		t.Errorf("expected the deleted label to stay deleted, got %q", l)
+	}
+	owners, _ = NewOwnerStoreWith(s)
+	if o := owners.Get(twilio.PhoneNumber("+14105551234")); o == nil || o.Team != "Support" || o.Rotation != "support-primary" {
+		t.Errorf("expected the owner to be reloaded, got %v", o)
+	}
+
+	purged, err := notes.PurgeBefore(time.Now().Add(time.Minute), false)
+	if err != nil || purged != 1 {
+		t.Fatalf("expected to purge 1 note, got %d, %v", purged, err)
+	}
+	if all, _ := s.All(notesBucket); len(all) != 0 {
+		t.Errorf("expected purged notes to be deleted from the store, got %v", all)
+	}
+}
+
+func TestAuditLogStore(t *testing.T) {
+	t.Parallel()
+	s := store.NewMemory()
+	a := NewAuditLogWith(nullLogger(), s)
+	now := time.Now().UTC()
+	a.Record(&AuditEvent{Time: now.Add(-2 * time.Hour), User: "test", Action: "grant"})
+	a.Record(&AuditEvent{Time: now.Add(-2 * time.Hour), User: "test", Action: "revoke_grant"})
+	a.Record(&AuditEvent{User: "test", Action: "add_note"})
+	if all, _ := s.All(auditBucket); len(all) != 3 {
+		t.Fatalf("expected events at the same time to be kept apart, got %d", len(all))
+	}
+	if purged, err := a.PurgeBefore(now.Add(-time.Hour), true); err != nil || purged != 2 {
+		t.Fatalf("expected a dry run to count 2 events, got %d, %v", purged, err)
+	}
+	if purged, err := a.PurgeBefore(now.Add(-time.Hour), false); err != nil || purged != 2 {
+		t.Fatalf("expected to purge 2 events, got %d, %v", purged, err)
+	}
+	if all, _ := s.All(auditBucket); len(all) != 1 {
+		t.Errorf("expected 1 event to be kept, got %d", len(all))
+	}
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saintpete/logrole/store"
+)
+
+// MaxTicketRefLength is the longest ticket reference a TicketStore will
+// accept.
+const MaxTicketRefLength = 200
+
+// The store.Store bucket ticket references are kept in, keyed by sid.
+const ticketsBucket = "tickets"
+
+// A TicketRef records a ticket someone created for a message or call, like
+// "SUPPORT-123" or a link to the ticket.
+type TicketRef struct {
+	Ref       string    `json:"ref"`
+	User      string    `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// URL returns the reference if it's an http or https URL, and the empty
+// string otherwise.
+func (t *TicketRef) URL() string {
+	u, err := url.Parse(t.Ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return t.Ref
+}
+
+// TicketStore holds ticket references for resources, keyed by sid. If it has
+// a path, the references are saved to that file as JSON after every change,
+// and loaded from it on startup. If it has a store.Store, each resource's
+// references are saved there instead.
+type TicketStore struct {
+	path string
+	db   store.Store
+	mu   sync.RWMutex
+	refs map[string][]*TicketRef
+}
+
+// NewTicketStore creates a TicketStore, loading any references in the file at
+// path. The file doesn't need to exist yet. If path is empty, references are
+// only kept in memory.
+func NewTicketStore(path string) (*TicketStore, error) {
+	ts := &TicketStore{
+		path: path,
+		refs: make(map[string][]*TicketRef),
+	}
+	if path == "" {
+		return ts, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &ts.refs); err != nil {
+		return nil, fmt.Errorf("Couldn't read tickets from %s: %v", path, err)
+	}
+	return ts, nil
+}
+
+// NewTicketStoreWith creates a TicketStore that saves references in s,
+// loading the references already there.
+func NewTicketStoreWith(s store.Store) (*TicketStore, error) {
+	ts := &TicketStore{
+		db:   s,
+		refs: make(map[string][]*TicketRef),
+	}
+	all, err := s.All(ticketsBucket)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read tickets: %v", err)
+	}
+	for sid, data := range all {
+		var refs []*TicketRef
+		if err := json.Unmarshal(data, &refs); err != nil {
+			return nil, fmt.Errorf("Couldn't read tickets for %s: %v", sid, err)
+		}
+		ts.refs[sid] = refs
+	}
+	return ts, nil
+}
+
+// Get returns the ticket references for sid, oldest first. A nil TicketStore
+// has no references.
+func (ts *TicketStore) Get(sid string) []*TicketRef {
+	if ts == nil {
+		return nil
+	}
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	refs := make([]*TicketRef, len(ts.refs[sid]))
+	copy(refs, ts.refs[sid])
+	return refs
+}
+
+// Add records that user created the ticket ref for sid.
+func (ts *TicketStore) Add(sid string, ref string, user string) (*TicketRef, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil, errors.New("Enter a ticket number or link")
+	}
+	if len(ref) > MaxTicketRefLength {
+		return nil, fmt.Errorf("Ticket reference is longer than %d characters", MaxTicketRefLength)
+	}
+	t := &TicketRef{Ref: ref, User: user, CreatedAt: time.Now().UTC()}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, existing := range ts.refs[sid] {
+		if existing.Ref == ref {
+			return existing, nil
+		}
+	}
+	ts.refs[sid] = append(ts.refs[sid], t)
+	return t, ts.save(sid)
+}
+
+// PurgeBefore removes the ticket references created before cutoff, and
+// returns how many it removed. If dryRun is true, it only counts them.
+func (ts *TicketStore) PurgeBefore(cutoff time.Time, dryRun bool) (int, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	purged := 0
+	kept := make(map[string][]*TicketRef, len(ts.refs))
+	changed := make([]string, 0)
+	for sid, refs := range ts.refs {
+		for _, t := range refs {
+			if t.CreatedAt.Before(cutoff) {
+				purged++
+				continue
+			}
+			kept[sid] = append(kept[sid], t)
+		}
+		if len(kept[sid]) < len(refs) {
+			changed = append(changed, sid)
+		}
+	}
+	if dryRun || purged == 0 {
+		return purged, nil
+	}
+	old := ts.refs
+	ts.refs = kept
+	if err := ts.save(changed...); err != nil {
+		ts.refs = old
+		return 0, err
+	}
+	return purged, nil
+}
+
+// save writes the references for the given sids to ts.db, or every
+// reference to ts.path. ts.mu must be held.
+func (ts *TicketStore) save(sids ...string) error {
+	if ts.db != nil {
+		for _, sid := range sids {
+			if err := putJSON(ts.db, ticketsBucket, sid, ts.refs[sid]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if ts.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(ts.refs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFile(ts.path, data)
+}
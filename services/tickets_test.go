@@ -0,0 +1,52 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTicketStore(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-tickets-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tickets.json")
+	ts, err := NewTicketStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Add("SM123", "  ", "test"); err == nil {
+		t.Error("expected an empty ref to be rejected")
+	}
+	if _, err := ts.Add("SM123", "SUPPORT-1", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Add("SM123", "https://example.zendesk.com/tickets/2", "test"); err != nil {
+		t.Fatal(err)
+	}
+	// Adding the same ref twice doesn't duplicate it.
+	if _, err := ts.Add("SM123", "SUPPORT-1", "other"); err != nil {
+		t.Fatal(err)
+	}
+	ts2, err := NewTicketStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := ts2.Get("SM123")
+	if len(refs) != 2 {
+		t.Fatalf("expected 2 refs to be loaded from disk, got %d", len(refs))
+	}
+	if refs[0].Ref != "SUPPORT-1" || refs[0].User != "test" || refs[0].URL() != "" {
+		t.Errorf("unexpected first ref %#v", refs[0])
+	}
+	if refs[1].URL() != "https://example.zendesk.com/tickets/2" {
+		t.Errorf("expected second ref to be a link, got %q", refs[1].URL())
+	}
+	if refs := ts2.Get("CA123"); len(refs) != 0 {
+		t.Errorf("expected no refs for another sid, got %d", len(refs))
+	}
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// A Translator translates a message body into another language - with an
+// internal translation service, for example - so agents can read messages
+// from customers who don't write in their language.
+type Translator interface {
+	Translate(ctx context.Context, text string, target string) (*Translation, error)
+}
+
+// A Translation is a message body in another language.
+type Translation struct {
+	Text string `json:"text"`
+	// The language of the original text, like "es", if the translator
+	// detected it.
+	SourceLanguage string `json:"source_language"`
+	TargetLanguage string `json:"target_language"`
+}
+
+type translateRequest struct {
+	Text           string `json:"text"`
+	TargetLanguage string `json:"target_language"`
+}
+
+// WebhookTranslator POSTs the text and the target language to a URL as JSON,
+// and reads the translation from the "text" field of the JSON response, and
+// the language it detected from "source_language".
+type WebhookTranslator struct {
+	URL string
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (wt *WebhookTranslator) Translate(ctx context.Context, text string, target string) (*Translation, error) {
+	data, err := json.Marshal(&translateRequest{Text: text, TargetLanguage: target})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", wt.URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	client := wt.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("Translator returned status %d", resp.StatusCode)
+	}
+	t := new(Translation)
+	if err := json.NewDecoder(resp.Body).Decode(t); err != nil {
+		return nil, fmt.Errorf("Could not parse translator response: %v", err)
+	}
+	if t.Text == "" {
+		return nil, errors.New("Translator returned an empty translation")
+	}
+	t.TargetLanguage = target
+	return t, nil
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestWebhookTranslator(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := new(translateRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			t.Error(err)
+		}
+		if req.Text != "¿Dónde está mi pedido?" || req.TargetLanguage != "en" {
+			t.Errorf("unexpected request %#v", req)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"text": "Where is my order?", "source_language": "es"}`))
+	}))
+	defer s.Close()
+	wt := &WebhookTranslator{URL: s.URL}
+	tr, err := wt.Translate(context.Background(), "¿Dónde está mi pedido?", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Text != "Where is my order?" || tr.SourceLanguage != "es" || tr.TargetLanguage != "en" {
+		t.Errorf("unexpected translation %#v", tr)
+	}
+}
+
+func TestWebhookTranslatorError(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer s.Close()
+	wt := &WebhookTranslator{URL: s.URL}
+	if _, err := wt.Translate(context.Background(), "hola", "en"); err == nil {
+		t.Error("expected error for a 503 response")
+	}
+}
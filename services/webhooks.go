@@ -0,0 +1,274 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Limits for the webhook debugger.
+const (
+	// How long a capture URL accepts requests.
+	WebhookCaptureTTL = 24 * time.Hour
+	// Only the most recent requests to a capture URL are kept.
+	MaxWebhookRequests = 50
+	// Request bodies are truncated to this many bytes.
+	MaxWebhookBody = 64 * 1024
+	// The most capture URLs a single user can have at once.
+	MaxWebhookCaptures = 10
+)
+
+var ErrCaptureNotFound = errors.New("Capture not found")
+
+// The results of checking a request's X-Twilio-Signature header.
+const (
+	SignatureValid   = "valid"
+	SignatureInvalid = "invalid"
+	SignatureMissing = "missing"
+	// There's no auth token to check the signature with.
+	SignatureUnchecked = "unchecked"
+)
+
+// A CapturedRequest is a request that was sent to a capture URL.
+type CapturedRequest struct {
+	Time       time.Time
+	Method     string
+	URL        string
+	RemoteAddr string
+	Header     http.Header
+	Body       []byte
+	// Set if the body was longer than MaxWebhookBody.
+	Truncated bool
+	// One of SignatureValid, SignatureInvalid, SignatureMissing or
+	// SignatureUnchecked.
+	Signature string
+	// The CallSid parameter Twilio sent, if any.
+	CallSid string
+	// What the capture's Target responded with. nil unless the capture
+	// forwards requests.
+	Response *CapturedResponse
+}
+
+// A CapturedResponse is what our webhook responded with when a capture URL
+// forwarded a request to it - usually TwiML.
+type CapturedResponse struct {
+	// Where the request was forwarded.
+	URL        string
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Set if the body was longer than MaxWebhookBody.
+	Truncated bool
+	// Set if the request couldn't be forwarded, or the response couldn't be
+	// read.
+	Err string
+}
+
+// A WebhookCapture is a URL that records the requests sent to it, so a user
+// can point a Twilio webhook at it and see exactly what Twilio sends.
+type WebhookCapture struct {
+	ID    string
+	Owner string
+	// The full URL to give to Twilio.
+	URL string
+	// If set, requests are forwarded to this URL, and its response is
+	// recorded and sent back to Twilio.
+	Target    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// Most recent first.
+	Requests []*CapturedRequest
+}
+
+func (c *WebhookCapture) copy() *WebhookCapture {
+	c2 := *c
+	c2.Requests = make([]*CapturedRequest, len(c.Requests))
+	copy(c2.Requests, c.Requests)
+	return &c2
+}
+
+type capturesByCreated []*WebhookCapture
+
+func (c capturesByCreated) Len() int           { return len(c) }
+func (c capturesByCreated) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c capturesByCreated) Less(i, j int) bool { return c[i].CreatedAt.After(c[j].CreatedAt) }
+
+// WebhookStore holds capture URLs and the requests sent to them in memory.
+// Captures are removed once they expire.
+type WebhookStore struct {
+	mu       sync.Mutex
+	captures map[string]*WebhookCapture
+}
+
+func NewWebhookStore() *WebhookStore {
+	return &WebhookStore{captures: make(map[string]*WebhookCapture)}
+}
+
+// prune removes expired captures. s.mu must be held.
+func (s *WebhookStore) prune(now time.Time) {
+	for id, c := range s.captures {
+		if !now.Before(c.ExpiresAt) {
+			delete(s.captures, id)
+		}
+	}
+}
+
+// Create makes a new capture URL for owner, under baseURL. If target isn't
+// empty, requests to the capture URL are forwarded to it.
+func (s *WebhookStore) Create(owner string, baseURL string, target string, now time.Time) (*WebhookCapture, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(b)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	count := 0
+	for _, c := range s.captures {
+		if c.Owner == owner {
+			count++
+		}
+	}
+	if count >= MaxWebhookCaptures {
+		return nil, errors.New("You have too many capture URLs; wait for one to expire")
+	}
+	c := &WebhookCapture{
+		ID:        id,
+		Owner:     owner,
+		URL:       baseURL + "/webhooks/" + id,
+		Target:    target,
+		CreatedAt: now,
+		ExpiresAt: now.Add(WebhookCaptureTTL),
+	}
+	s.captures[id] = c
+	return c.copy(), nil
+}
+
+// Get returns the capture with the given id. Only the user who created a
+// capture can see it.
+func (s *WebhookStore) Get(owner, id string, now time.Time) (*WebhookCapture, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	c, ok := s.captures[id]
+	if !ok || c.Owner != owner {
+		return nil, ErrCaptureNotFound
+	}
+	return c.copy(), nil
+}
+
+// List returns owner's captures, newest first.
+func (s *WebhookStore) List(owner string, now time.Time) []*WebhookCapture {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	captures := make([]*WebhookCapture, 0)
+	for _, c := range s.captures {
+		if c.Owner == owner {
+			captures = append(captures, c.copy())
+		}
+	}
+	sort.Sort(capturesByCreated(captures))
+	return captures
+}
+
+// URL returns the full URL of the capture with the given id, so the signature
+// of a request to it can be checked before it's recorded, and the URL the
+// request should be forwarded to, if any.
+func (s *WebhookStore) URL(id string, now time.Time) (string, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.captures[id]
+	if !ok || !now.Before(c.ExpiresAt) {
+		return "", "", ErrCaptureNotFound
+	}
+	return c.URL, c.Target, nil
+}
+
+type requestsByTime []*CapturedRequest
+
+func (r requestsByTime) Len() int           { return len(r) }
+func (r requestsByTime) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r requestsByTime) Less(i, j int) bool { return r[i].Time.After(r[j].Time) }
+
+// CallResponses returns the forwarded requests for the call with the given
+// sid, with what our webhook responded, newest first. Every capture is
+// searched, whoever created it.
+func (s *WebhookStore) CallResponses(callSid string, now time.Time) []*CapturedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(now)
+	reqs := make([]*CapturedRequest, 0)
+	for _, c := range s.captures {
+		for _, req := range c.Requests {
+			if req.CallSid == callSid && req.Response != nil {
+				reqs = append(reqs, req)
+			}
+		}
+	}
+	sort.Sort(requestsByTime(reqs))
+	return reqs
+}
+
+// Record adds req to the capture with the given id, dropping the oldest
+// request if the capture already has MaxWebhookRequests.
+func (s *WebhookStore) Record(id string, req *CapturedRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.captures[id]
+	if !ok || !req.Time.Before(c.ExpiresAt) {
+		return ErrCaptureNotFound
+	}
+	reqs := append([]*CapturedRequest{req}, c.Requests...)
+	if len(reqs) > MaxWebhookRequests {
+		reqs = reqs[:MaxWebhookRequests]
+	}
+	c.Requests = reqs
+	return nil
+}
+
+// TwilioSignature computes the X-Twilio-Signature Twilio sends with a request
+// to urlStr: the base64 HMAC-SHA1 of the URL followed by each POST parameter
+// name and value, sorted by name.
+func TwilioSignature(authToken string, urlStr string, form url.Values) string {
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	mac := hmac.New(sha1.New, []byte(authToken))
+	io.WriteString(mac, urlStr)
+	for _, k := range keys {
+		for _, v := range form[k] {
+			io.WriteString(mac, k)
+			io.WriteString(mac, v)
+		}
+	}
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CheckTwilioSignature returns one of the Signature constants for a request
+// to urlStr with the given POST parameters and X-Twilio-Signature header.
+func CheckTwilioSignature(authToken string, urlStr string, form url.Values, signature string) string {
+	switch {
+	case authToken == "":
+		return SignatureUnchecked
+	case signature == "":
+		return SignatureMissing
+	}
+	expected := TwilioSignature(authToken, urlStr, form)
+	if hmac.Equal([]byte(expected), []byte(signature)) {
+		return SignatureValid
+	}
+	return SignatureInvalid
+}
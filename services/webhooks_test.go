@@ -0,0 +1,106 @@
+package services
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestTwilioSignature(t *testing.T) {
+	t.Parallel()
+	// The example from https://www.twilio.com/docs/api/security
+	form := url.Values{
+		"CallSid": {"CA1234567890ABCDE"},
+		"Caller":  {"+12349013030"},
+		"Digits":  {"1234"},
+		"From":    {"+12349013030"},
+		"To":      {"+18005551212"},
+	}
+	u := "https://mycompany.com/myapp.php?foo=1&bar=2"
+	sig := "0/KCTR6DLpKmkAf8muzZqo1nDgQ="
+	if got := TwilioSignature("12345", u, form); got != sig {
+		t.Errorf("expected signature %s, got %s", sig, got)
+	}
+	tests := []struct {
+		token, sig, want string
+	}{
+		{"12345", sig, SignatureValid},
+		{"54321", sig, SignatureInvalid},
+		{"12345", "", SignatureMissing},
+		{"", sig, SignatureUnchecked},
+	}
+	for _, tt := range tests {
+		if got := CheckTwilioSignature(tt.token, u, form, tt.sig); got != tt.want {
+			t.Errorf("CheckTwilioSignature(%q, %q): expected %s, got %s", tt.token, tt.sig, tt.want, got)
+		}
+	}
+}
+
+func TestWebhookStore(t *testing.T) {
+	t.Parallel()
+	s := NewWebhookStore()
+	now := time.Date(2016, 11, 1, 12, 0, 0, 0, time.UTC)
+	c, err := s.Create("alice", "https://logrole.example.com", "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.URL != "https://logrole.example.com/webhooks/"+c.ID {
+		t.Errorf("unexpected capture URL %s", c.URL)
+	}
+	if _, err := s.Get("bob", c.ID, now); err != ErrCaptureNotFound {
+		t.Errorf("expected other users not to see the capture, got %v", err)
+	}
+	for i := 0; i < MaxWebhookRequests+5; i++ {
+		if err := s.Record(c.ID, &CapturedRequest{Time: now.Add(time.Duration(i) * time.Second), Method: "POST"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c2, err := s.Get("alice", c.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c2.Requests) != MaxWebhookRequests {
+		t.Errorf("expected %d requests, got %d", MaxWebhookRequests, len(c2.Requests))
+	}
+	if want := now.Add(time.Duration(MaxWebhookRequests+4) * time.Second); !c2.Requests[0].Time.Equal(want) {
+		t.Errorf("expected newest request first, got %v", c2.Requests[0].Time)
+	}
+	expired := now.Add(WebhookCaptureTTL)
+	if err := s.Record(c.ID, &CapturedRequest{Time: expired}); err != ErrCaptureNotFound {
+		t.Errorf("expected expired capture to reject requests, got %v", err)
+	}
+	if list := s.List("alice", expired); len(list) != 0 {
+		t.Errorf("expected expired capture to be removed, got %d", len(list))
+	}
+	for i := 0; i < MaxWebhookCaptures; i++ {
+		if _, err := s.Create("carol", "", "", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Create("carol", "", "", now); err == nil {
+		t.Error("expected an error creating too many captures")
+	}
+}
+
+func TestCallResponses(t *testing.T) {
+	t.Parallel()
+	s := NewWebhookStore()
+	now := time.Date(2016, 11, 1, 12, 0, 0, 0, time.UTC)
+	alice, _ := s.Create("alice", "", "https://example.com/voice", now)
+	bob, _ := s.Create("bob", "", "https://example.com/status", now)
+	twiml := &CapturedResponse{StatusCode: 200, Body: []byte("<Response><Say>Hi</Say></Response>")}
+	s.Record(alice.ID, &CapturedRequest{Time: now, CallSid: "CA123", Response: twiml})
+	s.Record(bob.ID, &CapturedRequest{Time: now.Add(time.Second), CallSid: "CA123", Response: twiml})
+	s.Record(bob.ID, &CapturedRequest{Time: now.Add(2 * time.Second), CallSid: "CA456", Response: twiml})
+	s.Record(bob.ID, &CapturedRequest{Time: now.Add(3 * time.Second), CallSid: "CA123"})
+	reqs := s.CallResponses("CA123", now)
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 responses for the call, got %d", len(reqs))
+	}
+	if !reqs[0].Time.Equal(now.Add(time.Second)) {
+		t.Errorf("expected newest response first, got %v", reqs[0].Time)
+	}
+	if reqs := s.CallResponses("CA123", now.Add(WebhookCaptureTTL)); len(reqs) != 0 {
+		t.Errorf("expected expired captures to be skipped, got %d", len(reqs))
+	}
+}
@@ -0,0 +1,20 @@
+//go:build postgres
+// +build postgres
+
+package store
+
+import (
+	"strconv"
+
+	// Registers the "postgres" database/sql driver.
+	_ "github.com/lib/pq"
+)
+
+func init() {
+	dialects["postgres"] = &dialect{
+		name:   "postgres",
+		driver: "postgres",
+		blob:   "BYTEA",
+		bind:   func(n int) string { return "$" + strconv.Itoa(n) },
+	}
+}
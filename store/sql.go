@@ -0,0 +1,104 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// The table every SQL store keeps its values in. Each value is a row, keyed
+// by bucket and name.
+const table = "logrole_store"
+
+// A dialect is what differs between the databases we support.
+type dialect struct {
+	// The name of the storage driver, for Open.
+	name string
+	// The name the database/sql driver registers.
+	driver string
+	// The column type for values.
+	blob string
+	// bind returns the placeholder for the nth argument, counting from 1.
+	bind func(n int) string
+}
+
+// SQL is a Store that keeps values in a database table, which is created if
+// it doesn't exist.
+type SQL struct {
+	db *sql.DB
+
+	get, put, del, all string
+}
+
+func openSQL(d *dialect, dsn string) (Store, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("The %s storage driver needs a storage_dsn", d.name)
+	}
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	create := "CREATE TABLE IF NOT EXISTS " + table + " (bucket TEXT NOT NULL, name TEXT NOT NULL, value " + d.blob + " NOT NULL, PRIMARY KEY (bucket, name))"
+	if _, err := db.Exec(create); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Couldn't create the %s table: %v", table, err)
+	}
+	return &SQL{
+		db:  db,
+		get: "SELECT value FROM " + table + " WHERE bucket = " + d.bind(1) + " AND name = " + d.bind(2),
+		// Needs Postgres 9.5 or SQLite 3.24 or newer.
+		put: "INSERT INTO " + table + " (bucket, name, value) VALUES (" + binds(d, 3) + ") ON CONFLICT (bucket, name) DO UPDATE SET value = excluded.value",
+		del: "DELETE FROM " + table + " WHERE bucket = " + d.bind(1) + " AND name = " + d.bind(2),
+		all: "SELECT name, value FROM " + table + " WHERE bucket = " + d.bind(1),
+	}, nil
+}
+
+// binds returns the placeholders for n arguments, separated by commas.
+func binds(d *dialect, n int) string {
+	b := make([]string, n)
+	for i := range b {
+		b[i] = d.bind(i + 1)
+	}
+	return strings.Join(b, ", ")
+}
+
+func (s *SQL) Get(bucket, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.QueryRow(s.get, bucket, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (s *SQL) Put(bucket, key string, value []byte) error {
+	_, err := s.db.Exec(s.put, bucket, key, value)
+	return err
+}
+
+func (s *SQL) Delete(bucket, key string) error {
+	_, err := s.db.Exec(s.del, bucket, key)
+	return err
+}
+
+func (s *SQL) All(bucket string) (map[string][]byte, error) {
+	rows, err := s.db.Query(s.all, bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	all := make(map[string][]byte)
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		all[key] = value
+	}
+	return all, rows.Err()
+}
+
+func (s *SQL) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,18 @@
+//go:build sqlite
+// +build sqlite
+
+package store
+
+import (
+	// Registers the "sqlite3" database/sql driver. It uses cgo.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	dialects["sqlite"] = &dialect{
+		name:   "sqlite",
+		driver: "sqlite3",
+		blob:   "BLOB",
+		bind:   func(n int) string { return "?" },
+	}
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	t.Parallel()
+	s, err := Open("memory", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.Get("notes", "SM123"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for a missing key, got %v", err)
+	}
+	value := []byte("escalated")
+	if err := s.Put("notes", "SM123", value); err != nil {
+		t.Fatal(err)
+	}
+	value[0] = 'E'
+	if err := s.Put("tickets", "SM123", []byte("SUPPORT-1")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get("notes", "SM123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "escalated" {
+		t.Errorf("expected changes to the value after Put not to be stored, got %q", got)
+	}
+	all, err := s.All("notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || string(all["SM123"]) != "escalated" {
+		t.Errorf("expected only the notes bucket, got %v", all)
+	}
+	if err := s.Delete("notes", "SM123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("notes", "SM456"); err != nil {
+		t.Errorf("expected deleting a missing key to succeed, got %v", err)
+	}
+	if _, err := s.Get("notes", "SM123"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound after Delete, got %v", err)
+	}
+	if _, err := s.Get("tickets", "SM123"); err != nil {
+		t.Errorf("expected other buckets to be left alone, got %v", err)
+	}
+}
+
+func TestOpenUnknownDriver(t *testing.T) {
+	t.Parallel()
+	_, err := Open("mysql", "")
+	if err == nil || !strings.Contains(err.Error(), "memory, postgres, sqlite") {
+		t.Errorf("expected an error listing the drivers, got %v", err)
+	}
+	_, err = Open("postgres", "")
+	if _, ok := dialects["postgres"]; ok {
+		if err == nil {
+			t.Error("expected an error opening postgres without a dsn")
+		}
+	} else if err == nil || !strings.Contains(err.Error(), "-tags postgres") {
+		t.Errorf("expected an error saying postgres isn't compiled in, got %v", err)
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	t.Parallel()
+	for _, name := range []string{"postgres", "sqlite"} {
+		d, ok := dialects[name]
+		if !ok {
+			continue
+		}
+		want := map[string]string{"postgres": "$1, $2, $3", "sqlite": "?, ?, ?"}[name]
+		if b := binds(d, 3); b != want {
+			t.Errorf("got %q for %s, want %q", b, name, want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+{{- define "auto-refresh-toggle" }}
+{{- if .AutoRefresh }}
+<label class="auto-refresh">
+  <input type="checkbox" id="auto-refresh"> Auto-refresh
+</label>
+{{- end }}
+{{- end }}
+
+{{- define "auto-refresh" }}
+{{- if .AutoRefresh }}
+<script type="text/javascript" nonce="{{ csp_nonce }}">
+  (function() {
+    var toggle = document.getElementById('auto-refresh');
+    if (toggle === null || !window.XMLHttpRequest) {
+      return;
+    }
+    var storageKey = 'auto-refresh:' + window.location.pathname;
+    var polling = false;
+
+    var table = function(doc) {
+      return doc.querySelector('table[data-newer]');
+    };
+
+    // Replace the table with the one on a freshly loaded copy of the page. If
+    // the page had no table rows to replace, reload all of it.
+    var refresh = function() {
+      var xhr = new XMLHttpRequest();
+      xhr.open('GET', window.location.href);
+      xhr.responseType = 'document';
+      xhr.onload = function() {
+        var current = table(document);
+        var fresh = xhr.status === 200 && xhr.response ? table(xhr.response) : null;
+        if (current === null || fresh === null || current.querySelectorAll('tbody tr').length === 0) {
+          window.location.reload();
+          return;
+        }
+        current.parentNode.replaceChild(document.importNode(fresh, true), current);
+        poll();
+      };
+      xhr.onerror = function() { setTimeout(poll, 30000); };
+      xhr.send();
+    };
+
+    // Ask the server to tell us when there's something newer than the top of
+    // the table. The server holds the request open until there is, or until
+    // it times out, so this doesn't make a request every few seconds.
+    var poll = function() {
+      var current = table(document);
+      if (!toggle.checked || current === null) {
+        polling = false;
+        return;
+      }
+      polling = true;
+      var xhr = new XMLHttpRequest();
+      xhr.open('GET', current.getAttribute('data-newer'));
+      xhr.onload = function() {
+        if (xhr.status !== 200) {
+          setTimeout(poll, 30000);
+          return;
+        }
+        var resp = JSON.parse(xhr.responseText);
+        if (resp.newer) {
+          refresh();
+        } else {
+          poll();
+        }
+      };
+      xhr.onerror = function() { setTimeout(poll, 30000); };
+      xhr.send();
+    };
+
+    toggle.checked = window.localStorage && localStorage.getItem(storageKey) === 'on';
+    toggle.addEventListener('change', function() {
+      if (window.localStorage) {
+        if (toggle.checked) {
+          localStorage.setItem(storageKey, 'on');
+        } else {
+          localStorage.removeItem(storageKey);
+        }
+      }
+      if (toggle.checked && !polling) {
+        poll();
+      }
+    });
+    if (toggle.checked) {
+      poll();
+    }
+  })();
+</script>
+{{- end }}
+{{- end }}
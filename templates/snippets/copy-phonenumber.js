@@ -1,5 +1,5 @@
 {{- define "copy-phonenumber" }}
-<script type="text/javascript">
+<script type="text/javascript" nonce="{{ csp_nonce }}">
   var evHandler = function(clipboardElem) {
     return function() {
       var pnCopy = clipboardElem.parentNode.querySelector('.copy-target');
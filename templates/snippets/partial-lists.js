@@ -0,0 +1,93 @@
+{{- define "partial-lists" }}
+{{- if .Partial }}
+<script type="text/javascript" nonce="{{ csp_nonce }}">
+  (function() {
+    var results = document.getElementById('results');
+    var form = document.querySelector('.row-search form');
+    if (results === null || form === null || !window.XMLHttpRequest || !window.history.pushState) {
+      return;
+    }
+    var nonce = document.currentScript ? document.currentScript.nonce : '';
+    var loading = null;
+
+    // Scripts set with innerHTML don't run. Run the ones in the new results
+    // with this page's nonce; the nonce they came with was for another
+    // response.
+    var runScripts = function() {
+      var scripts = results.querySelectorAll('script');
+      for (var i = 0; i < scripts.length; i++) {
+        var s = document.createElement('script');
+        s.nonce = nonce;
+        s.text = scripts[i].text;
+        scripts[i].parentNode.replaceChild(s, scripts[i]);
+      }
+    };
+
+    // Fetch just the results for url and swap them in. If the server doesn't
+    // like the filters, load the whole page, so the error is shown with them.
+    var load = function(url, scroll) {
+      if (loading !== null) {
+        loading.abort();
+      }
+      var xhr = new XMLHttpRequest();
+      loading = xhr;
+      xhr.open('GET', url);
+      xhr.setRequestHeader('Logrole-Fragment', 'results');
+      results.setAttribute('aria-busy', 'true');
+      results.classList.add('results-loading');
+      xhr.onload = function() {
+        loading = null;
+        if (xhr.status !== 200) {
+          window.location.href = url;
+          return;
+        }
+        // Stop the old auto-refresh loop; the new results start their own.
+        var toggle = document.getElementById('auto-refresh');
+        if (toggle !== null) {
+          toggle.checked = false;
+        }
+        results.innerHTML = xhr.responseText;
+        runScripts();
+        results.removeAttribute('aria-busy');
+        results.classList.remove('results-loading');
+        window.history.pushState(null, '', url);
+        if (scroll) {
+          results.scrollIntoView();
+        }
+      };
+      xhr.onerror = function() { window.location.href = url; };
+      xhr.send();
+    };
+
+    form.addEventListener('submit', function(ev) {
+      var params = [];
+      for (var i = 0; i < form.elements.length; i++) {
+        var el = form.elements[i];
+        if (!el.name || el.disabled || ((el.type === 'checkbox' || el.type === 'radio') && !el.checked)) {
+          continue;
+        }
+        params.push(encodeURIComponent(el.name) + '=' + encodeURIComponent(el.value));
+      }
+      ev.preventDefault();
+      load(form.getAttribute('action') + '?' + params.join('&'), false);
+    });
+
+    results.addEventListener('click', function(ev) {
+      if (ev.button !== 0 || ev.ctrlKey || ev.metaKey || ev.shiftKey || !ev.target.closest) {
+        return;
+      }
+      var link = ev.target.closest('a[rel="next"], a[rel="prev"]');
+      if (link !== null) {
+        ev.preventDefault();
+        load(link.href, true);
+      }
+    });
+
+    // The search form doesn't match older results, so load the whole page.
+    window.addEventListener('popstate', function() {
+      window.location.reload();
+    });
+  })();
+</script>
+{{- end }}
+{{- end }}
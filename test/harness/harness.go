@@ -42,3 +42,12 @@ func ViewsClient(harness ViewHarness) views.Client {
 	}
 	return views.NewClient(NullLogger, c, harness.SecretKey, config.NewPermission(harness.MaxResourceAge))
 }
+
+// FixtureClient returns a views.Client that serves the resources in f, for
+// handler tests that don't need a Twilio API server.
+func FixtureClient(harness ViewHarness, f *views.Fixtures) views.Client {
+	if harness.MaxResourceAge == 0 {
+		harness.MaxResourceAge = 720 * time.Hour
+	}
+	return views.NewFixtureClient(NullLogger, "AC123", config.NewPermission(harness.MaxResourceAge), f)
+}
@@ -0,0 +1,161 @@
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	types "github.com/kevinburke/go-types"
+	"github.com/saintpete/logrole/views"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// A TwilioServer is a fake Twilio API that serves the messages in a
+// views.Fixtures, for handler tests that need to send or change messages, or
+// use an API FixtureClient doesn't have. Point a client at it with
+// ViewHarness.TestServer.
+//
+// Lists return every message on one page. POSTing to Messages.json creates a
+// queued message, and POSTing to a message sets its Status. The form of each
+// POST is recorded. Other APIs, like recordings or the Messaging API, only
+// answer with the responses set with Respond.
+type TwilioServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	messages  []*twilio.Message
+	created   []*twilio.Message
+	posts     []url.Values
+	requests  []*url.URL
+	responses map[string]string
+}
+
+// NewTwilioServer starts a TwilioServer serving the messages in f. Close it
+// when the test is done. f isn't modified.
+func NewTwilioServer(f *views.Fixtures) *TwilioServer {
+	ts := &TwilioServer{responses: make(map[string]string)}
+	for _, m := range f.Messages {
+		copied := *m
+		ts.messages = append(ts.messages, &copied)
+	}
+	ts.Server = httptest.NewServer(http.HandlerFunc(ts.serveHTTP))
+	return ts
+}
+
+// Respond makes the server answer requests for path with body, which should
+// be JSON. A request matches if its URL path ends with path, so
+// "/Recordings.json" answers requests for the account's recordings; if several
+// paths match, the longest wins.
+func (ts *TwilioServer) Respond(path string, body string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.responses[path] = body
+}
+
+// Requests returns the URL of each request the server received, in order.
+func (ts *TwilioServer) Requests() []*url.URL {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append([]*url.URL{}, ts.requests...)
+}
+
+// Posts returns the form of each POST request the server received, in order.
+func (ts *TwilioServer) Posts() []url.Values {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append([]url.Values{}, ts.posts...)
+}
+
+// Created returns the messages created with a POST, in order.
+func (ts *TwilioServer) Created() []*twilio.Message {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append([]*twilio.Message{}, ts.created...)
+}
+
+func (ts *TwilioServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.requests = append(ts.requests, r.URL)
+	if r.Method == "POST" {
+		r.ParseForm()
+		ts.posts = append(ts.posts, r.PostForm)
+	}
+	if body, ok := ts.response(r.URL.Path); ok {
+		w.Write([]byte(body))
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/Messages.json") {
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(ts.create(r.PostForm))
+			return
+		}
+		json.NewEncoder(w).Encode(&twilio.MessagePage{Messages: ts.messages})
+		return
+	}
+	idx := strings.LastIndex(r.URL.Path, "/Messages/")
+	if idx == -1 || !strings.HasSuffix(r.URL.Path, ".json") {
+		notFound(w, r)
+		return
+	}
+	sid := strings.TrimSuffix(r.URL.Path[idx+len("/Messages/"):], ".json")
+	for _, m := range ts.messages {
+		if m.Sid != sid {
+			continue
+		}
+		if status := r.PostForm.Get("Status"); r.Method == "POST" && status != "" {
+			m.Status = twilio.Status(status)
+		}
+		json.NewEncoder(w).Encode(m)
+		return
+	}
+	notFound(w, r)
+}
+
+// response returns the body set with Respond for the longest path that ends
+// urlPath. ts.mu must be held.
+func (ts *TwilioServer) response(urlPath string) (string, bool) {
+	match := ""
+	for path := range ts.responses {
+		if strings.HasSuffix(urlPath, path) && len(path) > len(match) {
+			match = path
+		}
+	}
+	if match == "" {
+		return "", false
+	}
+	return ts.responses[match], true
+}
+
+func (ts *TwilioServer) create(form url.Values) *twilio.Message {
+	now := twilio.TwilioTime{Valid: true, Time: time.Now().UTC()}
+	m := &twilio.Message{
+		Sid:         fmt.Sprintf("SM%032x", len(ts.messages)),
+		From:        twilio.PhoneNumber(form.Get("From")),
+		To:          twilio.PhoneNumber(form.Get("To")),
+		Body:        form.Get("Body"),
+		Status:      twilio.StatusQueued,
+		Direction:   twilio.DirectionOutboundAPI,
+		NumSegments: 1,
+		DateCreated: now,
+		DateUpdated: now,
+	}
+	if sid := form.Get("MessagingServiceSid"); sid != "" {
+		m.MessagingServiceSid = types.NullString{Valid: true, String: sid}
+	}
+	ts.messages = append(ts.messages, m)
+	ts.created = append(ts.created, m)
+	return m
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprintf(w, `{"code": 20404, "message": "The requested resource %s was not found", "more_info": "https://www.twilio.com/docs/errors/20404", "status": 404}`, r.URL.Path)
+}
@@ -0,0 +1,45 @@
+package harness
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/saintpete/logrole/services"
+)
+
+// A WebhookTarget is a fake customer app for Twilio to send webhooks to. It
+// answers every request with TwiML that says hello to the CallSid, and checks
+// each request's X-Twilio-Signature against the auth token.
+type WebhookTarget struct {
+	*httptest.Server
+
+	authToken  string
+	mu         sync.Mutex
+	signatures []string
+}
+
+// NewWebhookTarget starts a WebhookTarget that checks signatures against
+// authToken. Close it when the test is done.
+func NewWebhookTarget(authToken string) *WebhookTarget {
+	wt := &WebhookTarget{authToken: authToken}
+	wt.Server = httptest.NewServer(http.HandlerFunc(wt.serveHTTP))
+	return wt
+}
+
+// Signatures returns the result of checking each request's signature, like
+// services.SignatureValid, in order.
+func (wt *WebhookTarget) Signatures() []string {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	return append([]string{}, wt.signatures...)
+}
+
+func (wt *WebhookTarget) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	wt.mu.Lock()
+	wt.signatures = append(wt.signatures, services.CheckTwilioSignature(wt.authToken, "http://"+r.Host+r.URL.RequestURI(), r.PostForm, r.Header.Get("X-Twilio-Signature")))
+	wt.mu.Unlock()
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte(`<Response><Say>Hello ` + r.PostForm.Get("CallSid") + `</Say></Response>`))
+}
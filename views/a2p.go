@@ -0,0 +1,383 @@
+package views
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+	types "github.com/kevinburke/go-types"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+// Brands, campaigns and messaging services live in the Messaging API, which
+// twilio-go doesn't support.
+const messagingBaseURL = "https://messaging.twilio.com"
+
+// Registration status changes over days, not seconds.
+var a2pTimeout = 10 * time.Minute
+
+// Stop listing messaging services after this many pages of 50, and numbers
+// after the first page of 1000 for each service.
+const maxA2PServicePages = 4
+
+// How many messaging services to look up at once.
+const a2pConcurrency = 4
+
+// Error codes for messages Twilio (or a carrier) rejected because of A2P 10DLC
+// registration - see https://www.twilio.com/docs/api/errors.
+var a2pErrorCodes = map[twilio.Code]bool{
+	30034: true, // Message from an unregistered number
+	30035: true, // Message from a number still being configured
+	30036: true, // Message from a number associated with an expired campaign
+	30037: true, // Outbound messaging disabled
+}
+
+// IsA2PErrorCode returns true if a message failed with code because of A2P
+// 10DLC registration.
+func IsA2PErrorCode(code twilio.Code) bool {
+	return a2pErrorCodes[code]
+}
+
+type a2pBrand struct {
+	Sid            string            `json:"sid"`
+	Status         string            `json:"status"`
+	BrandType      string            `json:"brand_type"`
+	TCRID          types.NullString  `json:"tcr_id"`
+	IdentityStatus types.NullString  `json:"identity_status"`
+	FailureReason  types.NullString  `json:"failure_reason"`
+	DateCreated    twilio.TwilioTime `json:"date_created"`
+	DateUpdated    twilio.TwilioTime `json:"date_updated"`
+}
+
+type a2pCampaign struct {
+	Sid                  string            `json:"sid"`
+	BrandRegistrationSid string            `json:"brand_registration_sid"`
+	MessagingServiceSid  string            `json:"messaging_service_sid"`
+	CampaignID           types.NullString  `json:"campaign_id"`
+	CampaignStatus       string            `json:"campaign_status"`
+	UseCase              string            `json:"us_app_to_person_usecase"`
+	Description          string            `json:"description"`
+	DateCreated          twilio.TwilioTime `json:"date_created"`
+	DateUpdated          twilio.TwilioTime `json:"date_updated"`
+}
+
+type a2pPhoneNumber struct {
+	Sid         string             `json:"sid"`
+	PhoneNumber twilio.PhoneNumber `json:"phone_number"`
+	CountryCode string             `json:"country_code"`
+}
+
+type a2pService struct {
+	Sid          string `json:"sid"`
+	FriendlyName string `json:"friendly_name"`
+	Registered   bool   `json:"us_app_to_person_registered"`
+	// Filled in from other requests.
+	Campaigns           []*a2pCampaign
+	PhoneNumbers        []*a2pPhoneNumber
+	PhoneNumbersPartial bool
+}
+
+type a2pBrandPage struct {
+	Meta   twilio.Meta `json:"meta"`
+	Brands []*a2pBrand `json:"data"`
+}
+
+type a2pServicePage struct {
+	Meta     twilio.Meta   `json:"meta"`
+	Services []*a2pService `json:"services"`
+}
+
+type a2pCampaignPage struct {
+	Meta      twilio.Meta    `json:"meta"`
+	Campaigns []*a2pCampaign `json:"compliance"`
+}
+
+type a2pPhoneNumberPage struct {
+	Meta         twilio.Meta       `json:"meta"`
+	PhoneNumbers []*a2pPhoneNumber `json:"phone_numbers"`
+}
+
+// a2pRegistrations is what gets cached; everything the Messaging API says
+// about the account's registrations.
+type a2pRegistrations struct {
+	Brands   []*a2pBrand
+	Services []*a2pService
+	// True if there were too many messaging services to list.
+	Partial bool
+}
+
+// An A2PFinder looks up the account's A2P 10DLC registrations. The archive
+// client doesn't have any, and doesn't implement it.
+type A2PFinder interface {
+	GetA2PRegistrations(context.Context, *config.User) (*A2PRegistrations, error)
+}
+
+// A2PRegistrations are the account's A2P 10DLC brands, and the messaging
+// services their campaigns and numbers are attached to.
+type A2PRegistrations struct {
+	Brands   []*A2PBrand
+	Services []*A2PService
+	Partial  bool
+	// How long ago the registrations were fetched from Twilio.
+	Age time.Duration
+}
+
+// A2PBrand is a business registered with The Campaign Registry.
+type A2PBrand struct {
+	Sid            string
+	Status         string
+	BrandType      string
+	TCRID          string
+	IdentityStatus string
+	FailureReason  string
+	Updated        time.Time
+}
+
+// A2PCampaign is a use case registered for a brand, and attached to one
+// messaging service.
+type A2PCampaign struct {
+	Sid         string
+	Brand       *A2PBrand
+	CampaignID  string
+	Status      string
+	UseCase     string
+	Description string
+	Updated     time.Time
+}
+
+// A2PService is a messaging service; numbers can only send A2P 10DLC traffic
+// through a messaging service with a verified campaign.
+type A2PService struct {
+	Sid          string
+	FriendlyName string
+	Campaigns    []*A2PCampaign
+	PhoneNumbers []twilio.PhoneNumber
+	// True if the service has more numbers than were listed.
+	PartialNumbers bool
+}
+
+// StatusClass returns "success", "warning" or "danger" for a brand or
+// campaign status, for coloring labels.
+func StatusClass(status string) string {
+	switch strings.ToUpper(status) {
+	case "APPROVED", "VERIFIED":
+		return "success"
+	case "PENDING", "IN_PROGRESS", "IN_REVIEW":
+		return "warning"
+	default:
+		return "danger"
+	}
+}
+
+// Problem describes why messages sent through the service might be blocked,
+// or returns the empty string if its campaign is verified and its brand is
+// approved.
+func (s *A2PService) Problem() string {
+	if len(s.Campaigns) == 0 {
+		return "No A2P campaign is registered for this messaging service"
+	}
+	for _, c := range s.Campaigns {
+		if StatusClass(c.Status) == "success" && (c.Brand == nil || StatusClass(c.Brand.Status) == "success") {
+			return ""
+		}
+	}
+	c := s.Campaigns[0]
+	if c.Brand != nil && StatusClass(c.Brand.Status) != "success" {
+		return fmt.Sprintf("The campaign's brand is %s", strings.ToLower(c.Brand.Status))
+	}
+	return fmt.Sprintf("The campaign is %s", strings.ToLower(strings.Replace(c.Status, "_", " ", -1)))
+}
+
+// HasNumber returns true if pn is attached to the service.
+func (s *A2PService) HasNumber(pn twilio.PhoneNumber) bool {
+	for _, n := range s.PhoneNumbers {
+		if n == pn {
+			return true
+		}
+	}
+	return false
+}
+
+// ForNumber returns the messaging services pn is attached to.
+func (r *A2PRegistrations) ForNumber(pn twilio.PhoneNumber) []*A2PService {
+	var services []*A2PService
+	for _, s := range r.Services {
+		if s.HasNumber(pn) {
+			services = append(services, s)
+		}
+	}
+	return services
+}
+
+func newA2PBrand(b *a2pBrand) *A2PBrand {
+	return &A2PBrand{
+		Sid:            b.Sid,
+		Status:         b.Status,
+		BrandType:      b.BrandType,
+		TCRID:          b.TCRID.String,
+		IdentityStatus: b.IdentityStatus.String,
+		FailureReason:  b.FailureReason.String,
+		Updated:        b.DateUpdated.Time,
+	}
+}
+
+type a2pServicesByName []*A2PService
+
+func (s a2pServicesByName) Len() int           { return len(s) }
+func (s a2pServicesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s a2pServicesByName) Less(i, j int) bool { return s[i].FriendlyName < s[j].FriendlyName }
+
+// newA2PRegistrations returns the registrations, or config.PermissionDenied if
+// the user can't view messages. Registrations are exempt from max resource
+// age; a brand registered long ago still decides whether today's messages
+// are delivered.
+func newA2PRegistrations(r *a2pRegistrations, u *config.User) (*A2PRegistrations, error) {
+	if !u.CanViewMessages() {
+		return nil, config.PermissionDenied
+	}
+	regs := &A2PRegistrations{Partial: r.Partial}
+	brands := make(map[string]*A2PBrand)
+	for _, b := range r.Brands {
+		brand := newA2PBrand(b)
+		brands[b.Sid] = brand
+		regs.Brands = append(regs.Brands, brand)
+	}
+	for _, s := range r.Services {
+		svc := &A2PService{Sid: s.Sid, FriendlyName: s.FriendlyName, PartialNumbers: s.PhoneNumbersPartial}
+		for _, c := range s.Campaigns {
+			svc.Campaigns = append(svc.Campaigns, &A2PCampaign{
+				Sid:         c.Sid,
+				Brand:       brands[c.BrandRegistrationSid],
+				CampaignID:  c.CampaignID.String,
+				Status:      c.CampaignStatus,
+				UseCase:     c.UseCase,
+				Description: c.Description,
+				Updated:     c.DateUpdated.Time,
+			})
+		}
+		for _, pn := range s.PhoneNumbers {
+			svc.PhoneNumbers = append(svc.PhoneNumbers, pn.PhoneNumber)
+		}
+		regs.Services = append(regs.Services, svc)
+	}
+	sort.Sort(a2pServicesByName(regs.Services))
+	return regs, nil
+}
+
+// restClient makes requests to the Twilio API at base, like the Messaging
+// API, with the same credentials and HTTP client as vc.client. If vc.client
+// has been pointed somewhere other than the Twilio API, like a test server,
+// the requests go there too.
+func (vc *client) restClient(base string) *rest.Client {
+	if vc.client.Base != twilio.BaseURL {
+		base = vc.client.Base
+	}
+	rc := rest.NewClient(vc.client.Client.ID, vc.client.Client.Token, base)
+	rc.Client = vc.client.Client.Client
+	return rc
+}
+
+func getRestResource(ctx context.Context, rc *rest.Client, path string, data url.Values, v interface{}) error {
+	if strings.HasPrefix(path, rc.Base) {
+		path = path[len(rc.Base):]
+	}
+	if len(data) > 0 {
+		path = path + "?" + data.Encode()
+	}
+	req, err := rc.NewRequest("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	return rc.Do(req.WithContext(ctx), v)
+}
+
+func (vc *client) fetchA2PRegistrations(ctx context.Context) (*a2pRegistrations, error) {
+	rc := vc.restClient(messagingBaseURL)
+	regs := new(a2pRegistrations)
+	brands := new(a2pBrandPage)
+	if err := getRestResource(ctx, rc, "/v1/a2p/BrandRegistrations", url.Values{"PageSize": []string{"50"}}, brands); err != nil {
+		return nil, err
+	}
+	regs.Brands = brands.Brands
+	next := "/v1/Services"
+	data := url.Values{"PageSize": []string{"50"}}
+	for i := 0; next != ""; i++ {
+		if i == maxA2PServicePages {
+			regs.Partial = true
+			break
+		}
+		page := new(a2pServicePage)
+		if err := getRestResource(ctx, rc, next, data, page); err != nil {
+			return nil, err
+		}
+		regs.Services = append(regs.Services, page.Services...)
+		next, data = page.Meta.NextPageURL.String, nil
+	}
+	g, errctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, a2pConcurrency)
+	for _, s := range regs.Services {
+		s := s
+		g.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			campaigns := new(a2pCampaignPage)
+			if err := getRestResource(errctx, rc, "/v1/Services/"+s.Sid+"/Compliance/Usa2p", nil, campaigns); err != nil {
+				return err
+			}
+			s.Campaigns = campaigns.Campaigns
+			numbers := new(a2pPhoneNumberPage)
+			if err := getRestResource(errctx, rc, "/v1/Services/"+s.Sid+"/PhoneNumbers", url.Values{"PageSize": []string{"1000"}}, numbers); err != nil {
+				return err
+			}
+			s.PhoneNumbers = numbers.PhoneNumbers
+			s.PhoneNumbersPartial = numbers.Meta.NextPageURL.Valid && numbers.Meta.NextPageURL.String != ""
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return regs, nil
+}
+
+// GetA2PRegistrations returns the account's A2P 10DLC brands, campaigns and
+// the numbers attached to each messaging service. Looking these up takes two
+// requests for every messaging service, so the result is cached.
+func (vc *client) GetA2PRegistrations(ctx context.Context, u *config.User) (*A2PRegistrations, error) {
+	if !u.CanViewMessages() {
+		return nil, config.PermissionDenied
+	}
+	key := "a2p-registrations"
+	val, err := vc.group.Do(key, func() (interface{}, error) {
+		regs := new(a2pRegistrations)
+		t, err := vc.cache.Get(key, regs)
+		if err == nil {
+			return &CacheResult{t, regs}, nil
+		}
+		regs, err = vc.fetchA2PRegistrations(ctx)
+		if err != nil {
+			return nil, err
+		}
+		vc.cache.Set(key, regs, a2pTimeout)
+		return &CacheResult{Value: regs}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := val.(*CacheResult)
+	regs, err := newA2PRegistrations(result.Value.(*a2pRegistrations), u)
+	if err != nil {
+		return nil, err
+	}
+	if result.Time > 0 {
+		regs.Age = monotime.Since(result.Time)
+	}
+	return regs, nil
+}
@@ -0,0 +1,62 @@
+package views
+
+import (
+	"testing"
+
+	types "github.com/kevinburke/go-types"
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+func TestA2PServiceProblem(t *testing.T) {
+	t.Parallel()
+	raw := &a2pRegistrations{
+		Brands: []*a2pBrand{
+			{Sid: "BN1", Status: "APPROVED"},
+			{Sid: "BN2", Status: "FAILED", FailureReason: types.NullString{Valid: true, String: "Tax ID mismatch"}},
+		},
+		Services: []*a2pService{
+			{Sid: "MG1", FriendlyName: "Verified", PhoneNumbers: []*a2pPhoneNumber{{PhoneNumber: "+14105551234"}},
+				Campaigns: []*a2pCampaign{{Sid: "QE1", BrandRegistrationSid: "BN1", CampaignStatus: "VERIFIED"}}},
+			{Sid: "MG2", FriendlyName: "Bad brand",
+				Campaigns: []*a2pCampaign{{Sid: "QE2", BrandRegistrationSid: "BN2", CampaignStatus: "VERIFIED"}}},
+			{Sid: "MG3", FriendlyName: "Pending", PhoneNumbers: []*a2pPhoneNumber{{PhoneNumber: "+14105551234"}},
+				Campaigns: []*a2pCampaign{{Sid: "QE3", BrandRegistrationSid: "BN1", CampaignStatus: "IN_PROGRESS"}}},
+			{Sid: "MG4", FriendlyName: "Alerts"},
+		},
+	}
+	regs, err := newA2PRegistrations(raw, config.NewUser(config.AllUserSettings()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"MG1": "",
+		"MG2": "The campaign's brand is failed",
+		"MG3": "The campaign is in progress",
+		"MG4": "No A2P campaign is registered for this messaging service",
+	}
+	for _, s := range regs.Services {
+		if got := s.Problem(); got != want[s.Sid] {
+			t.Errorf("%s: expected problem %q, got %q", s.Sid, want[s.Sid], got)
+		}
+	}
+	if services := regs.ForNumber(twilio.PhoneNumber("+14105551234")); len(services) != 2 {
+		t.Errorf("expected the number to be in 2 services, got %d", len(services))
+	}
+
+	us := config.AllUserSettings()
+	us.CanViewMessages = false
+	if _, err := newA2PRegistrations(raw, config.NewUser(us)); err != config.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+}
+
+func TestIsA2PErrorCode(t *testing.T) {
+	t.Parallel()
+	if !IsA2PErrorCode(30034) {
+		t.Error("expected 30034 to be an A2P error")
+	}
+	if IsA2PErrorCode(30003) {
+		t.Error("expected 30003 not to be an A2P error")
+	}
+}
@@ -0,0 +1,747 @@
+package views
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	types "github.com/kevinburke/go-types"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+// AllAccounts is the value of the "Account" filter that lists messages, calls
+// or alerts from every account at once, newest first.
+const AllAccounts = "all"
+
+// The page size of an all accounts list, if the filters don't have one.
+const defaultMergedPageSize = 50
+
+// Resource sids whose account is remembered, so the pages for a subaccount's
+// message or call don't look in every account again. When there are more,
+// the list is cleared.
+const maxAccountOwners = 10000
+
+// An Account is a Twilio account Logrole lists resources from: the main
+// account, or one of its subaccounts.
+type Account struct {
+	Sid  string
+	Name string
+	// False if the account's alerts can't be listed. Twilio lists the alerts
+	// of the account that made the request, so a subaccount needs its own
+	// API key.
+	Alerts bool
+	Client Client
+}
+
+// An AccountLister can list messages, calls and alerts from more than one
+// account.
+type AccountLister interface {
+	// Accounts returns the accounts, starting with the main one.
+	Accounts() []*Account
+}
+
+// accountsClient sends requests for a subaccount's resources to that
+// subaccount, and everything else to the main account's Client.
+type accountsClient struct {
+	Client
+	accounts []*Account
+
+	mu     sync.Mutex
+	owners map[string]*Account
+}
+
+// NewAccountsClient returns a Client that lists messages, calls and alerts
+// from main, or from one of the subaccounts: the one named by sid in the
+// "Account" filter, or main if there isn't one. If the filter is AllAccounts,
+// the lists from every account are merged, newest first. Single messages,
+// calls and alerts are looked up in main, and then in each subaccount. If
+// there are no subaccounts, main's Client is returned.
+func NewAccountsClient(main *Account, subaccounts ...*Account) Client {
+	if len(subaccounts) == 0 {
+		return main.Client
+	}
+	accounts := make([]*Account, 1, len(subaccounts)+1)
+	accounts[0] = main
+	accounts = append(accounts, subaccounts...)
+	return &accountsClient{
+		Client:   main.Client,
+		accounts: accounts,
+		owners:   make(map[string]*Account),
+	}
+}
+
+func (vc *accountsClient) Accounts() []*Account {
+	return vc.accounts
+}
+
+func (vc *accountsClient) account(sid string) *Account {
+	for _, a := range vc.accounts {
+		if a.Sid == sid {
+			return a
+		}
+	}
+	return nil
+}
+
+// owner returns the account the resource with the given sid was last found
+// in, or the main account.
+func (vc *accountsClient) owner(sid string) *Account {
+	vc.mu.Lock()
+	a, ok := vc.owners[sid]
+	vc.mu.Unlock()
+	if !ok {
+		return vc.accounts[0]
+	}
+	return a
+}
+
+// setOwner remembers that the resource with the given sid is in a. Only
+// subaccounts are remembered, since the main account is the default.
+func (vc *accountsClient) setOwner(sid string, a *Account) {
+	if sid == "" || a == vc.accounts[0] {
+		return
+	}
+	vc.mu.Lock()
+	if len(vc.owners) >= maxAccountOwners {
+		vc.owners = make(map[string]*Account)
+	}
+	vc.owners[sid] = a
+	vc.mu.Unlock()
+}
+
+// listAccount returns the account named in data, and data without the
+// "Account" filter. If data asks for every account, the returned Account is
+// nil.
+func (vc *accountsClient) listAccount(data url.Values) (*Account, url.Values, error) {
+	sid := data.Get("Account")
+	filters := url.Values{}
+	for k, v := range data {
+		if k != "Account" {
+			filters[k] = v
+		}
+	}
+	if sid == "" {
+		return vc.accounts[0], filters, nil
+	}
+	if sid == AllAccounts {
+		return nil, filters, nil
+	}
+	a := vc.account(sid)
+	if a == nil {
+		return nil, nil, &rest.Error{
+			StatusCode: 400,
+			Title:      "Unknown account " + sid,
+		}
+	}
+	return a, filters, nil
+}
+
+// The next page URIs of a subaccount's lists end with the account's sid, so
+// the next page is fetched from the same account.
+func accountSuffix(a *Account) string {
+	return "&Account=" + a.Sid
+}
+
+// pageAccount returns the account the next page URI came from, and the URI
+// its Client gave out. If the URI is for a list of every account, the
+// returned Account is nil.
+func (vc *accountsClient) pageAccount(nextPage string) (*Account, string, error) {
+	u, err := url.Parse(nextPage)
+	if err != nil {
+		return nil, "", err
+	}
+	sid := u.Query().Get("Account")
+	if sid == "" {
+		return vc.accounts[0], nextPage, nil
+	}
+	if sid == AllAccounts {
+		return nil, nextPage, nil
+	}
+	a := vc.account(sid)
+	if a == nil {
+		return nil, "", &rest.Error{
+			StatusCode: 400,
+			Title:      "Unknown account " + sid,
+		}
+	}
+	return a, strings.TrimSuffix(nextPage, accountSuffix(a)), nil
+}
+
+// accountPageURI returns the next page URI to give out for a page of a's
+// list.
+func (vc *accountsClient) accountPageURI(a *Account, npuri types.NullString) types.NullString {
+	if !npuri.Valid || a == vc.accounts[0] {
+		return npuri
+	}
+	return types.NullString{Valid: true, String: npuri.String + accountSuffix(a)}
+}
+
+func isNotFound(err error) bool {
+	terr, ok := err.(*rest.Error)
+	return ok && terr.StatusCode == 404
+}
+
+// find calls get for the owner of sid, and then for every other account until
+// one doesn't return a 404, and returns the account the resource was found
+// in.
+func (vc *accountsClient) find(sid string, get func(*Account) error) (*Account, error) {
+	first := vc.owner(sid)
+	err := get(first)
+	if !isNotFound(err) {
+		return first, err
+	}
+	for _, a := range vc.accounts {
+		if a == first {
+			continue
+		}
+		if aerr := get(a); !isNotFound(aerr) {
+			if aerr == nil {
+				vc.setOwner(sid, a)
+			}
+			return a, aerr
+		}
+	}
+	return first, err
+}
+
+func (vc *accountsClient) GetMessage(ctx context.Context, user *config.User, sid string) (*Message, error) {
+	var msg *Message
+	a, err := vc.find(sid, func(a *Account) error {
+		var err error
+		msg, err = a.Client.GetMessage(ctx, user, sid)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg.account = a.Name
+	return msg, nil
+}
+
+func (vc *accountsClient) GetCall(ctx context.Context, user *config.User, sid string) (*Call, error) {
+	var call *Call
+	a, err := vc.find(sid, func(a *Account) error {
+		var err error
+		call, err = a.Client.GetCall(ctx, user, sid)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	call.account = a.Name
+	return call, nil
+}
+
+func (vc *accountsClient) GetAlert(ctx context.Context, user *config.User, sid string) (*Alert, error) {
+	var alert *Alert
+	a, err := vc.find(sid, func(a *Account) error {
+		if !a.Alerts {
+			return &rest.Error{StatusCode: 404, Title: "Alert not found"}
+		}
+		var err error
+		alert, err = a.Client.GetAlert(ctx, user, sid)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	alert.account = a.Name
+	return alert, nil
+}
+
+// The media, recordings, alerts, child calls and events of a message or call
+// come from the account it was found in.
+
+func (vc *accountsClient) GetMediaURLs(ctx context.Context, u *config.User, sid string) ([]*url.URL, error) {
+	return vc.owner(sid).Client.GetMediaURLs(ctx, u, sid)
+}
+
+func (vc *accountsClient) GetCallRecordings(ctx context.Context, user *config.User, sid string, data url.Values) (*RecordingPage, error) {
+	return vc.owner(sid).Client.GetCallRecordings(ctx, user, sid, data)
+}
+
+func (vc *accountsClient) GetCallAlerts(ctx context.Context, user *config.User, sid string) (*AlertPage, error) {
+	a := vc.owner(sid)
+	if !a.Alerts {
+		return &AlertPage{}, nil
+	}
+	return a.Client.GetCallAlerts(ctx, user, sid)
+}
+
+func (vc *accountsClient) GetMessageAlerts(ctx context.Context, user *config.User, sid string) (*AlertPage, error) {
+	a := vc.owner(sid)
+	if !a.Alerts {
+		return &AlertPage{}, nil
+	}
+	return a.Client.GetMessageAlerts(ctx, user, sid)
+}
+
+func (vc *accountsClient) GetChildCalls(ctx context.Context, user *config.User, sid string) (*CallPage, error) {
+	a := vc.owner(sid)
+	page, err := a.Client.GetChildCalls(ctx, user, sid)
+	if err != nil {
+		return nil, err
+	}
+	for _, call := range page.calls {
+		call.account = a.Name
+		vc.setOwner(call.call.Sid, a)
+	}
+	return page, nil
+}
+
+func (vc *accountsClient) LoadCallEvents(ctx context.Context, call *Call) error {
+	return vc.owner(call.call.Sid).Client.LoadCallEvents(ctx, call)
+}
+
+func (vc *accountsClient) GetResourceEvents(ctx context.Context, user *config.User, sid string) ([]*Event, error) {
+	return vc.owner(sid).Client.GetResourceEvents(ctx, user, sid)
+}
+
+// CacheCommonQueries keeps the first pages of every account's lists in the
+// cache.
+func (vc *accountsClient) CacheCommonQueries(pageSize uint, doneCh <-chan bool) {
+	for _, a := range vc.accounts[1:] {
+		go a.Client.CacheCommonQueries(pageSize, doneCh)
+	}
+	vc.Client.CacheCommonQueries(pageSize, doneCh)
+}
+
+func (vc *accountsClient) IsTwilioNumber(num twilio.PhoneNumber) bool {
+	for _, a := range vc.accounts {
+		if a.Client.IsTwilioNumber(num) {
+			return true
+		}
+	}
+	return false
+}
+
+// A mergeItem is a resource in an all accounts list, with the time the list
+// is sorted by.
+type mergeItem struct {
+	t       time.Time
+	account *Account
+	v       interface{}
+}
+
+// A mergeFetcher fetches a page of a's list. If uri is empty, it fetches the
+// first page described by filters.
+type mergeFetcher func(ctx context.Context, a *Account, uri string, filters url.Values) ([]mergeItem, types.NullString, error)
+
+// A mergeList is where an all accounts list has got to in one account's list.
+type mergeList struct {
+	account *Account
+	// The URI of the page being read, or empty for the first page, and the
+	// number of its resources that have been read.
+	uri  string
+	read int
+	done bool
+
+	fetched bool
+	items   []mergeItem
+	next    types.NullString
+}
+
+// fill fetches the page l is reading, moving on to the next page if every
+// resource on it has been read.
+func (l *mergeList) fill(ctx context.Context, fetch mergeFetcher, filters url.Values) error {
+	for !l.done {
+		if l.fetched {
+			if l.read < len(l.items) {
+				return nil
+			}
+			if !l.next.Valid {
+				l.done = true
+				return nil
+			}
+			l.uri, l.read = l.next.String, 0
+		}
+		items, next, err := fetch(ctx, l.account, l.uri, filters)
+		if err == twilio.NoMoreResults {
+			l.done = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		l.fetched = true
+		l.items, l.next = items, next
+	}
+	return nil
+}
+
+// A mergeCursor is where an all accounts list has got to in every account's
+// list. It's encoded in the list's next page URI.
+type mergeCursor struct {
+	Filters url.Values
+	Lists   []*mergeList
+}
+
+// newMergeCursor returns a cursor at the start of each account's list.
+func newMergeCursor(accounts []*Account, filters url.Values) *mergeCursor {
+	c := &mergeCursor{Filters: filters}
+	for _, a := range accounts {
+		c.Lists = append(c.Lists, &mergeList{account: a})
+	}
+	return c
+}
+
+// parseMergeCursor reads the cursor in an all accounts next page URI.
+func parseMergeCursor(accounts []*Account, nextPage string) (*mergeCursor, error) {
+	u, err := url.Parse(nextPage)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	c := &mergeCursor{Filters: url.Values{}}
+	for k, v := range query {
+		if k != "Account" && !strings.HasPrefix(k, "Page.") && !strings.HasPrefix(k, "Read.") && !strings.HasPrefix(k, "Done.") {
+			c.Filters[k] = v
+		}
+	}
+	for _, a := range accounts {
+		if _, ok := query["Page."+a.Sid]; !ok && query.Get("Done."+a.Sid) == "" {
+			continue
+		}
+		l := &mergeList{account: a, uri: query.Get("Page." + a.Sid)}
+		l.done = query.Get("Done."+a.Sid) != ""
+		if read := query.Get("Read." + a.Sid); read != "" {
+			l.read, err = strconv.Atoi(read)
+			if err != nil || l.read < 0 {
+				return nil, &rest.Error{StatusCode: 400, Title: "Invalid next page uri"}
+			}
+		}
+		c.Lists = append(c.Lists, l)
+	}
+	return c, nil
+}
+
+// pageURI returns the next page URI for the cursor, or an invalid NullString
+// if every list has been read.
+func (c *mergeCursor) pageURI(resource string) types.NullString {
+	query := url.Values{}
+	for k, v := range c.Filters {
+		query[k] = v
+	}
+	query.Set("Account", AllAccounts)
+	more := false
+	for _, l := range c.Lists {
+		if l.done || (l.fetched && l.read >= len(l.items) && !l.next.Valid) {
+			query.Set("Done."+l.account.Sid, "true")
+			continue
+		}
+		more = true
+		query.Set("Page."+l.account.Sid, l.uri)
+		query.Set("Read."+l.account.Sid, strconv.Itoa(l.read))
+	}
+	if !more {
+		return types.NullString{}
+	}
+	return types.NullString{
+		Valid:  true,
+		String: "/" + twilio.APIVersion + "/Accounts/" + AllAccounts + "/" + resource + ".json?" + query.Encode(),
+	}
+}
+
+// merge reads the next page of the all accounts list at c, fetching the
+// pages each account's list is at in parallel, and returns the resources on
+// it, newest first.
+func (c *mergeCursor) merge(ctx context.Context, fetch mergeFetcher) ([]mergeItem, error) {
+	pageSize := defaultMergedPageSize
+	if ps, err := strconv.Atoi(c.Filters.Get("PageSize")); err == nil && ps > 0 {
+		pageSize = ps
+	}
+	g, errctx := errgroup.WithContext(ctx)
+	for _, l := range c.Lists {
+		l := l
+		g.Go(func() error {
+			return l.fill(errctx, fetch, c.Filters)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	items := make([]mergeItem, 0, pageSize)
+	for len(items) < pageSize {
+		var newest *mergeList
+		for _, l := range c.Lists {
+			if err := l.fill(ctx, fetch, c.Filters); err != nil {
+				return nil, err
+			}
+			if l.done {
+				continue
+			}
+			if newest == nil || l.items[l.read].t.After(newest.items[newest.read].t) {
+				newest = l
+			}
+		}
+		if newest == nil {
+			break
+		}
+		items = append(items, newest.items[newest.read])
+		newest.read++
+	}
+	return items, nil
+}
+
+// The fetchers for each account's list. Each resource is marked with the
+// account it came from.
+
+func (vc *accountsClient) messageFetcher(user *config.User, start, end time.Time) mergeFetcher {
+	return func(ctx context.Context, a *Account, uri string, filters url.Values) ([]mergeItem, types.NullString, error) {
+		var page *MessagePage
+		var err error
+		if uri == "" {
+			page, _, err = a.Client.GetMessagePageInRange(ctx, user, start, end, filters)
+		} else {
+			page, _, err = a.Client.GetNextMessagePageInRange(ctx, user, start, end, uri)
+		}
+		if err != nil {
+			return nil, types.NullString{}, err
+		}
+		items := make([]mergeItem, len(page.messages))
+		for i, msg := range page.messages {
+			msg.account = a.Name
+			items[i] = mergeItem{t: msg.message.DateCreated.Time, account: a, v: msg}
+		}
+		return items, page.nextPageURI, nil
+	}
+}
+
+func (vc *accountsClient) callFetcher(user *config.User, start, end time.Time) mergeFetcher {
+	return func(ctx context.Context, a *Account, uri string, filters url.Values) ([]mergeItem, types.NullString, error) {
+		var page *CallPage
+		var err error
+		if uri == "" {
+			page, _, err = a.Client.GetCallPageInRange(ctx, user, start, end, filters)
+		} else {
+			page, _, err = a.Client.GetNextCallPageInRange(ctx, user, start, end, uri)
+		}
+		if err != nil {
+			return nil, types.NullString{}, err
+		}
+		items := make([]mergeItem, len(page.calls))
+		for i, call := range page.calls {
+			call.account = a.Name
+			items[i] = mergeItem{t: callTime(call.call).Time, account: a, v: call}
+		}
+		return items, page.nextPageURI, nil
+	}
+}
+
+func (vc *accountsClient) alertFetcher(user *config.User, start, end time.Time) mergeFetcher {
+	return func(ctx context.Context, a *Account, uri string, filters url.Values) ([]mergeItem, types.NullString, error) {
+		var page *AlertPage
+		var err error
+		if uri == "" {
+			page, _, err = a.Client.GetAlertPageInRange(ctx, user, start, end, filters)
+		} else {
+			page, _, err = a.Client.GetNextAlertPageInRange(ctx, user, start, end, uri)
+		}
+		if err != nil {
+			return nil, types.NullString{}, err
+		}
+		items := make([]mergeItem, len(page.alerts))
+		for i, alert := range page.alerts {
+			alert.account = a.Name
+			items[i] = mergeItem{t: alert.alert.DateCreated.Time, account: a, v: alert}
+		}
+		return items, page.nextPageURI, nil
+	}
+}
+
+// noAlerts is the error for a list of the alerts of an account that can't
+// list them.
+func noAlerts(a *Account) error {
+	return &rest.Error{
+		StatusCode: 400,
+		Title:      "Alerts can't be listed for " + a.Name + " without an API key for it",
+	}
+}
+
+// alertAccounts returns the accounts whose alerts can be listed.
+func (vc *accountsClient) alertAccounts() []*Account {
+	accounts := make([]*Account, 0, len(vc.accounts))
+	for _, a := range vc.accounts {
+		if a.Alerts {
+			accounts = append(accounts, a)
+		}
+	}
+	return accounts
+}
+
+func (vc *accountsClient) mergedMessages(ctx context.Context, user *config.User, c *mergeCursor, start, end time.Time) (*MessagePage, uint64, error) {
+	items, err := c.merge(ctx, vc.messageFetcher(user, start, end))
+	if err != nil {
+		return nil, 0, err
+	}
+	page := &MessagePage{messages: make([]*Message, len(items)), nextPageURI: c.pageURI("Messages")}
+	for i, item := range items {
+		page.messages[i] = item.v.(*Message)
+		vc.setOwner(page.messages[i].message.Sid, item.account)
+	}
+	return page, 0, nil
+}
+
+func (vc *accountsClient) accountMessages(a *Account, page *MessagePage) *MessagePage {
+	for _, msg := range page.messages {
+		msg.account = a.Name
+		vc.setOwner(msg.message.Sid, a)
+	}
+	page.nextPageURI = vc.accountPageURI(a, page.nextPageURI)
+	return page
+}
+
+func (vc *accountsClient) GetMessagePageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, data url.Values) (*MessagePage, uint64, error) {
+	a, filters, err := vc.listAccount(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if a == nil {
+		return vc.mergedMessages(ctx, user, newMergeCursor(vc.accounts, filters), start, end)
+	}
+	page, cachedAt, err := a.Client.GetMessagePageInRange(ctx, user, start, end, filters)
+	if err != nil {
+		return nil, 0, err
+	}
+	return vc.accountMessages(a, page), cachedAt, nil
+}
+
+func (vc *accountsClient) GetNextMessagePageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, nextPage string) (*MessagePage, uint64, error) {
+	a, uri, err := vc.pageAccount(nextPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	if a == nil {
+		c, err := parseMergeCursor(vc.accounts, nextPage)
+		if err != nil {
+			return nil, 0, err
+		}
+		return vc.mergedMessages(ctx, user, c, start, end)
+	}
+	page, cachedAt, err := a.Client.GetNextMessagePageInRange(ctx, user, start, end, uri)
+	if err != nil {
+		return nil, 0, err
+	}
+	return vc.accountMessages(a, page), cachedAt, nil
+}
+
+func (vc *accountsClient) mergedCalls(ctx context.Context, user *config.User, c *mergeCursor, start, end time.Time) (*CallPage, uint64, error) {
+	items, err := c.merge(ctx, vc.callFetcher(user, start, end))
+	if err != nil {
+		return nil, 0, err
+	}
+	page := &CallPage{calls: make([]*Call, len(items)), nextPageURI: c.pageURI("Calls")}
+	for i, item := range items {
+		page.calls[i] = item.v.(*Call)
+		vc.setOwner(page.calls[i].call.Sid, item.account)
+	}
+	return page, 0, nil
+}
+
+func (vc *accountsClient) accountCalls(a *Account, page *CallPage) *CallPage {
+	for _, call := range page.calls {
+		call.account = a.Name
+		vc.setOwner(call.call.Sid, a)
+	}
+	page.nextPageURI = vc.accountPageURI(a, page.nextPageURI)
+	return page
+}
+
+func (vc *accountsClient) GetCallPageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, data url.Values) (*CallPage, uint64, error) {
+	a, filters, err := vc.listAccount(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if a == nil {
+		return vc.mergedCalls(ctx, user, newMergeCursor(vc.accounts, filters), start, end)
+	}
+	page, cachedAt, err := a.Client.GetCallPageInRange(ctx, user, start, end, filters)
+	if err != nil {
+		return nil, 0, err
+	}
+	return vc.accountCalls(a, page), cachedAt, nil
+}
+
+func (vc *accountsClient) GetNextCallPageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, nextPage string) (*CallPage, uint64, error) {
+	a, uri, err := vc.pageAccount(nextPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	if a == nil {
+		c, err := parseMergeCursor(vc.accounts, nextPage)
+		if err != nil {
+			return nil, 0, err
+		}
+		return vc.mergedCalls(ctx, user, c, start, end)
+	}
+	page, cachedAt, err := a.Client.GetNextCallPageInRange(ctx, user, start, end, uri)
+	if err != nil {
+		return nil, 0, err
+	}
+	return vc.accountCalls(a, page), cachedAt, nil
+}
+
+func (vc *accountsClient) mergedAlerts(ctx context.Context, user *config.User, c *mergeCursor, start, end time.Time) (*AlertPage, uint64, error) {
+	items, err := c.merge(ctx, vc.alertFetcher(user, start, end))
+	if err != nil {
+		return nil, 0, err
+	}
+	page := &AlertPage{alerts: make([]*Alert, len(items)), nextPageURI: c.pageURI("Alerts")}
+	for i, item := range items {
+		page.alerts[i] = item.v.(*Alert)
+		vc.setOwner(page.alerts[i].alert.Sid, item.account)
+	}
+	return page, 0, nil
+}
+
+func (vc *accountsClient) accountAlerts(a *Account, page *AlertPage) *AlertPage {
+	for _, alert := range page.alerts {
+		alert.account = a.Name
+		vc.setOwner(alert.alert.Sid, a)
+	}
+	page.nextPageURI = vc.accountPageURI(a, page.nextPageURI)
+	return page
+}
+
+func (vc *accountsClient) GetAlertPageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, data url.Values) (*AlertPage, uint64, error) {
+	a, filters, err := vc.listAccount(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if a == nil {
+		return vc.mergedAlerts(ctx, user, newMergeCursor(vc.alertAccounts(), filters), start, end)
+	}
+	if !a.Alerts {
+		return nil, 0, noAlerts(a)
+	}
+	page, cachedAt, err := a.Client.GetAlertPageInRange(ctx, user, start, end, filters)
+	if err != nil {
+		return nil, 0, err
+	}
+	return vc.accountAlerts(a, page), cachedAt, nil
+}
+
+func (vc *accountsClient) GetNextAlertPageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, nextPage string) (*AlertPage, uint64, error) {
+	a, uri, err := vc.pageAccount(nextPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	if a == nil {
+		c, err := parseMergeCursor(vc.alertAccounts(), nextPage)
+		if err != nil {
+			return nil, 0, err
+		}
+		return vc.mergedAlerts(ctx, user, c, start, end)
+	}
+	page, cachedAt, err := a.Client.GetNextAlertPageInRange(ctx, user, start, end, uri)
+	if err != nil {
+		return nil, 0, err
+	}
+	return vc.accountAlerts(a, page), cachedAt, nil
+}
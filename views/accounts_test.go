@@ -0,0 +1,189 @@
+package views
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	types "github.com/kevinburke/go-types"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+// accountTestClient serves one account's messages, newest first, in pages of
+// the size in the filters.
+type accountTestClient struct {
+	Client
+	sid      string
+	messages []*Message
+	gets     int
+}
+
+func (c *accountTestClient) page(i, size int) (*MessagePage, uint64, error) {
+	first := i * size
+	if first >= len(c.messages) {
+		return nil, 0, twilio.NoMoreResults
+	}
+	last := first + size
+	page := &MessagePage{}
+	if last < len(c.messages) {
+		query := url.Values{"Page": []string{strconv.Itoa(i + 1)}, "PageSize": []string{strconv.Itoa(size)}}
+		page.nextPageURI = types.NullString{Valid: true, String: "/" + twilio.APIVersion + "/Accounts/" + c.sid + "/Messages.json?" + query.Encode()}
+	} else {
+		last = len(c.messages)
+	}
+	page.messages = c.messages[first:last]
+	return page, 0, nil
+}
+
+func (c *accountTestClient) GetMessagePageInRange(ctx context.Context, u *config.User, start, end time.Time, data url.Values) (*MessagePage, uint64, error) {
+	if data.Get("Account") != "" {
+		return nil, 0, &rest.Error{StatusCode: 400, Title: "Account filter passed to Twilio"}
+	}
+	size, _ := strconv.Atoi(data.Get("PageSize"))
+	return c.page(0, size)
+}
+
+func (c *accountTestClient) GetNextMessagePageInRange(ctx context.Context, u *config.User, start, end time.Time, next string) (*MessagePage, uint64, error) {
+	if !strings.HasPrefix(next, "/"+twilio.APIVersion+"/Accounts/"+c.sid+"/") || strings.Contains(next, "Account=") {
+		return nil, 0, &rest.Error{StatusCode: 400, Title: "Wrong next page URI " + next}
+	}
+	nu, err := url.Parse(next)
+	if err != nil {
+		return nil, 0, err
+	}
+	i, _ := strconv.Atoi(nu.Query().Get("Page"))
+	size, _ := strconv.Atoi(nu.Query().Get("PageSize"))
+	return c.page(i, size)
+}
+
+func (c *accountTestClient) GetMessage(ctx context.Context, u *config.User, sid string) (*Message, error) {
+	c.gets++
+	for _, msg := range c.messages {
+		if msg.message.Sid == sid {
+			return msg, nil
+		}
+	}
+	return nil, &rest.Error{StatusCode: 404, Title: "Message not found"}
+}
+
+// newAccountsTestClient returns a client for a main account and a
+// subaccount, whose messages were sent the given numbers of minutes ago.
+// Each message's sid is "SM" and its number of minutes.
+func newAccountsTestClient(t *testing.T, main, sub []int) (Client, *accountTestClient, *accountTestClient) {
+	now := time.Now().UTC()
+	u := config.NewUser(config.AllUserSettings())
+	newClient := func(sid string, minutes []int) *accountTestClient {
+		c := &accountTestClient{sid: sid}
+		for _, m := range minutes {
+			tmsg := &twilio.Message{Sid: "SM" + strconv.Itoa(m), DateCreated: twilio.TwilioTime{Valid: true, Time: now.Add(-time.Duration(m) * time.Minute)}}
+			msg, err := NewMessage(tmsg, config.NewPermission(time.Hour), u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.messages = append(c.messages, msg)
+		}
+		return c
+	}
+	mc := newClient("AC1", main)
+	sc := newClient("AC2", sub)
+	vc := NewAccountsClient(&Account{Sid: "AC1", Name: "Main account", Client: mc},
+		&Account{Sid: "AC2", Name: "Support", Client: sc})
+	return vc, mc, sc
+}
+
+func TestAccountsMergedMessages(t *testing.T) {
+	t.Parallel()
+	vc, _, _ := newAccountsTestClient(t, []int{1, 4, 5, 8}, []int{2, 3, 6, 7, 9})
+	ctx := context.Background()
+	page, _, err := vc.GetMessagePageInRange(ctx, nil, twilio.Epoch, twilio.HeatDeath, url.Values{"Account": []string{AllAccounts}, "PageSize": []string{"3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sids, accounts []string
+	pages := 1
+	for {
+		for _, msg := range page.Messages() {
+			sids = append(sids, msg.message.Sid)
+			accounts = append(accounts, msg.Account())
+		}
+		next := page.NextPageURI()
+		if !next.Valid {
+			break
+		}
+		if !strings.HasPrefix(next.String, "/"+twilio.APIVersion) {
+			t.Fatalf("expected a next page URI like Twilio's, got %s", next.String)
+		}
+		if len(page.Messages()) != 3 {
+			t.Errorf("expected every page but the last to be full, got %d messages", len(page.Messages()))
+		}
+		page, _, err = vc.GetNextMessagePageInRange(ctx, nil, twilio.Epoch, twilio.HeatDeath, next.String)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+	}
+	want := "SM1,SM2,SM3,SM4,SM5,SM6,SM7,SM8,SM9"
+	if got := strings.Join(sids, ","); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+	if pages != 3 {
+		t.Errorf("expected 3 pages, got %d", pages)
+	}
+	if accounts[0] != "Main account" || accounts[1] != "Support" {
+		t.Errorf("expected messages to be marked with their account, got %v", accounts)
+	}
+}
+
+func TestAccountsSubaccountMessages(t *testing.T) {
+	t.Parallel()
+	vc, _, _ := newAccountsTestClient(t, []int{1}, []int{2, 3, 4})
+	ctx := context.Background()
+	page, _, err := vc.GetMessagePageInRange(ctx, nil, twilio.Epoch, twilio.HeatDeath, url.Values{"Account": []string{"AC2"}, "PageSize": []string{"2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages()) != 2 || page.Messages()[0].Account() != "Support" {
+		t.Fatalf("expected the subaccount's messages, got %d", len(page.Messages()))
+	}
+	next := page.NextPageURI()
+	if !strings.HasSuffix(next.String, "&Account=AC2") {
+		t.Fatalf("expected the next page URI to name the subaccount, got %s", next.String)
+	}
+	page, _, err = vc.GetNextMessagePageInRange(ctx, nil, twilio.Epoch, twilio.HeatDeath, next.String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages()) != 1 || page.Messages()[0].message.Sid != "SM4" {
+		t.Errorf("expected the subaccount's second page, got %d messages", len(page.Messages()))
+	}
+	if _, _, err := vc.GetMessagePageInRange(ctx, nil, twilio.Epoch, twilio.HeatDeath, url.Values{"Account": []string{"AC3"}}); err == nil {
+		t.Error("expected an unknown account to be an error")
+	}
+}
+
+func TestAccountsGetMessage(t *testing.T) {
+	t.Parallel()
+	vc, mc, sc := newAccountsTestClient(t, []int{1}, []int{2})
+	ctx := context.Background()
+	msg, err := vc.GetMessage(ctx, nil, "SM2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Account() != "Support" {
+		t.Errorf("expected the message to be found in the subaccount, got %q", msg.Account())
+	}
+	if _, err := vc.GetMessage(ctx, nil, "SM2"); err != nil {
+		t.Fatal(err)
+	}
+	if mc.gets != 1 || sc.gets != 2 {
+		t.Errorf("expected the subaccount to be remembered, got %d and %d lookups", mc.gets, sc.gets)
+	}
+	if _, err := vc.GetMessage(ctx, nil, "SM3"); !isNotFound(err) {
+		t.Errorf("expected a missing message to be a 404, got %v", err)
+	}
+}
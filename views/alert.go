@@ -3,6 +3,7 @@ package views
 import (
 	"errors"
 	"strings"
+	"time"
 
 	types "github.com/kevinburke/go-types"
 	twilio "github.com/saintpete/twilio-go"
@@ -16,30 +17,66 @@ type AlertPage struct {
 }
 
 type Alert struct {
-	user  *config.User
+	user *config.User
+	// The user's permissions when the alert was created.
+	perms *config.PermissionSnapshot
 	alert *twilio.Alert
+	// Hides configured patterns in the request and response. May be nil.
+	redactor *config.Redactor
+	// The name of the Twilio account the alert belongs to, if Logrole lists
+	// more than one.
+	account string
 }
 
 func NewAlert(alert *twilio.Alert, p *config.Permission, u *config.User) (*Alert, error) {
 	if u.CanViewAlerts() == false {
 		return nil, config.PermissionDenied
 	}
+	return newAlert(alert, p, u.Snapshot(p.MaxResourceAge(), time.Now()), u)
+}
+
+// newAlert creates an Alert for a user who can view alerts, with their
+// permissions in perms.
+func newAlert(alert *twilio.Alert, p *config.Permission, perms *config.PermissionSnapshot, u *config.User) (*Alert, error) {
 	if alert.DateCreated.Valid == false {
 		return nil, errors.New("Invalid DateCreated for alert")
 	}
-	if !u.CanViewResource(alert.DateCreated.Time, p.MaxResourceAge()) {
+	if !perms.CanViewResource(alert.DateCreated.Time) {
 		return nil, config.ErrTooOld
 	}
-	return &Alert{user: u, alert: alert}, nil
+	if p.Blocklist().Hides(u, alertNumbers(alert)...) {
+		return nil, config.PermissionDenied
+	}
+	return &Alert{user: u, perms: perms, alert: alert, redactor: p.AlertRedactor()}, nil
+}
+
+// alertNumbers returns the phone numbers in the webhook request that raised
+// the alert, if any.
+func alertNumbers(alert *twilio.Alert) []string {
+	vals := alert.RequestVariables.Values
+	numbers := make([]string, 0, 4)
+	for _, key := range []string{"From", "To", "Caller", "Called"} {
+		if n := vals.Get(key); n != "" {
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers
+}
+
+// Account returns the name of the Twilio account the alert belongs to, or the
+// empty string if Logrole only lists one account.
+func (a *Alert) Account() string {
+	return a.account
 }
 
 func NewAlertPage(ap *twilio.AlertPage, p *config.Permission, u *config.User) (*AlertPage, error) {
 	if u.CanViewAlerts() == false {
 		return nil, config.PermissionDenied
 	}
-	alerts := make([]*Alert, 0)
+	perms := u.Snapshot(p.MaxResourceAge(), time.Now())
+	alerts := make([]*Alert, 0, len(ap.Alerts))
 	for _, alert := range ap.Alerts {
-		cl, err := NewAlert(alert, p, u)
+		cl, err := newAlert(alert, p, perms, u)
 		if err == config.ErrTooOld || err == config.PermissionDenied {
 			continue
 		}
@@ -95,16 +132,28 @@ func (ap *AlertPage) ShowHeader(fieldName string) bool {
 }
 
 func (c *Alert) CanViewProperty(property string) bool {
+	return c.perms.Has(alertPermission(property))
+}
+
+// HiddenReason explains why property is hidden, if the user is debugging
+// permissions.
+func (c *Alert) HiddenReason(property string) string {
 	if c.user == nil {
-		return false
+		return ""
 	}
+	return c.user.HiddenReason(alertPermission(property))
+}
+
+// alertPermission returns the permission needed to view property.
+func alertPermission(property string) string {
 	switch property {
 	case "Sid", "ErrorCode", "MoreInfo", "DateCreated", "DateUpdated",
 		"ResourceSid", "LogLevel", "ServiceSid":
-		return c.user.CanViewAlerts()
-	case "RequestURL", "RequestMethod", "RequestVariables", "AlertText",
-		"ResponseHeaders", "ResponseBody":
-		return c.user.CanViewCallbackURLs()
+		return "can_view_alerts"
+	case "RequestURL", "RequestMethod", "AlertText":
+		return "can_view_callback_urls"
+	case "RequestVariables", "ResponseHeaders", "ResponseBody":
+		return "can_view_alert_payloads"
 	default:
 		panic("unknown property " + property)
 	}
@@ -116,14 +165,14 @@ func (a *Alert) CanViewDescription() bool {
 
 func (a *Alert) Description() (string, error) {
 	if a.CanViewDescription() {
-		return a.alert.Description(), nil
+		return a.redactor.Redact(a.alert.Description()), nil
 	} else {
 		return "", config.PermissionDenied
 	}
 }
 
 func (a *Alert) CanViewStatusCode() bool {
-	return a.user.CanViewCallbackURLs()
+	return a.perms.Has("can_view_callback_urls")
 }
 
 func (a *Alert) StatusCode() (int, error) {
@@ -149,15 +198,15 @@ func (a *Alert) ResourceSid() (string, error) {
 		sid := a.alert.ResourceSid
 		switch {
 		case strings.HasPrefix(sid, "CA"):
-			if a.user.CanViewCalls() {
+			if a.perms.Has("can_view_calls") {
 				return sid, nil
 			}
 		case strings.HasPrefix(sid, "SM") || strings.HasPrefix(sid, "MM"):
-			if a.user.CanViewMessages() {
+			if a.perms.Has("can_view_messages") {
 				return sid, nil
 			}
 		case strings.HasPrefix(sid, "CF"):
-			if a.user.CanViewConferences() {
+			if a.perms.Has("can_view_conferences") {
 				return sid, nil
 			}
 		default:
@@ -203,7 +252,7 @@ func (a *Alert) RequestMethod() (string, error) {
 
 func (a *Alert) RequestVariables() (twilio.Values, error) {
 	if a.CanViewProperty("RequestVariables") {
-		return a.alert.RequestVariables, nil
+		return twilio.Values{Values: a.redactor.RedactValues(a.alert.RequestVariables.Values)}, nil
 	} else {
 		return twilio.Values{}, config.PermissionDenied
 	}
@@ -211,7 +260,7 @@ func (a *Alert) RequestVariables() (twilio.Values, error) {
 
 func (a *Alert) ResponseHeaders() (twilio.Values, error) {
 	if a.CanViewProperty("ResponseHeaders") {
-		return a.alert.ResponseHeaders, nil
+		return twilio.Values{Values: a.redactor.RedactValues(a.alert.ResponseHeaders.Values)}, nil
 	} else {
 		return twilio.Values{}, config.PermissionDenied
 	}
@@ -251,7 +300,7 @@ func (a *Alert) DateUpdated() (twilio.TwilioTime, error) {
 
 func (a *Alert) ResponseBody() (string, error) {
 	if a.CanViewProperty("ResponseBody") {
-		return a.alert.ResponseBody, nil
+		return a.redactor.Redact(a.alert.ResponseBody), nil
 	} else {
 		return "", config.PermissionDenied
 	}
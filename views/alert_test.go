@@ -1,6 +1,7 @@
 package views
 
 import (
+	"net/url"
 	"testing"
 	"time"
 
@@ -34,3 +35,51 @@ func TestViewResourceSid(t *testing.T) {
 		t.Errorf("wrong Sid")
 	}
 }
+
+func TestAlertPayloads(t *testing.T) {
+	redactor, err := config.NewRedactor([]string{`\b\d{16}\b`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	permission := config.NewPermission(2 * time.Hour).WithAlertRedactor(redactor)
+	talert := &twilio.Alert{
+		Sid:              "NO123",
+		DateCreated:      twilio.TwilioTime{Valid: true, Time: time.Now()},
+		RequestURL:       "https://example.com/sms",
+		RequestVariables: twilio.Values{Values: url.Values{"Body": []string{"card 4111111111111111"}}},
+		ResponseBody:     "Charged 4111111111111111",
+	}
+	s := config.AllUserSettings()
+	alert, err := NewAlert(talert, permission, config.NewUser(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals, err := alert.RequestVariables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := vals.Get("Body"); got != "card [redacted]" {
+		t.Errorf("expected redacted Body, got %q", got)
+	}
+	if body, _ := alert.ResponseBody(); body != "Charged [redacted]" {
+		t.Errorf("expected redacted response body, got %q", body)
+	}
+	if talert.RequestVariables.Get("Body") != "card 4111111111111111" {
+		t.Errorf("redacting changed the underlying alert")
+	}
+
+	s.CanViewAlertPayloads = false
+	alert, err = NewAlert(talert, permission, config.NewUser(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alert.ResponseBody(); err != config.PermissionDenied {
+		t.Errorf("expected PermissionDenied for the response body, got %v", err)
+	}
+	if _, err := alert.RequestVariables(); err != config.PermissionDenied {
+		t.Errorf("expected PermissionDenied for request variables, got %v", err)
+	}
+	if u, err := alert.RequestURL(); err != nil || u != "https://example.com/sms" {
+		t.Errorf("expected to see the request URL, got %q, %v", u, err)
+	}
+}
@@ -0,0 +1,544 @@
+package views
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	types "github.com/kevinburke/go-types"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+// The files NewArchiveClient reads from the archive directory. Each file has
+// one resource per line, in the format the Twilio API returns it. Missing
+// files are treated as empty.
+const (
+	ArchiveMessagesFile    = "messages.ndjson"
+	ArchiveCallsFile       = "calls.ndjson"
+	ArchiveConferencesFile = "conferences.ndjson"
+	ArchiveAlertsFile      = "alerts.ndjson"
+	ArchiveNumbersFile     = "incoming_phone_numbers.ndjson"
+)
+
+// Twilio's default and maximum page sizes.
+const archiveDefaultPageSize = 50
+const archiveMaxPageSize = 1000
+
+// archiveClient serves resources from an archive exported before the account
+// was closed, or from fixtures. Resources still pass through the same
+// permission checks as resources from the Twilio API.
+type archiveClient struct {
+	log.Logger
+	accountSid string
+	permission *config.Permission
+	// Where the resources came from, for errors, like "the archive".
+	source string
+
+	// All sorted newest first.
+	messages    []*twilio.Message
+	calls       []*twilio.Call
+	conferences []*twilio.Conference
+	alerts      []*twilio.Alert
+	numbers     []*twilio.IncomingPhoneNumber
+
+	numberSet map[twilio.PhoneNumber]bool
+}
+
+// NewArchiveClient loads the archive in dir and returns a Client that serves
+// resources from it, instead of from the Twilio API. accountSid is the
+// account the archive was exported from.
+func NewArchiveClient(l log.Logger, dir string, accountSid string, p *config.Permission) (Client, error) {
+	f := new(Fixtures)
+	err := readNDJSON(filepath.Join(dir, ArchiveMessagesFile), func() interface{} {
+		m := new(twilio.Message)
+		f.Messages = append(f.Messages, m)
+		return m
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = readNDJSON(filepath.Join(dir, ArchiveCallsFile), func() interface{} {
+		c := new(twilio.Call)
+		f.Calls = append(f.Calls, c)
+		return c
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = readNDJSON(filepath.Join(dir, ArchiveConferencesFile), func() interface{} {
+		c := new(twilio.Conference)
+		f.Conferences = append(f.Conferences, c)
+		return c
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = readNDJSON(filepath.Join(dir, ArchiveAlertsFile), func() interface{} {
+		a := new(twilio.Alert)
+		f.Alerts = append(f.Alerts, a)
+		return a
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = readNDJSON(filepath.Join(dir, ArchiveNumbersFile), func() interface{} {
+		n := new(twilio.IncomingPhoneNumber)
+		f.Numbers = append(f.Numbers, n)
+		return n
+	})
+	if err != nil {
+		return nil, err
+	}
+	vc := newArchiveClient(l, accountSid, p, f, "the archive")
+	l.Info("Loaded archive", "dir", dir, "messages", len(vc.messages),
+		"calls", len(vc.calls), "conferences", len(vc.conferences),
+		"alerts", len(vc.alerts), "numbers", len(vc.numbers))
+	return vc, nil
+}
+
+// newArchiveClient returns an archiveClient serving copies of the resources
+// in f, sorted newest first.
+func newArchiveClient(l log.Logger, accountSid string, p *config.Permission, f *Fixtures, source string) *archiveClient {
+	vc := &archiveClient{
+		Logger:      l,
+		accountSid:  accountSid,
+		permission:  p,
+		source:      source,
+		messages:    append([]*twilio.Message{}, f.Messages...),
+		calls:       append([]*twilio.Call{}, f.Calls...),
+		conferences: append([]*twilio.Conference{}, f.Conferences...),
+		alerts:      append([]*twilio.Alert{}, f.Alerts...),
+		numbers:     append([]*twilio.IncomingPhoneNumber{}, f.Numbers...),
+		numberSet:   make(map[twilio.PhoneNumber]bool),
+	}
+	sort.Stable(newestFirst{len(vc.messages), func(i int) twilio.TwilioTime { return vc.messages[i].DateCreated }, func(i, j int) {
+		vc.messages[i], vc.messages[j] = vc.messages[j], vc.messages[i]
+	}})
+	sort.Stable(newestFirst{len(vc.calls), func(i int) twilio.TwilioTime { return callTime(vc.calls[i]) }, func(i, j int) {
+		vc.calls[i], vc.calls[j] = vc.calls[j], vc.calls[i]
+	}})
+	sort.Stable(newestFirst{len(vc.conferences), func(i int) twilio.TwilioTime { return vc.conferences[i].DateCreated }, func(i, j int) {
+		vc.conferences[i], vc.conferences[j] = vc.conferences[j], vc.conferences[i]
+	}})
+	sort.Stable(newestFirst{len(vc.alerts), func(i int) twilio.TwilioTime { return vc.alerts[i].DateCreated }, func(i, j int) {
+		vc.alerts[i], vc.alerts[j] = vc.alerts[j], vc.alerts[i]
+	}})
+	sort.Stable(newestFirst{len(vc.numbers), func(i int) twilio.TwilioTime { return vc.numbers[i].DateCreated }, func(i, j int) {
+		vc.numbers[i], vc.numbers[j] = vc.numbers[j], vc.numbers[i]
+	}})
+	for _, n := range vc.numbers {
+		vc.numberSet[n.PhoneNumber] = true
+	}
+	return vc
+}
+
+// readNDJSON decodes every record in the file at path into a value returned
+// by next. A missing file has no records.
+func readNDJSON(path string, next func() interface{}) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for i := 1; ; i++ {
+		err := dec.Decode(next())
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Couldn't read record %d in %s: %v", i, path, err)
+		}
+	}
+}
+
+// newestFirst sorts a slice of resources by the time returned by at.
+type newestFirst struct {
+	n    int
+	at   func(i int) twilio.TwilioTime
+	swap func(i, j int)
+}
+
+func (s newestFirst) Len() int           { return s.n }
+func (s newestFirst) Swap(i, j int)      { s.swap(i, j) }
+func (s newestFirst) Less(i, j int) bool { return s.at(i).Time.After(s.at(j).Time) }
+
+// callTime returns the time the list filters use for c - the start time, or
+// the time it was created if it never started.
+func callTime(c *twilio.Call) twilio.TwilioTime {
+	if c.StartTime.Valid {
+		return c.StartTime
+	}
+	return c.DateCreated
+}
+
+func inRange(t twilio.TwilioTime, start, end time.Time) bool {
+	return t.Valid && !t.Time.Before(start) && t.Time.Before(end)
+}
+
+func (vc *archiveClient) notFound(resource, id string) error {
+	return &rest.Error{
+		StatusCode: 404,
+		Title:      fmt.Sprintf("%s %s not found in %s", resource, id, vc.source),
+	}
+}
+
+// archivePage returns the indexes of the first and last resources on the
+// page described by data, out of n matching resources, and the URI of the
+// next page, if there is one. The next page URI has the same filters as data,
+// so it can be passed back to the GetNext functions.
+func archivePage(base string, n int, data url.Values) (int, int, types.NullString) {
+	pageSize, _ := strconv.Atoi(data.Get("PageSize"))
+	if pageSize <= 0 {
+		pageSize = archiveDefaultPageSize
+	}
+	if pageSize > archiveMaxPageSize {
+		pageSize = archiveMaxPageSize
+	}
+	page, _ := strconv.Atoi(data.Get("Page"))
+	if page < 0 {
+		page = 0
+	}
+	lo := page * pageSize
+	if lo > n {
+		lo = n
+	}
+	hi := lo + pageSize
+	if hi >= n {
+		return lo, n, types.NullString{}
+	}
+	next := url.Values{}
+	for k, v := range data {
+		next[k] = v
+	}
+	next.Set("Page", strconv.Itoa(page+1))
+	next.Set("PageSize", strconv.Itoa(pageSize))
+	return lo, hi, types.NullString{Valid: true, String: base + "?" + next.Encode()}
+}
+
+// nextPageData returns the filters in a next page URI returned by
+// archivePage.
+func nextPageData(nextPage string) (url.Values, error) {
+	u, err := url.Parse(nextPage)
+	if err != nil {
+		return nil, err
+	}
+	return u.Query(), nil
+}
+
+func (vc *archiveClient) listURI(resource string) string {
+	return "/" + twilio.APIVersion + "/Accounts/" + vc.accountSid + "/" + resource + ".json"
+}
+
+// SetBasicAuth does nothing, since there are no credentials for a closed
+// account.
+func (vc *archiveClient) SetBasicAuth(r *http.Request) {}
+
+func (vc *archiveClient) GetMessage(ctx context.Context, user *config.User, sid string) (*Message, error) {
+	for _, m := range vc.messages {
+		if m.Sid == sid {
+			return NewMessage(m, vc.permission, user)
+		}
+	}
+	return nil, vc.notFound("Message", sid)
+}
+
+func (vc *archiveClient) GetCall(ctx context.Context, user *config.User, sid string) (*Call, error) {
+	for _, c := range vc.calls {
+		if c.Sid == sid {
+			return NewCall(c, vc.permission, user)
+		}
+	}
+	return nil, vc.notFound("Call", sid)
+}
+
+func (vc *archiveClient) GetConference(ctx context.Context, user *config.User, sid string) (*Conference, error) {
+	for _, c := range vc.conferences {
+		if c.Sid == sid {
+			return NewConference(c, vc.permission, user)
+		}
+	}
+	return nil, vc.notFound("Conference", sid)
+}
+
+func (vc *archiveClient) GetIncomingNumber(ctx context.Context, user *config.User, sid string) (*IncomingNumber, error) {
+	for _, n := range vc.numbers {
+		if n.Sid == sid {
+			return NewIncomingNumber(n, vc.permission, user)
+		}
+	}
+	return nil, vc.notFound("Phone number", sid)
+}
+
+func (vc *archiveClient) GetIncomingNumberByPN(ctx context.Context, user *config.User, pn string) (*IncomingNumber, error) {
+	for _, n := range vc.numbers {
+		if string(n.PhoneNumber) == pn {
+			return NewIncomingNumber(n, vc.permission, user)
+		}
+	}
+	return nil, vc.notFound("Phone number", pn)
+}
+
+func (vc *archiveClient) GetAlert(ctx context.Context, user *config.User, sid string) (*Alert, error) {
+	for _, a := range vc.alerts {
+		if a.Sid == sid {
+			return NewAlert(a, vc.permission, user)
+		}
+	}
+	return nil, vc.notFound("Alert", sid)
+}
+
+// GetMediaURLs returns no media; archives only contain message metadata.
+func (vc *archiveClient) GetMediaURLs(ctx context.Context, u *config.User, sid string) ([]*url.URL, error) {
+	if u.CanViewMedia() == false {
+		return nil, config.PermissionDenied
+	}
+	return []*url.URL{}, nil
+}
+
+func (vc *archiveClient) messagePage(user *config.User, start, end time.Time, data url.Values) (*MessagePage, uint64, error) {
+	matches := make([]*twilio.Message, 0)
+	for _, m := range vc.messages {
+		if !inRange(m.DateCreated, start, end) {
+			continue
+		}
+		if from := data.Get("From"); from != "" && string(m.From) != from {
+			continue
+		}
+		if to := data.Get("To"); to != "" && string(m.To) != to {
+			continue
+		}
+		if status := data.Get("Status"); status != "" && string(m.Status) != status {
+			continue
+		}
+		matches = append(matches, m)
+	}
+	lo, hi, next := archivePage(vc.listURI("Messages"), len(matches), data)
+	page := &twilio.MessagePage{Messages: matches[lo:hi]}
+	page.NextPageURI = next
+	mp, err := NewMessagePage(page, vc.permission, user)
+	return mp, 0, err
+}
+
+func (vc *archiveClient) GetMessagePageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, data url.Values) (*MessagePage, uint64, error) {
+	return vc.messagePage(user, start, end, data)
+}
+
+func (vc *archiveClient) GetNextMessagePageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, nextPage string) (*MessagePage, uint64, error) {
+	data, err := nextPageData(nextPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	return vc.messagePage(user, start, end, data)
+}
+
+func (vc *archiveClient) callPage(start, end time.Time, data url.Values) *twilio.CallPage {
+	matches := make([]*twilio.Call, 0)
+	for _, c := range vc.calls {
+		if !inRange(callTime(c), start, end) {
+			continue
+		}
+		if from := data.Get("From"); from != "" && string(c.From) != from {
+			continue
+		}
+		if to := data.Get("To"); to != "" && string(c.To) != to {
+			continue
+		}
+		if status := data.Get("Status"); status != "" && string(c.Status) != status {
+			continue
+		}
+		if parent := data.Get("ParentCallSid"); parent != "" && c.ParentCallSid.String != parent {
+			continue
+		}
+		matches = append(matches, c)
+	}
+	lo, hi, next := archivePage(vc.listURI("Calls"), len(matches), data)
+	page := &twilio.CallPage{Calls: matches[lo:hi]}
+	page.NextPageURI = next
+	return page
+}
+
+func (vc *archiveClient) GetCallPageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, data url.Values) (*CallPage, uint64, error) {
+	cp, err := NewCallPage(vc.callPage(start, end, data), vc.permission, user)
+	return cp, 0, err
+}
+
+func (vc *archiveClient) GetNextCallPageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, nextPage string) (*CallPage, uint64, error) {
+	data, err := nextPageData(nextPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	cp, err := NewCallPage(vc.callPage(start, end, data), vc.permission, user)
+	return cp, 0, err
+}
+
+func (vc *archiveClient) GetChildCalls(ctx context.Context, user *config.User, parentSid string) (*CallPage, error) {
+	data := url.Values{}
+	data.Set("ParentCallSid", parentSid)
+	data.Set("PageSize", "100")
+	return NewCallPage(vc.callPage(twilio.Epoch, twilio.HeatDeath, data), vc.permission, user)
+}
+
+// LoadCallEvents loads no events; the archive doesn't have the requests Twilio
+// made to webhooks.
+func (vc *archiveClient) LoadCallEvents(ctx context.Context, call *Call) error {
+	return nil
+}
+
+// GetResourceEvents returns no events; Monitor Events aren't part of the
+// archive.
+func (vc *archiveClient) GetResourceEvents(ctx context.Context, user *config.User, resourceSid string) ([]*Event, error) {
+	return []*Event{}, nil
+}
+
+func (vc *archiveClient) numberPage(user *config.User, data url.Values) (*IncomingNumberPage, uint64, error) {
+	matches := make([]*twilio.IncomingPhoneNumber, 0)
+	for _, n := range vc.numbers {
+		if pn := data.Get("PhoneNumber"); pn != "" && !strings.Contains(string(n.PhoneNumber), pn) {
+			continue
+		}
+		if name := data.Get("FriendlyName"); name != "" && !strings.Contains(n.FriendlyName, name) {
+			continue
+		}
+		matches = append(matches, n)
+	}
+	lo, hi, next := archivePage(vc.listURI("IncomingPhoneNumbers"), len(matches), data)
+	page := &twilio.IncomingPhoneNumberPage{IncomingPhoneNumbers: matches[lo:hi]}
+	page.NextPageURI = next
+	np, err := NewIncomingNumberPage(page, vc.permission, user)
+	return np, 0, err
+}
+
+func (vc *archiveClient) GetNumberPage(ctx context.Context, user *config.User, data url.Values) (*IncomingNumberPage, uint64, error) {
+	return vc.numberPage(user, data)
+}
+
+func (vc *archiveClient) GetNextNumberPage(ctx context.Context, user *config.User, nextPage string) (*IncomingNumberPage, uint64, error) {
+	data, err := nextPageData(nextPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	return vc.numberPage(user, data)
+}
+
+func (vc *archiveClient) conferencePage(user *config.User, start, end time.Time, data url.Values) (*ConferencePage, uint64, error) {
+	matches := make([]*twilio.Conference, 0)
+	for _, c := range vc.conferences {
+		if !inRange(c.DateCreated, start, end) {
+			continue
+		}
+		if name := data.Get("FriendlyName"); name != "" && c.FriendlyName != name {
+			continue
+		}
+		if status := data.Get("Status"); status != "" && string(c.Status) != status {
+			continue
+		}
+		matches = append(matches, c)
+	}
+	lo, hi, next := archivePage(vc.listURI("Conferences"), len(matches), data)
+	page := &twilio.ConferencePage{Conferences: matches[lo:hi]}
+	page.NextPageURI = next
+	cp, err := NewConferencePage(page, vc.permission, user)
+	return cp, 0, err
+}
+
+func (vc *archiveClient) GetConferencePageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, data url.Values) (*ConferencePage, uint64, error) {
+	return vc.conferencePage(user, start, end, data)
+}
+
+func (vc *archiveClient) GetNextConferencePageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, nextPage string) (*ConferencePage, uint64, error) {
+	data, err := nextPageData(nextPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	return vc.conferencePage(user, start, end, data)
+}
+
+func (vc *archiveClient) alertPage(start, end time.Time, data url.Values) *twilio.AlertPage {
+	matches := make([]*twilio.Alert, 0)
+	for _, a := range vc.alerts {
+		if !inRange(a.DateCreated, start, end) {
+			continue
+		}
+		if sid := data.Get("ResourceSid"); sid != "" && a.ResourceSid != sid {
+			continue
+		}
+		if level := data.Get("LogLevel"); level != "" && string(a.LogLevel) != level {
+			continue
+		}
+		matches = append(matches, a)
+	}
+	base := twilio.MonitorBaseURL + "/" + twilio.MonitorVersion + "/Alerts"
+	lo, hi, next := archivePage(base, len(matches), data)
+	return &twilio.AlertPage{
+		Meta:   twilio.Meta{NextPageURL: next},
+		Alerts: matches[lo:hi],
+	}
+}
+
+func (vc *archiveClient) GetAlertPageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, data url.Values) (*AlertPage, uint64, error) {
+	ap, err := NewAlertPage(vc.alertPage(start, end, data), vc.permission, user)
+	return ap, 0, err
+}
+
+func (vc *archiveClient) GetNextAlertPageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, nextPage string) (*AlertPage, uint64, error) {
+	data, err := nextPageData(nextPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	ap, err := NewAlertPage(vc.alertPage(start, end, data), vc.permission, user)
+	return ap, 0, err
+}
+
+func (vc *archiveClient) GetCallAlerts(ctx context.Context, user *config.User, callSid string) (*AlertPage, error) {
+	return vc.resourceAlerts(user, callSid)
+}
+
+func (vc *archiveClient) GetMessageAlerts(ctx context.Context, user *config.User, messageSid string) (*AlertPage, error) {
+	return vc.resourceAlerts(user, messageSid)
+}
+
+func (vc *archiveClient) resourceAlerts(user *config.User, sid string) (*AlertPage, error) {
+	data := url.Values{}
+	data.Set("ResourceSid", sid)
+	data.Set("PageSize", "400")
+	return NewAlertPage(vc.alertPage(twilio.Epoch, twilio.HeatDeath, data), vc.permission, user)
+}
+
+// GetCallRecordings returns no recordings; the recording files are deleted
+// along with the account.
+func (vc *archiveClient) GetCallRecordings(ctx context.Context, user *config.User, callSid string, data url.Values) (*RecordingPage, error) {
+	return NewRecordingPage(&twilio.RecordingPage{}, vc.permission, user, nil)
+}
+
+func (vc *archiveClient) GetRecordingPage(ctx context.Context, user *config.User, data url.Values) (*RecordingPage, error) {
+	return NewRecordingPage(&twilio.RecordingPage{}, vc.permission, user, nil)
+}
+
+func (vc *archiveClient) GetNextRecordingPage(ctx context.Context, user *config.User, nextPage string) (*RecordingPage, error) {
+	return NewRecordingPage(&twilio.RecordingPage{}, vc.permission, user, nil)
+}
+
+// CacheCommonQueries does nothing, since the whole archive is in memory.
+func (vc *archiveClient) CacheCommonQueries(pageSize uint, doneCh <-chan bool) {}
+
+func (vc *archiveClient) IsTwilioNumber(num twilio.PhoneNumber) bool {
+	return vc.numberSet[services.BareNumber(num)]
+}
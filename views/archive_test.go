@@ -0,0 +1,119 @@
+package views
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/handlers"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+const archivedMessages = `{"sid": "SM1", "from": "+14105551234", "to": "+19253920364", "status": "delivered", "direction": "inbound", "date_created": "Thu, 27 Oct 2016 23:27:03 +0000"}
+{"sid": "SM3", "from": "+19253920364", "to": "+14105551234", "status": "delivered", "direction": "outbound-api", "date_created": "Thu, 27 Oct 2016 23:29:03 +0000"}
+{"sid": "SM2", "from": "+14105551234", "to": "+19253920364", "status": "received", "direction": "inbound", "date_created": "Thu, 27 Oct 2016 23:28:03 +0000"}
+`
+
+func newTestArchive(t *testing.T) (Client, func()) {
+	dir, err := ioutil.TempDir("", "logrole-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ArchiveMessagesFile), []byte(archivedMessages), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vc, err := NewArchiveClient(handlers.Logger, dir, "AC123", config.NewPermission(1000*1000*time.Hour))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return vc, func() { os.RemoveAll(dir) }
+}
+
+func TestArchiveMessagePages(t *testing.T) {
+	t.Parallel()
+	vc, cleanup := newTestArchive(t)
+	defer cleanup()
+	u := config.NewUser(config.AllUserSettings())
+	data := url.Values{"PageSize": []string{"2"}}
+	page, _, err := vc.GetMessagePageInRange(context.Background(), u, twilio.Epoch, twilio.HeatDeath, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := page.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(msgs))
+	}
+	if sid, _ := msgs[0].Sid(); sid != "SM3" {
+		t.Errorf("expected newest message first, got %s", sid)
+	}
+	next := page.NextPageURI()
+	if !next.Valid || !strings.HasPrefix(next.String, "/"+twilio.APIVersion+"/Accounts/AC123/Messages.json") {
+		t.Fatalf("expected a next page URI, got %v", next)
+	}
+	page, _, err = vc.GetNextMessagePageInRange(context.Background(), u, twilio.Epoch, twilio.HeatDeath, next.String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages()) != 1 {
+		t.Fatalf("expected 1 message on the last page, got %d", len(page.Messages()))
+	}
+	if page.NextPageURI().Valid {
+		t.Errorf("expected no next page after the last page")
+	}
+
+	data = url.Values{"Status": []string{"received"}}
+	page, _, err = vc.GetMessagePageInRange(context.Background(), u, twilio.Epoch, twilio.HeatDeath, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages()) != 1 {
+		t.Errorf("expected filters to apply, got %d messages", len(page.Messages()))
+	}
+}
+
+func TestArchiveGetMessage(t *testing.T) {
+	t.Parallel()
+	vc, cleanup := newTestArchive(t)
+	defer cleanup()
+	u := config.NewUser(config.AllUserSettings())
+	msg, err := vc.GetMessage(context.Background(), u, "SM2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := msg.Status(); status != "received" {
+		t.Errorf("expected status to be received, got %s", status)
+	}
+	_, err = vc.GetMessage(context.Background(), u, "SM404")
+	if rerr, ok := err.(*rest.Error); !ok || rerr.StatusCode != 404 {
+		t.Errorf("expected a 404 error, got %v", err)
+	}
+	s := config.AllUserSettings()
+	s.CanViewMessages = false
+	if _, err := vc.GetMessage(context.Background(), config.NewUser(s), "SM2"); err != config.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+}
+
+func TestArchiveInvalidRecord(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logrole-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, ArchiveCallsFile), []byte("{\"sid\": \"CA1\"}\nnot json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewArchiveClient(handlers.Logger, dir, "AC123", config.NewPermission(time.Hour))
+	if err == nil || !strings.Contains(err.Error(), "record 2") {
+		t.Errorf("expected error for the second record, got %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package views
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Message encodings, as Twilio picks them for a body.
+const (
+	EncodingGSM7 = "GSM-7"
+	EncodingUCS2 = "UCS-2"
+)
+
+// Characters in the GSM 03.38 default alphabet, which take one septet each.
+// The escape character is left out, since it's only used to reach the
+// extension table.
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// Characters in the GSM extension table, which take two septets each.
+const gsm7Extended = "\f^{}\\[~]|€"
+
+// Segment sizes. A message that doesn't fit in one segment is split, and each
+// part loses room to a header that says how to put them back together.
+const (
+	gsm7Single = 160
+	gsm7Multi  = 153
+	ucs2Single = 70
+	ucs2Multi  = 67
+)
+
+// hiddenRune returns true for characters that are invisible, or that change
+// the direction of the text around them, and so can hide or reorder what a
+// message says. Zero width joiners and non-joiners are allowed, since emoji
+// and many scripts need them.
+func hiddenRune(r rune) bool {
+	switch {
+	case r >= 0x202A && r <= 0x202E: // bidi embeddings and overrides
+		return true
+	case r >= 0x2066 && r <= 0x2069: // bidi isolates
+		return true
+	case r == 0x200B, r == 0x2060, r == 0xFEFF, r == 0x180E, r == 0x00AD:
+		return true
+	case r >= 0x2061 && r <= 0x2064: // invisible math operators
+		return true
+	case r == '\n', r == '\r', r == '\t':
+		return false
+	}
+	return unicode.Is(unicode.Cc, r)
+}
+
+// SanitizeBody replaces every hidden character in body with a visible
+// placeholder like "[U+202E]", and returns how many it replaced.
+func SanitizeBody(body string) (string, int) {
+	count := 0
+	out := make([]rune, 0, len(body))
+	for _, r := range body {
+		if hiddenRune(r) {
+			count++
+			out = append(out, []rune(fmt.Sprintf("[U+%04X]", r))...)
+			continue
+		}
+		out = append(out, r)
+	}
+	if count == 0 {
+		return body, 0
+	}
+	return string(out), count
+}
+
+// BodyEncoding returns EncodingGSM7 if every character in body is in the GSM
+// alphabet, and EncodingUCS2 otherwise.
+func BodyEncoding(body string) string {
+	for _, r := range body {
+		if !strings.ContainsRune(gsm7Basic, r) && !strings.ContainsRune(gsm7Extended, r) {
+			return EncodingUCS2
+		}
+	}
+	return EncodingGSM7
+}
+
+// BodySegments returns the number of segments body is sent in. Characters
+// from the GSM extension table take two septets, characters outside the Basic
+// Multilingual Plane (most emoji) take two UCS-2 code units, and neither is
+// split across segments.
+func BodySegments(body string) int {
+	return len(splitBody(body))
+}
+
+// A BodySegment is one of the parts a message body is split into.
+type BodySegment struct {
+	// The segment's text, with hidden characters replaced as in
+	// SanitizeBody.
+	Text string
+	// How many septets or code units the text takes, and how many fit in the
+	// segment.
+	Used int
+	Size int
+}
+
+// splitBody returns the segments body is sent in, or nil if body is empty.
+func splitBody(body string) []*BodySegment {
+	if body == "" {
+		return nil
+	}
+	runes := []rune(body)
+	sizes := make([]int, len(runes))
+	single, multi := gsm7Single, gsm7Multi
+	if BodyEncoding(body) == EncodingGSM7 {
+		for i, r := range runes {
+			if strings.ContainsRune(gsm7Extended, r) {
+				sizes[i] = 2
+			} else {
+				sizes[i] = 1
+			}
+		}
+	} else {
+		single, multi = ucs2Single, ucs2Multi
+		for i, r := range runes {
+			if r > 0xFFFF {
+				sizes[i] = 2
+			} else {
+				sizes[i] = 1
+			}
+		}
+	}
+	total := 0
+	for _, size := range sizes {
+		total += size
+	}
+	if total <= single {
+		text, _ := SanitizeBody(body)
+		return []*BodySegment{{Text: text, Used: total, Size: single}}
+	}
+	var segments []*BodySegment
+	start, used := 0, 0
+	for i, size := range sizes {
+		if used+size > multi {
+			text, _ := SanitizeBody(string(runes[start:i]))
+			segments = append(segments, &BodySegment{Text: text, Used: used, Size: multi})
+			start, used = i, 0
+		}
+		used += size
+	}
+	text, _ := SanitizeBody(string(runes[start:]))
+	return append(segments, &BodySegment{Text: text, Used: used, Size: multi})
+}
+
+// A BodyCharacter is a character that isn't in the GSM alphabet.
+type BodyCharacter struct {
+	// The character, or empty if it's invisible or changes the direction
+	// of the text.
+	Char      string
+	CodePoint string
+	// How many times it appears in the body.
+	Count int
+}
+
+// A BodyInspection explains how a body is encoded and split into segments.
+type BodyInspection struct {
+	Encoding string
+	// "septets" for GSM-7 and "code units" for UCS-2.
+	Units    string
+	Segments []*BodySegment
+	// The characters that force the body to be sent as UCS-2, in the order
+	// they first appear.
+	UCS2Characters []*BodyCharacter
+	// The number of segments the body would take if every character in
+	// UCS2Characters were replaced with one from the GSM alphabet.
+	GSM7Segments int
+	// What the extra segments UCS-2 takes cost, like "0.0150 USD". Empty if
+	// the body is GSM-7 or the price is unknown.
+	ExtraCost string
+}
+
+// InspectBody returns how body is encoded and split into segments.
+func InspectBody(body string) *BodyInspection {
+	bi := &BodyInspection{
+		Encoding: BodyEncoding(body),
+		Units:    "septets",
+		Segments: splitBody(body),
+	}
+	if bi.Encoding == EncodingGSM7 {
+		bi.GSM7Segments = len(bi.Segments)
+		return bi
+	}
+	bi.Units = "code units"
+	seen := make(map[rune]*BodyCharacter)
+	replaced := make([]rune, 0, len(body))
+	for _, r := range body {
+		if strings.ContainsRune(gsm7Basic, r) || strings.ContainsRune(gsm7Extended, r) {
+			replaced = append(replaced, r)
+			continue
+		}
+		replaced = append(replaced, '?')
+		if c, ok := seen[r]; ok {
+			c.Count++
+			continue
+		}
+		c := &BodyCharacter{CodePoint: fmt.Sprintf("U+%04X", r), Count: 1}
+		if !hiddenRune(r) {
+			c.Char = string(r)
+		}
+		seen[r] = c
+		bi.UCS2Characters = append(bi.UCS2Characters, c)
+	}
+	bi.GSM7Segments = BodySegments(string(replaced))
+	return bi
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// extendsPrevious returns true for runes that are drawn as part of the
+// character before them.
+func extendsPrevious(r rune) bool {
+	switch {
+	case r >= 0xFE00 && r <= 0xFE0F: // variation selectors
+		return true
+	case r >= 0x1F3FB && r <= 0x1F3FF: // skin tone modifiers
+		return true
+	case r >= 0xE0020 && r <= 0xE007F: // emoji tag sequences
+		return true
+	}
+	return unicode.In(r, unicode.Mn, unicode.Me)
+}
+
+// BodyCharacters returns the number of characters a reader sees in body. An
+// emoji built from several code points, like a flag or a family, counts once.
+func BodyCharacters(body string) int {
+	count := 0
+	joined, pendingFlag := false, false
+	for _, r := range body {
+		switch {
+		case r == 0x200D:
+			joined = true
+			continue
+		case joined, extendsPrevious(r):
+		case isRegionalIndicator(r) && pendingFlag:
+			pendingFlag = false
+		default:
+			count++
+			pendingFlag = isRegionalIndicator(r)
+		}
+		joined = false
+	}
+	return count
+}
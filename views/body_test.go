@@ -0,0 +1,114 @@
+package views
+
+import (
+	"strings"
+	"testing"
+)
+
+var sanitizeTests = []struct {
+	in     string
+	out    string
+	hidden int
+}{
+	{"hello", "hello", 0},
+	{"pay \u202egnp.exe", "pay [U+202E]gnp.exe", 1},
+	{"ap\u200bple\ufeff", "ap[U+200B]ple[U+FEFF]", 2},
+	{"line one\nline two", "line one\nline two", 0},
+	{"bell\x07", "bell[U+0007]", 1},
+	// Zero width joiners hold emoji sequences together.
+	{"\U0001F468\u200d\U0001F469\u200d\U0001F467", "\U0001F468\u200d\U0001F469\u200d\U0001F467", 0},
+	{"مرحبا", "مرحبا", 0},
+}
+
+func TestSanitizeBody(t *testing.T) {
+	t.Parallel()
+	for _, tt := range sanitizeTests {
+		out, hidden := SanitizeBody(tt.in)
+		if out != tt.out || hidden != tt.hidden {
+			t.Errorf("SanitizeBody(%q): got (%q, %d), want (%q, %d)", tt.in, out, hidden, tt.out, tt.hidden)
+		}
+	}
+}
+
+var segmentTests = []struct {
+	in         string
+	encoding   string
+	segments   int
+	characters int
+}{
+	{"", EncodingGSM7, 0, 0},
+	{"Hello", EncodingGSM7, 1, 5},
+	{strings.Repeat("a", 160), EncodingGSM7, 1, 160},
+	{strings.Repeat("a", 161), EncodingGSM7, 2, 161},
+	// Extension characters take two septets.
+	{strings.Repeat("€", 80), EncodingGSM7, 1, 80},
+	{strings.Repeat("€", 81), EncodingGSM7, 2, 81},
+	// 152 septets, then an extension character that doesn't fit in the first
+	// segment.
+	{strings.Repeat("a", 152) + "{" + strings.Repeat("a", 10), EncodingGSM7, 2, 163},
+	{strings.Repeat("é", 70), EncodingGSM7, 1, 70},
+	{strings.Repeat("ê", 70), EncodingUCS2, 1, 70},
+	{strings.Repeat("ê", 71), EncodingUCS2, 2, 71},
+	// Emoji outside the BMP take two code units each.
+	{strings.Repeat("\U0001F600", 35), EncodingUCS2, 1, 35},
+	{strings.Repeat("\U0001F600", 36), EncodingUCS2, 2, 36},
+	{"a" + strings.Repeat("\U0001F600", 35), EncodingUCS2, 2, 36},
+	// A family, a flag, a thumbs up with a skin tone, and a heart with a
+	// variation selector are four characters.
+	{"\U0001F468\u200d\U0001F469\u200d\U0001F467\U0001F1FA\U0001F1F8\U0001F44D\U0001F3FD❤\ufe0f", EncodingUCS2, 1, 4},
+	{"e\u0301", EncodingUCS2, 1, 1},
+}
+
+func TestBodySegments(t *testing.T) {
+	t.Parallel()
+	for _, tt := range segmentTests {
+		if enc := BodyEncoding(tt.in); enc != tt.encoding {
+			t.Errorf("BodyEncoding(%q): got %s, want %s", tt.in, enc, tt.encoding)
+		}
+		if segments := BodySegments(tt.in); segments != tt.segments {
+			t.Errorf("BodySegments(%q): got %d, want %d", tt.in, segments, tt.segments)
+		}
+		if chars := BodyCharacters(tt.in); chars != tt.characters {
+			t.Errorf("BodyCharacters(%q): got %d, want %d", tt.in, chars, tt.characters)
+		}
+	}
+}
+
+func TestInspectBody(t *testing.T) {
+	t.Parallel()
+	// 150 GSM characters, then a curly apostrophe that forces UCS-2.
+	body := strings.Repeat("a", 75) + "’" + strings.Repeat("b", 75) + "’‮"
+	bi := InspectBody(body)
+	if bi.Encoding != EncodingUCS2 || bi.Units != "code units" {
+		t.Errorf("expected UCS-2, got %s (%s)", bi.Encoding, bi.Units)
+	}
+	if len(bi.Segments) != 3 {
+		t.Fatalf("expected 3 segments, got %d", len(bi.Segments))
+	}
+	if seg := bi.Segments[0]; seg.Text != strings.Repeat("a", 67) || seg.Used != 67 || seg.Size != ucs2Multi {
+		t.Errorf("bad first segment: %+v", seg)
+	}
+	if seg := bi.Segments[2]; !strings.HasSuffix(seg.Text, "’[U+202E]") || seg.Used != 19 {
+		t.Errorf("bad last segment: %+v", seg)
+	}
+	if len(bi.UCS2Characters) != 2 {
+		t.Fatalf("expected 2 characters forcing UCS-2, got %d", len(bi.UCS2Characters))
+	}
+	if c := bi.UCS2Characters[0]; c.Char != "’" || c.CodePoint != "U+2019" || c.Count != 2 {
+		t.Errorf("bad character: %+v", c)
+	}
+	if c := bi.UCS2Characters[1]; c.Char != "" || c.CodePoint != "U+202E" || c.Count != 1 {
+		t.Errorf("hidden characters should only show their code point, got %+v", c)
+	}
+	if bi.GSM7Segments != 1 {
+		t.Errorf("expected 1 segment as GSM-7, got %d", bi.GSM7Segments)
+	}
+
+	bi = InspectBody(strings.Repeat("a", 152) + "{")
+	if bi.Encoding != EncodingGSM7 || len(bi.UCS2Characters) != 0 || bi.GSM7Segments != 1 {
+		t.Errorf("bad GSM-7 inspection: %+v", bi)
+	}
+	if seg := bi.Segments[0]; seg.Used != 154 || seg.Size != gsm7Single {
+		t.Errorf("bad GSM-7 segment: %+v", seg)
+	}
+}
@@ -2,10 +2,12 @@ package views
 
 import (
 	"errors"
+	"time"
 
 	types "github.com/kevinburke/go-types"
 	twilio "github.com/saintpete/twilio-go"
 	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
 )
 
 type CallPage struct {
@@ -16,36 +18,86 @@ type CallPage struct {
 
 type Call struct {
 	user *config.User
-	call *twilio.Call
+	// The user's permissions when the call was created.
+	perms *config.PermissionSnapshot
+	call  *twilio.Call
+	// Empty for calls made through Twilio.
+	provider string
+	// The name of the Twilio account the call belongs to, if Logrole lists
+	// more than one.
+	account string
+	// The requests Twilio made to the call's webhooks. Empty until they're
+	// loaded with LoadCallEvents.
+	events []*callEvent
 }
 
 func NewCall(call *twilio.Call, p *config.Permission, u *config.User) (*Call, error) {
 	if u.CanViewCalls() == false {
 		return nil, config.PermissionDenied
 	}
+	return newCall(call, p, u.Snapshot(p.MaxResourceAge(), time.Now()), u)
+}
+
+// newCall creates a Call for a user who can view calls, with their
+// permissions in perms.
+func newCall(call *twilio.Call, p *config.Permission, perms *config.PermissionSnapshot, u *config.User) (*Call, error) {
 	if call.DateCreated.Valid == false {
 		return nil, errors.New("Invalid DateCreated for call")
 	}
-	if !u.CanViewResource(call.DateCreated.Time, p.MaxResourceAge()) {
+	if !perms.CanViewResource(call.DateCreated.Time) {
 		return nil, config.ErrTooOld
 	}
-	return &Call{user: u, call: call}, nil
+	if !u.CanViewNumbers(string(call.From), string(call.To)) {
+		return nil, config.PermissionDenied
+	}
+	if p.Blocklist().Hides(u, string(call.From), string(call.To)) {
+		return nil, config.PermissionDenied
+	}
+	return &Call{user: u, perms: perms, call: call}, nil
+}
+
+// Provider returns the name of the provider that carried the call, like
+// "twilio".
+func (c *Call) Provider() string {
+	if c.provider == "" {
+		return config.ProviderTwilio
+	}
+	return c.provider
+}
+
+// Account returns the name of the Twilio account the call belongs to, or the
+// empty string if Logrole only lists one account.
+func (c *Call) Account() string {
+	return c.account
 }
 
 func (c *Call) CanViewProperty(property string) bool {
+	return c.perms.Has(callPermission(property))
+}
+
+// HiddenReason explains why property is hidden, if the user is debugging
+// permissions.
+func (c *Call) HiddenReason(property string) string {
 	if c.user == nil {
-		return false
+		return ""
 	}
+	return c.user.HiddenReason(callPermission(property))
+}
+
+// callPermission returns the permission needed to view property.
+func callPermission(property string) string {
 	switch property {
 	case "Sid", "Direction", "Status", "DateCreated", "DateUpdated",
-		"Duration", "StartTime", "EndTime":
-		return c.user.CanViewCalls()
+		"Duration", "StartTime", "EndTime", "ParentCallSid", "AnsweredBy":
+		return "can_view_calls"
+	case "Digits":
+		return "can_view_call_digits"
 	case "Price", "PriceUnit":
-		return c.user.CanViewCallPrice()
+		return "can_view_call_price"
 	case "From":
-		return c.user.CanViewCallFrom()
+		return "can_view_call_from"
 	case "To":
-		return c.user.CanViewCallTo()
+		return "can_view_call_to"
 	default:
 		panic("unknown property " + property)
 	}
@@ -59,6 +111,16 @@ func (c *Call) Sid() (string, error) {
 	}
 }
 
+// ParentCallSid returns the Sid of the call that created this one with
+// <Dial>, if there is one.
+func (c *Call) ParentCallSid() (types.NullString, error) {
+	if c.CanViewProperty("ParentCallSid") {
+		return c.call.ParentCallSid, nil
+	} else {
+		return types.NullString{}, config.PermissionDenied
+	}
+}
+
 func (c *Call) DateCreated() (twilio.TwilioTime, error) {
 	if c.CanViewProperty("DateCreated") {
 		return c.call.DateCreated, nil
@@ -99,6 +161,29 @@ func (c *Call) To() (twilio.PhoneNumber, error) {
 	}
 }
 
+// InCountry returns true if the From or To number of the call belongs to the
+// region with the given code. Numbers the user can't view never match.
+func (c *Call) InCountry(code string) bool {
+	if from, err := c.From(); err == nil && services.CountryCode(string(from)) == code {
+		return true
+	}
+	if to, err := c.To(); err == nil && services.CountryCode(string(to)) == code {
+		return true
+	}
+	return false
+}
+
+// OwnedBy returns true if team owns the From or To number of the call.
+func (c *Call) OwnedBy(owners *services.OwnerStore, team string) bool {
+	if from, err := c.From(); err == nil && owners.OwnedBy(from, team) {
+		return true
+	}
+	if to, err := c.To(); err == nil && owners.OwnedBy(to, team) {
+		return true
+	}
+	return false
+}
+
 func (c *Call) Duration() (twilio.TwilioDuration, error) {
 	if c.CanViewProperty("Duration") {
 		return c.call.Duration, nil
@@ -115,6 +200,16 @@ func (c *Call) StartTime() (twilio.TwilioTime, error) {
 	}
 }
 
+// ListTime returns the time the list filters use for the call: when it
+// started, or when it was created if it never started.
+func (c *Call) ListTime() (twilio.TwilioTime, error) {
+	if c.CanViewProperty("StartTime") && c.CanViewProperty("DateCreated") {
+		return callTime(c.call), nil
+	} else {
+		return twilio.TwilioTime{}, config.PermissionDenied
+	}
+}
+
 func (c *Call) FriendlyPrice() (string, error) {
 	if c.CanViewProperty("Price") && c.CanViewProperty("PriceUnit") {
 		return c.call.FriendlyPrice(), nil
@@ -124,11 +219,11 @@ func (c *Call) FriendlyPrice() (string, error) {
 }
 
 func (c *Call) CanViewNumRecordings() bool {
-	return c.user.CanViewNumRecordings()
+	return c.perms.Has("can_view_num_recordings")
 }
 
 func (c *Call) CanViewCallAlerts() bool {
-	return c.user.CanViewAlerts()
+	return c.perms.Has("can_view_alerts")
 }
 
 func (c *Call) Failed() (bool, error) {
@@ -143,9 +238,10 @@ func NewCallPage(cp *twilio.CallPage, p *config.Permission, u *config.User) (*Ca
 	if u.CanViewCalls() == false {
 		return nil, config.PermissionDenied
 	}
-	calls := make([]*Call, 0)
+	perms := u.Snapshot(p.MaxResourceAge(), time.Now())
+	calls := make([]*Call, 0, len(cp.Calls))
 	for _, call := range cp.Calls {
-		cl, err := NewCall(call, p, u)
+		cl, err := newCall(call, p, perms, u)
 		if err == config.ErrTooOld || err == config.PermissionDenied {
 			continue
 		}
@@ -177,6 +273,22 @@ func (cp *CallPage) PreviousPageURI() types.NullString {
 	return cp.previousPageURI
 }
 
+// Filter returns a copy of the page containing only the calls for which fn
+// returns true. The next and previous page URIs are unchanged.
+func (cp *CallPage) Filter(fn func(*Call) bool) *CallPage {
+	calls := make([]*Call, 0, len(cp.calls))
+	for _, call := range cp.calls {
+		if fn(call) {
+			calls = append(calls, call)
+		}
+	}
+	return &CallPage{
+		calls:           calls,
+		nextPageURI:     cp.nextPageURI,
+		previousPageURI: cp.previousPageURI,
+	}
+}
+
 // ShowHeader returns true if we should show the table header in the call
 // list view. This is true if the user is allowed to view the fieldName on any
 // message in the list, and true if there are no messages.
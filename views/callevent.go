@@ -0,0 +1,132 @@
+package views
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+// callEvent is a request Twilio made to one of a call's webhooks, as returned
+// by the Call Events API. twilio-go doesn't have a type for these.
+type callEvent struct {
+	Request struct {
+		Method string `json:"method"`
+		// The parameters Twilio sent, like "Digits" after a <Gather>, or
+		// "AnsweredBy" when answering machine detection finished.
+		Parameters map[string]interface{} `json:"parameters"`
+	} `json:"request"`
+}
+
+type callEventPage struct {
+	Meta   twilio.Meta  `json:"meta"`
+	Events []*callEvent `json:"events"`
+}
+
+// The most webhook requests we look at for a call. Calls rarely make more.
+const callEventPageSize = 100
+
+// param returns the request parameter with the given name, or the empty
+// string if Twilio didn't send it.
+func (e *callEvent) param(name string) string {
+	return eventValue(e.Request.Parameters[name])
+}
+
+// GatheredDigits are the keys a caller pressed during a <Gather>.
+type GatheredDigits struct {
+	// A dot for each digit. Always set.
+	Masked string
+	// Empty if the user can't view the digits.
+	Digits string
+}
+
+// LoadCallEvents fetches the webhook requests Twilio made for call, so
+// AnsweredBy and Digits can be read from them.
+func (vc *client) LoadCallEvents(ctx context.Context, call *Call) error {
+	data := url.Values{}
+	data.Set("PageSize", strconv.Itoa(callEventPageSize))
+	page := new(callEventPage)
+	if err := vc.client.ListResource(ctx, "Calls/"+call.call.Sid+"/Events", data, page); err != nil {
+		return err
+	}
+	call.events = page.Events
+	return nil
+}
+
+// AnsweredBy returns the result of answering machine detection, like "human"
+// or "machine_end_beep", or the empty string if the call didn't use it or its
+// events weren't loaded.
+func (c *Call) AnsweredBy() (string, error) {
+	if !c.CanViewProperty("AnsweredBy") {
+		return "", config.PermissionDenied
+	}
+	// Asynchronous detection sends the result to its own callback, after
+	// the call's first request, so use the last one.
+	answeredBy := ""
+	for _, e := range c.events {
+		if val := e.param("AnsweredBy"); val != "" {
+			answeredBy = val
+		}
+	}
+	return answeredBy, nil
+}
+
+// FriendlyAnsweredBy describes the result of answering machine detection, or
+// returns the empty string if there isn't one.
+func (c *Call) FriendlyAnsweredBy() (string, error) {
+	answeredBy, err := c.AnsweredBy()
+	if err != nil {
+		return "", err
+	}
+	switch answeredBy {
+	case "":
+		return "", nil
+	case "human":
+		return "Human", nil
+	case "machine_start":
+		return "Machine (greeting started)", nil
+	case "machine_end_beep":
+		return "Machine (ended with a beep)", nil
+	case "machine_end_silence":
+		return "Machine (ended with silence)", nil
+	case "machine_end_other":
+		return "Machine (ended another way)", nil
+	case "fax":
+		return "Fax machine", nil
+	case "unknown":
+		return "Unknown", nil
+	default:
+		return answeredBy, nil
+	}
+}
+
+// CanViewDigits returns true if the user can reveal the digits a caller
+// pressed.
+func (c *Call) CanViewDigits() bool {
+	return c.CanViewProperty("Digits")
+}
+
+// Digits returns the keys the caller pressed in each <Gather>, in order. The
+// digits themselves are only included if the user can view them; everyone who
+// can view the call sees how many were pressed.
+func (c *Call) Digits() ([]*GatheredDigits, error) {
+	if !c.perms.Has("can_view_calls") {
+		return nil, config.PermissionDenied
+	}
+	gathered := make([]*GatheredDigits, 0)
+	for _, e := range c.events {
+		digits := e.param("Digits")
+		if digits == "" {
+			continue
+		}
+		gd := &GatheredDigits{Masked: strings.Repeat("•", len(digits))}
+		if c.CanViewDigits() {
+			gd.Digits = digits
+		}
+		gathered = append(gathered, gd)
+	}
+	return gathered, nil
+}
@@ -0,0 +1,74 @@
+package views
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+const callEventsJSON = `{"events": [
+  {"request": {"method": "POST", "parameters": {"CallSid": "CA123", "AnsweredBy": "unknown"}}},
+  {"request": {"method": "POST", "parameters": {"CallSid": "CA123", "Digits": "4321"}}},
+  {"request": {"method": "POST", "parameters": {"CallSid": "CA123", "AnsweredBy": "machine_end_beep", "MachineDetectionDuration": "4200"}}},
+  {"request": {"method": "POST", "parameters": {"CallSid": "CA123", "Digits": "1#"}}}
+], "meta": {}}`
+
+func newTestCall(t *testing.T, us *config.UserSettings) *Call {
+	page := new(callEventPage)
+	if err := json.Unmarshal([]byte(callEventsJSON), page); err != nil {
+		t.Fatal(err)
+	}
+	tc := &twilio.Call{
+		Sid:         "CA123",
+		DateCreated: twilio.TwilioTime{Valid: true, Time: time.Now()},
+	}
+	call, err := NewCall(tc, config.NewPermission(24*time.Hour), config.NewUser(us))
+	if err != nil {
+		t.Fatal(err)
+	}
+	call.events = page.Events
+	return call
+}
+
+func TestCallEvents(t *testing.T) {
+	t.Parallel()
+	call := newTestCall(t, config.AllUserSettings())
+	if answeredBy, _ := call.AnsweredBy(); answeredBy != "machine_end_beep" {
+		t.Errorf("expected the last machine detection result, got %q", answeredBy)
+	}
+	digits, err := call.Digits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(digits) != 2 {
+		t.Fatalf("expected 2 sets of digits, got %d", len(digits))
+	}
+	if digits[0].Digits != "4321" || digits[0].Masked != "••••" || digits[1].Digits != "1#" {
+		t.Errorf("bad digits: %#v, %#v", digits[0], digits[1])
+	}
+}
+
+func TestCallDigitsMasked(t *testing.T) {
+	t.Parallel()
+	us := config.AllUserSettings()
+	us.CanViewCallDigits = false
+	call := newTestCall(t, us)
+	if call.CanViewDigits() {
+		t.Error("expected users without can_view_call_digits not to see digits")
+	}
+	digits, err := call.Digits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range digits {
+		if d.Digits != "" || d.Masked == "" {
+			t.Errorf("expected only masked digits, got %#v", d)
+		}
+	}
+	if answeredBy, _ := call.FriendlyAnsweredBy(); answeredBy != "Machine (ended with a beep)" {
+		t.Errorf("expected the machine detection result to still show, got %q", answeredBy)
+	}
+}
@@ -8,6 +8,7 @@ package views
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -20,6 +21,7 @@ import (
 	"github.com/kevinburke/rest"
 	"github.com/saintpete/logrole/cache"
 	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/logging"
 	"github.com/saintpete/logrole/services"
 	twilio "github.com/saintpete/twilio-go"
 	"golang.org/x/net/context"
@@ -55,7 +57,12 @@ type Client interface {
 	GetNextAlertPageInRange(context.Context, *config.User, time.Time, time.Time, string) (*AlertPage, uint64, error)
 	GetNextRecordingPage(context.Context, *config.User, string) (*RecordingPage, error)
 	GetCallRecordings(context.Context, *config.User, string, url.Values) (*RecordingPage, error)
+	GetRecordingPage(context.Context, *config.User, url.Values) (*RecordingPage, error)
 	GetCallAlerts(context.Context, *config.User, string) (*AlertPage, error)
+	GetMessageAlerts(context.Context, *config.User, string) (*AlertPage, error)
+	GetChildCalls(context.Context, *config.User, string) (*CallPage, error)
+	LoadCallEvents(context.Context, *Call) error
+	GetResourceEvents(context.Context, *config.User, string) ([]*Event, error)
 	CacheCommonQueries(uint, <-chan bool)
 	IsTwilioNumber(num twilio.PhoneNumber) bool
 }
@@ -78,7 +85,7 @@ const averageCacheEntryBytes = 3000
 // NewClient creates a new Client encapsulating the provided values.
 func NewClient(l log.Logger, c *twilio.Client, secretKey *[32]byte, p *config.Permission) Client {
 	return &client{
-		Logger:     l,
+		Logger:     logging.Module(l, logging.ModuleViews),
 		group:      singleflight.Group{},
 		cache:      cache.NewCache(cacheSizeMB*1024*1024/averageCacheEntryBytes, l),
 		client:     c,
@@ -87,6 +94,186 @@ func NewClient(l log.Logger, c *twilio.Client, secretKey *[32]byte, p *config.Pe
 	}
 }
 
+// A Snapshotter can save its cache and load it again, so a restarted server
+// doesn't start cold. The archive client has nothing worth saving and doesn't
+// implement it.
+type Snapshotter interface {
+	SnapshotCache(w io.Writer, maxBytes int64) (int, error)
+	RestoreCache(r io.Reader) (int, error)
+}
+
+// A CacheStatter can report how big its cache is. The archive client doesn't
+// have a cache and doesn't implement it.
+type CacheStatter interface {
+	CacheStats() (entries int, size int64)
+}
+
+// CacheStats returns the number of cached API responses and their size in
+// bytes.
+func (vc *client) CacheStats() (int, int64) {
+	return vc.cache.Stats()
+}
+
+// SnapshotCache writes the cached API responses to w. See cache.Snapshot.
+func (vc *client) SnapshotCache(w io.Writer, maxBytes int64) (int, error) {
+	return vc.cache.Snapshot(w, maxBytes)
+}
+
+// RestoreCache loads cached API responses saved by SnapshotCache. Responses
+// are cached before permissions are applied, so a snapshot holds data no user
+// may be allowed to see; keep it somewhere only Logrole can read.
+func (vc *client) RestoreCache(r io.Reader) (int, error) {
+	return vc.cache.Restore(r)
+}
+
+// A Replicator can send the responses it caches to other instances, and
+// cache the ones they send it, so a standby instance is warm when it takes
+// over. The archive client has nothing worth sharing and doesn't implement it.
+type Replicator interface {
+	OnCacheSet(fn func(*cache.Entry))
+	ApplyCacheEntries(entries []*cache.Entry) int
+}
+
+// OnCacheSet calls fn with every API response the client caches. See
+// cache.OnSet.
+func (vc *client) OnCacheSet(fn func(*cache.Entry)) {
+	vc.cache.OnSet(fn)
+}
+
+// ApplyCacheEntries caches API responses sent by another instance. Like
+// snapshots, they hold data before permissions are applied.
+func (vc *client) ApplyCacheEntries(entries []*cache.Entry) int {
+	return vc.cache.Apply(entries)
+}
+
+// A CacheHistorian keeps old versions of the API responses it caches, so an
+// admin can see what a list looked like earlier. The archive client doesn't
+// have a cache and doesn't implement it.
+type CacheHistorian interface {
+	KeepCacheGenerations(n int)
+	CacheKeys() []string
+	CacheGenerations(key string) []*cache.Generation
+	GetCachedMessagePage(u *config.User, key string, set uint64) (*MessagePage, error)
+	GetCachedCallPage(u *config.User, key string, set uint64) (*CallPage, error)
+}
+
+// Kinds of cached API responses, the start of each cache key. See
+// CacheKeyKind.
+const (
+	CacheKindMessages = "messages"
+	CacheKindCalls    = "calls"
+)
+
+// CacheKeyKind returns the kind of API response cached at key, like
+// CacheKindMessages.
+func CacheKeyKind(key string) string {
+	if i := strings.IndexByte(key, '|'); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// KeepCacheGenerations keeps the last n API responses cached at each key. See
+// cache.KeepGenerations.
+func (vc *client) KeepCacheGenerations(n int) {
+	vc.cache.KeepGenerations(n)
+}
+
+// CacheKeys returns the keys of the cached API responses.
+func (vc *client) CacheKeys() []string {
+	return vc.cache.Keys()
+}
+
+// CacheGenerations returns the versions of the API response cached at key,
+// newest first.
+func (vc *client) CacheGenerations(key string) []*cache.Generation {
+	return vc.cache.Generations(key)
+}
+
+// GetCachedMessagePage returns the page of messages cached at key at set, a
+// time from CacheGenerations, with u's permissions applied, even if it's
+// expired or has been replaced since.
+func (vc *client) GetCachedMessagePage(u *config.User, key string, set uint64) (*MessagePage, error) {
+	if CacheKeyKind(key) != CacheKindMessages {
+		return nil, fmt.Errorf("%q isn't a page of messages", key)
+	}
+	page := new(twilio.MessagePage)
+	if err := vc.cache.GetGeneration(key, set, page); err != nil {
+		return nil, err
+	}
+	return NewMessagePage(page, vc.permission, u)
+}
+
+// GetCachedCallPage returns the page of calls cached at key at set, like
+// GetCachedMessagePage.
+func (vc *client) GetCachedCallPage(u *config.User, key string, set uint64) (*CallPage, error) {
+	if CacheKeyKind(key) != CacheKindCalls {
+		return nil, fmt.Errorf("%q isn't a page of calls", key)
+	}
+	page := new(twilio.CallPage)
+	if err := vc.cache.GetGeneration(key, set, page); err != nil {
+		return nil, err
+	}
+	return NewCallPage(page, vc.permission, u)
+}
+
+// A Resender can send a failed message again. The archive client can't send
+// messages and doesn't implement it.
+type Resender interface {
+	ResendMessage(ctx context.Context, u *config.User, sid string) (*Message, error)
+}
+
+// ErrNotResendable is returned when asked to resend a message that was
+// delivered, or that was sent to this account instead of from it.
+var ErrNotResendable = errors.New("Only failed or undelivered outbound messages can be resent")
+
+// ResendMessage sends the body and media of the message with the given sid
+// again, from the same number or messaging service to the same recipient, and
+// returns the new message. The caller is responsible for not sending the same
+// message twice.
+func (vc *client) ResendMessage(ctx context.Context, u *config.User, sid string) (*Message, error) {
+	if !u.CanResendMessages() {
+		return nil, config.PermissionDenied
+	}
+	original, err := vc.client.Messages.Get(ctx, sid)
+	if err != nil {
+		return nil, err
+	}
+	// Check the user could see the original, so a resend can't be used to
+	// reach messages outside their max resource age.
+	msg, err := NewMessage(original, vc.permission, u)
+	if err != nil {
+		return nil, err
+	}
+	if !msg.Resendable() {
+		return nil, ErrNotResendable
+	}
+	data := url.Values{}
+	data.Set("To", string(original.To))
+	if original.MessagingServiceSid.Valid && original.MessagingServiceSid.String != "" {
+		data.Set("MessagingServiceSid", original.MessagingServiceSid.String)
+	} else {
+		data.Set("From", string(original.From))
+	}
+	if original.Body != "" {
+		data.Set("Body", original.Body)
+	}
+	if original.NumMedia > 0 {
+		urls, err := vc.client.Messages.GetMediaURLs(ctx, sid, mediaUrlsFilters)
+		if err != nil {
+			return nil, err
+		}
+		for _, mediaURL := range urls {
+			data.Add("MediaUrl", mediaURL.String())
+		}
+	}
+	sent, err := vc.client.Messages.Create(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return NewMessage(sent, vc.permission, u)
+}
+
 func (vc *client) getNumbers() {
 	iter := vc.client.IncomingNumbers.GetPageIterator(nil)
 	size, count := 0, 0
@@ -111,9 +298,11 @@ func (vc *client) getNumbers() {
 	vc.Debug("Updated phone number map", "size", size)
 }
 
-// SetBasicAuth sets the Twilio AccountSid and AuthToken on the given request.
+// SetBasicAuth sets the Twilio credentials on the given request: the
+// AccountSid and AuthToken, or the API key sid and secret if Logrole is
+// configured with an API key.
 func (vc *client) SetBasicAuth(r *http.Request) {
-	r.SetBasicAuth(vc.client.AccountSid, vc.client.AuthToken)
+	r.SetBasicAuth(vc.client.Client.ID, vc.client.Client.Token)
 }
 
 // GetMessage fetches a single Message from the Twilio API, and returns any
@@ -189,7 +378,7 @@ var mediaUrlsFilters = url.Values{
 }
 
 // GetMediaURLs retrieves all media URL's for a given client, but encrypts and
-// obscures them behind our image proxy first.
+// obscures them behind our image proxy first. The proxy URLs only work for u.
 func (vc *client) GetMediaURLs(ctx context.Context, u *config.User, sid string) ([]*url.URL, error) {
 	if u.CanViewMedia() == false {
 		return nil, config.PermissionDenied
@@ -199,8 +388,8 @@ func (vc *client) GetMediaURLs(ctx context.Context, u *config.User, sid string)
 		return nil, err
 	}
 	opaqueImages := make([]*url.URL, len(urls))
-	for i, u := range urls {
-		enc := services.Opaque(u.String(), vc.secretKey)
+	for i, mediaURL := range urls {
+		enc := services.StableOpaqueFor(mediaURL.String(), u.ID(), vc.secretKey)
 		opaqueURL, err := url.Parse("/images/" + enc)
 		if err != nil {
 			return nil, err
@@ -219,7 +408,7 @@ func (vc *client) getAndCacheMessage(ctx context.Context, start, end time.Time,
 	if err != nil {
 		return nil, err
 	}
-	key := hash("messages", data.Encode(), start, end)
+	key := hash(CacheKindMessages, data.Encode(), start, end)
 	vc.cache.Set(key, page, frontPageTimeout)
 	return &CacheResult{Value: page}, nil
 }
@@ -249,7 +438,7 @@ func (vc *client) getAndCacheCall(ctx context.Context, start, end time.Time, dat
 	if err != nil {
 		return nil, err
 	}
-	key := hash("calls", data.Encode(), start, end)
+	key := hash(CacheKindCalls, data.Encode(), start, end)
 	vc.cache.Set(key, page, frontPageTimeout)
 	return &CacheResult{Value: page}, nil
 }
@@ -283,7 +472,7 @@ func (vc *client) cacheToMsg(user *config.User, val interface{}) (*MessagePage,
 }
 
 func (vc *client) GetMessagePageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, data url.Values) (*MessagePage, uint64, error) {
-	key := hash("messages", data.Encode(), start, end)
+	key := hash(CacheKindMessages, data.Encode(), start, end)
 	val, err := vc.group.Do(key, func() (interface{}, error) {
 		page := new(twilio.MessagePage)
 		t, err := vc.cache.Get(key, page)
@@ -299,7 +488,7 @@ func (vc *client) GetMessagePageInRange(ctx context.Context, user *config.User,
 }
 
 func (vc *client) GetNextMessagePageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, nextPage string) (*MessagePage, uint64, error) {
-	key := hash("messages", nextPage, start, end)
+	key := hash(CacheKindMessages, nextPage, start, end)
 	val, err := vc.group.Do(key, func() (interface{}, error) {
 		page := new(twilio.MessagePage)
 		t, err := vc.cache.Get(key, page)
@@ -333,7 +522,7 @@ func (vc *client) cacheToCall(user *config.User, val interface{}) (*CallPage, ui
 }
 
 func (vc *client) GetCallPageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, data url.Values) (*CallPage, uint64, error) {
-	key := hash("calls", data.Encode(), start, end)
+	key := hash(CacheKindCalls, data.Encode(), start, end)
 	val, err := vc.group.Do(key, func() (interface{}, error) {
 		page := new(twilio.CallPage)
 		t, err := vc.cache.Get(key, page)
@@ -349,7 +538,7 @@ func (vc *client) GetCallPageInRange(ctx context.Context, user *config.User, sta
 }
 
 func (vc *client) GetNextCallPageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, nextPage string) (*CallPage, uint64, error) {
-	key := hash("calls", nextPage, start, end)
+	key := hash(CacheKindCalls, nextPage, start, end)
 	val, err := vc.group.Do(key, func() (interface{}, error) {
 		page := new(twilio.CallPage)
 		t, err := vc.cache.Get(key, page)
@@ -540,9 +729,29 @@ func (vc *client) GetCallRecordings(ctx context.Context, user *config.User, call
 	return NewRecordingPage(page, vc.permission, user, vc.secretKey)
 }
 
+// GetRecordingPage returns the account's recordings matching data, which can
+// filter by CallSid, ConferenceSid and DateCreated.
+func (vc *client) GetRecordingPage(ctx context.Context, user *config.User, data url.Values) (*RecordingPage, error) {
+	page, err := vc.client.Recordings.GetPage(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecordingPage(page, vc.permission, user, vc.secretKey)
+}
+
 func (vc *client) GetCallAlerts(ctx context.Context, user *config.User, callSid string) (*AlertPage, error) {
+	return vc.resourceAlerts(ctx, user, callSid)
+}
+
+// GetMessageAlerts returns the alerts Twilio raised for the message with the
+// given sid, like a failed status callback.
+func (vc *client) GetMessageAlerts(ctx context.Context, user *config.User, messageSid string) (*AlertPage, error) {
+	return vc.resourceAlerts(ctx, user, messageSid)
+}
+
+func (vc *client) resourceAlerts(ctx context.Context, user *config.User, sid string) (*AlertPage, error) {
 	data := url.Values{}
-	data.Set("ResourceSid", callSid)
+	data.Set("ResourceSid", sid)
 	data.Set("PageSize", "400")
 	page, err := vc.client.Monitor.Alerts.GetPage(ctx, data)
 	if err != nil {
@@ -551,6 +760,32 @@ func (vc *client) GetCallAlerts(ctx context.Context, user *config.User, callSid
 	return NewAlertPage(page, vc.permission, user)
 }
 
+// GetChildCalls returns the calls created by the call with the given sid,
+// for example with <Dial>.
+func (vc *client) GetChildCalls(ctx context.Context, user *config.User, parentSid string) (*CallPage, error) {
+	data := url.Values{}
+	data.Set("ParentCallSid", parentSid)
+	data.Set("PageSize", "100")
+	page, err := vc.client.Calls.GetPage(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return NewCallPage(page, vc.permission, user)
+}
+
+// GetResourceEvents returns the most recent changes to the resource with the
+// given sid, newest first, from the Monitor Events API.
+func (vc *client) GetResourceEvents(ctx context.Context, user *config.User, resourceSid string) ([]*Event, error) {
+	data := url.Values{}
+	data.Set("ResourceSid", resourceSid)
+	data.Set("PageSize", "100")
+	page := new(monitorEventPage)
+	if err := vc.client.Monitor.ListResource(ctx, "Events", data, page); err != nil {
+		return nil, err
+	}
+	return newEvents(page, vc.permission, user)
+}
+
 func (vc *client) CacheCommonQueries(pageSize uint, doneCh <-chan bool) {
 	timeout := time.After(1 * time.Millisecond)
 	ps := strconv.FormatUint(uint64(pageSize), 10)
@@ -576,7 +811,7 @@ func (vc *client) CacheCommonQueries(pageSize uint, doneCh <-chan bool) {
 
 func (vc *client) IsTwilioNumber(num twilio.PhoneNumber) bool {
 	vc.numbersMu.RLock()
-	_, ok := vc.numbers[num]
+	_, ok := vc.numbers[services.BareNumber(num)]
 	vc.numbersMu.RUnlock()
 	return ok
 }
@@ -84,10 +84,24 @@ func (c *Conference) CanViewProperty(property string) bool {
 	if c.user == nil {
 		return false
 	}
+	return c.user.HasPermission(conferencePermission(property))
+}
+
+// HiddenReason explains why property is hidden, if the user is debugging
+// permissions.
+func (c *Conference) HiddenReason(property string) string {
+	if c.user == nil {
+		return ""
+	}
+	return c.user.HiddenReason(conferencePermission(property))
+}
+
+// conferencePermission returns the permission needed to view property.
+func conferencePermission(property string) string {
 	switch property {
 	case "Sid", "DateCreated", "DateUpdated", "APIVersion", "AccountSID",
 		"URI", "Status", "FriendlyName", "Region":
-		return c.user.CanViewConferences()
+		return "can_view_conferences"
 	default:
 		panic("unknown property " + property)
 	}
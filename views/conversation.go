@@ -0,0 +1,519 @@
+package views
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+
+	types "github.com/kevinburke/go-types"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+// Conversations live in the Conversations API, which twilio-go doesn't
+// support.
+const conversationsBaseURL = "https://conversations.twilio.com"
+
+// How many participants and messages to show on a conversation's page; the
+// messages are the most recent ones.
+const (
+	maxConversationParticipants = 100
+	maxConversationMessages     = 50
+)
+
+// Channels a conversation participant can take part over.
+const (
+	ChannelSMS      = services.ChannelSMS
+	ChannelWhatsApp = services.ChannelWhatsApp
+	ChannelChat     = "Chat"
+)
+
+var validConversationStates = []string{"active", "inactive", "closed"}
+
+// ConversationStates returns the states a conversation can be in, for
+// filtering the list.
+func ConversationStates() []string {
+	return validConversationStates
+}
+
+type conversationResource struct {
+	Sid                 string            `json:"sid"`
+	FriendlyName        types.NullString  `json:"friendly_name"`
+	UniqueName          types.NullString  `json:"unique_name"`
+	State               string            `json:"state"`
+	MessagingServiceSid types.NullString  `json:"messaging_service_sid"`
+	DateCreated         twilio.TwilioTime `json:"date_created"`
+	DateUpdated         twilio.TwilioTime `json:"date_updated"`
+}
+
+type conversationBinding struct {
+	Type         string `json:"type"`
+	Address      string `json:"address"`
+	ProxyAddress string `json:"proxy_address"`
+}
+
+type conversationParticipant struct {
+	Sid              string               `json:"sid"`
+	Identity         types.NullString     `json:"identity"`
+	MessagingBinding *conversationBinding `json:"messaging_binding"`
+	DateCreated      twilio.TwilioTime    `json:"date_created"`
+}
+
+type conversationMessage struct {
+	Sid            string            `json:"sid"`
+	Index          int               `json:"index"`
+	Author         string            `json:"author"`
+	Body           types.NullString  `json:"body"`
+	Media          []json.RawMessage `json:"media"`
+	ParticipantSid types.NullString  `json:"participant_sid"`
+	DateCreated    twilio.TwilioTime `json:"date_created"`
+}
+
+type conversationPage struct {
+	Meta          twilio.Meta             `json:"meta"`
+	Conversations []*conversationResource `json:"conversations"`
+}
+
+type conversationParticipantPage struct {
+	Meta         twilio.Meta                `json:"meta"`
+	Participants []*conversationParticipant `json:"participants"`
+}
+
+type conversationMessagePage struct {
+	Meta     twilio.Meta            `json:"meta"`
+	Messages []*conversationMessage `json:"messages"`
+}
+
+// A ConversationFinder looks up conversations in the Conversations API. The
+// archive client doesn't have any, and doesn't implement it.
+type ConversationFinder interface {
+	// GetConversationPage returns a page of conversations. If next is
+	// non-empty, it's the path of the page to fetch, and data is ignored.
+	GetConversationPage(ctx context.Context, u *config.User, data url.Values, next string) (*ConversationPage, error)
+	// GetConversation returns a conversation with its participants and most
+	// recent messages.
+	GetConversation(ctx context.Context, u *config.User, sid string) (*Conversation, error)
+}
+
+// A Conversation is a thread of messages between participants, each of whom
+// can take part over SMS, WhatsApp or chat. Conversations use the same
+// permissions as messages. Since the same participant sends some messages
+// and receives others, their addresses are only shown to users who can see
+// both the From and To of a message.
+type Conversation struct {
+	user         *config.User
+	conversation *conversationResource
+	participants []*ConversationParticipant
+	messages     []*ConversationMessage
+	moreMessages bool
+}
+
+// A ConversationParticipant is someone taking part in a conversation.
+type ConversationParticipant struct {
+	user        *config.User
+	participant *conversationParticipant
+}
+
+// A ConversationMessage is a message sent in a conversation.
+type ConversationMessage struct {
+	user    *config.User
+	message *conversationMessage
+	channel string
+}
+
+type ConversationPage struct {
+	conversations   []*Conversation
+	nextPageURI     types.NullString
+	previousPageURI types.NullString
+}
+
+func (cp *ConversationPage) Conversations() []*Conversation {
+	return cp.conversations
+}
+
+// NextPageURI returns the path of the next page of conversations, without
+// the Conversations API host.
+func (cp *ConversationPage) NextPageURI() types.NullString {
+	return cp.nextPageURI
+}
+
+func (cp *ConversationPage) PreviousPageURI() types.NullString {
+	return cp.previousPageURI
+}
+
+func (cp *ConversationPage) ShowHeader(fieldName string) bool {
+	if cp == nil || len(cp.conversations) == 0 {
+		return showAllColumnsOnEmptyPage
+	}
+	for _, c := range cp.conversations {
+		if c.CanViewProperty(fieldName) {
+			return true
+		}
+	}
+	return false
+}
+
+// conversationPermissions returns the permissions needed to view property
+// of a conversation, participant or message.
+func conversationPermissions(property string) []string {
+	switch property {
+	case "Sid", "FriendlyName", "State", "DateCreated", "DateUpdated",
+		"Channel", "Index", "MessagingServiceSid":
+		return []string{"can_view_messages"}
+	case "Address", "ProxyAddress", "Author":
+		return []string{"can_view_message_from", "can_view_message_to"}
+	case "Body":
+		return []string{"can_view_message_body"}
+	case "NumMedia":
+		return []string{"can_view_num_media"}
+	default:
+		panic("unknown property " + property)
+	}
+}
+
+func canViewConversationProperty(u *config.User, property string) bool {
+	if u == nil {
+		return false
+	}
+	for _, perm := range conversationPermissions(property) {
+		if !u.HasPermission(perm) {
+			return false
+		}
+	}
+	return true
+}
+
+func conversationHiddenReason(u *config.User, property string) string {
+	if u == nil {
+		return ""
+	}
+	for _, perm := range conversationPermissions(property) {
+		if reason := u.HiddenReason(perm); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+func (c *Conversation) CanViewProperty(property string) bool {
+	return canViewConversationProperty(c.user, property)
+}
+
+// HiddenReason explains why property is hidden, if the user is debugging
+// permissions.
+func (c *Conversation) HiddenReason(property string) string {
+	return conversationHiddenReason(c.user, property)
+}
+
+func (c *Conversation) Sid() (string, error) {
+	if c.CanViewProperty("Sid") {
+		return c.conversation.Sid, nil
+	}
+	return "", config.PermissionDenied
+}
+
+// FriendlyName returns the conversation's friendly name, or its unique name
+// if it doesn't have one.
+func (c *Conversation) FriendlyName() (string, error) {
+	if !c.CanViewProperty("FriendlyName") {
+		return "", config.PermissionDenied
+	}
+	if c.conversation.FriendlyName.String != "" {
+		return c.conversation.FriendlyName.String, nil
+	}
+	return c.conversation.UniqueName.String, nil
+}
+
+func (c *Conversation) State() (string, error) {
+	if c.CanViewProperty("State") {
+		return c.conversation.State, nil
+	}
+	return "", config.PermissionDenied
+}
+
+func (c *Conversation) MessagingServiceSid() (string, error) {
+	if c.CanViewProperty("MessagingServiceSid") {
+		return c.conversation.MessagingServiceSid.String, nil
+	}
+	return "", config.PermissionDenied
+}
+
+func (c *Conversation) DateCreated() (twilio.TwilioTime, error) {
+	if c.CanViewProperty("DateCreated") {
+		return c.conversation.DateCreated, nil
+	}
+	return twilio.TwilioTime{}, config.PermissionDenied
+}
+
+func (c *Conversation) DateUpdated() (twilio.TwilioTime, error) {
+	if c.CanViewProperty("DateUpdated") {
+		return c.conversation.DateUpdated, nil
+	}
+	return twilio.TwilioTime{}, config.PermissionDenied
+}
+
+func (c *Conversation) Participants() []*ConversationParticipant {
+	return c.participants
+}
+
+// Messages returns the conversation's most recent messages, oldest first.
+func (c *Conversation) Messages() []*ConversationMessage {
+	return c.messages
+}
+
+// MoreMessages returns true if the conversation has older messages than the
+// ones in Messages.
+func (c *Conversation) MoreMessages() bool {
+	return c.moreMessages
+}
+
+func (p *ConversationParticipant) CanViewProperty(property string) bool {
+	return canViewConversationProperty(p.user, property)
+}
+
+func (p *ConversationParticipant) HiddenReason(property string) string {
+	return conversationHiddenReason(p.user, property)
+}
+
+func (p *ConversationParticipant) Sid() (string, error) {
+	if p.CanViewProperty("Sid") {
+		return p.participant.Sid, nil
+	}
+	return "", config.PermissionDenied
+}
+
+// Channel returns ChannelSMS, ChannelWhatsApp or ChannelChat.
+func (p *ConversationParticipant) Channel() string {
+	return p.participant.channel()
+}
+
+func (p *conversationParticipant) channel() string {
+	if p.MessagingBinding == nil {
+		return ChannelChat
+	}
+	if p.MessagingBinding.Type == "whatsapp" {
+		return ChannelWhatsApp
+	}
+	channel, _ := services.SplitChannel(p.MessagingBinding.Address)
+	return channel
+}
+
+// Address returns the participant's phone number or WhatsApp address, or
+// their chat identity.
+func (p *ConversationParticipant) Address() (string, error) {
+	if !p.CanViewProperty("Address") {
+		return "", config.PermissionDenied
+	}
+	if p.participant.MessagingBinding == nil {
+		return p.participant.Identity.String, nil
+	}
+	return p.participant.MessagingBinding.Address, nil
+}
+
+// ProxyAddress returns the Twilio number the participant's messages are sent
+// from and to, or the empty string for chat participants.
+func (p *ConversationParticipant) ProxyAddress() (string, error) {
+	if !p.CanViewProperty("ProxyAddress") {
+		return "", config.PermissionDenied
+	}
+	if p.participant.MessagingBinding == nil {
+		return "", nil
+	}
+	return p.participant.MessagingBinding.ProxyAddress, nil
+}
+
+func (m *ConversationMessage) CanViewProperty(property string) bool {
+	return canViewConversationProperty(m.user, property)
+}
+
+func (m *ConversationMessage) HiddenReason(property string) string {
+	return conversationHiddenReason(m.user, property)
+}
+
+func (m *ConversationMessage) Sid() (string, error) {
+	if m.CanViewProperty("Sid") {
+		return m.message.Sid, nil
+	}
+	return "", config.PermissionDenied
+}
+
+func (m *ConversationMessage) Index() (int, error) {
+	if m.CanViewProperty("Index") {
+		return m.message.Index, nil
+	}
+	return 0, config.PermissionDenied
+}
+
+// Channel returns the channel of the participant who sent the message.
+func (m *ConversationMessage) Channel() string {
+	return m.channel
+}
+
+func (m *ConversationMessage) Author() (string, error) {
+	if m.CanViewProperty("Author") {
+		return m.message.Author, nil
+	}
+	return "", config.PermissionDenied
+}
+
+// SafeBody returns the body with invisible and direction-changing characters
+// replaced by placeholders.
+func (m *ConversationMessage) SafeBody() (string, error) {
+	if !m.CanViewProperty("Body") {
+		return "", config.PermissionDenied
+	}
+	body, _ := SanitizeBody(m.message.Body.String)
+	return body, nil
+}
+
+func (m *ConversationMessage) NumMedia() (int, error) {
+	if m.CanViewProperty("NumMedia") {
+		return len(m.message.Media), nil
+	}
+	return 0, config.PermissionDenied
+}
+
+func (m *ConversationMessage) DateCreated() (twilio.TwilioTime, error) {
+	if m.CanViewProperty("DateCreated") {
+		return m.message.DateCreated, nil
+	}
+	return twilio.TwilioTime{}, config.PermissionDenied
+}
+
+// newConversation returns the conversation, or config.ErrTooOld if it hasn't
+// been updated within the max resource age. A conversation that's still in
+// use can be much older than its latest messages, so its age is the time of
+// its last update, and messages older than the max resource age are left
+// out.
+func newConversation(c *conversationResource, participants []*conversationParticipant, messages []*conversationMessage, p *config.Permission, u *config.User) (*Conversation, error) {
+	if !u.CanViewMessages() {
+		return nil, config.PermissionDenied
+	}
+	updated := c.DateUpdated
+	if !updated.Valid {
+		updated = c.DateCreated
+	}
+	if !updated.Valid {
+		return nil, errors.New("Invalid DateCreated for conversation")
+	}
+	if !u.CanViewResource(updated.Time, p.MaxResourceAge()) {
+		return nil, config.ErrTooOld
+	}
+	for _, cp := range participants {
+		if cp.MessagingBinding == nil {
+			continue
+		}
+		if !u.CanViewNumbers(cp.MessagingBinding.Address, cp.MessagingBinding.ProxyAddress) ||
+			p.Blocklist().Hides(u, cp.MessagingBinding.Address, cp.MessagingBinding.ProxyAddress) {
+			return nil, config.PermissionDenied
+		}
+	}
+	conv := &Conversation{user: u, conversation: c}
+	channels := make(map[string]string, len(participants))
+	for _, cp := range participants {
+		channels[cp.Sid] = cp.channel()
+		conv.participants = append(conv.participants, &ConversationParticipant{user: u, participant: cp})
+	}
+	for _, m := range messages {
+		if !m.DateCreated.Valid || !u.CanViewResource(m.DateCreated.Time, p.MaxResourceAge()) {
+			conv.moreMessages = true
+			continue
+		}
+		channel, ok := channels[m.ParticipantSid.String]
+		if !ok {
+			// Messages sent with the REST API don't belong to a participant.
+			channel = ChannelChat
+		}
+		conv.messages = append(conv.messages, &ConversationMessage{user: u, message: m, channel: channel})
+	}
+	return conv, nil
+}
+
+// relativePageURI strips the host from a Conversations API page URL, so it
+// can be passed back to GetConversationPage.
+func relativePageURI(u types.NullString) types.NullString {
+	if !u.Valid || u.String == "" {
+		return types.NullString{}
+	}
+	parsed, err := url.Parse(u.String)
+	if err != nil {
+		return types.NullString{}
+	}
+	return types.NullString{Valid: true, String: parsed.RequestURI()}
+}
+
+func (vc *client) GetConversationPage(ctx context.Context, u *config.User, data url.Values, next string) (*ConversationPage, error) {
+	if !u.CanViewMessages() {
+		return nil, config.PermissionDenied
+	}
+	rc := vc.restClient(conversationsBaseURL)
+	page := new(conversationPage)
+	path := "/v1/Conversations"
+	if next != "" {
+		path, data = next, nil
+	}
+	if err := getRestResource(ctx, rc, path, data, page); err != nil {
+		return nil, err
+	}
+	cp := &ConversationPage{
+		conversations:   make([]*Conversation, 0, len(page.Conversations)),
+		previousPageURI: relativePageURI(page.Meta.PreviousPageURL),
+	}
+	for _, c := range page.Conversations {
+		conv, err := newConversation(c, nil, nil, vc.permission, u)
+		if err == config.ErrTooOld {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		cp.conversations = append(cp.conversations, conv)
+	}
+	if len(cp.conversations) > 0 {
+		cp.nextPageURI = relativePageURI(page.Meta.NextPageURL)
+	}
+	return cp, nil
+}
+
+func (vc *client) GetConversation(ctx context.Context, u *config.User, sid string) (*Conversation, error) {
+	if !u.CanViewMessages() {
+		return nil, config.PermissionDenied
+	}
+	rc := vc.restClient(conversationsBaseURL)
+	path := "/v1/Conversations/" + sid
+	c := new(conversationResource)
+	participants := new(conversationParticipantPage)
+	messages := new(conversationMessagePage)
+	g, errctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return getRestResource(errctx, rc, path, nil, c)
+	})
+	g.Go(func() error {
+		data := url.Values{"PageSize": []string{strconv.Itoa(maxConversationParticipants)}}
+		return getRestResource(errctx, rc, path+"/Participants", data, participants)
+	})
+	g.Go(func() error {
+		data := url.Values{"PageSize": []string{strconv.Itoa(maxConversationMessages)}, "Order": []string{"desc"}}
+		return getRestResource(errctx, rc, path+"/Messages", data, messages)
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	// Fetched newest first, so the page has the most recent messages.
+	msgs := make([]*conversationMessage, len(messages.Messages))
+	for i, m := range messages.Messages {
+		msgs[len(msgs)-1-i] = m
+	}
+	conv, err := newConversation(c, participants.Participants, msgs, vc.permission, u)
+	if err != nil {
+		return nil, err
+	}
+	if messages.Meta.NextPageURL.Valid && messages.Meta.NextPageURL.String != "" {
+		conv.moreMessages = true
+	}
+	return conv, nil
+}
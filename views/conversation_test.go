@@ -0,0 +1,58 @@
+package views
+
+import (
+	"testing"
+	"time"
+
+	types "github.com/kevinburke/go-types"
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+func TestConversationMasking(t *testing.T) {
+	t.Parallel()
+	now := time.Now().UTC()
+	c := &conversationResource{Sid: "CH123", DateCreated: twilio.TwilioTime{Valid: true, Time: now.Add(-48 * time.Hour)}, DateUpdated: twilio.TwilioTime{Valid: true, Time: now}}
+	participants := []*conversationParticipant{
+		{Sid: "MB1", MessagingBinding: &conversationBinding{Type: "sms", Address: "+14105551234", ProxyAddress: "+19253920364"}},
+		{Sid: "MB2", MessagingBinding: &conversationBinding{Type: "whatsapp", Address: "whatsapp:+14105556789"}},
+		{Sid: "MB3", Identity: types.NullString{Valid: true, String: "agent@example.com"}},
+	}
+	messages := []*conversationMessage{
+		{Sid: "IM1", Author: "+14105551234", Body: types.NullString{Valid: true, String: "hi"}, ParticipantSid: types.NullString{Valid: true, String: "MB1"}, DateCreated: twilio.TwilioTime{Valid: true, Time: now.Add(-47 * time.Hour)}},
+		{Sid: "IM2", Author: "agent@example.com", Body: types.NullString{Valid: true, String: "hello"}, ParticipantSid: types.NullString{Valid: true, String: "MB3"}, DateCreated: twilio.TwilioTime{Valid: true, Time: now}},
+	}
+	us := config.AllUserSettings()
+	us.CanViewMessageTo = false
+	us.CanViewMessageBody = false
+	us.MaxResourceAge = 0
+	conv, err := newConversation(c, participants, messages, config.NewPermission(24*time.Hour), config.NewUser(us))
+	if err != nil {
+		t.Fatal(err)
+	}
+	channels := []string{ChannelSMS, ChannelWhatsApp, ChannelChat}
+	for i, p := range conv.Participants() {
+		if p.Channel() != channels[i] {
+			t.Errorf("participant %d: expected channel %s, got %s", i, channels[i], p.Channel())
+		}
+		if _, err := p.Address(); err != config.PermissionDenied {
+			t.Errorf("participant %d: expected the address to be hidden without can_view_message_to, got %v", i, err)
+		}
+	}
+	// The first message is older than the max resource age.
+	msgs := conv.Messages()
+	if len(msgs) != 1 || !conv.MoreMessages() {
+		t.Fatalf("expected one message and more to be hidden, got %d", len(msgs))
+	}
+	if msgs[0].Channel() != ChannelChat {
+		t.Errorf("expected the message's channel to come from its participant, got %s", msgs[0].Channel())
+	}
+	if _, err := msgs[0].SafeBody(); err != config.PermissionDenied {
+		t.Errorf("expected the body to be hidden, got %v", err)
+	}
+
+	us.CanViewMessages = false
+	if _, err := newConversation(c, nil, nil, config.NewPermission(24*time.Hour), config.NewUser(us)); err != config.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package views
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	types "github.com/kevinburke/go-types"
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// monitorEvent is a change to a resource in the account, as returned by the
+// Monitor Events API - someone changing a phone number's webhook URLs, for
+// example. twilio-go doesn't have a type for these.
+type monitorEvent struct {
+	Sid          string            `json:"sid"`
+	EventType    string            `json:"event_type"`
+	EventDate    twilio.TwilioTime `json:"event_date"`
+	ResourceSid  string            `json:"resource_sid"`
+	ResourceType string            `json:"resource_type"`
+	ActorType    string            `json:"actor_type"`
+	ActorSid     types.NullString  `json:"actor_sid"`
+	Description  types.NullString  `json:"description"`
+	// Keyed by the name of the changed field. The values are usually objects
+	// with "previous" and "updated" keys.
+	EventData map[string]json.RawMessage `json:"event_data"`
+}
+
+type monitorEventPage struct {
+	Meta   twilio.Meta     `json:"meta"`
+	Events []*monitorEvent `json:"events"`
+}
+
+// An Event is a change to a resource, like a phone number.
+type Event struct {
+	user  *config.User
+	event *monitorEvent
+}
+
+// An EventChange is a field that was changed by an Event.
+type EventChange struct {
+	Field    string
+	Previous string
+	Updated  string
+}
+
+// NewEvent returns an Event, or an error if the user can't see it. Events
+// follow the same age rules as other resources.
+func NewEvent(event *monitorEvent, p *config.Permission, u *config.User) (*Event, error) {
+	if !event.EventDate.Valid {
+		return nil, errors.New("Invalid EventDate for event")
+	}
+	if !u.CanViewResource(event.EventDate.Time, p.MaxResourceAge()) {
+		return nil, config.ErrTooOld
+	}
+	return &Event{user: u, event: event}, nil
+}
+
+func newEvents(page *monitorEventPage, p *config.Permission, u *config.User) ([]*Event, error) {
+	events := make([]*Event, 0, len(page.Events))
+	for _, event := range page.Events {
+		e, err := NewEvent(event, p, u)
+		if err == config.ErrTooOld || err == config.PermissionDenied {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func (e *Event) Sid() string {
+	return e.event.Sid
+}
+
+// EventType is a string like "incoming-phone-number.updated".
+func (e *Event) EventType() string {
+	return e.event.EventType
+}
+
+func (e *Event) EventDate() twilio.TwilioTime {
+	return e.event.EventDate
+}
+
+// Actor returns who made the change, like "account AC123" or "user US123".
+func (e *Event) Actor() string {
+	if e.event.ActorSid.Valid {
+		return e.event.ActorType + " " + e.event.ActorSid.String
+	}
+	return e.event.ActorType
+}
+
+func (e *Event) Description() string {
+	return e.event.Description.String
+}
+
+// CanViewChanges returns true if the user can see the previous and updated
+// values of changed fields. These are often webhook URLs.
+func (e *Event) CanViewChanges() bool {
+	return e.user.CanViewCallbackURLs()
+}
+
+// Changes returns the fields changed by the event, sorted by name. The
+// previous and updated values are left blank if the user can't view them.
+func (e *Event) Changes() []*EventChange {
+	changes := make([]*EventChange, 0, len(e.event.EventData))
+	for field, raw := range e.event.EventData {
+		change := &EventChange{Field: field}
+		if e.CanViewChanges() {
+			var values struct {
+				Previous interface{} `json:"previous"`
+				Updated  interface{} `json:"updated"`
+			}
+			if err := json.Unmarshal(raw, &values); err == nil && (values.Previous != nil || values.Updated != nil) {
+				change.Previous = eventValue(values.Previous)
+				change.Updated = eventValue(values.Updated)
+			} else {
+				change.Updated = string(raw)
+			}
+		}
+		changes = append(changes, change)
+	}
+	sort.Sort(eventChanges(changes))
+	return changes
+}
+
+func eventValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	default:
+		data, _ := json.Marshal(t)
+		return string(data)
+	}
+}
+
+type eventChanges []*EventChange
+
+func (c eventChanges) Len() int           { return len(c) }
+func (c eventChanges) Less(i, j int) bool { return c[i].Field < c[j].Field }
+func (c eventChanges) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
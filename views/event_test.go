@@ -0,0 +1,59 @@
+package views
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+func TestEventChanges(t *testing.T) {
+	t.Parallel()
+	me := &monitorEvent{
+		EventType: "incoming-phone-number.updated",
+		EventDate: twilio.TwilioTime{Valid: true, Time: time.Now()},
+		EventData: map[string]json.RawMessage{
+			"sms_url":   json.RawMessage(`{"previous": "https://old.example.com", "updated": "https://new.example.com"}`),
+			"voice_url": json.RawMessage(`{"previous": null, "updated": "https://voice.example.com"}`),
+		},
+	}
+	p := config.NewPermission(24 * time.Hour)
+	event, err := NewEvent(me, p, config.NewUser(config.AllUserSettings()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := event.Changes()
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %d", len(changes))
+	}
+	if c := changes[0]; c.Field != "sms_url" || c.Previous != "https://old.example.com" || c.Updated != "https://new.example.com" {
+		t.Errorf("unexpected first change %#v", c)
+	}
+	if c := changes[1]; c.Field != "voice_url" || c.Previous != "" || c.Updated != "https://voice.example.com" {
+		t.Errorf("unexpected second change %#v", c)
+	}
+
+	s := config.AllUserSettings()
+	s.CanViewCallbackURLs = false
+	event, err = NewEvent(me, p, config.NewUser(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range event.Changes() {
+		if c.Previous != "" || c.Updated != "" {
+			t.Errorf("expected values to be hidden, got %#v", c)
+		}
+	}
+}
+
+func TestEventTooOld(t *testing.T) {
+	t.Parallel()
+	me := &monitorEvent{EventDate: twilio.TwilioTime{Valid: true, Time: time.Now().Add(-48 * time.Hour)}}
+	s := config.AllUserSettings()
+	s.MaxResourceAge = 0
+	if _, err := NewEvent(me, config.NewPermission(24*time.Hour), config.NewUser(s)); err != config.ErrTooOld {
+		t.Errorf("expected ErrTooOld, got %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package views
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	types "github.com/kevinburke/go-types"
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// Fixtures are resources for a Client to serve from memory, in the format the
+// Twilio API returns them. They can be in any order.
+type Fixtures struct {
+	Messages    []*twilio.Message
+	Calls       []*twilio.Call
+	Conferences []*twilio.Conference
+	Alerts      []*twilio.Alert
+	Numbers     []*twilio.IncomingPhoneNumber
+}
+
+// NewFixtureClient returns a Client that serves the resources in f, instead
+// of asking the Twilio API, for handler tests and demos. Lists are filtered
+// and paged like Twilio's, and resources pass through the same permission
+// checks. There is no media, recordings or events. f isn't modified, and
+// changes to it after NewFixtureClient returns aren't seen.
+func NewFixtureClient(l log.Logger, accountSid string, p *config.Permission, f *Fixtures) Client {
+	return newArchiveClient(l, accountSid, p, f, "the fixtures")
+}
+
+// DemoAccountSid is the account the demo fixtures belong to.
+const DemoAccountSid = "AC00000000000000000000000000000de0"
+
+// The demo numbers are in the 555-01XX range, which is reserved for fiction.
+var demoNumbers = []struct {
+	number twilio.PhoneNumber
+	name   string
+}{
+	{"+14155550100", "Main support line"},
+	{"+14155550101", "Sales"},
+	{"+14155550102", "Appointment reminders"},
+}
+
+var demoBodies = []string{
+	"Hi! Your order has shipped and should arrive on Thursday.",
+	"Can I move my appointment to next week?",
+	"Reminder: your appointment is tomorrow at 10:30am. Reply C to cancel.",
+	"Thanks, that worked 👍",
+	"Your verification code is 481516.",
+	"STOP",
+	"Where is my package? It was supposed to be here yesterday.",
+	"We're sorry for the wait - an agent will call you back within the hour.",
+}
+
+// demoSid returns the sid of the nth demo resource with the given prefix, like
+// "SM".
+func demoSid(prefix string, n int) string {
+	return fmt.Sprintf("%s%032x", prefix, n+0xde0000)
+}
+
+func demoCustomer(n int) twilio.PhoneNumber {
+	return twilio.PhoneNumber(fmt.Sprintf("+1212555%04d", 110+n%40))
+}
+
+func demoTime(t time.Time) twilio.TwilioTime {
+	return twilio.TwilioTime{Valid: true, Time: t}
+}
+
+// DemoFixtures returns a few days of made up messages, calls, conferences,
+// alerts and phone numbers, ending at now, for the demo.
+func DemoFixtures(now time.Time) *Fixtures {
+	now = now.UTC().Truncate(time.Minute)
+	f := new(Fixtures)
+	for i, dn := range demoNumbers {
+		f.Numbers = append(f.Numbers, &twilio.IncomingPhoneNumber{
+			Sid:          demoSid("PN", i),
+			PhoneNumber:  dn.number,
+			FriendlyName: dn.name,
+			DateCreated:  demoTime(now.Add(-time.Duration(90+30*i) * 24 * time.Hour)),
+		})
+	}
+	for i := 0; i < 150; i++ {
+		created := now.Add(-time.Duration(i*27) * time.Minute)
+		ours := demoNumbers[i%len(demoNumbers)].number
+		m := &twilio.Message{
+			Sid:         demoSid("SM", i),
+			Body:        demoBodies[i%len(demoBodies)],
+			NumSegments: 1,
+			Price:       "-0.00790",
+			PriceUnit:   "USD",
+			DateCreated: demoTime(created),
+			DateSent:    demoTime(created.Add(2 * time.Second)),
+		}
+		if i%3 == 1 {
+			m.From, m.To = demoCustomer(i), ours
+			m.Direction = twilio.DirectionInbound
+			m.Status = twilio.StatusReceived
+		} else {
+			m.From, m.To = ours, demoCustomer(i)
+			m.Direction = twilio.DirectionOutboundAPI
+			m.Status = twilio.StatusDelivered
+		}
+		if i%17 == 5 {
+			m.Status = twilio.StatusUndelivered
+			m.ErrorCode = 30003
+			f.Alerts = append(f.Alerts, &twilio.Alert{
+				Sid:         demoSid("NO", len(f.Alerts)),
+				ErrorCode:   30003,
+				LogLevel:    twilio.LogLevelWarning,
+				AlertText:   "Unreachable destination handset",
+				ResourceSid: m.Sid,
+				DateCreated: demoTime(created.Add(time.Minute)),
+			})
+		}
+		f.Messages = append(f.Messages, m)
+	}
+	for i := 0; i < 60; i++ {
+		start := now.Add(-time.Duration(i*71) * time.Minute)
+		ours := demoNumbers[i%2].number
+		c := &twilio.Call{
+			Sid:         demoSid("CA", i),
+			From:        demoCustomer(i),
+			To:          ours,
+			Direction:   twilio.DirectionInbound,
+			Status:      twilio.StatusCompleted,
+			Price:       "-0.00850",
+			PriceUnit:   "USD",
+			DateCreated: demoTime(start.Add(-time.Second)),
+			StartTime:   demoTime(start),
+		}
+		switch {
+		case i%9 == 4:
+			c.Status = twilio.StatusNoAnswer
+			c.Price = ""
+		case i%13 == 7:
+			c.Status = twilio.StatusBusy
+			c.Price = ""
+		default:
+			d := time.Duration(45+(i*37)%600) * time.Second
+			c.Duration = twilio.TwilioDuration(d)
+			c.EndTime = demoTime(start.Add(d))
+		}
+		if i%4 == 2 {
+			c.From, c.To = ours, demoCustomer(i)
+			c.Direction = twilio.DirectionOutboundAPI
+		}
+		f.Calls = append(f.Calls, c)
+		if i%10 == 3 {
+			// An escalation, forwarded to a supervisor.
+			f.Calls = append(f.Calls, &twilio.Call{
+				Sid:           demoSid("CA", 1000+i),
+				ParentCallSid: types.NullString{Valid: true, String: c.Sid},
+				From:          c.To,
+				To:            "+12125550199",
+				Direction:     twilio.DirectionOutboundDial,
+				Status:        twilio.StatusCompleted,
+				Duration:      twilio.TwilioDuration(90 * time.Second),
+				DateCreated:   demoTime(start.Add(20 * time.Second)),
+				StartTime:     demoTime(start.Add(25 * time.Second)),
+				EndTime:       demoTime(start.Add(115 * time.Second)),
+			})
+		}
+	}
+	for i := 0; i < 5; i++ {
+		created := now.Add(-time.Duration(i*13+2) * time.Hour)
+		conf := &twilio.Conference{
+			Sid:          demoSid("CF", i),
+			FriendlyName: fmt.Sprintf("Escalation %d", 5-i),
+			Status:       twilio.StatusCompleted,
+			DateCreated:  demoTime(created),
+			DateUpdated:  demoTime(created.Add(18 * time.Minute)),
+		}
+		if i == 0 {
+			conf.Status = twilio.StatusInProgress
+			conf.DateUpdated = conf.DateCreated
+		}
+		f.Conferences = append(f.Conferences, conf)
+	}
+	f.Alerts = append(f.Alerts, &twilio.Alert{
+		Sid:           demoSid("NO", len(f.Alerts)),
+		ErrorCode:     11200,
+		LogLevel:      twilio.LogLevelError,
+		AlertText:     "HTTP retrieval failure",
+		ResourceSid:   f.Calls[3].Sid,
+		RequestURL:    "https://example.com/twilio/voice",
+		RequestMethod: "POST",
+		DateCreated:   f.Calls[3].DateCreated,
+	})
+	return f
+}
@@ -0,0 +1,92 @@
+package views
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/handlers"
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+var demoSidPattern = regexp.MustCompile(`^(SM|CA|CF|NO|PN)[a-f0-9]{32}$`)
+
+func TestDemoFixtureSids(t *testing.T) {
+	t.Parallel()
+	f := DemoFixtures(time.Now())
+	seen := make(map[string]bool)
+	check := func(sid string) {
+		if !demoSidPattern.MatchString(sid) {
+			t.Errorf("expected %q to look like a Twilio sid", sid)
+		}
+		if seen[sid] {
+			t.Errorf("sid %s is used twice", sid)
+		}
+		seen[sid] = true
+	}
+	for _, m := range f.Messages {
+		check(m.Sid)
+	}
+	for _, c := range f.Calls {
+		check(c.Sid)
+	}
+	for _, c := range f.Conferences {
+		check(c.Sid)
+	}
+	for _, a := range f.Alerts {
+		check(a.Sid)
+	}
+	for _, n := range f.Numbers {
+		check(n.Sid)
+	}
+}
+
+func TestFixtureClient(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	f := DemoFixtures(now)
+	// Fixtures can be in any order.
+	f.Messages[0], f.Messages[5] = f.Messages[5], f.Messages[0]
+	vc := NewFixtureClient(handlers.Logger, DemoAccountSid, config.NewPermission(720*time.Hour), f)
+	u := config.NewUser(config.AllUserSettings())
+	ctx := context.Background()
+	iter := MessagesInRange(vc, u, now.Add(-24*time.Hour), now.Add(time.Minute), url.Values{"PageSize": []string{"20"}})
+	iter.Interval = 0
+	var count int
+	var last time.Time
+	for iter.Next(ctx) {
+		created, _ := iter.Message().DateCreated()
+		if count > 0 && created.Time.After(last) {
+			t.Fatalf("expected messages newest first, got %v after %v", created.Time, last)
+		}
+		last = created.Time
+		count++
+	}
+	if iter.Err() != nil {
+		t.Fatal(iter.Err())
+	}
+	// One every 27 minutes.
+	if count != 54 {
+		t.Errorf("expected 54 messages in the last day, got %d", count)
+	}
+	if f.Messages[0].Sid != demoSid("SM", 5) {
+		t.Error("expected NewFixtureClient not to sort the fixtures")
+	}
+
+	if _, err := vc.GetCall(ctx, u, demoSid("CA", 4)); err != nil {
+		t.Fatal(err)
+	}
+	children, err := vc.GetChildCalls(ctx, u, demoSid("CA", 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := children.Calls(); len(calls) != 1 {
+		t.Errorf("expected 1 child call, got %d", len(calls))
+	}
+	if !vc.IsTwilioNumber(twilio.PhoneNumber("+14155550100")) {
+		t.Error("expected the demo numbers to be Twilio numbers")
+	}
+}
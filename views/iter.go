@@ -0,0 +1,383 @@
+package views
+
+import (
+	"net/url"
+	"time"
+
+	types "github.com/kevinburke/go-types"
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+// DefaultPageInterval is the least time an iterator leaves between fetching
+// pages from Twilio, so a long walk through a list doesn't use up the
+// account's rate limit. Pages served from the cache aren't delayed.
+const DefaultPageInterval = 100 * time.Millisecond
+
+// A Pager follows next_page_uri for an iterator, waiting Interval between
+// pages that came from Twilio. Iterators embed a Pager; set its fields before
+// the first call to Next.
+type Pager struct {
+	// The least time between pages fetched from Twilio. Zero means don't
+	// wait.
+	Interval time.Duration
+	// Stop after this many pages. Zero means read the whole list.
+	MaxPages int
+
+	fetch func(ctx context.Context, w Window, next string) (types.NullString, uint64, error)
+	// The lists to walk, one after another. Iterators over a range have one.
+	windows []Window
+	window  int
+	// Set if the iterator walks windows, and drops the resources Twilio
+	// returns that aren't in the current window, or were already returned
+	// from it.
+	windowed  bool
+	seen      map[string]bool
+	next      string
+	pages     int
+	started   bool
+	done      bool
+	truncated bool
+	err       error
+	// When the last page was fetched from Twilio, or zero if it came from
+	// the cache.
+	fetchedAt time.Time
+}
+
+// nextPage fetches the next page, moving on to the next window at the end of
+// each window's list, and returns false if there isn't one or it couldn't be
+// fetched.
+func (p *Pager) nextPage(ctx context.Context) bool {
+	for {
+		if p.done {
+			return false
+		}
+		if p.started && p.next == "" {
+			if p.window+1 >= len(p.windows) {
+				p.done = true
+				return false
+			}
+			p.window++
+			p.seen = nil
+		}
+		if p.MaxPages > 0 && p.pages >= p.MaxPages {
+			p.truncated = true
+			p.done = true
+			return false
+		}
+		if !p.fetchedAt.IsZero() && p.Interval > 0 {
+			if wait := p.Interval - time.Since(p.fetchedAt); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					p.err = ctx.Err()
+					p.done = true
+					return false
+				}
+			}
+		}
+		next, cachedAt, err := p.fetch(ctx, p.windows[p.window], p.next)
+		p.started = true
+		if err == twilio.NoMoreResults {
+			p.next = ""
+			continue
+		}
+		if err != nil {
+			p.err = err
+			p.done = true
+			return false
+		}
+		p.pages++
+		if cachedAt == 0 {
+			p.fetchedAt = time.Now()
+		} else {
+			p.fetchedAt = time.Time{}
+		}
+		if next.Valid {
+			p.next = next.String
+		} else {
+			p.next = ""
+		}
+		return true
+	}
+}
+
+// keep reports whether a windowed iterator should return the resource with
+// the given sid, created at t: only if it's in the current window, and hasn't
+// been returned from it already.
+func (p *Pager) keep(sid string, t twilio.TwilioTime) bool {
+	if !p.windows[p.window].Contains(t) {
+		return false
+	}
+	if sid == "" {
+		return true
+	}
+	if p.seen[sid] {
+		return false
+	}
+	if p.seen == nil {
+		p.seen = make(map[string]bool)
+	}
+	p.seen[sid] = true
+	return true
+}
+
+// setWindows makes the iterator walk windows of the range from start to end,
+// one after another.
+func (p *Pager) setWindows(start, end time.Time, width time.Duration, max int) {
+	p.windows = Windows(start, end, width, max)
+	p.windowed = true
+	p.done = len(p.windows) == 0
+}
+
+// Err returns the error that stopped the iterator, if any. Running out of
+// resources isn't an error.
+func (p *Pager) Err() error {
+	return p.err
+}
+
+// Truncated reports whether the iterator stopped at MaxPages before the end
+// of the list.
+func (p *Pager) Truncated() bool {
+	return p.truncated
+}
+
+// Pages returns the number of pages read so far.
+func (p *Pager) Pages() int {
+	return p.pages
+}
+
+// A MessageIterator walks every message in a range, a page at a time.
+//
+//     iter := views.MessagesInRange(vc, u, start, end, data)
+//     for iter.Next(ctx) {
+//         fmt.Println(iter.Message().Sid())
+//     }
+//     if err := iter.Err(); err != nil {
+//         return err
+//     }
+type MessageIterator struct {
+	Pager
+	page *MessagePage
+	i    int
+}
+
+// MessagesInRange returns an iterator over the messages created between start
+// and end that match data, as u is allowed to see them.
+func MessagesInRange(vc Client, u *config.User, start, end time.Time, data url.Values) *MessageIterator {
+	it := &MessageIterator{Pager: Pager{Interval: DefaultPageInterval, windows: []Window{{Start: start, End: end}}}}
+	it.fetch = func(ctx context.Context, w Window, next string) (types.NullString, uint64, error) {
+		var page *MessagePage
+		var cachedAt uint64
+		var err error
+		if next == "" {
+			page, cachedAt, err = vc.GetMessagePageInRange(ctx, u, w.Start, w.End, data)
+		} else {
+			page, cachedAt, err = vc.GetNextMessagePageInRange(ctx, u, w.Start, w.End, next)
+		}
+		if err != nil {
+			return types.NullString{}, 0, err
+		}
+		it.page, it.i = page, -1
+		return page.NextPageURI(), cachedAt, nil
+	}
+	return it
+}
+
+// MessagesInWindows is like MessagesInRange, but walks the range a window at
+// a time, as described in Windows, so walking the same range again returns
+// the same messages.
+func MessagesInWindows(vc Client, u *config.User, start, end time.Time, width time.Duration, max int, data url.Values) *MessageIterator {
+	it := MessagesInRange(vc, u, start, end, data)
+	it.setWindows(start, end, width, max)
+	return it
+}
+
+// Next moves to the next message, fetching another page if it needs to. It
+// returns false at the end of the list, or if there was an error.
+func (it *MessageIterator) Next(ctx context.Context) bool {
+	for {
+		if it.page != nil && it.i+1 < len(it.page.Messages()) {
+			it.i++
+			if it.windowed {
+				sid, _ := it.Message().Sid()
+				created, _ := it.Message().DateCreated()
+				if !it.keep(sid, created) {
+					continue
+				}
+			}
+			return true
+		}
+		if !it.nextPage(ctx) {
+			return false
+		}
+	}
+}
+
+// Message returns the current message.
+func (it *MessageIterator) Message() *Message {
+	return it.page.Messages()[it.i]
+}
+
+// A CallIterator walks every call in a range, a page at a time.
+type CallIterator struct {
+	Pager
+	page *CallPage
+	i    int
+}
+
+// CallsInRange returns an iterator over the calls created between start and
+// end that match data, as u is allowed to see them.
+func CallsInRange(vc Client, u *config.User, start, end time.Time, data url.Values) *CallIterator {
+	it := &CallIterator{Pager: Pager{Interval: DefaultPageInterval, windows: []Window{{Start: start, End: end}}}}
+	it.fetch = func(ctx context.Context, w Window, next string) (types.NullString, uint64, error) {
+		var page *CallPage
+		var cachedAt uint64
+		var err error
+		if next == "" {
+			page, cachedAt, err = vc.GetCallPageInRange(ctx, u, w.Start, w.End, data)
+		} else {
+			page, cachedAt, err = vc.GetNextCallPageInRange(ctx, u, w.Start, w.End, next)
+		}
+		if err != nil {
+			return types.NullString{}, 0, err
+		}
+		it.page, it.i = page, -1
+		return page.NextPageURI(), cachedAt, nil
+	}
+	return it
+}
+
+// Next moves to the next call, fetching another page if it needs to. It
+// returns false at the end of the list, or if there was an error.
+func (it *CallIterator) Next(ctx context.Context) bool {
+	for {
+		if it.page != nil && it.i+1 < len(it.page.Calls()) {
+			it.i++
+			return true
+		}
+		if !it.nextPage(ctx) {
+			return false
+		}
+	}
+}
+
+// Call returns the current call.
+func (it *CallIterator) Call() *Call {
+	return it.page.Calls()[it.i]
+}
+
+// A ConferenceIterator walks every conference in a range, a page at a time.
+type ConferenceIterator struct {
+	Pager
+	page *ConferencePage
+	i    int
+}
+
+// ConferencesInRange returns an iterator over the conferences created between
+// start and end that match data, as u is allowed to see them.
+func ConferencesInRange(vc Client, u *config.User, start, end time.Time, data url.Values) *ConferenceIterator {
+	it := &ConferenceIterator{Pager: Pager{Interval: DefaultPageInterval, windows: []Window{{Start: start, End: end}}}}
+	it.fetch = func(ctx context.Context, w Window, next string) (types.NullString, uint64, error) {
+		var page *ConferencePage
+		var cachedAt uint64
+		var err error
+		if next == "" {
+			page, cachedAt, err = vc.GetConferencePageInRange(ctx, u, w.Start, w.End, data)
+		} else {
+			page, cachedAt, err = vc.GetNextConferencePageInRange(ctx, u, w.Start, w.End, next)
+		}
+		if err != nil {
+			return types.NullString{}, 0, err
+		}
+		it.page, it.i = page, -1
+		return page.NextPageURI(), cachedAt, nil
+	}
+	return it
+}
+
+// Next moves to the next conference, fetching another page if it needs to.
+// It returns false at the end of the list, or if there was an error.
+func (it *ConferenceIterator) Next(ctx context.Context) bool {
+	for {
+		if it.page != nil && it.i+1 < len(it.page.Conferences()) {
+			it.i++
+			return true
+		}
+		if !it.nextPage(ctx) {
+			return false
+		}
+	}
+}
+
+// Conference returns the current conference.
+func (it *ConferenceIterator) Conference() *Conference {
+	return it.page.Conferences()[it.i]
+}
+
+// An AlertIterator walks every alert in a range, a page at a time.
+type AlertIterator struct {
+	Pager
+	page *AlertPage
+	i    int
+}
+
+// AlertsInRange returns an iterator over the alerts created between start and
+// end that match data, as u is allowed to see them.
+func AlertsInRange(vc Client, u *config.User, start, end time.Time, data url.Values) *AlertIterator {
+	it := &AlertIterator{Pager: Pager{Interval: DefaultPageInterval, windows: []Window{{Start: start, End: end}}}}
+	it.fetch = func(ctx context.Context, w Window, next string) (types.NullString, uint64, error) {
+		var page *AlertPage
+		var cachedAt uint64
+		var err error
+		if next == "" {
+			page, cachedAt, err = vc.GetAlertPageInRange(ctx, u, w.Start, w.End, data)
+		} else {
+			page, cachedAt, err = vc.GetNextAlertPageInRange(ctx, u, w.Start, w.End, next)
+		}
+		if err != nil {
+			return types.NullString{}, 0, err
+		}
+		it.page, it.i = page, -1
+		return page.NextPageURI(), cachedAt, nil
+	}
+	return it
+}
+
+// AlertsInWindows is like AlertsInRange, but walks the range a window at a
+// time, as described in Windows, so walking the same range again returns the
+// same alerts.
+func AlertsInWindows(vc Client, u *config.User, start, end time.Time, width time.Duration, max int, data url.Values) *AlertIterator {
+	it := AlertsInRange(vc, u, start, end, data)
+	it.setWindows(start, end, width, max)
+	return it
+}
+
+// Next moves to the next alert, fetching another page if it needs to. It
+// returns false at the end of the list, or if there was an error.
+func (it *AlertIterator) Next(ctx context.Context) bool {
+	for {
+		if it.page != nil && it.i+1 < len(it.page.Alerts()) {
+			it.i++
+			if it.windowed {
+				sid, _ := it.Alert().Sid()
+				created, _ := it.Alert().DateCreated()
+				if !it.keep(sid, created) {
+					continue
+				}
+			}
+			return true
+		}
+		if !it.nextPage(ctx) {
+			return false
+		}
+	}
+}
+
+// Alert returns the current alert.
+func (it *AlertIterator) Alert() *Alert {
+	return it.page.Alerts()[it.i]
+}
@@ -0,0 +1,221 @@
+package views
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	types "github.com/kevinburke/go-types"
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+// pageClient serves pages of empty messages from a list, with next page URIs
+// "1", "2" and so on.
+type pageClient struct {
+	Client
+	sizes []int
+	err   error
+	calls int
+}
+
+func (c *pageClient) page(i int) (*MessagePage, uint64, error) {
+	c.calls++
+	if i >= len(c.sizes) {
+		if c.err != nil {
+			return nil, 0, c.err
+		}
+		return nil, 0, twilio.NoMoreResults
+	}
+	page := &MessagePage{messages: make([]*Message, c.sizes[i])}
+	if i+1 < len(c.sizes) || c.err != nil {
+		page.nextPageURI = types.NullString{Valid: true, String: strconv.Itoa(i + 1)}
+	}
+	return page, 0, nil
+}
+
+func (c *pageClient) GetMessagePageInRange(ctx context.Context, u *config.User, start, end time.Time, data url.Values) (*MessagePage, uint64, error) {
+	return c.page(0)
+}
+
+func (c *pageClient) GetNextMessagePageInRange(ctx context.Context, u *config.User, start, end time.Time, next string) (*MessagePage, uint64, error) {
+	i, err := strconv.Atoi(next)
+	if err != nil {
+		return nil, 0, err
+	}
+	return c.page(i)
+}
+
+func countMessages(iter *MessageIterator) int {
+	n := 0
+	for iter.Next(context.Background()) {
+		n++
+	}
+	return n
+}
+
+func TestMessagesInRange(t *testing.T) {
+	t.Parallel()
+	c := &pageClient{sizes: []int{2, 0, 3}}
+	iter := MessagesInRange(c, nil, time.Time{}, time.Time{}, url.Values{})
+	iter.Interval = 0
+	if n := countMessages(iter); n != 5 {
+		t.Errorf("expected 5 messages, got %d", n)
+	}
+	if iter.Err() != nil || iter.Truncated() || iter.Pages() != 3 || c.calls != 3 {
+		t.Errorf("bad iterator state: err %v, truncated %t, %d pages, %d calls", iter.Err(), iter.Truncated(), iter.Pages(), c.calls)
+	}
+}
+
+func TestMessagesInRangeMaxPages(t *testing.T) {
+	t.Parallel()
+	c := &pageClient{sizes: []int{2, 2, 2}}
+	iter := MessagesInRange(c, nil, time.Time{}, time.Time{}, url.Values{})
+	iter.Interval = 0
+	iter.MaxPages = 2
+	if n := countMessages(iter); n != 4 {
+		t.Errorf("expected 4 messages, got %d", n)
+	}
+	if !iter.Truncated() || iter.Err() != nil {
+		t.Errorf("expected iterator to be truncated, got %t, %v", iter.Truncated(), iter.Err())
+	}
+
+	// Reading to exactly the end of the list isn't truncated.
+	c = &pageClient{sizes: []int{2, 2}}
+	iter = MessagesInRange(c, nil, time.Time{}, time.Time{}, url.Values{})
+	iter.Interval = 0
+	iter.MaxPages = 2
+	countMessages(iter)
+	if iter.Truncated() {
+		t.Error("expected a list with MaxPages pages not to be truncated")
+	}
+}
+
+func TestMessagesInRangeError(t *testing.T) {
+	t.Parallel()
+	c := &pageClient{sizes: []int{1}, err: errors.New("boom")}
+	iter := MessagesInRange(c, nil, time.Time{}, time.Time{}, url.Values{})
+	iter.Interval = 0
+	if n := countMessages(iter); n != 1 {
+		t.Errorf("expected 1 message, got %d", n)
+	}
+	if iter.Err() == nil || iter.Err().Error() != "boom" {
+		t.Errorf("expected an error, got %v", iter.Err())
+	}
+	if iter.Next(context.Background()) {
+		t.Error("expected Next to keep returning false after an error")
+	}
+}
+
+func TestMessagesInRangeWaits(t *testing.T) {
+	t.Parallel()
+	c := &pageClient{sizes: []int{1, 1, 1}}
+	iter := MessagesInRange(c, nil, time.Time{}, time.Time{}, url.Values{})
+	iter.Interval = 20 * time.Millisecond
+	start := time.Now()
+	countMessages(iter)
+	if since := time.Since(start); since < 40*time.Millisecond {
+		t.Errorf("expected to wait between pages, took %v", since)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	iter = MessagesInRange(&pageClient{sizes: []int{1, 1}}, nil, time.Time{}, time.Time{}, url.Values{})
+	for iter.Next(ctx) {
+	}
+	if iter.Err() != context.Canceled {
+		t.Errorf("expected a canceled context to stop the iterator, got %v", iter.Err())
+	}
+}
+
+// windowClient serves pages of two messages, from every message created
+// between start and end, including end. Each page after the first repeats the
+// last message of the page before it, the way a list shifts when a new
+// message arrives while it's being walked.
+type windowClient struct {
+	Client
+	messages []*Message
+}
+
+func (c *windowClient) page(start, end time.Time, i int) (*MessagePage, uint64, error) {
+	var matched []*Message
+	for _, msg := range c.messages {
+		created, _ := msg.DateCreated()
+		if !created.Time.Before(start) && !created.Time.After(end) {
+			matched = append(matched, msg)
+		}
+	}
+	first := 2 * i
+	if i > 0 {
+		first--
+	}
+	if first >= len(matched) {
+		return nil, 0, twilio.NoMoreResults
+	}
+	last := first + 2
+	page := &MessagePage{}
+	if last < len(matched) {
+		page.nextPageURI = types.NullString{Valid: true, String: strconv.Itoa(i + 1)}
+	} else {
+		last = len(matched)
+	}
+	page.messages = matched[first:last]
+	return page, 0, nil
+}
+
+func (c *windowClient) GetMessagePageInRange(ctx context.Context, u *config.User, start, end time.Time, data url.Values) (*MessagePage, uint64, error) {
+	return c.page(start, end, 0)
+}
+
+func (c *windowClient) GetNextMessagePageInRange(ctx context.Context, u *config.User, start, end time.Time, next string) (*MessagePage, uint64, error) {
+	i, err := strconv.Atoi(next)
+	if err != nil {
+		return nil, 0, err
+	}
+	return c.page(start, end, i)
+}
+
+func TestMessagesInWindows(t *testing.T) {
+	t.Parallel()
+	end := time.Now().UTC().Truncate(time.Hour)
+	start := end.Add(-3 * time.Hour)
+	u := config.NewUser(config.AllUserSettings())
+	c := &windowClient{}
+	// Newest first, with messages on every boundary.
+	offsets := []time.Duration{
+		0, -time.Minute, -time.Hour, -time.Hour - time.Minute, -time.Hour - 2*time.Minute,
+		-2 * time.Hour, -3*time.Hour + time.Minute, -3 * time.Hour, -3*time.Hour - time.Minute,
+	}
+	for i, offset := range offsets {
+		tmsg := &twilio.Message{Sid: "SM" + strconv.Itoa(i), DateCreated: twilio.TwilioTime{Valid: true, Time: end.Add(offset)}}
+		msg, err := NewMessage(tmsg, config.NewPermission(24*time.Hour), u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.messages = append(c.messages, msg)
+	}
+	iter := MessagesInWindows(c, u, start, end, time.Hour, 10, url.Values{})
+	iter.Interval = 0
+	var sids []string
+	for iter.Next(context.Background()) {
+		sid, _ := iter.Message().Sid()
+		sids = append(sids, sid)
+	}
+	if iter.Err() != nil {
+		t.Fatal(iter.Err())
+	}
+	// SM0 and SM8 are outside the range, and each of the rest is returned
+	// once, newest first.
+	expected := []string{"SM1", "SM2", "SM3", "SM4", "SM5", "SM6", "SM7"}
+	if len(sids) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, sids)
+	}
+	for i := range expected {
+		if sids[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, sids)
+			break
+		}
+	}
+}
@@ -2,15 +2,28 @@ package views
 
 import (
 	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 
 	types "github.com/kevinburke/go-types"
 	twilio "github.com/saintpete/twilio-go"
 	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
 )
 
 type Message struct {
-	user    *config.User
+	user *config.User
+	// The user's permissions when the message was created.
+	perms   *config.PermissionSnapshot
 	message *twilio.Message
+	// Empty for messages sent through Twilio.
+	provider string
+	// The name of the Twilio account the message belongs to, if Logrole
+	// lists more than one.
+	account string
 }
 
 type MessagePage struct {
@@ -31,6 +44,22 @@ func (mp *MessagePage) PreviousPageURI() types.NullString {
 	return mp.previousPageURI
 }
 
+// Filter returns a copy of the page containing only the messages for which fn
+// returns true. The next and previous page URIs are unchanged.
+func (mp *MessagePage) Filter(fn func(*Message) bool) *MessagePage {
+	messages := make([]*Message, 0, len(mp.messages))
+	for _, message := range mp.messages {
+		if fn(message) {
+			messages = append(messages, message)
+		}
+	}
+	return &MessagePage{
+		messages:        messages,
+		nextPageURI:     mp.nextPageURI,
+		previousPageURI: mp.previousPageURI,
+	}
+}
+
 const showAllColumnsOnEmptyPage = true
 
 // ShowHeader returns true if we should show the table header in the message
@@ -56,9 +85,10 @@ func NewMessagePage(mp *twilio.MessagePage, p *config.Permission, u *config.User
 	if u.CanViewMessages() == false {
 		return nil, config.PermissionDenied
 	}
-	messages := make([]*Message, 0)
+	perms := u.Snapshot(p.MaxResourceAge(), time.Now())
+	messages := make([]*Message, 0, len(mp.Messages))
 	for _, message := range mp.Messages {
-		msg, err := NewMessage(message, p, u)
+		msg, err := newMessage(message, p, perms, u)
 		if err == config.ErrTooOld || err == config.PermissionDenied {
 			continue
 		}
@@ -82,31 +112,42 @@ func NewMessagePage(mp *twilio.MessagePage, p *config.Permission, u *config.User
 // CanViewProperty panics if the property does not exist. The input is
 // case-sensitive; "MessagingServiceSid" is the correct casing.
 func (m *Message) CanViewProperty(property string) bool {
+	return m.perms.Has(messagePermission(property))
+}
+
+// HiddenReason explains why property is hidden, if the user is debugging
+// permissions.
+func (m *Message) HiddenReason(property string) string {
 	if m.user == nil {
-		return false
+		return ""
 	}
+	return m.user.HiddenReason(messagePermission(property))
+}
+
+// messagePermission returns the permission needed to view property.
+func messagePermission(property string) string {
 	switch property {
 	case "Sid", "DateCreated", "DateUpdated", "MessagingServiceSid",
 		"Status", "Direction", "ErrorCode",
-		"ErrorMessage":
-		return m.user.CanViewMessages()
+		"ErrorMessage", "Channel":
+		return "can_view_messages"
 	case "Price", "PriceUnit":
-		return m.user.CanViewMessagePrice()
+		return "can_view_message_price"
 	case "NumMedia":
-		return m.user.CanViewNumMedia()
+		return "can_view_num_media"
 	case "From":
-		return m.user.CanViewMessageFrom()
+		return "can_view_message_from"
 	case "To":
-		return m.user.CanViewMessageTo()
+		return "can_view_message_to"
 	case "Body", "NumSegments":
-		return m.user.CanViewMessageBody()
+		return "can_view_message_body"
 	default:
 		panic("unknown property " + property)
 	}
 }
 
 func (m *Message) NumMedia() (twilio.NumMedia, error) {
-	if m.user.CanViewNumMedia() {
+	if m.perms.Has("can_view_num_media") {
 		return m.message.NumMedia, nil
 	} else {
 		return 0, config.PermissionDenied
@@ -145,6 +186,31 @@ func (m *Message) To() (twilio.PhoneNumber, error) {
 	}
 }
 
+// InCountry returns true if the From or To number of the message belongs to
+// the region with the given code, like "US". Numbers the user doesn't have
+// permission to view never match.
+func (m *Message) InCountry(code string) bool {
+	if from, err := m.From(); err == nil && services.CountryCode(string(from)) == code {
+		return true
+	}
+	if to, err := m.To(); err == nil && services.CountryCode(string(to)) == code {
+		return true
+	}
+	return false
+}
+
+// OwnedBy returns true if team owns the From or To number of the message.
+// Numbers the user doesn't have permission to view never match.
+func (m *Message) OwnedBy(owners *services.OwnerStore, team string) bool {
+	if from, err := m.From(); err == nil && owners.OwnedBy(from, team) {
+		return true
+	}
+	if to, err := m.To(); err == nil && owners.OwnedBy(to, team) {
+		return true
+	}
+	return false
+}
+
 func (m *Message) MessagingServiceSid() (types.NullString, error) {
 	if m.CanViewProperty("MessagingServiceSid") {
 		return m.message.MessagingServiceSid, nil
@@ -161,6 +227,45 @@ func (m *Message) Status() (twilio.Status, error) {
 	}
 }
 
+// Channel returns services.ChannelWhatsApp if the message was sent or
+// received over WhatsApp, and services.ChannelSMS otherwise. It's based on
+// the From and To addresses, but only reveals the channel, so it needs the
+// same permission as the message's status.
+func (m *Message) Channel() (string, error) {
+	if !m.CanViewProperty("Channel") {
+		return "", config.PermissionDenied
+	}
+	if channel, _ := services.SplitChannel(string(m.message.From)); channel != services.ChannelSMS {
+		return channel, nil
+	}
+	channel, _ := services.SplitChannel(string(m.message.To))
+	return channel, nil
+}
+
+// IsWhatsApp returns true if the message was sent or received over WhatsApp.
+func (m *Message) IsWhatsApp() bool {
+	channel, err := m.Channel()
+	return err == nil && channel == services.ChannelWhatsApp
+}
+
+// WasRead returns true if the recipient opened the message. Only WhatsApp
+// reports this.
+func (m *Message) WasRead() bool {
+	status, err := m.Status()
+	return err == nil && status == StatusRead
+}
+
+// ErrorHelp explains a WhatsApp error code in terms of what to do about it,
+// or returns the empty string if there's nothing to add to the error
+// message.
+func (m *Message) ErrorHelp() string {
+	code, err := m.ErrorCode()
+	if err != nil || !m.IsWhatsApp() {
+		return ""
+	}
+	return whatsAppErrorHelp[code]
+}
+
 func (m *Message) Direction() (twilio.Direction, error) {
 	if m.CanViewProperty("Direction") {
 		return m.message.Direction, nil
@@ -201,6 +306,78 @@ func (m *Message) Body() (string, error) {
 	}
 }
 
+// SafeBody returns the body with invisible and direction-changing characters
+// replaced by placeholders, so they can't disguise what the message says.
+func (m *Message) SafeBody() (string, error) {
+	if m.CanViewProperty("Body") {
+		body, _ := SanitizeBody(m.message.Body)
+		return body, nil
+	} else {
+		return "", config.PermissionDenied
+	}
+}
+
+// HiddenCharacters returns the number of characters SafeBody replaced.
+func (m *Message) HiddenCharacters() (int, error) {
+	if m.CanViewProperty("Body") {
+		_, count := SanitizeBody(m.message.Body)
+		return count, nil
+	} else {
+		return 0, config.PermissionDenied
+	}
+}
+
+// Encoding returns EncodingGSM7 or EncodingUCS2, depending on the characters
+// in the body.
+func (m *Message) Encoding() (string, error) {
+	if m.CanViewProperty("Body") {
+		return BodyEncoding(m.message.Body), nil
+	} else {
+		return "", config.PermissionDenied
+	}
+}
+
+// Characters returns the number of characters a reader sees in the body.
+func (m *Message) Characters() (int, error) {
+	if m.CanViewProperty("Body") {
+		return BodyCharacters(m.message.Body), nil
+	} else {
+		return 0, config.PermissionDenied
+	}
+}
+
+// ExpectedSegments returns the number of segments the body should have been
+// sent in, which can differ from NumSegments if Twilio changed the encoding,
+// for example with smart encoding.
+func (m *Message) ExpectedSegments() (int, error) {
+	if m.CanViewProperty("Body") {
+		return BodySegments(m.message.Body), nil
+	} else {
+		return 0, config.PermissionDenied
+	}
+}
+
+// Inspect returns how the body is encoded and split into segments. If the
+// user can view the price and number of segments, it says what sending the
+// body as UCS-2 cost.
+func (m *Message) Inspect() (*BodyInspection, error) {
+	if !m.CanViewProperty("Body") {
+		return nil, config.PermissionDenied
+	}
+	bi := InspectBody(m.message.Body)
+	extra := len(bi.Segments) - bi.GSM7Segments
+	if extra <= 0 || !m.CanViewProperty("Price") || !m.CanViewProperty("PriceUnit") || !m.CanViewProperty("NumSegments") || m.message.NumSegments <= 0 {
+		return bi, nil
+	}
+	price, err := strconv.ParseFloat(m.message.Price, 64)
+	if err != nil || price == 0 {
+		return bi, nil
+	}
+	perSegment := math.Abs(price) / float64(m.message.NumSegments)
+	bi.ExtraCost = fmt.Sprintf("%.4f %s", perSegment*float64(extra), m.message.PriceUnit)
+	return bi, nil
+}
+
 func (m *Message) NumSegments() (twilio.Segments, error) {
 	if m.CanViewProperty("NumSegments") {
 		return m.message.NumSegments, nil
@@ -227,7 +404,63 @@ func (m *Message) ErrorMessage() (string, error) {
 
 func (m *Message) CanViewMedia() bool {
 	// Hack - a separate function since this is not a property on the object.
-	return m.user != nil && m.user.CanViewMedia()
+	return m.perms.Has("can_view_media")
+}
+
+// Resendable returns true if the message was sent from this account through
+// Twilio and never arrived, so it makes sense to send it again.
+func (m *Message) Resendable() bool {
+	if m.Provider() != config.ProviderTwilio {
+		return false
+	}
+	if m.message.Status != twilio.StatusFailed && m.message.Status != twilio.StatusUndelivered {
+		return false
+	}
+	return strings.HasPrefix(string(m.message.Direction), "outbound")
+}
+
+// Scheduled returns true if a Messaging Service will send the message later.
+func (m *Message) Scheduled() bool {
+	return m.Provider() == config.ProviderTwilio && m.message.Status == StatusScheduled
+}
+
+// CanCancel returns true if the user can cancel the message, and it hasn't
+// been sent yet.
+func (m *Message) CanCancel() bool {
+	return m.perms.Has("can_cancel_messages") && m.Scheduled()
+}
+
+// CanTranslate returns true if the user can translate the message, and it
+// has a body to translate.
+func (m *Message) CanTranslate() bool {
+	return m.perms.Has("can_translate_messages") && m.message.Body != ""
+}
+
+// Provider returns the name of the provider that sent or received the
+// message, like "twilio".
+func (m *Message) Provider() string {
+	if m.provider == "" {
+		return config.ProviderTwilio
+	}
+	return m.provider
+}
+
+// Account returns the name of the Twilio account the message belongs to, or
+// the empty string if Logrole only lists one account.
+func (m *Message) Account() string {
+	return m.account
+}
+
+// CanResend returns true if the user can resend the message, and the message
+// is resendable.
+func (m *Message) CanResend() bool {
+	return m.perms.Has("can_resend_messages") && m.Resendable()
+}
+
+// A2PError returns true if the user can see the message's error code, and it
+// means the message was blocked because of A2P 10DLC registration.
+func (m *Message) A2PError() bool {
+	return m.CanViewProperty("ErrorCode") && IsA2PErrorCode(m.message.ErrorCode)
 }
 
 // NewMessage creates a new Message, setting fields to be hidden or shown as
@@ -236,11 +469,23 @@ func NewMessage(msg *twilio.Message, p *config.Permission, u *config.User) (*Mes
 	if u.CanViewMessages() == false {
 		return nil, config.PermissionDenied
 	}
+	return newMessage(msg, p, u.Snapshot(p.MaxResourceAge(), time.Now()), u)
+}
+
+// newMessage creates a Message for a user who can view messages, with their
+// permissions in perms.
+func newMessage(msg *twilio.Message, p *config.Permission, perms *config.PermissionSnapshot, u *config.User) (*Message, error) {
 	if msg.DateCreated.Valid == false {
 		return nil, errors.New("Invalid DateCreated for message")
 	}
-	if !u.CanViewResource(msg.DateCreated.Time, p.MaxResourceAge()) {
+	if !perms.CanViewResource(msg.DateCreated.Time) {
 		return nil, config.ErrTooOld
 	}
-	return &Message{user: u, message: msg}, nil
+	if !u.CanViewNumbers(string(msg.From), string(msg.To)) {
+		return nil, config.PermissionDenied
+	}
+	if p.Blocklist().Hides(u, string(msg.From), string(msg.To)) {
+		return nil, config.PermissionDenied
+	}
+	return &Message{user: u, perms: perms, message: msg}, nil
 }
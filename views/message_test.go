@@ -0,0 +1,271 @@
+package views
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	twilio "github.com/saintpete/twilio-go"
+)
+
+func TestMessagePriceHidden(t *testing.T) {
+	t.Parallel()
+	s := config.AllUserSettings()
+	s.CanViewPrices = false
+	tmsg := &twilio.Message{Sid: "SM123", Price: "-0.0075", PriceUnit: "USD", DateCreated: twilio.TwilioTime{Valid: true, Time: time.Now()}}
+	msg, err := NewMessage(tmsg, config.NewPermission(time.Hour), config.NewUser(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.CanViewProperty("Price") {
+		t.Error("expected Price to be hidden")
+	}
+	if _, err := msg.FriendlyPrice(); err != config.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+	if _, err := msg.PriceUnit(); err != config.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+}
+
+func TestMessageSafeBody(t *testing.T) {
+	t.Parallel()
+	tmsg := &twilio.Message{Sid: "SM123", Body: "pay \u202egnp.exe", DateCreated: twilio.TwilioTime{Valid: true, Time: time.Now()}}
+	msg, err := NewMessage(tmsg, config.NewPermission(time.Hour), config.NewUser(config.AllUserSettings()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := msg.SafeBody(); body != "pay [U+202E]gnp.exe" {
+		t.Errorf("expected override to be replaced, got %q", body)
+	}
+	if count, _ := msg.HiddenCharacters(); count != 1 {
+		t.Errorf("expected 1 hidden character, got %d", count)
+	}
+	if enc, _ := msg.Encoding(); enc != EncodingUCS2 {
+		t.Errorf("expected UCS-2, got %s", enc)
+	}
+	s := config.AllUserSettings()
+	s.CanViewMessageBody = false
+	msg, _ = NewMessage(tmsg, config.NewPermission(time.Hour), config.NewUser(s))
+	if _, err := msg.SafeBody(); err != config.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+}
+
+func TestMessageInspectCost(t *testing.T) {
+	t.Parallel()
+	// 100 GSM characters and a curly quote take 2 UCS-2 segments, but only
+	// 1 GSM-7 segment.
+	body := strings.Repeat("a", 100) + "’"
+	tmsg := &twilio.Message{Sid: "SM123", Body: body, NumSegments: 2, Price: "-0.01500", PriceUnit: "USD", DateCreated: twilio.TwilioTime{Valid: true, Time: time.Now()}}
+	msg, err := NewMessage(tmsg, config.NewPermission(time.Hour), config.NewUser(config.AllUserSettings()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bi, err := msg.Inspect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bi.Segments) != 2 || bi.GSM7Segments != 1 {
+		t.Errorf("expected 2 segments, 1 as GSM-7, got %d and %d", len(bi.Segments), bi.GSM7Segments)
+	}
+	if bi.ExtraCost != "0.0075 USD" {
+		t.Errorf("expected extra cost of 0.0075 USD, got %q", bi.ExtraCost)
+	}
+
+	s := config.AllUserSettings()
+	s.CanViewPrices = false
+	msg, _ = NewMessage(tmsg, config.NewPermission(time.Hour), config.NewUser(s))
+	if bi, _ := msg.Inspect(); bi.ExtraCost != "" {
+		t.Errorf("expected cost to be hidden without price access, got %q", bi.ExtraCost)
+	}
+	s = config.AllUserSettings()
+	s.CanViewMessageBody = false
+	msg, _ = NewMessage(tmsg, config.NewPermission(time.Hour), config.NewUser(s))
+	if _, err := msg.Inspect(); err != config.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+}
+
+func TestMessageResendable(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		status    twilio.Status
+		direction twilio.Direction
+		want      bool
+	}{
+		{twilio.StatusFailed, twilio.DirectionOutboundAPI, true},
+		{twilio.StatusUndelivered, twilio.DirectionOutboundReply, true},
+		{twilio.StatusDelivered, twilio.DirectionOutboundAPI, false},
+		{twilio.StatusFailed, twilio.DirectionInbound, false},
+	}
+	for _, tt := range tests {
+		tmsg := &twilio.Message{Sid: "SM123", Status: tt.status, Direction: tt.direction, DateCreated: twilio.TwilioTime{Valid: true, Time: time.Now()}}
+		msg, err := NewMessage(tmsg, config.NewPermission(time.Hour), config.NewUser(config.AllUserSettings()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := msg.Resendable(); got != tt.want {
+			t.Errorf("Resendable(%s, %s): got %t, want %t", tt.status, tt.direction, got, tt.want)
+		}
+		if got := msg.CanResend(); got != tt.want {
+			t.Errorf("CanResend(%s, %s): got %t, want %t", tt.status, tt.direction, got, tt.want)
+		}
+	}
+	s := config.AllUserSettings()
+	s.CanResendMessages = false
+	tmsg := &twilio.Message{Sid: "SM123", Status: twilio.StatusFailed, Direction: twilio.DirectionOutboundAPI, DateCreated: twilio.TwilioTime{Valid: true, Time: time.Now()}}
+	msg, _ := NewMessage(tmsg, config.NewPermission(time.Hour), config.NewUser(s))
+	if msg.CanResend() {
+		t.Error("expected users without can_resend_messages not to be able to resend")
+	}
+}
+
+func TestMessageChannel(t *testing.T) {
+	t.Parallel()
+	tmsg := &twilio.Message{Sid: "SM123", From: "whatsapp:+14155551234", To: "whatsapp:+14105556789", Status: StatusRead, ErrorCode: 63016, DateCreated: twilio.TwilioTime{Valid: true, Time: time.Now()}}
+	msg, err := NewMessage(tmsg, config.NewPermission(time.Hour), config.NewUser(config.AllUserSettings()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := msg.Channel(); c != services.ChannelWhatsApp {
+		t.Errorf("expected WhatsApp channel, got %q", c)
+	}
+	if !msg.WasRead() {
+		t.Error("expected message to be read")
+	}
+	if msg.ErrorHelp() == "" {
+		t.Error("expected help for WhatsApp error 63016")
+	}
+	tmsg = &twilio.Message{Sid: "SM456", From: "+14155551234", To: "+14105556789", ErrorCode: 63016, DateCreated: twilio.TwilioTime{Valid: true, Time: time.Now()}}
+	msg, _ = NewMessage(tmsg, config.NewPermission(time.Hour), config.NewUser(config.AllUserSettings()))
+	if c, _ := msg.Channel(); c != services.ChannelSMS {
+		t.Errorf("expected SMS channel, got %q", c)
+	}
+	if msg.IsWhatsApp() || msg.ErrorHelp() != "" {
+		t.Error("expected SMS message to have no WhatsApp details")
+	}
+}
+
+func TestMessageExcludedCountry(t *testing.T) {
+	t.Parallel()
+	s := config.AllUserSettings()
+	s.ExcludedCountries = []string{"EU"}
+	u := config.NewUser(s)
+	now := twilio.TwilioTime{Valid: true, Time: time.Now()}
+	eu := &twilio.Message{Sid: "SM123", From: "+14155551234", To: "+493012345678", DateCreated: now}
+	us := &twilio.Message{Sid: "SM456", From: "+14155551234", To: "+14105556789", DateCreated: now}
+	if _, err := NewMessage(eu, config.NewPermission(time.Hour), u); err != config.PermissionDenied {
+		t.Errorf("expected PermissionDenied for a message to an excluded country, got %v", err)
+	}
+	page, err := NewMessagePage(&twilio.MessagePage{Messages: []*twilio.Message{eu, us}}, config.NewPermission(time.Hour), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgs := page.Messages(); len(msgs) != 1 || msgs[0].message.Sid != "SM456" {
+		t.Errorf("expected only the US message on the page, got %d messages", len(msgs))
+	}
+}
+
+func TestMessageBlockedNumber(t *testing.T) {
+	t.Parallel()
+	b, err := config.NewBlocklist(&config.BlocklistConfig{Group: "executives", Numbers: []string{"+14155550123"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := config.NewPermission(time.Hour).WithBlocklist(b)
+	policy := config.Policy{
+		{Name: "support", Permissions: config.AllUserSettings(), Users: []string{"support@example.com"}},
+		{Name: "executives", Permissions: config.AllUserSettings(), Users: []string{"ceo@example.com"}},
+	}
+	support, _, _ := policy.Lookup("support@example.com")
+	exec, _, _ := policy.Lookup("ceo@example.com")
+	now := twilio.TwilioTime{Valid: true, Time: time.Now()}
+	msg := &twilio.Message{Sid: "SM123", From: "+14155550123", To: "+14105556789", DateCreated: now}
+	if _, err := NewMessage(msg, p, support); err != config.PermissionDenied {
+		t.Errorf("expected PermissionDenied for a message from a blocked number, got %v", err)
+	}
+	page, err := NewMessagePage(&twilio.MessagePage{Messages: []*twilio.Message{msg}}, p, support)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages()) != 0 {
+		t.Error("expected the blocked message to be left off the page")
+	}
+	if _, err := NewMessage(msg, p, exec); err != nil {
+		t.Errorf("expected the blocklist's group to see the message, got %v", err)
+	}
+}
+
+func BenchmarkNewMessagePage(b *testing.B) {
+	mp := &twilio.MessagePage{Messages: make([]*twilio.Message, 100)}
+	for i := range mp.Messages {
+		mp.Messages[i] = &twilio.Message{Sid: "SM123", From: "+14105551234", To: "+14155556789", Body: "hello", Price: "-0.0075", PriceUnit: "USD", DateCreated: twilio.TwilioTime{Valid: true, Time: time.Now()}}
+	}
+	p := config.NewPermission(time.Hour)
+	u := config.NewUser(config.AllUserSettings())
+	props := []string{"Sid", "DateCreated", "From", "To", "Body", "Price", "Status", "Direction", "NumMedia"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		page, err := NewMessagePage(mp, p, u)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, msg := range page.Messages() {
+			for _, prop := range props {
+				msg.CanViewProperty(prop)
+			}
+		}
+	}
+}
+
+func TestMessageCanCancel(t *testing.T) {
+	t.Parallel()
+	now := twilio.TwilioTime{Valid: true, Time: time.Now()}
+	scheduled := &twilio.Message{Sid: "SM123", Status: StatusScheduled, Direction: twilio.DirectionOutboundAPI, DateCreated: now}
+	msg, err := NewMessage(scheduled, config.NewPermission(time.Hour), config.NewUser(config.AllUserSettings()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msg.CanCancel() {
+		t.Error("expected to be able to cancel a scheduled message")
+	}
+	sent := &twilio.Message{Sid: "SM123", Status: twilio.StatusSent, Direction: twilio.DirectionOutboundAPI, DateCreated: now}
+	msg, _ = NewMessage(sent, config.NewPermission(time.Hour), config.NewUser(config.AllUserSettings()))
+	if msg.CanCancel() {
+		t.Error("expected not to be able to cancel a sent message")
+	}
+	s := config.AllUserSettings()
+	s.CanCancelMessages = false
+	msg, _ = NewMessage(scheduled, config.NewPermission(time.Hour), config.NewUser(s))
+	if msg.CanCancel() {
+		t.Error("expected users without can_cancel_messages not to be able to cancel")
+	}
+}
+
+func TestMessageCanTranslate(t *testing.T) {
+	t.Parallel()
+	now := twilio.TwilioTime{Valid: true, Time: time.Now()}
+	m := &twilio.Message{Sid: "SM123", Body: "Hola", Direction: twilio.DirectionInbound, DateCreated: now}
+	msg, err := NewMessage(m, config.NewPermission(time.Hour), config.NewUser(config.AllUserSettings()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msg.CanTranslate() {
+		t.Error("expected to be able to translate a message with a body")
+	}
+	empty := &twilio.Message{Sid: "SM123", Direction: twilio.DirectionInbound, DateCreated: now}
+	msg, _ = NewMessage(empty, config.NewPermission(time.Hour), config.NewUser(config.AllUserSettings()))
+	if msg.CanTranslate() {
+		t.Error("expected not to be able to translate a message without a body")
+	}
+	s := config.AllUserSettings()
+	s.CanViewMessageBody = false
+	msg, _ = NewMessage(m, config.NewPermission(time.Hour), config.NewUser(s))
+	if msg.CanTranslate() {
+		t.Error("expected users who can't view the body not to be able to translate it")
+	}
+}
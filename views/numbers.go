@@ -37,6 +37,9 @@ func NewIncomingNumber(pn *twilio.IncomingPhoneNumber, p *config.Permission, u *
 	}
 	// NB: Phone numbers are *exempt* from max resource age rules, they don't
 	// really make sense.
+	if !u.CanViewNumbers(string(pn.PhoneNumber)) || p.Blocklist().Hides(u, string(pn.PhoneNumber)) {
+		return nil, config.PermissionDenied
+	}
 	return &IncomingNumber{user: u, number: pn}, nil
 }
 
@@ -88,15 +91,31 @@ func (n *IncomingNumber) CanViewProperty(property string) bool {
 	if n.number == nil {
 		return false
 	}
+	perm := numberPermission(property)
+	return perm == "" || n.user.HasPermission(perm)
+}
+
+// HiddenReason explains why property is hidden, if the user is debugging
+// permissions.
+func (n *IncomingNumber) HiddenReason(property string) string {
+	if n.number == nil {
+		return ""
+	}
+	return n.user.HiddenReason(numberPermission(property))
+}
+
+// numberPermission returns the permission needed to view property, or the
+// empty string if everyone can view it.
+func numberPermission(property string) string {
 	switch property {
 	case "Sid", "DateCreated", "PhoneNumber", "FriendlyName", "Beta",
 		"TrunkSid", "Capabilities", "EmergencyStatus":
-		return true
+		return ""
 	case "VoiceURL", "SMSURL", "VoiceMethod", "SMSMethod", "StatusCallback",
 		"StatusCallbackMethod", "VoiceFallbackURL", "VoiceFallbackMethod",
 		"SMSFallbackURL", "SMSFallbackMethod", "VoiceApplicationSid",
 		"SMSApplicationSid":
-		return n.user.CanViewCallbackURLs()
+		return "can_view_callback_urls"
 	default:
 		panic("unknown property " + property)
 	}
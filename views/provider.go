@@ -0,0 +1,349 @@
+package views
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	"github.com/saintpete/logrole/services"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+// A Provider retrieves messages, calls and media from an API other than
+// Twilio's, and returns them as Twilio resources, so they can be shown and
+// permission checked the same way. Providers don't check permissions
+// themselves.
+type Provider interface {
+	// Name is the provider's name in the providers setting, like "telnyx".
+	Name() string
+	// Owns returns true if id is the ID of one of the provider's messages or
+	// calls. IDs from different providers must not overlap.
+	Owns(id string) bool
+	GetMessage(ctx context.Context, id string) (*twilio.Message, error)
+	// GetMessagesInRange returns the page of messages described by data,
+	// which has the same filters as a Twilio message list, like "From" and
+	// "PageSize". The next page URI, if there is one, must start with
+	// ProviderPagePrefix(Name()).
+	GetMessagesInRange(ctx context.Context, start, end time.Time, data url.Values) (*twilio.MessagePage, error)
+	GetCall(ctx context.Context, id string) (*twilio.Call, error)
+	GetCallsInRange(ctx context.Context, start, end time.Time, data url.Values) (*twilio.CallPage, error)
+	// GetMediaURLs returns the URLs of the media attached to a message. The
+	// URLs must not need credentials to fetch.
+	GetMediaURLs(ctx context.Context, id string) ([]*url.URL, error)
+}
+
+// ProviderPagePrefix is the start of the next page URIs for the named
+// provider. It starts like a Twilio URI, so next page URIs from any provider
+// can be passed around the same way.
+func ProviderPagePrefix(name string) string {
+	return "/" + twilio.APIVersion + "/Providers/" + name + "/"
+}
+
+// NewProviders returns a Provider for each of the configured providers, in
+// alphabetical order by name. Unknown providers are skipped; the config
+// package rejects them.
+func NewProviders(configs map[string]config.ProviderConfig) []Provider {
+	providers := make([]Provider, 0, len(configs))
+	for _, name := range config.ProviderNames() {
+		pc, ok := configs[name]
+		if !ok {
+			continue
+		}
+		switch name {
+		case config.ProviderTelnyx:
+			providers = append(providers, NewTelnyxProvider(pc.APIKey, pc.BaseURL))
+		}
+	}
+	return providers
+}
+
+// A ProviderLister can say which providers messages and calls come from.
+type ProviderLister interface {
+	// Providers returns the provider names, starting with the primary
+	// provider.
+	Providers() []string
+}
+
+// providerClient sends requests for the messages and calls of other providers
+// to those providers, and everything else to the primary Client.
+type providerClient struct {
+	Client
+	secretKey  *[32]byte
+	permission *config.Permission
+	providers  []Provider
+}
+
+// NewProviderClient returns a Client that serves messages and calls from
+// primary, and from each of the providers. A list only has the messages or
+// calls from one provider: the one named in the "Provider" filter, or primary
+// if there isn't one. Single messages and calls go to the provider that owns
+// their ID. If there are no providers, primary is returned.
+func NewProviderClient(primary Client, secretKey *[32]byte, p *config.Permission, providers ...Provider) Client {
+	if len(providers) == 0 {
+		return primary
+	}
+	return &providerClient{
+		Client:     primary,
+		secretKey:  secretKey,
+		permission: p,
+		providers:  providers,
+	}
+}
+
+func (vc *providerClient) Providers() []string {
+	names := make([]string, 1, len(vc.providers)+1)
+	names[0] = config.ProviderTwilio
+	for _, p := range vc.providers {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+// Accounts returns the Twilio accounts messages, calls and alerts can be
+// listed from, or nil if there's only one.
+func (vc *providerClient) Accounts() []*Account {
+	if al, ok := vc.Client.(AccountLister); ok {
+		return al.Accounts()
+	}
+	return nil
+}
+
+func (vc *providerClient) owner(id string) Provider {
+	for _, p := range vc.providers {
+		if p.Owns(id) {
+			return p
+		}
+	}
+	return nil
+}
+
+func (vc *providerClient) named(name string) Provider {
+	for _, p := range vc.providers {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// pageProvider returns the provider the next page URI came from, or nil if it
+// came from the primary Client.
+func (vc *providerClient) pageProvider(nextPage string) Provider {
+	for _, p := range vc.providers {
+		if strings.HasPrefix(nextPage, ProviderPagePrefix(p.Name())) {
+			return p
+		}
+	}
+	return nil
+}
+
+// listProvider returns the provider named in data, and data without the
+// "Provider" filter. If data names the primary provider, or no provider, the
+// returned Provider is nil.
+func (vc *providerClient) listProvider(data url.Values) (Provider, url.Values, error) {
+	name := data.Get("Provider")
+	if name == "" {
+		return nil, data, nil
+	}
+	filters := url.Values{}
+	for k, v := range data {
+		if k != "Provider" {
+			filters[k] = v
+		}
+	}
+	if name == config.ProviderTwilio {
+		return nil, filters, nil
+	}
+	p := vc.named(name)
+	if p == nil {
+		return nil, nil, &rest.Error{
+			StatusCode: 400,
+			Title:      "Unknown provider " + name,
+		}
+	}
+	return p, filters, nil
+}
+
+func (vc *providerClient) GetMessage(ctx context.Context, user *config.User, id string) (*Message, error) {
+	p := vc.owner(id)
+	if p == nil {
+		return vc.Client.GetMessage(ctx, user, id)
+	}
+	message, err := p.GetMessage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := NewMessage(message, vc.permission, user)
+	if err != nil {
+		return nil, err
+	}
+	msg.provider = p.Name()
+	return msg, nil
+}
+
+func (vc *providerClient) GetCall(ctx context.Context, user *config.User, id string) (*Call, error) {
+	p := vc.owner(id)
+	if p == nil {
+		return vc.Client.GetCall(ctx, user, id)
+	}
+	call, err := p.GetCall(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCall(call, vc.permission, user)
+	if err != nil {
+		return nil, err
+	}
+	c.provider = p.Name()
+	return c, nil
+}
+
+// GetMediaURLs returns proxy URLs for the media attached to the message with
+// the given id, like the Twilio client does.
+func (vc *providerClient) GetMediaURLs(ctx context.Context, u *config.User, id string) ([]*url.URL, error) {
+	p := vc.owner(id)
+	if p == nil {
+		return vc.Client.GetMediaURLs(ctx, u, id)
+	}
+	if u.CanViewMedia() == false {
+		return nil, config.PermissionDenied
+	}
+	urls, err := p.GetMediaURLs(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	opaqueImages := make([]*url.URL, len(urls))
+	for i, mediaURL := range urls {
+		enc := services.StableOpaqueFor(mediaURL.String(), u.ID(), vc.secretKey)
+		opaqueURL, err := url.Parse("/images/" + enc)
+		if err != nil {
+			return nil, err
+		}
+		opaqueImages[i] = opaqueURL
+	}
+	return opaqueImages, nil
+}
+
+func (vc *providerClient) messagePage(ctx context.Context, user *config.User, p Provider, start, end time.Time, data url.Values) (*MessagePage, uint64, error) {
+	page, err := p.GetMessagesInRange(ctx, start, end, data)
+	if err != nil {
+		return nil, 0, err
+	}
+	mp, err := NewMessagePage(page, vc.permission, user)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, msg := range mp.messages {
+		msg.provider = p.Name()
+	}
+	return mp, 0, nil
+}
+
+func (vc *providerClient) GetMessagePageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, data url.Values) (*MessagePage, uint64, error) {
+	p, filters, err := vc.listProvider(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if p == nil {
+		return vc.Client.GetMessagePageInRange(ctx, user, start, end, filters)
+	}
+	return vc.messagePage(ctx, user, p, start, end, filters)
+}
+
+func (vc *providerClient) GetNextMessagePageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, nextPage string) (*MessagePage, uint64, error) {
+	p := vc.pageProvider(nextPage)
+	if p == nil {
+		return vc.Client.GetNextMessagePageInRange(ctx, user, start, end, nextPage)
+	}
+	data, err := nextPageData(nextPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	return vc.messagePage(ctx, user, p, start, end, data)
+}
+
+func (vc *providerClient) callPage(ctx context.Context, user *config.User, p Provider, start, end time.Time, data url.Values) (*CallPage, uint64, error) {
+	page, err := p.GetCallsInRange(ctx, start, end, data)
+	if err != nil {
+		return nil, 0, err
+	}
+	cp, err := NewCallPage(page, vc.permission, user)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, c := range cp.calls {
+		c.provider = p.Name()
+	}
+	return cp, 0, nil
+}
+
+func (vc *providerClient) GetCallPageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, data url.Values) (*CallPage, uint64, error) {
+	p, filters, err := vc.listProvider(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if p == nil {
+		return vc.Client.GetCallPageInRange(ctx, user, start, end, filters)
+	}
+	return vc.callPage(ctx, user, p, start, end, filters)
+}
+
+func (vc *providerClient) GetNextCallPageInRange(ctx context.Context, user *config.User, start time.Time, end time.Time, nextPage string) (*CallPage, uint64, error) {
+	p := vc.pageProvider(nextPage)
+	if p == nil {
+		return vc.Client.GetNextCallPageInRange(ctx, user, start, end, nextPage)
+	}
+	data, err := nextPageData(nextPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	return vc.callPage(ctx, user, p, start, end, data)
+}
+
+// Recordings, alerts, child calls and events only exist in Twilio. Other
+// providers' messages and calls have none of them.
+
+func (vc *providerClient) GetCallRecordings(ctx context.Context, user *config.User, sid string, data url.Values) (*RecordingPage, error) {
+	if vc.owner(sid) == nil {
+		return vc.Client.GetCallRecordings(ctx, user, sid, data)
+	}
+	return NewRecordingPage(new(twilio.RecordingPage), vc.permission, user, vc.secretKey)
+}
+
+func (vc *providerClient) GetCallAlerts(ctx context.Context, user *config.User, sid string) (*AlertPage, error) {
+	if vc.owner(sid) == nil {
+		return vc.Client.GetCallAlerts(ctx, user, sid)
+	}
+	return NewAlertPage(new(twilio.AlertPage), vc.permission, user)
+}
+
+func (vc *providerClient) GetMessageAlerts(ctx context.Context, user *config.User, sid string) (*AlertPage, error) {
+	if vc.owner(sid) == nil {
+		return vc.Client.GetMessageAlerts(ctx, user, sid)
+	}
+	return NewAlertPage(new(twilio.AlertPage), vc.permission, user)
+}
+
+func (vc *providerClient) GetChildCalls(ctx context.Context, user *config.User, sid string) (*CallPage, error) {
+	if vc.owner(sid) == nil {
+		return vc.Client.GetChildCalls(ctx, user, sid)
+	}
+	return NewCallPage(new(twilio.CallPage), vc.permission, user)
+}
+
+func (vc *providerClient) LoadCallEvents(ctx context.Context, call *Call) error {
+	if vc.owner(call.call.Sid) == nil {
+		return vc.Client.LoadCallEvents(ctx, call)
+	}
+	return nil
+}
+
+func (vc *providerClient) GetResourceEvents(ctx context.Context, user *config.User, sid string) ([]*Event, error) {
+	if vc.owner(sid) == nil {
+		return vc.Client.GetResourceEvents(ctx, user, sid)
+	}
+	return []*Event{}, nil
+}
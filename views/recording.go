@@ -25,17 +25,28 @@ func (r *RecordingPage) NextPageURI() types.NullString {
 type Recording struct {
 	user      *config.User
 	recording *twilio.Recording
-	// The recording URL, encrypted with the secret key. This must be set in
-	// NewRecording.
+	// The recording URL, encrypted with the secret key and bound to user.
+	// This must be set in NewRecording.
 	url string
 }
 
 func (r *Recording) CanViewProperty(property string) bool {
+	return r.user.HasPermission(recordingPermission(property))
+}
+
+// HiddenReason explains why property is hidden, if the user is debugging
+// permissions.
+func (r *Recording) HiddenReason(property string) string {
+	return r.user.HiddenReason(recordingPermission(property))
+}
+
+// recordingPermission returns the permission needed to view property.
+func recordingPermission(property string) string {
 	switch property {
-	case "Sid", "DateCreated", "DateUpdated", "Duration":
-		return r.user.CanPlayRecordings()
+	case "Sid", "CallSid", "DateCreated", "DateUpdated", "Duration":
+		return "can_play_recordings"
 	case "Price", "PriceUnit":
-		return r.user.CanViewRecordingPrice()
+		return "can_view_recording_price"
 	default:
 		panic("Unknown property " + property)
 	}
@@ -49,6 +60,14 @@ func (r *Recording) Sid() (string, error) {
 	}
 }
 
+func (r *Recording) CallSid() (string, error) {
+	if r.CanViewProperty("CallSid") {
+		return r.recording.CallSid, nil
+	} else {
+		return "", config.PermissionDenied
+	}
+}
+
 func (r *Recording) Duration() (twilio.TwilioDuration, error) {
 	if r.CanViewProperty("Duration") {
 		return r.recording.Duration, nil
@@ -116,7 +135,7 @@ func NewRecording(r *twilio.Recording, p *config.Permission, u *config.User, key
 	if !u.CanViewResource(r.DateCreated.Time, p.MaxResourceAge()) {
 		return nil, config.ErrTooOld
 	}
-	url := services.Opaque(r.URL(".wav"), key)
+	url := services.StableOpaqueFor(r.URL(".wav"), u.ID(), key)
 	return &Recording{
 		user:      u,
 		recording: r,
@@ -0,0 +1,137 @@
+package views
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	types "github.com/kevinburke/go-types"
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+// Statuses of messages a Messaging Service will send later, and of scheduled
+// messages that were canceled before they were sent.
+const (
+	StatusScheduled = twilio.Status("scheduled")
+	StatusCanceled  = twilio.Status("canceled")
+)
+
+// The Messages API can't filter by status, so scheduled messages are found by
+// reading the newest pages of messages. Stop after this many pages.
+const maxScheduledPages = 5
+const scheduledPageSize = 1000
+
+// ErrNotScheduled is returned when asked to cancel a message that was already
+// sent or canceled.
+var ErrNotScheduled = errors.New("Only scheduled messages that haven't been sent yet can be canceled")
+
+// A Scheduler can find and cancel messages that a Messaging Service will send
+// later. Scheduling is a Twilio feature; the archive client doesn't implement
+// it.
+type Scheduler interface {
+	GetScheduledMessages(ctx context.Context, u *config.User) ([]*ScheduledMessage, error)
+	CancelScheduledMessage(ctx context.Context, u *config.User, sid string) (*Message, error)
+}
+
+// A ScheduledMessage is a message that hasn't been sent yet.
+type ScheduledMessage struct {
+	*Message
+	// When Twilio will send the message. Zero if the API didn't say.
+	SendAt time.Time
+}
+
+// scheduledMessageResource is a Message with the send_at field Twilio returns
+// for scheduled messages, which twilio-go doesn't know about.
+type scheduledMessageResource struct {
+	twilio.Message
+	SendAt twilio.TwilioTime `json:"send_at"`
+}
+
+type scheduledMessagePage struct {
+	Messages    []*scheduledMessageResource `json:"messages"`
+	NextPageURI types.NullString            `json:"next_page_uri"`
+}
+
+type scheduledMessagesBySendAt []*ScheduledMessage
+
+func (s scheduledMessagesBySendAt) Len() int           { return len(s) }
+func (s scheduledMessagesBySendAt) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s scheduledMessagesBySendAt) Less(i, j int) bool { return s[i].SendAt.Before(s[j].SendAt) }
+
+// GetScheduledMessages returns the scheduled messages in the newest
+// maxScheduledPages pages of messages that u can see, the next one to be sent
+// first. Results aren't cached, so a message disappears as soon as it's sent
+// or canceled.
+func (vc *client) GetScheduledMessages(ctx context.Context, u *config.User) ([]*ScheduledMessage, error) {
+	if !u.CanViewMessages() {
+		return nil, config.PermissionDenied
+	}
+	data := url.Values{}
+	data.Set("PageSize", strconv.Itoa(scheduledPageSize))
+	page := new(scheduledMessagePage)
+	if err := vc.client.ListResource(ctx, "Messages", data, page); err != nil {
+		return nil, err
+	}
+	scheduled := make([]*ScheduledMessage, 0)
+	for pages := 1; ; pages++ {
+		for _, resource := range page.Messages {
+			if resource.Status != StatusScheduled {
+				continue
+			}
+			msg, err := NewMessage(&resource.Message, vc.permission, u)
+			if err == config.ErrTooOld || err == config.PermissionDenied {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			sm := &ScheduledMessage{Message: msg}
+			if resource.SendAt.Valid {
+				sm.SendAt = resource.SendAt.Time
+			}
+			scheduled = append(scheduled, sm)
+		}
+		if !page.NextPageURI.Valid || page.NextPageURI.String == "" || pages >= maxScheduledPages {
+			break
+		}
+		next := new(scheduledMessagePage)
+		if err := vc.client.GetNextPage(ctx, page.NextPageURI.String, next); err != nil {
+			return nil, err
+		}
+		page = next
+	}
+	sort.Stable(scheduledMessagesBySendAt(scheduled))
+	return scheduled, nil
+}
+
+// CancelScheduledMessage stops Twilio from sending the scheduled message with
+// the given sid, and returns the canceled message.
+func (vc *client) CancelScheduledMessage(ctx context.Context, u *config.User, sid string) (*Message, error) {
+	if !u.CanCancelMessages() {
+		return nil, config.PermissionDenied
+	}
+	original, err := vc.client.Messages.Get(ctx, sid)
+	if err != nil {
+		return nil, err
+	}
+	// Check the user could see the message, so canceling can't be used to
+	// reach messages outside their max resource age.
+	msg, err := NewMessage(original, vc.permission, u)
+	if err != nil {
+		return nil, err
+	}
+	if !msg.Scheduled() {
+		return nil, ErrNotScheduled
+	}
+	data := url.Values{}
+	data.Set("Status", string(StatusCanceled))
+	canceled := new(twilio.Message)
+	if err := vc.client.UpdateResource(ctx, "Messages", sid, data, canceled); err != nil {
+		return nil, err
+	}
+	return NewMessage(canceled, vc.permission, u)
+}
@@ -0,0 +1,420 @@
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	types "github.com/kevinburke/go-types"
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+const telnyxBaseURL = "https://api.telnyx.com"
+
+// Telnyx message and call IDs are UUIDs; Twilio sids never are.
+var telnyxID = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// The detail record types for messages and calls.
+const (
+	telnyxMessageRecords = "messaging"
+	telnyxCallRecords    = "call-control"
+)
+
+// telnyxProvider reads messages and calls from the Telnyx v2 API. Single
+// messages come from the Messages API, and lists come from detail records,
+// since Telnyx can't list messages or finished calls any other way.
+type telnyxProvider struct {
+	apiKey string
+	base   string
+	client *http.Client
+}
+
+// NewTelnyxProvider returns a Provider for the Telnyx account with the given
+// API key. If base is empty, requests go to the Telnyx API.
+func NewTelnyxProvider(apiKey string, base string) Provider {
+	if base == "" {
+		base = telnyxBaseURL
+	}
+	return &telnyxProvider{
+		apiKey: apiKey,
+		base:   strings.TrimSuffix(base, "/"),
+		client: http.DefaultClient,
+	}
+}
+
+func (t *telnyxProvider) Name() string {
+	return config.ProviderTelnyx
+}
+
+func (t *telnyxProvider) Owns(id string) bool {
+	return telnyxID.MatchString(id)
+}
+
+type telnyxError struct {
+	Code   string `json:"code"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+}
+
+type telnyxPhone struct {
+	PhoneNumber twilio.PhoneNumber `json:"phone_number"`
+	Status      string             `json:"status"`
+}
+
+type telnyxMessage struct {
+	ID        string         `json:"id"`
+	Direction string         `json:"direction"`
+	From      telnyxPhone    `json:"from"`
+	To        []telnyxPhone  `json:"to"`
+	Text      string         `json:"text"`
+	Parts     uint           `json:"parts"`
+	Errors    []*telnyxError `json:"errors"`
+	Media     []struct {
+		URL string `json:"url"`
+	} `json:"media"`
+	Cost *struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	} `json:"cost"`
+	MessagingProfileID string    `json:"messaging_profile_id"`
+	ReceivedAt         time.Time `json:"received_at"`
+	SentAt             time.Time `json:"sent_at"`
+}
+
+type telnyxMessageRecord struct {
+	ID        string    `json:"id"`
+	Direction string    `json:"direction"`
+	CLI       string    `json:"cli"`
+	CLD       string    `json:"cld"`
+	Status    string    `json:"status"`
+	Parts     uint      `json:"parts"`
+	Cost      string    `json:"cost"`
+	Currency  string    `json:"currency"`
+	ErrorCode string    `json:"error_code"`
+	CreatedAt time.Time `json:"created_at"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+type telnyxCallRecord struct {
+	ID          string    `json:"id"`
+	Direction   string    `json:"direction"`
+	CLI         string    `json:"cli"`
+	CLD         string    `json:"cld"`
+	HangupCause string    `json:"hangup_cause"`
+	CallSec     int64     `json:"call_sec"`
+	Cost        string    `json:"cost"`
+	Currency    string    `json:"currency"`
+	CreatedAt   time.Time `json:"created_at"`
+	StartedAt   time.Time `json:"started_at"`
+	EndedAt     time.Time `json:"ended_at"`
+}
+
+type telnyxMeta struct {
+	PageNumber int `json:"page_number"`
+	TotalPages int `json:"total_pages"`
+}
+
+// get makes an authenticated GET request to the Telnyx API and decodes the
+// response into v. Errors from Telnyx are returned as a *rest.Error with the
+// response's status code.
+func (t *telnyxProvider) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	uri := t.base + path
+	if len(query) > 0 {
+		uri = uri + "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	req.Header.Set("Accept", "application/json")
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		body := new(struct {
+			Errors []*telnyxError `json:"errors"`
+		})
+		rerr := &rest.Error{
+			StatusCode: resp.StatusCode,
+			Title:      fmt.Sprintf("Telnyx API error: %s", resp.Status),
+		}
+		if json.NewDecoder(resp.Body).Decode(body) == nil && len(body.Errors) > 0 {
+			rerr.Title = "Telnyx API error: " + body.Errors[0].Title
+			rerr.Detail = body.Errors[0].Detail
+		}
+		return rerr
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func telnyxTime(t time.Time) twilio.TwilioTime {
+	if t.IsZero() {
+		return twilio.TwilioTime{}
+	}
+	return twilio.TwilioTime{Time: t, Valid: true}
+}
+
+func telnyxDirection(direction string) twilio.Direction {
+	if direction == "outbound" {
+		return twilio.DirectionOutboundAPI
+	}
+	return twilio.DirectionInbound
+}
+
+// telnyxPrice returns cost the way Twilio shows prices, as a negative amount.
+func telnyxPrice(cost string) string {
+	if cost == "" || strings.HasPrefix(cost, "-") {
+		return cost
+	}
+	if f, err := strconv.ParseFloat(cost, 64); err == nil && f == 0 {
+		return cost
+	}
+	return "-" + cost
+}
+
+// telnyxMessageStatus maps a Telnyx message status to the closest Twilio one.
+func telnyxMessageStatus(status string) twilio.Status {
+	switch status {
+	case "queued":
+		return twilio.StatusQueued
+	case "sending":
+		return twilio.StatusSending
+	case "sent", "delivery_unconfirmed":
+		return twilio.StatusSent
+	case "delivered":
+		return twilio.StatusDelivered
+	case "delivery_failed", "expired":
+		return twilio.StatusUndelivered
+	case "sending_failed", "failed":
+		return twilio.StatusFailed
+	case "received", "webhook_delivered", "webhook_failed":
+		return twilio.StatusReceived
+	default:
+		return twilio.Status(status)
+	}
+}
+
+// telnyxCallStatus maps the reason a Telnyx call ended to the closest Twilio
+// call status.
+func telnyxCallStatus(hangupCause string) twilio.Status {
+	switch hangupCause {
+	case "normal_clearing", "":
+		return twilio.StatusCompleted
+	case "user_busy":
+		return twilio.StatusBusy
+	case "no_answer", "timeout", "originator_cancel":
+		return twilio.StatusNoAnswer
+	default:
+		return twilio.StatusFailed
+	}
+}
+
+func telnyxCode(code string) twilio.Code {
+	n, _ := strconv.Atoi(code)
+	return twilio.Code(n)
+}
+
+func (m *telnyxMessage) toTwilio() *twilio.Message {
+	msg := &twilio.Message{
+		Sid:         m.ID,
+		Body:        m.Text,
+		From:        m.From.PhoneNumber,
+		Direction:   telnyxDirection(m.Direction),
+		NumSegments: twilio.Segments(m.Parts),
+		NumMedia:    twilio.NumMedia(len(m.Media)),
+		DateCreated: telnyxTime(m.ReceivedAt),
+		DateSent:    telnyxTime(m.SentAt),
+	}
+	if len(m.To) > 0 {
+		msg.To = m.To[0].PhoneNumber
+		msg.Status = telnyxMessageStatus(m.To[0].Status)
+	}
+	if m.MessagingProfileID != "" {
+		msg.MessagingServiceSid = types.NullString{Valid: true, String: m.MessagingProfileID}
+	}
+	if m.Cost != nil {
+		msg.Price = telnyxPrice(m.Cost.Amount)
+		msg.PriceUnit = m.Cost.Currency
+	}
+	if len(m.Errors) > 0 {
+		msg.ErrorCode = telnyxCode(m.Errors[0].Code)
+		msg.ErrorMessage = m.Errors[0].Title
+	}
+	return msg
+}
+
+func (r *telnyxMessageRecord) toTwilio() *twilio.Message {
+	return &twilio.Message{
+		Sid:         r.ID,
+		From:        twilio.PhoneNumber(r.CLI),
+		To:          twilio.PhoneNumber(r.CLD),
+		Status:      telnyxMessageStatus(r.Status),
+		Direction:   telnyxDirection(r.Direction),
+		NumSegments: twilio.Segments(r.Parts),
+		Price:       telnyxPrice(r.Cost),
+		PriceUnit:   r.Currency,
+		ErrorCode:   telnyxCode(r.ErrorCode),
+		DateCreated: telnyxTime(r.CreatedAt),
+		DateSent:    telnyxTime(r.SentAt),
+	}
+}
+
+func (r *telnyxCallRecord) toTwilio() *twilio.Call {
+	return &twilio.Call{
+		Sid:         r.ID,
+		From:        twilio.PhoneNumber(r.CLI),
+		To:          twilio.PhoneNumber(r.CLD),
+		Status:      telnyxCallStatus(r.HangupCause),
+		Direction:   telnyxDirection(r.Direction),
+		Duration:    twilio.TwilioDuration(time.Duration(r.CallSec) * time.Second),
+		Price:       telnyxPrice(r.Cost),
+		PriceUnit:   r.Currency,
+		DateCreated: telnyxTime(r.CreatedAt),
+		StartTime:   telnyxTime(r.StartedAt),
+		EndTime:     telnyxTime(r.EndedAt),
+	}
+}
+
+func (t *telnyxProvider) getMessage(ctx context.Context, id string) (*telnyxMessage, error) {
+	resp := new(struct {
+		Data *telnyxMessage `json:"data"`
+	})
+	if err := t.get(ctx, "/v2/messages/"+id, nil, resp); err != nil {
+		return nil, err
+	}
+	if resp.Data == nil {
+		return nil, &rest.Error{StatusCode: 404, Title: "Message " + id + " not found"}
+	}
+	return resp.Data, nil
+}
+
+func (t *telnyxProvider) GetMessage(ctx context.Context, id string) (*twilio.Message, error) {
+	m, err := t.getMessage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return m.toTwilio(), nil
+}
+
+func (t *telnyxProvider) GetMediaURLs(ctx context.Context, id string) ([]*url.URL, error) {
+	m, err := t.getMessage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]*url.URL, 0, len(m.Media))
+	for _, media := range m.Media {
+		u, err := url.Parse(media.URL)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+// recordQuery returns the detail record filters for a list described by
+// data, which has Twilio's filter names.
+func recordQuery(recordType string, start, end time.Time, data url.Values) url.Values {
+	q := url.Values{}
+	q.Set("filter[record_type]", recordType)
+	if !start.Equal(twilio.Epoch) {
+		q.Set("filter[created_at][gte]", start.UTC().Format(time.RFC3339))
+	}
+	if !end.Equal(twilio.HeatDeath) {
+		q.Set("filter[created_at][lt]", end.UTC().Format(time.RFC3339))
+	}
+	if from := data.Get("From"); from != "" {
+		q.Set("filter[cli]", from)
+	}
+	if to := data.Get("To"); to != "" {
+		q.Set("filter[cld]", to)
+	}
+	if status := data.Get("Status"); status != "" {
+		q.Set("filter[status]", status)
+	}
+	page, _ := strconv.Atoi(data.Get("Page"))
+	if page < 0 {
+		page = 0
+	}
+	// Telnyx numbers pages from 1.
+	q.Set("page[number]", strconv.Itoa(page+1))
+	if size := data.Get("PageSize"); size != "" {
+		q.Set("page[size]", size)
+	}
+	q.Set("sort", "-created_at")
+	return q
+}
+
+// nextPageURI returns the URI of the page after the one described by data,
+// if meta says there is one.
+func (t *telnyxProvider) nextPageURI(resource string, meta telnyxMeta, data url.Values) types.NullString {
+	if meta.PageNumber >= meta.TotalPages {
+		return types.NullString{}
+	}
+	next := url.Values{}
+	for k, v := range data {
+		next[k] = v
+	}
+	next.Set("Page", strconv.Itoa(meta.PageNumber))
+	next.Set("Provider", t.Name())
+	return types.NullString{Valid: true, String: ProviderPagePrefix(t.Name()) + resource + ".json?" + next.Encode()}
+}
+
+func (t *telnyxProvider) GetMessagesInRange(ctx context.Context, start, end time.Time, data url.Values) (*twilio.MessagePage, error) {
+	resp := new(struct {
+		Data []*telnyxMessageRecord `json:"data"`
+		Meta telnyxMeta             `json:"meta"`
+	})
+	if err := t.get(ctx, "/v2/detail_records", recordQuery(telnyxMessageRecords, start, end, data), resp); err != nil {
+		return nil, err
+	}
+	page := &twilio.MessagePage{Messages: make([]*twilio.Message, len(resp.Data))}
+	for i, r := range resp.Data {
+		page.Messages[i] = r.toTwilio()
+	}
+	page.NextPageURI = t.nextPageURI("Messages", resp.Meta, data)
+	return page, nil
+}
+
+func (t *telnyxProvider) GetCall(ctx context.Context, id string) (*twilio.Call, error) {
+	q := url.Values{}
+	q.Set("filter[record_type]", telnyxCallRecords)
+	q.Set("filter[id]", id)
+	resp := new(struct {
+		Data []*telnyxCallRecord `json:"data"`
+	})
+	if err := t.get(ctx, "/v2/detail_records", q, resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, &rest.Error{StatusCode: 404, Title: "Call " + id + " not found"}
+	}
+	return resp.Data[0].toTwilio(), nil
+}
+
+func (t *telnyxProvider) GetCallsInRange(ctx context.Context, start, end time.Time, data url.Values) (*twilio.CallPage, error) {
+	resp := new(struct {
+		Data []*telnyxCallRecord `json:"data"`
+		Meta telnyxMeta          `json:"meta"`
+	})
+	if err := t.get(ctx, "/v2/detail_records", recordQuery(telnyxCallRecords, start, end, data), resp); err != nil {
+		return nil, err
+	}
+	page := &twilio.CallPage{Calls: make([]*twilio.Call, len(resp.Data))}
+	for i, r := range resp.Data {
+		page.Calls[i] = r.toTwilio()
+	}
+	page.NextPageURI = t.nextPageURI("Calls", resp.Meta, data)
+	return page, nil
+}
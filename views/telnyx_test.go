@@ -0,0 +1,171 @@
+package views
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kevinburke/rest"
+	"github.com/saintpete/logrole/config"
+	twilio "github.com/saintpete/twilio-go"
+	"golang.org/x/net/context"
+)
+
+const telnyxMessageID = "40385f64-5717-4562-b3fc-2c963f66afa6"
+
+const telnyxMessageResponse = `{"data": {"id": "40385f64-5717-4562-b3fc-2c963f66afa6", "direction": "outbound", "from": {"phone_number": "+19253920364"}, "to": [{"phone_number": "+14105551234", "status": "delivery_failed"}], "text": "hello", "parts": 1, "media": [{"url": "https://media.example.com/a.jpg"}], "cost": {"amount": "0.0040", "currency": "USD"}, "errors": [{"code": "40008", "title": "Undeliverable"}], "received_at": "2016-10-27T23:27:03Z"}}`
+
+func newTelnyxServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer KEY123" {
+			w.WriteHeader(401)
+			w.Write([]byte(`{"errors": [{"title": "Authentication failed"}]}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/v2/messages/"+telnyxMessageID:
+			w.Write([]byte(telnyxMessageResponse))
+		case r.URL.Path == "/v2/detail_records":
+			q := r.URL.Query()
+			if q.Get("filter[record_type]") != "messaging" {
+				w.Write([]byte(`{"data": [], "meta": {"page_number": 1, "total_pages": 0}}`))
+				return
+			}
+			if q.Get("filter[cli]") != "+19253920364" {
+				t.Errorf("expected From to become filter[cli], got %q", q.Get("filter[cli]"))
+			}
+			if q.Get("page[number]") == "1" {
+				w.Write([]byte(`{"data": [{"id": "5f7b3f0a-6b1c-4a38-9b7d-1f2e3d4c5b6a", "direction": "outbound", "cli": "+19253920364", "cld": "+14105551234", "status": "delivered", "parts": 1, "cost": "0.0040", "currency": "USD", "created_at": "2016-10-27T23:28:03Z"}], "meta": {"page_number": 1, "total_pages": 2}}`))
+			} else {
+				w.Write([]byte(`{"data": [{"id": "6a8c4e1b-7c2d-4b49-8c8e-2f3e4d5c6b7a", "direction": "inbound", "cli": "+19253920364", "cld": "+14105551234", "status": "received", "created_at": "2016-10-27T23:26:03Z"}], "meta": {"page_number": 2, "total_pages": 2}}`))
+			}
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"errors": [{"title": "Resource not found"}]}`))
+		}
+	}))
+}
+
+func newTestProviderClient(t *testing.T, base string) (Client, func()) {
+	archive, cleanup := newTestArchive(t)
+	p := config.NewPermission(1000 * 1000 * time.Hour)
+	return NewProviderClient(archive, new([32]byte), p, NewTelnyxProvider("KEY123", base)), cleanup
+}
+
+func TestProviderClientGetMessage(t *testing.T) {
+	t.Parallel()
+	s := newTelnyxServer(t)
+	defer s.Close()
+	vc, cleanup := newTestProviderClient(t, s.URL)
+	defer cleanup()
+	u := config.NewUser(config.AllUserSettings())
+	msg, err := vc.GetMessage(context.Background(), u, telnyxMessageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Provider() != config.ProviderTelnyx {
+		t.Errorf("expected telnyx provider, got %q", msg.Provider())
+	}
+	if status, _ := msg.Status(); status != twilio.StatusUndelivered {
+		t.Errorf("expected undelivered status, got %q", status)
+	}
+	if price, _ := msg.Price(); price != "-0.0040" {
+		t.Errorf("expected a negative price, got %q", price)
+	}
+	if code, _ := msg.ErrorCode(); code != 40008 {
+		t.Errorf("expected error code 40008, got %d", code)
+	}
+	if msg.Resendable() {
+		t.Error("only Twilio messages should be resendable")
+	}
+	urls, err := vc.GetMediaURLs(context.Background(), u, telnyxMessageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || !strings.HasPrefix(urls[0].Path, "/images/") {
+		t.Errorf("expected one proxied media URL, got %v", urls)
+	}
+
+	// Twilio sids still go to the primary client.
+	msg, err = vc.GetMessage(context.Background(), u, "SM1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Provider() != config.ProviderTwilio {
+		t.Errorf("expected twilio provider, got %q", msg.Provider())
+	}
+}
+
+func TestProviderClientMessagePages(t *testing.T) {
+	t.Parallel()
+	s := newTelnyxServer(t)
+	defer s.Close()
+	vc, cleanup := newTestProviderClient(t, s.URL)
+	defer cleanup()
+	u := config.NewUser(config.AllUserSettings())
+	data := url.Values{}
+	data.Set("Provider", "telnyx")
+	data.Set("From", "+19253920364")
+	page, _, err := vc.GetMessagePageInRange(context.Background(), u, twilio.Epoch, twilio.HeatDeath, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages()) != 1 || page.Messages()[0].Provider() != "telnyx" {
+		t.Fatalf("expected one telnyx message, got %v", page.Messages())
+	}
+	next := page.NextPageURI()
+	if !next.Valid || !strings.HasPrefix(next.String, ProviderPagePrefix("telnyx")+"Messages.json") {
+		t.Fatalf("expected a telnyx next page URI, got %v", next)
+	}
+	page, _, err = vc.GetNextMessagePageInRange(context.Background(), u, twilio.Epoch, twilio.HeatDeath, next.String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages()) != 1 {
+		t.Fatalf("expected one message on the second page, got %d", len(page.Messages()))
+	}
+	if sid, _ := page.Messages()[0].Sid(); sid != "6a8c4e1b-7c2d-4b49-8c8e-2f3e4d5c6b7a" {
+		t.Errorf("wrong message on the second page: %s", sid)
+	}
+	if page.NextPageURI().Valid {
+		t.Errorf("expected no more pages, got %v", page.NextPageURI())
+	}
+
+	data.Set("Provider", "twilio")
+	data.Del("From")
+	page, _, err = vc.GetMessagePageInRange(context.Background(), u, twilio.Epoch, twilio.HeatDeath, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages()) != 3 {
+		t.Errorf("expected the 3 archived messages, got %d", len(page.Messages()))
+	}
+
+	data.Set("Provider", "bandwidth")
+	_, _, err = vc.GetMessagePageInRange(context.Background(), u, twilio.Epoch, twilio.HeatDeath, data)
+	if rerr, ok := err.(*rest.Error); !ok || rerr.StatusCode != 400 {
+		t.Errorf("expected a 400 for an unknown provider, got %v", err)
+	}
+}
+
+func TestTelnyxNotFound(t *testing.T) {
+	t.Parallel()
+	s := newTelnyxServer(t)
+	defer s.Close()
+	p := NewTelnyxProvider("KEY123", s.URL)
+	_, err := p.GetCall(context.Background(), "00000000-0000-0000-0000-000000000000")
+	rerr, ok := err.(*rest.Error)
+	if !ok {
+		t.Fatalf("expected a rest.Error, got %v", err)
+	}
+	if rerr.StatusCode != 404 {
+		t.Errorf("expected a 404, got %d", rerr.StatusCode)
+	}
+	if !p.Owns(telnyxMessageID) || p.Owns("SM1a2b3c") {
+		t.Error("Owns should only match UUIDs")
+	}
+}
@@ -0,0 +1,21 @@
+package views
+
+import twilio "github.com/saintpete/twilio-go"
+
+// StatusRead is the status of a WhatsApp message after the recipient opened
+// it. SMS messages never get it.
+const StatusRead = twilio.Status("read")
+
+// What to do about common WhatsApp errors - see
+// https://www.twilio.com/docs/api/errors. The error message from Twilio says
+// what went wrong, but not why.
+var whatsAppErrorHelp = map[twilio.Code]string{
+	63003: "The recipient's number isn't on WhatsApp, or hasn't accepted WhatsApp's terms of service.",
+	63007: "The sending number isn't a registered WhatsApp sender for this account.",
+	63013: "WhatsApp blocked the message for violating its commerce or business policy.",
+	63015: "The WhatsApp sandbox can only send to numbers that have joined the sandbox.",
+	63016: "More than 24 hours have passed since the recipient last messaged you, so only an approved template can be sent.",
+	63018: "The sender went over WhatsApp's rate limit for its messaging tier.",
+	63024: "The recipient's number isn't valid on WhatsApp.",
+	63032: "WhatsApp doesn't deliver marketing templates to this recipient.",
+}
@@ -0,0 +1,54 @@
+package views
+
+import (
+	"time"
+
+	twilio "github.com/saintpete/twilio-go"
+)
+
+// A Window is a slice of a range of time, from Start up to but not including
+// End, the same way the list filters treat a range.
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Contains reports whether t falls in the window. Invalid times never do.
+func (w Window) Contains(t twilio.TwilioTime) bool {
+	return inRange(t, w.Start, w.End)
+}
+
+// Windows splits the range from start to end into windows of the given width,
+// newest first, the order Twilio lists resources in.
+//
+// Twilio's pages can shift while they're being walked, as new resources
+// arrive and old ones are backfilled, so a long walk through one list can
+// skip or repeat resources. A window's list is short, and closed windows
+// don't change, so walking the windows one at a time gives the same results
+// every time.
+//
+// Boundaries fall on multiples of width, so the same range is always split
+// the same way. After max windows, the last window holds the rest of the
+// range, so an open-ended range isn't split into thousands of empty windows.
+// If width is zero, the whole range is one window.
+func Windows(start, end time.Time, width time.Duration, max int) []Window {
+	if !start.Before(end) {
+		return nil
+	}
+	if width <= 0 || max <= 1 {
+		return []Window{{Start: start, End: end}}
+	}
+	var windows []Window
+	for wEnd := end; wEnd.After(start); {
+		wStart := wEnd.Truncate(width)
+		if !wStart.Before(wEnd) {
+			wStart = wEnd.Add(-width)
+		}
+		if len(windows) == max-1 || wStart.Before(start) {
+			wStart = start
+		}
+		windows = append(windows, Window{Start: wStart, End: wEnd})
+		wEnd = wStart
+	}
+	return windows
+}
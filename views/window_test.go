@@ -0,0 +1,57 @@
+package views
+
+import (
+	"testing"
+	"time"
+
+	twilio "github.com/saintpete/twilio-go"
+)
+
+func TestWindows(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2016, 10, 18, 10, 30, 0, 0, time.UTC)
+	end := time.Date(2016, 10, 18, 13, 15, 0, 0, time.UTC)
+	windows := Windows(start, end, time.Hour, 10)
+	expected := []Window{
+		{time.Date(2016, 10, 18, 13, 0, 0, 0, time.UTC), end},
+		{time.Date(2016, 10, 18, 12, 0, 0, 0, time.UTC), time.Date(2016, 10, 18, 13, 0, 0, 0, time.UTC)},
+		{time.Date(2016, 10, 18, 11, 0, 0, 0, time.UTC), time.Date(2016, 10, 18, 12, 0, 0, 0, time.UTC)},
+		{start, time.Date(2016, 10, 18, 11, 0, 0, 0, time.UTC)},
+	}
+	if len(windows) != len(expected) {
+		t.Fatalf("expected %d windows, got %d: %v", len(expected), len(windows), windows)
+	}
+	for i := range expected {
+		if !windows[i].Start.Equal(expected[i].Start) || !windows[i].End.Equal(expected[i].End) {
+			t.Errorf("window %d: expected %v, got %v", i, expected[i], windows[i])
+		}
+	}
+
+	// A resource on a boundary is in exactly one window.
+	boundary := twilio.TwilioTime{Valid: true, Time: time.Date(2016, 10, 18, 12, 0, 0, 0, time.UTC)}
+	if windows[1].Contains(boundary) == false || windows[2].Contains(boundary) {
+		t.Errorf("expected %v to be in the second window only", boundary.Time)
+	}
+	if windows[0].Contains(twilio.TwilioTime{}) {
+		t.Error("expected an invalid time not to be in a window")
+	}
+}
+
+func TestWindowsMax(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2016, 10, 18, 0, 0, 0, 0, time.UTC)
+	windows := Windows(start, end, 24*time.Hour, 3)
+	if len(windows) != 3 {
+		t.Fatalf("expected 3 windows, got %d", len(windows))
+	}
+	if last := windows[2]; !last.Start.Equal(start) || !last.End.Equal(time.Date(2016, 10, 16, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the last window to hold the rest of the range, got %v", last)
+	}
+	if w := Windows(start, end, 0, 3); len(w) != 1 || !w[0].Start.Equal(start) || !w[0].End.Equal(end) {
+		t.Errorf("expected a zero width to give one window, got %v", w)
+	}
+	if w := Windows(end, start, time.Hour, 3); w != nil {
+		t.Errorf("expected an empty range to give no windows, got %v", w)
+	}
+}